@@ -1,22 +1,11 @@
 #!/usr/bin/env bash
 set -euo pipefail
 
-KAFKA_BOOTSTRAP="${KAFKA_BOOTSTRAP:-localhost:29092}"
-
-create_topic() {
-  local name="$1"
-  local partitions="${2:-6}"
-  local replicas="${3:-1}"
-  docker run --rm --network host confluentinc/cp-kafka:7.6.1 \
-    kafka-topics \
-      --bootstrap-server "$KAFKA_BOOTSTRAP" \
-      --create --if-not-exists \
-      --topic "$name" \
-      --partitions "$partitions" \
-      --replication-factor "$replicas"
-}
-
+# Topics are declared in kafka/topics.json and applied by
+# services/tools/cmd/kafka-admin. Extra args are passed through, e.g.
+#   ./create-topics.sh -dry-run
+#   ./create-topics.sh -check
 # crawl.jobs removed — using Asynq (Redis) for job scheduling instead
-create_topic "user.listen.raw" 12 1
+docker compose run --rm kafka-admin kafka-admin -config /kafka/topics.json "$@"
 
-echo "Topics created."
+echo "Topics up to date."
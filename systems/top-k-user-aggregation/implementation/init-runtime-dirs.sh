@@ -12,6 +12,16 @@ sudo mkdir -p "$RUNTIME_BASE/kafka"
 sudo mkdir -p "$RUNTIME_BASE/postgres"
 sudo mkdir -p "$RUNTIME_BASE/cassandra"
 sudo mkdir -p "$RUNTIME_BASE/redis"
+sudo mkdir -p "$RUNTIME_BASE/minio"
+sudo mkdir -p "$RUNTIME_BASE/prometheus"
+sudo mkdir -p "$RUNTIME_BASE/grafana"
+
+# Second datacenter (docker-compose.multi-dc.yml)
+sudo mkdir -p "$RUNTIME_BASE/dc2/zookeeper/data"
+sudo mkdir -p "$RUNTIME_BASE/dc2/zookeeper/log"
+sudo mkdir -p "$RUNTIME_BASE/dc2/kafka"
+sudo mkdir -p "$RUNTIME_BASE/dc2/cassandra"
+sudo mkdir -p "$RUNTIME_BASE/dc2/redis"
 
 # Set permissions (Docker containers often run as specific users)
 sudo chmod -R 777 "$RUNTIME_BASE"
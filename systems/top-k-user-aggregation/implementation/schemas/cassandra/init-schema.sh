@@ -8,14 +8,16 @@ until docker compose exec -T cassandra cqlsh -e "describe keyspaces" > /dev/null
     sleep 5
 done
 
-echo "Cassandra is ready. Creating schema..."
+echo "Cassandra is ready. Applying migrations..."
 
-# Run the schema script
-docker compose exec -T cassandra cqlsh < schemas/cassandra/init.cql
+# Apply pending migrations (schemas/cassandra/migrations) with cmd/migrate
+docker compose run --rm migrate
 
-echo "Schema created successfully."
+echo "Schema up to date."
 
 # Verify
 echo ""
+docker compose run --rm migrate migrate -dir /schemas/cassandra/migrations -status
+echo ""
 echo "Verifying tables:"
 docker compose exec -T cassandra cqlsh -e "USE topk; DESCRIBE TABLES;"
@@ -5,7 +5,7 @@ CREATE TABLE IF NOT EXISTS user_crawl_schedule (
     user_id       TEXT NOT NULL,
     provider      TEXT NOT NULL,
     next_crawl_at TIMESTAMP NOT NULL DEFAULT NOW(),
-    status        TEXT NOT NULL DEFAULT 'IDLE',  -- IDLE, ENQUEUED, RUNNING
+    status        TEXT NOT NULL DEFAULT 'IDLE',  -- IDLE, ENQUEUED, RUNNING, SUSPENDED
     updated_at    TIMESTAMP NOT NULL DEFAULT NOW(),
     created_at    TIMESTAMP NOT NULL DEFAULT NOW(),
     last_error    TEXT,
@@ -23,6 +23,87 @@ CREATE INDEX IF NOT EXISTS idx_crawl_stuck
 ON user_crawl_schedule (status, updated_at)
 WHERE status = 'ENQUEUED';
 
+-- Added with per-user crawl cadence. cadence is the policy: 'auto' (the
+-- scheduler picks hourly, daily or weekly from the events recent crawls
+-- returned) or a fixed 'hourly', 'daily' or 'weekly'. The worker records
+-- each crawl and sets cadence_pending; the scheduler folds it into
+-- events_per_day and retunes crawl_interval_secs.
+ALTER TABLE user_crawl_schedule ADD COLUMN IF NOT EXISTS cadence TEXT NOT NULL DEFAULT 'auto';
+ALTER TABLE user_crawl_schedule ADD COLUMN IF NOT EXISTS crawl_interval_secs INT NOT NULL DEFAULT 86400;
+ALTER TABLE user_crawl_schedule ADD COLUMN IF NOT EXISTS last_crawled_at TIMESTAMP;
+ALTER TABLE user_crawl_schedule ADD COLUMN IF NOT EXISTS last_crawl_events INT;
+ALTER TABLE user_crawl_schedule ADD COLUMN IF NOT EXISTS last_crawl_window_secs BIGINT;
+ALTER TABLE user_crawl_schedule ADD COLUMN IF NOT EXISTS events_per_day DOUBLE PRECISION;
+ALTER TABLE user_crawl_schedule ADD COLUMN IF NOT EXISTS cadence_pending BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- Index for the cadence adjuster: crawls not folded in yet
+CREATE INDEX IF NOT EXISTS idx_crawl_cadence_pending
+ON user_crawl_schedule (user_id, provider)
+WHERE cadence_pending;
+
+-- Added with dormant-user suspension: consecutive crawls that returned no
+-- events, and why and since when a user's crawls are SUSPENDED
+ALTER TABLE user_crawl_schedule ADD COLUMN IF NOT EXISTS empty_crawls INT NOT NULL DEFAULT 0;
+ALTER TABLE user_crawl_schedule ADD COLUMN IF NOT EXISTS suspended_at TIMESTAMP;
+ALTER TABLE user_crawl_schedule ADD COLUMN IF NOT EXISTS suspended_reason TEXT;
+
+-- Index for the suspension pass: idle users with empty crawls
+CREATE INDEX IF NOT EXISTS idx_crawl_empty
+ON user_crawl_schedule (empty_crawls)
+WHERE status = 'IDLE' AND empty_crawls > 0;
+
+-- Added with user soft-delete: a deleted user's rows are never enqueued and
+-- their data is kept until purge_after, when the grace period before the
+-- hard delete ends. Every row of the user carries the same values.
+ALTER TABLE user_crawl_schedule ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP;
+ALTER TABLE user_crawl_schedule ADD COLUMN IF NOT EXISTS purge_after TIMESTAMP;
+ALTER TABLE user_crawl_schedule ADD COLUMN IF NOT EXISTS deleted_reason TEXT;
+
+-- Index for the deleted-users list and its sync to Redis
+CREATE INDEX IF NOT EXISTS idx_crawl_deleted
+ON user_crawl_schedule (purge_after)
+WHERE deleted_at IS NOT NULL;
+
+-- Outbox for crawl-worker Kafka publishing
+-- Written in the same transaction as the schedule update, drained to
+-- user.listen.raw by the worker's background publisher
+CREATE TABLE IF NOT EXISTS crawl_outbox (
+    id          BIGSERIAL PRIMARY KEY,
+    msg_key     TEXT NOT NULL,                -- Kafka message key (user_id)
+    payload     BYTEA NOT NULL,               -- encoded ListenEvent (pkg/events)
+    trace_id    TEXT,                         -- published as the trace_id header
+    attempts    INT NOT NULL DEFAULT 0,
+    last_error  TEXT,
+    created_at  TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+-- Raw listen history (used by raw-event-processor when SINK=postgres)
+-- Relational counterpart of the Cassandra user_listen_history table
+CREATE TABLE IF NOT EXISTS user_listen_history (
+    event_id    TEXT PRIMARY KEY,
+    user_id     TEXT NOT NULL,
+    day         DATE NOT NULL,
+    listened_at TIMESTAMP NOT NULL,
+    song_id     TEXT NOT NULL,
+    provider    TEXT NOT NULL,
+    hour_bucket TIMESTAMP,
+    hour        INT,
+    weekday     TEXT,
+    source      TEXT,
+    context     TEXT,
+    artist_id   TEXT,
+    duration_ms BIGINT,
+    extra       JSONB
+);
+
+-- Added with artist aggregation and listening time; CREATE TABLE above
+-- skips existing tables
+ALTER TABLE user_listen_history ADD COLUMN IF NOT EXISTS artist_id TEXT;
+ALTER TABLE user_listen_history ADD COLUMN IF NOT EXISTS duration_ms BIGINT;
+
+CREATE INDEX IF NOT EXISTS idx_listen_history_user_day
+ON user_listen_history (user_id, day, listened_at DESC);
+
 -- Trigger to auto-update updated_at
 CREATE OR REPLACE FUNCTION update_updated_at_column()
 RETURNS TRIGGER AS $$
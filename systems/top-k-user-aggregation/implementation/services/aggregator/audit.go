@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"expvar"
+	"hash/fnv"
+	"log"
+	"time"
+
+	"github.com/system-design-lab/pkg/storage"
+)
+
+// Dedup audit metrics (DEDUP_AUDIT_RATE > 0)
+var (
+	metricAuditSampled        = expvar.NewInt("dedup_audit_sampled")
+	metricAuditNew            = expvar.NewInt("dedup_audit_new")             // sampled IDs seen for the first time
+	metricAuditFalsePositives = expvar.NewInt("dedup_audit_false_positives") // new, but the bloom called them duplicates
+	metricAuditMissed         = expvar.NewInt("dedup_audit_missed")          // duplicates the bloom let through
+	metricAuditErrors         = expvar.NewInt("dedup_audit_errors")
+)
+
+func init() {
+	expvar.Publish("dedup_audit_fp_rate", expvar.Func(func() interface{} { return auditFPRate() }))
+	expvar.Publish("dedup_audit_configured_fp_rate", expvar.Func(func() interface{} { return bloomErrorRate }))
+}
+
+// auditFPRate is the measured false-positive rate: of the sampled events the
+// exact record had never seen, the share the bloom filter dropped
+func auditFPRate() float64 {
+	n := metricAuditNew.Value()
+	if n == 0 {
+		return 0
+	}
+	return float64(metricAuditFalsePositives.Value()) / float64(n)
+}
+
+// dedupAudit checks the bloom filter's decisions on a sample of events
+// against an exact record of their IDs in dedup_audit. Sampling hashes the
+// event ID, so every delivery of a sampled event is checked, duplicates
+// included.
+type dedupAudit struct {
+	repo *storage.DedupAuditRepo
+	rate float64       // share of event IDs sampled, 0-1
+	ttl  time.Duration // how long an ID stays in the exact record
+}
+
+func (d *dedupAudit) sampled(eventID string) bool {
+	h := fnv.New64a()
+	h.Write([]byte(eventID))
+	return float64(h.Sum64()%1_000_000) < d.rate*1_000_000
+}
+
+// check records a sampled event's ID and compares the bloom's verdict with
+// the exact one. Failures only cost the sample.
+func (d *dedupAudit) check(ctx context.Context, day, eventID string, bloomDuplicate bool) {
+	seen, err := d.repo.Record(ctx, day, eventID, bloomDuplicate, d.ttl)
+	if err != nil {
+		metricAuditErrors.Add(1)
+		return
+	}
+	metricAuditSampled.Add(1)
+	if !seen {
+		metricAuditNew.Add(1)
+	}
+	switch {
+	case bloomDuplicate && !seen:
+		metricAuditFalsePositives.Add(1)
+		log.Printf("Dedup audit: bloom false positive, dropped new event %s (%s)", eventID, day)
+	case !bloomDuplicate && seen:
+		metricAuditMissed.Add(1)
+		log.Printf("Dedup audit: duplicate event %s (%s) passed the bloom filter", eventID, day)
+	}
+}
+
+// logAudit reports the measured rate so far next to the configured one
+func logAudit() {
+	log.Printf("Dedup audit: %d sampled, %d false positives in %d new events (%.4f%%, configured %.2f%%), %d duplicates missed",
+		metricAuditSampled.Value(), metricAuditFalsePositives.Value(), metricAuditNew.Value(),
+		auditFPRate()*100, bloomErrorRate*100, metricAuditMissed.Value())
+}
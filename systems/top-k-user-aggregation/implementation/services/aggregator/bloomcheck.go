@@ -0,0 +1,237 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/system-design-lab/pkg/storage"
+)
+
+// The dedup marker sits next to the bloom filters with no TTL: when it is
+// gone, Redis lost its data and the filters went with it
+const (
+	dedupMarkerKey  = "dedup:marker"
+	dedupReady      = "ready"
+	dedupRebuilding = "rebuilding" // claimed by the aggregator rebuilding the filters
+)
+
+const (
+	bloomRebuildBatch = 1000 // event IDs per bloomAdd
+	bloomWaitPoll     = 5 * time.Second
+)
+
+// errScanDone stops a partition scan at the first event older than the rebuild
+var errScanDone = errors.New("scan done")
+
+// bloomCheck rebuilds the day bloom filters after Redis lost them (a restart
+// without persistence, a failover to an empty replica) from the event IDs of
+// the last hours of user_listen_history. Empty filters would let every
+// redelivery and duplicate of those hours be counted again.
+//
+// The aggregator that claims the missing marker rebuilds. At startup the
+// others wait for it before consuming; running ones check every interval and
+// keep consuming meanwhile.
+type bloomCheck struct {
+	redis       *redis.Client
+	history     bloomHistory
+	hours       int
+	interval    time.Duration
+	timeout     time.Duration
+	concurrency int
+}
+
+// bloomCheckFromEnv reads BLOOM_REBUILD_HOURS, BLOOM_CHECK_INTERVAL,
+// BLOOM_REBUILD_TIMEOUT and BLOOM_REBUILD_CONCURRENCY; nil when
+// BLOOM_REBUILD_HOURS is 0
+func bloomCheckFromEnv(history bloomHistory, rdb *redis.Client) (*bloomCheck, error) {
+	hours := getEnvInt("BLOOM_REBUILD_HOURS", 24)
+	switch {
+	case hours == 0:
+		return nil, nil
+	case hours < 0 || hours > 24*bloomTTLDays:
+		return nil, fmt.Errorf("BLOOM_REBUILD_HOURS must be between 0 and %d", 24*bloomTTLDays)
+	}
+	c := &bloomCheck{
+		redis:       rdb,
+		history:     history,
+		hours:       hours,
+		interval:    getEnvDuration("BLOOM_CHECK_INTERVAL", time.Minute),
+		timeout:     getEnvDuration("BLOOM_REBUILD_TIMEOUT", 10*time.Minute),
+		concurrency: getEnvInt("BLOOM_REBUILD_CONCURRENCY", 16),
+	}
+	if c.interval <= 0 || c.timeout <= 0 || c.concurrency < 1 {
+		return nil, fmt.Errorf("BLOOM_CHECK_INTERVAL, BLOOM_REBUILD_TIMEOUT and BLOOM_REBUILD_CONCURRENCY must be positive")
+	}
+	return c, nil
+}
+
+func (c *bloomCheck) String() string {
+	return fmt.Sprintf("last %dh of user_listen_history, checked every %s", c.hours, c.interval)
+}
+
+// startup returns once the filters are complete, rebuilding them or waiting
+// for the aggregator that does. After timeout it gives up and consumption
+// starts anyway.
+func (c *bloomCheck) startup(ctx context.Context) {
+	deadline := time.Now().Add(c.timeout)
+	for {
+		state, err := c.ensure(ctx)
+		switch {
+		case err != nil:
+			log.Printf("Warning: bloom filter check failed: %v", err)
+		case state == dedupReady:
+			return
+		default:
+			log.Printf("Waiting for another aggregator to rebuild the bloom filters")
+		}
+		if time.Now().After(deadline) {
+			log.Printf("ALERT: bloom filters not rebuilt after %s, consuming anyway: duplicates of the last %dh may be counted again",
+				c.timeout, c.hours)
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(bloomWaitPoll):
+		}
+	}
+}
+
+// run repeats the check until ctx is done
+func (c *bloomCheck) run(ctx context.Context) {
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := c.ensure(ctx); err != nil && ctx.Err() == nil {
+				log.Printf("Warning: bloom filter check failed: %v", err)
+			}
+		}
+	}
+}
+
+// ensure reads the marker and, when it is gone and this aggregator claims
+// it, rebuilds the filters. It returns the marker's state afterwards.
+func (c *bloomCheck) ensure(ctx context.Context) (string, error) {
+	state, err := c.redis.Get(ctx, dedupMarkerKey).Result()
+	switch {
+	case err == nil:
+		return state, nil
+	case err != redis.Nil:
+		return "", err
+	}
+
+	// The claim expires, so a rebuild that dies is taken over
+	claimed, err := c.redis.SetNX(ctx, dedupMarkerKey, dedupRebuilding, c.timeout).Result()
+	if err != nil || !claimed {
+		return dedupRebuilding, err
+	}
+	log.Printf("ALERT: %s is missing, Redis lost the bloom filters: rebuilding them from the last %dh of user_listen_history",
+		dedupMarkerKey, c.hours)
+	if err := c.rebuild(ctx); err != nil {
+		metricBloomRebuildErrors.Add(1)
+		c.redis.Del(context.Background(), dedupMarkerKey)
+		return "", fmt.Errorf("rebuild bloom filters: %w", err)
+	}
+	metricBloomRebuilds.Add(1)
+	return dedupReady, c.redis.Set(ctx, dedupMarkerKey, dedupReady, 0).Err()
+}
+
+// historyDay is one (user_id, day) partition of user_listen_history
+type historyDay struct{ user, day string }
+
+// rebuild adds the event IDs listened in the last hours to their day
+// filters. Late listens stored under an older day, and events the
+// raw-event-processor hadn't stored yet, are not in it.
+func (c *bloomCheck) rebuild(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	start := time.Now().UTC()
+	since := start.Add(-time.Duration(c.hours) * time.Hour)
+	days := make(map[string]bool)
+	for t := since; t.Before(start); t = t.Add(time.Hour) {
+		days[t.Format(storage.DayFormat)] = true
+	}
+	days[start.Format(storage.DayFormat)] = true
+	for day := range days {
+		if err := reserveBloom(ctx, c.redis, day); err != nil {
+			return err
+		}
+	}
+
+	var parts []historyDay
+	err := c.history.Partitions(ctx, func(user, day string) error {
+		if days[day] {
+			parts = append(parts, historyDay{user, day})
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("list partitions: %w", err)
+	}
+
+	var (
+		added, failed atomic.Int64
+		work          = make(chan historyDay)
+		wg            sync.WaitGroup
+	)
+	for i := 0; i < c.concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for p := range work {
+				n, err := c.rebuildPartition(ctx, p, since)
+				added.Add(int64(n))
+				if err != nil {
+					log.Printf("Error rebuilding bloom filter of %s/%s: %v", p.user, p.day, err)
+					failed.Add(1)
+				}
+			}
+		}()
+	}
+	for _, p := range parts {
+		work <- p
+	}
+	close(work)
+	wg.Wait()
+
+	metricBloomRebuildEvents.Add(added.Load())
+	log.Printf("Rebuilt bloom filters from %d events of %d partitions in %s (%d failed)",
+		added.Load(), len(parts), time.Since(start).Round(time.Millisecond), failed.Load())
+	if n := failed.Load(); n > 0 {
+		return fmt.Errorf("%d of %d partitions failed", n, len(parts))
+	}
+	return nil
+}
+
+// rebuildPartition adds a partition's events since since, newest first
+func (c *bloomCheck) rebuildPartition(ctx context.Context, p historyDay, since time.Time) (int, error) {
+	var ids []interface{}
+	err := c.history.ScanDay(ctx, p.user, p.day, func(row storage.HistoryRow) error {
+		if row.ListenedAt < since.Unix() {
+			return errScanDone
+		}
+		ids = append(ids, row.EventID)
+		return nil
+	})
+	if err != nil && err != errScanDone {
+		return 0, err
+	}
+	for i := 0; i < len(ids); i += bloomRebuildBatch {
+		batch := ids[i:min(i+bloomRebuildBatch, len(ids))]
+		if _, err := bloomAdd(ctx, c.redis, p.day, batch...); err != nil {
+			return i, err
+		}
+	}
+	return len(ids), nil
+}
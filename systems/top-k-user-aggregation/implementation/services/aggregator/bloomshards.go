@@ -0,0 +1,360 @@
+package main
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"log"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/system-design-lab/pkg/storage"
+)
+
+// A day's filter fills up with its events: past its capacity the
+// false-positive rate climbs and new listens get dropped as duplicates. So
+// before it gets there the monitor rolls the day to another shard, a filter
+// of the same size: new IDs go to the newest shard, and an ID is a
+// duplicate when any shard has it. Shard 0 is dedup:<day>, shard i
+// dedup:<day>:<i>, and dedup:<day>:shards holds the count (1 when missing),
+// so every aggregator switches at once.
+
+func bloomShardKey(scope string, shard int) string {
+	if shard == 0 {
+		return bloomKey(scope)
+	}
+	return fmt.Sprintf("%s:%d", bloomKey(scope), shard)
+}
+
+func bloomShardsKey(scope string) string {
+	return bloomKey(scope) + ":shards"
+}
+
+// bloomAddScript adds each ARGV to the newest shard of KEYS[1], unless an
+// older shard has it. KEYS[2] is the shard count. Returns 1 per ID added, 0
+// per ID already seen.
+var bloomAddScript = redis.NewScript(`
+local n = tonumber(redis.call('GET', KEYS[2])) or 1
+local newest = KEYS[1]
+if n > 1 then
+  newest = KEYS[1] .. ':' .. (n - 1)
+end
+local res = {}
+for i, id in ipairs(ARGV) do
+  local seen = 0
+  for s = 0, n - 2 do
+    local key = KEYS[1]
+    if s > 0 then
+      key = key .. ':' .. s
+    end
+    if redis.call('BF.EXISTS', key, id) == 1 then
+      seen = 1
+      break
+    end
+  end
+  if seen == 1 then
+    res[i] = 0
+  else
+    res[i] = redis.call('BF.ADD', newest, id)
+  end
+end
+return res
+`)
+
+// bloomExistsScript returns 1 when any shard of KEYS[1] has ARGV[1]
+var bloomExistsScript = redis.NewScript(`
+local n = tonumber(redis.call('GET', KEYS[2])) or 1
+for s = 0, n - 1 do
+  local key = KEYS[1]
+  if s > 0 then
+    key = key .. ':' .. s
+  end
+  if redis.call('BF.EXISTS', key, ARGV[1]) == 1 then
+    return 1
+  end
+end
+return 0
+`)
+
+// bloomRollScript bumps the shard count KEYS[1] from ARGV[1] to ARGV[1]+1,
+// unless another aggregator already did; ARGV[2] is its TTL in ms
+var bloomRollScript = redis.NewScript(`
+local n = tonumber(redis.call('GET', KEYS[1])) or 1
+if n ~= tonumber(ARGV[1]) then
+  return 0
+end
+redis.call('SET', KEYS[1], n + 1, 'PX', ARGV[2])
+return 1
+`)
+
+// bloomAdd adds ids to scope's filter and reports, per ID, whether it was
+// new
+func bloomAdd(ctx context.Context, rdb *redis.Client, scope string, ids ...interface{}) ([]bool, error) {
+	res, err := bloomAddScript.Run(ctx, rdb, []string{bloomKey(scope), bloomShardsKey(scope)}, ids...).Int64Slice()
+	if err != nil {
+		return nil, err
+	}
+	if len(res) != len(ids) {
+		return nil, fmt.Errorf("bloom add returned %d results for %d IDs", len(res), len(ids))
+	}
+	added := make([]bool, len(res))
+	for i, r := range res {
+		added[i] = r == 1
+	}
+	return added, nil
+}
+
+// bloomExists reports whether scope's filter has eventID
+func bloomExists(ctx context.Context, rdb *redis.Client, scope, eventID string) (bool, error) {
+	n, err := bloomExistsScript.Run(ctx, rdb, []string{bloomKey(scope), bloomShardsKey(scope)}, eventID).Int64()
+	return n == 1, err
+}
+
+// bloomMonitor watches the fill of the day filters, warns as one nears its
+// capacity and rolls it to a new shard before it's full. It also alerts when
+// the false-positive rate rises past the configured one, estimated from the
+// fill or measured by the dedup audit.
+type bloomMonitor struct {
+	redis     *redis.Client
+	interval  time.Duration
+	warnFill  float64 // warn once a shard is this full
+	rollFill  float64 // add a shard once the newest is this full
+	maxShards int
+	audit     bool // the dedup audit measures the actual rate
+
+	mu     sync.Mutex
+	warned map[string]bool // "<key>" or "<key> fp", warned about once
+}
+
+// bloomMonitorFromEnv reads BLOOM_MONITOR_INTERVAL, BLOOM_WARN_FILL,
+// BLOOM_ROLL_FILL and BLOOM_MAX_SHARDS; nil when BLOOM_MONITOR_INTERVAL is 0
+func bloomMonitorFromEnv(rdb *redis.Client, audit bool) (*bloomMonitor, error) {
+	m := &bloomMonitor{
+		redis:     rdb,
+		interval:  getEnvDuration("BLOOM_MONITOR_INTERVAL", time.Minute),
+		warnFill:  getEnvFloat("BLOOM_WARN_FILL", 0.8),
+		rollFill:  getEnvFloat("BLOOM_ROLL_FILL", 0.9),
+		maxShards: getEnvInt("BLOOM_MAX_SHARDS", 4),
+		audit:     audit,
+		warned:    make(map[string]bool),
+	}
+	switch {
+	case m.interval == 0:
+		return nil, nil
+	case m.interval < 0:
+		return nil, fmt.Errorf("BLOOM_MONITOR_INTERVAL must be positive, or 0 for off")
+	case m.warnFill <= 0 || m.rollFill <= 0 || m.warnFill > 1 || m.rollFill > 1:
+		return nil, fmt.Errorf("BLOOM_WARN_FILL and BLOOM_ROLL_FILL must be between 0 and 1")
+	case m.maxShards < 1:
+		return nil, fmt.Errorf("BLOOM_MAX_SHARDS must be at least 1")
+	}
+	return m, nil
+}
+
+func (m *bloomMonitor) String() string {
+	return fmt.Sprintf("every %s, warn at %.0f%%, new shard at %.0f%% (up to %d per day)",
+		m.interval, m.warnFill*100, m.rollFill*100, m.maxShards)
+}
+
+// run checks the filters every interval until ctx is done
+func (m *bloomMonitor) run(ctx context.Context) {
+	t := time.NewTicker(m.interval)
+	defer t.Stop()
+	for {
+		m.check(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// check looks at the filters of every day still in the dedup window
+func (m *bloomMonitor) check(ctx context.Context) {
+	now := time.Now().UTC()
+	window := 0
+	for i := 0; i < bloomTTLDays; i++ {
+		day := now.AddDate(0, 0, -i).Format(storage.DayFormat)
+		ok, err := m.checkDay(ctx, day)
+		if err != nil {
+			if ctx.Err() == nil {
+				metricBloomMonitorErrors.Add(1)
+				log.Printf("Warning: bloom filter check of %s failed: %v", day, err)
+			}
+			continue
+		}
+		if ok {
+			window++
+		} else {
+			metricBloomFill.Delete(day)
+			metricBloomItems.Delete(day)
+			metricBloomShards.Delete(day)
+			metricBloomFPRate.Delete(day)
+		}
+	}
+	metricBloomWindow.Set(int64(window))
+	m.checkAudit()
+}
+
+// checkDay updates day's metrics and rolls it when its newest shard is
+// nearly full. It reports whether the day has a filter.
+func (m *bloomMonitor) checkDay(ctx context.Context, day string) (bool, error) {
+	shards, err := m.redis.Get(ctx, bloomShardsKey(day)).Int()
+	switch {
+	case err == redis.Nil:
+		shards = 1
+	case err != nil:
+		return false, err
+	}
+
+	var items, newest, capacity int64
+	var fill float64
+	notFP := 1.0
+	for s := 0; s < shards; s++ {
+		n, c, err := bloomInfo(ctx, m.redis, bloomShardKey(day, s))
+		if err != nil {
+			return false, err
+		}
+		if c == 0 {
+			if s == 0 {
+				return false, nil // not created yet, or expired
+			}
+			continue
+		}
+		items += n
+		newest, capacity, fill = n, c, float64(n)/float64(c)
+		notFP *= 1 - bloomFPRate(fill)
+	}
+	fpRate := 1 - notFP
+
+	metricBloomFill.Set(day, floatVar(fill))
+	metricBloomItems.Set(day, intVar(items))
+	metricBloomShards.Set(day, intVar(int64(shards)))
+	metricBloomFPRate.Set(day, floatVar(fpRate))
+
+	key := bloomShardKey(day, shards-1)
+	switch {
+	case fill >= m.rollFill && shards < m.maxShards:
+		return true, m.roll(ctx, day, shards, capacity, fill)
+	case fill >= m.rollFill:
+		m.warnOnce(key, "ALERT: bloom filter %s is %.0f%% full and %s has its %d shards (BLOOM_MAX_SHARDS): new listens will increasingly be dropped as duplicates",
+			key, fill*100, day, shards)
+	case fill >= m.warnFill:
+		m.warnOnce(key, "Warning: bloom filter %s is %.0f%% full (%d of %d items), a new shard comes at %.0f%%",
+			key, fill*100, newest, capacity, m.rollFill*100)
+	}
+	if fpRate > 2*bloomErrorRate {
+		m.warnOnce(key+" fp", "ALERT: bloom filters of %s have an estimated false-positive rate of %.3f%% (configured %.2f%%)",
+			day, fpRate*100, bloomErrorRate*100)
+	}
+	return true, nil
+}
+
+// roll adds shard number shards to day, sized and expiring like shard 0.
+// When several aggregators roll at once one bumps the count; the others
+// find it done.
+func (m *bloomMonitor) roll(ctx context.Context, day string, shards int, capacity int64, fill float64) error {
+	ttl, err := m.redis.PTTL(ctx, bloomKey(day)).Result()
+	if err != nil {
+		return err
+	}
+	if ttl <= 0 {
+		_, ttl = bloomSize(day)
+	}
+	key := bloomShardKey(day, shards)
+	err = m.redis.Do(ctx, "BF.RESERVE", key, bloomErrorRate, capacity, "NONSCALING").Err()
+	if err != nil && !strings.Contains(err.Error(), "item exists") {
+		return fmt.Errorf("reserve %s: %w", key, err)
+	}
+	if err := m.redis.PExpire(ctx, key, ttl).Err(); err != nil {
+		return err
+	}
+	rolled, err := bloomRollScript.Run(ctx, m.redis, []string{bloomShardsKey(day)}, shards, ttl.Milliseconds()).Int()
+	if err != nil {
+		return fmt.Errorf("roll %s: %w", day, err)
+	}
+	if rolled == 1 {
+		metricBloomRolls.Add(1)
+		log.Printf("Warning: bloom filter %s is %.0f%% full, new event IDs of %s go to %s",
+			bloomShardKey(day, shards-1), fill*100, day, key)
+	}
+	return nil
+}
+
+// checkAudit alerts when the dedup audit measures twice the configured
+// false-positive rate, once it has enough samples to tell
+func (m *bloomMonitor) checkAudit() {
+	if !m.audit || metricAuditNew.Value() < 1000 {
+		return
+	}
+	if rate := auditFPRate(); rate > 2*bloomErrorRate {
+		m.warnOnce("audit fp", "ALERT: dedup audit measures a %.3f%% false-positive rate (configured %.2f%%): the bloom filters drop new listens",
+			rate*100, bloomErrorRate*100)
+	}
+}
+
+// warnOnce logs a problem the first time it's seen, not on every check
+func (m *bloomMonitor) warnOnce(key, format string, args ...interface{}) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.warned[key] {
+		return
+	}
+	m.warned[key] = true
+	log.Printf(format, args...)
+}
+
+// bloomInfo returns a filter's items and capacity, 0 and 0 when it doesn't
+// exist
+func bloomInfo(ctx context.Context, rdb *redis.Client, key string) (int64, int64, error) {
+	res, err := rdb.Do(ctx, "BF.INFO", key).Result()
+	if err != nil {
+		if strings.Contains(strings.ToLower(err.Error()), "not found") {
+			return 0, 0, nil
+		}
+		return 0, 0, err
+	}
+	info := make(map[string]int64)
+	switch v := res.(type) {
+	case []interface{}: // RESP2: name, value, ...
+		for i := 0; i+1 < len(v); i += 2 {
+			name, _ := v[i].(string)
+			n, _ := v[i+1].(int64)
+			info[name] = n
+		}
+	case map[interface{}]interface{}: // RESP3
+		for k, val := range v {
+			name, _ := k.(string)
+			n, _ := val.(int64)
+			info[name] = n
+		}
+	default:
+		return 0, 0, fmt.Errorf("unexpected type %T from BF.INFO", res)
+	}
+	return info["Number of items inserted"], info["Capacity"], nil
+}
+
+// bloomFPRate estimates the false-positive rate of a filter sized for
+// bloomErrorRate once fill (items over capacity) of it is used: RedisBloom
+// gives it -ln(p)/ln²2 bits and ⌈-log2 p⌉ hashes per item
+func bloomFPRate(fill float64) float64 {
+	bits := -math.Log(bloomErrorRate) / (math.Ln2 * math.Ln2)
+	hashes := math.Ceil(-math.Log2(bloomErrorRate))
+	return math.Pow(1-math.Exp(-hashes*fill/bits), hashes)
+}
+
+func floatVar(f float64) *expvar.Float {
+	v := new(expvar.Float)
+	v.Set(f)
+	return v
+}
+
+func intVar(n int64) *expvar.Int {
+	v := new(expvar.Int)
+	v.Set(n)
+	return v
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/system-design-lab/pkg/kafkautil"
+)
+
+// Commit strategies (COMMIT_STRATEGY): when a flush's offsets are committed
+// relative to its counter writes
+const (
+	commitAfterWrite  = "commit-after-write"  // at-least-once: the bloom filter skips replays
+	commitBeforeWrite = "commit-before-write" // at-most-once: a crash loses the flush
+	commitTransaction = "transactional"       // exactly-once sink: the apply log fences replays
+)
+
+// commitTradeoffs is logged at startup so an experiment's logs say what
+// guarantee it ran with
+var commitTradeoffs = map[string]string{
+	commitAfterWrite: "at-least-once: a crash after the writes replays the flush; the bloom filter " +
+		"skips its events, except ones marked but never flushed, whose counts are lost",
+	commitBeforeWrite: "at-most-once: a crash or failed write after the commit loses the flush's counts; " +
+		"a commit that fails is written anyway and replays after a crash, where the bloom filter skips it",
+	commitTransaction: "exactly-once per partition: each flush is claimed and completed in applied_flushes " +
+		"(two LWTs per partition), redelivered offsets are skipped",
+}
+
+// commitStrategy checks COMMIT_STRATEGY against the sink. The transactional
+// strategy is the exactly-once sink's and the only one it supports; unset
+// means the sink's default.
+func commitStrategy(strategy, sinkMode string) (string, error) {
+	if strategy == "" {
+		if sinkMode == sinkExactlyOnce {
+			return commitTransaction, nil
+		}
+		return commitAfterWrite, nil
+	}
+	switch strategy {
+	case commitAfterWrite, commitBeforeWrite:
+		if sinkMode == sinkExactlyOnce {
+			return "", fmt.Errorf("SINK_MODE=exactly-once only commits %s", commitTransaction)
+		}
+	case commitTransaction:
+		if sinkMode != sinkExactlyOnce {
+			return "", fmt.Errorf("%s needs SINK_MODE=exactly-once", commitTransaction)
+		}
+	default:
+		return "", fmt.Errorf("want %s, %s or %s", commitAfterWrite, commitBeforeWrite, commitTransaction)
+	}
+	return strategy, nil
+}
+
+// commitRanges commits the counter sink's offsets: every partition of the
+// flush up to its last message, in one commit. A flush spans as many
+// partitions as the instance owns, and a commit only moves the partitions it
+// names.
+func (a *Aggregator) commitRanges(ctx context.Context, ranges map[int]offsetRange) {
+	if len(ranges) == 0 {
+		return
+	}
+	topic := a.reader.Config().Topic
+	partitions := make([]int, 0, len(ranges))
+	for p := range ranges {
+		partitions = append(partitions, p)
+	}
+	sort.Ints(partitions)
+	msgs := make([]kafka.Message, len(partitions))
+	committed := make([]string, len(partitions))
+	for i, p := range partitions {
+		msgs[i] = kafka.Message{Topic: topic, Partition: p, Offset: ranges[p].last}
+		committed[i] = fmt.Sprintf("%d=%d", p, ranges[p].last)
+	}
+	if err := kafkautil.CommitWithRetry(ctx, a.reader, 3, msgs...); err != nil {
+		log.Printf("Error committing offsets: %v", err)
+		metricCommitErrors.Add(1)
+		return
+	}
+	log.Printf("Committed offsets: %s", strings.Join(committed, " "))
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/system-design-lab/pkg/events"
+	"github.com/system-design-lab/pkg/freshness"
+	"github.com/system-design-lab/pkg/kafkautil"
+)
+
+// publishDeltas sends what this flush added, one message per user and day, to
+// the deltas topic, with the publish times of the day's listens. It runs
+// after the counters are written and before the offset commit; downstream
+// consumers are best effort, so a failed publish is logged and the flush
+// goes on.
+func (a *Aggregator) publishDeltas(ctx context.Context, counts map[AggregateKey]int64, published map[userDay]freshness.Published) {
+	if a.deltas == nil || len(counts) == 0 {
+		return
+	}
+
+	// A song can have several keys when its events disagree on the artist;
+	// deltas are per song
+	grouped := make(map[userDay]map[string]int64)
+	for key, delta := range counts {
+		k := userDay{key.UserID, key.Day}
+		if grouped[k] == nil {
+			grouped[k] = make(map[string]int64)
+		}
+		grouped[k][key.SongID] += delta
+	}
+
+	now := time.Now().Unix()
+	msgs := make([]kafka.Message, 0, len(grouped))
+	for k, bySong := range grouped {
+		songs := make([]events.SongDelta, 0, len(bySong))
+		for song, delta := range bySong {
+			songs = append(songs, events.SongDelta{SongID: song, Delta: delta})
+		}
+		value, err := events.MarshalDelta(events.AggregateDelta{
+			UserID: k.user, Day: k.day, Songs: songs, FlushedAt: now, Published: published[k],
+		})
+		if err != nil {
+			log.Printf("Error encoding delta for %s/%s: %v", k.user, k.day, err)
+			continue
+		}
+		msgs = append(msgs, kafka.Message{Key: []byte(k.user), Value: value})
+	}
+	kafkautil.Inject(ctx, msgs)
+
+	if err := a.deltas.WriteMessages(ctx, msgs...); err != nil {
+		log.Printf("Error publishing %d deltas to %s: %v", len(msgs), a.deltas.Topic, err)
+		metricDeltaErrors.Add(1)
+		return
+	}
+	metricDeltasPublished.Add(int64(len(msgs)))
+}
@@ -0,0 +1,335 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/system-design-lab/pkg/idgen"
+	"github.com/system-design-lab/pkg/storage"
+)
+
+const (
+	sinkCounter     = "counter"      // increment counters, the bloom filter catches replays
+	sinkExactlyOnce = "exactly-once" // fence every flush through applied_flushes
+	sinkReduce      = "reduce"       // publish flushes for the reducers to write (reduce.go)
+)
+
+// offsetRange is the offsets of one partition a flush covers
+type offsetRange struct {
+	first, last int64
+}
+
+// pendingID is an accumulated event awaiting its flush, for the bloom marks
+type pendingID struct {
+	day       string // bloom filter day, see dedupScope
+	partition int
+}
+
+// exactlyOnce keeps each partition's row of the apply log. Offsets up to a
+// partition's last applied flush are skipped on redelivery, and each flush is
+// claimed before its increments and completed after, so re-applying the same
+// offsets after a crash or rebalance is a no-op.
+type exactlyOnce struct {
+	flushes *storage.AppliedFlushRepo
+	group   string
+	topic   string
+	ids     *idgen.Generator
+
+	mu      sync.Mutex
+	applied map[int]appliedState // loaded on a partition's first message
+}
+
+type appliedState struct {
+	flush  storage.AppliedFlush
+	exists bool
+}
+
+func newExactlyOnce(flushes *storage.AppliedFlushRepo, group, topic string, ids *idgen.Generator) *exactlyOnce {
+	return &exactlyOnce{flushes: flushes, group: group, topic: topic, ids: ids, applied: make(map[int]appliedState)}
+}
+
+// alreadyApplied reports whether msg is covered by an applied flush, i.e. a
+// redelivery after a crash or rebalance
+func (x *exactlyOnce) alreadyApplied(ctx context.Context, msg kafka.Message) bool {
+	st, err := x.state(ctx, msg.Partition)
+	if err != nil {
+		return false // shutting down; nothing is flushed after this
+	}
+	return msg.Offset <= st.flush.LastOffset
+}
+
+// state returns a partition's apply log, loading it on first use. Loading is
+// retried until it succeeds: without it the partition's offsets can't be
+// judged.
+func (x *exactlyOnce) state(ctx context.Context, partition int) (appliedState, error) {
+	x.mu.Lock()
+	st, ok := x.applied[partition]
+	x.mu.Unlock()
+	if ok {
+		return st, nil
+	}
+
+	backoff := 500 * time.Millisecond
+	for {
+		f, exists, err := x.flushes.Get(ctx, x.group, x.topic, partition)
+		if err == nil {
+			st = appliedState{flush: f, exists: exists}
+			break
+		}
+		log.Printf("Error loading apply log for partition %d (retrying in %s): %v", partition, backoff, err)
+		select {
+		case <-ctx.Done():
+			return st, ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > 10*time.Second {
+			backoff = 10 * time.Second
+		}
+	}
+
+	if st.flush.PendingID != "" {
+		st = x.recover(ctx, partition, st)
+	}
+	x.mu.Lock()
+	x.applied[partition] = st
+	x.mu.Unlock()
+	log.Printf("Apply log for partition %d: last flush %q (offset %d)", partition, st.flush.FlushID, st.flush.LastOffset)
+	return st, nil
+}
+
+// recover settles a flush that was claimed and never completed: the process
+// stopped while applying it. Counters can't tell which of its increments
+// landed, so rather than risk counting them twice it is completed as is and
+// reported; replay its offsets from history to reconcile.
+func (x *exactlyOnce) recover(ctx context.Context, partition int, st appliedState) appliedState {
+	f := st.flush
+	log.Printf("ALERT: flush %s (partition %d up to offset %d) was claimed but not completed; marking it applied, its counts may be partial",
+		f.PendingID, partition, f.PendingLast)
+	metricFlushesRecovered.Add(1)
+	if err := x.complete(ctx, partition, f.PendingID, f.PendingLast); err != nil {
+		return st // shutting down; the next start recovers it again
+	}
+	return appliedState{
+		flush:  storage.AppliedFlush{FlushID: f.PendingID, LastOffset: f.PendingLast},
+		exists: true,
+	}
+}
+
+// errFenced means another aggregator applied the partition's offsets first
+var errFenced = fmt.Errorf("apply log moved on")
+
+// errOverlap means another aggregator applied only some of the offsets: the
+// rest were applied by no one and have to be read again
+var errOverlap = errors.New("apply log moved on partway through the flush")
+
+// apply claims r of a partition in the apply log, runs write, then completes
+// the claim. A claim error leaves nothing applied, so the caller can retry
+// the counts with the next flush. errFenced means all of r was applied
+// elsewhere (after a rebalance) and the counts must be dropped; errOverlap
+// that part of it was, so they must be dropped and the rest read again.
+func (x *exactlyOnce) apply(ctx context.Context, partition int, r offsetRange, write func()) error {
+	st, err := x.state(ctx, partition)
+	if err != nil {
+		return err
+	}
+	if err := fenced(r, st.flush.LastOffset); err != nil {
+		return err
+	}
+
+	// A fresh ID per claim, so two claims of the same offsets (a retry, or
+	// another aggregator after a rebalance) are told apart in the log
+	id := x.ids.NextString()
+	claimed, err := x.flushes.Claim(ctx, x.group, x.topic, partition, st.flush, st.exists, id, r.last)
+	if err != nil {
+		return err
+	}
+	if !claimed {
+		x.mu.Lock()
+		delete(x.applied, partition) // reload on the next message
+		x.mu.Unlock()
+		// How far the other aggregator got decides what's dropped: typically
+		// the partition's old owner finishing its rebalance flush after this
+		// one loaded the row
+		f, _, err := x.flushes.Get(ctx, x.group, x.topic, partition)
+		if err != nil {
+			return err
+		}
+		through := f.LastOffset
+		if f.PendingID != "" && f.PendingLast > through {
+			through = f.PendingLast // being applied, or recovered as applied
+		}
+		if err := fenced(r, through); err != nil {
+			return err
+		}
+		return fmt.Errorf("apply log moved on to offset %d, short of the flush", through) // claim again
+	}
+
+	write()
+
+	if err := x.complete(ctx, partition, id, r.last); err != nil {
+		// Left pending: the next start (or owner) recovers it as applied
+		x.mu.Lock()
+		delete(x.applied, partition)
+		x.mu.Unlock()
+		return nil
+	}
+	x.mu.Lock()
+	x.applied[partition] = appliedState{flush: storage.AppliedFlush{FlushID: id, LastOffset: r.last}, exists: true}
+	x.mu.Unlock()
+	return nil
+}
+
+// fenced returns errFenced if offsets up to through cover all of r, errOverlap
+// if they cover part of it, and nil if none
+func fenced(r offsetRange, through int64) error {
+	switch {
+	case r.last <= through:
+		return errFenced
+	case r.first <= through:
+		return fmt.Errorf("%w: applied through offset %d", errOverlap, through)
+	}
+	return nil
+}
+
+// complete retries Complete until it succeeds or ctx ends. The increments are
+// already written, so giving up early would only leave the flush pending.
+func (x *exactlyOnce) complete(ctx context.Context, partition int, id string, last int64) error {
+	backoff := 500 * time.Millisecond
+	for {
+		ok, err := x.flushes.Complete(ctx, x.group, x.topic, partition, id, last)
+		if err == nil && ok {
+			return nil
+		}
+		if err == nil {
+			// Someone else recovered it, which also marks it applied
+			log.Printf("Flush %s was completed by another aggregator", id)
+			return nil
+		}
+		log.Printf("Error completing flush %s (retrying in %s): %v", id, backoff, err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > 10*time.Second {
+			backoff = 10 * time.Second
+		}
+	}
+}
+
+// applyExactlyOnce writes counts partition by partition, each under its own
+// claim. It returns the daily rollup that was written, the offsets to commit
+// and the partitions to read again. Partitions whose claim failed go back
+// into the accumulator for the next flush; fenced ones are dropped, and so
+// are overlapped ones, whose unapplied offsets the caller re-reads (rewind).
+func (a *Aggregator) applyExactlyOnce(ctx context.Context, counts, millis map[AggregateKey]int64, ranges map[int]offsetRange, ids map[string]pendingID) (map[AggregateKey]int64, []kafka.Message, map[int]bool) {
+	byPartition := splitPartitions(counts)
+	millisByPartition := splitPartitions(millis)
+
+	daily := make(map[AggregateKey]int64)
+	var commits []kafka.Message
+	applied := make(map[int]bool, len(ranges))
+	reread := make(map[int]bool)
+	for partition, r := range ranges {
+		part, partMillis := byPartition[partition], millisByPartition[partition]
+		err := a.once.apply(ctx, partition, r, func() {
+			for key, delta := range a.applyCounts(ctx, part, partMillis) {
+				daily[key] = delta
+			}
+		})
+		switch {
+		case err == errFenced:
+			log.Printf("Dropping %d aggregates of partition %d offsets %d-%d: already applied by another aggregator",
+				len(part), partition, r.first, r.last)
+			metricFlushesFenced.Add(1)
+		case errors.Is(err, errOverlap):
+			log.Printf("Dropping %d aggregates of partition %d offsets %d-%d to read them again: %v",
+				len(part), partition, r.first, r.last, err)
+			metricFlushesRewound.Add(1)
+			reread[partition] = true
+		case err != nil:
+			log.Printf("Error claiming partition %d offsets %d-%d, retrying with the next flush: %v", partition, r.first, r.last, err)
+			metricFlushErrors.Add(1)
+			a.requeue(part, partMillis, partition, r, ids)
+		default:
+			applied[partition] = true
+			commits = append(commits, kafka.Message{Topic: a.once.topic, Partition: partition, Offset: r.last})
+		}
+	}
+
+	a.markSeen(ctx, ids, applied)
+	return daily, commits, reread
+}
+
+func splitPartitions(counts map[AggregateKey]int64) map[int]map[AggregateKey]int64 {
+	byPartition := make(map[int]map[AggregateKey]int64)
+	for key, delta := range counts {
+		if byPartition[key.Partition] == nil {
+			byPartition[key.Partition] = make(map[AggregateKey]int64)
+		}
+		byPartition[key.Partition][key] = delta
+	}
+	return byPartition
+}
+
+// requeue puts a partition's unapplied counts and time back for the next
+// flush, which then covers their offsets too
+func (a *Aggregator) requeue(counts, millis map[AggregateKey]int64, partition int, r offsetRange, ids map[string]pendingID) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for key, delta := range counts {
+		a.counts[key] += delta
+	}
+	for key, ms := range millis {
+		a.millis[key] += ms
+	}
+	if cur, ok := a.ranges[partition]; ok {
+		r.last = cur.last
+	}
+	a.ranges[partition] = r
+	for id, p := range ids {
+		if p.partition == partition {
+			a.pendingIDs[id] = p
+		}
+	}
+}
+
+// seenBefore is the exactly-once bloom check. Events are only checked here
+// and marked once their flush is applied (markSeen): marking on arrival, as
+// the counter sink does, would make an unflushed event look like a duplicate
+// when a crash redelivers it.
+func (a *Aggregator) seenBefore(ctx context.Context, day, eventID string) (bool, error) {
+	a.mu.Lock()
+	_, pending := a.pendingIDs[eventID]
+	a.mu.Unlock()
+	if pending {
+		return true, nil
+	}
+	return bloomExists(ctx, a.redis, day, eventID)
+}
+
+// markSeen adds the applied partitions' event IDs to the day bloom filters.
+// Best effort: a missed mark only lets a later duplicate of the event through.
+func (a *Aggregator) markSeen(ctx context.Context, ids map[string]pendingID, applied map[int]bool) {
+	byDay := make(map[string][]interface{})
+	for id, p := range ids {
+		if applied[p.partition] {
+			byDay[p.day] = append(byDay[p.day], id)
+		}
+	}
+	for day, dayIDs := range byDay {
+		if err := a.ensureBloomFilter(ctx, day); err != nil {
+			log.Printf("Warning: failed to ensure bloom filter: %v", err)
+		}
+		if _, err := bloomAdd(ctx, a.redis, day, dayIDs...); err != nil {
+			log.Printf("Warning: bloom add failed for %d events of %s: %v", len(dayIDs), day, err)
+		}
+	}
+}
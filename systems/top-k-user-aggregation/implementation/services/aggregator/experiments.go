@@ -0,0 +1,32 @@
+package main
+
+import (
+	"context"
+	"log"
+)
+
+// applyExperimentCounts adds a flush's stored daily counts of tagged listens
+// to the per-experiment counters. accumulate only keeps a tag on the days its
+// experiment runs, so a stale or unknown tag is counted like an untagged
+// listen. Derived like the artist counters: a failure costs the experiment
+// reads only.
+func (a *Aggregator) applyExperimentCounts(ctx context.Context, daily map[AggregateKey]int64) {
+	if a.experimentTopK == nil {
+		return
+	}
+	type experimentSong struct{ user, day, experiment, song string }
+	counts := make(map[experimentSong]int64)
+	for key, delta := range daily {
+		if key.Experiment != "" {
+			counts[experimentSong{key.UserID, key.Day, key.Experiment, key.SongID}] += delta
+		}
+	}
+	for k, delta := range counts {
+		if err := a.experimentTopK.Increment(ctx, k.user, k.day, k.experiment, k.song, delta); err != nil {
+			log.Printf("Error updating experiment counter: %v", err)
+			metricExperimentErrors.Add(1)
+			continue
+		}
+		metricExperimentListens.Add(delta)
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/system-design-lab/pkg/freshness"
+	"github.com/system-design-lab/pkg/kafkautil"
+)
+
+// userDay is one user's day, the unit deltas are published in
+type userDay struct{ user, day string }
+
+// notePublished records the publish time of a counted listen, when its
+// producer stamped one; a.mu must be held. Replays were published long ago
+// and aren't fresh listens.
+func (a *Aggregator) notePublished(key AggregateKey, msg kafka.Message) {
+	h := kafkautil.ParseHeaders(msg)
+	if h.PublishedAt.IsZero() || h.IsReplay() {
+		return
+	}
+	k := userDay{key.UserID, key.Day}
+	if a.published[k] == nil {
+		a.published[k] = make(freshness.Published)
+	}
+	a.published[k].Add(h.PublishedAt)
+}
+
+// observeFlushed counts a flush's listens in event_to_flush_latency, from
+// their publish to the end of the flush's writes
+func observeFlushed(published map[userDay]freshness.Published) {
+	now := time.Now()
+	for _, p := range published {
+		p.Observe(metricEventToFlush, now)
+	}
+}
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/segmentio/kafka-go"
+	"github.com/system-design-lab/pkg/dc"
+	"github.com/system-design-lab/pkg/events"
+	"github.com/system-design-lab/pkg/freshness"
+	"github.com/system-design-lab/pkg/idgen"
+	"github.com/system-design-lab/pkg/kafkautil"
+	"github.com/system-design-lab/pkg/runtimecfg"
+	"github.com/system-design-lab/pkg/sqlstore"
+	"github.com/system-design-lab/pkg/storage"
+)
+
+// instanceConfig is what the aggregator instances of one process share
+type instanceConfig struct {
+	kafka         kafkautil.Config
+	session       *storage.Session
+	sql           *sqlstore.DB // STORAGE_BACKEND database; nil = Cassandra (session)
+	redis         *redis.Client
+	topic         string
+	group         string
+	sinkMode      string
+	commit        string
+	flushInterval time.Duration
+	minPlayMs     int64 // MIN_PLAY_MS
+	songStats     bool
+	audit         *dedupAudit   // nil = no dedup audit
+	deltas        *kafka.Writer // nil = don't publish deltas
+	partials      *kafka.Writer // SINK_MODE=reduce: where flushes go instead of Cassandra
+	reducer       bool          // REDUCER: consume partials rather than listens
+	takedowns     *storage.TakedownSet
+	experiments   *storage.ExperimentSet
+	dual          *dualWrite       // nil = no dual write
+	watermarks    *watermarkConfig // nil = no watermarks
+}
+
+// newAggregator creates one member of the consumer group, with its own
+// reader, in-memory counts and (exactly-once) flush-ID lease. Close it to
+// leave the group.
+func newAggregator(ctx context.Context, c instanceConfig, name string) (*Aggregator, error) {
+	newReader := func() *kafka.Reader {
+		return c.kafka.NewReader(kafkautil.ReaderConfig{Topic: c.topic, GroupID: c.group, Member: name})
+	}
+	reader := newReader()
+	a := &Aggregator{
+		name:          name,
+		counts:        make(map[AggregateKey]int64),
+		millis:        make(map[AggregateKey]int64),
+		audit:         c.audit,
+		reader:        reader,
+		newReader:     newReader,
+		redis:         c.redis,
+		deltas:        c.deltas,
+		takedowns:     c.takedowns,
+		ranges:        make(map[int]offsetRange),
+		pendingIDs:    make(map[string]pendingID),
+		published:     make(map[userDay]freshness.Published),
+		eventTimes:    make(map[int]int64),
+		fetched:       make(map[int]int64),
+		settings:      runtimecfg.New(c.redis, "aggregator"),
+		commit:        c.commit,
+		flushInterval: c.flushInterval,
+		minPlayMs:     c.minPlayMs,
+	}
+	if c.sql != nil {
+		a.topk, a.store = sqlstore.NewDailyTopKRepo(c.sql), c.sql.Backend()
+	} else {
+		a.topk, a.store = storage.NewDailyTopKRepo(c.session), "Cassandra"
+		a.hourly = storage.NewHourlyTopKRepo(c.session)
+		a.artists = storage.NewDailyArtistTopKRepo(c.session)
+		a.totals = storage.NewUserTotalsRepo(c.session)
+		a.experimentTopK = storage.NewExperimentTopKRepo(c.session)
+		if c.songStats {
+			a.songs = storage.NewSongStatsRepo(c.session)
+		}
+	}
+	a.experiments = c.experiments
+	a.dual = c.dual
+	a.partials = c.partials
+	a.reducer = c.reducer
+	if c.watermarks != nil {
+		a.marks = newWatermarks(*c.watermarks, c.redis, c.group, reader.Config().Topic, name)
+	}
+	if c.sinkMode == sinkExactlyOnce {
+		ids, lease, err := idgen.FromEnv(ctx, c.redis)
+		if err != nil {
+			reader.Close()
+			return nil, fmt.Errorf("worker ID for flush IDs: %w", err)
+		}
+		a.lease = lease
+		// Offsets belong to one cluster, so each DC keeps its own apply log rows
+		a.once = newExactlyOnce(storage.NewAppliedFlushRepo(c.session), dc.Prefix()+c.group, reader.Config().Topic, ids)
+		log.Printf("%s: exactly-once sink, flushes are fenced through applied_flushes (worker ID %d)", name, ids.Worker())
+	}
+	return a, nil
+}
+
+// close leaves the consumer group and releases the worker ID. Flush first:
+// counts still in memory are dropped.
+func (a *Aggregator) close() {
+	reader, _ := a.currentReader()
+	reader.Close()
+	if a.lease != nil {
+		a.lease.Release(context.Background())
+	}
+}
+
+// run consumes until ctx is done, flushing every flush interval and after
+// each rebalance
+func (a *Aggregator) run(ctx context.Context) {
+	go a.flushLoop(ctx)
+	go a.watchRebalances(ctx)
+	if a.marks != nil {
+		go a.marks.run(ctx)
+	}
+
+	for {
+		reader, gen := a.currentReader()
+		msg, err := reader.FetchMessage(ctx)
+		a.fetchMu.Lock() // waits out a rewind
+		if _, now := a.currentReader(); now != gen {
+			// Fetched from (or closed with) a reader a rewind replaced: the
+			// new one reads it again
+			a.fetchMu.Unlock()
+			continue
+		}
+		if err != nil {
+			a.fetchMu.Unlock()
+			if ctx.Err() != nil {
+				return
+			}
+			log.Printf("%s: error fetching message: %v", a.name, err)
+			continue
+		}
+		a.handle(ctx, msg)
+		a.fetchMu.Unlock()
+	}
+}
+
+// handle accumulates (or, as a reducer, merges) one fetched message
+func (a *Aggregator) handle(ctx context.Context, msg kafka.Message) {
+	a.mu.Lock()
+	if msg.Offset >= a.fetched[msg.Partition] {
+		a.fetched[msg.Partition] = msg.Offset + 1
+	}
+	a.mu.Unlock()
+	if a.marks != nil {
+		a.marks.fetched(msg)
+	}
+
+	if a.reducer {
+		a.reducePartial(ctx, msg)
+		return
+	}
+
+	event, err := events.Unmarshal(msg.Value)
+	if err == nil {
+		err = event.Validate()
+	}
+	if err != nil {
+		log.Printf("Error decoding event: %v", err)
+		a.skip(msg) // committed with its partition's next flush
+		return
+	}
+	if kafkautil.ParseHeaders(msg).IsReplay() {
+		metricReplayedListens.Add(1)
+	}
+
+	a.accumulate(ctx, event, msg)
+}
+
+// flushLoop flushes every flush interval. Runtime config: dedup_enabled,
+// flush_interval and min_play_ms (pkg/runtimecfg).
+func (a *Aggregator) flushLoop(ctx context.Context) {
+	ticker := time.NewTicker(a.flushInterval)
+	defer ticker.Stop()
+	a.settings.OnChange(func() {
+		if d := a.settings.Duration("flush_interval", a.flushInterval); d > 0 {
+			ticker.Reset(d)
+		}
+	})
+	if err := a.settings.Start(ctx); err != nil {
+		log.Printf("Warning: runtime config not loaded, using env values until Redis answers: %v", err)
+	}
+
+	for {
+		select {
+		case <-ticker.C:
+			a.flush(ctx)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// watchRebalances flushes as soon as the reader has joined a new generation
+// of the group. kafka-go has no revocation callback, so this runs just after
+// a rebalance rather than before it: counts held for partitions that moved
+// are still written by this instance, within a second instead of at the next
+// tick, and their redeliveries to the new owner are skipped by the bloom
+// filter (counter sink) or the apply log (exactly-once).
+func (a *Aggregator) watchRebalances(ctx context.Context) {
+	t := time.NewTicker(time.Second)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			return
+		}
+		// Stats counters are reset by each call: this is the only caller
+		if reader, _ := a.currentReader(); reader.Stats().Rebalances == 0 {
+			continue
+		}
+		a.mu.Lock()
+		pending := len(a.counts) > 0 || a.hasMsg
+		a.mu.Unlock()
+		if pending {
+			log.Printf("%s: consumer group rebalanced, flushing", a.name)
+			metricRebalanceFlushes.Add(1)
+			a.flush(ctx)
+		}
+		if a.marks != nil {
+			a.marks.rebalanced(ctx)
+		}
+	}
+}
+
+// currentReader returns the reader and the number of rewinds before it
+func (a *Aggregator) currentReader() (*kafka.Reader, int) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.reader, a.readerGen
+}
+
+// rewind drops everything accumulated and restarts the reader, so every
+// partition is read again from its committed offset, skipping what the apply
+// log covers. It's how the exactly-once sink re-reads an overlapped flush
+// (errOverlap): a group reader can't seek back. The restart is a rebalance
+// of its own. Called by flush, after its commits.
+func (a *Aggregator) rewind() {
+	a.fetchMu.Lock()
+	defer a.fetchMu.Unlock()
+	a.mu.Lock()
+	a.reset()
+	old := a.reader
+	a.mu.Unlock()
+
+	old.Close() // leave before rejoining, so the group doesn't hold the member twice
+	a.mu.Lock()
+	a.reader = a.newReader()
+	a.readerGen++
+	a.mu.Unlock()
+	log.Printf("%s: rejoined the group to read again from the committed offsets", a.name)
+}
+
+// fetchedOffsets returns, per partition, the offset after the last message
+// this instance fetched
+func (a *Aggregator) fetchedOffsets() map[int]int64 {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	out := make(map[int]int64, len(a.fetched))
+	for p, off := range a.fetched {
+		out[p] = off
+	}
+	return out
+}
+
+// instanceName names instance i of n in logs; a lone instance is just the
+// aggregator
+func instanceName(i, n int) string {
+	if n == 1 {
+		return "aggregator"
+	}
+	return fmt.Sprintf("aggregator-%d", i)
+}
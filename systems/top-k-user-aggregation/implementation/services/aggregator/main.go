@@ -2,47 +2,102 @@ package main
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 	"log"
 	"os"
 	"os/signal"
+	"strconv"
 	"strings"
 	"sync"
 	"syscall"
 	"time"
 
-	"github.com/gocql/gocql"
 	"github.com/redis/go-redis/v9"
 	"github.com/segmentio/kafka-go"
+	"github.com/system-design-lab/pkg/chaos"
+	"github.com/system-design-lab/pkg/events"
+	"github.com/system-design-lab/pkg/freshness"
+	"github.com/system-design-lab/pkg/idgen"
+	"github.com/system-design-lab/pkg/kafkautil"
+	"github.com/system-design-lab/pkg/redisutil"
+	"github.com/system-design-lab/pkg/runtimecfg"
+	"github.com/system-design-lab/pkg/sqlstore"
+	"github.com/system-design-lab/pkg/startup"
+	"github.com/system-design-lab/pkg/storage"
+	"github.com/system-design-lab/pkg/topology"
 )
 
-// ListenEvent matches the event published by crawl-worker
-type ListenEvent struct {
-	EventID    string `json:"event_id"`
-	UserID     string `json:"user_id"`
-	SongID     string `json:"song_id"`
-	Provider   string `json:"provider"`
-	ListenedAt int64  `json:"listened_at"`
-}
-
 // AggregateKey is the key for in-memory counts
 type AggregateKey struct {
 	UserID string
 	Day    string
+	Hour   int // UTC; zero in daily rollups
 	SongID string
+	// ArtistID rides along with the song it came with; empty when the
+	// event had none
+	ArtistID string
+	// Experiment likewise, only while the experiment is running (experiments.go)
+	Experiment string
+	// Recompute marks the listens of a recompute, which only count again in
+	// the tables it reset (recompute.go)
+	Recompute bool
+
+	Partition int // source partition, so a flush can be applied per partition
 }
 
-// Aggregator holds the in-memory state
+// Aggregator holds the in-memory state of one consumer group member
 type Aggregator struct {
+	name       string // in logs, with several instances per process
 	mu         sync.Mutex
 	counts     map[AggregateKey]int64
-	session    *gocql.Session
+	millis     map[AggregateKey]int64 // time played per key, from events with a duration_ms
+	topk       dailyCounters
+	store      string                  // where topk is, for logs: Cassandra or the STORAGE_BACKEND
+	hourly     *storage.HourlyTopKRepo // nil with STORAGE_BACKEND, as artists and totals (sqlbackend.go)
+	artists    *storage.DailyArtistTopKRepo
+	totals     *storage.UserTotalsRepo
+	songs      *storage.SongStatsRepo // nil = no per-song stats
+	audit      *dedupAudit            // nil = no dedup audit
 	reader     *kafka.Reader
 	redis      *redis.Client
-	lastMsg    kafka.Message
+	deltas     *kafka.Writer // nil = don't publish deltas
+	takedowns  *storage.TakedownSet
 	hasMsg     bool
 	dedupCount int64 // Track how many duplicates skipped
+	settings   *runtimecfg.Config
+	commit     string        // COMMIT_STRATEGY
+	lease      *idgen.Lease  // exactly-once worker ID; nil with WORKER_ID
+	fetched    map[int]int64 // partition -> offset after the last message fetched
+
+	flushInterval time.Duration
+	flushMu       sync.Mutex // one flush at a time, so commits stay in order
+	minPlayMs     int64      // plays reported shorter aren't counted (0 = all are)
+
+	// Experiment counters (experiments.go)
+	experiments    *storage.ExperimentSet
+	experimentTopK *storage.ExperimentTopKRepo // nil with STORAGE_BACKEND
+
+	dual *dualWrite // nil = no dual write (dualwrite.go)
+
+	// Two-stage aggregation (reduce.go)
+	partials *kafka.Writer // SINK_MODE=reduce: flushes are published here, not written
+	reducer  bool          // REDUCER: merges partials from PARTIAL_TOPIC and writes them
+
+	// Exactly-once sink (SINK_MODE=exactly-once); nil = counter sink
+	once       *exactlyOnce
+	ranges     map[int]offsetRange  // offsets accumulated per partition since the last flush
+	pendingIDs map[string]pendingID // event IDs to mark in the bloom once flushed
+
+	published map[userDay]freshness.Published // publish times of the listens counted since the last flush (freshness.go)
+
+	// Watermarks (watermark.go)
+	marks      *watermarks   // nil = not emitted
+	eventTimes map[int]int64 // partition -> latest listened_at since the last flush
+
+	// Rewinds (instance.go), which replace reader under mu
+	newReader func() *kafka.Reader
+	readerGen int        // rewinds so far
+	fetchMu   sync.Mutex // held by run from fetching a message until it's accumulated
 }
 
 const (
@@ -53,110 +108,346 @@ const (
 )
 
 func main() {
-	kafkaBroker := getEnv("KAFKA_BROKER", "localhost:29092")
+	// REDUCER=true runs the second stage of SINK_MODE=reduce (reduce.go),
+	// wired as the pipeline's reducer service
+	reducer := os.Getenv("REDUCER") == "true"
+	service := "aggregator"
+	if reducer {
+		service = "reducer"
+	}
+	if _, err := topology.Apply(service); err != nil {
+		log.Fatalf("Invalid pipeline config: %v", err)
+	}
+
 	cassandraHosts := getEnv("CASSANDRA_HOSTS", "localhost:9042")
 	redisAddr := getEnv("REDIS_ADDR", "localhost:6379")
 	consumerGroup := getEnv("CONSUMER_GROUP", "aggregator")
 	flushInterval := getEnvDuration("FLUSH_INTERVAL", 30*time.Second)
-	topic := "user.listen.raw"
+	minPlayMs := getEnvInt("MIN_PLAY_MS", 0)
+	metricsAddr := getEnv("METRICS_ADDR", ":9103")
+	deltaTopic := getEnv("DELTA_TOPIC", "user.listen.agg")
+	sinkMode := getEnv("SINK_MODE", sinkCounter)
+	songStats := getEnv("SONG_STATS", "true") == "true"
+	auditRate := getEnvFloat("DEDUP_AUDIT_RATE", 0)
+	instances := getEnvInt("INSTANCES", 1)
+	scaleTest := getEnv("SCALE_TEST", "false") == "true"
+	topic := getEnv("TOPIC", "user.listen.raw")
+	partialTopic := getEnv("PARTIAL_TOPIC", defaultPartialTopic)
+	if reducer {
+		consumerGroup = getEnv("CONSUMER_GROUP", "reducer")
+		topic = getEnv("TOPIC", partialTopic)
+	}
+	takedownTopic := getEnv("TAKEDOWN_TOPIC", "song.takedown")
+	watermarkInterval := getEnvDuration("WATERMARK_INTERVAL", 0)
+	watermarkTopic := getEnv("WATERMARK_TOPIC", defaultWatermarkTopic)
+	if reducer {
+		// The aggregators purge takedowns; a reducer drops the songs from
+		// its flushes
+		takedownTopic = ""
+		// Partials carry no event time: the aggregators' watermarks stand
+		watermarkInterval = 0
+	}
+
+	kafkaCfg, err := kafkautil.ConfigFromEnv("localhost:29092")
+	if err != nil {
+		log.Fatalf("Invalid Kafka config: %v", err)
+	}
 
-	log.Printf("Starting aggregator: kafka=%s cassandra=%s redis=%s group=%s flush=%s",
-		kafkaBroker, cassandraHosts, redisAddr, consumerGroup, flushInterval)
+	// STORAGE_BACKEND=postgres or sqlite: daily counts only (sqlbackend.go)
+	storageCfg, err := sqlstore.ConfigFromEnv()
+	if err != nil {
+		log.Fatalf("Invalid storage config: %v", err)
+	}
+	if storageCfg.SQL() {
+		cassandraHosts = "none (" + storageCfg.String() + ")"
+		// Takedowns are registered in Cassandra: none to purge
+		takedownTopic = ""
+	}
+
+	log.Printf("Starting %s: kafka=%v cassandra=%s redis=%s group=%s flush=%s sink=%s instances=%d",
+		service, kafkaCfg.Brokers, cassandraHosts, redisAddr, consumerGroup, flushInterval, sinkMode, instances)
+	log.Printf("Consumer group membership: %s", kafkaCfg.Group)
+	if sinkMode != sinkCounter && sinkMode != sinkExactlyOnce && sinkMode != sinkReduce {
+		log.Fatalf("Invalid SINK_MODE %q (want counter, exactly-once or reduce)", sinkMode)
+	}
+	if reducer && sinkMode != sinkCounter {
+		log.Fatalf("A reducer writes with the counter sink, not SINK_MODE=%s", sinkMode)
+	}
+	if scaleTest && (reducer || sinkMode == sinkReduce) {
+		// It checks the counters its own instances write
+		log.Fatalf("SCALE_TEST runs with SINK_MODE=counter or exactly-once, without REDUCER")
+	}
+	if storageCfg.SQL() {
+		switch {
+		case sinkMode == sinkExactlyOnce:
+			log.Fatalf("SINK_MODE=exactly-once keeps its apply log in Cassandra, not STORAGE_BACKEND=%s", storageCfg.Backend)
+		case auditRate > 0, os.Getenv("DUAL_WRITE") != "":
+			log.Fatalf("DEDUP_AUDIT_RATE and DUAL_WRITE need Cassandra, not STORAGE_BACKEND=%s", storageCfg.Backend)
+		}
+	}
+	if instances < 1 {
+		log.Fatalf("Invalid INSTANCES %d", instances)
+	}
+	if minPlayMs > 0 {
+		log.Printf("Plays shorter than %dms aren't counted", minPlayMs)
+	}
+	if (instances > 1 || scaleTest) && sinkMode == sinkExactlyOnce && os.Getenv("WORKER_ID") != "" {
+		// Every instance would generate flush IDs as the same worker
+		log.Fatalf("WORKER_ID is one ID per process: unset it to lease one per instance")
+	}
+	commit, err := commitStrategy(os.Getenv("COMMIT_STRATEGY"), sinkMode)
+	if err != nil {
+		log.Fatalf("Invalid COMMIT_STRATEGY: %v", err)
+	}
+	log.Printf("Commit strategy %s: %s", commit, commitTradeoffs[commit])
 	log.Printf("Redis Bloom Filter: capacity=%d error_rate=%.4f ttl_days=%d",
 		bloomCapacity, bloomErrorRate, bloomTTLDays)
 
-	// Connect to Cassandra
-	cluster := gocql.NewCluster(strings.Split(cassandraHosts, ",")...)
-	cluster.Keyspace = "topk"
-	cluster.Consistency = gocql.LocalOne
-	cluster.Timeout = 10 * time.Second
+	if err := startup.Kafka(context.Background(), kafkaCfg); err != nil {
+		log.Fatalf("Failed to connect to Kafka: %v", err)
+	}
 
-	session, err := cluster.CreateSession()
-	if err != nil {
-		log.Fatalf("Failed to connect to Cassandra: %v", err)
+	// Connect to Cassandra, or the STORAGE_BACKEND database
+	var (
+		session *storage.Session
+		sqlDB   *sqlstore.DB
+	)
+	if storageCfg.SQL() {
+		if sqlDB, err = startup.SQL(context.Background(), storageCfg); err != nil {
+			log.Fatalf("Failed to connect to %s: %v", storageCfg.Backend, err)
+		}
+		defer sqlDB.Close()
+		log.Printf("Connected to %s", storageCfg)
+	} else {
+		if session, err = startup.Cassandra(context.Background()); err != nil {
+			log.Fatalf("Failed to connect to Cassandra: %v", err)
+		}
+		defer session.Close()
+		log.Println("Connected to Cassandra")
 	}
-	defer session.Close()
-	log.Println("Connected to Cassandra")
 
 	// Connect to Redis (with RedisBloom module)
-	rdb := redis.NewClient(&redis.Options{
-		Addr: redisAddr,
-	})
+	redisCfg, err := redisutil.ConfigFromEnv(redisAddr, 0)
+	if err != nil {
+		log.Fatalf("Invalid Redis config: %v", err)
+	}
+	rdb := redisutil.NewClient(redisCfg, "default")
 	defer rdb.Close()
 
-	// Test Redis connection
-	if err := rdb.Ping(context.Background()).Err(); err != nil {
+	if err := startup.Redis(context.Background(), rdb); err != nil {
 		log.Fatalf("Failed to connect to Redis: %v", err)
 	}
-	log.Println("Connected to Redis (RedisBloom)")
+	log.Printf("Connected to Redis (RedisBloom): %s", redisCfg)
+	if chaos.Enabled() {
+		rdb.AddHook(chaos.RedisHook{})
+	}
+
+	// Without Cassandra the sets stay empty: nothing is taken down, and no
+	// experiment runs
+	var (
+		takedownRepo   *storage.TakedownRepo
+		experimentRepo *storage.ExperimentRepo
+	)
+	if session != nil {
+		takedownRepo = storage.NewTakedownRepo(session)
+		experimentRepo = storage.NewExperimentRepo(session)
+	}
+	takedowns := storage.NewTakedownSet(takedownRepo)
+	if err := takedowns.Start(context.Background()); err != nil {
+		log.Printf("Warning: failed to load song takedowns, retrying every %s: %v", storage.TakedownRefresh, err)
+	} else if n := takedowns.Len(); n > 0 {
+		log.Printf("Dropping listens of %d taken-down songs", n)
+	}
+	experiments := storage.NewExperimentSet(experimentRepo)
+	if err := experiments.Start(context.Background()); err != nil {
+		log.Printf("Warning: failed to load experiments, retrying every %s: %v", storage.ExperimentRefresh, err)
+	} else if n := experiments.Len(); n > 0 {
+		log.Printf("Splitting counts of %d registered experiments", n)
+	}
+
+	cfg := instanceConfig{
+		kafka:         kafkaCfg,
+		session:       session,
+		sql:           sqlDB,
+		redis:         rdb,
+		topic:         topic,
+		group:         consumerGroup,
+		sinkMode:      sinkMode,
+		commit:        commit,
+		flushInterval: flushInterval,
+		minPlayMs:     int64(minPlayMs),
+		songStats:     songStats,
+		takedowns:     takedowns,
+		experiments:   experiments,
+		reducer:       reducer,
+	}
+	if cfg.dual, err = dualWriteFromEnv(session); err != nil {
+		log.Fatalf("Invalid dual-write config: %v", err)
+	} else if cfg.dual != nil {
+		log.Printf("Dual-writing daily counters to user_daily_topk_bucketed (%d buckets) from %s", cfg.dual.shadow.Buckets(), cfg.dual.from)
+	}
+	if scaleTest {
+		// Runs against its own topic and group, see scaletest.go
+		if err := runScaleTest(cfg, scaleTestFromEnv(instances)); err != nil {
+			log.Fatalf("Scale test failed: %v", err)
+		}
+		log.Println("Scale test passed")
+		return
+	}
+
+	// Before joining the group: rebuild the bloom filters if Redis lost them
+	bloom, err := bloomCheckFromEnv(historyOf(session, sqlDB), rdb)
+	if err != nil {
+		log.Fatalf("Invalid bloom check config: %v", err)
+	}
+	if bloom != nil {
+		log.Printf("Bloom filter rebuild: %s", bloom)
+		bloom.startup(context.Background())
+	}
 
-	// Create Kafka reader (consumer group)
-	reader := kafka.NewReader(kafka.ReaderConfig{
-		Brokers:  []string{kafkaBroker},
-		Topic:    topic,
-		GroupID:  consumerGroup,
-		MinBytes: 1,
-		MaxBytes: 10e6,
-	})
-	defer reader.Close()
-	log.Printf("Listening on topic: %s", topic)
+	// Fill of the day filters, rolled to a new shard before they saturate
+	monitor, err := bloomMonitorFromEnv(rdb, auditRate > 0)
+	if err != nil {
+		log.Fatalf("Invalid bloom monitor config: %v", err)
+	}
+	if monitor != nil {
+		log.Printf("Bloom filter monitor: %s", monitor)
+	}
 
-	agg := &Aggregator{
-		counts:  make(map[AggregateKey]int64),
-		session: session,
-		reader:  reader,
-		redis:   rdb,
+	if auditRate > 0 {
+		cfg.audit = &dedupAudit{
+			repo: storage.NewDedupAuditRepo(session),
+			rate: auditRate,
+			ttl:  getEnvDuration("DEDUP_AUDIT_TTL", 48*time.Hour),
+		}
+		log.Printf("Dedup audit: checking %.2f%% of events against dedup_audit (TTL %s)", auditRate*100, cfg.audit.ttl)
+	}
+	if songStats {
+		log.Println("Maintaining per-song listens and unique listeners")
+	}
+	if deltaTopic != "" {
+		cfg.deltas = kafkaCfg.NewWriter(deltaTopic, kafkautil.WriterConfigFromEnv())
+		defer cfg.deltas.Close()
+		log.Printf("Publishing flush deltas to %s", deltaTopic)
+	}
+	if sinkMode == sinkReduce {
+		cfg.partials = kafkaCfg.NewWriter(partialTopic, kafkautil.WriterConfigFromEnv())
+		defer cfg.partials.Close()
+		log.Printf("Two-stage aggregation: publishing flushes to %s for the reducers to write", partialTopic)
+	}
+	if watermarkInterval > 0 {
+		cfg.watermarks = &watermarkConfig{
+			interval: watermarkInterval,
+			// Longer than a flush, so what was read is flushed once idle
+			idle: getEnvDuration("WATERMARK_IDLE_TIMEOUT", 2*flushInterval),
+		}
+		to := watermarkKey(consumerGroup, topic)
+		if watermarkTopic != "" {
+			cfg.watermarks.writer = kafkaCfg.NewWriter(watermarkTopic, kafkautil.WriterConfigFromEnv())
+			defer cfg.watermarks.writer.Close()
+			to += " and " + watermarkTopic
+		}
+		log.Printf("Watermarks: every %s to %s (idle after %s)", watermarkInterval, to, cfg.watermarks.idle)
 	}
 
+	// One consumer group member per instance; they split the partitions
+	aggs := make([]*Aggregator, 0, instances)
+	for i := 0; i < instances; i++ {
+		agg, err := newAggregator(context.Background(), cfg, instanceName(i, instances))
+		if err != nil {
+			log.Fatalf("Failed to start %s: %v", instanceName(i, instances), err)
+		}
+		defer agg.close()
+		aggs = append(aggs, agg)
+	}
+	log.Printf("Listening on topic: %s", aggs[0].reader.Config().Topic)
+
+	startMetricsServer(metricsAddr)
+
 	// Handle shutdown gracefully
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
+	if bloom != nil {
+		go bloom.run(ctx)
+	}
+	if monitor != nil {
+		go monitor.run(ctx)
+	}
+
+	var purge *purger
+	if takedownTopic != "" {
+		purge = &purger{
+			reader: kafkaCfg.NewReader(kafkautil.ReaderConfig{
+				Topic:   takedownTopic,
+				GroupID: getEnv("TAKEDOWN_GROUP", "aggregator-takedown"),
+			}),
+			takedowns: takedowns,
+			repo:      takedownRepo,
+			topk:      storage.NewDailyTopKRepo(session),
+			hourly:    storage.NewHourlyTopKRepo(session),
+			totals:    storage.NewUserTotalsRepo(session),
+			redis:     rdb,
+			deltas:    cfg.deltas,
+			// Past every aggregator's reload, and the flush of what it had counted
+			delay: getEnvDuration("TAKEDOWN_PURGE_DELAY", storage.TakedownRefresh+2*flushInterval),
+		}
+		if songStats {
+			purge.songs = storage.NewSongStatsRepo(session)
+		}
+		if cfg.dual != nil {
+			purge.shadow = cfg.dual.shadow
+		}
+		defer purge.reader.Close()
+		log.Printf("Purging taken-down songs from %s (delay %s)", takedownTopic, purge.delay)
+	}
+
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
-
-	// Periodic flush goroutine
-	go func() {
-		ticker := time.NewTicker(flushInterval)
-		defer ticker.Stop()
-		for {
+	for _, agg := range aggs {
+		if agg.lease == nil {
+			continue
+		}
+		// Another process may now hold the worker ID: stop as if signalled
+		go func(lease *idgen.Lease) {
 			select {
-			case <-ticker.C:
-				agg.flush(ctx)
+			case <-lease.Lost():
+				sigChan <- syscall.SIGTERM
 			case <-ctx.Done():
-				return
 			}
-		}
-	}()
+		}(agg.lease)
+	}
 
 	// Shutdown handler
 	go func() {
 		<-sigChan
 		log.Println("Shutting down... flushing remaining counts")
-		agg.flush(ctx)
+		for _, agg := range aggs {
+			agg.flush(ctx)
+			if agg.marks != nil {
+				agg.marks.emit(ctx)
+			}
+		}
 		cancel()
 	}()
 
 	// Process messages
-	for {
-		msg, err := reader.FetchMessage(ctx)
-		if err != nil {
-			if ctx.Err() != nil {
-				break
-			}
-			log.Printf("Error fetching message: %v", err)
-			continue
-		}
-
-		var event ListenEvent
-		if err := json.Unmarshal(msg.Value, &event); err != nil {
-			log.Printf("Error unmarshaling event: %v", err)
-			reader.CommitMessages(ctx, msg)
-			continue
-		}
-
-		agg.accumulate(ctx, event, msg)
+	var wg sync.WaitGroup
+	if purge != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			purge.run(ctx)
+		}()
+	}
+	for _, agg := range aggs {
+		wg.Add(1)
+		go func(agg *Aggregator) {
+			defer wg.Done()
+			agg.run(ctx)
+		}(agg)
 	}
+	wg.Wait()
 
 	log.Println("Shutdown complete")
 }
@@ -168,11 +459,17 @@ func bloomKey(day string) string {
 
 // ensureBloomFilter creates a bloom filter if it doesn't exist and sets TTL
 func (a *Aggregator) ensureBloomFilter(ctx context.Context, day string) error {
+	return reserveBloom(ctx, a.redis, day)
+}
+
+// reserveBloom is ensureBloomFilter for callers without an Aggregator
+func reserveBloom(ctx context.Context, rdb *redis.Client, day string) error {
 	key := bloomKey(day)
 
 	// Try to reserve (create) the bloom filter
 	// BF.RESERVE key error_rate capacity [EXPANSION expansion] [NONSCALING]
-	err := a.redis.Do(ctx, "BF.RESERVE", key, bloomErrorRate, bloomCapacity, "NONSCALING").Err()
+	capacity, ttl := bloomSize(day)
+	err := rdb.Do(ctx, "BF.RESERVE", key, bloomErrorRate, capacity, "NONSCALING").Err()
 	if err != nil {
 		// Ignore "item exists" error - filter already created
 		if !strings.Contains(err.Error(), "item exists") {
@@ -180,8 +477,7 @@ func (a *Aggregator) ensureBloomFilter(ctx context.Context, day string) error {
 		}
 	} else {
 		// New filter created - set TTL
-		ttl := time.Duration(bloomTTLDays) * 24 * time.Hour
-		a.redis.Expire(ctx, key, ttl)
+		rdb.Expire(ctx, key, ttl)
 		log.Printf("Created bloom filter: %s (TTL: %v)", key, ttl)
 	}
 
@@ -190,41 +486,62 @@ func (a *Aggregator) ensureBloomFilter(ctx context.Context, day string) error {
 
 // checkAndAddToBloom returns true if item was already seen (or possibly seen)
 func (a *Aggregator) checkAndAddToBloom(ctx context.Context, day, eventID string) (bool, error) {
-	key := bloomKey(day)
-
 	// Ensure bloom filter exists
 	if err := a.ensureBloomFilter(ctx, day); err != nil {
 		log.Printf("Warning: failed to ensure bloom filter: %v", err)
 		// Continue anyway - BF.ADD will create if needed
 	}
 
-	// BF.ADD returns 1 if item was added (new), 0 if it already existed
-	// go-redis returns this as int64
-	result, err := a.redis.Do(ctx, "BF.ADD", key, eventID).Result()
+	// Added to the day's newest shard unless one of them has it
+	// (bloomshards.go)
+	added, err := bloomAdd(ctx, a.redis, day, eventID)
 	if err != nil {
 		return false, err
 	}
-
-	// result == 0 (int64) means item already existed (duplicate)
-	// result == 1 (int64) means item was added (new)
-	switch v := result.(type) {
-	case int64:
-		return v == 0, nil
-	case bool:
-		// Some versions return bool: true = added, false = existed
-		return !v, nil
-	default:
-		return false, fmt.Errorf("unexpected type %T from BF.ADD", result)
-	}
+	return !added[0], nil
 }
 
-func (a *Aggregator) accumulate(ctx context.Context, event ListenEvent, msg kafka.Message) {
+func (a *Aggregator) accumulate(ctx context.Context, event events.ListenEvent, msg kafka.Message) {
 	// Convert timestamp to day
-	listenedAt := time.Unix(event.ListenedAt, 0)
-	day := listenedAt.Format("2006-01-02")
+	day := event.Day()
+
+	// Exactly-once: offsets an applied flush covers are redeliveries
+	if a.once != nil && a.once.alreadyApplied(ctx, msg) {
+		metricReplaysSkipped.Add(1)
+		return
+	}
+
+	// Taken-down songs aren't counted any more (takedown.go)
+	if a.takedowns.Has(event.SongID) {
+		metricTakedownDropped.Add(1)
+		a.skipEvent(msg, event)
+		return
+	}
+
+	// Plays the provider reports as shorter than min_play_ms are skips, not
+	// listens: they'd put a song tried once in the top-K
+	if event.ShortPlay(int64(a.settings.Int("min_play_ms", int(a.minPlayMs)))) {
+		metricShortPlaysDropped.Add(1)
+		a.skipEvent(msg, event)
+		return
+	}
 
 	// DEDUP CHECK: Use Redis Bloom Filter (shared across all aggregators)
-	isDuplicate, err := a.checkAndAddToBloom(ctx, day, event.EventID)
+	// A recompute's events have a filter of their own (recompute.go)
+	scope, recompute := dedupScope(day, msg)
+	var isDuplicate bool
+	var err error
+	switch {
+	case !a.settings.Bool("dedup_enabled", true):
+		// Switched off at runtime: count everything
+	case a.once != nil:
+		isDuplicate, err = a.seenBefore(ctx, scope, event.EventID)
+	default:
+		isDuplicate, err = a.checkAndAddToBloom(ctx, scope, event.EventID)
+	}
+	if err == nil && !recompute && a.audit != nil && a.settings.Bool("dedup_enabled", true) && a.audit.sampled(event.EventID) {
+		a.audit.check(ctx, day, event.EventID, isDuplicate)
+	}
 	if err != nil {
 		log.Printf("Warning: bloom filter check failed: %v (processing event anyway)", err)
 		// On error, we process the event to avoid data loss
@@ -233,8 +550,8 @@ func (a *Aggregator) accumulate(ctx context.Context, event ListenEvent, msg kafk
 		// Already seen - SKIP to prevent over-counting
 		a.mu.Lock()
 		a.dedupCount++
-		a.lastMsg = msg
-		a.hasMsg = true
+		a.track(msg)
+		a.noteEventTime(msg.Partition, event)
 		a.mu.Unlock()
 		return
 	}
@@ -242,17 +559,66 @@ func (a *Aggregator) accumulate(ctx context.Context, event ListenEvent, msg kafk
 	key := AggregateKey{
 		UserID: event.UserID,
 		Day:    day,
+		Hour:   event.Hour(),
 		SongID: event.SongID,
+
+		ArtistID:  event.ArtistID,
+		Recompute: recompute,
+		Partition: msg.Partition,
+	}
+	if event.Experiment != "" {
+		if a.experiments.Active(event.Experiment, day) {
+			key.Experiment = event.Experiment
+		} else {
+			metricExperimentTagsIgnored.Add(1)
+		}
 	}
 
 	a.mu.Lock()
 	a.counts[key]++
-	a.lastMsg = msg
+	if event.DurationMs > 0 {
+		a.millis[key] += event.DurationMs
+	}
+	a.track(msg)
+	a.noteEventTime(msg.Partition, event)
+	if a.once != nil {
+		a.pendingIDs[event.EventID] = pendingID{day: scope, partition: msg.Partition}
+	}
+	a.notePublished(key, msg)
+	a.mu.Unlock()
+}
+
+// track records msg as part of the next flush; a.mu must be held
+func (a *Aggregator) track(msg kafka.Message) {
 	a.hasMsg = true
+	r, ok := a.ranges[msg.Partition]
+	if !ok {
+		r.first = msg.Offset
+	}
+	r.last = msg.Offset
+	a.ranges[msg.Partition] = r
+}
+
+// skip adds a message that isn't counted to the next flush's offsets
+func (a *Aggregator) skip(msg kafka.Message) {
+	a.mu.Lock()
+	a.track(msg)
+	a.mu.Unlock()
+}
+
+// skipEvent is skip for a listen left out: its partition still got that far
+// in event time
+func (a *Aggregator) skipEvent(msg kafka.Message, event events.ListenEvent) {
+	a.mu.Lock()
+	a.track(msg)
+	a.noteEventTime(msg.Partition, event)
 	a.mu.Unlock()
 }
 
 func (a *Aggregator) flush(ctx context.Context) {
+	a.flushMu.Lock()
+	defer a.flushMu.Unlock()
+
 	a.mu.Lock()
 	if len(a.counts) == 0 && !a.hasMsg {
 		a.mu.Unlock()
@@ -261,45 +627,222 @@ func (a *Aggregator) flush(ctx context.Context) {
 
 	// Snapshot current counts
 	counts := a.counts
-	lastMsg := a.lastMsg
+	millis := a.millis
 	hasMsg := a.hasMsg
 	dedupCount := a.dedupCount
+	ranges := a.ranges
+	pendingIDs := a.pendingIDs
+	published := a.published
+	eventTimes := a.eventTimes
 
 	// Reset for next batch
-	a.counts = make(map[AggregateKey]int64)
-	a.hasMsg = false
-	a.dedupCount = 0
+	a.reset()
 	a.mu.Unlock()
 
-	log.Printf("Flushing %d aggregates to Cassandra (skipped %d duplicates via Redis Bloom)", len(counts), dedupCount)
+	// Songs taken down since they were counted: the purge waits for this
+	// flush, so it must not write them back
+	for key, n := range counts {
+		if a.takedowns.Has(key.SongID) {
+			metricTakedownDropped.Add(n)
+			delete(counts, key)
+			delete(millis, key)
+		}
+	}
+
+	sink := a.store
+	if a.partials != nil {
+		sink = a.partials.Topic
+	}
+	log.Printf("Flushing %d aggregates to %s (skipped %d duplicates via Redis Bloom)", len(counts), sink, dedupCount)
+	start := time.Now()
 
 	// WITH BLOOM FILTER: Write to Cassandra FIRST, then commit offset
 	// Bloom filter protects against duplicates if replay happens
 	
+	// 0. commit-before-write: commit first, so a crash loses the flush
+	// instead of replaying it
+	if a.commit == commitBeforeWrite && hasMsg {
+		a.commitRanges(ctx, ranges)
+	}
+
 	// 1. Write counter increments to Cassandra FIRST
-	for key, delta := range counts {
-		query := `
-			UPDATE user_daily_topk
-			SET listen_count = listen_count + ?
-			WHERE user_id = ? AND day = ? AND song_id = ?
-		`
-		if err := a.session.Query(query, delta, key.UserID, key.Day, key.SongID).Exec(); err != nil {
+	var (
+		daily   map[AggregateKey]int64
+		commits []kafka.Message
+		reread  map[int]bool // exactly-once: partitions to read again
+	)
+	switch {
+	case a.once != nil:
+		daily, commits, reread = a.applyExactlyOnce(ctx, counts, millis, ranges, pendingIDs)
+	case a.partials != nil:
+		// SINK_MODE=reduce: the reducer writes them, then observes their
+		// freshness and publishes the deltas
+		a.publishPartials(ctx, counts, millis, published)
+		published = nil
+	default:
+		daily = a.applyCounts(ctx, counts, millis)
+	}
+
+	observeFlushed(published)
+
+	// 2. Tell downstream consumers what changed
+	a.publishDeltas(ctx, daily, published)
+
+	// 3. Commit offsets AFTER successful Cassandra write, one per partition
+	// If crash before commit: replay happens, bloom filter skips duplicates
+	// (exactly-once: the apply log does, one offset per applied partition)
+	if a.once != nil {
+		if len(commits) > 0 {
+			if err := kafkautil.CommitWithRetry(ctx, a.reader, 3, commits...); err != nil {
+				log.Printf("Error committing offsets: %v", err)
+				metricCommitErrors.Add(1)
+			}
+		}
+	} else if a.commit == commitAfterWrite && hasMsg {
+		a.commitRanges(ctx, ranges)
+	}
+	if a.marks != nil {
+		for p := range reread {
+			delete(eventTimes, p) // not flushed that far
+			delete(ranges, p)
+		}
+		a.marks.advance(eventTimes, ranges)
+	}
+	if len(reread) > 0 {
+		a.rewind()
+	}
+
+	metricFlushes.Add(1)
+	metricAggregatesFlushed.Add(int64(len(daily)))
+	metricDuplicatesSkipped.Add(dedupCount)
+	metricLastFlushAggregates.Set(int64(len(daily)))
+	metricLastFlushUnix.Set(time.Now().Unix())
+	metricLastFlushMillis.Set(time.Since(start).Milliseconds())
+	if a.audit != nil {
+		logAudit()
+	}
+
+	log.Printf("Flush complete")
+}
+
+// reset empties what's accumulated for the next flush; a.mu must be held
+func (a *Aggregator) reset() {
+	a.counts = make(map[AggregateKey]int64)
+	a.millis = make(map[AggregateKey]int64)
+	a.hasMsg = false
+	a.dedupCount = 0
+	a.ranges = make(map[int]offsetRange)
+	a.pendingIDs = make(map[string]pendingID)
+	a.published = make(map[userDay]freshness.Published)
+	a.eventTimes = make(map[int]int64)
+}
+
+// applyCounts writes the daily rollup and hourly counters of counts (and the
+// listening time of millis) and returns the daily increments that were stored
+func (a *Aggregator) applyCounts(ctx context.Context, counts, millis map[AggregateKey]int64) map[AggregateKey]int64 {
+	daily := rollupDays(counts)
+	for key, delta := range daily {
+		if err := a.topk.Increment(ctx, key.UserID, key.Day, key.SongID, delta); err != nil {
 			log.Printf("Error updating counter: %v", err)
+			metricFlushErrors.Add(1)
+			delete(daily, key) // not stored, so not a delta either
 			// Continue with other updates
 		}
 	}
 
-	// 2. Commit offset AFTER successful Cassandra write
-	// If crash before commit: replay happens, bloom filter skips duplicates
-	if hasMsg {
-		if err := a.reader.CommitMessages(ctx, lastMsg); err != nil {
-			log.Printf("Error committing offset: %v", err)
-		} else {
-			log.Printf("Committed offset: partition=%d offset=%d", lastMsg.Partition, lastMsg.Offset)
+	// Hourly counters for sliding windows; the daily ones stay the source
+	// of truth, so a failure here only costs the sliding reads
+	for key, delta := range counts {
+		if key.Recompute || a.hourly == nil {
+			continue
+		}
+		if err := a.hourly.Increment(ctx, key.UserID, key.Day, key.Hour, key.SongID, delta); err != nil {
+			log.Printf("Error updating hourly counter: %v", err)
+			metricHourlyErrors.Add(1)
 		}
 	}
 
-	log.Printf("Flush complete")
+	a.applyTimes(ctx, millis)
+	a.applyDualWrite(ctx, daily, millis)
+	live := withoutRecomputes(daily)
+	a.applyArtistCounts(ctx, live)
+	a.applyExperimentCounts(ctx, live)
+	a.applyTotals(ctx, daily)
+	a.applySongStats(ctx, live)
+	return daily
+}
+
+// applyTimes adds a flush's listening time to the listen_ms counters next to
+// the daily and hourly counts. Time only ranks songs (rank_by=time), it isn't
+// part of the deltas, so a failure is logged and counted
+// (time_flush_errors) without holding the flush back.
+func (a *Aggregator) applyTimes(ctx context.Context, millis map[AggregateKey]int64) {
+	for key, ms := range rollupDays(millis) {
+		if err := a.topk.IncrementTime(ctx, key.UserID, key.Day, key.SongID, ms); err != nil {
+			log.Printf("Error updating listening time: %v", err)
+			metricTimeErrors.Add(1)
+		}
+	}
+	for key, ms := range millis {
+		if key.Recompute || a.hourly == nil {
+			continue
+		}
+		if err := a.hourly.IncrementTime(ctx, key.UserID, key.Day, key.Hour, key.SongID, ms); err != nil {
+			log.Printf("Error updating hourly listening time: %v", err)
+			metricTimeErrors.Add(1)
+		}
+	}
+}
+
+// applyArtistCounts adds a flush's stored daily counts to the per-artist
+// counters. Like the hourly ones they are derived from the song counts, so a
+// failure costs the artist reads only.
+func (a *Aggregator) applyArtistCounts(ctx context.Context, daily map[AggregateKey]int64) {
+	if a.artists == nil {
+		return
+	}
+	type artistDay struct{ user, day, artist string }
+	artists := make(map[artistDay]int64)
+	for key, delta := range daily {
+		if key.ArtistID != "" {
+			artists[artistDay{key.UserID, key.Day, key.ArtistID}] += delta
+		}
+	}
+	for k, delta := range artists {
+		if err := a.artists.Increment(ctx, k.user, k.day, k.artist, delta); err != nil {
+			log.Printf("Error updating artist counter: %v", err)
+			metricArtistErrors.Add(1)
+		}
+	}
+}
+
+// applyTotals adds a flush's stored daily counts to the per-user day totals
+// behind the api-server's activity view. Derived like the artist counters.
+func (a *Aggregator) applyTotals(ctx context.Context, daily map[AggregateKey]int64) {
+	if a.totals == nil {
+		return // STORAGE_BACKEND sums them from user_daily_topk
+	}
+	type userDay struct{ user, day string }
+	totals := make(map[userDay]int64)
+	for key, delta := range daily {
+		totals[userDay{key.UserID, key.Day}] += delta
+	}
+	for k, delta := range totals {
+		if err := a.totals.Increment(ctx, k.user, k.day, delta); err != nil {
+			log.Printf("Error updating day total: %v", err)
+			metricTotalErrors.Add(1)
+		}
+	}
+}
+
+// rollupDays sums hourly keys into (user, day, song, artist) keys
+func rollupDays(counts map[AggregateKey]int64) map[AggregateKey]int64 {
+	daily := make(map[AggregateKey]int64, len(counts))
+	for key, delta := range counts {
+		key.Hour = 0
+		daily[key] += delta
+	}
+	return daily
 }
 
 func getEnv(key, fallback string) string {
@@ -309,6 +852,26 @@ func getEnv(key, fallback string) string {
 	return fallback
 }
 
+func getEnvInt(key string, fallback int) int {
+	if v := os.Getenv(key); v != "" {
+		i, err := strconv.Atoi(v)
+		if err == nil {
+			return i
+		}
+	}
+	return fallback
+}
+
+func getEnvFloat(key string, fallback float64) float64 {
+	if v := os.Getenv(key); v != "" {
+		f, err := strconv.ParseFloat(v, 64)
+		if err == nil {
+			return f
+		}
+	}
+	return fallback
+}
+
 func getEnvDuration(key string, fallback time.Duration) time.Duration {
 	if v := os.Getenv(key); v != "" {
 		d, err := time.ParseDuration(v)
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"expvar"
+	"log"
+	"net/http"
+
+	"github.com/system-design-lab/pkg/freshness"
+)
+
+// Flush metrics, served as JSON on METRICS_ADDR/debug/vars
+var (
+	metricFlushes             = expvar.NewInt("flushes")
+	metricFlushErrors         = expvar.NewInt("flush_errors") // failed counter increments
+	metricHourlyErrors        = expvar.NewInt("hourly_flush_errors")
+	metricArtistErrors        = expvar.NewInt("artist_flush_errors")
+	metricTotalErrors         = expvar.NewInt("total_flush_errors")
+	metricTimeErrors          = expvar.NewInt("time_flush_errors")
+	metricSongStatErrors      = expvar.NewInt("song_stats_errors")
+	metricSongDaysUpdated     = expvar.NewInt("song_days_updated")
+	metricCommitErrors        = expvar.NewInt("commit_errors")
+	metricAggregatesFlushed   = expvar.NewInt("aggregates_flushed")
+	metricDuplicatesSkipped   = expvar.NewInt("duplicates_skipped")
+	metricLastFlushAggregates = expvar.NewInt("last_flush_aggregates")
+	metricLastFlushUnix       = expvar.NewInt("last_flush_unix")
+	metricLastFlushMillis     = expvar.NewInt("last_flush_ms")
+	metricDeltasPublished     = expvar.NewInt("deltas_published")
+	metricDeltaErrors         = expvar.NewInt("delta_publish_errors")
+	metricReplaysSkipped      = expvar.NewInt("replays_skipped")     // exactly-once: offsets already applied
+	metricFlushesFenced       = expvar.NewInt("flushes_fenced")      // exactly-once: applied by another aggregator
+	metricFlushesRecovered    = expvar.NewInt("flushes_recovered")   // exactly-once: claimed, never completed
+	metricFlushesRewound      = expvar.NewInt("flushes_rewound")     // exactly-once: partly applied by another aggregator, read again
+	metricRebalanceFlushes    = expvar.NewInt("rebalance_flushes")   // flushes triggered by a group rebalance
+	metricReplayedListens     = expvar.NewInt("replayed_listens")    // listens republished by tools/cmd/replay
+	metricShortPlaysDropped   = expvar.NewInt("short_plays_dropped") // under min_play_ms, not counted
+)
+
+// Two-stage aggregation metrics (reduce.go)
+var (
+	metricPartialsPublished = expvar.NewInt("partials_published") // SINK_MODE=reduce: user-days sent to the reducers
+	metricPartialErrors     = expvar.NewInt("partial_publish_errors")
+	metricPartialsMerged    = expvar.NewInt("partials_merged")  // reducer: partials counted
+	metricPartialsSkipped   = expvar.NewInt("partials_skipped") // reducer: redelivered, already merged
+)
+
+// Watermark metrics (watermark.go)
+var (
+	metricWatermarksEmitted = expvar.NewInt("watermarks_emitted") // partition watermarks written
+	metricWatermarkErrors   = expvar.NewInt("watermark_errors")
+	metricWatermarkLag      = expvar.NewMap("watermark_lag_seconds") // by partition: now minus its watermark, 0 when idle
+)
+
+// Experiment metrics (experiments.go)
+var (
+	metricExperimentListens     = expvar.NewInt("experiment_listens")
+	metricExperimentTagsIgnored = expvar.NewInt("experiment_tags_ignored") // unknown experiment, or outside its days
+	metricExperimentErrors      = expvar.NewInt("experiment_flush_errors")
+)
+
+// Dual-write metrics (dualwrite.go)
+var (
+	metricDualWrites      = expvar.NewInt("dual_writes") // daily counters copied
+	metricDualWriteErrors = expvar.NewInt("dual_write_errors")
+)
+
+// Freshness metrics (freshness.go): listens from their producer's publish to
+// the end of their flush, the aggregator's part of the pipeline SLO
+var metricEventToFlush = freshness.NewHistogram("event_to_flush_latency")
+
+// Bloom rebuild metrics (bloomcheck.go)
+var (
+	metricBloomRebuilds      = expvar.NewInt("bloom_rebuilds") // filters lost with Redis and rebuilt
+	metricBloomRebuildEvents = expvar.NewInt("bloom_rebuild_events")
+	metricBloomRebuildErrors = expvar.NewInt("bloom_rebuild_errors")
+)
+
+// Bloom saturation metrics (bloomshards.go), keyed by day
+var (
+	metricBloomFill          = expvar.NewMap("bloom_fill_ratio")        // newest shard's items over its capacity
+	metricBloomItems         = expvar.NewMap("bloom_items")             // of every shard
+	metricBloomShards        = expvar.NewMap("bloom_shards")            // filters of the day
+	metricBloomFPRate        = expvar.NewMap("bloom_estimated_fp_rate") // for a new ID, across the shards
+	metricBloomWindow        = expvar.NewInt("bloom_window_days")       // days with a filter: how far back duplicates are caught
+	metricBloomRolls         = expvar.NewInt("bloom_shard_rolls")       // shards this process added
+	metricBloomMonitorErrors = expvar.NewInt("bloom_monitor_errors")
+)
+
+// Takedown metrics (takedown.go)
+var (
+	metricTakedownDropped     = expvar.NewInt("takedown_listens_dropped") // listens of taken-down songs not counted
+	metricTakedownsPurged     = expvar.NewInt("takedowns_purged")
+	metricTakedownRowsDeleted = expvar.NewInt("takedown_rows_deleted")
+	metricTakedownErrors      = expvar.NewInt("takedown_errors")
+)
+
+// startMetricsServer exposes expvar metrics over HTTP
+func startMetricsServer(addr string) {
+	go func() {
+		log.Printf("Metrics on http://%s/debug/vars", addr)
+		if err := http.ListenAndServe(addr, nil); err != nil {
+			log.Printf("Metrics server error: %v", err)
+		}
+	}()
+}
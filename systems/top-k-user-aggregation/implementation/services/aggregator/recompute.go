@@ -0,0 +1,62 @@
+package main
+
+import (
+	"strings"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/system-design-lab/pkg/kafkautil"
+	"github.com/system-design-lab/pkg/replay"
+)
+
+// A recompute (the api-server's POST /admin/users/{id}/recompute) resets a
+// user's daily counters, listening time and day totals for some days, then
+// replays the history of those days under a replay ID starting with
+// replay.RecomputePrefix. Every one of its events was seen before, so they
+// are deduplicated in a filter of their own per run and day instead of the
+// day's, and counted again only in the tables the recompute reset: the
+// hourly, artist, experiment and song counters keep what they had.
+
+// recomputeBloomCapacity sizes a recompute's filter: one user's day
+const recomputeBloomCapacity = 100_000
+
+// dedupScope returns the bloom filter "day" of an event: its day, or for a
+// recompute's events the day and run, and whether it is a recompute's
+func dedupScope(day string, msg kafka.Message) (string, bool) {
+	if id := kafkautil.ParseHeaders(msg).ReplayID; replay.IsRecompute(id) {
+		return day + "/" + id, true
+	}
+	return day, false
+}
+
+// bloomSize returns the capacity and TTL of a scope's filter. A recompute's
+// is only needed until its events are flushed.
+func bloomSize(scope string) (int, time.Duration) {
+	if strings.Contains(scope, "/") {
+		return recomputeBloomCapacity, 24 * time.Hour
+	}
+	return bloomCapacity, time.Duration(bloomTTLDays) * 24 * time.Hour
+}
+
+// withoutRecomputes returns daily without the counts of recompute listens,
+// for the derived counters a recompute doesn't reset. It copies only when
+// there is one to drop.
+func withoutRecomputes(daily map[AggregateKey]int64) map[AggregateKey]int64 {
+	found := false
+	for key := range daily {
+		if key.Recompute {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return daily
+	}
+	live := make(map[AggregateKey]int64, len(daily))
+	for key, delta := range daily {
+		if !key.Recompute {
+			live[key] = delta
+		}
+	}
+	return live
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/system-design-lab/pkg/events"
+	"github.com/system-design-lab/pkg/freshness"
+	"github.com/system-design-lab/pkg/kafkautil"
+)
+
+// Two-stage aggregation: aggregators in SINK_MODE=reduce publish each
+// flush's counts to PARTIAL_TOPIC, keyed by user_id, instead of writing them.
+// Aggregators run with REDUCER=true consume that topic in their own group,
+// so each partition, a range of users, has one reducer that merges the
+// partials of every aggregator and writes them as one flush.
+const defaultPartialTopic = "user.listen.partial"
+
+// partialScope is the bloom filter scope of a day's partial IDs, apart from
+// the day's event IDs
+func partialScope(day string) string {
+	return "partial:" + day
+}
+
+// publishPartials sends a flush's counts to the reducers, one partial per
+// user and day. It stands in for the counter writes: a failed publish loses
+// the flush's counts, as failed increments do (partial_publish_errors).
+func (a *Aggregator) publishPartials(ctx context.Context, counts, millis map[AggregateKey]int64, published map[userDay]freshness.Published) {
+	grouped := make(map[userDay][]events.PartialCount)
+	for key, n := range counts {
+		k := userDay{key.UserID, key.Day}
+		grouped[k] = append(grouped[k], events.PartialCount{
+			Hour:       key.Hour,
+			SongID:     key.SongID,
+			ArtistID:   key.ArtistID,
+			Experiment: key.Experiment,
+			Recompute:  key.Recompute,
+			Count:      n,
+			ListenMs:   millis[key],
+		})
+	}
+
+	now := time.Now().Unix()
+	msgs := make([]kafka.Message, 0, len(grouped))
+	for k, partialCounts := range grouped {
+		id, err := partialID()
+		if err != nil {
+			log.Printf("Error drawing a partial ID for %s/%s: %v", k.user, k.day, err)
+			metricPartialErrors.Add(1)
+			continue
+		}
+		value, err := events.MarshalPartial(events.PartialAggregate{
+			ID: id, UserID: k.user, Day: k.day, Counts: partialCounts, FlushedAt: now, Published: published[k],
+		})
+		if err != nil {
+			log.Printf("Error encoding partial for %s/%s: %v", k.user, k.day, err)
+			metricPartialErrors.Add(1)
+			continue
+		}
+		msgs = append(msgs, kafka.Message{Key: []byte(k.user), Value: value})
+	}
+	if len(msgs) == 0 {
+		return
+	}
+	kafkautil.Inject(ctx, msgs)
+
+	if err := a.partials.WriteMessages(ctx, msgs...); err != nil {
+		log.Printf("Error publishing %d partials to %s: %v", len(msgs), a.partials.Topic, err)
+		metricPartialErrors.Add(1)
+		return
+	}
+	metricPartialsPublished.Add(int64(len(msgs)))
+}
+
+// partialID is a random ID, unique per partial
+func partialID() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// reducePartial merges a partial into the counts of the reducer's next flush,
+// unless the bloom filter has its ID: a partial redelivered after a crash or
+// rebalance. Like the counter sink, an ID marked and then lost in a crash
+// before its flush is skipped on redelivery.
+func (a *Aggregator) reducePartial(ctx context.Context, msg kafka.Message) {
+	p, err := events.UnmarshalPartial(msg.Value)
+	if err != nil {
+		log.Printf("Error decoding partial: %v", err)
+		a.skip(msg)
+		return
+	}
+
+	seen, err := a.checkAndAddToBloom(ctx, partialScope(p.Day), p.ID)
+	if err != nil {
+		log.Printf("Warning: bloom filter check failed: %v (merging partial anyway)", err)
+	} else if seen {
+		metricPartialsSkipped.Add(1)
+		a.skip(msg)
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, c := range p.Counts {
+		key := AggregateKey{
+			UserID: p.UserID,
+			Day:    p.Day,
+			Hour:   c.Hour,
+			SongID: c.SongID,
+
+			ArtistID:   c.ArtistID,
+			Experiment: c.Experiment,
+			Recompute:  c.Recompute,
+			Partition:  msg.Partition,
+		}
+		a.counts[key] += c.Count
+		if c.ListenMs > 0 {
+			a.millis[key] += c.ListenMs
+		}
+	}
+	if len(p.Published) > 0 {
+		k := userDay{p.UserID, p.Day}
+		if a.published[k] == nil {
+			a.published[k] = make(freshness.Published)
+		}
+		a.published[k].Merge(p.Published)
+	}
+	a.track(msg)
+	metricPartialsMerged.Add(1)
+}
@@ -0,0 +1,604 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/system-design-lab/pkg/events"
+	"github.com/system-design-lab/pkg/kafkautil"
+	"github.com/system-design-lab/pkg/sqlstore"
+	"github.com/system-design-lab/pkg/storage"
+)
+
+// scaleTest is the SCALE_TEST=true run: several aggregator instances in this
+// process, members of a throwaway consumer group on a throwaway topic, checked
+// against the counters in Cassandra or the STORAGE_BACKEND database. It's
+// also run by scaletest_test.go (go test -tags integration).
+type scaleTest struct {
+	instances  int           // INSTANCES, at least 2 (default 3)
+	events     int           // SCALE_TEST_EVENTS
+	users      int           // SCALE_TEST_USERS
+	songs      int           // SCALE_TEST_SONGS
+	partitions int           // SCALE_TEST_PARTITIONS
+	timeout    time.Duration // SCALE_TEST_TIMEOUT, per wait
+}
+
+func scaleTestFromEnv(instances int) scaleTest {
+	if instances < 2 {
+		instances = 3
+	}
+	return scaleTest{
+		instances:  instances,
+		events:     getEnvInt("SCALE_TEST_EVENTS", 20000),
+		users:      getEnvInt("SCALE_TEST_USERS", 200),
+		songs:      getEnvInt("SCALE_TEST_SONGS", 50),
+		partitions: getEnvInt("SCALE_TEST_PARTITIONS", 12),
+		timeout:    getEnvDuration("SCALE_TEST_TIMEOUT", 2*time.Minute),
+	}
+}
+
+// countKey is one daily counter of user_daily_topk
+type countKey struct{ user, day, song string }
+
+// dayCounts reads back the counters the instances wrote
+type dayCounts interface {
+	DayCounts(ctx context.Context, userID, day string) (map[string]int64, error)
+}
+
+// member is a running instance of the test
+type member struct {
+	agg    *Aggregator
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+type scaleRun struct {
+	t       scaleTest
+	cfg     instanceConfig
+	client  *kafka.Client
+	topk    dayCounts
+	members []*member // every member started, stopped ones included
+	live    []*member
+
+	mismatches []string // of the last waitCounts
+}
+
+// runScaleTest checks that instances split the partitions, that a rebalance
+// alone flushes what they hold, and that the counters end up matching the
+// input exactly:
+//
+//  1. N instances join; every partition is assigned to exactly one, and the
+//     first half of the events is consumed without overlap.
+//  2. One more instance joins. Periodic flushes are off, so the first half
+//     can only reach Cassandra through the rebalance flushes.
+//  3. The second half is consumed and one instance leaves. The rest hold
+//     the second half until that rebalance flushes it, and then every
+//     counter must match the input.
+//  4. Exactly-once only: a partition's old owner finishes a late flush of
+//     part of what its new owner holds (staleOwnerRace). The new owner's
+//     claim is fenced partway through, and the rest must still be counted.
+func runScaleTest(cfg instanceConfig, t scaleTest) error {
+	id := strconv.FormatInt(time.Now().UnixNano(), 36)
+	cfg.kafka.SourceDC = "" // read under the name it's written with
+	cfg.topic = "aggregator.scaletest." + id
+	cfg.group = "aggregator-scaletest-" + id
+	cfg.flushInterval = 24 * time.Hour // only rebalances and shutdowns flush
+	cfg.songStats = false              // the test users would count in the real per-song stats
+	cfg.deltas = nil
+
+	r := &scaleRun{t: t, cfg: cfg, client: cfg.kafka.Client()}
+	if cfg.sql != nil {
+		r.topk = sqlstore.NewDailyTopKRepo(cfg.sql)
+	} else {
+		r.topk = storage.NewDailyTopKRepo(cfg.session)
+	}
+	ctx := context.Background()
+	log.Printf("Scale test %s: %d instances, %d events of %d users on %d partitions of %s (sink %s)",
+		id, t.instances, t.events, t.users, t.partitions, cfg.topic, cfg.sinkMode)
+
+	if err := r.createTopic(ctx); err != nil {
+		return fmt.Errorf("create %s: %w", cfg.topic, err)
+	}
+	defer r.deleteTopic()
+	defer r.stopAll()
+
+	msgs, keys := scaleTestEvents(id, t)
+	half := len(msgs) / 2
+	firstHalf, expected := tally(keys[:half]), tally(keys)
+	writer := cfg.kafka.NewWriter(cfg.topic, kafkautil.WriterConfigFromEnv())
+	defer writer.Close()
+
+	// 1. Assignment
+	for i := 0; i < t.instances; i++ {
+		if err := r.start(); err != nil {
+			return err
+		}
+	}
+	if err := r.waitAssigned(ctx, t.instances); err != nil {
+		return err
+	}
+	// An instance's first generation counts as a rebalance too: let every
+	// watchRebalances tick (a second) see it while nothing is held, or it
+	// would flush the first half before step 2
+	time.Sleep(2 * time.Second)
+	flushesBefore := metricRebalanceFlushes.Value()
+	if err := writer.WriteMessages(ctx, msgs[:half]...); err != nil {
+		return fmt.Errorf("write first half: %w", err)
+	}
+	if err := r.waitFetched(ctx); err != nil {
+		return err
+	}
+	if err := r.checkDisjoint(); err != nil {
+		return err
+	}
+	if n := metricRebalanceFlushes.Value() - flushesBefore; n > 0 {
+		return fmt.Errorf("%d rebalance flushes before any rebalance", n)
+	}
+
+	// 2. Rebalance-time flush
+	if err := r.start(); err != nil {
+		return err
+	}
+	if err := r.waitAssigned(ctx, t.instances+1); err != nil {
+		return err
+	}
+	if err := r.waitCounts(ctx, firstHalf); err != nil {
+		return fmt.Errorf("first half not flushed by the rebalance: %w", err)
+	}
+	log.Printf("Rebalance flushed the first half: %d rebalance flushes", metricRebalanceFlushes.Value()-flushesBefore)
+
+	// 3. Scale down, then the totals
+	if err := writer.WriteMessages(ctx, msgs[half:]...); err != nil {
+		return fmt.Errorf("write second half: %w", err)
+	}
+	if err := r.waitFetched(ctx); err != nil {
+		return err
+	}
+	flushesBefore = metricRebalanceFlushes.Value()
+	r.stop(r.live[0])
+	if err := r.waitAssigned(ctx, t.instances); err != nil {
+		return err
+	}
+	if err := r.waitCounts(ctx, expected); err != nil {
+		for i, m := range r.mismatches {
+			if i == 10 {
+				log.Printf("... and %d more", len(r.mismatches)-i)
+				break
+			}
+			log.Printf("Mismatch: %s", m)
+		}
+		return fmt.Errorf("second half not flushed by the rebalance, or not exactly: %w", err)
+	}
+	log.Printf("Rebalance flushed the second half: %d rebalance flushes", metricRebalanceFlushes.Value()-flushesBefore)
+
+	// 4. Stale owner
+	if cfg.sinkMode == sinkExactlyOnce {
+		race, raceKeys := scaleTestEvents(id+"-race", scaleTest{events: t.events / 4, users: t.users, songs: t.songs})
+		if err := writer.WriteMessages(ctx, race...); err != nil {
+			return fmt.Errorf("write the race events: %w", err)
+		}
+		for k, n := range tally(raceKeys) {
+			expected[k] += n
+		}
+		if err := r.staleOwnerRace(ctx, expected); err != nil {
+			return fmt.Errorf("stale owner race: %w", err)
+		}
+	}
+	r.stopAll()
+
+	// The shutdown flushes must not have added anything
+	mismatches, err := r.compare(ctx, expected)
+	if err != nil {
+		return err
+	}
+	if len(mismatches) > 0 {
+		return fmt.Errorf("%d of %d counters changed at shutdown, first %s", len(mismatches), len(expected), mismatches[0])
+	}
+	log.Printf("All %d counters match the %d events (%d duplicates skipped, %d flushes fenced)",
+		len(expected), len(msgs), metricDuplicatesSkipped.Value(), metricFlushesFenced.Value())
+	log.Printf("Test rows stay in %s under users %s-user-*", r.store(), id)
+	return nil
+}
+
+// staleOwnerRace applies the first half of a partition a live instance holds
+// the way its previous owner's rebalance flush would: through the apply log,
+// after the instance loaded the log's row. The instance's own flush is then
+// fenced partway through its offsets, drops them and reads the rest again.
+func (r *scaleRun) staleOwnerRace(ctx context.Context, want map[countKey]int64) error {
+	if err := r.waitFetched(ctx); err != nil {
+		return err
+	}
+	m := r.live[0]
+	a := m.agg
+	partition, held := -1, offsetRange{}
+	a.mu.Lock()
+	for p, rg := range a.ranges {
+		if rg.last > rg.first && (partition < 0 || rg.last-rg.first > held.last-held.first) {
+			partition, held = p, rg
+		}
+	}
+	a.mu.Unlock()
+	if partition < 0 {
+		return fmt.Errorf("%s holds no partition with two messages or more", a.name)
+	}
+	stale := offsetRange{first: held.first, last: (held.first + held.last) / 2}
+
+	msgs, err := r.fetchRange(ctx, partition, stale)
+	if err != nil {
+		return err
+	}
+	counts := make(map[countKey]int64)
+	for _, msg := range msgs {
+		e, err := events.Unmarshal(msg.Value)
+		if err != nil {
+			return fmt.Errorf("decode offset %d: %w", msg.Offset, err)
+		}
+		counts[countKey{e.UserID, e.Day(), e.SongID}]++
+	}
+	old := newExactlyOnce(a.once.flushes, a.once.group, a.once.topic, a.once.ids)
+	topk := storage.NewDailyTopKRepo(r.cfg.session)
+	var writeErr error
+	err = old.apply(ctx, partition, stale, func() {
+		for k, n := range counts {
+			if err := topk.Increment(ctx, k.user, k.day, k.song, n); err != nil && writeErr == nil {
+				writeErr = err
+			}
+		}
+	})
+	if err == nil {
+		err = writeErr
+	}
+	if err != nil {
+		return fmt.Errorf("apply offsets %d-%d of partition %d: %w", stale.first, stale.last, partition, err)
+	}
+	log.Printf("Applied partition %d offsets %d-%d behind %s, which holds %d-%d", partition, stale.first, stale.last, a.name, held.first, held.last)
+
+	rewound := metricFlushesRewound.Value()
+	a.flush(ctx)
+	if metricFlushesRewound.Value() == rewound {
+		return fmt.Errorf("%s's flush of partition %d wasn't fenced partway through", a.name, partition)
+	}
+	// What's read again waits for a flush like any other
+	err = r.poll(ctx, func() (bool, error) {
+		for _, l := range r.live {
+			l.agg.flush(ctx)
+		}
+		var err error
+		r.mismatches, err = r.compare(ctx, want)
+		return len(r.mismatches) == 0, err
+	})
+	if errors.Is(err, context.DeadlineExceeded) {
+		return fmt.Errorf("%d of %d counters still differ after the rewind: %w", len(r.mismatches), len(want), err)
+	}
+	return err
+}
+
+// fetchRange reads offsets rg of a partition straight from the broker,
+// outside the group
+func (r *scaleRun) fetchRange(ctx context.Context, partition int, rg offsetRange) ([]kafka.Message, error) {
+	var msgs []kafka.Message
+	for next := rg.first; next <= rg.last; {
+		resp, err := r.client.Fetch(ctx, &kafka.FetchRequest{
+			Topic: r.cfg.topic, Partition: partition, Offset: next, MinBytes: 1, MaxBytes: 10e6, MaxWait: time.Second,
+		})
+		if err == nil {
+			err = resp.Error
+		}
+		if err != nil {
+			return nil, fmt.Errorf("fetch %s[%d] at %d: %w", r.cfg.topic, partition, next, err)
+		}
+		from := next
+		for {
+			rec, err := resp.Records.ReadRecord()
+			if err != nil {
+				break // io.EOF at the end of the response
+			}
+			value, err := kafka.ReadAll(rec.Value)
+			if err != nil {
+				return nil, err
+			}
+			if rec.Offset >= next && rec.Offset <= rg.last {
+				msgs = append(msgs, kafka.Message{Partition: partition, Offset: rec.Offset, Value: value})
+				next = rec.Offset + 1
+			}
+		}
+		if next == from {
+			return nil, fmt.Errorf("fetch %s[%d] at %d: no records", r.cfg.topic, partition, next)
+		}
+	}
+	return msgs, nil
+}
+
+// store names where the counters are
+func (r *scaleRun) store() string {
+	if r.cfg.sql != nil {
+		return r.cfg.sql.Backend()
+	}
+	return "Cassandra"
+}
+
+// scaleTestEvents returns the test's messages, keyed by user like real
+// events, and the counter each one adds to
+func scaleTestEvents(id string, t scaleTest) ([]kafka.Message, []countKey) {
+	rng := rand.New(rand.NewSource(time.Now().UnixNano()))
+	now := time.Now()
+	msgs := make([]kafka.Message, 0, t.events)
+	keys := make([]countKey, 0, t.events)
+	for i := 0; i < t.events; i++ {
+		e := events.New(
+			fmt.Sprintf("%s-%d", id, i),
+			fmt.Sprintf("%s-user-%d", id, rng.Intn(t.users)),
+			fmt.Sprintf("song-%d", rng.Intn(t.songs)),
+			"scaletest",
+			now.Add(-time.Duration(rng.Int63n(int64(time.Hour)))),
+		)
+		value, err := events.Marshal(e, events.FormatJSON)
+		if err != nil {
+			log.Fatalf("Encoding test event: %v", err)
+		}
+		msgs = append(msgs, kafka.Message{Key: []byte(e.UserID), Value: value})
+		keys = append(keys, countKey{e.UserID, e.Day(), e.SongID})
+	}
+	return msgs, keys
+}
+
+func tally(keys []countKey) map[countKey]int64 {
+	counts := make(map[countKey]int64)
+	for _, k := range keys {
+		counts[k]++
+	}
+	return counts
+}
+
+func (r *scaleRun) createTopic(ctx context.Context) error {
+	resp, err := r.client.CreateTopics(ctx, &kafka.CreateTopicsRequest{
+		Topics: []kafka.TopicConfig{{Topic: r.cfg.topic, NumPartitions: r.t.partitions, ReplicationFactor: 1}},
+	})
+	if err != nil {
+		return err
+	}
+	return resp.Errors[r.cfg.topic]
+}
+
+func (r *scaleRun) deleteTopic() {
+	resp, err := r.client.DeleteTopics(context.Background(), &kafka.DeleteTopicsRequest{Topics: []string{r.cfg.topic}})
+	if err == nil {
+		err = resp.Errors[r.cfg.topic]
+	}
+	if err != nil {
+		log.Printf("Warning: failed to delete %s: %v", r.cfg.topic, err)
+	}
+}
+
+// start adds an instance to the group
+func (r *scaleRun) start() error {
+	name := fmt.Sprintf("aggregator-%d", len(r.members))
+	agg, err := newAggregator(context.Background(), r.cfg, name)
+	if err != nil {
+		return fmt.Errorf("start %s: %w", name, err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	m := &member{agg: agg, cancel: cancel, done: make(chan struct{})}
+	go func() {
+		defer close(m.done)
+		agg.run(ctx)
+	}()
+	r.members = append(r.members, m)
+	r.live = append(r.live, m)
+	log.Printf("Started %s", name)
+	return nil
+}
+
+// stop shuts an instance down the way a signal does, except that it stops
+// fetching before the last flush, so nothing is fetched after it
+func (r *scaleRun) stop(m *member) {
+	m.cancel()
+	<-m.done
+	m.agg.flush(context.Background())
+	m.agg.close()
+	for i, l := range r.live {
+		if l == m {
+			r.live = append(r.live[:i], r.live[i+1:]...)
+			break
+		}
+	}
+	log.Printf("Stopped %s", m.agg.name)
+}
+
+func (r *scaleRun) stopAll() {
+	for len(r.live) > 0 {
+		r.stop(r.live[len(r.live)-1])
+	}
+}
+
+// waitAssigned waits for the group to settle with n members and checks its
+// assignment: every partition owned by exactly one member, and no member
+// idle while there are enough partitions to go around
+func (r *scaleRun) waitAssigned(ctx context.Context, n int) error {
+	var last error
+	err := r.poll(ctx, func() (bool, error) {
+		resp, err := r.client.DescribeGroups(ctx, &kafka.DescribeGroupsRequest{GroupIDs: []string{r.cfg.group}})
+		if err != nil {
+			return false, err
+		}
+		if len(resp.Groups) != 1 || resp.Groups[0].Error != nil {
+			return false, fmt.Errorf("describe %s: %v", r.cfg.group, resp.Groups)
+		}
+		g := resp.Groups[0]
+		if g.GroupState != "Stable" || len(g.Members) != n {
+			last = fmt.Errorf("group is %s with %d members", g.GroupState, len(g.Members))
+			return false, nil
+		}
+
+		owner := make(map[int]string)
+		var summary []string
+		for _, mem := range g.Members {
+			var parts []int
+			for _, topic := range mem.MemberAssignments.Topics {
+				if topic.Topic == r.cfg.topic {
+					parts = append(parts, topic.Partitions...)
+				}
+			}
+			if len(parts) == 0 && r.t.partitions >= n {
+				last = fmt.Errorf("member %s has no partitions", mem.MemberID)
+				return false, nil
+			}
+			for _, p := range parts {
+				if prev, ok := owner[p]; ok {
+					return false, fmt.Errorf("partition %d assigned to both %s and %s", p, prev, mem.MemberID)
+				}
+				owner[p] = mem.MemberID
+			}
+			sort.Ints(parts)
+			summary = append(summary, fmt.Sprint(parts))
+		}
+		if len(owner) != r.t.partitions {
+			last = fmt.Errorf("%d of %d partitions assigned", len(owner), r.t.partitions)
+			return false, nil
+		}
+		sort.Strings(summary)
+		log.Printf("Assignment with %d members: %v", n, summary)
+		return true, nil
+	})
+	if errors.Is(err, context.DeadlineExceeded) && last != nil {
+		return fmt.Errorf("group didn't settle with %d members: %w", n, last)
+	}
+	return err
+}
+
+// waitFetched waits until the instances between them have fetched every
+// message written so far
+func (r *scaleRun) waitFetched(ctx context.Context) error {
+	var reqs []kafka.OffsetRequest
+	for p := 0; p < r.t.partitions; p++ {
+		reqs = append(reqs, kafka.LastOffsetOf(p))
+	}
+	resp, err := r.client.ListOffsets(ctx, &kafka.ListOffsetsRequest{Topics: map[string][]kafka.OffsetRequest{r.cfg.topic: reqs}})
+	if err != nil {
+		return fmt.Errorf("list offsets: %w", err)
+	}
+	ends := make(map[int]int64)
+	for _, p := range resp.Topics[r.cfg.topic] {
+		if p.Error != nil {
+			return fmt.Errorf("list offsets %s[%d]: %w", r.cfg.topic, p.Partition, p.Error)
+		}
+		ends[p.Partition] = p.LastOffset
+	}
+
+	var behind int64
+	err = r.poll(ctx, func() (bool, error) {
+		fetched := make(map[int]int64)
+		for _, m := range r.members {
+			for p, off := range m.agg.fetchedOffsets() {
+				if off > fetched[p] {
+					fetched[p] = off
+				}
+			}
+		}
+		behind = 0
+		for p, end := range ends {
+			if end > fetched[p] {
+				behind += end - fetched[p]
+			}
+		}
+		return behind == 0, nil
+	})
+	if errors.Is(err, context.DeadlineExceeded) {
+		return fmt.Errorf("%d messages still unfetched: %w", behind, err)
+	}
+	return err
+}
+
+// checkDisjoint checks that no two live instances fetched from the same
+// partition, which holds as long as the group hasn't rebalanced since they
+// joined
+func (r *scaleRun) checkDisjoint() error {
+	fetchedBy := make(map[int]string)
+	for _, m := range r.live {
+		var parts []int
+		for p := range m.agg.fetchedOffsets() {
+			if prev, ok := fetchedBy[p]; ok {
+				return fmt.Errorf("partition %d consumed by both %s and %s", p, prev, m.agg.name)
+			}
+			fetchedBy[p] = m.agg.name
+			parts = append(parts, p)
+		}
+		sort.Ints(parts)
+		log.Printf("%s consumed partitions %v", m.agg.name, parts)
+	}
+	return nil
+}
+
+// waitCounts waits until the counters match want
+func (r *scaleRun) waitCounts(ctx context.Context, want map[countKey]int64) error {
+	err := r.poll(ctx, func() (bool, error) {
+		var err error
+		r.mismatches, err = r.compare(ctx, want)
+		return len(r.mismatches) == 0, err
+	})
+	if errors.Is(err, context.DeadlineExceeded) {
+		return fmt.Errorf("%d of %d counters still differ: %w", len(r.mismatches), len(want), err)
+	}
+	return err
+}
+
+// compare reads the test users' days back and lists the counters that differ
+// from want, including ones want doesn't have
+func (r *scaleRun) compare(ctx context.Context, want map[countKey]int64) ([]string, error) {
+	type userDay struct{ user, day string }
+	days := make(map[userDay]map[string]int64)
+	for k, n := range want {
+		ud := userDay{k.user, k.day}
+		if days[ud] == nil {
+			days[ud] = make(map[string]int64)
+		}
+		days[ud][k.song] = n
+	}
+
+	var mismatches []string
+	for ud, songs := range days {
+		counts, err := r.topk.DayCounts(ctx, ud.user, ud.day)
+		if err != nil {
+			return nil, err
+		}
+		for song, got := range counts {
+			if w := songs[song]; got != w {
+				mismatches = append(mismatches, fmt.Sprintf("%s/%s/%s: got %d, want %d", ud.user, ud.day, song, got, w))
+			}
+		}
+		for song, w := range songs {
+			if _, ok := counts[song]; !ok {
+				mismatches = append(mismatches, fmt.Sprintf("%s/%s/%s: got 0, want %d", ud.user, ud.day, song, w))
+			}
+		}
+	}
+	sort.Strings(mismatches)
+	return mismatches, nil
+}
+
+// poll calls check every half second until it's done, fails, or the test's
+// timeout passes
+func (r *scaleRun) poll(ctx context.Context, check func() (bool, error)) error {
+	ctx, cancel := context.WithTimeout(ctx, r.t.timeout)
+	defer cancel()
+	t := time.NewTicker(500 * time.Millisecond)
+	defer t.Stop()
+	for {
+		done, err := check()
+		if err != nil || done {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+}
@@ -0,0 +1,106 @@
+//go:build integration
+
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/system-design-lab/pkg/kafkautil"
+	"github.com/system-design-lab/pkg/redisutil"
+	"github.com/system-design-lab/pkg/sqlstore"
+	"github.com/system-design-lab/pkg/startup"
+	"github.com/system-design-lab/pkg/storage"
+)
+
+// scaleTestConfig connects to the stack the service would: KAFKA_BROKER,
+// REDIS_ADDR, and CASSANDRA_HOSTS or the STORAGE_BACKEND database
+func scaleTestConfig(t *testing.T) instanceConfig {
+	t.Helper()
+	ctx := context.Background()
+
+	kafkaCfg, err := kafkautil.ConfigFromEnv("localhost:29092")
+	if err != nil {
+		t.Fatalf("Kafka config: %v", err)
+	}
+	if err := startup.Kafka(ctx, kafkaCfg); err != nil {
+		t.Fatalf("connect to Kafka: %v", err)
+	}
+
+	cfg := instanceConfig{kafka: kafkaCfg}
+	storageCfg, err := sqlstore.ConfigFromEnv()
+	if err != nil {
+		t.Fatalf("storage config: %v", err)
+	}
+	var takedowns *storage.TakedownRepo
+	var experiments *storage.ExperimentRepo
+	if storageCfg.SQL() {
+		if cfg.sql, err = startup.SQL(ctx, storageCfg); err != nil {
+			t.Fatalf("connect to %s: %v", storageCfg, err)
+		}
+		t.Cleanup(func() { cfg.sql.Close() })
+	} else {
+		if cfg.session, err = startup.Cassandra(ctx); err != nil {
+			t.Fatalf("connect to Cassandra: %v", err)
+		}
+		t.Cleanup(cfg.session.Close)
+		takedowns, experiments = storage.NewTakedownRepo(cfg.session), storage.NewExperimentRepo(cfg.session)
+	}
+
+	redisCfg, err := redisutil.ConfigFromEnv(getEnv("REDIS_ADDR", "localhost:6379"), 0)
+	if err != nil {
+		t.Fatalf("Redis config: %v", err)
+	}
+	cfg.redis = redisutil.NewClient(redisCfg, "default")
+	t.Cleanup(func() { cfg.redis.Close() })
+	if err := startup.Redis(ctx, cfg.redis); err != nil {
+		t.Fatalf("connect to Redis: %v", err)
+	}
+
+	// Loaded (and the test's songs never taken down) so that a takedown in
+	// the stack can't make a counter differ
+	cfg.takedowns = storage.NewTakedownSet(takedowns)
+	cfg.experiments = storage.NewExperimentSet(experiments)
+	ctx, cancel := context.WithCancel(ctx)
+	t.Cleanup(cancel)
+	if err := cfg.takedowns.Start(ctx); err != nil {
+		t.Fatalf("load takedowns: %v", err)
+	}
+	if err := cfg.experiments.Start(ctx); err != nil {
+		t.Fatalf("load experiments: %v", err)
+	}
+	return cfg
+}
+
+// TestScaleTest runs the SCALE_TEST check with 3 instances per sink: the
+// assignment splits the partitions, each rebalance flushes what the
+// instances hold, and the counters match the input exactly
+func TestScaleTest(t *testing.T) {
+	base := scaleTestConfig(t)
+	for _, sink := range []string{sinkCounter, sinkExactlyOnce} {
+		t.Run(sink, func(t *testing.T) {
+			if sink == sinkExactlyOnce && base.sql != nil {
+				t.Skip("the exactly-once sink keeps its apply log in Cassandra")
+			}
+			cfg := base
+			cfg.sinkMode = sink
+			var err error
+			if cfg.commit, err = commitStrategy("", sink); err != nil {
+				t.Fatal(err)
+			}
+			st := scaleTest{instances: 3, events: 6000, users: 100, songs: 30, partitions: 12, timeout: time.Minute}
+
+			flushes := metricRebalanceFlushes.Value()
+			if err := runScaleTest(cfg, st); err != nil {
+				t.Fatalf("scale test: %v", err)
+			}
+			// Every instance holding counts flushes at a rebalance: the 3
+			// that consumed the first half when the fourth joins, and the 3
+			// left holding the second half when one leaves
+			if got := metricRebalanceFlushes.Value() - flushes; got < int64(2*st.instances) {
+				t.Errorf("%d rebalance flushes, want at least %d", got, 2*st.instances)
+			}
+		})
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// listenersTTL keeps a week of HyperLogLogs for the api-server's multi-day
+// unique-listener estimates, like the bloom filters
+const listenersTTL = bloomTTLDays * 24 * time.Hour
+
+// listenersKey is the Redis HyperLogLog of a song's listeners on a day
+func listenersKey(day, songID string) string {
+	return fmt.Sprintf("hll:listeners:%s:%s", day, songID)
+}
+
+type songDay struct{ song, day string }
+
+// applySongStats adds a flush's stored daily counts to the per-song stats:
+// listens are counter increments, listeners are PFADDed to the song-day's
+// HyperLogLog and its new estimate is written over the old one. Re-adding a
+// user is a no-op for the HyperLogLog, so only listens share the counters'
+// replay caveats. Failures here cost the song stats only.
+func (a *Aggregator) applySongStats(ctx context.Context, daily map[AggregateKey]int64) {
+	if a.songs == nil || len(daily) == 0 {
+		return
+	}
+
+	listens := make(map[songDay]int64)
+	users := make(map[songDay][]interface{})
+	for key, delta := range daily {
+		k := songDay{key.SongID, key.Day}
+		listens[k] += delta
+		users[k] = append(users[k], key.UserID)
+	}
+
+	for k, delta := range listens {
+		if err := a.songs.IncrementListens(ctx, k.song, k.day, delta); err != nil {
+			log.Printf("Error updating song listens: %v", err)
+			metricSongStatErrors.Add(1)
+		}
+	}
+
+	pipe := a.redis.Pipeline()
+	counts := make(map[songDay]*redis.IntCmd, len(users))
+	for k, ids := range users {
+		key := listenersKey(k.day, k.song)
+		pipe.PFAdd(ctx, key, ids...)
+		pipe.Expire(ctx, key, listenersTTL)
+		counts[k] = pipe.PFCount(ctx, key)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		log.Printf("Error updating listener HyperLogLogs for %d songs: %v", len(users), err)
+		metricSongStatErrors.Add(1)
+		return
+	}
+	for k, cmd := range counts {
+		if err := a.songs.SetListeners(ctx, k.song, k.day, cmd.Val()); err != nil {
+			log.Printf("Error updating song listeners: %v", err)
+			metricSongStatErrors.Add(1)
+		}
+	}
+	metricSongDaysUpdated.Add(int64(len(listens)))
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"context"
+
+	"github.com/system-design-lab/pkg/sqlstore"
+	"github.com/system-design-lab/pkg/storage"
+)
+
+// STORAGE_BACKEND=postgres or sqlite writes the daily counts and listening
+// time to that database (pkg/sqlstore), where the api-server reads them and
+// its day totals. The hourly, artist, experiment, song and total counters
+// are Cassandra tables, so those aren't kept; nor are the exactly-once sink,
+// dual write, dedup audit and takedown purge available.
+
+// dailyCounters writes user_daily_topk, in Cassandra or the STORAGE_BACKEND
+// database
+type dailyCounters interface {
+	Increment(ctx context.Context, userID, day, songID string, delta int64) error
+	IncrementTime(ctx context.Context, userID, day, songID string, ms int64) error
+}
+
+// bloomHistory is the listen history the bloom filters are rebuilt from
+type bloomHistory interface {
+	Partitions(ctx context.Context, fn func(userID, day string) error) error
+	ScanDay(ctx context.Context, userID, day string, fn func(storage.HistoryRow) error) error
+}
+
+// historyOf is the history in the database the aggregator writes to: db's
+// unless nil, session's otherwise
+func historyOf(session *storage.Session, db *sqlstore.DB) bloomHistory {
+	if db != nil {
+		return sqlstore.NewListenHistoryRepo(db)
+	}
+	return storage.NewListenHistoryRepo(session)
+}
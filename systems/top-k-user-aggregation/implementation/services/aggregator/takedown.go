@@ -0,0 +1,259 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/segmentio/kafka-go"
+	"github.com/system-design-lab/pkg/events"
+	"github.com/system-design-lab/pkg/kafkautil"
+	"github.com/system-design-lab/pkg/storage"
+)
+
+// purger deletes the counters of taken-down songs (tools takedown). Every
+// aggregator process runs one in the same group on song.takedown, so each
+// takedown is purged once. New listens of the song are already dropped by
+// accumulate and flush; the purge waits out the delay first, so no
+// aggregator still holding counts of the song writes them after the delete.
+type purger struct {
+	reader    *kafka.Reader
+	takedowns *storage.TakedownSet
+	repo      *storage.TakedownRepo
+	topk      *storage.DailyTopKRepo
+	hourly    *storage.HourlyTopKRepo
+	totals    *storage.UserTotalsRepo
+	songs     *storage.SongStatsRepo  // nil = no per-song stats
+	shadow    *storage.ShadowTopKRepo // nil = no dual write
+	redis     *redis.Client
+	deltas    *kafka.Writer // nil = don't publish deltas
+	delay     time.Duration // after requested_at, before the purge
+}
+
+// run purges takedowns as they arrive until ctx is done. Takedowns already
+// waiting are purged together, in one scan of the counters. Offsets are
+// committed once a batch is purged, so a crash purges it again, which only
+// finds what is left.
+func (p *purger) run(ctx context.Context) {
+	for {
+		batch, msgs, err := p.fetchBatch(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			log.Printf("Error fetching takedown: %v", err)
+			continue
+		}
+		if len(batch) > 0 {
+			p.purgeWithRetry(ctx, batch)
+			if ctx.Err() != nil {
+				return // uncommitted, purged again on restart
+			}
+		}
+		if err := kafkautil.CommitWithRetry(ctx, p.reader, 3, kafkautil.LatestPerPartition(msgs)...); err != nil {
+			log.Printf("Error committing takedown offsets: %v", err)
+			metricCommitErrors.Add(1)
+		}
+	}
+}
+
+// fetchBatch blocks for a takedown, then takes the ones already waiting. Each
+// song is added to the takedown set right away, ahead of the table reload.
+func (p *purger) fetchBatch(ctx context.Context) (map[string]events.SongTakedown, []kafka.Message, error) {
+	batch := make(map[string]events.SongTakedown)
+	var msgs []kafka.Message
+	fetchCtx := ctx
+	for len(msgs) < 100 {
+		msg, err := p.reader.FetchMessage(fetchCtx)
+		if err != nil {
+			if len(msgs) > 0 && errors.Is(err, context.DeadlineExceeded) {
+				break
+			}
+			return batch, msgs, err
+		}
+		msgs = append(msgs, msg)
+		if len(msgs) == 1 {
+			var cancel context.CancelFunc
+			fetchCtx, cancel = context.WithTimeout(ctx, time.Second)
+			defer cancel()
+		}
+		if len(msg.Value) == 0 {
+			continue // a compacted-away key
+		}
+		t, err := events.UnmarshalTakedown(msg.Value)
+		if err != nil {
+			log.Printf("Error decoding takedown (offset %d): %v", msg.Offset, err)
+			metricTakedownErrors.Add(1)
+			continue
+		}
+		p.takedowns.Add(t.SongID)
+		batch[t.SongID] = t
+	}
+	return batch, msgs, nil
+}
+
+// purgeWithRetry skips the songs song_takedowns has as purged since their
+// request, waits out the delay and purges the rest, retrying until it
+// succeeds or ctx is done
+func (p *purger) purgeWithRetry(ctx context.Context, batch map[string]events.SongTakedown) {
+	songs := make(map[string]bool, len(batch))
+	var latest int64
+	recorded := p.recorded(ctx)
+	for song, t := range batch {
+		if r, ok := recorded[song]; ok && !r.PurgedAt.IsZero() && !r.PurgedAt.Before(time.Unix(t.RequestedAt, 0)) {
+			continue
+		}
+		songs[song] = true
+		if t.RequestedAt > latest {
+			latest = t.RequestedAt
+		}
+	}
+	if len(songs) == 0 {
+		return
+	}
+
+	wait := time.Until(time.Unix(latest, 0).Add(p.delay))
+	log.Printf("Purging %d taken-down songs in %s", len(songs), wait.Round(time.Second))
+	select {
+	case <-ctx.Done():
+		return
+	case <-time.After(wait):
+	}
+
+	backoff := time.Second
+	for {
+		start := time.Now()
+		rows, err := p.purge(ctx, songs)
+		if err == nil {
+			for song := range songs {
+				if err := p.repo.MarkPurged(ctx, song, rows[song]); err != nil {
+					log.Printf("Error marking %s purged: %v", song, err)
+					metricTakedownErrors.Add(1)
+				}
+				log.Printf("Purged %s: %d user-day rows in %s", song, rows[song], time.Since(start).Round(time.Millisecond))
+			}
+			metricTakedownsPurged.Add(int64(len(songs)))
+			return
+		}
+		log.Printf("Error purging %d songs, retrying in %s: %v", len(songs), backoff, err)
+		metricTakedownErrors.Add(1)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > time.Minute {
+			backoff = time.Minute
+		}
+	}
+}
+
+// recorded returns song_takedowns by song, or nothing if it can't be read
+// (every song of the batch is then purged)
+func (p *purger) recorded(ctx context.Context) map[string]storage.Takedown {
+	list, err := p.repo.List(ctx)
+	if err != nil {
+		log.Printf("Error reading takedowns: %v", err)
+		return nil
+	}
+	out := make(map[string]storage.Takedown, len(list))
+	for _, t := range list {
+		out[t.SongID] = t
+	}
+	return out
+}
+
+// purge scans the daily counters for the songs' rows and, for each, deletes
+// it with its hourly rows, takes its count off the user's daily total and
+// publishes the negative delta, so the materializer and compactor rewrite
+// the day. The per-song stats and listener HyperLogLogs go last. It returns
+// the rows deleted per song.
+//
+// A row is deleted before its total is decremented: a crash in between
+// leaves the total high rather than taking the count off twice on retry.
+func (p *purger) purge(ctx context.Context, songs map[string]bool) (map[string]int64, error) {
+	rows := make(map[string]int64)
+	var pending []events.AggregateDelta
+	now := time.Now().Unix()
+	err := p.topk.Scan(ctx, func(row storage.CounterRow) error {
+		if !songs[row.SongID] {
+			return nil
+		}
+		if err := p.topk.DeleteSong(ctx, row.UserID, row.Day, row.SongID); err != nil {
+			return err
+		}
+		if err := p.hourly.DeleteSong(ctx, row.UserID, row.Day, row.SongID); err != nil {
+			return err
+		}
+		if p.shadow != nil {
+			if err := p.shadow.DeleteSong(ctx, row.UserID, row.Day, row.SongID); err != nil {
+				return err
+			}
+		}
+		if row.Count != 0 {
+			if err := p.totals.Increment(ctx, row.UserID, row.Day, -row.Count); err != nil {
+				log.Printf("Error decrementing total of %s/%s: %v", row.UserID, row.Day, err)
+				metricTotalErrors.Add(1)
+			}
+		}
+		rows[row.SongID]++
+		metricTakedownRowsDeleted.Add(1)
+
+		pending = append(pending, events.AggregateDelta{
+			UserID:    row.UserID,
+			Day:       row.Day,
+			Songs:     []events.SongDelta{{SongID: row.SongID, Delta: -row.Count}},
+			FlushedAt: now,
+		})
+		if len(pending) >= 500 {
+			p.publish(ctx, pending)
+			pending = pending[:0]
+		}
+		return nil
+	})
+	p.publish(ctx, pending)
+	if err != nil {
+		return rows, err
+	}
+
+	for song := range songs {
+		if p.songs != nil {
+			if err := p.songs.DeleteSong(ctx, song); err != nil {
+				return rows, err
+			}
+		}
+		keys := make([]string, 0, bloomTTLDays)
+		for _, day := range storage.LastDays(bloomTTLDays) {
+			keys = append(keys, listenersKey(day, song))
+		}
+		if err := p.redis.Del(ctx, keys...).Err(); err != nil {
+			return rows, err
+		}
+	}
+	return rows, nil
+}
+
+// publish sends purge deltas like a flush's: best effort
+func (p *purger) publish(ctx context.Context, deltas []events.AggregateDelta) {
+	if p.deltas == nil || len(deltas) == 0 {
+		return
+	}
+	msgs := make([]kafka.Message, 0, len(deltas))
+	for _, d := range deltas {
+		value, err := events.MarshalDelta(d)
+		if err != nil {
+			log.Printf("Error encoding delta for %s/%s: %v", d.UserID, d.Day, err)
+			continue
+		}
+		msgs = append(msgs, kafka.Message{Key: []byte(d.UserID), Value: value})
+	}
+	kafkautil.Inject(ctx, msgs)
+	if err := p.deltas.WriteMessages(ctx, msgs...); err != nil {
+		log.Printf("Error publishing %d purge deltas to %s: %v", len(msgs), p.deltas.Topic, err)
+		metricDeltaErrors.Add(1)
+		return
+	}
+	metricDeltasPublished.Add(int64(len(msgs)))
+}
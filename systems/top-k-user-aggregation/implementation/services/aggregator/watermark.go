@@ -0,0 +1,243 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/segmentio/kafka-go"
+	"github.com/system-design-lab/pkg/events"
+)
+
+// Watermarks (WATERMARK_INTERVAL): each instance publishes, per partition it
+// owns, the latest listened_at it has flushed, so windowed consumers
+// downstream know how far in event time the counts are complete. A partition
+// with nothing to read is reported idle rather than stuck at its last
+// listen, so it doesn't hold their windows open.
+const defaultWatermarkTopic = "user.listen.watermarks"
+
+// watermarkConfig is what the instances of a process share
+type watermarkConfig struct {
+	writer   *kafka.Writer // nil = Redis only
+	interval time.Duration
+	idle     time.Duration // caught up and nothing fetched for this long = idle
+}
+
+// watermarkKey is the Redis hash of a group's watermarks on a topic, one
+// field per partition. It is also the checkpoint: an instance taking a
+// partition over starts from it, so a watermark never goes back.
+func watermarkKey(group, topic string) string {
+	return "watermarks:" + group + ":" + topic
+}
+
+// watermarks tracks one instance's partitions
+type watermarks struct {
+	watermarkConfig
+	redis    *redis.Client
+	group    string
+	topic    string
+	instance string
+
+	mu    sync.Mutex
+	marks map[int]*partitionMark
+}
+
+type partitionMark struct {
+	eventTime int64 // latest listened_at flushed, unix seconds
+	offset    int64 // after the last message flushed
+	fetchedAt time.Time
+	caughtUp  bool // the last message fetched was the partition's newest
+	loaded    bool // checkpoint read
+}
+
+func newWatermarks(c watermarkConfig, rdb *redis.Client, group, topic, name string) *watermarks {
+	instance, _ := os.Hostname()
+	if name != "aggregator" {
+		instance += "/" + name
+	}
+	return &watermarks{
+		watermarkConfig: c,
+		redis:           rdb,
+		group:           group,
+		topic:           topic,
+		instance:        instance,
+		marks:           make(map[int]*partitionMark),
+	}
+}
+
+func (w *watermarks) mark(partition int) *partitionMark {
+	m, ok := w.marks[partition]
+	if !ok {
+		m = &partitionMark{}
+		w.marks[partition] = m
+	}
+	return m
+}
+
+// fetched notes a message read from its partition
+func (w *watermarks) fetched(msg kafka.Message) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	m := w.mark(msg.Partition)
+	m.fetchedAt = time.Now()
+	m.caughtUp = msg.Offset+1 >= msg.HighWaterMark
+}
+
+// advance moves the partitions a flush covered up to its listens and offsets.
+// Ones forgotten by a rebalance meanwhile aren't brought back: another
+// instance may own them now.
+func (w *watermarks) advance(eventTimes map[int]int64, ranges map[int]offsetRange) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for p, t := range eventTimes {
+		if m, ok := w.marks[p]; ok && t > m.eventTime {
+			m.eventTime = t
+		}
+	}
+	for p, r := range ranges {
+		if m, ok := w.marks[p]; ok && r.last+1 > m.offset {
+			m.offset = r.last + 1
+		}
+	}
+}
+
+// run emits every interval until ctx is done
+func (w *watermarks) run(ctx context.Context) {
+	t := time.NewTicker(w.interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			w.emit(ctx)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// rebalanced emits, then forgets the partitions: the ones still owned come
+// back with their next message, from the checkpoint just written
+func (w *watermarks) rebalanced(ctx context.Context) {
+	w.emit(ctx)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for p := range w.marks {
+		metricWatermarkLag.Delete(strconv.Itoa(p))
+	}
+	w.marks = make(map[int]*partitionMark)
+}
+
+// emit writes every partition's watermark to Redis and the topic. Partitions
+// whose checkpoint can't be read yet are left for the next tick.
+func (w *watermarks) emit(ctx context.Context) {
+	key := watermarkKey(w.group, w.topic)
+	w.load(ctx, key)
+
+	now := time.Now()
+	w.mu.Lock()
+	marks := make([]events.PartitionWatermark, 0, len(w.marks))
+	for p, m := range w.marks {
+		if !m.loaded {
+			continue
+		}
+		marks = append(marks, events.PartitionWatermark{
+			Group:     w.group,
+			Topic:     w.topic,
+			Partition: p,
+			EventTime: m.eventTime,
+			Offset:    m.offset,
+			Idle:      m.caughtUp && now.Sub(m.fetchedAt) >= w.idle,
+			Instance:  w.instance,
+			UpdatedAt: now.Unix(),
+		})
+	}
+	w.mu.Unlock()
+	if len(marks) == 0 {
+		return
+	}
+
+	pipe := w.redis.Pipeline()
+	msgs := make([]kafka.Message, 0, len(marks))
+	for _, mark := range marks {
+		data, err := events.MarshalWatermark(mark)
+		if err != nil {
+			log.Printf("Error encoding watermark of partition %d: %v", mark.Partition, err)
+			continue
+		}
+		pipe.HSet(ctx, key, strconv.Itoa(mark.Partition), data)
+		msgs = append(msgs, kafka.Message{Key: []byte(events.WatermarkKey(w.group, w.topic, mark.Partition)), Value: data})
+
+		// An idle partition isn't behind
+		lag := new(expvar.Int)
+		if !mark.Idle && mark.EventTime > 0 {
+			lag.Set(now.Unix() - mark.EventTime)
+		}
+		metricWatermarkLag.Set(strconv.Itoa(mark.Partition), lag)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		log.Printf("Error writing %d watermarks to %s: %v", len(msgs), key, err)
+		metricWatermarkErrors.Add(1)
+	}
+	if w.writer != nil {
+		if err := w.writer.WriteMessages(ctx, msgs...); err != nil {
+			log.Printf("Error publishing %d watermarks to %s: %v", len(msgs), w.writer.Topic, err)
+			metricWatermarkErrors.Add(1)
+			return
+		}
+	}
+	metricWatermarksEmitted.Add(int64(len(msgs)))
+}
+
+// load reads the checkpoint of partitions new to this instance; what another
+// instance flushed before a rebalance may be ahead of what this one has
+func (w *watermarks) load(ctx context.Context, key string) {
+	w.mu.Lock()
+	var fields []string
+	for p, m := range w.marks {
+		if !m.loaded {
+			fields = append(fields, strconv.Itoa(p))
+		}
+	}
+	w.mu.Unlock()
+	if len(fields) == 0 {
+		return
+	}
+
+	values, err := w.redis.HMGet(ctx, key, fields...).Result()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		log.Printf("Warning: failed to read watermark checkpoints from %s: %v", key, err)
+		metricWatermarkErrors.Add(1)
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for i, v := range values {
+		p, _ := strconv.Atoi(fields[i])
+		m := w.mark(p)
+		m.loaded = true
+		data, ok := v.(string)
+		if !ok {
+			continue // no checkpoint yet
+		}
+		stored, err := events.UnmarshalWatermark([]byte(data))
+		if err != nil {
+			log.Printf("Warning: ignoring watermark checkpoint of partition %d: %v", p, err)
+			continue
+		}
+		m.eventTime = max(m.eventTime, stored.EventTime)
+		m.offset = max(m.offset, stored.Offset)
+	}
+}
+
+// noteEventTime records a listen of the next flush; a.mu must be held
+func (a *Aggregator) noteEventTime(partition int, event events.ListenEvent) {
+	if event.ListenedAt > a.eventTimes[partition] {
+		a.eventTimes[partition] = event.ListenedAt
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/system-design-lab/pkg/storage"
+)
+
+const (
+	kindSongRepeat = "song_repeat" // one song played implausibly often in a day
+	kindUserVolume = "user_volume" // more listens in a day than a person could play
+	kindUserSpike  = "user_spike"  // a day far above the user's own baseline
+)
+
+// Thresholds are the detection rules' limits
+type Thresholds struct {
+	SongDay         int64   // max listens of one song by one user in a day
+	UserDay         int64   // max listens by one user in a day
+	SpikeZ          float64 // z-score of a day's total against the user's baseline
+	SpikeMin        int64   // days below this total are never spikes
+	BaselineDays    int     // days before the evaluated one that make the baseline
+	MinBaselineDays int     // active days the baseline needs before a z-score counts
+}
+
+// baseline is the mean and standard deviation of a user's daily totals over
+// the days they listened at all
+type baseline struct {
+	mean, stddev float64
+	days         int
+}
+
+// Detector checks a user's counts for one day against the thresholds. Only
+// the flushed day is re-read per delta; the days before it change rarely, so
+// their baseline is cached.
+type Detector struct {
+	topk  *storage.DailyTopKRepo
+	th    Thresholds
+	cache map[string]baseline // user|day -> baseline of the days before day
+	max   int                 // cache entries before it is reset
+}
+
+func newDetector(topk *storage.DailyTopKRepo, th Thresholds, cacheSize int) *Detector {
+	return &Detector{topk: topk, th: th, cache: make(map[string]baseline), max: cacheSize}
+}
+
+// Evaluate returns the anomalies of userID on day, if any
+func (d *Detector) Evaluate(ctx context.Context, userID, day string) ([]storage.Anomaly, error) {
+	counts, err := d.topk.DayCounts(ctx, userID, day)
+	if err != nil {
+		return nil, fmt.Errorf("read counts: %w", err)
+	}
+
+	now := time.Now()
+	flag := func(kind, songID string, count int64, threshold, score float64) storage.Anomaly {
+		return storage.Anomaly{Day: day, UserID: userID, Kind: kind, SongID: songID,
+			Count: count, Threshold: threshold, Score: score, DetectedAt: now}
+	}
+
+	var out []storage.Anomaly
+	var total int64
+	for songID, c := range counts {
+		total += c
+		if d.th.SongDay > 0 && c > d.th.SongDay {
+			out = append(out, flag(kindSongRepeat, songID, c, float64(d.th.SongDay), float64(c)/float64(d.th.SongDay)))
+		}
+	}
+	if d.th.UserDay > 0 && total > d.th.UserDay {
+		out = append(out, flag(kindUserVolume, "", total, float64(d.th.UserDay), float64(total)/float64(d.th.UserDay)))
+	}
+
+	if d.th.SpikeZ > 0 && total >= d.th.SpikeMin {
+		b, err := d.baseline(ctx, userID, day)
+		if err != nil {
+			return nil, err
+		}
+		if b.days >= d.th.MinBaselineDays {
+			// A flat baseline has no spread; one listen of slack keeps a
+			// steady listener's ordinary day from scoring infinite
+			z := (float64(total) - b.mean) / math.Max(b.stddev, 1)
+			if z >= d.th.SpikeZ {
+				out = append(out, flag(kindUserSpike, "", total, d.th.SpikeZ, z))
+			}
+		}
+	}
+	return out, nil
+}
+
+// baseline returns the stats of the BaselineDays before day
+func (d *Detector) baseline(ctx context.Context, userID, day string) (baseline, error) {
+	key := userID + "|" + day
+	if b, ok := d.cache[key]; ok {
+		return b, nil
+	}
+
+	t, err := time.Parse(storage.DayFormat, day)
+	if err != nil {
+		return baseline{}, fmt.Errorf("invalid day %q: %w", day, err)
+	}
+	var totals []float64
+	for i := 1; i <= d.th.BaselineDays; i++ {
+		counts, err := d.topk.DayCounts(ctx, userID, t.AddDate(0, 0, -i).Format(storage.DayFormat))
+		if err != nil {
+			return baseline{}, fmt.Errorf("read baseline: %w", err)
+		}
+		var total int64
+		for _, c := range counts {
+			total += c
+		}
+		if total > 0 {
+			totals = append(totals, float64(total))
+		}
+	}
+
+	b := baseline{days: len(totals)}
+	if len(totals) > 0 {
+		for _, v := range totals {
+			b.mean += v
+		}
+		b.mean /= float64(len(totals))
+		for _, v := range totals {
+			b.stddev += (v - b.mean) * (v - b.mean)
+		}
+		b.stddev = math.Sqrt(b.stddev / float64(len(totals)))
+	}
+
+	if len(d.cache) >= d.max {
+		d.cache = make(map[string]baseline)
+	}
+	d.cache[key] = b
+	return b, nil
+}
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/segmentio/kafka-go"
+	"github.com/system-design-lab/pkg/chaos"
+	"github.com/system-design-lab/pkg/events"
+	"github.com/system-design-lab/pkg/kafkautil"
+	"github.com/system-design-lab/pkg/runtimecfg"
+	"github.com/system-design-lab/pkg/startup"
+	"github.com/system-design-lab/pkg/storage"
+	"github.com/system-design-lab/pkg/topology"
+)
+
+func main() {
+	if _, err := topology.Apply("anomaly-detector"); err != nil {
+		log.Fatalf("Invalid pipeline config: %v", err)
+	}
+
+	topic := getEnv("TOPIC", "user.listen.agg")
+	consumerGroup := getEnv("CONSUMER_GROUP", "anomaly-detector")
+	redisAddr := getEnv("REDIS_ADDR", "localhost:6379")
+	exclusionTTL := getEnvDuration("EXCLUSION_TTL", 8*24*time.Hour)
+	batchSize := getEnvInt("BATCH_SIZE", 500)
+	batchTimeout := getEnvDuration("BATCH_TIMEOUT", time.Second)
+	cacheSize := getEnvInt("BASELINE_CACHE_SIZE", 100000)
+	metricsAddr := getEnv("METRICS_ADDR", ":9107")
+	th := Thresholds{
+		SongDay:         int64(getEnvInt("SONG_DAY_LIMIT", 200)),
+		UserDay:         int64(getEnvInt("USER_DAY_LIMIT", 500)),
+		SpikeZ:          getEnvFloat("SPIKE_Z", 4),
+		SpikeMin:        int64(getEnvInt("SPIKE_MIN_COUNT", 100)),
+		BaselineDays:    getEnvInt("BASELINE_DAYS", 14),
+		MinBaselineDays: getEnvInt("MIN_BASELINE_DAYS", 3),
+	}
+
+	kafkaCfg, err := kafkautil.ConfigFromEnv("localhost:29092")
+	if err != nil {
+		log.Fatalf("Invalid Kafka config: %v", err)
+	}
+
+	log.Printf("Starting anomaly-detector: kafka=%v topic=%s group=%s song_day=%d user_day=%d spike_z=%.1f baseline=%dd",
+		kafkaCfg.Brokers, topic, consumerGroup, th.SongDay, th.UserDay, th.SpikeZ, th.BaselineDays)
+
+	if err := startup.Kafka(context.Background(), kafkaCfg); err != nil {
+		log.Fatalf("Failed to connect to Kafka: %v", err)
+	}
+
+	session, err := startup.Cassandra(context.Background())
+	if err != nil {
+		log.Fatalf("Failed to connect to Cassandra: %v", err)
+	}
+	defer session.Close()
+	log.Println("Connected to Cassandra")
+
+	rdb := redis.NewClient(&redis.Options{Addr: redisAddr})
+	defer rdb.Close()
+	if err := startup.Redis(context.Background(), rdb); err != nil {
+		log.Fatalf("Failed to connect to Redis: %v", err)
+	}
+	log.Println("Connected to Redis")
+	if chaos.Enabled() {
+		rdb.AddHook(chaos.RedisHook{})
+	}
+
+	reader := kafkaCfg.NewReader(kafkautil.ReaderConfig{Topic: topic, GroupID: consumerGroup})
+	defer reader.Close()
+
+	startMetricsServer(metricsAddr)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-sigChan
+		log.Println("Shutting down...")
+		cancel()
+	}()
+
+	// log_level and SIGHUP reloads of pipeline.yaml's settings (pkg/runtimecfg)
+	if err := runtimecfg.New(rdb, "anomaly-detector").Start(ctx); err != nil {
+		log.Printf("Warning: runtime config not loaded: %v", err)
+	}
+
+	m := &Monitor{
+		reader:       reader,
+		detector:     newDetector(storage.NewDailyTopKRepo(session), th, cacheSize),
+		anomalies:    storage.NewAnomalyRepo(session),
+		redis:        rdb,
+		exclusionTTL: exclusionTTL,
+		flagged:      make(map[string]bool),
+		batchSize:    batchSize,
+		batchTimeout: batchTimeout,
+	}
+	m.Run(ctx)
+
+	log.Println("Shutdown complete")
+}
+
+// Monitor consumes aggregate deltas in batches and evaluates each touched
+// user and day once per batch
+type Monitor struct {
+	reader       *kafka.Reader
+	detector     *Detector
+	anomalies    *storage.AnomalyRepo
+	redis        *redis.Client
+	exclusionTTL time.Duration
+	batchSize    int
+	batchTimeout time.Duration
+
+	flagged map[string]bool // anomalies already reported, so each is counted once
+}
+
+// maxFlagged bounds the reported set; past it the set is reset and anomalies
+// still ongoing are logged again
+const maxFlagged = 100000
+
+// exclusionKey is the Redis set of users flagged on day, read by global-charts
+func exclusionKey(day string) string {
+	return "anomalies:users:" + day
+}
+
+func (m *Monitor) Run(ctx context.Context) {
+	for {
+		batch, err := m.fetchBatch(ctx)
+		if ctx.Err() != nil {
+			return // an unprocessed batch is redelivered on restart
+		}
+		if len(batch) > 0 {
+			m.process(ctx, batch)
+		}
+		if err != nil {
+			log.Printf("Error fetching message: %v", err)
+		}
+	}
+}
+
+// fetchBatch blocks for the first message, then collects more until the
+// batch is full or batchTimeout has passed
+func (m *Monitor) fetchBatch(ctx context.Context) ([]kafka.Message, error) {
+	msg, err := m.reader.FetchMessage(ctx)
+	if err != nil {
+		return nil, err
+	}
+	batch := []kafka.Message{msg}
+
+	fetchCtx, cancel := context.WithTimeout(ctx, m.batchTimeout)
+	defer cancel()
+	for len(batch) < m.batchSize {
+		msg, err := m.reader.FetchMessage(fetchCtx)
+		if err != nil {
+			if errors.Is(err, context.DeadlineExceeded) {
+				break
+			}
+			return batch, err
+		}
+		batch = append(batch, msg)
+	}
+	return batch, nil
+}
+
+type userDay struct{ user, day string }
+
+func (m *Monitor) process(ctx context.Context, batch []kafka.Message) {
+	seen := make(map[userDay]bool)
+	var order []userDay
+	for _, msg := range batch {
+		delta, err := events.UnmarshalDelta(msg.Value)
+		if err != nil {
+			log.Printf("Error decoding delta (partition=%d offset=%d): %v", msg.Partition, msg.Offset, err)
+			metricDecodeErrors.Add(1)
+			continue
+		}
+		metricDeltasConsumed.Add(1)
+		k := userDay{delta.UserID, delta.Day}
+		if !seen[k] {
+			seen[k] = true
+			order = append(order, k)
+		}
+	}
+
+	found := 0
+	for _, k := range order {
+		found += m.evaluate(ctx, k.user, k.day)
+	}
+
+	if err := kafkautil.CommitWithRetry(ctx, m.reader, 3, kafkautil.LatestPerPartition(batch)...); err != nil {
+		log.Printf("Error committing offsets: %v", err)
+		metricCommitErrors.Add(1)
+	}
+	if found > 0 {
+		log.Printf("Processed %d deltas for %d user-days: %d new anomalies", len(batch), len(order), found)
+	}
+}
+
+// evaluate checks one user and day, stores what it finds and flags the user
+// for exclusion. It returns how many anomalies were new. Detection is best
+// effort: a failed write is retried on the user's next delta.
+func (m *Monitor) evaluate(ctx context.Context, userID, day string) int {
+	metricUserDays.Add(1)
+	found, err := m.detector.Evaluate(ctx, userID, day)
+	if err != nil {
+		log.Printf("Error evaluating %s on %s: %v", userID, day, err)
+		metricEvalErrors.Add(1)
+		return 0
+	}
+	if len(found) == 0 {
+		return 0
+	}
+
+	if err := m.exclude(ctx, userID, day); err != nil {
+		log.Printf("Error flagging %s for exclusion: %v", userID, err)
+		metricExclusionErrors.Add(1)
+	}
+
+	n := 0
+	for _, a := range found {
+		if err := m.anomalies.Put(ctx, a); err != nil {
+			log.Printf("Error storing %s anomaly for %s on %s: %v", a.Kind, userID, day, err)
+			metricWriteErrors.Add(1)
+			continue
+		}
+		key := fmt.Sprintf("%s|%s|%s|%s", day, userID, a.Kind, a.SongID)
+		if m.flagged[key] {
+			continue // known: the row was just refreshed with the new count
+		}
+		if len(m.flagged) >= maxFlagged {
+			m.flagged = make(map[string]bool)
+		}
+		m.flagged[key] = true
+		metricAnomalies.Add(a.Kind, 1)
+		log.Printf("Anomaly: kind=%s user=%s day=%s song=%q count=%d score=%.1f (threshold %.1f)",
+			a.Kind, userID, day, a.SongID, a.Count, a.Score, a.Threshold)
+		n++
+	}
+	return n
+}
+
+// exclude adds userID to the day's flagged set
+func (m *Monitor) exclude(ctx context.Context, userID, day string) error {
+	pipe := m.redis.TxPipeline()
+	pipe.SAdd(ctx, exclusionKey(day), userID)
+	pipe.Expire(ctx, exclusionKey(day), m.exclusionTTL)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+func getEnv(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
+
+func getEnvInt(key string, fallback int) int {
+	if v := os.Getenv(key); v != "" {
+		i, err := strconv.Atoi(v)
+		if err == nil {
+			return i
+		}
+	}
+	return fallback
+}
+
+func getEnvFloat(key string, fallback float64) float64 {
+	if v := os.Getenv(key); v != "" {
+		f, err := strconv.ParseFloat(v, 64)
+		if err == nil {
+			return f
+		}
+	}
+	return fallback
+}
+
+func getEnvDuration(key string, fallback time.Duration) time.Duration {
+	if v := os.Getenv(key); v != "" {
+		d, err := time.ParseDuration(v)
+		if err == nil {
+			return d
+		}
+	}
+	return fallback
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"expvar"
+	"log"
+	"net/http"
+)
+
+// Detector metrics, served as JSON on METRICS_ADDR/debug/vars
+var (
+	metricDeltasConsumed  = expvar.NewInt("deltas_consumed")
+	metricDecodeErrors    = expvar.NewInt("decode_errors")
+	metricUserDays        = expvar.NewInt("user_days_evaluated")
+	metricEvalErrors      = expvar.NewInt("evaluate_errors")
+	metricAnomalies       = expvar.NewMap("anomalies") // newly flagged, by kind
+	metricWriteErrors     = expvar.NewInt("write_errors")
+	metricExclusionErrors = expvar.NewInt("exclusion_errors")
+	metricCommitErrors    = expvar.NewInt("commit_errors")
+)
+
+// startMetricsServer exposes expvar metrics over HTTP
+func startMetricsServer(addr string) {
+	go func() {
+		log.Printf("Metrics on http://%s/debug/vars", addr)
+		if err := http.ListenAndServe(addr, nil); err != nil {
+			log.Printf("Metrics server error: %v", err)
+		}
+	}()
+}
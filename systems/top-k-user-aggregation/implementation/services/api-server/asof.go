@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/system-design-lab/pkg/storage"
+)
+
+// parseAsOf reads as_of=YYYY-MM-DD, the last day (UTC) of a past days
+// window. It returns "" when unset or today, which is the current window.
+func parseAsOf(v string) (string, error) {
+	if v == "" {
+		return "", nil
+	}
+	day, err := time.Parse(storage.DayFormat, v)
+	if err != nil {
+		return "", errors.New("as_of must be YYYY-MM-DD")
+	}
+	asOf, today := day.Format(storage.DayFormat), storage.LastDays(1)[0]
+	switch {
+	case asOf > today:
+		return "", errors.New("as_of is after today (UTC)")
+	case asOf == today:
+		return "", nil
+	}
+	return asOf, nil
+}
+
+// asOfTopK ranks the days window ending on asOf from the daily counters of
+// those days only. Snapshots and ranked lists hold the current window, so
+// they can't answer. The counters are read as they are now: listens that
+// arrived late, recomputes and takedowns since asOf are in. rank_by=recent
+// decays from asOf, not today.
+func asOfTopK(ctx context.Context, userID, asOf string, days int, rankBy string, halfLife int, experiment string, k int) ([]TopKResult, error) {
+	window := asOfWindow(asOf, days)
+	switch {
+	case experiment != "":
+		return experimentTopKOf(ctx, userID, experiment, window, k)
+	case rankBy == rankByTime:
+		totals, err := dailyTopK.SumTotals(ctx, userID, window)
+		return rankByListenTime(withoutTakedowns(totals), k), err
+	case rankBy == rankByRecent:
+		return recentTopK(ctx, userID, window, halfLife, k)
+	}
+	songCounts, err := dailyTopK.SumCounts(ctx, userID, window)
+	if err != nil {
+		return nil, err
+	}
+	return rankTopK(withoutTakedowns(songCounts), k), nil
+}
+
+// asOfWindow is the days window ending on asOf (parseAsOf's), newest first
+func asOfWindow(asOf string, days int) []string {
+	last, _ := time.Parse(storage.DayFormat, asOf)
+	return storage.DaysEnding(last, days)
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/system-design-lab/pkg/ratelimit"
+	"golang.org/x/sync/semaphore"
+)
+
+var (
+	metricCostInFlight = expvar.NewInt("query_cost_in_flight") // estimated rows being read
+	metricCostBudget   = expvar.NewInt("query_cost_budget")
+	metricCostQueued   = expvar.NewInt("query_cost_queued")     // queries that waited for budget
+	metricCostRejected = expvar.NewMap("query_budget_rejected") // by code
+)
+
+// Budget rejection codes
+const (
+	codeClientBudget = "client_budget" // 429: the client's share is in use
+	codeQueryBudget  = "query_budget"  // 503: the instance's budget is, and stayed so
+)
+
+// queryCost estimates the Cassandra rows a computed (cache-missing) read
+// touches: one partition row per song a user played in each day or hour of
+// the window. k doesn't change it, the whole window is summed either way.
+type queryCost struct {
+	Unit        string `json:"unit"` // day or hour
+	Units       int    `json:"units"`
+	RowsPerUnit int64  `json:"rows_per_unit"`
+	Rows        int64  `json:"rows"`
+}
+
+// Expected rows of a user's day and hour (QUERY_ROWS_PER_DAY, _PER_HOUR)
+var rowsPerDay, rowsPerHour int64 = 200, 20
+
+func dayCost(days int) queryCost {
+	return queryCost{Unit: "day", Units: days, RowsPerUnit: rowsPerDay, Rows: int64(days) * rowsPerDay}
+}
+
+func hourCost(hours int) queryCost {
+	return queryCost{Unit: "hour", Units: hours, RowsPerUnit: rowsPerHour, Rows: int64(hours) * rowsPerHour}
+}
+
+// queryBudget bounds the estimated rows read at once on this instance. A
+// query that doesn't fit waits in line for up to queueTimeout, then gets a
+// 503. One client may hold at most perClient of it, running or waiting, so a
+// batch storm from one caller is refused with a 429 before it queues up in
+// front of everyone else.
+type queryBudget struct {
+	sem          *semaphore.Weighted
+	total        int64
+	perClient    int64
+	queueTimeout time.Duration
+	maxQueued    int64
+
+	queued  atomic.Int64
+	mu      sync.Mutex
+	clients map[string]int64 // cost held or waited for, by client
+}
+
+// budget is nil when QUERY_COST_BUDGET is 0: reads are unbounded
+var budget *queryBudget
+
+func newQueryBudget(total, perClient int64, queueTimeout time.Duration, maxQueued int) *queryBudget {
+	if perClient <= 0 || perClient > total {
+		perClient = total
+	}
+	metricCostBudget.Set(total)
+	return &queryBudget{
+		sem:          semaphore.NewWeighted(total),
+		total:        total,
+		perClient:    perClient,
+		queueTimeout: queueTimeout,
+		maxQueued:    int64(maxQueued),
+		clients:      make(map[string]int64),
+	}
+}
+
+// budgetError is a query refused for its cost, served by writeBudgetError
+type budgetError struct {
+	Code       string    `json:"code"`
+	Message    string    `json:"error"`
+	Cost       queryCost `json:"cost"`
+	Budget     int64     `json:"budget"`
+	InUse      int64     `json:"in_use"` // the client's (429) or the instance's (503)
+	RetryAfter int       `json:"retry_after_seconds"`
+	Hint       string    `json:"hint"`
+}
+
+func (e *budgetError) Error() string { return e.Message }
+
+// acquire reserves cost for client, waiting in line when the budget is in
+// use. A query larger than the whole budget runs alone.
+func (b *queryBudget) acquire(ctx context.Context, client string, cost queryCost) (func(), error) {
+	if b == nil {
+		return func() {}, nil
+	}
+	n := min(cost.Rows, b.total)
+	clientN := min(n, b.perClient)
+
+	b.mu.Lock()
+	held := b.clients[client]
+	if held > 0 && held+clientN > b.perClient {
+		b.mu.Unlock()
+		metricCostRejected.Add(codeClientBudget, 1)
+		return nil, &budgetError{
+			Code:       codeClientBudget,
+			Message:    "too many expensive queries from this client",
+			Cost:       cost,
+			Budget:     b.perClient,
+			InUse:      held,
+			RetryAfter: 1,
+			Hint:       "wait for your queries in flight to finish, send fewer at once, or ask for fewer days",
+		}
+	}
+	b.clients[client] = held + clientN
+	b.mu.Unlock()
+	unreserve := func() {
+		b.mu.Lock()
+		if b.clients[client] -= clientN; b.clients[client] <= 0 {
+			delete(b.clients, client)
+		}
+		b.mu.Unlock()
+	}
+
+	if !b.sem.TryAcquire(n) {
+		metricCostQueued.Add(1)
+		if b.queued.Add(1) > b.maxQueued {
+			b.queued.Add(-1)
+			unreserve()
+			return nil, b.overloaded(cost)
+		}
+		waitCtx, cancel := context.WithTimeout(ctx, b.queueTimeout)
+		err := b.sem.Acquire(waitCtx, n)
+		cancel()
+		b.queued.Add(-1)
+		if err != nil {
+			unreserve()
+			return nil, b.overloaded(cost)
+		}
+	}
+	metricCostInFlight.Add(n)
+	return func() {
+		metricCostInFlight.Add(-n)
+		b.sem.Release(n)
+		unreserve()
+	}, nil
+}
+
+func (b *queryBudget) overloaded(cost queryCost) error {
+	metricCostRejected.Add(codeQueryBudget, 1)
+	return &budgetError{
+		Code:       codeQueryBudget,
+		Message:    "the API is at its read budget",
+		Cost:       cost,
+		Budget:     b.total,
+		InUse:      metricCostInFlight.Value(),
+		RetryAfter: max(1, int(b.queueTimeout/time.Second)),
+		Hint:       "retry after the delay",
+	}
+}
+
+// budgeted runs read within the budget, charged to r's client. Used as the
+// coalesced computation, so requests sharing a read are charged once.
+func budgeted[T any](r *http.Request, cost queryCost, read func(context.Context) (T, error)) func(context.Context) (T, error) {
+	client := ratelimit.ClientIP(r)
+	return func(ctx context.Context) (T, error) {
+		release, err := budget.acquire(ctx, client, cost)
+		if err != nil {
+			var zero T
+			return zero, err
+		}
+		defer release()
+		return read(ctx)
+	}
+}
+
+// writeBudgetError answers a budget refusal, reporting whether err was one
+func writeBudgetError(w http.ResponseWriter, err error) bool {
+	var be *budgetError
+	if !errors.As(err, &be) {
+		return false
+	}
+	status := http.StatusServiceUnavailable
+	if be.Code == codeClientBudget {
+		status = http.StatusTooManyRequests
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Retry-After", strconv.Itoa(be.RetryAfter))
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(be)
+	return true
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Cache holds serialized API responses for the handlers
+type Cache interface {
+	// Get returns a cached response, errCacheMiss if there is none. Any other
+	// error is a backend failure, which the handlers serve as a miss.
+	Get(ctx context.Context, key string) (string, error)
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	// Monitor refreshes the cache's metrics every interval until ctx ends
+	Monitor(ctx context.Context, interval time.Duration, ttl func() time.Duration)
+}
+
+var errCacheMiss = errors.New("cache miss")
+
+// Cache backends (CACHE_BACKEND)
+const (
+	cacheRedis  = "redis"  // shared by every instance
+	cacheLocal  = "local"  // in process, per instance; no Redis for the cache
+	cacheTiered = "tiered" // local in front of Redis
+)
+
+// newCache returns the backend's cache. shared is the Redis tier, nil for
+// the local backend.
+func newCache(backend string, shared *redisCache, localBytes int64, localTTL time.Duration) (Cache, error) {
+	switch backend {
+	case cacheRedis:
+		return shared, nil
+	case cacheLocal:
+		return newLocalCache(localBytes, 0)
+	case cacheTiered:
+		local, err := newLocalCache(localBytes, localTTL)
+		if err != nil {
+			return nil, err
+		}
+		return &tieredCache{local: local, shared: shared}, nil
+	}
+	return nil, fmt.Errorf("unknown cache backend %q (want redis, local or tiered)", backend)
+}
+
+// redisCache holds serialized responses in Redis under a key budget. Every
+// cached key is indexed in a sorted set scored by its last access, and past
+// maxKeys the least recently used are deleted here, rather than left to
+// Redis' maxmemory policy, which can't tell a cache entry from a bloom filter.
+type redisCache struct {
+	rdb     *redis.Client
+	index   string // sorted set: cache key -> last access (unix ms)
+	maxKeys int64  // 0 = no budget
+}
+
+func newRedisCache(rdb *redis.Client, prefix string, maxKeys int64) *redisCache {
+	metricCacheBudget.Set(maxKeys)
+	return &redisCache{rdb: rdb, index: prefix + "cache:topk:index", maxKeys: maxKeys}
+}
+
+// Get returns a cached response and marks it used
+func (c *redisCache) Get(ctx context.Context, key string) (string, error) {
+	pipe := c.rdb.Pipeline()
+	get := pipe.Get(ctx, key)
+	// XX: only touch keys already indexed, never index a miss
+	pipe.ZAddXX(ctx, c.index, redis.Z{Score: nowMillis(), Member: key})
+	pipe.Exec(ctx)
+	v, err := get.Result()
+	if errors.Is(err, redis.Nil) {
+		return "", errCacheMiss
+	}
+	return v, err
+}
+
+// Set caches a response and evicts the least recently used keys over budget
+func (c *redisCache) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	pipe := c.rdb.Pipeline()
+	pipe.Set(ctx, key, value, ttl)
+	pipe.ZAdd(ctx, c.index, redis.Z{Score: nowMillis(), Member: key})
+	card := pipe.ZCard(ctx, c.index)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return err
+	}
+	if over := card.Val() - c.maxKeys; c.maxKeys > 0 && over > 0 {
+		c.evict(ctx, over)
+	}
+	return nil
+}
+
+// evict deletes the n least recently used keys. Concurrent api-servers may
+// pop the same budget overrun; the result is only a few keys too many evicted.
+func (c *redisCache) evict(ctx context.Context, n int64) {
+	popped, err := c.rdb.ZPopMin(ctx, c.index, n).Result()
+	if err != nil || len(popped) == 0 {
+		return
+	}
+	keys := make([]string, len(popped))
+	for i, z := range popped {
+		keys[i] = z.Member.(string)
+	}
+	if err := c.rdb.Unlink(ctx, keys...).Err(); err != nil {
+		log.Printf("Warning: evicting %d cache keys: %v", len(keys), err)
+		return
+	}
+	metricCacheEvictions.Add(int64(len(keys)))
+}
+
+// Monitor refreshes the size metrics every interval until ctx ends. Keys that
+// expired by TTL leave the index once they've been idle longer than ttl():
+// a key is never read after its last access plus its TTL.
+func (c *redisCache) Monitor(ctx context.Context, interval time.Duration, ttl func() time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		c.refreshStats(ctx, ttl())
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// sampleSize is how many keys MEMORY USAGE is run on per refresh
+const sampleSize = 20
+
+func (c *redisCache) refreshStats(ctx context.Context, ttl time.Duration) {
+	expired := strconv.FormatFloat(nowMillis()-float64(ttl.Milliseconds()), 'f', 0, 64)
+	if err := c.rdb.ZRemRangeByScore(ctx, c.index, "-inf", "("+expired).Err(); err != nil {
+		log.Printf("Warning: pruning cache index: %v", err)
+		return
+	}
+	n, err := c.rdb.ZCard(ctx, c.index).Result()
+	if err != nil {
+		return
+	}
+	metricCacheKeys.Set(n)
+
+	sample, err := c.rdb.ZRandMember(ctx, c.index, sampleSize).Result()
+	if err == nil && len(sample) > 0 {
+		var total, found int64
+		for _, key := range sample {
+			if b, err := c.rdb.MemoryUsage(ctx, key).Result(); err == nil {
+				total += b
+				found++
+			}
+		}
+		if found > 0 {
+			metricCacheBytes.Set(total / found * n)
+		}
+	}
+
+	if info, err := c.rdb.InfoMap(ctx, "memory").Result(); err == nil {
+		if used, err := strconv.ParseInt(info["Memory"]["used_memory"], 10, 64); err == nil {
+			metricCacheRedisMemory.Set(used)
+		}
+	}
+}
+
+func nowMillis() float64 {
+	return float64(time.Now().UnixMilli())
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/dgraph-io/ristretto/v2"
+)
+
+// localCache holds responses in process memory (ristretto), bounded by bytes
+// rather than keys: admission and eviction weigh each entry by its size, and
+// keep the keys that are read most. Each instance has its own, so a fleet
+// computes every response once per instance.
+type localCache struct {
+	c      *ristretto.Cache[string, []byte]
+	maxTTL time.Duration // caps entry TTLs, 0 = none
+}
+
+func newLocalCache(maxBytes int64, maxTTL time.Duration) (*localCache, error) {
+	if maxBytes <= 0 {
+		return nil, fmt.Errorf("invalid local cache size %d bytes", maxBytes)
+	}
+	// ristretto wants ~10 counters per entry it will hold; responses run
+	// around 1KB
+	counters := maxBytes / 1024 * 10
+	if counters < 10000 {
+		counters = 10000
+	}
+	c, err := ristretto.NewCache(&ristretto.Config[string, []byte]{
+		NumCounters: counters,
+		MaxCost:     maxBytes,
+		BufferItems: 64,
+		Metrics:     true,
+		OnEvict:     func(*ristretto.Item[[]byte]) { metricCacheLocalEvictions.Add(1) },
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &localCache{c: c, maxTTL: maxTTL}, nil
+}
+
+func (c *localCache) Get(_ context.Context, key string) (string, error) {
+	v, ok := c.c.Get(key)
+	if !ok {
+		return "", errCacheMiss
+	}
+	return string(v), nil
+}
+
+// Set caches a response. Writes are buffered and may be dropped under
+// contention or refused by admission: a later miss recomputes it.
+func (c *localCache) Set(_ context.Context, key string, value []byte, ttl time.Duration) error {
+	if c.maxTTL > 0 && (ttl <= 0 || ttl > c.maxTTL) {
+		ttl = c.maxTTL
+	}
+	c.c.SetWithTTL(key, value, int64(len(value)), ttl)
+	return nil
+}
+
+// Monitor refreshes the local size metrics every interval until ctx ends.
+// Expired entries are dropped by ristretto itself.
+func (c *localCache) Monitor(ctx context.Context, interval time.Duration, _ func() time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		m := c.c.Metrics
+		metricCacheLocalKeys.Set(int64(m.KeysAdded() - m.KeysEvicted()))
+		metricCacheLocalBytes.Set(int64(m.CostAdded() - m.CostEvicted()))
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// tieredCache reads through a local tier to Redis. A Redis hit is copied
+// locally, so a hot key costs each instance one Redis read per local TTL,
+// and a response computed anywhere is served everywhere. Other instances
+// can't invalidate a local copy: it may outlive its Redis entry by up to
+// the local TTL, which is why that is short.
+type tieredCache struct {
+	local  *localCache
+	shared *redisCache
+}
+
+func (c *tieredCache) Get(ctx context.Context, key string) (string, error) {
+	if v, err := c.local.Get(ctx, key); err == nil {
+		metricCacheLocalHits.Add(1)
+		return v, nil
+	}
+	v, err := c.shared.Get(ctx, key)
+	if err == nil {
+		c.local.Set(ctx, key, []byte(v), c.local.maxTTL)
+	}
+	return v, err
+}
+
+func (c *tieredCache) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	c.local.Set(ctx, key, value, ttl)
+	return c.shared.Set(ctx, key, value, ttl)
+}
+
+func (c *tieredCache) Monitor(ctx context.Context, interval time.Duration, ttl func() time.Duration) {
+	go c.local.Monitor(ctx, interval, ttl)
+	c.shared.Monitor(ctx, interval, ttl)
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"time"
+
+	"github.com/system-design-lab/pkg/storage"
+)
+
+// metricCacheTrimmed counts responses cut down from a larger cached k
+var metricCacheTrimmed = expvar.NewInt("cache_trimmed")
+
+// kBuckets are the k a response is computed and cached at: a request is
+// served from the smallest bucket holding its k, trimmed, so clients asking
+// for k=10, 12 and 20 share one entry (k=25)
+var kBuckets = []int{10, 25, 50, 100}
+
+// bucketK is the k to compute and cache a request for k at
+func bucketK(k int) int {
+	for _, b := range kBuckets {
+		if k <= b {
+			return b
+		}
+	}
+	return k
+}
+
+// windowKey names a window by its length and its last day (UTC), so a
+// days=7 entry computed before midnight isn't served after it
+func windowKey(days int) string {
+	return windowKeyAsOf(days, storage.LastDays(1)[0])
+}
+
+// windowKeyAsOf names a days window ending on a given day
+func windowKeyAsOf(days int, last string) string {
+	return fmt.Sprintf("%d@%s", days, last)
+}
+
+// hourWindowKey names a sliding window by its length and its last hour (UTC)
+func hourWindowKey(hours int) string {
+	return fmt.Sprintf("%dh@%s", hours, time.Now().UTC().Format("2006-01-02T15"))
+}
+
+// untilNextDay is how long the day windows keep their last day
+func untilNextDay() time.Duration {
+	return time.Until(time.Now().UTC().Truncate(24 * time.Hour).Add(24 * time.Hour))
+}
+
+// trimmer is a response whose results can be cut to a smaller k
+type trimmer interface {
+	trim(k int)
+}
+
+func (r *TopKResponse) trim(k int) {
+	if len(r.Results) > k {
+		r.Results = r.Results[:k]
+	}
+	r.K = k
+}
+
+func (r *ArtistTopKResponse) trim(k int) {
+	if len(r.Results) > k {
+		r.Results = r.Results[:k]
+	}
+	r.K = k
+}
+
+func (r *TagTopKResponse) trim(k int) {
+	if len(r.Results) > k {
+		r.Results = r.Results[:k]
+	}
+	r.K = k
+}
+
+func (r *FilteredTopKResponse) trim(k int) {
+	if len(r.Results) > k {
+		r.Results = r.Results[:k]
+	}
+	r.K = k
+}
+
+// trimJSON serves a response cached at bucketK(k) for k. Results are ranked,
+// so the first k are the top k; data is returned as is when k is a bucket.
+func trimJSON[T any, PT interface {
+	*T
+	trimmer
+}](data []byte, k int) ([]byte, error) {
+	if k == bucketK(k) {
+		return data, nil
+	}
+	var resp T
+	if err := json.Unmarshal(data, &resp); err != nil {
+		return nil, fmt.Errorf("trim cached response: %w", err)
+	}
+	PT(&resp).trim(k)
+	metricCacheTrimmed.Add(1)
+	return json.Marshal(resp)
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"expvar"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/system-design-lab/pkg/storage"
+)
+
+// settledMaxAge is the Cache-Control max-age of settled responses
+const settledMaxAge = 365 * 24 * time.Hour
+
+var (
+	cdnCache   bool          // CDN_CACHE: send Cache-Control for a CDN in front
+	cdnMaxAge  = time.Minute // CDN_MAX_AGE: longest max-age of windows that can still change
+	settleDays = 8           // SETTLE_DAYS: days after which a day's counts are taken as final
+	signingKey []byte        // RESPONSE_SIGNING_KEY; nil = responses aren't signed
+
+	metricSettledResponses = expvar.NewInt("settled_responses") // served with the long max-age
+	metricSignedResponses  = expvar.NewInt("signed_responses")
+)
+
+// settled reports whether day is at least SETTLE_DAYS in the past (UTC):
+// past the bloom filters' dedup window and any recompute, so a window ending
+// on it no longer changes, barring takedowns and deletions
+func settled(day string) bool {
+	return day <= time.Now().UTC().AddDate(0, 0, -settleDays).Format(storage.DayFormat)
+}
+
+// setCacheControl tells a CDN how long it may keep a user's response:
+// settled windows for settledMaxAge, as immutable, the others for their
+// response cache TTL up to CDN_MAX_AGE. Surrogate-Key lets a user's copies
+// be purged at once, after a deletion or takedown. Without CDN_CACHE it does
+// nothing.
+func setCacheControl(w http.ResponseWriter, userID string, isSettled bool, ttl time.Duration) {
+	if !cdnCache {
+		return
+	}
+	w.Header().Set("Surrogate-Key", "user:"+userID)
+	if isSettled {
+		metricSettledResponses.Add(1)
+		w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d, immutable", int(settledMaxAge.Seconds())))
+		return
+	}
+	maxAge := min(ttl, cdnMaxAge)
+	if maxAge < time.Second {
+		w.Header().Set("Cache-Control", "no-store")
+		return
+	}
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(maxAge.Seconds())))
+}
+
+// signResponse sets X-TopK-Signature, an HMAC-SHA256 under
+// RESPONSE_SIGNING_KEY of the request URI and the body, so a client can tell
+// a CDN's copy is the response to its request, unaltered
+func signResponse(w http.ResponseWriter, r *http.Request, body []byte) {
+	if signingKey == nil {
+		return
+	}
+	metricSignedResponses.Add(1)
+	w.Header().Set("X-TopK-Signature", "v1="+responseSignature(r.URL.RequestURI(), body))
+}
+
+// responseSignature is the hex HMAC of "<request URI>\n<body>"
+func responseSignature(requestURI string, body []byte) string {
+	mac := hmac.New(sha256.New, signingKey)
+	mac.Write([]byte(requestURI))
+	mac.Write([]byte{'\n'})
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
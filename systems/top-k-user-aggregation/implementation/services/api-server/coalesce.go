@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+
+	"github.com/system-design-lab/pkg/storage"
+	"golang.org/x/sync/singleflight"
+)
+
+// Dashboards ask for several windows of a user at once (days=1, 7 and 30),
+// and every window sums the same recent day partitions. Reads in flight are
+// shared at two levels: identical requests that miss the cache wait for one
+// computation of the response (keyed by cache key), and different windows
+// share the partition reads they have in common (keyed by table, user and
+// partition). Only reads in flight are shared; nothing is kept after they
+// return.
+var (
+	responseFlights  singleflight.Group
+	partitionFlights singleflight.Group
+
+	// Calls that joined a read in flight instead of making their own, by
+	// table ("response" for whole responses)
+	metricCoalesced = expvar.NewMap("coalesced_reads")
+)
+
+// coalesce calls read once for all concurrent callers with the same key and
+// hands each the result, which they must not modify. The read runs without
+// the first caller's cancellation, since the others still want it; query
+// timeouts bound it.
+func coalesce[T any](ctx context.Context, g *singleflight.Group, table, key string, read func(context.Context) (T, error)) (T, error) {
+	ran := false
+	v, err, _ := g.Do(key, func() (interface{}, error) {
+		ran = true
+		return read(context.WithoutCancel(ctx))
+	})
+	if !ran {
+		metricCoalesced.Add(table, 1)
+	}
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+	return v.(T), nil
+}
+
+func partitionKey(table, userID, partition string) string {
+	return table + "/" + userID + "/" + partition
+}
+
+// coalescedDailyTopK sums user_daily_topk through shared day reads. Counts
+// come from the totals read, so a count window and a time window of the
+// same user share it too.
+type coalescedDailyTopK struct{ r dayTotalsReader }
+
+// dayTotalsReader reads one day of user_daily_topk, in Cassandra or a
+// STORAGE_BACKEND database
+type dayTotalsReader interface {
+	DayTotals(ctx context.Context, userID, day string) (map[string]storage.SongTotals, error)
+}
+
+func (c coalescedDailyTopK) SumCounts(ctx context.Context, userID string, days []string) (map[string]int64, error) {
+	totals, err := c.SumTotals(ctx, userID, days)
+	if err != nil {
+		return nil, err
+	}
+	return countsOf(totals), nil
+}
+
+func (c coalescedDailyTopK) SumTotals(ctx context.Context, userID string, days []string) (map[string]storage.SongTotals, error) {
+	const table = "user_daily_topk"
+	sum := make(map[string]storage.SongTotals)
+	for _, day := range days {
+		totals, err := coalesce(ctx, &partitionFlights, table, partitionKey(table, userID, day), func(ctx context.Context) (map[string]storage.SongTotals, error) {
+			return c.r.DayTotals(ctx, userID, day)
+		})
+		if err != nil {
+			return nil, err
+		}
+		sumTotals(sum, totals)
+	}
+	return sum, nil
+}
+
+// coalescedHourlyTopK sums user_hourly_topk through shared span reads.
+// Windows of different lengths share the spans they cover alike: the
+// current day's and every whole day's.
+type coalescedHourlyTopK struct{ r *storage.HourlyTopKRepo }
+
+func (c coalescedHourlyTopK) SumCounts(ctx context.Context, userID string, spans []storage.HourSpan) (map[string]int64, error) {
+	totals, err := c.SumTotals(ctx, userID, spans)
+	if err != nil {
+		return nil, err
+	}
+	return countsOf(totals), nil
+}
+
+func (c coalescedHourlyTopK) SumTotals(ctx context.Context, userID string, spans []storage.HourSpan) (map[string]storage.SongTotals, error) {
+	const table = "user_hourly_topk"
+	sum := make(map[string]storage.SongTotals)
+	for _, span := range spans {
+		key := partitionKey(table, userID, fmt.Sprintf("%s/%d-%d", span.Day, span.From, span.To))
+		totals, err := coalesce(ctx, &partitionFlights, table, key, func(ctx context.Context) (map[string]storage.SongTotals, error) {
+			return c.r.HourTotals(ctx, userID, span)
+		})
+		if err != nil {
+			return nil, err
+		}
+		sumTotals(sum, totals)
+	}
+	return sum, nil
+}
+
+// coalescedArtistTopK sums user_daily_artist_topk through shared day reads
+type coalescedArtistTopK struct{ r *storage.DailyArtistTopKRepo }
+
+func (c coalescedArtistTopK) SumCounts(ctx context.Context, userID string, days []string) (map[string]int64, error) {
+	const table = "user_daily_artist_topk"
+	sum := make(map[string]int64)
+	for _, day := range days {
+		counts, err := coalesce(ctx, &partitionFlights, table, partitionKey(table, userID, day), func(ctx context.Context) (map[string]int64, error) {
+			return c.r.DayCounts(ctx, userID, day)
+		})
+		if err != nil {
+			return nil, err
+		}
+		for artist, n := range counts {
+			sum[artist] += n
+		}
+	}
+	return sum, nil
+}
+
+// coalescedTagTopK sums user_daily_tag_topk through shared day reads
+type coalescedTagTopK struct{ r *storage.TagTopKRepo }
+
+func (c coalescedTagTopK) SumCounts(ctx context.Context, userID, kind string, days []string) (map[string]int64, error) {
+	const table = "user_daily_tag_topk"
+	sum := make(map[string]int64)
+	for _, day := range days {
+		counts, err := coalesce(ctx, &partitionFlights, table, partitionKey(table, userID, day+"/"+kind), func(ctx context.Context) (map[string]int64, error) {
+			return c.r.DayCounts(ctx, userID, day, kind)
+		})
+		if err != nil {
+			return nil, err
+		}
+		for tag, n := range counts {
+			sum[tag] += n
+		}
+	}
+	return sum, nil
+}
+
+func sumTotals(sum, add map[string]storage.SongTotals) {
+	for song, t := range add {
+		s := sum[song]
+		s.Count += t.Count
+		s.Millis += t.Millis
+		sum[song] = s
+	}
+}
+
+func countsOf(totals map[string]storage.SongTotals) map[string]int64 {
+	counts := make(map[string]int64, len(totals))
+	for song, t := range totals {
+		counts[song] = t.Count
+	}
+	return counts
+}
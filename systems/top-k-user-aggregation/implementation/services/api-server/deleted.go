@@ -0,0 +1,40 @@
+package main
+
+import (
+	"encoding/json"
+	"expvar"
+	"net/http"
+
+	"github.com/system-design-lab/pkg/userstate"
+)
+
+// metricDeletedUserRequests counts requests answered 404 user_deleted
+var metricDeletedUserRequests = expvar.NewInt("deleted_user_requests")
+
+// errorCodeUserDeleted tells a soft-deleted user from an unknown one
+const errorCodeUserDeleted = "user_deleted"
+
+// deletedUsers are the users the crawl-scheduler soft-deleted, reloaded
+// every userstate.Refresh. Their data is kept for the grace period but every
+// /users/{user_id}/... route hides it.
+var deletedUsers *userstate.Set
+
+// hideDeleted answers 404 with {"code": "user_deleted"} for a soft-deleted
+// user and reports whether it did
+func hideDeleted(w http.ResponseWriter, userID string) bool {
+	if deletedUsers == nil {
+		return false
+	}
+	if _, ok := deletedUsers.Deleted(userID); !ok {
+		return false
+	}
+	metricDeletedUserRequests.Add(1)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusNotFound)
+	json.NewEncoder(w).Encode(map[string]string{
+		"code":    errorCodeUserDeleted,
+		"error":   "user is deleted",
+		"user_id": userID,
+	})
+	return true
+}
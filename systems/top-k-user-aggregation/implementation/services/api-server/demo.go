@@ -0,0 +1,448 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/system-design-lab/pkg/storage"
+)
+
+// -demo runs the API with nothing else up. An embedded Redis (miniredis)
+// holds the response cache, runtime config, global charts and listener
+// HyperLogLogs, and demoStore stands in for the Cassandra tables, fed by a
+// synthetic listen generator that plays the pipeline's part: it seeds a
+// history at start, then keeps listening at DEMO_RATE.
+
+type demoConfig struct {
+	Users           int
+	Songs           int     // song-N is by artist-N/10, like loadgen's catalog
+	HistoryDays     int     // days of listens seeded at start, today included
+	ListensPerDay   int     // per user, on average, while seeding
+	Rate            float64 // live listens/s across all users (0 = none)
+	ZipfS           float64 // song popularity skew, as in loadgen
+	PublishInterval time.Duration
+}
+
+func demoConfigFromEnv() (demoConfig, error) {
+	cfg := demoConfig{
+		Users:           getEnvInt("DEMO_USERS", 20),
+		Songs:           getEnvInt("DEMO_SONGS", 100),
+		HistoryDays:     getEnvInt("DEMO_HISTORY_DAYS", 30),
+		ListensPerDay:   getEnvInt("DEMO_LISTENS_PER_DAY", 25),
+		Rate:            float64(getEnvInt("DEMO_RATE", 5)),
+		ZipfS:           1.1,
+		PublishInterval: getEnvDuration("DEMO_PUBLISH_INTERVAL", 10*time.Second),
+	}
+	switch {
+	case cfg.Users < 1 || cfg.Songs < 2:
+		return cfg, fmt.Errorf("DEMO_USERS must be >= 1 and DEMO_SONGS >= 2")
+	case cfg.HistoryDays < 1 || cfg.HistoryDays > 365:
+		return cfg, fmt.Errorf("DEMO_HISTORY_DAYS must be 1-365")
+	case cfg.ListensPerDay < 0 || cfg.Rate < 0:
+		return cfg, fmt.Errorf("DEMO_LISTENS_PER_DAY and DEMO_RATE must be >= 0")
+	case cfg.PublishInterval <= 0:
+		return cfg, fmt.Errorf("DEMO_PUBLISH_INTERVAL must be > 0")
+	}
+	return cfg, nil
+}
+
+// Synthetic catalog tags, the ones metadata/songs.jsonl uses
+var (
+	demoGenres = []string{"pop", "rock", "indie", "electronic", "hip-hop", "jazz", "folk", "soul", "classical", "metal"}
+	demoMoods  = []string{"upbeat", "chill", "energetic", "melancholy"}
+)
+
+type (
+	userDay  struct{ user, day string }
+	userHour struct {
+		user, day string
+		hour      int
+	}
+	songDay struct{ song, day string }
+	dayHour struct {
+		day  string
+		hour int
+	}
+)
+
+// demoStore keeps every counter the API reads in memory
+type demoStore struct {
+	cfg  demoConfig
+	rng  *rand.Rand // only used by the goroutine generating listens
+	zipf *rand.Zipf
+
+	mu        sync.RWMutex
+	daily     map[userDay]map[string]storage.SongTotals
+	hourly    map[userHour]map[string]storage.SongTotals
+	artists   map[userDay]map[string]int64
+	tags      map[userDay]map[storage.TagKey]int64
+	totals    map[userDay]int64
+	listens   map[songDay]int64
+	listeners map[songDay]map[string]bool
+	global    map[dayHour]map[string]int64 // every user's listens, for the charts
+	generated int64
+}
+
+// startDemo starts the embedded Redis and seeds a demoStore. It returns the
+// Redis address to connect to.
+func startDemo(cfg demoConfig) (*demoStore, string, error) {
+	mr, err := miniredis.Run()
+	if err != nil {
+		return nil, "", fmt.Errorf("start embedded Redis: %w", err)
+	}
+
+	rng := rand.New(rand.NewSource(time.Now().UnixNano()))
+	d := &demoStore{
+		cfg:       cfg,
+		rng:       rng,
+		zipf:      rand.NewZipf(rng, cfg.ZipfS, 1, uint64(cfg.Songs-1)),
+		daily:     make(map[userDay]map[string]storage.SongTotals),
+		hourly:    make(map[userHour]map[string]storage.SongTotals),
+		artists:   make(map[userDay]map[string]int64),
+		tags:      make(map[userDay]map[storage.TagKey]int64),
+		totals:    make(map[userDay]int64),
+		listens:   make(map[songDay]int64),
+		listeners: make(map[songDay]map[string]bool),
+		global:    make(map[dayHour]map[string]int64),
+	}
+	d.seed(time.Now())
+	log.Printf("Demo: seeded %d listens for %d users over %d days, embedded Redis on %s",
+		d.generated, cfg.Users, cfg.HistoryDays, mr.Addr())
+	return d, mr.Addr(), nil
+}
+
+// install points the handlers' readers at the store
+func (d *demoStore) install() {
+	dailyTopK = demoDaily{d}
+	filteredTopK = demoDaily{d}
+	hourlyTopK = demoHourly{d}
+	artistTopK = demoArtists{d}
+	tagTopK = demoTags{d}
+	userTotals = demoTotals{d}
+	songStats = demoSongStats{d}
+	// Listens are only counted, not kept: exports have no history rows
+	listenHistory = demoNoHistory{}
+	// Snapshots, ranked lists and year reviews are written by the
+	// materializer and tools, which the demo doesn't run
+	snapshots = demoNoSnapshots{}
+	rankedLists = demoNoSnapshots{}
+	yearReviews = demoNoReviews{}
+	// Playlists are loaded with tools metadata: filter with song_ids
+	playlists = demoNoPlaylists{}
+	// No song_takedowns to load: nothing is taken down
+	takedowns = storage.NewTakedownSet(nil)
+	// Nor experiments: experiment= answers 404
+	experiments = storage.NewExperimentSet(nil)
+}
+
+// seed plays about ListensPerDay listens per user on each of the last
+// HistoryDays days, up to now. Users skip a day now and then, so activity
+// streaks break.
+func (d *demoStore) seed(now time.Time) {
+	now = now.UTC()
+	today := now.Truncate(24 * time.Hour)
+	for i := 0; i < d.cfg.HistoryDays; i++ {
+		start := today.AddDate(0, 0, -i)
+		span := 24 * time.Hour
+		if i == 0 {
+			span = now.Sub(start)
+		}
+		for u := 0; u < d.cfg.Users; u++ {
+			if d.rng.Float64() < 0.15 {
+				continue
+			}
+			n := int(float64(d.cfg.ListensPerDay) * (0.5 + d.rng.Float64()) * span.Hours() / 24)
+			for ; n > 0; n-- {
+				d.listen(u, start.Add(time.Duration(d.rng.Int63n(int64(span)+1))))
+			}
+		}
+	}
+}
+
+// Run generates live listens and republishes the charts and listener
+// HyperLogLogs every PublishInterval until ctx is done
+func (d *demoStore) Run(ctx context.Context, rdb *redis.Client) {
+	d.publish(ctx, rdb)
+
+	var listen <-chan time.Time
+	if d.cfg.Rate > 0 {
+		t := time.NewTicker(time.Duration(float64(time.Second) / d.cfg.Rate))
+		defer t.Stop()
+		listen = t.C
+	}
+	publish := time.NewTicker(d.cfg.PublishInterval)
+	defer publish.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-listen:
+			d.listen(d.rng.Intn(d.cfg.Users), now)
+		case <-publish.C:
+			d.publish(ctx, rdb)
+		}
+	}
+}
+
+// listen records one listen of user u at t. Each user draws from the same
+// skewed popularity over their own rotation of the catalog, so users' top
+// songs differ.
+func (d *demoStore) listen(u int, t time.Time) {
+	song := (int(d.zipf.Uint64()) + u*7) % d.cfg.Songs
+	user := fmt.Sprintf("user-%d", u)
+	songID := fmt.Sprintf("song-%d", song)
+	t = t.UTC()
+	day, hour := t.Format(storage.DayFormat), t.Hour()
+	ms := int64(150+(song*37)%150) * 1000 // played whole, as loadgen's events
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.generated++
+
+	ud := userDay{user, day}
+	addTotals(d.daily, ud, songID, ms)
+	addTotals(d.hourly, userHour{user, day, hour}, songID, ms)
+	addCount(d.artists, ud, fmt.Sprintf("artist-%d", song/10), 1)
+	for _, tag := range songTags(song) {
+		addCount(d.tags, ud, tag, 1)
+	}
+	d.totals[ud]++
+
+	sd := songDay{songID, day}
+	d.listens[sd]++
+	if d.listeners[sd] == nil {
+		d.listeners[sd] = make(map[string]bool)
+	}
+	d.listeners[sd][user] = true
+	addCount(d.global, dayHour{day, hour}, songID, 1)
+}
+
+// songTags returns a song's genres (one or two) and mood
+func songTags(song int) []storage.TagKey {
+	tags := []storage.TagKey{{Kind: storage.TagGenre, Tag: demoGenres[song%len(demoGenres)]}}
+	if song%3 == 0 {
+		tags = append(tags, storage.TagKey{Kind: storage.TagGenre, Tag: demoGenres[(song+3)%len(demoGenres)]})
+	}
+	return append(tags, storage.TagKey{Kind: storage.TagMood, Tag: demoMoods[(song*7)%len(demoMoods)]})
+}
+
+func addTotals[K comparable](m map[K]map[string]storage.SongTotals, k K, songID string, ms int64) {
+	if m[k] == nil {
+		m[k] = make(map[string]storage.SongTotals)
+	}
+	t := m[k][songID]
+	t.Count++
+	t.Millis += ms
+	m[k][songID] = t
+}
+
+func addCount[K, V comparable](m map[K]map[V]int64, k K, v V, n int64) {
+	if m[k] == nil {
+		m[k] = make(map[V]int64)
+	}
+	m[k][v] += n
+}
+
+// demoChartWindows are global-charts' default windows, in hours
+var demoChartWindows = []struct {
+	name  string
+	hours int
+}{{"1h", 1}, {"24h", 24}, {"7d", 24 * 7}}
+
+// publish writes what global-charts and the aggregator keep in Redis: the
+// global charts and each song's daily listener HyperLogLog
+func (d *demoStore) publish(ctx context.Context, rdb *redis.Client) {
+	now := time.Now()
+	pipe := rdb.Pipeline()
+
+	d.mu.RLock()
+	for _, w := range demoChartWindows {
+		counts := make(map[string]int64)
+		var events int64
+		for _, span := range storage.HoursEnding(now, w.hours) {
+			for h := span.From; h <= span.To; h++ {
+				for song, n := range d.global[dayHour{span.Day, h}] {
+					counts[song] += n
+					events += n
+				}
+			}
+		}
+		chart := GlobalChart{Window: w.name, ComputedAt: now.UTC(), Events: events}
+		for i, sc := range topCounts(counts, 100) {
+			chart.Songs = append(chart.Songs, ChartSong{Rank: i + 1, SongID: sc.id, Count: sc.count})
+		}
+		data, _ := json.Marshal(chart)
+		pipe.Set(ctx, "charts:global:"+w.name, data, 0)
+	}
+	for _, day := range storage.LastDays(listenerHLLDays) {
+		for sd, users := range d.listeners {
+			if sd.day != day {
+				continue
+			}
+			members := make([]interface{}, 0, len(users))
+			for u := range users {
+				members = append(members, u)
+			}
+			pipe.PFAdd(ctx, fmt.Sprintf("hll:listeners:%s:%s", day, sd.song), members...)
+		}
+	}
+	d.mu.RUnlock()
+
+	if _, err := pipe.Exec(ctx); err != nil && ctx.Err() == nil {
+		log.Printf("Demo: publishing charts: %v", err)
+	}
+}
+
+// The store's views, one per reader the handlers use
+type (
+	demoDaily     struct{ *demoStore }
+	demoHourly    struct{ *demoStore }
+	demoArtists   struct{ *demoStore }
+	demoTags      struct{ *demoStore }
+	demoTotals    struct{ *demoStore }
+	demoSongStats struct{ *demoStore }
+
+	demoNoSnapshots struct{}
+	demoNoReviews   struct{}
+	demoNoHistory   struct{}
+	demoNoPlaylists struct{}
+)
+
+func (v demoDaily) SumCounts(ctx context.Context, userID string, days []string) (map[string]int64, error) {
+	totals, err := v.SumTotals(ctx, userID, days)
+	return countsOf(totals), err
+}
+
+func (v demoDaily) SumTotals(_ context.Context, userID string, days []string) (map[string]storage.SongTotals, error) {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	sum := make(map[string]storage.SongTotals)
+	for _, day := range days {
+		sumTotals(sum, v.daily[userDay{userID, day}])
+	}
+	return sum, nil
+}
+
+func (v demoDaily) SumCountsOf(_ context.Context, userID string, days, songIDs []string) (map[string]int64, error) {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	sum := make(map[string]int64)
+	for _, day := range days {
+		totals := v.daily[userDay{userID, day}]
+		for _, id := range songIDs {
+			if t, ok := totals[id]; ok {
+				sum[id] += t.Count
+			}
+		}
+	}
+	return sum, nil
+}
+
+func (v demoHourly) SumCounts(ctx context.Context, userID string, spans []storage.HourSpan) (map[string]int64, error) {
+	totals, err := v.SumTotals(ctx, userID, spans)
+	return countsOf(totals), err
+}
+
+func (v demoHourly) SumTotals(_ context.Context, userID string, spans []storage.HourSpan) (map[string]storage.SongTotals, error) {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	sum := make(map[string]storage.SongTotals)
+	for _, span := range spans {
+		for h := span.From; h <= span.To; h++ {
+			sumTotals(sum, v.hourly[userHour{userID, span.Day, h}])
+		}
+	}
+	return sum, nil
+}
+
+func (v demoArtists) SumCounts(_ context.Context, userID string, days []string) (map[string]int64, error) {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	sum := make(map[string]int64)
+	for _, day := range days {
+		for artist, n := range v.artists[userDay{userID, day}] {
+			sum[artist] += n
+		}
+	}
+	return sum, nil
+}
+
+func (v demoTags) SumCounts(_ context.Context, userID, kind string, days []string) (map[string]int64, error) {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	sum := make(map[string]int64)
+	for _, day := range days {
+		for tag, n := range v.tags[userDay{userID, day}] {
+			if tag.Kind == kind {
+				sum[tag.Tag] += n
+			}
+		}
+	}
+	return sum, nil
+}
+
+func (v demoTotals) Range(_ context.Context, userID, from, to string) (map[string]int64, error) {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	out := make(map[string]int64)
+	for ud, n := range v.totals {
+		if ud.user == userID && ud.day >= from && ud.day <= to {
+			out[ud.day] = n
+		}
+	}
+	return out, nil
+}
+
+func (v demoSongStats) Days(_ context.Context, songID string, days []string) ([]storage.SongDay, error) {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	out := make([]storage.SongDay, len(days))
+	for i, day := range days {
+		sd := songDay{songID, day}
+		out[i] = storage.SongDay{Day: day, Listens: v.listens[sd], Listeners: int64(len(v.listeners[sd]))}
+	}
+	return out, nil
+}
+
+func (v demoSongStats) DailyListens(_ context.Context, songID, from, to string) (map[string]int64, error) {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	out := make(map[string]int64)
+	for sd, n := range v.listens {
+		if sd.song == songID && sd.day >= from && sd.day <= to {
+			out[sd.day] = n
+		}
+	}
+	return out, nil
+}
+
+func (demoNoSnapshots) Get(context.Context, string, int) (storage.Snapshot, bool, error) {
+	return storage.Snapshot{}, false, nil
+}
+
+func (demoNoSnapshots) Scan(context.Context, int, func(storage.Snapshot) error) error {
+	return nil
+}
+
+func (demoNoSnapshots) Page(context.Context, string, int, int64, int, int) ([]storage.SongCount, error) {
+	return nil, nil
+}
+
+func (demoNoReviews) Get(context.Context, string, int) ([]byte, bool, error) {
+	return nil, false, nil
+}
+
+func (demoNoHistory) ScanDay(context.Context, string, string, func(storage.HistoryRow) error) error {
+	return nil
+}
+
+func (demoNoPlaylists) Get(context.Context, string) (storage.Playlist, bool, error) {
+	return storage.Playlist{}, false, nil
+}
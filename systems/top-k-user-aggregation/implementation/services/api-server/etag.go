@@ -0,0 +1,55 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"expvar"
+	"log"
+	"net/http"
+	"strings"
+)
+
+var metricNotModified = expvar.NewInt("not_modified") // 304s to If-None-Match
+
+// writeJSON serves body with an ETag of its contents, or a 304 without it
+// when the request's If-None-Match already names that ETag. Responses don't
+// carry when they were read ("cached" is false in the cache too), so a hit
+// and a miss of the same counts have the same ETag. With RESPONSE_SIGNING_KEY
+// the body is signed (cdn.go).
+func writeJSON(w http.ResponseWriter, r *http.Request, body []byte) {
+	sum := sha256.Sum256(body)
+	etag := `"` + hex.EncodeToString(sum[:8]) + `"`
+	w.Header().Set("ETag", etag)
+	if etagMatch(r.Header.Get("If-None-Match"), etag) {
+		metricNotModified.Add(1)
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	signResponse(w, r, body)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(body)
+}
+
+// encodeJSON serves v with writeJSON
+func encodeJSON(w http.ResponseWriter, r *http.Request, v any) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		log.Printf("Error encoding response: %v", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, r, append(body, '\n'))
+}
+
+// etagMatch reports whether an If-None-Match header names etag; weak
+// comparison, as for GET
+func etagMatch(header, etag string) bool {
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || strings.TrimPrefix(tag, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"context"
+
+	"github.com/system-design-lab/pkg/storage"
+)
+
+// readExperiment is the read path of experiment= requests: the aggregator's
+// counters of the listens tagged with the experiment
+const readExperiment = "experiment"
+
+var (
+	experiments    *storage.ExperimentSet // registered with tools experiment
+	experimentTopK experimentTopKReader
+)
+
+// experimentTopKOf sums the experiment counters over the window's days the
+// experiment ran, the only days it has counts for
+func experimentTopKOf(ctx context.Context, userID, experiment string, window []string, k int) ([]TopKResult, error) {
+	exp, _ := experiments.Get(experiment)
+	var ran []string
+	for _, day := range window {
+		if exp.Covers(day) {
+			ran = append(ran, day)
+		}
+	}
+	songCounts, err := experimentTopK.SumCounts(ctx, userID, experiment, ran)
+	if err != nil {
+		return nil, err
+	}
+	return rankTopK(withoutTakedowns(songCounts), k), nil
+}
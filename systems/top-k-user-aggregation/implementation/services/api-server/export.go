@@ -0,0 +1,290 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/system-design-lab/pkg/storage"
+)
+
+// Export formats of /users/{user_id}/export
+const (
+	exportNDJSON = "ndjson"
+	exportCSV    = "csv" // zip of one CSV per table
+)
+
+var (
+	metricExports        = expvar.NewMap("exports") // by format
+	metricExportsRefused = expvar.NewInt("exports_refused")
+	metricExportErrors   = expvar.NewInt("export_errors")
+	metricExportRows     = expvar.NewInt("export_rows")
+)
+
+// exportSlots bounds the exports running at once, on this instance: each
+// reads every day partition of a user (EXPORT_CONCURRENCY)
+var exportSlots chan struct{}
+
+// ExportDaily is one song's counts on one day, from user_daily_topk
+type ExportDaily struct {
+	Type        string `json:"type"` // "daily"
+	Day         string `json:"day"`
+	SongID      string `json:"song_id"`
+	ListenCount int64  `json:"listen_count"`
+	ListenMs    int64  `json:"listen_ms"`
+}
+
+// ExportListen is one raw event, from user_listen_history
+type ExportListen struct {
+	Type       string `json:"type"` // "listen"
+	Day        string `json:"day"`
+	ListenedAt string `json:"listened_at"` // RFC 3339, UTC
+	EventID    string `json:"event_id"`
+	SongID     string `json:"song_id"`
+	ArtistID   string `json:"artist_id,omitempty"`
+	Provider   string `json:"provider"`
+	Source     string `json:"source,omitempty"`
+	Context    string `json:"context,omitempty"`
+	DurationMs int64  `json:"duration_ms,omitempty"`
+}
+
+// ExportSummary ends a complete NDJSON export; an export cut short ends
+// with {"type":"error"} instead, or with neither if the connection broke
+type ExportSummary struct {
+	Type        string `json:"type"` // "summary" or "error"
+	UserID      string `json:"user_id"`
+	From        string `json:"from"`
+	To          string `json:"to"`
+	Days        int    `json:"days"`
+	DailyRows   int64  `json:"daily_rows"`
+	ListenRows  int64  `json:"listen_rows"`
+	Error       string `json:"error,omitempty"`
+	GeneratedAt string `json:"generated_at"`
+}
+
+var (
+	exportDailyHeader  = []string{"day", "song_id", "listen_count", "listen_ms"}
+	exportListenHeader = []string{"day", "listened_at", "event_id", "song_id", "artist_id", "provider", "source", "context", "duration_ms"}
+)
+
+// exportHandler handles GET /users/{user_id}/export?format=ndjson|csv&from=&to=,
+// streaming every day of the user's daily song counts and raw history. Days
+// come from user_daily_totals; each is read and written before the next,
+// so memory stays flat however long the history is.
+func exportHandler(w http.ResponseWriter, r *http.Request, userID string) {
+	q := r.URL.Query()
+	format := q.Get("format")
+	if format == "" {
+		format = exportNDJSON
+	}
+	if format != exportNDJSON && format != exportCSV {
+		http.Error(w, "format must be ndjson or csv", http.StatusBadRequest)
+		return
+	}
+	from, to := q.Get("from"), q.Get("to")
+	if from == "" {
+		from = "1970-01-01"
+	}
+	if to == "" {
+		to = time.Now().UTC().Format(storage.DayFormat)
+	}
+	if _, err := time.Parse(storage.DayFormat, from); err != nil {
+		http.Error(w, "from must be YYYY-MM-DD", http.StatusBadRequest)
+		return
+	}
+	if _, err := time.Parse(storage.DayFormat, to); err != nil || to < from {
+		http.Error(w, "to must be YYYY-MM-DD, not before from", http.StatusBadRequest)
+		return
+	}
+
+	select {
+	case exportSlots <- struct{}{}:
+		defer func() { <-exportSlots }()
+	default:
+		metricExportsRefused.Add(1)
+		w.Header().Set("Retry-After", "30")
+		http.Error(w, "too many exports in progress", http.StatusTooManyRequests)
+		return
+	}
+
+	ctx := r.Context()
+	totals, err := userTotals.Range(ctx, userID, from, to)
+	if err != nil {
+		log.Printf("Error listing export days: %v", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	days := make([]string, 0, len(totals))
+	for day, n := range totals {
+		if n > 0 {
+			days = append(days, day)
+		}
+	}
+	sort.Strings(days)
+
+	metricExports.Add(format, 1)
+	summary := ExportSummary{UserID: userID, From: from, To: to, Days: len(days)}
+	name := fmt.Sprintf("%s-export.%s", userID, format)
+	if format == exportCSV {
+		name = fmt.Sprintf("%s-export.zip", userID)
+	}
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+
+	if format == exportNDJSON {
+		err = exportNDJSONTo(ctx, w, userID, days, &summary)
+	} else {
+		err = exportZipTo(ctx, w, userID, days, &summary)
+	}
+	metricExportRows.Add(summary.DailyRows + summary.ListenRows)
+	if err != nil {
+		// The status is long sent: NDJSON ends with an error record, a zip
+		// without its directory
+		log.Printf("Export of %s cut short after %d rows: %v", userID, summary.DailyRows+summary.ListenRows, err)
+		metricExportErrors.Add(1)
+	}
+}
+
+// exportNDJSONTo writes each day's counts then its listens, one JSON object
+// per line, and a summary last. The response is flushed after every day.
+func exportNDJSONTo(ctx context.Context, w http.ResponseWriter, userID string, days []string, summary *ExportSummary) error {
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	enc := json.NewEncoder(w)
+	flusher, _ := w.(http.Flusher)
+
+	err := exportDays(ctx, userID, days, func(d ExportDaily) error {
+		summary.DailyRows++
+		return enc.Encode(d)
+	}, func(l ExportListen) error {
+		summary.ListenRows++
+		return enc.Encode(l)
+	}, func() {
+		if flusher != nil {
+			flusher.Flush()
+		}
+	})
+	summary.Type = "summary"
+	if err != nil {
+		summary.Type, summary.Error = "error", err.Error()
+	}
+	summary.GeneratedAt = time.Now().UTC().Format(time.RFC3339)
+	if encErr := enc.Encode(summary); err == nil {
+		err = encErr
+	}
+	return err
+}
+
+// exportZipTo writes a zip of daily_topk.csv and listen_history.csv. An
+// entry is written whole before the next, so the days are read twice:
+// counts first, then history.
+func exportZipTo(ctx context.Context, w http.ResponseWriter, userID string, days []string, summary *ExportSummary) error {
+	w.Header().Set("Content-Type", "application/zip")
+	zw := zip.NewWriter(w)
+	flusher, _ := w.(http.Flusher)
+	flush := func(cw *csv.Writer) func() {
+		return func() {
+			cw.Flush()
+			zw.Flush()
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+	}
+
+	daily, err := newCSVEntry(zw, "daily_topk.csv", exportDailyHeader)
+	if err != nil {
+		return err
+	}
+	err = exportDays(ctx, userID, days, func(d ExportDaily) error {
+		summary.DailyRows++
+		return daily.Write([]string{d.Day, d.SongID, strconv.FormatInt(d.ListenCount, 10), strconv.FormatInt(d.ListenMs, 10)})
+	}, nil, flush(daily))
+	if err == nil {
+		err = daily.Error()
+	}
+	if err != nil {
+		return err
+	}
+
+	history, err := newCSVEntry(zw, "listen_history.csv", exportListenHeader)
+	if err != nil {
+		return err
+	}
+	err = exportDays(ctx, userID, days, nil, func(l ExportListen) error {
+		summary.ListenRows++
+		return history.Write([]string{l.Day, l.ListenedAt, l.EventID, l.SongID, l.ArtistID, l.Provider, l.Source, l.Context, strconv.FormatInt(l.DurationMs, 10)})
+	}, flush(history))
+	if err == nil {
+		err = history.Error()
+	}
+	if err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+func newCSVEntry(zw *zip.Writer, name string, header []string) (*csv.Writer, error) {
+	f, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: time.Now()})
+	if err != nil {
+		return nil, err
+	}
+	cw := csv.NewWriter(f)
+	return cw, cw.Write(header)
+}
+
+// exportDays reads the days in order and passes their rows on: a day's
+// counts (songs by ID) to daily, then its history (newest first, paged) to
+// listen. A nil func skips that table. done is called after each day.
+func exportDays(ctx context.Context, userID string, days []string, daily func(ExportDaily) error, listen func(ExportListen) error, done func()) error {
+	for _, day := range days {
+		if daily != nil {
+			totals, err := dailyTopK.SumTotals(ctx, userID, []string{day})
+			if err != nil {
+				return fmt.Errorf("daily counts of %s: %w", day, err)
+			}
+			totals = withoutTakedowns(totals)
+			songs := make([]string, 0, len(totals))
+			for song := range totals {
+				songs = append(songs, song)
+			}
+			sort.Strings(songs)
+			for _, song := range songs {
+				t := totals[song]
+				if err := daily(ExportDaily{Type: "daily", Day: day, SongID: song, ListenCount: t.Count, ListenMs: t.Millis}); err != nil {
+					return err
+				}
+			}
+		}
+		if listen != nil {
+			err := listenHistory.ScanDay(ctx, userID, day, func(row storage.HistoryRow) error {
+				return listen(ExportListen{
+					Type:       "listen",
+					Day:        day,
+					ListenedAt: row.Time().UTC().Format(time.RFC3339),
+					EventID:    row.EventID,
+					SongID:     row.SongID,
+					ArtistID:   row.ArtistID,
+					Provider:   row.Provider,
+					Source:     row.Source,
+					Context:    row.Context,
+					DurationMs: row.DurationMs,
+				})
+			})
+			if err != nil {
+				return fmt.Errorf("history of %s: %w", day, err)
+			}
+		}
+		done()
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
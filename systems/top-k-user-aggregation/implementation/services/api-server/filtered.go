@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/system-design-lab/pkg/storage"
+)
+
+// Subsets of /users/{user_id}/topk:filtered
+const (
+	maxFilterSongs = 1000 // songs in one request or playlist
+	// Subsets up to this size are read by song_id, one query per day; larger
+	// ones read the window's days whole, as /topk does, and filter
+	maxTargetedSongs = 100
+)
+
+// Read paths of filtered top-Ks
+const (
+	readTargeted = "targeted"
+	readWindow   = "window"
+)
+
+var (
+	filteredTopK filteredTopKReader
+	playlists    playlistReader // loaded with tools metadata playlists
+
+	metricFilteredReads = expvar.NewMap("filtered_topk_reads") // by read path
+)
+
+// FilterRequest is the body of /users/{user_id}/topk:filtered: the songs to
+// rank, listed or as a playlist
+type FilterRequest struct {
+	SongIDs    []string `json:"song_ids,omitempty"`
+	PlaylistID string   `json:"playlist_id,omitempty"`
+}
+
+// FilteredTopKResponse is the API response of /users/{user_id}/topk:filtered
+type FilteredTopKResponse struct {
+	UserID     string       `json:"user_id"`
+	Days       int          `json:"days"`
+	K          int          `json:"k"`
+	PlaylistID string       `json:"playlist_id,omitempty"`
+	Songs      int          `json:"songs"` // in the subset, played or not
+	MinCount   int          `json:"min_count,omitempty"`
+	Results    []TopKResult `json:"results"`
+	Cached     bool         `json:"cached"`
+}
+
+// filteredTopKHandler handles POST /users/{user_id}/topk:filtered?days=7&k=10,
+// the user's ranking of the songs in the body. Songs the user didn't play in
+// the window aren't listed.
+func filteredTopKHandler(w http.ResponseWriter, r *http.Request, userID string) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if hideDeleted(w, userID) {
+		return
+	}
+	days := getQueryInt(r, "days", 7)
+	k := getQueryInt(r, "k", 10)
+	if days < 1 || days > 30 {
+		http.Error(w, "days must be 1-30", http.StatusBadRequest)
+		return
+	}
+	if k < 1 || k > 100 {
+		http.Error(w, "k must be 1-100", http.StatusBadRequest)
+		return
+	}
+	minListens, err := parseMinCount(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var req FilterRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&req); err != nil {
+		http.Error(w, "invalid body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if (len(req.SongIDs) > 0) == (req.PlaylistID != "") {
+		http.Error(w, "body needs song_ids or playlist_id, not both", http.StatusBadRequest)
+		return
+	}
+
+	songIDs := req.SongIDs
+	if req.PlaylistID != "" {
+		p, ok, err := playlists.Get(r.Context(), req.PlaylistID)
+		if err != nil {
+			log.Printf("Error reading playlist %s: %v", req.PlaylistID, err)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		if !ok {
+			http.Error(w, "unknown playlist", http.StatusNotFound)
+			return
+		}
+		songIDs = p.SongIDs
+	}
+	songIDs = songSet(songIDs)
+	if len(songIDs) > maxFilterSongs {
+		http.Error(w, fmt.Sprintf("at most %d songs", maxFilterSongs), http.StatusBadRequest)
+		return
+	}
+
+	kb := bucketK(k)
+	cacheKey := fmt.Sprintf("%stopk-filtered:%s:%s:%s:%d", songsKeyPrefix(), userID, windowKey(days), subsetKey(songIDs), kb)
+	if minListens > 1 {
+		cacheKey += fmt.Sprintf(":min=%d", minListens)
+	}
+	serveCached(w, r, cacheKey, k, filteredCost(days, len(songIDs)), func(ctx context.Context) (*FilteredTopKResponse, error) {
+		counts, err := subsetCounts(ctx, userID, storage.LastDays(days), songIDs)
+		if err != nil {
+			return nil, fmt.Errorf("filtered topk: %w", err)
+		}
+		return &FilteredTopKResponse{
+			UserID:     userID,
+			Days:       days,
+			K:          kb,
+			PlaylistID: req.PlaylistID,
+			Songs:      len(songIDs),
+			MinCount:   minCountOf(minListens),
+			Results:    atLeast(rankTopK(withoutTakedowns(counts), kb), minListens, kb),
+		}, nil
+	})
+}
+
+// subsetCounts sums a user's counts of songIDs over days, by song_id for
+// small subsets and from the whole days (shared with /topk reads) otherwise
+func subsetCounts(ctx context.Context, userID string, days, songIDs []string) (map[string]int64, error) {
+	if len(songIDs) == 0 {
+		return map[string]int64{}, nil
+	}
+	if len(songIDs) <= maxTargetedSongs {
+		metricFilteredReads.Add(readTargeted, 1)
+		return filteredTopK.SumCountsOf(ctx, userID, days, songIDs)
+	}
+	metricFilteredReads.Add(readWindow, 1)
+	all, err := dailyTopK.SumCounts(ctx, userID, days)
+	if err != nil {
+		return nil, err
+	}
+	counts := make(map[string]int64, len(songIDs))
+	for _, id := range songIDs {
+		if c, ok := all[id]; ok {
+			counts[id] = c
+		}
+	}
+	return counts, nil
+}
+
+// filteredCost is the query cost of a subset: a targeted read touches at
+// most one row per song and day
+func filteredCost(days, songs int) queryCost {
+	cost := dayCost(days)
+	if songs <= maxTargetedSongs && int64(songs) < cost.RowsPerUnit {
+		cost.RowsPerUnit = int64(songs)
+		cost.Rows = int64(days) * cost.RowsPerUnit
+	}
+	return cost
+}
+
+// songSet returns the distinct non-empty song IDs, sorted
+func songSet(songIDs []string) []string {
+	seen := make(map[string]bool, len(songIDs))
+	var out []string
+	for _, id := range songIDs {
+		if id = strings.TrimSpace(id); id != "" && !seen[id] {
+			seen[id] = true
+			out = append(out, id)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// subsetKey names a sorted song set in cache keys: a playlist's entry
+// changes with its songs
+func subsetKey(songIDs []string) string {
+	sum := sha256.Sum256([]byte(strings.Join(songIDs, "\n")))
+	return fmt.Sprintf("%d-%s", len(songIDs), hex.EncodeToString(sum[:8]))
+}
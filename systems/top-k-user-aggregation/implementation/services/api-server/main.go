@@ -3,6 +3,9 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"expvar"
+	"flag"
 	"fmt"
 	"log"
 	"net/http"
@@ -12,68 +15,401 @@ import (
 	"strings"
 	"time"
 
-	"github.com/gocql/gocql"
 	"github.com/redis/go-redis/v9"
+	"github.com/system-design-lab/pkg/chaos"
+	"github.com/system-design-lab/pkg/dc"
+	topkv1 "github.com/system-design-lab/pkg/gen/topk/v1"
+	"github.com/system-design-lab/pkg/grpcutil"
+	"github.com/system-design-lab/pkg/kafkautil"
+	"github.com/system-design-lab/pkg/ratelimit"
+	"github.com/system-design-lab/pkg/redisutil"
+	"github.com/system-design-lab/pkg/runtimecfg"
+	"github.com/system-design-lab/pkg/sqlstore"
+	"github.com/system-design-lab/pkg/startup"
+	"github.com/system-design-lab/pkg/storage"
+	"github.com/system-design-lab/pkg/topology"
+	"github.com/system-design-lab/pkg/userstate"
 )
 
 // TopKResult is a single song in the Top-K response
 type TopKResult struct {
-	SongID      string `json:"song_id"`
-	ListenCount int64  `json:"listen_count"`
-	Rank        int    `json:"rank"`
+	SongID      string  `json:"song_id"`
+	ListenCount int64   `json:"listen_count"`
+	ListenMs    int64   `json:"listen_ms,omitempty"` // rank_by=time only
+	Score       float64 `json:"score,omitempty"`     // rank_by=recent only
+	Rank        int     `json:"rank"`
 }
 
 // TopKResponse is the API response
 type TopKResponse struct {
 	UserID  string       `json:"user_id"`
-	Days    int          `json:"days"`
+	Days    int          `json:"days,omitempty"`
+	Hours   int          `json:"hours,omitempty"`
 	K       int          `json:"k"`
+	RankBy  string       `json:"rank_by"`
 	Results []TopKResult `json:"results"`
 	Cached  bool         `json:"cached"`
+	// experiment= only: the counts are of the listens tagged with it
+	Experiment string `json:"experiment,omitempty"`
+	// as_of= only: the window's last day, in the past
+	AsOf string `json:"as_of,omitempty"`
+	// rank_by=recent only: days after which a listen counts half
+	HalfLife int `json:"half_life,omitempty"`
+	// Least listens a listed song has, when over 1 (min_count)
+	MinCount int `json:"min_count,omitempty"`
+	// max_wait_ms only: some days weren't read in time and aren't counted
+	Partial     bool     `json:"partial,omitempty"`
+	MissingDays []string `json:"missing_days,omitempty"`
+	// Paged reads (cursor=) only: the ranked list's length, and the cursor
+	// of the next page unless this is the last
+	Total      int    `json:"total,omitempty"`
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// ArtistResult is a single artist in the artist Top-K response
+type ArtistResult struct {
+	ArtistID    string `json:"artist_id"`
+	ListenCount int64  `json:"listen_count"`
+	Rank        int    `json:"rank"`
+}
+
+// ArtistTopKResponse is the API response of /users/{user_id}/topk/artists
+type ArtistTopKResponse struct {
+	UserID  string         `json:"user_id"`
+	Days    int            `json:"days"`
+	K       int            `json:"k"`
+	Results []ArtistResult `json:"results"`
+	Cached  bool           `json:"cached"`
+}
+
+// TagResult is a single genre or mood in the tag Top-K response
+type TagResult struct {
+	Tag         string `json:"tag"`
+	ListenCount int64  `json:"listen_count"`
+	Rank        int    `json:"rank"`
 }
 
+// TagTopKResponse is the API response of /users/{user_id}/topk/genres and
+// /topk/moods
+type TagTopKResponse struct {
+	UserID  string      `json:"user_id"`
+	Kind    string      `json:"kind"` // genre or mood
+	Days    int         `json:"days"`
+	K       int         `json:"k"`
+	Results []TagResult `json:"results"`
+	Cached  bool        `json:"cached"`
+}
+
+// Cache metrics, served as JSON on /debug/vars
 var (
-	cassandraSession *gocql.Session
-	redisClient      *redis.Client
-	cacheTTL         time.Duration
+	metricCacheHits   = expvar.NewInt("cache_hits")
+	metricCacheMisses = expvar.NewInt("cache_misses")
+	metricCacheErrors = expvar.NewInt("cache_errors") // Redis failures, served as misses
+
+	metricCacheKeys        = expvar.NewInt("cache_keys")           // indexed response keys
+	metricCacheBytes       = expvar.NewInt("cache_bytes_estimate") // sampled average size × keys
+	metricCacheEvictions   = expvar.NewInt("cache_evictions")      // LRU keys dropped over CACHE_MAX_KEYS
+	metricCacheBudget      = expvar.NewInt("cache_max_keys")
+	metricCacheRedisMemory = expvar.NewInt("cache_redis_used_memory") // used_memory of the cache's Redis
+
+	// The in-process tier (CACHE_BACKEND=local or tiered); local hits of the
+	// tiered cache are also counted in cache_hits
+	metricCacheLocalHits      = expvar.NewInt("cache_local_hits")
+	metricCacheLocalKeys      = expvar.NewInt("cache_local_keys")
+	metricCacheLocalBytes     = expvar.NewInt("cache_local_bytes")
+	metricCacheLocalEvictions = expvar.NewInt("cache_local_evictions")
+
+	metricSnapshotHits     = expvar.NewInt("snapshot_hits")
+	metricSnapshotFallback = expvar.NewMap("snapshot_fallbacks") // by reason
+)
+
+const (
+	readCompute  = "compute"  // sum daily counters per request
+	readSnapshot = "snapshot" // read user_topk_snapshot, compute when unusable
+	readSliding  = "sliding"  // sum hourly counters; hours= requests only
+	readRanked   = "ranked"   // page through user_topk_ranked; cursor= requests only
+	readAsOf     = "as_of"    // sum a past window's daily counters; as_of= requests only
+)
+
+// rank_by values of /users/{user_id}/topk
+const (
+	rankByCount = "count"
+	rankByTime  = "time" // listen_ms counters, from events with a duration_ms
+	// Counts decayed by age, from the daily counters; see recentTopK
+	rankByRecent = "recent"
+)
+
+var (
+	dailyTopK     dailyTopKReader
+	artistTopK    artistTopKReader
+	tagTopK       tagTopKReader
+	yearReviews   yearReviewReader
+	userTotals    userTotalsReader
+	hourlyTopK    hourlyTopKReader
+	snapshots     snapshotReader
+	rankedLists   rankedReader
+	songStats     songStatsReader
+	listenHistory historyReader
+	redisClient   *redis.Client
+	cache         Cache
+	cacheTTL      time.Duration
+	cachePrefix   string
+	readMode      string
+	settings      *runtimecfg.Config // cache_ttl, min_count, rate_limit, rate_limit_burst
 )
 
 func main() {
+	demoMode := flag.Bool("demo", false, "run without Kafka, Cassandra or Redis, on synthetic listens kept in memory")
+	flag.Parse()
+	if _, err := topology.Apply("api-server"); err != nil {
+		log.Fatalf("Invalid pipeline config: %v", err)
+	}
+
 	cassandraHosts := getEnv("CASSANDRA_HOSTS", "localhost:9042")
 	redisAddr := getEnv("REDIS_ADDR", "localhost:6379")
 	port := getEnv("PORT", "8080")
+	grpcAddr := getEnv("GRPC_ADDR", ":9080")
 	cacheTTL = getEnvDuration("CACHE_TTL", 1*time.Hour)
+	minCount = getEnvInt("MIN_COUNT", minCount)
+	cacheAddr := getEnv("CACHE_REDIS_ADDR", redisAddr)
+	cacheDB := getEnvInt("CACHE_REDIS_DB", 0)
+	cacheMaxKeys := getEnvInt("CACHE_MAX_KEYS", 100000)
+	cacheStatsInterval := getEnvDuration("CACHE_STATS_INTERVAL", 30*time.Second)
+	cacheBackend := getEnv("CACHE_BACKEND", cacheRedis)
+	cacheLocalMB := getEnvInt("CACHE_LOCAL_MAX_MB", 64)
+	cacheLocalTTL := getEnvDuration("CACHE_LOCAL_TTL", 10*time.Second)
+	// Counts differ between DCs until replication catches up, so a Redis
+	// shared across DCs keeps one cache per DC
+	cachePrefix = getEnv("CACHE_KEY_PREFIX", dc.Prefix())
+	readMode = getEnv("READ_MODE", readCompute)
+	rateLimit := getEnvInt("RATE_LIMIT", 0)
+	rateLimitBurst := getEnvInt("RATE_LIMIT_BURST", 0)
+	rateLimitAlgorithm := getEnv("RATE_LIMIT_ALGORITHM", ratelimit.TokenBucket)
+	rateLimitBackend := getEnv("RATE_LIMIT_BACKEND", ratelimit.Redis)
+	exportSlots = make(chan struct{}, getEnvInt("EXPORT_CONCURRENCY", 4))
+	costBudget := getEnvInt("QUERY_COST_BUDGET", 200000)
+	rowsPerDay = int64(getEnvInt("QUERY_ROWS_PER_DAY", int(rowsPerDay)))
+	rowsPerHour = int64(getEnvInt("QUERY_ROWS_PER_HOUR", int(rowsPerHour)))
+	if costBudget > 0 {
+		budget = newQueryBudget(int64(costBudget), int64(getEnvInt("QUERY_CLIENT_BUDGET", costBudget/4)),
+			getEnvDuration("QUERY_QUEUE_TIMEOUT", 2*time.Second), getEnvInt("QUERY_MAX_QUEUED", 100))
+	}
+	if readMode != readCompute && readMode != readSnapshot {
+		log.Fatalf("Invalid READ_MODE %q (want compute or snapshot)", readMode)
+	}
+	if minCount < 1 {
+		log.Fatalf("Invalid MIN_COUNT %d (want >= 1)", minCount)
+	}
+	cdnCache = getEnv("CDN_CACHE", "false") == "true"
+	cdnMaxAge = getEnvDuration("CDN_MAX_AGE", cdnMaxAge)
+	settleDays = getEnvInt("SETTLE_DAYS", settleDays)
+	if settleDays < 1 {
+		log.Fatalf("Invalid SETTLE_DAYS %d (want >= 1)", settleDays)
+	}
+	if cdnCache {
+		log.Printf("CDN caching: windows settled for %d days for a year, others up to %s", settleDays, cdnMaxAge)
+	}
+	if key := os.Getenv("RESPONSE_SIGNING_KEY"); key != "" {
+		signingKey = []byte(key)
+		log.Println("Signing responses (X-TopK-Signature)")
+	}
+
+	// -demo: in-memory counters and an embedded Redis (demo.go). Cached
+	// responses expire sooner, so the generator's new listens show up.
+	var demo *demoStore
+	if *demoMode {
+		demoCfg, err := demoConfigFromEnv()
+		if err != nil {
+			log.Fatalf("Invalid demo config: %v", err)
+		}
+		if demo, redisAddr, err = startDemo(demoCfg); err != nil {
+			log.Fatalf("Failed to start demo: %v", err)
+		}
+		cassandraHosts = "none (demo)"
+		cacheAddr, cacheDB = redisAddr, 0
+		if os.Getenv("CACHE_TTL") == "" {
+			cacheTTL = 10 * time.Second
+		}
+	}
 
-	log.Printf("Starting api-server: cassandra=%s redis=%s port=%s cacheTTL=%s",
-		cassandraHosts, redisAddr, port, cacheTTL)
+	// STORAGE_BACKEND=postgres or sqlite: read from a SQL database instead
+	// of Cassandra (sqlbackend.go)
+	storageCfg, err := sqlstore.ConfigFromEnv()
+	if err != nil {
+		log.Fatalf("Invalid storage config: %v", err)
+	}
+	if storageCfg.SQL() && demo == nil {
+		cassandraHosts = "none (" + storageCfg.String() + ")"
+	}
+
+	log.Printf("Starting api-server: cassandra=%s redis=%s port=%s cacheTTL=%s read=%s dc=%q",
+		cassandraHosts, redisAddr, port, cacheTTL, readMode, dc.Local())
 
 	// Connect to Cassandra
-	cluster := gocql.NewCluster(strings.Split(cassandraHosts, ",")...)
-	cluster.Keyspace = "topk"
-	cluster.Consistency = gocql.LocalOne
-	cluster.Timeout = 10 * time.Second
+	if demo != nil {
+		demo.install()
+	} else if storageCfg.SQL() {
+		db, err := startup.SQL(context.Background(), storageCfg)
+		if err != nil {
+			log.Fatalf("Failed to connect to %s: %v", storageCfg.Backend, err)
+		}
+		defer db.Close()
+		installSQL(db)
+		log.Printf("Connected to %s", storageCfg)
+	} else {
+		session, err := startup.Cassandra(context.Background())
+		if err != nil {
+			log.Fatalf("Failed to connect to Cassandra: %v", err)
+		}
+		defer session.Close()
+		dailyTopK = coalescedDailyTopK{storage.NewDailyTopKRepo(session)}
+		artistTopK = coalescedArtistTopK{storage.NewDailyArtistTopKRepo(session)}
+		tagTopK = coalescedTagTopK{storage.NewTagTopKRepo(session)}
+		yearReviews = storage.NewYearReviewRepo(session)
+		userTotals = storage.NewUserTotalsRepo(session)
+		hourlyTopK = coalescedHourlyTopK{storage.NewHourlyTopKRepo(session)}
+		snapshots = storage.NewSnapshotRepo(session)
+		rankedLists = storage.NewRankedRepo(session)
+		songStats = storage.NewSongStatsRepo(session)
+		listenHistory = storage.NewListenHistoryRepo(session)
+		takedowns = storage.NewTakedownSet(storage.NewTakedownRepo(session))
+		experimentTopK = storage.NewExperimentTopKRepo(session)
+		experiments = storage.NewExperimentSet(storage.NewExperimentRepo(session))
+		filteredTopK = storage.NewDailyTopKRepo(session)
+		playlists = storage.NewPlaylistRepo(session)
+		log.Println("Connected to Cassandra")
 
-	var err error
-	cassandraSession, err = cluster.CreateSession()
-	if err != nil {
-		log.Fatalf("Failed to connect to Cassandra: %v", err)
+		kafkaCfg, err := kafkautil.ConfigFromEnv("localhost:29092")
+		if err != nil {
+			log.Fatalf("Invalid Kafka config: %v", err)
+		}
+		recomputes = &recomputer{
+			topk:    storage.NewDailyTopKRepo(session),
+			totals:  storage.NewUserTotalsRepo(session),
+			history: storage.NewListenHistoryRepo(session),
+			writer:  kafkaCfg.NewWriter(getEnv("RECOMPUTE_TOPIC", "user.listen.raw"), kafkautil.WriterConfigFromEnv()),
+			token:   os.Getenv("ADMIN_TOKEN"),
+			maxDays: getEnvInt("RECOMPUTE_MAX_DAYS", 7),
+			timeout: getEnvDuration("RECOMPUTE_TIMEOUT", 30*time.Minute),
+		}
+		defer recomputes.writer.Close()
 	}
-	defer cassandraSession.Close()
-	log.Println("Connected to Cassandra")
 
 	// Connect to Redis
-	redisClient = redis.NewClient(&redis.Options{
-		Addr: redisAddr,
-	})
+	redisCfg, err := redisutil.ConfigFromEnv(redisAddr, 0)
+	if err != nil {
+		log.Fatalf("Invalid Redis config: %v", err)
+	}
+	redisClient = redisutil.NewClient(redisCfg, "default")
 	ctx := context.Background()
-	if err := redisClient.Ping(ctx).Err(); err != nil {
+	if err := startup.Redis(ctx, redisClient); err != nil {
 		log.Fatalf("Failed to connect to Redis: %v", err)
 	}
-	log.Println("Connected to Redis")
+	log.Printf("Connected to Redis: %s", redisCfg)
+	if chaos.Enabled() {
+		redisClient.AddHook(chaos.RedisHook{})
+	}
+	if recomputes != nil {
+		recomputes.rdb = redisClient
+	}
+	settings = runtimecfg.New(redisClient, "api-server")
+	if err := settings.Start(ctx); err != nil {
+		log.Printf("Warning: runtime config not loaded, using env values until Redis answers: %v", err)
+	}
+	if err := takedowns.Start(ctx); err != nil {
+		log.Printf("Warning: failed to load song takedowns, retrying every %s: %v", storage.TakedownRefresh, err)
+	}
+	if err := experiments.Start(ctx); err != nil {
+		log.Printf("Warning: failed to load experiments, retrying every %s: %v", storage.ExperimentRefresh, err)
+	}
+	deletedUsers = userstate.NewSet(redisClient)
+	if err := deletedUsers.Start(ctx); err != nil {
+		log.Printf("Warning: failed to load deleted users, retrying every %s: %v", userstate.Refresh, err)
+	}
+
+	// Response cache: in Redis, in process, or both (CACHE_BACKEND). Its
+	// Redis is by default the same one, optionally another DB or instance so
+	// cache pressure stays away from the bloom filters.
+	var shared *redisCache
+	if cacheBackend == cacheRedis || cacheBackend == cacheTiered {
+		cacheClient := redisClient
+		if cacheAddr != redisAddr || cacheDB != 0 {
+			cacheCfg := redisCfg
+			cacheCfg.Addr, cacheCfg.DB = cacheAddr, cacheDB
+			cacheClient = redisutil.NewClient(cacheCfg, "cache")
+			if err := startup.Redis(ctx, cacheClient); err != nil {
+				log.Fatalf("Failed to connect to cache Redis: %v", err)
+			}
+			if chaos.Enabled() {
+				cacheClient.AddHook(chaos.RedisHook{})
+			}
+			log.Printf("Response cache on %s db %d", cacheAddr, cacheDB)
+		}
+		shared = newRedisCache(cacheClient, cachePrefix, int64(cacheMaxKeys))
+	}
+	cache, err = newCache(cacheBackend, shared, int64(cacheLocalMB)<<20, cacheLocalTTL)
+	if err != nil {
+		log.Fatalf("Invalid cache config: %v", err)
+	}
+	switch cacheBackend {
+	case cacheLocal:
+		log.Printf("Response cache in process (%dMB), not shared between instances", cacheLocalMB)
+	case cacheTiered:
+		log.Printf("Response cache in process (%dMB) in front of Redis, local copies live %s", cacheLocalMB, cacheLocalTTL)
+	}
+	if demo != nil {
+		go demo.Run(ctx, redisClient)
+	}
+	go cache.Monitor(ctx, cacheStatsInterval, func() time.Duration {
+		return settings.Duration("cache_ttl", cacheTTL)
+	})
+
+	// Per-client rate limit on the API routes, shared by every instance
+	// through Redis by default; health checks aren't limited. rate_limit and
+	// rate_limit_burst change it at runtime, 0 lifting it.
+	limiter := ratelimit.NewSwitch(redisClient, "api-server")
+	setRateLimit := func() error {
+		r := settings.Int("rate_limit", rateLimit)
+		changed, err := limiter.Configure(ratelimit.Config{
+			Algorithm: rateLimitAlgorithm,
+			Backend:   rateLimitBackend,
+			Rate:      float64(r),
+			Burst:     settings.Int("rate_limit_burst", rateLimitBurst),
+		})
+		if changed && r > 0 {
+			log.Printf("Rate limit: %d/s per client (%s, %s)", r, rateLimitAlgorithm, rateLimitBackend)
+		}
+		return err
+	}
+	if err := setRateLimit(); err != nil {
+		log.Fatalf("Invalid rate limit: %v", err)
+	}
+	settings.OnChange(func() {
+		if err := setRateLimit(); err != nil {
+			log.Printf("Warning: invalid rate limit, keeping the last one: %v", err)
+		}
+	})
+	limit := func(h http.HandlerFunc) http.Handler {
+		return ratelimit.Middleware(limiter, ratelimit.ClientIP, h)
+	}
 
 	// Routes
 	http.HandleFunc("/healthz", healthzHandler)
-	http.HandleFunc("/users/", topKHandler)
+	http.Handle("/users/", limit(topKHandler))
+	http.Handle("/charts/", limit(chartsHandler))
+	http.Handle("/songs/", limit(songsHandler))
+	http.HandleFunc("/admin/", adminHandler)
+
+	// Snapshots for the other services (proto/topk/v1/snapshot.proto)
+	if grpcAddr != "off" {
+		grpcSrv := grpcutil.NewServer()
+		topkv1.RegisterSnapshotReaderServer(grpcSrv, snapshotServer{})
+		go func() {
+			if err := grpcutil.Serve(context.Background(), grpcSrv, grpcAddr); err != nil {
+				log.Fatalf("gRPC server error: %v", err)
+			}
+		}()
+	}
 
 	log.Printf("Listening on :%s", port)
 	if err := http.ListenAndServe(":"+port, nil); err != nil {
@@ -86,27 +422,76 @@ func healthzHandler(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte("ok"))
 }
 
-// topKHandler handles GET /users/{user_id}/topk?days=7&k=10
+// topKHandler handles GET /users/{user_id}/topk?days=7&k=10, or
+// ?hours=168&k=10 for a sliding window
 func topKHandler(w http.ResponseWriter, r *http.Request) {
+	if userID, ok := strings.CutSuffix(strings.TrimPrefix(r.URL.Path, "/users/"), "/topk:filtered"); ok && !strings.Contains(userID, "/") {
+		filteredTopKHandler(w, r, userID)
+		return
+	}
 	if r.Method != http.MethodGet {
 		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	// Parse path: /users/{user_id}/topk
+	// Parse path: /users/{user_id}/topk or /users/{user_id}/topk/{artists,genres,moods}
 	path := strings.TrimPrefix(r.URL.Path, "/users/")
 	parts := strings.Split(path, "/")
+	if hideDeleted(w, parts[0]) {
+		return
+	}
+	if len(parts) == 2 && parts[1] == "year-review" {
+		yearReviewHandler(w, r, parts[0])
+		return
+	}
+	if len(parts) == 2 && parts[1] == "activity" {
+		activityHandler(w, r, parts[0])
+		return
+	}
+	if len(parts) == 2 && parts[1] == "export" {
+		exportHandler(w, r, parts[0])
+		return
+	}
+	if len(parts) == 3 && parts[1] == "topk" {
+		switch parts[2] {
+		case "artists":
+			artistTopKHandler(w, r, parts[0])
+			return
+		case "genres":
+			tagTopKHandler(w, r, parts[0], storage.TagGenre)
+			return
+		case "moods":
+			tagTopKHandler(w, r, parts[0], storage.TagMood)
+			return
+		}
+	}
 	if len(parts) != 2 || parts[1] != "topk" {
-		http.Error(w, "invalid path, expected /users/{user_id}/topk", http.StatusBadRequest)
+		http.Error(w, "invalid path, expected /users/{user_id}/topk[/artists|/genres|/moods|:filtered] or /users/{user_id}/{year-review,activity,export}", http.StatusBadRequest)
 		return
 	}
 	userID := parts[0]
 
 	// Parse query params
 	days := getQueryInt(r, "days", 7)
+	hours := getQueryInt(r, "hours", 0)
 	k := getQueryInt(r, "k", 10)
+	rankBy := r.URL.Query().Get("rank_by")
+	if rankBy == "" {
+		rankBy = rankByCount
+	}
+	halfLife := getQueryInt(r, "half_life", 7)
 
-	if days < 1 || days > 30 {
+	if r.URL.Query().Has("hours") {
+		if r.URL.Query().Has("days") {
+			http.Error(w, "use days or hours, not both", http.StatusBadRequest)
+			return
+		}
+		if hours < 1 || hours > 720 {
+			http.Error(w, "hours must be 1-720", http.StatusBadRequest)
+			return
+		}
+		days = 0
+	} else if days < 1 || days > 30 {
 		http.Error(w, "days must be 1-30", http.StatusBadRequest)
 		return
 	}
@@ -114,109 +499,793 @@ func topKHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "k must be 1-100", http.StatusBadRequest)
 		return
 	}
+	if rankBy != rankByCount && rankBy != rankByTime && rankBy != rankByRecent {
+		http.Error(w, "rank_by must be count, time or recent", http.StatusBadRequest)
+		return
+	}
+	if rankBy == rankByRecent {
+		if hours > 0 {
+			http.Error(w, "rank_by=recent ranks days windows only", http.StatusBadRequest)
+			return
+		}
+		if halfLife < 1 || halfLife > 30 {
+			http.Error(w, "half_life must be 1-30", http.StatusBadRequest)
+			return
+		}
+	} else if r.URL.Query().Has("half_life") {
+		http.Error(w, "half_life needs rank_by=recent", http.StatusBadRequest)
+		return
+	}
+	experiment := r.URL.Query().Get("experiment")
+	if experiment != "" {
+		if hours > 0 || rankBy != rankByCount || r.URL.Query().Has("cursor") {
+			http.Error(w, "experiment filters days windows ranked by count only", http.StatusBadRequest)
+			return
+		}
+		if _, ok := experiments.Get(experiment); !ok {
+			http.Error(w, "unknown experiment", http.StatusNotFound)
+			return
+		}
+	}
+	asOf, err := parseAsOf(r.URL.Query().Get("as_of"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	minListens, err := parseMinCount(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	maxWait, err := parseMaxWait(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if maxWait > 0 && (hours > 0 || rankBy == rankByRecent || experiment != "" || r.URL.Query().Has("cursor")) {
+		http.Error(w, "max_wait_ms bounds days windows ranked by count or time, without experiment or cursor", http.StatusBadRequest)
+		return
+	}
+	if asOf != "" && (hours > 0 || r.URL.Query().Has("cursor")) {
+		http.Error(w, "as_of ends days windows, without cursor", http.StatusBadRequest)
+		return
+	}
+	if r.URL.Query().Has("cursor") {
+		if hours > 0 || rankBy != rankByCount {
+			http.Error(w, "cursor pages days windows ranked by count only", http.StatusBadRequest)
+			return
+		}
+		if r.URL.Query().Has("min_count") {
+			http.Error(w, "min_count doesn't apply to cursor pages", http.StatusBadRequest)
+			return
+		}
+		topKPageHandler(w, r, userID, days, k, r.URL.Query().Get("cursor"))
+		return
+	}
 
 	ctx := r.Context()
 
-	// Check cache
-	cacheKey := fmt.Sprintf("topk:%s:%d:%d", userID, days, k)
-	cached, err := redisClient.Get(ctx, cacheKey).Result()
+	// Check cache, computed at k's bucket and trimmed to k
+	kb := bucketK(k)
+	cacheKey := fmt.Sprintf("%stopk:%s:%s:%d", songsKeyPrefix(), userID, windowKey(days), kb)
+	ttl := settings.Duration("cache_ttl", cacheTTL)
+	if asOf != "" {
+		// A past window doesn't move at midnight
+		cacheKey = fmt.Sprintf("%stopk:%s:%s:%d", songsKeyPrefix(), userID, windowKeyAsOf(days, asOf), kb)
+	} else if hours > 0 {
+		cacheKey = fmt.Sprintf("%stopk:%s:%s:%d", songsKeyPrefix(), userID, hourWindowKey(hours), kb)
+		// The window slides at the top of the hour; don't serve it past that
+		if untilNext := time.Until(time.Now().Truncate(time.Hour).Add(time.Hour)); untilNext < ttl {
+			ttl = untilNext
+		}
+	} else if untilNext := untilNextDay(); untilNext < ttl {
+		// Past midnight requests key the next day's window
+		ttl = untilNext
+	}
+	switch rankBy {
+	case rankByTime:
+		cacheKey += ":time"
+	case rankByRecent:
+		cacheKey += fmt.Sprintf(":recent=%d", halfLife)
+	}
+	if experiment != "" {
+		cacheKey += ":exp=" + experiment
+	}
+	if minListens > 1 {
+		cacheKey += fmt.Sprintf(":min=%d", minListens)
+	}
+	cached, err := cache.Get(ctx, cacheKey)
 	if err == nil {
-		w.Header().Set("Content-Type", "application/json")
-		w.Header().Set("X-Cache", "HIT")
-		w.Write([]byte(cached))
-		return
+		var body []byte
+		if body, err = trimJSON[TopKResponse]([]byte(cached), k); err == nil {
+			metricCacheHits.Add(1)
+			w.Header().Set("X-Cache", "HIT")
+			setCacheControl(w, userID, asOf != "" && settled(asOf), ttl)
+			writeJSON(w, r, body)
+			return
+		}
+	}
+	metricCacheMisses.Add(1)
+	if !errors.Is(err, errCacheMiss) {
+		metricCacheErrors.Add(1)
 	}
 
-	// Compute Top-K from Cassandra
-	results, err := computeTopK(ctx, userID, days, k)
+	// Read Top-K from Cassandra, once for identical requests in flight and
+	// within the read budget
+	cost := dayCost(days)
+	if hours > 0 {
+		cost = hourCost(hours)
+	}
+	// A bounded read shares its flight with the same bound only: the others
+	// wait for every day
+	flightKey := cacheKey
+	if maxWait > 0 {
+		flightKey += fmt.Sprintf(":wait=%d", maxWait.Milliseconds())
+	}
+	computed, err := coalesce(ctx, &responseFlights, "response", flightKey, budgeted(r, cost, func(ctx context.Context) (computedTopK, error) {
+		var (
+			results []TopKResult
+			missing []string
+			source  string
+			err     error
+		)
+		// min_count drops songs by count: the top kb by count are enough,
+		// the other rankings have to rank every song first
+		rankK := kb
+		if minListens > 1 && rankBy != rankByCount {
+			rankK = rankAll
+		}
+		switch {
+		case maxWait > 0 && asOf != "":
+			results, missing, err = boundedTopK(ctx, userID, asOfWindow(asOf, days), false, rankBy, rankK, maxWait)
+			source = readAsOf
+		case maxWait > 0:
+			results, missing, err = boundedTopK(ctx, userID, storage.LastDays(days), true, rankBy, rankK, maxWait)
+			source = readCompute
+		case asOf != "":
+			results, err = asOfTopK(ctx, userID, asOf, days, rankBy, halfLife, experiment, rankK)
+			source = readAsOf
+		case experiment != "":
+			results, err = experimentTopKOf(ctx, userID, experiment, storage.LastDays(days), rankK)
+			source = readExperiment
+		case rankBy == rankByTime:
+			results, source, err = timeTopK(ctx, userID, days, hours, rankK)
+		case rankBy == rankByRecent:
+			results, err = recentTopK(ctx, userID, storage.LastDays(days), halfLife, rankK)
+			source = readCompute
+		case hours > 0:
+			results, err = slidingTopK(ctx, userID, hours, rankK)
+			source = readSliding
+		default:
+			results, source, err = readTopK(ctx, userID, days, rankK)
+		}
+		if err != nil {
+			return computedTopK{}, err
+		}
+		results = atLeast(results, minListens, kb)
+
+		response := TopKResponse{
+			UserID:  userID,
+			Days:    days,
+			Hours:   hours,
+			K:       kb,
+			RankBy:  rankBy,
+			Results: results,
+			Cached:  false,
+
+			Experiment: experiment,
+			AsOf:       asOf,
+			MinCount:   minCountOf(minListens),
+
+			Partial:     len(missing) > 0,
+			MissingDays: missing,
+		}
+		if rankBy == rankByRecent {
+			response.HalfLife = halfLife
+		}
+		jsonData, err := json.Marshal(response)
+		if err != nil {
+			return computedTopK{}, err
+		}
+
+		// Cache the result; a partial one would be served whole
+		if !response.Partial {
+			if err := cache.Set(ctx, cacheKey, jsonData, ttl); err != nil {
+				metricCacheErrors.Add(1)
+			}
+		}
+		return computedTopK{jsonData, source, response.Partial}, nil
+	}))
+	var body []byte
+	if err == nil {
+		body, err = trimJSON[TopKResponse](computed.json, k)
+	}
+	if writeBudgetError(w, err) || writeNotStored(w, err) {
+		return
+	}
+	if errors.Is(err, errNoDays) {
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
 	if err != nil {
 		log.Printf("Error computing topk: %v", err)
 		http.Error(w, "internal error", http.StatusInternalServerError)
 		return
 	}
 
-	response := TopKResponse{
-		UserID:  userID,
-		Days:    days,
-		K:       k,
-		Results: results,
-		Cached:  false,
+	w.Header().Set("X-Cache", "MISS")
+	w.Header().Set("X-TopK-Source", computed.source)
+	if computed.partial {
+		// Missing days a later read may have
+		setCacheControl(w, userID, false, 0)
+	} else {
+		setCacheControl(w, userID, asOf != "" && settled(asOf), ttl)
+	}
+	writeJSON(w, r, body)
+}
+
+// computedTopK is a serialized Top-K response and the read path it came from
+type computedTopK struct {
+	json    []byte
+	source  string
+	partial bool // missing days (max_wait_ms), not cached
+}
+
+// artistTopKHandler handles GET /users/{user_id}/topk/artists?days=7&k=10,
+// summed from the daily artist counters. Listens without an artist_id aren't
+// counted here.
+func artistTopKHandler(w http.ResponseWriter, r *http.Request, userID string) {
+	days := getQueryInt(r, "days", 7)
+	k := getQueryInt(r, "k", 10)
+	if days < 1 || days > 30 {
+		http.Error(w, "days must be 1-30", http.StatusBadRequest)
+		return
+	}
+	if k < 1 || k > 100 {
+		http.Error(w, "k must be 1-100", http.StatusBadRequest)
+		return
+	}
+
+	kb := bucketK(k)
+	cacheKey := fmt.Sprintf("%stopk-artists:%s:%s:%d", cachePrefix, userID, windowKey(days), kb)
+	serveCached(w, r, cacheKey, k, dayCost(days), func(ctx context.Context) (*ArtistTopKResponse, error) {
+		artistCounts, err := artistTopK.SumCounts(ctx, userID, storage.LastDays(days))
+		if err != nil {
+			return nil, fmt.Errorf("artist topk: %w", err)
+		}
+		top := topCounts(artistCounts, kb)
+		results := make([]ArtistResult, len(top))
+		for i, ac := range top {
+			results[i] = ArtistResult{ArtistID: ac.id, ListenCount: ac.count, Rank: i + 1}
+		}
+		return &ArtistTopKResponse{UserID: userID, Days: days, K: kb, Results: results}, nil
+	})
+}
+
+// tagTopKHandler handles GET /users/{user_id}/topk/genres?days=7&k=10 (and
+// /topk/moods), summed from the materializer's per-day rollups
+func tagTopKHandler(w http.ResponseWriter, r *http.Request, userID, kind string) {
+	days := getQueryInt(r, "days", 7)
+	k := getQueryInt(r, "k", 10)
+	if days < 1 || days > 30 {
+		http.Error(w, "days must be 1-30", http.StatusBadRequest)
+		return
+	}
+	if k < 1 || k > 100 {
+		http.Error(w, "k must be 1-100", http.StatusBadRequest)
+		return
 	}
 
-	// Serialize response
-	jsonData, err := json.Marshal(response)
+	kb := bucketK(k)
+	cacheKey := fmt.Sprintf("%stopk-%ss:%s:%s:%d", cachePrefix, kind, userID, windowKey(days), kb)
+	serveCached(w, r, cacheKey, k, dayCost(days), func(ctx context.Context) (*TagTopKResponse, error) {
+		tagCounts, err := tagTopK.SumCounts(ctx, userID, kind, storage.LastDays(days))
+		if err != nil {
+			return nil, fmt.Errorf("%s topk: %w", kind, err)
+		}
+		top := topCounts(tagCounts, kb)
+		results := make([]TagResult, len(top))
+		for i, tc := range top {
+			results[i] = TagResult{Tag: tc.id, ListenCount: tc.count, Rank: i + 1}
+		}
+		return &TagTopKResponse{UserID: userID, Kind: kind, Days: days, K: kb, Results: results}, nil
+	})
+}
+
+// yearReviewHandler handles GET /users/{user_id}/year-review?year=2025
+// (default last year), the report precomputed by tools year-review
+func yearReviewHandler(w http.ResponseWriter, r *http.Request, userID string) {
+	year := getQueryInt(r, "year", time.Now().UTC().Year()-1)
+	if year < 2000 || year > time.Now().UTC().Year() {
+		http.Error(w, "invalid year", http.StatusBadRequest)
+		return
+	}
+
+	doc, ok, err := yearReviews.Get(r.Context(), userID, year)
 	if err != nil {
+		log.Printf("Error reading year review: %v", err)
 		http.Error(w, "internal error", http.StatusInternalServerError)
 		return
 	}
+	if !ok {
+		http.Error(w, "no report for this year", http.StatusNotFound)
+		return
+	}
+
+	// tools year-review only rewrites a settled year's report when rerun
+	setCacheControl(w, userID, settled(fmt.Sprintf("%d-12-31", year)), cdnMaxAge)
+	writeJSON(w, r, doc)
+}
 
-	// Cache the result
-	redisClient.Set(ctx, cacheKey, jsonData, cacheTTL)
+// ActivityResponse is a user's listens per day over a window, oldest first,
+// with their streaks
+type ActivityResponse struct {
+	UserID     string `json:"user_id"`
+	Days       int    `json:"days"`
+	Listens    int64  `json:"listens"`
+	ActiveDays int    `json:"active_days"`
+	// Consecutive days with listens up to today, or up to yesterday while
+	// today has none yet; at most the window
+	CurrentStreak int               `json:"current_streak"`
+	LongestStreak storage.Streak    `json:"longest_streak"` // within the window
+	Daily         []TimeseriesPoint `json:"daily"`
+}
+
+// activityHandler handles GET /users/{user_id}/activity?days=90
+func activityHandler(w http.ResponseWriter, r *http.Request, userID string) {
+	days := getQueryInt(r, "days", 90)
+	if days < 1 || days > 365 {
+		http.Error(w, "days must be 1-365", http.StatusBadRequest)
+		return
+	}
+
+	window := storage.LastDays(days) // newest first
+	totals, err := userTotals.Range(r.Context(), userID, window[len(window)-1], window[0])
+	if err != nil {
+		log.Printf("Error reading activity: %v", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	resp := ActivityResponse{UserID: userID, Days: days, Daily: make([]TimeseriesPoint, days)}
+	var streak storage.Streak
+	for i := days - 1; i >= 0; i-- {
+		day := window[i]
+		n := totals[day]
+		resp.Daily[days-1-i] = TimeseriesPoint{Day: day, Listens: n}
+		if n <= 0 {
+			streak = storage.Streak{}
+			continue
+		}
+		resp.Listens += n
+		resp.ActiveDays++
+		if streak.Days == 0 {
+			streak.From = day
+		}
+		streak.Days++
+		streak.To = day
+		if streak.Days > resp.LongestStreak.Days {
+			resp.LongestStreak = streak
+		}
+	}
+	// streak is now the run ending today; if today is still empty, the run
+	// ending yesterday is current too
+	resp.CurrentStreak = streak.Days
+	if streak.Days == 0 && days > 1 {
+		for _, day := range window[1:] {
+			if totals[day] <= 0 {
+				break
+			}
+			resp.CurrentStreak++
+		}
+	}
+
+	encodeJSON(w, r, resp)
+}
+
+// serveCached answers from the response cache, or computes the response at
+// k's bucket within the read budget, caches it for cache_ttl (until midnight
+// at most: the key names the window's last day) and serves it trimmed to k
+func serveCached[T any, PT interface {
+	*T
+	trimmer
+}](w http.ResponseWriter, r *http.Request, cacheKey string, k int, cost queryCost, compute func(context.Context) (PT, error)) {
+	ctx := r.Context()
+	cached, err := cache.Get(ctx, cacheKey)
+	if err == nil {
+		var body []byte
+		if body, err = trimJSON[T, PT]([]byte(cached), k); err == nil {
+			metricCacheHits.Add(1)
+			w.Header().Set("X-Cache", "HIT")
+			writeJSON(w, r, body)
+			return
+		}
+	}
+	metricCacheMisses.Add(1)
+	if !errors.Is(err, errCacheMiss) {
+		metricCacheErrors.Add(1)
+	}
+
+	// Once for identical requests in flight
+	jsonData, err := coalesce(ctx, &responseFlights, "response", cacheKey, budgeted(r, cost, func(ctx context.Context) ([]byte, error) {
+		resp, err := compute(ctx)
+		if err != nil {
+			return nil, err
+		}
+		jsonData, err := json.Marshal(resp)
+		if err != nil {
+			return nil, err
+		}
+		ttl := settings.Duration("cache_ttl", cacheTTL)
+		if untilNext := untilNextDay(); untilNext < ttl {
+			ttl = untilNext
+		}
+		if err := cache.Set(ctx, cacheKey, jsonData, ttl); err != nil {
+			metricCacheErrors.Add(1)
+		}
+		return jsonData, nil
+	}))
+	if err == nil {
+		jsonData, err = trimJSON[T, PT](jsonData, k)
+	}
+	if writeBudgetError(w, err) || writeNotStored(w, err) {
+		return
+	}
+	if err != nil {
+		log.Printf("Error computing %s: %v", cacheKey, err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
 
-	w.Header().Set("Content-Type", "application/json")
 	w.Header().Set("X-Cache", "MISS")
-	w.Write(jsonData)
+	writeJSON(w, r, jsonData)
+}
+
+// readTopK serves from the snapshot when READ_MODE=snapshot and one is usable,
+// otherwise computes from the daily counters. It returns which one it used.
+func readTopK(ctx context.Context, userID string, days, k int) ([]TopKResult, string, error) {
+	if readMode == readSnapshot {
+		results, reason, err := snapshotTopK(ctx, userID, days, k)
+		if err != nil {
+			log.Printf("Error reading snapshot, computing instead: %v", err)
+			reason = "error"
+		}
+		if reason == "" {
+			metricSnapshotHits.Add(1)
+			return results, readSnapshot, nil
+		}
+		metricSnapshotFallback.Add(reason, 1)
+	}
+	results, err := computeTopK(ctx, userID, days, k)
+	return results, readCompute, err
+}
+
+// snapshotTopK reads the precomputed top list in one query. A non-empty
+// reason says why the snapshot can't answer: there is none for the window, or
+// it was computed on an earlier day and its window has since moved. Snapshots
+// keep the materializer's SNAPSHOT_K songs (default 100, the API's max k).
+func snapshotTopK(ctx context.Context, userID string, days, k int) ([]TopKResult, string, error) {
+	snap, ok, err := snapshots.Get(ctx, userID, days)
+	if err != nil {
+		return nil, "", err
+	}
+	switch {
+	case !ok:
+		return nil, "missing", nil
+	case snap.ComputedAt.UTC().Format(storage.DayFormat) != storage.LastDays(1)[0]:
+		return nil, "stale", nil
+	}
+
+	results := make([]TopKResult, 0, k)
+	for _, sc := range snap.Songs {
+		if len(results) == k {
+			break
+		}
+		if takedowns.Has(sc.SongID) {
+			metricTakedownsFiltered.Add(1)
+			continue
+		}
+		results = append(results, TopKResult{SongID: sc.SongID, ListenCount: sc.Count, Rank: len(results) + 1})
+	}
+	return results, "", nil
 }
 
 func computeTopK(ctx context.Context, userID string, days, k int) ([]TopKResult, error) {
-	// Generate list of days to query
-	today := time.Now().UTC().Truncate(24 * time.Hour)
-	dayList := make([]string, days)
-	for i := 0; i < days; i++ {
-		day := today.AddDate(0, 0, -i)
-		dayList[i] = day.Format("2006-01-02")
+	// Aggregate counts across the last `days` days
+	songCounts, err := dailyTopK.SumCounts(ctx, userID, storage.LastDays(days))
+	if err != nil {
+		return nil, err
 	}
+	return rankTopK(withoutTakedowns(songCounts), k), nil
+}
 
-	// Aggregate counts across days
-	songCounts := make(map[string]int64)
+// slidingTopK sums the hourly counters of the last `hours` hours, including
+// the current one, so hours=24 is the last day whatever the time of day
+func slidingTopK(ctx context.Context, userID string, hours, k int) ([]TopKResult, error) {
+	songCounts, err := hourlyTopK.SumCounts(ctx, userID, storage.LastHours(hours))
+	if err != nil {
+		return nil, err
+	}
+	return rankTopK(withoutTakedowns(songCounts), k), nil
+}
 
-	for _, day := range dayList {
-		query := `
-			SELECT song_id, listen_count 
-			FROM user_daily_topk 
-			WHERE user_id = ? AND day = ?
-		`
-		iter := cassandraSession.Query(query, userID, day).Iter()
+// timeTopK ranks songs by listening time over the last `days` days, or the
+// last `hours` hours when hours > 0. Snapshots only keep counts, so this
+// always sums the counters; it returns which ones it read.
+func timeTopK(ctx context.Context, userID string, days, hours, k int) ([]TopKResult, string, error) {
+	if hours > 0 {
+		totals, err := hourlyTopK.SumTotals(ctx, userID, storage.LastHours(hours))
+		return rankByListenTime(withoutTakedowns(totals), k), readSliding, err
+	}
+	totals, err := dailyTopK.SumTotals(ctx, userID, storage.LastDays(days))
+	return rankByListenTime(withoutTakedowns(totals), k), readCompute, err
+}
 
-		var songID string
-		var count int64
-		for iter.Scan(&songID, &count) {
-			songCounts[songID] += count
+// rankByListenTime keeps the k songs with the most listening time. Songs
+// whose listens carried no duration_ms have none and rank after the rest, by
+// count.
+func rankByListenTime(totals map[string]storage.SongTotals, k int) []TopKResult {
+	results := make([]TopKResult, 0, len(totals))
+	for song, t := range totals {
+		results = append(results, TopKResult{SongID: song, ListenCount: t.Count, ListenMs: t.Millis})
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].ListenMs != results[j].ListenMs {
+			return results[i].ListenMs > results[j].ListenMs
 		}
-		if err := iter.Close(); err != nil {
-			return nil, fmt.Errorf("query error for day %s: %w", day, err)
+		return results[i].ListenCount > results[j].ListenCount
+	})
+	if len(results) > k {
+		results = results[:k]
+	}
+	for i := range results {
+		results[i].Rank = i + 1
+	}
+	return results
+}
+
+// rankTopK sorts song counts and keeps the top k
+func rankTopK(songCounts map[string]int64, k int) []TopKResult {
+	sorted := topCounts(songCounts, k)
+
+	// Build response
+	results := make([]TopKResult, len(sorted))
+	for i, sc := range sorted {
+		results[i] = TopKResult{
+			SongID:      sc.id,
+			ListenCount: sc.count,
+			Rank:        i + 1,
 		}
 	}
 
+	return results
+}
+
+// idCount is a song's or artist's count
+type idCount struct {
+	id    string
+	count int64
+}
+
+// topCounts returns the k highest counts, highest first
+func topCounts(counts map[string]int64, k int) []idCount {
 	// Convert to slice and sort
-	type songCount struct {
-		songID string
-		count  int64
-	}
-	var sorted []songCount
-	for songID, count := range songCounts {
-		sorted = append(sorted, songCount{songID, count})
+	var sorted []idCount
+	for id, count := range counts {
+		sorted = append(sorted, idCount{id, count})
 	}
+	// Ties break on ID, as in the materializer's snapshots, so equal counts
+	// rank (and hash to an ETag) the same way every time
 	sort.Slice(sorted, func(i, j int) bool {
-		return sorted[i].count > sorted[j].count
+		if sorted[i].count != sorted[j].count {
+			return sorted[i].count > sorted[j].count
+		}
+		return sorted[i].id < sorted[j].id
 	})
 
 	// Take top K
 	if len(sorted) > k {
 		sorted = sorted[:k]
 	}
+	return sorted
+}
 
-	// Build response
-	results := make([]TopKResult, len(sorted))
-	for i, sc := range sorted {
-		results[i] = TopKResult{
-			SongID:      sc.songID,
-			ListenCount: sc.count,
-			Rank:        i + 1,
+// GlobalChart is a window's chart as published to Redis by global-charts
+type GlobalChart struct {
+	Window     string      `json:"window"`
+	ComputedAt time.Time   `json:"computed_at"`
+	Events     int64       `json:"events"`
+	Songs      []ChartSong `json:"songs"`
+}
+
+// ChartSong is one ranked song of a GlobalChart
+type ChartSong struct {
+	Rank   int    `json:"rank"`
+	SongID string `json:"song_id"`
+	Count  int64  `json:"count"`
+}
+
+// chartsHandler handles GET /charts/{window}?n=10, the global top songs over
+// the last 1h, 24h or 7d. Counts are count-min sketch estimates.
+func chartsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	window := strings.TrimPrefix(r.URL.Path, "/charts/")
+	if window == "" || strings.Contains(window, "/") {
+		http.Error(w, "invalid path, expected /charts/{window}", http.StatusBadRequest)
+		return
+	}
+	n := getQueryInt(r, "n", 10)
+	if n < 1 || n > 100 {
+		http.Error(w, "n must be 1-100", http.StatusBadRequest)
+		return
+	}
+
+	data, err := redisClient.Get(r.Context(), "charts:global:"+window).Bytes()
+	if errors.Is(err, redis.Nil) {
+		// Unknown window, or global-charts hasn't published recently
+		http.Error(w, "chart not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error reading chart %s: %v", window, err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	var chart GlobalChart
+	if err := json.Unmarshal(data, &chart); err != nil {
+		log.Printf("Error decoding chart %s: %v", window, err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	// global-charts counts the raw stream: taken-down songs stay in its
+	// sketches until they age out of the window
+	if takedowns.Len() > 0 {
+		songs := chart.Songs[:0]
+		for _, s := range chart.Songs {
+			if takedowns.Has(s.SongID) {
+				metricTakedownsFiltered.Add(1)
+				continue
+			}
+			s.Rank = len(songs) + 1
+			songs = append(songs, s)
 		}
+		chart.Songs = songs
+	}
+	if len(chart.Songs) > n {
+		chart.Songs = chart.Songs[:n]
+	}
+
+	encodeJSON(w, r, chart)
+}
+
+// SongStatsResponse is a song's listens and unique listeners over a window
+type SongStatsResponse struct {
+	SongID  string `json:"song_id"`
+	Days    int    `json:"days"`
+	Listens int64  `json:"listens"`
+	// Distinct listeners across the whole window, a union of the daily
+	// HyperLogLogs; omitted when a day's is past its Redis TTL. The daily
+	// estimates can't be summed: a listener on two days counts twice.
+	UniqueListeners *int64            `json:"unique_listeners,omitempty"`
+	Daily           []storage.SongDay `json:"daily"` // newest first
+}
+
+// listenerHLLDays is how many days back the aggregator's listener
+// HyperLogLogs are kept in Redis
+const listenerHLLDays = 7
+
+// songsHandler routes GET /songs/{song_id}/stats and /timeseries
+func songsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
 	}
 
-	return results, nil
+	path := strings.TrimPrefix(r.URL.Path, "/songs/")
+	parts := strings.Split(path, "/")
+	if len(parts) != 2 || parts[0] == "" {
+		http.Error(w, "invalid path, expected /songs/{song_id}/stats or /timeseries", http.StatusBadRequest)
+		return
+	}
+	if takedowns.Has(parts[0]) {
+		http.Error(w, "song taken down", http.StatusGone)
+		return
+	}
+	switch parts[1] {
+	case "stats":
+		songStatsHandler(w, r, parts[0])
+	case "timeseries":
+		songTimeseriesHandler(w, r, parts[0])
+	default:
+		http.Error(w, "invalid path, expected /songs/{song_id}/stats or /timeseries", http.StatusBadRequest)
+	}
+}
+
+// songStatsHandler handles GET /songs/{song_id}/stats?days=7
+func songStatsHandler(w http.ResponseWriter, r *http.Request, songID string) {
+	days := getQueryInt(r, "days", 7)
+	if days < 1 || days > 30 {
+		http.Error(w, "days must be 1-30", http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	window := storage.LastDays(days)
+	daily, err := songStats.Days(ctx, songID, window)
+	if writeNotStored(w, err) {
+		return
+	}
+	if err != nil {
+		log.Printf("Error reading song stats: %v", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	resp := SongStatsResponse{SongID: songID, Days: days, Daily: daily}
+	for _, d := range daily {
+		resp.Listens += d.Listens
+	}
+	if days <= listenerHLLDays {
+		keys := make([]string, len(window))
+		for i, day := range window {
+			keys[i] = fmt.Sprintf("hll:listeners:%s:%s", day, songID)
+		}
+		if n, err := redisClient.PFCount(ctx, keys...).Result(); err == nil {
+			resp.UniqueListeners = &n
+		} else {
+			log.Printf("Warning: unique listeners of %s: %v", songID, err)
+		}
+	}
+
+	encodeJSON(w, r, resp)
+}
+
+// SongTimeseries is a song's global listens per day, oldest first
+type SongTimeseries struct {
+	SongID string            `json:"song_id"`
+	Days   int               `json:"days"`
+	Points []TimeseriesPoint `json:"points"`
+}
+
+// TimeseriesPoint is one day of a SongTimeseries
+type TimeseriesPoint struct {
+	Day     string `json:"day"`
+	Listens int64  `json:"listens"`
+}
+
+// songTimeseriesHandler handles GET /songs/{song_id}/timeseries?days=30
+func songTimeseriesHandler(w http.ResponseWriter, r *http.Request, songID string) {
+	days := getQueryInt(r, "days", 30)
+	if days < 1 || days > 365 {
+		http.Error(w, "days must be 1-365", http.StatusBadRequest)
+		return
+	}
+
+	window := storage.LastDays(days) // newest first
+	listens, err := songStats.DailyListens(r.Context(), songID, window[len(window)-1], window[0])
+	if writeNotStored(w, err) {
+		return
+	}
+	if err != nil {
+		log.Printf("Error reading song timeseries: %v", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	resp := SongTimeseries{SongID: songID, Days: days, Points: make([]TimeseriesPoint, days)}
+	for i, day := range window {
+		// Every day gets a point, zero if nobody listened, so charts don't
+		// have to fill gaps
+		resp.Points[days-1-i] = TimeseriesPoint{Day: day, Listens: listens[day]}
+	}
+
+	encodeJSON(w, r, resp)
 }
 
 func getQueryInt(r *http.Request, key string, defaultVal int) int {
@@ -247,3 +1316,13 @@ func getEnvDuration(key string, fallback time.Duration) time.Duration {
 	}
 	return fallback
 }
+
+func getEnvInt(key string, fallback int) int {
+	if v := os.Getenv(key); v != "" {
+		i, err := strconv.Atoi(v)
+		if err == nil {
+			return i
+		}
+	}
+	return fallback
+}
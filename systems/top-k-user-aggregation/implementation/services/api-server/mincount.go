@@ -0,0 +1,62 @@
+package main
+
+import (
+	"errors"
+	"expvar"
+	"math"
+	"net/http"
+	"strconv"
+)
+
+// minCount is MIN_COUNT, the least listens in the window a song needs to be
+// in a song top-K; the min_count runtime setting and query param override it.
+// 1 lists every song played.
+var minCount = 1
+
+// rankAll is the k of a read ranking every song, for filters applied after
+// ranking that don't follow its order
+const rankAll = math.MaxInt32
+
+var metricMinCountDropped = expvar.NewInt("min_count_dropped") // songs left out of responses under min_count
+
+// parseMinCount reads min_count, defaulting to the runtime setting
+func parseMinCount(r *http.Request) (int, error) {
+	v := r.URL.Query().Get("min_count")
+	if v == "" {
+		return settings.Int("min_count", minCount), nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 1 {
+		return 0, errors.New("min_count must be >= 1")
+	}
+	return n, nil
+}
+
+// minCountOf is the min_count of a response: 0, left out, when it's 1
+func minCountOf(n int) int {
+	if n > 1 {
+		return n
+	}
+	return 0
+}
+
+// atLeast keeps the results with at least least listens, up to k, ranked
+// again
+func atLeast(results []TopKResult, least, k int) []TopKResult {
+	if least <= 1 {
+		return results
+	}
+	kept := make([]TopKResult, 0, min(len(results), k))
+	for _, res := range results {
+		if res.ListenCount < int64(least) {
+			metricMinCountDropped.Add(1)
+			continue
+		}
+		if len(kept) == k {
+			break
+		}
+		res.Rank = len(kept) + 1
+		kept = append(kept, res)
+	}
+	return kept
+}
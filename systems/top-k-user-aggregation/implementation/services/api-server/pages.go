@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/system-design-lab/pkg/storage"
+)
+
+// pageCursor is where a paged read of a ranked list stands. It pins the
+// version the first page came from, so later pages stay consistent while the
+// materializer writes newer ones.
+type pageCursor struct {
+	days    int
+	version int64 // user_topk_ranked version
+	after   int   // last rank served
+	total   int   // length of the version's list
+}
+
+func (c pageCursor) encode() string {
+	return base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf("%d.%d.%d.%d", c.days, c.version, c.after, c.total)))
+}
+
+func decodeCursor(s string) (pageCursor, error) {
+	var c pageCursor
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return c, err
+	}
+	if _, err := fmt.Sscanf(string(raw), "%d.%d.%d.%d", &c.days, &c.version, &c.after, &c.total); err != nil {
+		return c, err
+	}
+	if c.version <= 0 || c.after < 0 || c.after > c.total {
+		return c, fmt.Errorf("cursor out of range")
+	}
+	return c, nil
+}
+
+// topKPageHandler serves one page of k songs of a user's ranked list
+// (/users/{user_id}/topk?cursor=). An empty cursor starts at rank 1 from the
+// window's current snapshot; each response's next_cursor continues on the
+// same version until it expires. Pages aren't cached: each is one slice
+// query. Taken-down songs are left out, keeping the ranks of the others, so
+// a page may hold fewer than k songs until the materializer rewrites the list.
+func topKPageHandler(w http.ResponseWriter, r *http.Request, userID string, days, k int, cursor string) {
+	ctx := r.Context()
+
+	var c pageCursor
+	if cursor == "" {
+		snap, ok, err := snapshots.Get(ctx, userID, days)
+		if err != nil {
+			log.Printf("Error reading snapshot: %v", err)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		if !ok || snap.RankedVersion == 0 || snap.ComputedAt.UTC().Format(storage.DayFormat) != storage.LastDays(1)[0] {
+			http.Error(w, "no current ranked list for this window (only materialized windows can be paged)", http.StatusNotFound)
+			return
+		}
+		c = pageCursor{days: days, version: snap.RankedVersion, total: snap.RankedTotal}
+	} else {
+		var err error
+		if c, err = decodeCursor(cursor); err != nil || c.days != days {
+			http.Error(w, "invalid cursor", http.StatusBadRequest)
+			return
+		}
+	}
+
+	songs, err := rankedLists.Page(ctx, userID, days, c.version, c.after, k)
+	if err != nil {
+		log.Printf("Error reading ranked list: %v", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	if len(songs) == 0 && c.after < c.total {
+		http.Error(w, "cursor expired, start again without one", http.StatusGone)
+		return
+	}
+
+	response := TopKResponse{
+		UserID:  userID,
+		Days:    days,
+		K:       k,
+		RankBy:  rankByCount,
+		Results: make([]TopKResult, 0, len(songs)),
+		Total:   c.total,
+	}
+	for i, sc := range songs {
+		if takedowns.Has(sc.SongID) {
+			metricTakedownsFiltered.Add(1)
+			continue
+		}
+		response.Results = append(response.Results, TopKResult{SongID: sc.SongID, ListenCount: sc.Count, Rank: c.after + i + 1})
+	}
+	if next := c.after + len(songs); next < c.total {
+		c.after = next
+		response.NextCursor = c.encode()
+	}
+
+	w.Header().Set("X-TopK-Source", readRanked)
+	encodeJSON(w, r, response)
+}
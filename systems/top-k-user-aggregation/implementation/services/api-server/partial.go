@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/system-design-lab/pkg/storage"
+)
+
+// maxWaitLimit bounds max_wait_ms
+const maxWaitLimit = 30 * time.Second
+
+// errNoDays is a max_wait_ms read that got none of its days in time
+var errNoDays = errors.New("no day of the window read within max_wait_ms")
+
+var (
+	metricPartial     = expvar.NewInt("partial_responses")    // max_wait_ms responses missing days
+	metricMissingDays = expvar.NewInt("partial_missing_days") // days left out of them
+)
+
+// parseMaxWait reads max_wait_ms, how long a /topk read may wait for its day
+// partitions; 0 when unset
+func parseMaxWait(r *http.Request) (time.Duration, error) {
+	v := r.URL.Query().Get("max_wait_ms")
+	if v == "" {
+		return 0, nil
+	}
+	ms, err := strconv.Atoi(v)
+	if err != nil || ms < 1 || time.Duration(ms)*time.Millisecond > maxWaitLimit {
+		return 0, errors.New("max_wait_ms must be 1-30000")
+	}
+	return time.Duration(ms) * time.Millisecond, nil
+}
+
+// boundedTopK ranks a days window from the days read within maxWait and
+// lists the others, newest first. A usable snapshot answers whole, as
+// readTopK's would.
+func boundedTopK(ctx context.Context, userID string, window []string, current bool, rankBy string, k int, maxWait time.Duration) ([]TopKResult, []string, error) {
+	if readMode == readSnapshot && current && rankBy == rankByCount {
+		results, reason, err := snapshotTopK(ctx, userID, len(window), k)
+		if err == nil && reason == "" {
+			metricSnapshotHits.Add(1)
+			return results, nil, nil
+		}
+		if err != nil {
+			log.Printf("Error reading snapshot, computing instead: %v", err)
+			reason = "error"
+		}
+		metricSnapshotFallback.Add(reason, 1)
+	}
+
+	totals, missing, err := windowTotals(ctx, userID, window, maxWait)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(missing) > 0 {
+		metricPartial.Add(1)
+		metricMissingDays.Add(int64(len(missing)))
+	}
+	if rankBy == rankByTime {
+		return rankByListenTime(withoutTakedowns(totals), k), missing, nil
+	}
+	return rankTopK(withoutTakedowns(countsOf(totals)), k), missing, nil
+}
+
+// windowTotals reads the window's days in parallel and sums the ones back
+// within maxWait. Days that failed or are still out are missing; those reads
+// finish in the background, as other requests may share them (coalesce).
+func windowTotals(ctx context.Context, userID string, window []string, maxWait time.Duration) (map[string]storage.SongTotals, []string, error) {
+	type dayRead struct {
+		day    string
+		totals map[string]storage.SongTotals
+		err    error
+	}
+	reads := make(chan dayRead, len(window))
+	for _, day := range window {
+		go func(day string) {
+			totals, err := dailyTopK.SumTotals(ctx, userID, []string{day})
+			reads <- dayRead{day, totals, err}
+		}(day)
+	}
+
+	timer := time.NewTimer(maxWait)
+	defer timer.Stop()
+	sum := make(map[string]storage.SongTotals)
+	read := make(map[string]bool, len(window))
+wait:
+	for n := 0; n < len(window); n++ {
+		select {
+		case <-ctx.Done():
+			return nil, nil, ctx.Err()
+		case <-timer.C:
+			break wait
+		case rd := <-reads:
+			if rd.err != nil {
+				log.Printf("Warning: day %s of %s left out: %v", rd.day, userID, rd.err)
+				continue
+			}
+			read[rd.day] = true
+			sumTotals(sum, rd.totals)
+		}
+	}
+	if len(read) == 0 {
+		return nil, nil, errNoDays
+	}
+
+	var missing []string
+	for _, day := range window {
+		if !read[day] {
+			missing = append(missing, day)
+		}
+	}
+	return sum, missing, nil
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"math"
+	"sort"
+)
+
+// recentTopK ranks the window's songs by recency-weighted count: a listen
+// halfLife days old counts half as much as one of the window's last day, one
+// 2×halfLife old a quarter. So a 30-day window ranked by recent answers
+// "what I'm into lately" rather than "this month". The daily counters are
+// read one day at a time (window is newest first, so window[i] is i days
+// old); snapshots only hold the window's sums and can't answer.
+func recentTopK(ctx context.Context, userID string, window []string, halfLife, k int) ([]TopKResult, error) {
+	scores := make(map[string]float64)
+	counts := make(map[string]int64)
+	for age, day := range window {
+		dayCounts, err := dailyTopK.SumCounts(ctx, userID, []string{day})
+		if err != nil {
+			return nil, err
+		}
+		weight := math.Exp2(-float64(age) / float64(halfLife))
+		for song, c := range dayCounts {
+			scores[song] += float64(c) * weight
+			counts[song] += c
+		}
+	}
+	counts = withoutTakedowns(counts)
+
+	results := make([]TopKResult, 0, len(counts))
+	for song, c := range counts {
+		results = append(results, TopKResult{SongID: song, ListenCount: c, Score: math.Round(scores[song]*1000) / 1000})
+	}
+	sort.Slice(results, func(i, j int) bool {
+		a, b := results[i], results[j]
+		if a.Score != b.Score {
+			return a.Score > b.Score
+		}
+		if a.ListenCount != b.ListenCount {
+			return a.ListenCount > b.ListenCount
+		}
+		return a.SongID < b.SongID
+	})
+	if len(results) > k {
+		results = results[:k]
+	}
+	for i := range results {
+		results[i].Rank = i + 1
+	}
+	return results, nil
+}
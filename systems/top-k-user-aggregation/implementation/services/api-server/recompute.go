@@ -0,0 +1,271 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/segmentio/kafka-go"
+	"github.com/system-design-lab/pkg/events"
+	"github.com/system-design-lab/pkg/kafkautil"
+	"github.com/system-design-lab/pkg/lock"
+	"github.com/system-design-lab/pkg/replay"
+	"github.com/system-design-lab/pkg/storage"
+)
+
+var (
+	metricRecomputes      = expvar.NewInt("recomputes_started")
+	metricRecomputeErrors = expvar.NewInt("recompute_errors") // failed resets and replays
+)
+
+const (
+	// recomputeStatusTTL is how long a recompute's status stays readable
+	recomputeStatusTTL = 24 * time.Hour
+	// recomputeLockTTL frees a user's lock this soon after its holder dies;
+	// a live one renews it
+	recomputeLockTTL = time.Minute
+)
+
+// recomputer fixes a user's corrupted counts: it resets the daily counters,
+// listening time and day totals of some days, then replays the raw history of
+// those days through the aggregator, which counts it again (see the
+// aggregator's recompute.go). Counters can't be deleted and incremented
+// again, so the reset subtracts what they hold; doing it twice is harmless.
+//
+// Each recompute has a status hash in Redis, recompute:<id>, and holds the
+// user's pkg/lock lock (lock:recompute:<user>) until its replay is published,
+// so two can't reset the same counters under each other. A recompute that
+// loses the lock stops publishing its replay.
+type recomputer struct {
+	topk    *storage.DailyTopKRepo
+	totals  *storage.UserTotalsRepo
+	history *storage.ListenHistoryRepo
+	writer  *kafka.Writer
+	rdb     *redis.Client
+	token   string        // required as a bearer token (empty = none)
+	maxDays int           // history retention: older days would replay empty
+	timeout time.Duration // of a replay
+}
+
+// recomputes is nil in -demo, which has neither history nor Kafka
+var recomputes *recomputer
+
+func recomputeStatusKey(id string) string  { return "recompute:" + id }
+func recomputeLockName(user string) string { return "recompute:" + user }
+
+// adminHandler serves POST /admin/users/{user_id}/recompute?from=YYYY-MM-DD[&to=YYYY-MM-DD]
+// and GET /admin/recomputes/{id}
+func adminHandler(w http.ResponseWriter, r *http.Request) {
+	if recomputes == nil {
+		http.Error(w, "recompute needs Cassandra and Kafka (not in -demo)", http.StatusServiceUnavailable)
+		return
+	}
+	if !recomputes.authorized(r) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if id, ok := strings.CutPrefix(r.URL.Path, "/admin/recomputes/"); ok && id != "" && !strings.Contains(id, "/") {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		recomputes.status(w, r, id)
+		return
+	}
+	path, ok := strings.CutPrefix(r.URL.Path, "/admin/users/")
+	userID, recompute := strings.CutSuffix(path, "/recompute")
+	if !ok || !recompute || userID == "" || strings.Contains(userID, "/") {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	recomputes.start(w, r, userID)
+}
+
+func (c *recomputer) authorized(r *http.Request) bool {
+	if c.token == "" {
+		return true
+	}
+	got := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return subtle.ConstantTimeCompare([]byte(got), []byte(c.token)) == 1
+}
+
+// start resets the days (synchronously, so a failure is reported) and
+// replays them in the background
+func (c *recomputer) start(w http.ResponseWriter, r *http.Request, userID string) {
+	days, err := replay.DayRange(r.URL.Query().Get("from"), r.URL.Query().Get("to"))
+	if err != nil {
+		http.Error(w, "invalid day range: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	recent := storage.LastDays(c.maxDays)
+	if days[0] < recent[len(recent)-1] || days[len(days)-1] > recent[0] {
+		http.Error(w, fmt.Sprintf("days must be from %s to %s: older history has expired", recent[len(recent)-1], recent[0]),
+			http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	id := fmt.Sprintf("%s%s-%d", replay.RecomputePrefix, userID, time.Now().UnixNano())
+	l, err := lock.Acquire(ctx, c.rdb, recomputeLockName(userID), recomputeLockTTL)
+	if errors.Is(err, lock.ErrHeld) {
+		http.Error(w, "a recompute of this user is already running", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Printf("Error locking recompute of %s: %v", userID, err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	songs, err := c.reset(ctx, userID, days)
+	if err == nil {
+		// Replaying after another recompute took the user over would
+		// count the days twice
+		var held bool
+		if held, err = l.Held(ctx); err == nil && !held {
+			err = errors.New("lost the recompute lock")
+		}
+	}
+	if err != nil {
+		l.Release(context.WithoutCancel(ctx))
+		log.Printf("Error resetting %s %s..%s after %d songs: %v", userID, days[0], days[len(days)-1], songs, err)
+		metricRecomputeErrors.Add(1)
+		http.Error(w, "reset failed, retry the recompute", http.StatusInternalServerError)
+		return
+	}
+	c.setStatus(ctx, id, map[string]interface{}{
+		"user_id":    userID,
+		"from":       days[0],
+		"to":         days[len(days)-1],
+		"state":      "replaying",
+		"started_at": time.Now().Unix(),
+		"lock_fence": l.Fence,
+	})
+	metricRecomputes.Add(1)
+	log.Printf("Recompute %s: reset %d songs of %s over %s..%s, replaying (lock fence %d)", id, songs, userID, days[0], days[len(days)-1], l.Fence)
+	go c.replay(id, userID, days, l)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"recompute_id": id,
+		"user_id":      userID,
+		"days":         days,
+		"songs_reset":  songs,
+	})
+}
+
+// reset takes the user's days back to zero and returns the song-days reset
+func (c *recomputer) reset(ctx context.Context, userID string, days []string) (int, error) {
+	songs := 0
+	for _, day := range days {
+		totals, err := c.topk.DayTotals(ctx, userID, day)
+		if err != nil {
+			return songs, err
+		}
+		for song, t := range totals {
+			if t.Count != 0 {
+				if err := c.topk.Increment(ctx, userID, day, song, -t.Count); err != nil {
+					return songs, err
+				}
+			}
+			if t.Millis != 0 {
+				if err := c.topk.IncrementTime(ctx, userID, day, song, -t.Millis); err != nil {
+					return songs, err
+				}
+			}
+			songs++
+		}
+	}
+
+	dayTotals, err := c.totals.Range(ctx, userID, days[0], days[len(days)-1])
+	if err != nil {
+		return songs, err
+	}
+	for day, n := range dayTotals {
+		if n != 0 {
+			if err := c.totals.Increment(ctx, userID, day, -n); err != nil {
+				return songs, err
+			}
+		}
+	}
+	return songs, nil
+}
+
+// replay publishes the days' history, then records the outcome and releases
+// the lock. Losing the lock cancels the replay.
+func (c *recomputer) replay(id, userID string, days []string, l *lock.Lock) {
+	defer l.Release(context.Background())
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+	go func() {
+		select {
+		case <-l.Lost():
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	ctx = kafkautil.ContextWithTrace(ctx, kafkautil.NewTraceID())
+
+	r := &replay.Replayer{
+		History:  c.history,
+		Writer:   c.writer,
+		Format:   events.FormatJSON, // keeps the extra fields of newer producers
+		Version:  events.VersionFromEnv(),
+		ReplayID: id,
+		Batch:    500,
+	}
+	err := r.Run(ctx, []string{userID}, days, nil)
+
+	status := map[string]interface{}{"state": "published", "events": r.Published, "finished_at": time.Now().Unix()}
+	if err != nil {
+		status["state"], status["error"] = "failed", err.Error()
+		metricRecomputeErrors.Add(1)
+		log.Printf("ALERT: recompute %s failed after %d events, counts stay reset until it is retried: %v", id, r.Published, err)
+	} else {
+		log.Printf("Recompute %s: published %d events (trace=%s)", id, r.Published, kafkautil.TraceFromContext(ctx))
+	}
+	c.setStatus(context.Background(), id, status)
+}
+
+func (c *recomputer) setStatus(ctx context.Context, id string, fields map[string]interface{}) {
+	_, err := c.rdb.TxPipelined(ctx, func(p redis.Pipeliner) error {
+		p.HSet(ctx, recomputeStatusKey(id), fields)
+		p.Expire(ctx, recomputeStatusKey(id), recomputeStatusTTL)
+		return nil
+	})
+	if err != nil {
+		log.Printf("Error recording status of recompute %s: %v", id, err)
+	}
+}
+
+func (c *recomputer) status(w http.ResponseWriter, r *http.Request, id string) {
+	fields, err := c.rdb.HGetAll(r.Context(), recomputeStatusKey(id)).Result()
+	if err != nil {
+		log.Printf("Error reading recompute %s: %v", id, err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	if len(fields) == 0 {
+		http.Error(w, "unknown recompute", http.StatusNotFound)
+		return
+	}
+	fields["recompute_id"] = id
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(fields)
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+
+	topkv1 "github.com/system-design-lab/pkg/gen/topk/v1"
+	"github.com/system-design-lab/pkg/grpcutil"
+	"github.com/system-design-lab/pkg/storage"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// snapshotServer is the SnapshotReader (proto/topk/v1/snapshot.proto) on
+// GRPC_ADDR: the materializer's snapshots for other services, with what the
+// HTTP routes hide (taken-down songs, soft-deleted users) left out too
+type snapshotServer struct {
+	topkv1.UnimplementedSnapshotReaderServer
+}
+
+// checkSnapshotRequest validates a window and k as the HTTP routes do
+func checkSnapshotRequest(windowDays, k int32) error {
+	switch {
+	case windowDays < 1 || windowDays > 30:
+		return status.Errorf(codes.InvalidArgument, "window_days %d out of range (1-30)", windowDays)
+	case k < 0:
+		return status.Errorf(codes.InvalidArgument, "negative k %d", k)
+	}
+	return nil
+}
+
+func (snapshotServer) GetSnapshot(ctx context.Context, req *topkv1.GetSnapshotRequest) (*topkv1.Snapshot, error) {
+	if req.UserId == "" {
+		return nil, status.Error(codes.InvalidArgument, "user_id is required")
+	}
+	if err := checkSnapshotRequest(req.WindowDays, req.K); err != nil {
+		return nil, err
+	}
+	if userDeleted(req.UserId) {
+		return nil, status.Errorf(codes.NotFound, "user %s is deleted", req.UserId)
+	}
+	snap, ok, err := snapshots.Get(ctx, req.UserId, int(req.WindowDays))
+	if err != nil {
+		return nil, grpcutil.StoreError(ctx, err)
+	}
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "no %d-day snapshot of user %s", req.WindowDays, req.UserId)
+	}
+	return snapshotProto(snap, int(req.K)), nil
+}
+
+func (snapshotServer) ListSnapshots(req *topkv1.ListSnapshotsRequest, stream topkv1.SnapshotReader_ListSnapshotsServer) error {
+	if err := checkSnapshotRequest(req.WindowDays, req.K); err != nil {
+		return err
+	}
+	ctx := stream.Context()
+	var sendErr error
+	err := snapshots.Scan(ctx, int(req.WindowDays), func(snap storage.Snapshot) error {
+		if userDeleted(snap.UserID) {
+			return nil
+		}
+		sendErr = stream.Send(snapshotProto(snap, int(req.K)))
+		return sendErr
+	})
+	switch {
+	case sendErr != nil:
+		return sendErr
+	case err != nil:
+		return grpcutil.StoreError(ctx, err)
+	}
+	return nil
+}
+
+// snapshotProto keeps the first k songs that aren't taken down (0 = all).
+// A snapshot computed before today (UTC) is stale: its window has moved on.
+func snapshotProto(snap storage.Snapshot, k int) *topkv1.Snapshot {
+	out := &topkv1.Snapshot{
+		UserId:     snap.UserID,
+		WindowDays: int32(snap.WindowDays),
+		ComputedAt: timestamppb.New(snap.ComputedAt),
+		Stale:      snap.ComputedAt.UTC().Format(storage.DayFormat) != storage.LastDays(1)[0],
+	}
+	for _, sc := range snap.Songs {
+		if k > 0 && len(out.Songs) == k {
+			break
+		}
+		if takedowns.Has(sc.SongID) {
+			metricTakedownsFiltered.Add(1)
+			continue
+		}
+		out.Songs = append(out.Songs, &topkv1.SongCount{SongId: sc.SongID, Count: sc.Count})
+	}
+	return out
+}
+
+// userDeleted reports whether the crawl-scheduler soft-deleted the user
+func userDeleted(userID string) bool {
+	if deletedUsers == nil {
+		return false
+	}
+	_, ok := deletedUsers.Deleted(userID)
+	return ok
+}
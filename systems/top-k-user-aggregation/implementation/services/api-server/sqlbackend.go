@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/system-design-lab/pkg/sqlstore"
+	"github.com/system-design-lab/pkg/storage"
+)
+
+// errNotStored is a read of a table the SQL backend doesn't keep
+var errNotStored = errors.New("not kept with STORAGE_BACKEND=postgres or sqlite")
+
+// installSQL points the handlers' readers at a STORAGE_BACKEND=postgres or
+// sqlite database (pkg/sqlstore): daily counts, user totals, snapshots and
+// history. Hourly, artist, tag and song reads answer 501; like -demo there
+// are no ranked lists, year reviews, playlists, takedowns or experiments.
+func installSQL(db *sqlstore.DB) {
+	daily := sqlstore.NewDailyTopKRepo(db)
+	dailyTopK = coalescedDailyTopK{daily}
+	filteredTopK = daily
+	userTotals = sqlstore.NewUserTotalsRepo(db)
+	snapshots = sqlstore.NewSnapshotRepo(db)
+	listenHistory = sqlstore.NewListenHistoryRepo(db)
+
+	hourlyTopK = sqlNoHourly{}
+	artistTopK = sqlNoArtists{}
+	tagTopK = sqlNoTags{}
+	songStats = sqlNoSongStats{}
+	rankedLists = demoNoSnapshots{}
+	yearReviews = demoNoReviews{}
+	playlists = demoNoPlaylists{}
+	takedowns = storage.NewTakedownSet(nil)
+	// experiment= answers 404
+	experiments = storage.NewExperimentSet(nil)
+}
+
+// writeNotStored answers 501 to a read the backend can't serve, reporting
+// whether err was one
+func writeNotStored(w http.ResponseWriter, err error) bool {
+	if !errors.Is(err, errNotStored) {
+		return false
+	}
+	http.Error(w, err.Error(), http.StatusNotImplemented)
+	return true
+}
+
+type (
+	sqlNoHourly    struct{}
+	sqlNoArtists   struct{}
+	sqlNoTags      struct{}
+	sqlNoSongStats struct{}
+)
+
+func (sqlNoHourly) SumCounts(context.Context, string, []storage.HourSpan) (map[string]int64, error) {
+	return nil, errNotStored
+}
+
+func (sqlNoHourly) SumTotals(context.Context, string, []storage.HourSpan) (map[string]storage.SongTotals, error) {
+	return nil, errNotStored
+}
+
+func (sqlNoArtists) SumCounts(context.Context, string, []string) (map[string]int64, error) {
+	return nil, errNotStored
+}
+
+func (sqlNoTags) SumCounts(context.Context, string, string, []string) (map[string]int64, error) {
+	return nil, errNotStored
+}
+
+func (sqlNoSongStats) Days(context.Context, string, []string) ([]storage.SongDay, error) {
+	return nil, errNotStored
+}
+
+func (sqlNoSongStats) DailyListens(context.Context, string, string, string) (map[string]int64, error) {
+	return nil, errNotStored
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+
+	"github.com/system-design-lab/pkg/storage"
+)
+
+// The reads the handlers make. The storage repos implement them over
+// Cassandra; -demo swaps in the in-memory demoStore (demo.go), and
+// STORAGE_BACKEND the sqlstore repos (sqlbackend.go).
+type (
+	dailyTopKReader interface {
+		SumCounts(ctx context.Context, userID string, days []string) (map[string]int64, error)
+		SumTotals(ctx context.Context, userID string, days []string) (map[string]storage.SongTotals, error)
+	}
+	hourlyTopKReader interface {
+		SumCounts(ctx context.Context, userID string, spans []storage.HourSpan) (map[string]int64, error)
+		SumTotals(ctx context.Context, userID string, spans []storage.HourSpan) (map[string]storage.SongTotals, error)
+	}
+	artistTopKReader interface {
+		SumCounts(ctx context.Context, userID string, days []string) (map[string]int64, error)
+	}
+	tagTopKReader interface {
+		SumCounts(ctx context.Context, userID, kind string, days []string) (map[string]int64, error)
+	}
+	yearReviewReader interface {
+		Get(ctx context.Context, userID string, year int) (doc []byte, ok bool, err error)
+	}
+	userTotalsReader interface {
+		Range(ctx context.Context, userID, from, to string) (map[string]int64, error)
+	}
+	snapshotReader interface {
+		Get(ctx context.Context, userID string, windowDays int) (storage.Snapshot, bool, error)
+		Scan(ctx context.Context, windowDays int, fn func(storage.Snapshot) error) error
+	}
+	rankedReader interface {
+		Page(ctx context.Context, userID string, windowDays int, version int64, after, limit int) ([]storage.SongCount, error)
+	}
+	experimentTopKReader interface {
+		SumCounts(ctx context.Context, userID, experiment string, days []string) (map[string]int64, error)
+	}
+	filteredTopKReader interface {
+		SumCountsOf(ctx context.Context, userID string, days, songIDs []string) (map[string]int64, error)
+	}
+	playlistReader interface {
+		Get(ctx context.Context, playlistID string) (storage.Playlist, bool, error)
+	}
+	historyReader interface {
+		ScanDay(ctx context.Context, userID, day string, fn func(storage.HistoryRow) error) error
+	}
+	songStatsReader interface {
+		Days(ctx context.Context, songID string, days []string) ([]storage.SongDay, error)
+		DailyListens(ctx context.Context, songID, from, to string) (map[string]int64, error)
+	}
+)
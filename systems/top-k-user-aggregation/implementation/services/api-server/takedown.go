@@ -0,0 +1,52 @@
+package main
+
+import (
+	"expvar"
+	"fmt"
+
+	"github.com/system-design-lab/pkg/storage"
+)
+
+// takedowns are the songs tools takedown removed. Their counters stay until
+// the aggregator purges them, and snapshots until the materializer rewrites
+// them, so every response listing songs leaves them out meanwhile.
+var takedowns *storage.TakedownSet
+
+var metricTakedownsFiltered = expvar.NewInt("takedowns_filtered") // songs left out of responses
+
+// withoutTakedowns returns counts without the taken-down songs. It copies
+// only when there is one to drop: readers' maps may be shared by coalesced
+// requests.
+func withoutTakedowns[V any](counts map[string]V) map[string]V {
+	if takedowns.Len() == 0 {
+		return counts
+	}
+	found := false
+	for song := range counts {
+		if takedowns.Has(song) {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return counts
+	}
+	out := make(map[string]V, len(counts))
+	for song, v := range counts {
+		if takedowns.Has(song) {
+			metricTakedownsFiltered.Add(1)
+			continue
+		}
+		out[song] = v
+	}
+	return out
+}
+
+// songsKeyPrefix prefixes the cache keys of responses listing songs. It
+// changes with every takedown, so none is served from before it.
+func songsKeyPrefix() string {
+	if v := takedowns.Version(); v > 0 {
+		return fmt.Sprintf("%std%d:", cachePrefix, v)
+	}
+	return cachePrefix
+}
@@ -0,0 +1,320 @@
+// Command cachestorm measures api-server tail latency when its response
+// cache stops helping. It runs phases against GET /users/{id}/topk:
+//
+//	warm    workers loop over a few cached keys: the baseline of hits
+//	herd    every worker asks for the same cold key at once, round after
+//	        round: a thundering herd, which coalescing should turn into one read
+//	spread  every request is a key nobody asked for yet: a miss storm, each
+//	        one a read against the store and the read budget
+//
+// Cold keys are the warm keys with a fresh min_count, which is part of the
+// cache key but not of the read: every miss sums the same days a real one
+// would, only the response is emptier. Hits and misses are told apart by
+// X-Cache. The server's /debug/vars give what it did in each phase: cache
+// misses, coalesced reads and budget waits and rejections.
+//
+//	cachestorm                                    against localhost:8080
+//	cachestorm -url http://api-server:8081 -users 10000 -user-prefix loaduser- -concurrency 256
+//	cachestorm -phases herd -rounds 50 -out results/cachestorm.json
+//
+// api-server -demo (no Cassandra) serves it too, for a quick run.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/system-design-lab/benchmarks/internal/bench"
+)
+
+// Params are the flags of a run
+type Params struct {
+	URL         string   `json:"url"`
+	Phases      []string `json:"phases"`
+	Concurrency int      `json:"concurrency"`
+	Duration    string   `json:"duration"` // of warm and spread
+	Rounds      int      `json:"rounds"`   // of herd
+	Users       int      `json:"users"`
+	UserPrefix  string   `json:"user_prefix"`
+	HotKeys     int      `json:"hot_keys"`
+	Days        int      `json:"days"`
+	K           int      `json:"k"`
+}
+
+// Result is one phase
+type Result struct {
+	Phase       string           `json:"phase"`
+	Requests    int64            `json:"requests"`
+	ElapsedS    float64          `json:"elapsed_s"`
+	RequestsSec float64          `json:"requests_per_sec"`
+	Latency     bench.Latency    `json:"latency"`    // every request
+	LatencyOK   bench.Latency    `json:"latency_ok"` // 200s only: budget rejections answer fast
+	Hits        int64            `json:"hits"`       // X-Cache: HIT
+	Misses      int64            `json:"misses"`     // X-Cache: MISS
+	Status      map[string]int64 `json:"status"`     // by HTTP status; "error" = no response
+	Server      map[string]int64 `json:"server,omitempty"`
+}
+
+// serverVars are the api-server counters reported per phase
+var serverVars = []string{
+	"cache_hits",
+	"cache_misses",
+	"cache_errors",
+	"coalesced_reads.response",
+	"query_cost_queued",
+	"query_budget_rejected.client_budget",
+	"query_budget_rejected.query_budget",
+}
+
+func main() {
+	baseURL := flag.String("url", getEnv("API_URL", "http://localhost:8080"), "api-server base URL (API_URL)")
+	phases := flag.String("phases", "warm,herd,spread", "phases to run, in order")
+	concurrency := flag.Int("concurrency", 64, "concurrent clients")
+	duration := flag.Duration("duration", 15*time.Second, "length of the warm and spread phases")
+	rounds := flag.Int("rounds", 20, "herd rounds, one cold key each")
+	users := flag.Int("users", 20, "users requested (api-server -demo has user-0 to user-19, DEMO_USERS)")
+	userPrefix := flag.String("user-prefix", "user-", "user IDs are this and a number (loadgen's are loaduser-)")
+	hotKeys := flag.Int("hot-keys", 10, "keys the warm phase loops over")
+	days := flag.Int("days", 7, "window of every request (1-30)")
+	k := flag.Int("k", 10, "k of every request (1-100)")
+	timeout := flag.Duration("timeout", 10*time.Second, "per request")
+	out := flag.String("out", "-", "result file (- = stdout)")
+	flag.Parse()
+
+	params := Params{
+		URL:         strings.TrimSuffix(*baseURL, "/"),
+		Phases:      bench.ParseList(*phases),
+		Concurrency: *concurrency,
+		Duration:    duration.String(),
+		Rounds:      *rounds,
+		Users:       *users,
+		UserPrefix:  *userPrefix,
+		HotKeys:     *hotKeys,
+		Days:        *days,
+		K:           *k,
+	}
+	switch {
+	case *concurrency < 1 || *rounds < 1 || *users < 1 || *hotKeys < 1:
+		log.Fatalf("-concurrency, -rounds, -users and -hot-keys must be >= 1")
+	case *days < 1 || *days > 30 || *k < 1 || *k > 100:
+		log.Fatalf("-days must be 1-30 and -k 1-100, as the API takes them")
+	}
+
+	s := &storm{
+		p: params,
+		client: &http.Client{
+			Timeout:   *timeout,
+			Transport: &http.Transport{MaxIdleConns: *concurrency, MaxIdleConnsPerHost: *concurrency},
+		},
+		// Unique across runs, so no cold key was cached by an earlier one
+		minCount: time.Now().UnixMicro(),
+	}
+	if err := s.check(); err != nil {
+		log.Fatalf("api-server %s: %v", params.URL, err)
+	}
+
+	run := bench.NewRun("cachestorm", params)
+	var results []Result
+	for _, phase := range params.Phases {
+		var r Result
+		var hot []string
+		if phase == "warm" {
+			// Before the counters are read: priming isn't the phase
+			hot = s.prime()
+		}
+		before := s.serverVars()
+		switch phase {
+		case "warm":
+			r = s.warm(hot, *duration)
+		case "herd":
+			r = s.herd()
+		case "spread":
+			r = s.spread(*duration)
+		default:
+			log.Fatalf("Unknown phase %q (want warm, herd or spread)", phase)
+		}
+		if after := s.serverVars(); before != nil && after != nil {
+			r.Server = bench.Delta(before, after, serverVars...)
+		}
+		log.Printf("%-6s %d requests, %.0f/s, p50=%.2fms p99=%.2fms (200s: %.2fms) max=%.2fms, %d hits %d misses, status %v",
+			phase, r.Requests, r.RequestsSec, r.Latency.P50Ms, r.Latency.P99Ms, r.LatencyOK.P99Ms, r.Latency.MaxMs, r.Hits, r.Misses, r.Status)
+		results = append(results, r)
+	}
+
+	run.Note("cold keys vary min_count over %d users' days=%d k=%d windows; responses of misses are emptier than real ones", params.Users, params.Days, params.K)
+	if err := run.Write(*out, results); err != nil {
+		log.Fatalf("Write results: %v", err)
+	}
+}
+
+type storm struct {
+	p        Params
+	client   *http.Client
+	minCount int64 // next cold key's min_count
+}
+
+// tally collects a phase's requests
+type tally struct {
+	rec, ok      bench.Recorder
+	hits, misses atomic.Int64
+	mu           sync.Mutex
+	status       map[string]int64
+}
+
+func (s *storm) url(user int, minCount int64) string {
+	u := fmt.Sprintf("%s/users/%s%d/topk?days=%d&k=%d", s.p.URL, s.p.UserPrefix, user, s.p.Days, s.p.K)
+	if minCount > 0 {
+		u += fmt.Sprintf("&min_count=%d", minCount)
+	}
+	return u
+}
+
+// coldURL is a key no one has asked for, of a random user
+func (s *storm) coldURL(rng *rand.Rand) string {
+	return s.url(rng.Intn(s.p.Users), atomic.AddInt64(&s.minCount, 1))
+}
+
+// get requests u and records it in t
+func (s *storm) get(t *tally, u string) {
+	start := time.Now()
+	resp, err := s.client.Get(u)
+	code := "error"
+	if err == nil {
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		code = fmt.Sprint(resp.StatusCode)
+		if resp.StatusCode == http.StatusOK {
+			t.ok.Since(start)
+		}
+		switch resp.Header.Get("X-Cache") {
+		case "HIT":
+			t.hits.Add(1)
+		case "MISS":
+			t.misses.Add(1)
+		}
+	}
+	t.rec.Since(start)
+	t.mu.Lock()
+	t.status[code]++
+	t.mu.Unlock()
+}
+
+func (s *storm) result(phase string, t *tally, elapsed time.Duration) Result {
+	lat := t.rec.Summary()
+	return Result{
+		Phase:       phase,
+		Requests:    int64(lat.Count),
+		ElapsedS:    bench.Seconds(elapsed),
+		RequestsSec: bench.Rate(int64(lat.Count), elapsed),
+		Latency:     lat,
+		LatencyOK:   t.ok.Summary(),
+		Hits:        t.hits.Load(),
+		Misses:      t.misses.Load(),
+		Status:      t.status,
+	}
+}
+
+// prime caches the hot keys
+func (s *storm) prime() []string {
+	hot := make([]string, s.p.HotKeys)
+	for i := range hot {
+		hot[i] = s.url(i%s.p.Users, 0)
+		s.get(&tally{status: make(map[string]int64)}, hot[i])
+	}
+	return hot
+}
+
+// warm loops over the hot keys
+func (s *storm) warm(hot []string, d time.Duration) Result {
+	return s.loop("warm", d, func(rng *rand.Rand) string { return hot[rng.Intn(len(hot))] })
+}
+
+// spread sends only cold keys
+func (s *storm) spread(d time.Duration) Result {
+	return s.loop("spread", d, s.coldURL)
+}
+
+// loop runs Concurrency workers requesting next() until d has passed
+func (s *storm) loop(phase string, d time.Duration, next func(*rand.Rand) string) Result {
+	t := &tally{status: make(map[string]int64)}
+	ctx, cancel := context.WithTimeout(context.Background(), d)
+	defer cancel()
+	var wg sync.WaitGroup
+	start := time.Now()
+	for w := 0; w < s.p.Concurrency; w++ {
+		wg.Add(1)
+		go func(seed int64) {
+			defer wg.Done()
+			rng := rand.New(rand.NewSource(seed))
+			for ctx.Err() == nil {
+				s.get(t, next(rng))
+			}
+		}(start.UnixNano() + int64(w))
+	}
+	wg.Wait()
+	return s.result(phase, t, time.Since(start))
+}
+
+// herd releases every worker on one cold key at once, Rounds times
+func (s *storm) herd() Result {
+	t := &tally{status: make(map[string]int64)}
+	rng := rand.New(rand.NewSource(time.Now().UnixNano()))
+	start := time.Now()
+	for round := 0; round < s.p.Rounds; round++ {
+		u := s.coldURL(rng)
+		release := make(chan struct{})
+		var wg sync.WaitGroup
+		for w := 0; w < s.p.Concurrency; w++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				<-release
+				s.get(t, u)
+			}()
+		}
+		close(release)
+		wg.Wait()
+	}
+	return s.result("herd", t, time.Since(start))
+}
+
+// check makes sure the server answers a request before the storm starts
+func (s *storm) check() error {
+	resp, err := s.client.Get(s.url(0, 0))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: HTTP %d", s.url(0, 0), resp.StatusCode)
+	}
+	return nil
+}
+
+// serverVars reads the server's counters; nil when /debug/vars doesn't answer
+func (s *storm) serverVars() map[string]int64 {
+	vars, err := bench.ReadVars(s.p.URL + "/debug/vars")
+	if err != nil {
+		log.Printf("Warning: %v", err)
+		return nil
+	}
+	return vars
+}
+
+func getEnv(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
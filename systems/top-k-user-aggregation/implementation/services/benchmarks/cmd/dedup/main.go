@@ -0,0 +1,323 @@
+// Command dedup measures what the aggregator's bloom filter dedup costs
+// against exact alternatives, on a live Redis. Each method sees the same
+// stream of event IDs, a fraction of them re-sent, from concurrent workers:
+//
+//	bloom  BF.ADD into one filter per day, reserved like the aggregator's
+//	       (capacity, error rate, NONSCALING); needs RedisBloom
+//	set    SADD into one set per day: exact, memory grows with every ID
+//	setnx  SET id NX EX per event: exact, one key (and TTL) per ID
+//
+// It reports throughput, per-call latency, the memory used per ID and the
+// mistakes: re-sends let through and first sends dropped as duplicates
+// (false positives, bloom only). Keys go under bench:dedup:<run> and are
+// removed afterwards unless -keep.
+//
+//	dedup                                 all methods, 1M events
+//	dedup -methods bloom,set -events 10000000 -workers 16
+//	dedup -batch 100 -out results/dedup.json
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/system-design-lab/benchmarks/internal/bench"
+)
+
+// Params are the flags of a run
+type Params struct {
+	Methods   []string `json:"methods"`
+	Events    int      `json:"events"`    // IDs sent, re-sends included
+	DupRatio  float64  `json:"dup_ratio"` // fraction that re-send an earlier ID
+	Workers   int      `json:"workers"`
+	Batch     int      `json:"batch"` // IDs per round trip; the aggregator sends 1
+	Capacity  int64    `json:"bloom_capacity"`
+	ErrorRate float64  `json:"bloom_error_rate"`
+	Seed      int64    `json:"seed"`
+}
+
+// Result is one method's run
+type Result struct {
+	Method         string        `json:"method"`
+	Events         int64         `json:"events"`
+	Unique         int64         `json:"unique"`
+	ElapsedS       float64       `json:"elapsed_s"`
+	EventsPerSec   float64       `json:"events_per_sec"`
+	Latency        bench.Latency `json:"latency"`         // per round trip
+	FalsePositives int64         `json:"false_positives"` // first sends reported as seen
+	FalseNegatives int64         `json:"false_negatives"` // re-sends reported as new
+	FPRate         float64       `json:"false_positive_rate"`
+	MemoryBytes    int64         `json:"memory_bytes"` // MEMORY USAGE, or used_memory growth for setnx
+	BytesPerID     float64       `json:"bytes_per_id"`
+	Errors         int64         `json:"errors"`
+	Skipped        string        `json:"skipped,omitempty"`
+}
+
+// sent is one ID of a worker's stream
+type sent struct {
+	id  string
+	dup bool // an earlier ID of the same worker, so it is seen first
+}
+
+func main() {
+	addr := flag.String("redis", getEnv("REDIS_ADDR", "localhost:6379"), "Redis address (REDIS_ADDR)")
+	methods := flag.String("methods", "bloom,set,setnx", "methods to run, in order")
+	events := flag.Int("events", 1_000_000, "IDs sent per method, re-sends included")
+	dupRatio := flag.Float64("dup-ratio", 0.05, "fraction of sends that repeat an earlier ID")
+	workers := flag.Int("workers", 8, "concurrent clients")
+	batch := flag.Int("batch", 1, "IDs pipelined per round trip")
+	capacity := flag.Int64("capacity", 10_000_000, "bloom capacity (the aggregator's is 10M a day)")
+	errorRate := flag.Float64("error-rate", 0.001, "bloom false-positive rate (the aggregator's is 0.1%)")
+	ttl := flag.Duration("ttl", time.Hour, "TTL of setnx keys")
+	seed := flag.Int64("seed", 1, "random seed of the ID stream")
+	keep := flag.Bool("keep", false, "leave the keys in Redis")
+	out := flag.String("out", "-", "result file (- = stdout)")
+	flag.Parse()
+
+	params := Params{
+		Methods:   bench.ParseList(*methods),
+		Events:    *events,
+		DupRatio:  *dupRatio,
+		Workers:   *workers,
+		Batch:     *batch,
+		Capacity:  *capacity,
+		ErrorRate: *errorRate,
+		Seed:      *seed,
+	}
+	switch {
+	case *events < 1 || *workers < 1 || *batch < 1:
+		log.Fatalf("-events, -workers and -batch must be >= 1")
+	case *dupRatio < 0 || *dupRatio >= 1:
+		log.Fatalf("-dup-ratio must be in [0, 1)")
+	}
+
+	rdb := redis.NewClient(&redis.Options{Addr: *addr, PoolSize: *workers * 2})
+	defer rdb.Close()
+	ctx := context.Background()
+	if err := rdb.Ping(ctx).Err(); err != nil {
+		log.Fatalf("Redis %s: %v", *addr, err)
+	}
+
+	run := bench.NewRun("dedup", params)
+	streams, unique := makeStreams(params)
+	var results []Result
+	for _, m := range params.Methods {
+		prefix := fmt.Sprintf("bench:dedup:%x:%s", time.Now().UnixNano(), m)
+		d, err := newDeduper(ctx, rdb, m, prefix, params, *ttl)
+		if err != nil {
+			log.Printf("Warning: skipping %s: %v", m, err)
+			results = append(results, Result{Method: m, Skipped: err.Error()})
+			continue
+		}
+		before := usedMemory(ctx, rdb)
+		r := runMethod(ctx, d, streams, params.Batch)
+		r.Unique = unique
+		if r.MemoryBytes = d.memory(ctx); r.MemoryBytes == 0 {
+			r.MemoryBytes = usedMemory(ctx, rdb) - before
+		}
+		if unique > 0 {
+			r.BytesPerID = float64(r.MemoryBytes) / float64(unique)
+			r.FPRate = float64(r.FalsePositives) / float64(unique)
+		}
+		log.Printf("%-6s %.0f events/s p99=%.3fms memory=%d (%.1f B/ID) fp=%d fn=%d errors=%d",
+			m, r.EventsPerSec, r.Latency.P99Ms, r.MemoryBytes, r.BytesPerID, r.FalsePositives, r.FalseNegatives, r.Errors)
+		results = append(results, r)
+		if !*keep {
+			if err := d.cleanup(ctx); err != nil {
+				log.Printf("Warning: failed to remove %s keys: %v", prefix, err)
+			}
+		}
+	}
+
+	run.Note("redis %s; one filter or set per run stands in for the aggregator's per-day key", *addr)
+	run.Note("the aggregator wraps BF.ADD in a Lua script over the day's shards (bloomshards.go); with one shard that adds a GET")
+	if err := run.Write(*out, results); err != nil {
+		log.Fatalf("Write results: %v", err)
+	}
+}
+
+// makeStreams splits Events over the workers. Re-sends repeat an ID of the
+// same worker, so each is seen after its first send.
+func makeStreams(p Params) ([][]sent, int64) {
+	rng := rand.New(rand.NewSource(p.Seed))
+	streams := make([][]sent, p.Workers)
+	var unique int64
+	for i := 0; i < p.Events; i++ {
+		w := i % p.Workers
+		s := streams[w]
+		if len(s) > 0 && rng.Float64() < p.DupRatio {
+			streams[w] = append(s, sent{id: s[rng.Intn(len(s))].id, dup: true})
+			continue
+		}
+		streams[w] = append(s, sent{id: "evt-" + strconv.FormatInt(rng.Int63(), 36)})
+		unique++
+	}
+	return streams, unique
+}
+
+func runMethod(ctx context.Context, d *deduper, streams [][]sent, batch int) Result {
+	var (
+		rec                  bench.Recorder
+		fp, fn, errs, events atomic.Int64
+		wg                   sync.WaitGroup
+	)
+	start := time.Now()
+	for _, stream := range streams {
+		wg.Add(1)
+		go func(stream []sent) {
+			defer wg.Done()
+			for i := 0; i < len(stream); i += batch {
+				chunk := stream[i:min(i+batch, len(stream))]
+				t := time.Now()
+				added, err := d.add(ctx, chunk)
+				rec.Since(t)
+				if err != nil {
+					errs.Add(int64(len(chunk)))
+					continue
+				}
+				events.Add(int64(len(chunk)))
+				for j, s := range chunk {
+					switch {
+					case s.dup && added[j]:
+						fn.Add(1)
+					case !s.dup && !added[j]:
+						fp.Add(1)
+					}
+				}
+			}
+		}(stream)
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	return Result{
+		Method:         d.method,
+		Events:         events.Load(),
+		ElapsedS:       bench.Seconds(elapsed),
+		EventsPerSec:   bench.Rate(events.Load(), elapsed),
+		Latency:        rec.Summary(),
+		FalsePositives: fp.Load(),
+		FalseNegatives: fn.Load(),
+		Errors:         errs.Load(),
+	}
+}
+
+// deduper is one method's keys
+type deduper struct {
+	method string
+	rdb    *redis.Client
+	prefix string
+	ttl    time.Duration
+}
+
+func newDeduper(ctx context.Context, rdb *redis.Client, method, prefix string, p Params, ttl time.Duration) (*deduper, error) {
+	d := &deduper{method: method, rdb: rdb, prefix: prefix, ttl: ttl}
+	switch method {
+	case "bloom":
+		// As the aggregator reserves a day's filter (reserveBloom)
+		if err := rdb.Do(ctx, "BF.RESERVE", prefix, p.ErrorRate, p.Capacity, "NONSCALING").Err(); err != nil {
+			return nil, fmt.Errorf("BF.RESERVE (is RedisBloom loaded?): %w", err)
+		}
+	case "set", "setnx":
+	default:
+		return nil, fmt.Errorf("unknown method (want bloom, set or setnx)")
+	}
+	return d, nil
+}
+
+// add sends ids in one round trip and reports, per ID, whether it was new
+func (d *deduper) add(ctx context.Context, ids []sent) ([]bool, error) {
+	pipe := d.rdb.Pipeline()
+	bools := make([]*redis.BoolCmd, len(ids))
+	ints := make([]*redis.IntCmd, len(ids))
+	for i, s := range ids {
+		switch d.method {
+		case "bloom":
+			bools[i] = pipe.BFAdd(ctx, d.prefix, s.id)
+		case "set":
+			ints[i] = pipe.SAdd(ctx, d.prefix, s.id)
+		case "setnx":
+			bools[i] = pipe.SetNX(ctx, d.prefix+":"+s.id, 1, d.ttl)
+		}
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, err
+	}
+	added := make([]bool, len(ids))
+	for i := range ids {
+		if ints[i] != nil {
+			added[i] = ints[i].Val() == 1
+		} else {
+			added[i] = bools[i].Val()
+		}
+	}
+	return added, nil
+}
+
+// memory is the size of the method's one key; 0 for setnx, whose keys are
+// measured as Redis' growth instead
+func (d *deduper) memory(ctx context.Context) int64 {
+	if d.method == "setnx" {
+		return 0
+	}
+	// SAMPLES 0: every member of the set, not an estimate
+	n, err := d.rdb.MemoryUsage(ctx, d.prefix, 0).Result()
+	if err != nil {
+		log.Printf("Warning: MEMORY USAGE %s: %v", d.prefix, err)
+	}
+	return n
+}
+
+func (d *deduper) cleanup(ctx context.Context) error {
+	if d.method != "setnx" {
+		return d.rdb.Unlink(ctx, d.prefix).Err()
+	}
+	iter := d.rdb.Scan(ctx, 0, d.prefix+":*", 10_000).Iterator()
+	var keys []string
+	for iter.Next(ctx) {
+		if keys = append(keys, iter.Val()); len(keys) == 10_000 {
+			if err := d.rdb.Unlink(ctx, keys...).Err(); err != nil {
+				return err
+			}
+			keys = keys[:0]
+		}
+	}
+	if len(keys) > 0 {
+		if err := d.rdb.Unlink(ctx, keys...).Err(); err != nil {
+			return err
+		}
+	}
+	return iter.Err()
+}
+
+// usedMemory is INFO memory's used_memory, 0 when it can't be read
+func usedMemory(ctx context.Context, rdb *redis.Client) int64 {
+	info, err := rdb.Info(ctx, "memory").Result()
+	if err != nil {
+		return 0
+	}
+	for _, line := range strings.Split(info, "\r\n") {
+		if v, ok := strings.CutPrefix(line, "used_memory:"); ok {
+			n, _ := strconv.ParseInt(v, 10, 64)
+			return n
+		}
+	}
+	return 0
+}
+
+func getEnv(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
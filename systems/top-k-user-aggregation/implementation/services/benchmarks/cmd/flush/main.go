@@ -0,0 +1,334 @@
+// Command flush measures aggregator throughput against its flush interval on
+// a running stack. For each interval it sets the aggregator's flush_interval
+// (pkg/runtimecfg, no restart), waits for the group to be caught up,
+// produces a burst of listens to the raw topic and times how long the group
+// takes to commit them all. With the aggregator's default commit-after-write
+// strategy, a flush commits every partition it read from once its writes are
+// done, so the interval is part of the drain: longer ones read as slower here
+// but make fewer counter writes per event, both reported.
+//
+// The aggregators' /debug/vars (-metrics, one URL per instance, comma
+// separated) give the flushes and counter writes behind each run; without
+// them only the Kafka side is reported. The flush_interval set before the
+// run is put back at the end.
+//
+//	flush                                       1s, 5s, 15s and 30s
+//	flush -intervals 2s,10s -events 500000 -rate 20000
+//	flush -metrics http://aggregator:9103/debug/vars -out /results/flush.json
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"math/rand"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/segmentio/kafka-go"
+	"github.com/system-design-lab/benchmarks/internal/bench"
+	"github.com/system-design-lab/pkg/events"
+	"github.com/system-design-lab/pkg/kafkautil"
+	"github.com/system-design-lab/pkg/runtimecfg"
+)
+
+// Params are the flags of a run
+type Params struct {
+	Intervals    []string `json:"flush_intervals"`
+	Events       int      `json:"events"` // per interval
+	Rate         float64  `json:"rate"`   // events/s; 0 = as fast as Kafka takes them
+	Users        int      `json:"users"`
+	Songs        int      `json:"songs"`
+	ZipfS        float64  `json:"zipf_s"`
+	Batch        int      `json:"batch"`
+	Topic        string   `json:"topic"`
+	Group        string   `json:"group"`
+	Metrics      []string `json:"metrics,omitempty"`
+	DrainTimeout string   `json:"drain_timeout"`
+}
+
+// Result is one flush interval's run
+type Result struct {
+	FlushInterval string  `json:"flush_interval"`
+	Events        int64   `json:"events"`
+	ProduceS      float64 `json:"produce_s"`
+	ProducedRate  float64 `json:"produced_per_sec"`
+	DrainS        float64 `json:"drain_s"`      // end of producing -> lag 0
+	EndToEndS     float64 `json:"end_to_end_s"` // first event sent -> lag 0
+	Throughput    float64 `json:"events_per_sec"`
+	PeakLag       int64   `json:"peak_lag"`
+	Drained       bool    `json:"drained"`
+
+	// From the aggregators' metrics; absent without -metrics
+	Flushes         int64   `json:"flushes,omitempty"`
+	CounterWrites   int64   `json:"counter_writes,omitempty"` // aggregates_flushed
+	WritesPerEvent  float64 `json:"writes_per_event,omitempty"`
+	FlushErrors     int64   `json:"flush_errors,omitempty"`
+	LastFlushMillis int64   `json:"last_flush_ms,omitempty"`
+	Error           string  `json:"error,omitempty"`
+}
+
+func main() {
+	intervals := flag.String("intervals", "1s,5s,15s,30s", "flush intervals to try, in order")
+	eventCount := flag.Int("events", 200_000, "listens produced per interval")
+	rate := flag.Float64("rate", 0, "events/s to produce at (0 = unthrottled)")
+	users := flag.Int("users", 10_000, "distinct users")
+	songs := flag.Int("songs", 50_000, "distinct songs")
+	zipfS := flag.Float64("zipf-s", 1.1, "song popularity skew (> 1)")
+	batch := flag.Int("batch", 500, "events per Kafka write")
+	topic := flag.String("topic", getEnv("TOPIC", "user.listen.raw"), "topic the aggregator reads (TOPIC)")
+	group := flag.String("group", getEnv("CONSUMER_GROUP", "aggregator"), "aggregator consumer group (CONSUMER_GROUP)")
+	metrics := flag.String("metrics", getEnv("AGGREGATOR_METRICS", ""), "aggregator /debug/vars URLs, comma separated (AGGREGATOR_METRICS)")
+	redisAddr := flag.String("redis", getEnv("REDIS_ADDR", "localhost:6379"), "Redis of the runtime config (REDIS_ADDR)")
+	drainTimeout := flag.Duration("drain-timeout", 5*time.Minute, "give up on a drain after this long")
+	out := flag.String("out", "-", "result file (- = stdout)")
+	flag.Parse()
+
+	params := Params{
+		Events:       *eventCount,
+		Rate:         *rate,
+		Users:        *users,
+		Songs:        *songs,
+		ZipfS:        *zipfS,
+		Batch:        *batch,
+		Topic:        *topic,
+		Group:        *group,
+		Metrics:      bench.ParseList(*metrics),
+		DrainTimeout: drainTimeout.String(),
+	}
+	durations, err := bench.ParseDurations(*intervals)
+	if err != nil {
+		log.Fatalf("Invalid -intervals: %v", err)
+	}
+	for _, d := range durations {
+		if d <= 0 {
+			log.Fatalf("Invalid -intervals: %s isn't positive", d)
+		}
+		params.Intervals = append(params.Intervals, d.String())
+	}
+	switch {
+	case *eventCount < 1 || *users < 1 || *songs < 2 || *batch < 1:
+		log.Fatalf("-events, -users and -batch must be >= 1, -songs >= 2")
+	case *zipfS <= 1:
+		log.Fatalf("-zipf-s must be > 1")
+	}
+
+	kafkaCfg, err := kafkautil.ConfigFromEnv("localhost:29092")
+	if err != nil {
+		log.Fatalf("Invalid Kafka config: %v", err)
+	}
+	rdb := redis.NewClient(&redis.Options{Addr: *redisAddr})
+	defer rdb.Close()
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	run := bench.NewRun("flush", params)
+	results, err := runIntervals(ctx, rdb, kafkaCfg, params, durations, *drainTimeout)
+	if err != nil {
+		log.Printf("Error: %v", err)
+		run.Note("stopped early: %v", err)
+	}
+	if len(params.Metrics) == 0 {
+		run.Note("no -metrics: flushes and counter writes not measured")
+	}
+	if err := run.Write(*out, results); err != nil {
+		log.Fatalf("Write results: %v", err)
+	}
+}
+
+// runIntervals runs every interval, then puts the aggregator's flush_interval
+// back as it was
+func runIntervals(ctx context.Context, rdb *redis.Client, kafkaCfg kafkautil.Config, p Params, intervals []time.Duration, drainTimeout time.Duration) ([]Result, error) {
+	current, err := runtimecfg.List(ctx, rdb, "aggregator")
+	if err != nil {
+		return nil, fmt.Errorf("read runtime config: %w", err)
+	}
+	previous, wasSet := current["flush_interval"]
+	defer func() {
+		// Also after Ctrl-C
+		restoreCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		var rerr error
+		if wasSet {
+			rerr = runtimecfg.Set(restoreCtx, rdb, "aggregator", "flush_interval", previous)
+		} else {
+			rerr = runtimecfg.Delete(restoreCtx, rdb, "aggregator", "flush_interval")
+		}
+		if rerr != nil {
+			log.Printf("Warning: failed to restore the aggregator's flush_interval: %v", rerr)
+		}
+	}()
+
+	client := kafkaCfg.Client()
+	w := kafkaCfg.NewWriter(p.Topic, kafkautil.WriterConfigFromEnv())
+	defer w.Close()
+	gen := newGenerator(p)
+
+	var results []Result
+	for _, interval := range intervals {
+		// What is already queued would count towards this interval
+		if _, _, err := waitDrained(ctx, client, p.Topic, p.Group, drainTimeout); err != nil {
+			return results, fmt.Errorf("before %s: %w", interval, err)
+		}
+		if err := runtimecfg.Set(ctx, rdb, "aggregator", "flush_interval", interval.String()); err != nil {
+			return results, err
+		}
+		// The aggregators reset their tickers when the change is announced
+		time.Sleep(time.Second)
+
+		r := Result{FlushInterval: interval.String()}
+		before, err := readMetrics(p.Metrics)
+		if err != nil {
+			log.Printf("Warning: %v", err)
+		}
+
+		start := time.Now()
+		r.Events, err = gen.produce(ctx, w, p.Events, p.Rate)
+		produced := time.Now()
+		if err != nil {
+			r.Error = err.Error()
+			results = append(results, r)
+			return results, err
+		}
+		peak, lagZero, err := waitDrained(ctx, client, p.Topic, p.Group, drainTimeout)
+		r.PeakLag = peak
+		if err != nil {
+			r.Error = err.Error()
+		} else {
+			r.Drained = true
+			r.DrainS = bench.Seconds(lagZero.Sub(produced))
+			r.EndToEndS = bench.Seconds(lagZero.Sub(start))
+			r.Throughput = bench.Rate(r.Events, lagZero.Sub(start))
+		}
+		r.ProduceS = bench.Seconds(produced.Sub(start))
+		r.ProducedRate = bench.Rate(r.Events, produced.Sub(start))
+
+		if after, err := readMetrics(p.Metrics); err != nil {
+			log.Printf("Warning: %v", err)
+		} else if before != nil {
+			r.Flushes = after["flushes"] - before["flushes"]
+			r.CounterWrites = after["aggregates_flushed"] - before["aggregates_flushed"]
+			r.FlushErrors = after["flush_errors"] - before["flush_errors"]
+			r.LastFlushMillis = after["last_flush_ms"]
+			if r.Events > 0 {
+				r.WritesPerEvent = float64(r.CounterWrites) / float64(r.Events)
+			}
+		}
+		log.Printf("flush_interval=%-5s %d events: %.0f/s end to end, drain %.1fs, peak lag %d, %d flushes, %.3f writes/event",
+			interval, r.Events, r.Throughput, r.DrainS, r.PeakLag, r.Flushes, r.WritesPerEvent)
+		results = append(results, r)
+		if ctx.Err() != nil {
+			return results, ctx.Err()
+		}
+	}
+	return results, nil
+}
+
+// waitDrained polls the group's lag until it is 0, returning the highest lag
+// seen and when it reached 0
+func waitDrained(ctx context.Context, client *kafka.Client, topic, group string, timeout time.Duration) (int64, time.Time, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	ticker := time.NewTicker(250 * time.Millisecond)
+	defer ticker.Stop()
+	var peak, last int64
+	for {
+		lag, err := kafkautil.GroupLag(ctx, client, topic, group)
+		if err == nil {
+			peak, last = max(peak, lag.Total), lag.Total
+			if lag.Total == 0 {
+				return peak, time.Now(), nil
+			}
+		} else if ctx.Err() == nil {
+			log.Printf("Warning: lag of %s: %v", group, err)
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return peak, time.Time{}, fmt.Errorf("%s not drained after %s (lag %d)", group, timeout, last)
+			}
+			return peak, time.Time{}, ctx.Err()
+		}
+	}
+}
+
+// readMetrics reads the aggregators' metrics; nil without URLs
+func readMetrics(urls []string) (map[string]int64, error) {
+	if len(urls) == 0 {
+		return nil, nil
+	}
+	return bench.ReadVars(urls...)
+}
+
+// generator makes listens shaped like loadgen's: Zipf-popular songs over
+// uniform users, under their own user and provider names
+type generator struct {
+	p     Params
+	rng   *rand.Rand
+	zipf  *rand.Zipf
+	runID string
+	seq   int64
+}
+
+func newGenerator(p Params) *generator {
+	rng := rand.New(rand.NewSource(time.Now().UnixNano()))
+	return &generator{
+		p:     p,
+		rng:   rng,
+		zipf:  rand.NewZipf(rng, p.ZipfS, 1, uint64(p.Songs-1)),
+		runID: fmt.Sprintf("%x", time.Now().UnixNano()),
+	}
+}
+
+// produce writes n listens at rate (0 = unthrottled), returning how many
+// were acked
+func (g *generator) produce(ctx context.Context, w *kafka.Writer, n int, rate float64) (int64, error) {
+	start := time.Now()
+	var sent int64
+	for sent < int64(n) {
+		if ctx.Err() != nil {
+			return sent, ctx.Err()
+		}
+		if rate > 0 {
+			ahead := time.Duration(float64(sent)/rate*float64(time.Second)) - time.Since(start)
+			if ahead > 0 {
+				time.Sleep(ahead)
+			}
+		}
+		batch := make([]kafka.Message, min(g.p.Batch, n-int(sent)))
+		for i := range batch {
+			batch[i] = g.next()
+		}
+		kafkautil.Stamp(batch, kafkautil.Headers{PublishedAt: time.Now()})
+		if err := w.WriteMessages(ctx, batch...); err != nil {
+			return sent, fmt.Errorf("produce: %w", err)
+		}
+		sent += int64(len(batch))
+	}
+	return sent, nil
+}
+
+func (g *generator) next() kafka.Message {
+	g.seq++
+	userID := fmt.Sprintf("benchuser-%d", g.rng.Intn(g.p.Users))
+	song := g.zipf.Uint64()
+	e := events.New(fmt.Sprintf("bench-%s-%d", g.runID, g.seq), userID, fmt.Sprintf("song-%d", song), "benchmark", time.Now())
+	e.ArtistID = fmt.Sprintf("artist-%d", song/10)
+	e.DurationMs = int64(150+(song*37)%150) * 1000
+	data, _ := events.Marshal(e, events.FormatJSON)
+	return kafka.Message{Key: []byte(userID), Value: data}
+}
+
+func getEnv(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
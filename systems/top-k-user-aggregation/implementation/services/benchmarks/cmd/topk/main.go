@@ -0,0 +1,217 @@
+// Command topk compares the two ways the services pick the k highest counts
+// out of a map: sorting every entry and truncating (api-server topCounts,
+// rankByListenTime) against a size-k min-heap (global-charts TopN). It runs
+// in process, needs nothing else up, and writes a bench.Run of ns/op, latency
+// percentiles and allocations per size, k and method.
+//
+//	topk                                  default sizes and k, zipf counts
+//	topk -sizes 1000,1000000 -ks 10       fewer configurations
+//	topk -dist uniform -out results/topk.json
+package main
+
+import (
+	"container/heap"
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"math/rand"
+	"runtime"
+	"sort"
+	"time"
+
+	"github.com/system-design-lab/benchmarks/internal/bench"
+)
+
+// Params are the flags of a run
+type Params struct {
+	Sizes     []int   `json:"sizes"` // distinct songs in the map
+	Ks        []int   `json:"ks"`
+	Dist      string  `json:"dist"`             // zipf or uniform counts
+	ZipfS     float64 `json:"zipf_s,omitempty"` // skew of zipf counts
+	BenchTime string  `json:"bench_time"`       // per configuration and method
+	Seed      int64   `json:"seed"`
+}
+
+// Result is one method on one configuration
+type Result struct {
+	Size        int           `json:"size"`
+	K           int           `json:"k"`
+	Method      string        `json:"method"` // sort or heap
+	Ops         int           `json:"ops"`
+	NsPerOp     int64         `json:"ns_per_op"`
+	AllocsPerOp int64         `json:"allocs_per_op"`
+	BytesPerOp  int64         `json:"bytes_per_op"`
+	Latency     bench.Latency `json:"latency"`
+	SpeedupX    float64       `json:"speedup_vs_sort"` // sort ns/op over this one's
+}
+
+type idCount struct {
+	id    string
+	count int64
+}
+
+func main() {
+	sizes := flag.String("sizes", "100,1000,10000,100000,1000000", "distinct songs per map")
+	ks := flag.String("ks", "10,50,100", "k values (the API allows 1-100)")
+	dist := flag.String("dist", "zipf", "count distribution: zipf or uniform")
+	zipfS := flag.Float64("zipf-s", 1.1, "skew of zipf counts")
+	benchTime := flag.Duration("benchtime", time.Second, "time spent per configuration and method")
+	seed := flag.Int64("seed", 1, "random seed of the counts")
+	out := flag.String("out", "-", "result file (- = stdout)")
+	flag.Parse()
+
+	params := Params{Dist: *dist, BenchTime: benchTime.String(), Seed: *seed}
+	var err error
+	if params.Sizes, err = bench.ParseInts(*sizes); err != nil {
+		log.Fatalf("Invalid -sizes: %v", err)
+	}
+	if params.Ks, err = bench.ParseInts(*ks); err != nil {
+		log.Fatalf("Invalid -ks: %v", err)
+	}
+	switch *dist {
+	case "zipf":
+		params.ZipfS = *zipfS
+	case "uniform":
+	default:
+		log.Fatalf("Invalid -dist %q (want zipf or uniform)", *dist)
+	}
+
+	run := bench.NewRun("topk", params)
+	rng := rand.New(rand.NewSource(*seed))
+	var results []Result
+	for _, size := range params.Sizes {
+		counts := makeCounts(rng, size, params)
+		for _, k := range params.Ks {
+			if !agree(topSort(counts, k), topHeap(counts, k)) {
+				log.Fatalf("sort and heap disagree at size=%d k=%d", size, k)
+			}
+			sorted := measure("sort", counts, k, *benchTime, topSort)
+			heaped := measure("heap", counts, k, *benchTime, topHeap)
+			sorted.SpeedupX = 1
+			if heaped.NsPerOp > 0 {
+				heaped.SpeedupX = math.Round(float64(sorted.NsPerOp)/float64(heaped.NsPerOp)*100) / 100
+			}
+			log.Printf("size=%-8d k=%-4d sort=%s heap=%s (%.2fx)", size, k,
+				time.Duration(sorted.NsPerOp), time.Duration(heaped.NsPerOp), heaped.SpeedupX)
+			results = append(results, sorted, heaped)
+		}
+	}
+
+	run.Note("counts are one map[string]int64 per size, the shape of a user's summed days in api-server")
+	if err := run.Write(*out, results); err != nil {
+		log.Fatalf("Write results: %v", err)
+	}
+}
+
+// makeCounts builds size songs' counts. Zipf counts fall off by rank, with
+// the long tail of ties at small counts a real user's history has.
+func makeCounts(rng *rand.Rand, size int, p Params) map[string]int64 {
+	counts := make(map[string]int64, size)
+	const head = 10_000 // count of the most played song
+	for i := 0; i < size; i++ {
+		var c int64
+		if p.Dist == "zipf" {
+			c = int64(head/math.Pow(float64(i+1), p.ZipfS)) + 1
+		} else {
+			c = rng.Int63n(head) + 1
+		}
+		counts[fmt.Sprintf("song-%d", rng.Int63())] = c
+	}
+	return counts
+}
+
+// measure runs pick until benchTime has passed, at least three times, after
+// one warm-up call
+func measure(method string, counts map[string]int64, k int, benchTime time.Duration, pick func(map[string]int64, int) []idCount) Result {
+	pick(counts, k)
+	runtime.GC()
+
+	var rec bench.Recorder
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	start := time.Now()
+	ops := 0
+	for ops < 3 || time.Since(start) < benchTime {
+		t := time.Now()
+		pick(counts, k)
+		rec.Since(t)
+		ops++
+	}
+	elapsed := time.Since(start)
+	runtime.ReadMemStats(&after)
+
+	return Result{
+		Size:        len(counts),
+		K:           k,
+		Method:      method,
+		Ops:         ops,
+		NsPerOp:     elapsed.Nanoseconds() / int64(ops),
+		AllocsPerOp: int64(after.Mallocs-before.Mallocs) / int64(ops),
+		BytesPerOp:  int64(after.TotalAlloc-before.TotalAlloc) / int64(ops),
+		Latency:     rec.Summary(),
+	}
+}
+
+// topSort is api-server's topCounts: every entry sorted, the first k kept
+func topSort(counts map[string]int64, k int) []idCount {
+	var sorted []idCount
+	for id, count := range counts {
+		sorted = append(sorted, idCount{id, count})
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].count > sorted[j].count
+	})
+	if len(sorted) > k {
+		sorted = sorted[:k]
+	}
+	return sorted
+}
+
+// topHeap keeps the k highest in a min-heap, as global-charts' TopN does,
+// and sorts only those at the end
+func topHeap(counts map[string]int64, k int) []idCount {
+	h := make(minHeap, 0, k)
+	for id, count := range counts {
+		if len(h) < k {
+			heap.Push(&h, idCount{id, count})
+			continue
+		}
+		if count > h[0].count {
+			h[0] = idCount{id, count}
+			heap.Fix(&h, 0)
+		}
+	}
+	out := []idCount(h)
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].count > out[j].count
+	})
+	return out
+}
+
+type minHeap []idCount
+
+func (h minHeap) Len() int           { return len(h) }
+func (h minHeap) Less(i, j int) bool { return h[i].count < h[j].count }
+func (h minHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *minHeap) Push(x any)        { *h = append(*h, x.(idCount)) }
+func (h *minHeap) Pop() any {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+// agree reports whether both picked the same counts; ties may pick different
+// songs
+func agree(a, b []idCount) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].count != b[i].count {
+			return false
+		}
+	}
+	return true
+}
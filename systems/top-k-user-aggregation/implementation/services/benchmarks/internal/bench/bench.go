@@ -0,0 +1,129 @@
+// Package bench is what the harnesses share: the result file each of them
+// writes, latency percentiles and list flags.
+package bench
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FormatVersion is the layout of Run; bumped when a field changes meaning,
+// so writeups comparing files across versions can tell
+const FormatVersion = 1
+
+// Host is where a run happened; numbers only compare on like hosts
+type Host struct {
+	Hostname   string `json:"hostname"`
+	GoVersion  string `json:"go_version"`
+	OS         string `json:"os"`
+	Arch       string `json:"arch"`
+	CPUs       int    `json:"cpus"`
+	GOMAXPROCS int    `json:"gomaxprocs"`
+}
+
+// Run is a harness's result file: what was measured, how, where, and the
+// results, one entry per configuration tried
+type Run struct {
+	Benchmark string      `json:"benchmark"`
+	Version   int         `json:"format_version"`
+	StartedAt time.Time   `json:"started_at"`
+	ElapsedS  float64     `json:"elapsed_s"`
+	Host      Host        `json:"host"`
+	Params    interface{} `json:"params"`
+	Results   interface{} `json:"results"`
+	Notes     []string    `json:"notes,omitempty"`
+}
+
+// NewRun starts the result of benchmark, run with params
+func NewRun(benchmark string, params interface{}) *Run {
+	hostname, _ := os.Hostname()
+	return &Run{
+		Benchmark: benchmark,
+		Version:   FormatVersion,
+		StartedAt: time.Now().UTC(),
+		Host: Host{
+			Hostname:   hostname,
+			GoVersion:  runtime.Version(),
+			OS:         runtime.GOOS,
+			Arch:       runtime.GOARCH,
+			CPUs:       runtime.NumCPU(),
+			GOMAXPROCS: runtime.GOMAXPROCS(0),
+		},
+		Params: params,
+	}
+}
+
+// Note adds a remark for the reader, e.g. a configuration skipped
+func (r *Run) Note(format string, args ...interface{}) {
+	r.Notes = append(r.Notes, fmt.Sprintf(format, args...))
+}
+
+// Write finishes the run with results and writes it as indented JSON to
+// path; "" or "-" is stdout
+func (r *Run) Write(path string, results interface{}) error {
+	r.Results = results
+	r.ElapsedS = round(time.Since(r.StartedAt).Seconds())
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	if path == "" || path == "-" {
+		_, err = os.Stdout.Write(data)
+		return err
+	}
+	return os.WriteFile(path, data, 0o644)
+}
+
+// ParseInts reads a comma-separated list of integers, e.g. a -sizes flag
+func ParseInts(s string) ([]int, error) {
+	var out []int
+	for _, f := range ParseList(s) {
+		n, err := strconv.Atoi(f)
+		if err != nil {
+			return nil, fmt.Errorf("%q isn't an integer", f)
+		}
+		out = append(out, n)
+	}
+	if len(out) == 0 {
+		return nil, fmt.Errorf("empty list")
+	}
+	return out, nil
+}
+
+// ParseDurations reads a comma-separated list of durations ("1s,5s,30s")
+func ParseDurations(s string) ([]time.Duration, error) {
+	var out []time.Duration
+	for _, f := range ParseList(s) {
+		d, err := time.ParseDuration(f)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, d)
+	}
+	if len(out) == 0 {
+		return nil, fmt.Errorf("empty list")
+	}
+	return out, nil
+}
+
+// ParseList splits a comma-separated list, dropping blanks
+func ParseList(s string) []string {
+	var out []string
+	for _, f := range strings.Split(s, ",") {
+		if f = strings.TrimSpace(f); f != "" {
+			out = append(out, f)
+		}
+	}
+	return out
+}
+
+// round keeps three decimals, enough for milliseconds and rates
+func round(f float64) float64 {
+	return float64(int64(f*1000+0.5)) / 1000
+}
@@ -0,0 +1,85 @@
+package bench
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Recorder collects latencies from concurrent workers. Every sample is kept:
+// runs are bounded, and exact percentiles beat a histogram's buckets at p99.
+type Recorder struct {
+	mu      sync.Mutex
+	samples []time.Duration
+}
+
+// Observe records one latency
+func (r *Recorder) Observe(d time.Duration) {
+	r.mu.Lock()
+	r.samples = append(r.samples, d)
+	r.mu.Unlock()
+}
+
+// Since records the time since start
+func (r *Recorder) Since(start time.Time) {
+	r.Observe(time.Since(start))
+}
+
+// Latency summarizes a Recorder, in milliseconds
+type Latency struct {
+	Count  int     `json:"count"`
+	MeanMs float64 `json:"mean_ms"`
+	P50Ms  float64 `json:"p50_ms"`
+	P90Ms  float64 `json:"p90_ms"`
+	P99Ms  float64 `json:"p99_ms"`
+	P999Ms float64 `json:"p999_ms"`
+	MaxMs  float64 `json:"max_ms"`
+}
+
+// Summary computes the percentiles of what was recorded so far
+func (r *Recorder) Summary() Latency {
+	r.mu.Lock()
+	samples := append([]time.Duration(nil), r.samples...)
+	r.mu.Unlock()
+	if len(samples) == 0 {
+		return Latency{}
+	}
+	sort.Slice(samples, func(i, j int) bool { return samples[i] < samples[j] })
+
+	var sum time.Duration
+	for _, d := range samples {
+		sum += d
+	}
+	return Latency{
+		Count:  len(samples),
+		MeanMs: ms(sum / time.Duration(len(samples))),
+		P50Ms:  ms(percentile(samples, 0.50)),
+		P90Ms:  ms(percentile(samples, 0.90)),
+		P99Ms:  ms(percentile(samples, 0.99)),
+		P999Ms: ms(percentile(samples, 0.999)),
+		MaxMs:  ms(samples[len(samples)-1]),
+	}
+}
+
+// percentile is the nearest-rank percentile of sorted samples
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	i := int(float64(len(sorted))*p+0.5) - 1
+	return sorted[min(max(i, 0), len(sorted)-1)]
+}
+
+func ms(d time.Duration) float64 {
+	return round(float64(d) / float64(time.Millisecond))
+}
+
+// Seconds is d in seconds, to the millisecond
+func Seconds(d time.Duration) float64 {
+	return round(d.Seconds())
+}
+
+// Rate is n per second over elapsed
+func Rate(n int64, elapsed time.Duration) float64 {
+	if elapsed <= 0 {
+		return 0
+	}
+	return round(float64(n) / elapsed.Seconds())
+}
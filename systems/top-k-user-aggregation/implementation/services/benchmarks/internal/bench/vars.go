@@ -0,0 +1,59 @@
+package bench
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+var varsClient = &http.Client{Timeout: 5 * time.Second}
+
+// ReadVars reads the integer expvars of services' /debug/vars, summed over
+// urls (one per instance). Maps of integers come out as name.key
+// ("coalesced_reads.response"); everything else is left out.
+func ReadVars(urls ...string) (map[string]int64, error) {
+	sum := make(map[string]int64)
+	for _, u := range urls {
+		resp, err := varsClient.Get(u)
+		if err != nil {
+			return nil, fmt.Errorf("metrics %s: %w", u, err)
+		}
+		var vars map[string]json.RawMessage
+		err = json.NewDecoder(resp.Body).Decode(&vars)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("metrics %s: %w", u, err)
+		}
+		for name, raw := range vars {
+			if n, err := strconv.ParseInt(string(raw), 10, 64); err == nil {
+				sum[name] += n
+				continue
+			}
+			var m map[string]json.RawMessage
+			if json.Unmarshal(raw, &m) != nil {
+				continue
+			}
+			for key, v := range m {
+				if n, err := strconv.ParseInt(string(v), 10, 64); err == nil {
+					sum[name+"."+key] += n
+				}
+			}
+		}
+	}
+	return sum, nil
+}
+
+// Delta is after minus before for keys; a key missing from both is left out
+func Delta(before, after map[string]int64, keys ...string) map[string]int64 {
+	out := make(map[string]int64)
+	for _, k := range keys {
+		b, okB := before[k]
+		a, okA := after[k]
+		if okA || okB {
+			out[k] = a - b
+		}
+	}
+	return out
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/system-design-lab/pkg/events"
+	"github.com/system-design-lab/pkg/kafkautil"
+	"github.com/system-design-lab/pkg/storage"
+)
+
+type userDay struct{ user, day string }
+
+// Compactor publishes the absolute totals of touched songs. Totals are read
+// from user_daily_topk rather than summed from deltas: deltas are published
+// best effort (a failed publish is dropped, a replayed flush is sent twice),
+// while the counters are what every reader agrees on.
+type Compactor struct {
+	topk   *storage.DailyTopKRepo
+	writer *kafka.Writer
+}
+
+// CompactAll compacts the touched days concurrently and returns the ones
+// that failed, with their songs
+func (c *Compactor) CompactAll(ctx context.Context, touched map[userDay]map[string]bool, concurrency int) map[userDay]map[string]bool {
+	var (
+		mu     sync.Mutex
+		failed = make(map[userDay]map[string]bool)
+		wg     sync.WaitGroup
+	)
+	sem := make(chan struct{}, concurrency)
+	for k, songs := range touched {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(k userDay, songs map[string]bool) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			if err := c.Compact(ctx, k.user, k.day, songs); err != nil {
+				log.Printf("Error compacting %s/%s: %v", k.user, k.day, err)
+				metricErrors.Add(1)
+				mu.Lock()
+				failed[k] = songs
+				mu.Unlock()
+				return
+			}
+			metricDaysCompacted.Add(1)
+		}(k, songs)
+	}
+	wg.Wait()
+	return failed
+}
+
+// Compact publishes the current total of each of songs in a user's day.
+// Only the touched songs are sent: the others' latest totals are already on
+// the topic. A song with nothing stored, as after a takedown purge, gets a
+// tombstone (a nil value) so compaction drops its key.
+func (c *Compactor) Compact(ctx context.Context, userID, day string, songs map[string]bool) error {
+	totals, err := c.topk.DayTotals(ctx, userID, day)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().Unix()
+	msgs := make([]kafka.Message, 0, len(songs))
+	tombstones := 0
+	for song := range songs {
+		key := []byte(events.TotalKey(userID, day, song))
+		t, ok := totals[song]
+		if !ok || t.Count <= 0 {
+			msgs = append(msgs, kafka.Message{Key: key})
+			tombstones++
+			continue
+		}
+		value, err := events.MarshalTotal(events.SongTotal{
+			UserID:    userID,
+			Day:       day,
+			SongID:    song,
+			Count:     t.Count,
+			ListenMs:  t.Millis,
+			UpdatedAt: now,
+		})
+		if err != nil {
+			return fmt.Errorf("encode %s: %w", song, err)
+		}
+		msgs = append(msgs, kafka.Message{Key: key, Value: value})
+	}
+	if len(msgs) == 0 {
+		return nil
+	}
+	kafkautil.Inject(ctx, msgs)
+	if err := c.writer.WriteMessages(ctx, msgs...); err != nil {
+		return fmt.Errorf("publish %d totals: %w", len(msgs), err)
+	}
+	metricTotalsPublished.Add(int64(len(msgs)))
+	metricTombstonesPublished.Add(int64(tombstones))
+	return nil
+}
@@ -0,0 +1,221 @@
+// Command compactor folds the aggregator's deltas into absolute per-day song
+// totals on a compacted topic, so a new consumer can bootstrap its state by
+// reading that topic instead of replaying months of deltas.
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/system-design-lab/pkg/events"
+	"github.com/system-design-lab/pkg/kafkautil"
+	"github.com/system-design-lab/pkg/runtimecfg"
+	"github.com/system-design-lab/pkg/startup"
+	"github.com/system-design-lab/pkg/storage"
+	"github.com/system-design-lab/pkg/topology"
+)
+
+func main() {
+	if _, err := topology.Apply("compactor"); err != nil {
+		log.Fatalf("Invalid pipeline config: %v", err)
+	}
+
+	topic := getEnv("TOPIC", "user.listen.agg")
+	consumerGroup := getEnv("CONSUMER_GROUP", "compactor")
+	totalsTopic := getEnv("TOTALS_TOPIC", "user.listen.totals")
+	concurrency := getEnvInt("CONCURRENCY", 8)
+	batchSize := getEnvInt("BATCH_SIZE", 5000)
+	batchTimeout := getEnvDuration("BATCH_TIMEOUT", 30*time.Second)
+	metricsAddr := getEnv("METRICS_ADDR", ":9108")
+
+	kafkaCfg, err := kafkautil.ConfigFromEnv("localhost:29092")
+	if err != nil {
+		log.Fatalf("Invalid Kafka config: %v", err)
+	}
+
+	log.Printf("Starting compactor: kafka=%v topic=%s group=%s totals=%s batch=%d/%s",
+		kafkaCfg.Brokers, topic, consumerGroup, totalsTopic, batchSize, batchTimeout)
+
+	if err := startup.Kafka(context.Background(), kafkaCfg); err != nil {
+		log.Fatalf("Failed to connect to Kafka: %v", err)
+	}
+
+	session, err := startup.Cassandra(context.Background())
+	if err != nil {
+		log.Fatalf("Failed to connect to Cassandra: %v", err)
+	}
+	defer session.Close()
+	log.Println("Connected to Cassandra")
+
+	reader := kafkaCfg.NewReader(kafkautil.ReaderConfig{Topic: topic, GroupID: consumerGroup})
+	defer reader.Close()
+	writer := kafkaCfg.NewWriter(totalsTopic, kafkautil.WriterConfigFromEnv())
+	defer writer.Close()
+
+	startMetricsServer(metricsAddr)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-sigChan
+		log.Println("Shutting down...")
+		cancel()
+	}()
+
+	// log_level and SIGHUP reloads of pipeline.yaml's settings (pkg/runtimecfg)
+	if err := runtimecfg.New(nil, "compactor").Start(ctx); err != nil {
+		log.Printf("Warning: runtime config not loaded: %v", err)
+	}
+
+	c := &Consumer{
+		reader: reader,
+		compactor: &Compactor{
+			topk:   storage.NewDailyTopKRepo(session),
+			writer: writer,
+		},
+		concurrency:  concurrency,
+		batchSize:    batchSize,
+		batchTimeout: batchTimeout,
+	}
+	c.Run(ctx)
+
+	log.Println("Shutdown complete")
+}
+
+// Consumer reads deltas in batches and compacts each touched (user, day)
+// once per batch. Offsets are committed once every day of the batch is
+// published, so a failure re-delivers the batch; republishing a total is
+// harmless since it is absolute.
+type Consumer struct {
+	reader       *kafka.Reader
+	compactor    *Compactor
+	concurrency  int
+	batchSize    int
+	batchTimeout time.Duration
+}
+
+func (c *Consumer) Run(ctx context.Context) {
+	for {
+		batch, err := c.fetchBatch(ctx)
+		if ctx.Err() != nil {
+			return // an unprocessed batch is redelivered on restart
+		}
+		if len(batch) > 0 {
+			c.process(ctx, batch)
+		}
+		if err != nil {
+			log.Printf("Error fetching message: %v", err)
+		}
+	}
+}
+
+// fetchBatch blocks for the first message, then collects more until the
+// batch is full or batchTimeout has passed
+func (c *Consumer) fetchBatch(ctx context.Context) ([]kafka.Message, error) {
+	msg, err := c.reader.FetchMessage(ctx)
+	if err != nil {
+		return nil, err
+	}
+	batch := []kafka.Message{msg}
+
+	fetchCtx, cancel := context.WithTimeout(ctx, c.batchTimeout)
+	defer cancel()
+	for len(batch) < c.batchSize {
+		msg, err := c.reader.FetchMessage(fetchCtx)
+		if err != nil {
+			if errors.Is(err, context.DeadlineExceeded) {
+				break
+			}
+			return batch, err
+		}
+		batch = append(batch, msg)
+	}
+	return batch, nil
+}
+
+func (c *Consumer) process(ctx context.Context, batch []kafka.Message) {
+	start := time.Now()
+
+	touched := make(map[userDay]map[string]bool) // -> songs the deltas changed
+	for _, msg := range batch {
+		delta, err := events.UnmarshalDelta(msg.Value)
+		if err != nil {
+			log.Printf("Error decoding delta (partition=%d offset=%d): %v", msg.Partition, msg.Offset, err)
+			metricDecodeErrors.Add(1)
+			continue
+		}
+		metricDeltasConsumed.Add(1)
+		k := userDay{delta.UserID, delta.Day}
+		if touched[k] == nil {
+			touched[k] = make(map[string]bool)
+		}
+		for _, s := range delta.Songs {
+			touched[k][s.SongID] = true
+		}
+	}
+
+	days := len(touched)
+	backoff := 500 * time.Millisecond
+	for len(touched) > 0 {
+		touched = c.compactor.CompactAll(ctx, touched, c.concurrency)
+		if len(touched) == 0 {
+			break
+		}
+		log.Printf("Retrying %d days in %s", len(touched), backoff)
+		select {
+		case <-ctx.Done():
+			log.Printf("Shutdown during retry, batch left uncommitted")
+			return
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > 10*time.Second {
+			backoff = 10 * time.Second
+		}
+	}
+
+	if err := kafkautil.CommitWithRetry(ctx, c.reader, 3, kafkautil.LatestPerPartition(batch)...); err != nil {
+		log.Printf("Error committing offsets: %v", err)
+		metricCommitErrors.Add(1)
+	}
+
+	metricLastBatchDays.Set(int64(days))
+	metricLastBatchMillis.Set(time.Since(start).Milliseconds())
+	log.Printf("Compacted %d user-days from %d deltas in %s", days, len(batch), time.Since(start).Round(time.Millisecond))
+}
+
+func getEnv(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
+
+func getEnvInt(key string, fallback int) int {
+	if v := os.Getenv(key); v != "" {
+		i, err := strconv.Atoi(v)
+		if err == nil {
+			return i
+		}
+	}
+	return fallback
+}
+
+func getEnvDuration(key string, fallback time.Duration) time.Duration {
+	if v := os.Getenv(key); v != "" {
+		d, err := time.ParseDuration(v)
+		if err == nil {
+			return d
+		}
+	}
+	return fallback
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"expvar"
+	"log"
+	"net/http"
+)
+
+// Compactor metrics, served as JSON on METRICS_ADDR/debug/vars
+var (
+	metricDeltasConsumed      = expvar.NewInt("deltas_consumed")
+	metricDecodeErrors        = expvar.NewInt("decode_errors")
+	metricDaysCompacted       = expvar.NewInt("days_compacted")
+	metricTotalsPublished     = expvar.NewInt("totals_published") // tombstones included
+	metricTombstonesPublished = expvar.NewInt("tombstones_published")
+	metricErrors              = expvar.NewInt("compact_errors")
+	metricCommitErrors        = expvar.NewInt("commit_errors")
+	metricLastBatchDays       = expvar.NewInt("last_batch_days")
+	metricLastBatchMillis     = expvar.NewInt("last_batch_ms")
+)
+
+// startMetricsServer exposes expvar metrics over HTTP
+func startMetricsServer(addr string) {
+	go func() {
+		log.Printf("Metrics on http://%s/debug/vars", addr)
+		if err := http.ListenAndServe(addr, nil); err != nil {
+			log.Printf("Metrics server error: %v", err)
+		}
+	}()
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"time"
+)
+
+// Cadences of user_crawl_schedule.cadence. cadenceAuto picks one of the
+// others from the user's listening.
+const (
+	cadenceAuto   = "auto"
+	cadenceHourly = "hourly"
+	cadenceDaily  = "daily"
+	cadenceWeekly = "weekly"
+)
+
+var cadenceIntervals = map[string]time.Duration{
+	cadenceHourly: time.Hour,
+	cadenceDaily:  24 * time.Hour,
+	cadenceWeekly: 7 * 24 * time.Hour,
+}
+
+// CadenceConfig tunes the auto cadence
+type CadenceConfig struct {
+	HeavyPerDay   float64 // events per day from which a user is crawled hourly
+	DormantPerDay float64 // events per day under which a user is crawled weekly
+	Smoothing     float64 // weight of the latest crawl in events_per_day (0-1]
+}
+
+// interval is the crawl interval of a cadence, for auto the one fitting
+// eventsPerDay. An unknown cadence counts as auto.
+func (c CadenceConfig) interval(cadence string, eventsPerDay float64) time.Duration {
+	if d, ok := cadenceIntervals[cadence]; ok {
+		return d
+	}
+	switch {
+	case eventsPerDay >= c.HeavyPerDay:
+		return cadenceIntervals[cadenceHourly]
+	case eventsPerDay < c.DormantPerDay:
+		return cadenceIntervals[cadenceWeekly]
+	default:
+		return cadenceIntervals[cadenceDaily]
+	}
+}
+
+// pendingCrawl is a completed crawl not folded into its user's cadence yet
+type pendingCrawl struct {
+	UserID       string
+	Provider     string
+	Cadence      string
+	IntervalSecs int64
+	EventsPerDay sql.NullFloat64
+	Events       int64
+	WindowSecs   int64
+	CrawledAt    time.Time
+}
+
+// adjustCadences folds the latest crawls into each user's events_per_day
+// (an exponential moving average of the events a crawl returned per day it
+// covered) and sets the crawl interval the cadence calls for. A changed
+// interval moves the pending next crawl with it, so a user who turned heavy
+// isn't left waiting a week.
+func adjustCadences(ctx context.Context, db *sql.DB, cfg CadenceConfig) int {
+	rows, err := db.QueryContext(ctx, `
+		SELECT user_id, provider, cadence, crawl_interval_secs, events_per_day,
+		       COALESCE(last_crawl_events, 0), COALESCE(last_crawl_window_secs, 0), last_crawled_at
+		FROM user_crawl_schedule
+		WHERE cadence_pending AND last_crawled_at IS NOT NULL
+		LIMIT 500
+	`)
+	if err != nil {
+		log.Printf("Error querying crawls to fold into cadences: %v", err)
+		return 0
+	}
+	var pending []pendingCrawl
+	for rows.Next() {
+		var c pendingCrawl
+		if err := rows.Scan(&c.UserID, &c.Provider, &c.Cadence, &c.IntervalSecs, &c.EventsPerDay,
+			&c.Events, &c.WindowSecs, &c.CrawledAt); err != nil {
+			log.Printf("Error scanning row: %v", err)
+			continue
+		}
+		pending = append(pending, c)
+	}
+	rows.Close()
+
+	changed := 0
+	for _, c := range pending {
+		// A window under a minute says little about a day: weigh it as one
+		rate := float64(c.Events) * 86400 / float64(max(c.WindowSecs, 60))
+		if c.EventsPerDay.Valid {
+			rate = cfg.Smoothing*rate + (1-cfg.Smoothing)*c.EventsPerDay.Float64
+		}
+		interval := int64(cfg.interval(c.Cadence, rate).Seconds())
+
+		// Only if no crawl completed since the read: that one is folded in
+		// on the next pass instead
+		_, err := db.ExecContext(ctx, `
+			UPDATE user_crawl_schedule
+			SET events_per_day = $1,
+			    crawl_interval_secs = $2,
+			    next_crawl_at = CASE WHEN status = 'IDLE'
+			        THEN last_crawled_at + $2 * INTERVAL '1 second'
+			        ELSE next_crawl_at END,
+			    cadence_pending = FALSE
+			WHERE user_id = $3 AND provider = $4 AND last_crawled_at = $5
+		`, rate, interval, c.UserID, c.Provider, c.CrawledAt)
+		if err != nil {
+			log.Printf("Error updating cadence for user=%s provider=%s: %v", c.UserID, c.Provider, err)
+			continue
+		}
+		if interval != c.IntervalSecs {
+			changed++
+			log.Printf("Cadence: user=%s provider=%s every %s (%.1f events/day)",
+				c.UserID, c.Provider, time.Duration(interval)*time.Second, rate)
+		}
+	}
+	return changed
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/redis/go-redis/v9"
+	"github.com/system-design-lab/pkg/userstate"
+)
+
+// deletedUser is a soft-deleted user as served by GET /deleted-users
+type deletedUser struct {
+	UserID     string    `json:"user_id"`
+	DeletedAt  time.Time `json:"deleted_at"`
+	PurgeAfter time.Time `json:"purge_after"` // end of the grace period
+	Reason     string    `json:"reason,omitempty"`
+	Providers  []string  `json:"providers"`
+}
+
+// deletions soft-deletes and restores users. A deleted user's rows keep
+// their schedule and the user's data is kept, but the rows are never
+// enqueued and the api-server hides the user (pkg/userstate). Until
+// purge_after the user can be restored; after it the hard delete may remove
+// the data, and restore is refused.
+type deletions struct {
+	db    *sql.DB
+	rdb   *redis.Client
+	grace time.Duration
+	token string // required as a bearer token (empty = none)
+}
+
+func (d *deletions) authorized(r *http.Request) bool {
+	if d.token == "" {
+		return true
+	}
+	got := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return subtle.ConstantTimeCompare([]byte(got), []byte(d.token)) == 1
+}
+
+// delete soft-deletes every provider of a user. It returns nothing when the
+// user is unknown or already deleted.
+func (d *deletions) delete(ctx context.Context, userID, reason string) (*deletedUser, error) {
+	rows, err := d.db.QueryContext(ctx, `
+		UPDATE user_crawl_schedule
+		SET deleted_at = NOW(),
+		    purge_after = NOW() + $2 * INTERVAL '1 second',
+		    deleted_reason = NULLIF($3, '')
+		WHERE user_id = $1
+		  AND deleted_at IS NULL
+		RETURNING provider, deleted_at, purge_after
+	`, userID, int64(d.grace/time.Second), reason)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	u := &deletedUser{UserID: userID, Reason: reason}
+	for rows.Next() {
+		var p string
+		if err := rows.Scan(&p, &u.DeletedAt, &u.PurgeAfter); err != nil {
+			return nil, err
+		}
+		u.Providers = append(u.Providers, p)
+	}
+	if err := rows.Err(); err != nil || len(u.Providers) == 0 {
+		return nil, err
+	}
+	if err := userstate.MarkDeleted(ctx, d.rdb, userID, u.DeletedAt); err != nil {
+		log.Printf("Error marking user=%s deleted in Redis, hidden at the next sync: %v", userID, err)
+	}
+	return u, nil
+}
+
+// restore undoes a soft delete within the grace period and returns the
+// providers restored. Their next crawl is due now and covers everything
+// since the last one.
+func (d *deletions) restore(ctx context.Context, userID string) ([]string, error) {
+	rows, err := d.db.QueryContext(ctx, `
+		UPDATE user_crawl_schedule
+		SET deleted_at = NULL,
+		    purge_after = NULL,
+		    deleted_reason = NULL,
+		    next_crawl_at = NOW(),
+		    status = CASE WHEN status = 'ENQUEUED' THEN 'IDLE' ELSE status END
+		WHERE user_id = $1
+		  AND deleted_at IS NOT NULL
+		  AND purge_after > NOW()
+		RETURNING provider
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	providers := []string{}
+	for rows.Next() {
+		var p string
+		if err := rows.Scan(&p); err != nil {
+			return nil, err
+		}
+		providers = append(providers, p)
+	}
+	if err := rows.Err(); err != nil || len(providers) == 0 {
+		return nil, err
+	}
+	if err := userstate.Restore(ctx, d.rdb, userID); err != nil {
+		log.Printf("Error restoring user=%s in Redis, shown at the next sync: %v", userID, err)
+	}
+	return providers, nil
+}
+
+// list returns the soft-deleted users, only those past their grace period
+// when due
+func (d *deletions) list(ctx context.Context, due bool) ([]deletedUser, error) {
+	rows, err := d.db.QueryContext(ctx, `
+		SELECT user_id, MIN(deleted_at), MIN(purge_after), COALESCE(MAX(deleted_reason), ''),
+		       ARRAY_AGG(provider ORDER BY provider)
+		FROM user_crawl_schedule
+		WHERE deleted_at IS NOT NULL
+		GROUP BY user_id
+		HAVING NOT $1 OR MIN(purge_after) <= NOW()
+		ORDER BY MIN(purge_after)
+	`, due)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := []deletedUser{}
+	for rows.Next() {
+		var u deletedUser
+		if err := rows.Scan(&u.UserID, &u.DeletedAt, &u.PurgeAfter, &u.Reason, pq.Array(&u.Providers)); err != nil {
+			return nil, err
+		}
+		users = append(users, u)
+	}
+	return users, rows.Err()
+}
+
+// sync rewrites Redis' copy of the deleted users from the table, in case a
+// delete or restore didn't reach it
+func (d *deletions) sync(ctx context.Context) {
+	users, err := d.list(ctx, false)
+	if err != nil {
+		log.Printf("Error listing deleted users: %v", err)
+		return
+	}
+	deleted := make(map[string]time.Time, len(users))
+	for _, u := range users {
+		deleted[u.UserID] = u.DeletedAt
+	}
+	if err := userstate.Sync(ctx, d.rdb, deleted); err != nil {
+		log.Printf("Error syncing deleted users to Redis: %v", err)
+	}
+}
+
+// deleteHandler serves POST /users/{user_id}/delete[?reason=...]
+func (d *deletions) deleteHandler(w http.ResponseWriter, r *http.Request, userID string) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !d.authorized(r) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	u, err := d.delete(r.Context(), userID, r.URL.Query().Get("reason"))
+	if err != nil {
+		log.Printf("Error deleting user=%s: %v", userID, err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	if u == nil {
+		http.Error(w, "unknown or already deleted user", http.StatusNotFound)
+		return
+	}
+	log.Printf("Soft-deleted user=%s providers=%s purge_after=%s", userID, strings.Join(u.Providers, ","), u.PurgeAfter.Format(time.RFC3339))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(u)
+}
+
+// restoreHandler serves POST /users/{user_id}/restore
+func (d *deletions) restoreHandler(w http.ResponseWriter, r *http.Request, userID string) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !d.authorized(r) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	ctx := r.Context()
+	providers, err := d.restore(ctx, userID)
+	if err != nil {
+		log.Printf("Error restoring user=%s: %v", userID, err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	if len(providers) == 0 {
+		var expired bool
+		err := d.db.QueryRowContext(ctx, `
+			SELECT EXISTS (SELECT 1 FROM user_crawl_schedule WHERE user_id = $1 AND deleted_at IS NOT NULL)
+		`, userID).Scan(&expired)
+		switch {
+		case err != nil:
+			log.Printf("Error restoring user=%s: %v", userID, err)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+		case expired:
+			http.Error(w, "grace period over, the user is due for hard delete", http.StatusGone)
+		default:
+			http.Error(w, "user is not deleted", http.StatusNotFound)
+		}
+		return
+	}
+	log.Printf("Restored user=%s providers=%s", userID, strings.Join(providers, ","))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"user_id": userID, "restored": providers})
+}
+
+// listHandler serves GET /deleted-users[?due=true], the soft-deleted users
+// soonest purged first; due=true is the hard delete's work list
+func (d *deletions) listHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !d.authorized(r) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	users, err := d.list(r.Context(), r.URL.Query().Get("due") == "true")
+	if err != nil {
+		log.Printf("Error listing deleted users: %v", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(users)
+}
+
+// usersHandler routes /users/{user_id}/{reactivate,delete,restore}
+func usersHandler(db *sql.DB, d *deletions) http.HandlerFunc {
+	reactivate := reactivateHandler(db)
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, action, ok := strings.Cut(strings.TrimPrefix(r.URL.Path, "/users/"), "/")
+		if !ok || userID == "" {
+			http.NotFound(w, r)
+			return
+		}
+		switch action {
+		case "reactivate":
+			reactivate(w, r)
+		case "delete":
+			d.deleteHandler(w, r, userID)
+		case "restore":
+			d.restoreHandler(w, r, userID)
+		default:
+			http.NotFound(w, r)
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// suspendDormant suspends the crawls of users whose last `after` crawls
+// returned no events: a dormant user costs provider quota and queue capacity
+// for nothing. Suspended rows are never enqueued; reactivate brings them back.
+func suspendDormant(ctx context.Context, db *sql.DB, after int) int {
+	rows, err := db.QueryContext(ctx, `
+		UPDATE user_crawl_schedule
+		SET status = 'SUSPENDED', suspended_at = NOW(), suspended_reason = $2
+		WHERE (user_id, provider) IN (
+			SELECT user_id, provider
+			FROM user_crawl_schedule
+			WHERE status = 'IDLE'
+			  AND empty_crawls >= $1
+			LIMIT 500
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING user_id, provider
+	`, after, fmt.Sprintf("no events in the last %d crawls", after))
+	if err != nil {
+		log.Printf("Error suspending dormant users: %v", err)
+		return 0
+	}
+	defer rows.Close()
+
+	count := 0
+	for rows.Next() {
+		var userID, provider string
+		if err := rows.Scan(&userID, &provider); err != nil {
+			log.Printf("Error scanning row: %v", err)
+			continue
+		}
+		count++
+		log.Printf("Suspended dormant user=%s provider=%s", userID, provider)
+	}
+	return count
+}
+
+// reactivate resumes a user's suspended crawls, of one provider or (empty)
+// all, and returns the providers resumed. The next crawl is due now and
+// covers everything since the last one; the cadence starts over from daily.
+func reactivate(ctx context.Context, db *sql.DB, userID, provider string) ([]string, error) {
+	rows, err := db.QueryContext(ctx, `
+		UPDATE user_crawl_schedule
+		SET status = 'IDLE',
+		    next_crawl_at = NOW(),
+		    empty_crawls = 0,
+		    events_per_day = NULL,
+		    crawl_interval_secs = 86400,
+		    suspended_at = NULL,
+		    suspended_reason = NULL
+		WHERE user_id = $1
+		  AND ($2 = '' OR provider = $2)
+		  AND status = 'SUSPENDED'
+		RETURNING provider
+	`, userID, provider)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	providers := []string{}
+	for rows.Next() {
+		var p string
+		if err := rows.Scan(&p); err != nil {
+			return nil, err
+		}
+		providers = append(providers, p)
+	}
+	return providers, rows.Err()
+}
+
+// reactivateHandler serves POST /users/{user_id}/reactivate[?provider=...],
+// the manual way back for a suspended user
+func reactivateHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := strings.CutSuffix(strings.TrimPrefix(r.URL.Path, "/users/"), "/reactivate")
+		if !ok || userID == "" || strings.Contains(userID, "/") {
+			http.NotFound(w, r)
+			return
+		}
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		providers, err := reactivate(r.Context(), db, userID, r.URL.Query().Get("provider"))
+		if err != nil {
+			log.Printf("Error reactivating user=%s: %v", userID, err)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		if len(providers) == 0 {
+			http.Error(w, "no suspended crawls", http.StatusNotFound)
+			return
+		}
+		log.Printf("Reactivated user=%s providers=%s (manual)", userID, strings.Join(providers, ","))
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"user_id": userID, "reactivated": providers})
+	}
+}
+
+// activityEvent is the body of POST /webhooks/activity: a provider telling
+// us a user listened again
+type activityEvent struct {
+	UserID   string `json:"user_id"`
+	Provider string `json:"provider"`
+}
+
+// activityWebhookHandler reactivates the user a provider reports activity
+// for. With a secret set, callers must send it in X-Webhook-Secret. It
+// answers 204 whether or not the user was suspended, so a provider can't
+// probe the registry and doesn't retry a no-op.
+func activityWebhookHandler(db *sql.DB, secret string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if secret != "" && subtle.ConstantTimeCompare([]byte(r.Header.Get("X-Webhook-Secret")), []byte(secret)) != 1 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		var e activityEvent
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16)).Decode(&e); err != nil || e.UserID == "" || e.Provider == "" {
+			http.Error(w, "body must be {\"user_id\": ..., \"provider\": ...}", http.StatusBadRequest)
+			return
+		}
+
+		providers, err := reactivate(r.Context(), db, e.UserID, e.Provider)
+		if err != nil {
+			log.Printf("Error reactivating user=%s provider=%s: %v", e.UserID, e.Provider, err)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		if len(providers) > 0 {
+			log.Printf("Reactivated user=%s provider=%s (activity webhook)", e.UserID, e.Provider)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -5,4 +5,10 @@ go 1.22
 require (
 	github.com/hibiken/asynq v0.24.1
 	github.com/lib/pq v1.10.9
+	github.com/redis/go-redis/v9 v9.5.1
+	github.com/system-design-lab/pkg v0.0.0
+	google.golang.org/grpc v1.64.1
+	google.golang.org/protobuf v1.34.2
 )
+
+replace github.com/system-design-lab/pkg => ../pkg
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/hibiken/asynq"
+	"github.com/redis/go-redis/v9"
+	"github.com/system-design-lab/pkg/providerhealth"
+)
+
+// HealthConfig tunes the provider health monitor
+type HealthConfig struct {
+	Window     time.Duration // error rate window of a healthy provider
+	MinCalls   int64         // calls in the window before the rate counts
+	PauseRate  float64       // error rate that pauses a provider (0 = off)
+	Cooldown   time.Duration // paused before ramping up again
+	RampStart  int           // jobs per poll when ramping up, doubled every check
+	WebhookURL string        // POST state changes here (empty = log only)
+}
+
+type providerState string
+
+const (
+	stateHealthy providerState = "healthy"
+	statePaused  providerState = "paused"
+	stateRamping providerState = "ramping"
+)
+
+type providerStatus struct {
+	state  providerState
+	since  time.Time // entered the state
+	budget int       // ramping: ready jobs enqueued per poll
+}
+
+// healthMonitor pauses the crawl queues of a provider whose calls fail too
+// often, as recorded by the crawl-workers (pkg/providerhealth). After the
+// cooldown the queues are resumed, but the scheduler enqueues the provider's
+// jobs at a ramp (RampStart per poll, doubled every healthy check) until the
+// ramp reaches a full poll: a provider back from an outage isn't hit with
+// the whole backlog at once. Errors during the ramp pause it again.
+//
+// It runs on the scheduler's goroutine, so it needs no locking.
+type healthMonitor struct {
+	cfg       HealthConfig
+	rdb       *redis.Client
+	inspector *asynq.Inspector
+	client    *http.Client
+	providers map[string]*providerStatus
+}
+
+// newHealthMonitor returns nil if the monitor is off; a nil monitor holds
+// nothing back
+func newHealthMonitor(cfg HealthConfig, rdb *redis.Client, inspector *asynq.Inspector) *healthMonitor {
+	if cfg.PauseRate <= 0 {
+		return nil
+	}
+	if cfg.RampStart < 1 {
+		cfg.RampStart = 1
+	}
+	return &healthMonitor{
+		cfg:       cfg,
+		rdb:       rdb,
+		inspector: inspector,
+		client:    &http.Client{Timeout: 5 * time.Second},
+		providers: make(map[string]*providerStatus),
+	}
+}
+
+// restore picks up the queues left paused by a previous run. Their cooldown
+// starts over.
+func (m *healthMonitor) restore(ctx context.Context) {
+	providers, err := providerhealth.Providers(ctx, m.rdb)
+	if err != nil {
+		log.Printf("Error listing providers: %v", err)
+		return
+	}
+	for _, p := range providers {
+		for _, q := range providerhealth.Queues(p, crawlShards) {
+			info, err := m.inspector.GetQueueInfo(q)
+			if err != nil || !info.Paused {
+				continue // a queue without tasks yet isn't paused
+			}
+			m.providers[p] = &providerStatus{state: statePaused, since: time.Now()}
+			log.Printf("Provider %s is paused, resuming in %s", p, m.cfg.Cooldown)
+			break
+		}
+	}
+}
+
+// pauseQueues pauses every crawl queue of provider, its shards' included.
+// asynq refuses to pause a paused queue: those are skipped, so a pause that
+// failed halfway is retried whole.
+func (m *healthMonitor) pauseQueues(provider string) error {
+	for _, q := range providerhealth.Queues(provider, crawlShards) {
+		if err := m.inspector.PauseQueue(q); err != nil && !strings.Contains(err.Error(), "already paused") {
+			return err
+		}
+	}
+	return nil
+}
+
+// unpauseQueues resumes every crawl queue of provider, skipping the ones
+// not paused
+func (m *healthMonitor) unpauseQueues(provider string) error {
+	for _, q := range providerhealth.Queues(provider, crawlShards) {
+		if err := m.inspector.UnpauseQueue(q); err != nil && !strings.Contains(err.Error(), "not paused") {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *healthMonitor) status(provider string) *providerStatus {
+	s, ok := m.providers[provider]
+	if !ok {
+		s = &providerStatus{state: stateHealthy}
+		m.providers[provider] = s
+	}
+	return s
+}
+
+// check moves every provider along: pauses the failing, ramps up the ones
+// paused long enough, and fully resumes those whose ramp went well
+func (m *healthMonitor) check(ctx context.Context) {
+	providers, err := providerhealth.Providers(ctx, m.rdb)
+	if err != nil {
+		log.Printf("Error listing providers: %v", err)
+		return
+	}
+	now := time.Now()
+	for _, p := range providers {
+		s := m.status(p)
+		switch s.state {
+		case stateHealthy:
+			c, err := providerhealth.Window(ctx, m.rdb, p, now.Add(-m.cfg.Window))
+			if err != nil {
+				log.Printf("Error checking %s: %v", p, err)
+				continue
+			}
+			if m.failing(c, m.cfg.MinCalls) {
+				m.pause(ctx, p, s, c)
+			}
+
+		case statePaused:
+			if now.Sub(s.since) < m.cfg.Cooldown {
+				continue
+			}
+			if err := m.unpauseQueues(p); err != nil {
+				log.Printf("Error resuming %s, retrying: %v", p, err)
+				continue
+			}
+			*s = providerStatus{state: stateRamping, since: now, budget: m.cfg.RampStart}
+			m.notify(ctx, p, s, providerhealth.Counts{})
+
+		case stateRamping:
+			// Only the calls since the ramp started: the outage is over
+			c, err := providerhealth.Window(ctx, m.rdb, p, s.since)
+			if err != nil {
+				log.Printf("Error checking %s: %v", p, err)
+				continue
+			}
+			minCalls := m.cfg.MinCalls
+			if int64(m.cfg.RampStart) < minCalls {
+				minCalls = int64(m.cfg.RampStart)
+			}
+			if m.failing(c, minCalls) {
+				m.pause(ctx, p, s, c)
+				continue
+			}
+			if s.budget *= 2; s.budget >= readyBatch {
+				*s = providerStatus{state: stateHealthy, since: now}
+				m.notify(ctx, p, s, c)
+			}
+		}
+	}
+}
+
+func (m *healthMonitor) failing(c providerhealth.Counts, minCalls int64) bool {
+	return c.Calls >= minCalls && c.ErrorRate() >= m.cfg.PauseRate
+}
+
+func (m *healthMonitor) pause(ctx context.Context, provider string, s *providerStatus, c providerhealth.Counts) {
+	if err := m.pauseQueues(provider); err != nil {
+		log.Printf("Error pausing %s, retrying: %v", provider, err)
+		return
+	}
+	*s = providerStatus{state: statePaused, since: time.Now()}
+	m.notify(ctx, provider, s, c)
+}
+
+// held are the providers whose ready jobs aren't enqueued in the main batch:
+// paused, or ramping with their own budget. Never nil: a nil array binds as
+// NULL, which would match no provider at all in NOT (provider = ANY($1)).
+func (m *healthMonitor) held() []string {
+	out := []string{}
+	if m == nil {
+		return out
+	}
+	for p, s := range m.providers {
+		if s.state != stateHealthy {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+// rampBudgets returns the jobs per poll of each ramping provider
+func (m *healthMonitor) rampBudgets() map[string]int {
+	if m == nil {
+		return nil
+	}
+	out := make(map[string]int)
+	for p, s := range m.providers {
+		if s.state == stateRamping {
+			out[p] = s.budget
+		}
+	}
+	return out
+}
+
+// healthNotification is the webhook body of a provider state change
+type healthNotification struct {
+	Provider  string  `json:"provider"`
+	State     string  `json:"state"`
+	Calls     int64   `json:"calls"`
+	ErrorRate float64 `json:"error_rate"`
+	At        int64   `json:"at"`
+}
+
+// notify logs a state change and, with a webhook, posts it. A failed post
+// is logged only: the state change already happened.
+func (m *healthMonitor) notify(ctx context.Context, provider string, s *providerStatus, c providerhealth.Counts) {
+	switch s.state {
+	case statePaused:
+		log.Printf("ALERT: provider %s paused: %d of %d calls failed; resuming in %s",
+			provider, c.Errors, c.Calls, m.cfg.Cooldown)
+	case stateRamping:
+		log.Printf("Provider %s ramping up from %d jobs per poll", provider, s.budget)
+	case stateHealthy:
+		log.Printf("Provider %s resumed: %d of %d calls failed during the ramp", provider, c.Errors, c.Calls)
+	}
+	if m.cfg.WebhookURL == "" {
+		return
+	}
+
+	body, _ := json.Marshal(healthNotification{
+		Provider:  provider,
+		State:     string(s.state),
+		Calls:     c.Calls,
+		ErrorRate: c.ErrorRate(),
+		At:        s.since.Unix(),
+	})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, m.cfg.WebhookURL, bytes.NewReader(body))
+	if err != nil {
+		log.Printf("Error notifying %s %s: %v", provider, s.state, err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := m.client.Do(req)
+	if err == nil {
+		resp.Body.Close()
+		if resp.StatusCode/100 != 2 {
+			err = fmt.Errorf("status %d", resp.StatusCode)
+		}
+	}
+	if err != nil {
+		log.Printf("Error notifying %s %s: %v", provider, s.state, err)
+	}
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"expvar"
+	"sync/atomic"
+	"time"
+
+	"github.com/lib/pq"
+	topkv1 "github.com/system-design-lab/pkg/gen/topk/v1"
+	"github.com/system-design-lab/pkg/grpcutil"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+var metricCrawlReports = expvar.NewMap("registry_crawl_reports") // ok, failed
+
+// maxDueCrawls bounds one ListDueCrawls claim
+const maxDueCrawls = 1000
+
+// completeCrawlSQL marks a crawl complete and schedules the next one after
+// the user's crawl interval, or at $5 when set. The events and window feed
+// the cadence job, which retunes the interval from them (cadence_pending) and
+// suspends users whose crawls keep coming back empty (empty_crawls).
+const completeCrawlSQL = `
+	UPDATE user_crawl_schedule
+	SET status = 'IDLE',
+	    next_crawl_at = COALESCE($5, NOW() + crawl_interval_secs * INTERVAL '1 second'),
+	    last_crawled_at = NOW(),
+	    last_crawl_events = $1,
+	    last_crawl_window_secs = $2,
+	    cadence_pending = TRUE,
+	    empty_crawls = CASE WHEN $1 = 0 THEN empty_crawls + 1 ELSE 0 END,
+	    last_error = NULL,
+	    updated_at = NOW()
+	WHERE user_id = $3 AND provider = $4
+	RETURNING next_crawl_at
+`
+
+// registryServer is the UserRegistry over user_crawl_schedule
+// (proto/topk/v1/registry.proto). crawl-workers report their crawls to it
+// instead of writing the table themselves.
+type registryServer struct {
+	topkv1.UnimplementedUserRegistryServer
+	db *sql.DB
+	// held are the providers the health monitor holds back, set by the
+	// scheduler loop after each check: ListDueCrawls doesn't claim theirs
+	held atomic.Pointer[[]string]
+}
+
+func newRegistryServer(db *sql.DB) *registryServer {
+	r := &registryServer{db: db}
+	r.setHeld(nil)
+	return r
+}
+
+func (r *registryServer) setHeld(providers []string) {
+	if providers == nil {
+		providers = []string{}
+	}
+	r.held.Store(&providers)
+}
+
+var statuses = map[string]topkv1.CrawlStatus{
+	"IDLE":      topkv1.CrawlStatus_CRAWL_STATUS_IDLE,
+	"ENQUEUED":  topkv1.CrawlStatus_CRAWL_STATUS_ENQUEUED,
+	"RUNNING":   topkv1.CrawlStatus_CRAWL_STATUS_RUNNING,
+	"SUSPENDED": topkv1.CrawlStatus_CRAWL_STATUS_SUSPENDED,
+}
+
+func (r *registryServer) GetUser(ctx context.Context, req *topkv1.GetUserRequest) (*topkv1.User, error) {
+	if req.UserId == "" {
+		return nil, status.Error(codes.InvalidArgument, "user_id is required")
+	}
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT provider, status, next_crawl_at, last_error, created_at, deleted_at
+		FROM user_crawl_schedule
+		WHERE user_id = $1
+		ORDER BY provider
+	`, req.UserId)
+	if err != nil {
+		return nil, grpcutil.StoreError(ctx, err)
+	}
+	defer rows.Close()
+
+	user := &topkv1.User{UserId: req.UserId}
+	for rows.Next() {
+		var (
+			provider, state string
+			next, created   time.Time
+			lastError       sql.NullString
+			deleted         sql.NullTime
+		)
+		if err := rows.Scan(&provider, &state, &next, &lastError, &created, &deleted); err != nil {
+			return nil, grpcutil.StoreError(ctx, err)
+		}
+		user.Providers = append(user.Providers, &topkv1.Provider{
+			Name:        provider,
+			Status:      statuses[state],
+			NextCrawlAt: timestamppb.New(next),
+			LastError:   lastError.String,
+		})
+		if user.CreatedAt == nil || created.Before(user.CreatedAt.AsTime()) {
+			user.CreatedAt = timestamppb.New(created)
+		}
+		if deleted.Valid {
+			user.DeletedAt = timestamppb.New(deleted.Time)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, grpcutil.StoreError(ctx, err)
+	}
+	if len(user.Providers) == 0 {
+		return nil, status.Errorf(codes.NotFound, "user %s not found", req.UserId)
+	}
+	return user, nil
+}
+
+// ListDueCrawls claims like the scheduler's own poll: IDLE, due, not deleted
+// and not of a held provider
+func (r *registryServer) ListDueCrawls(ctx context.Context, req *topkv1.ListDueCrawlsRequest) (*topkv1.ListDueCrawlsResponse, error) {
+	limit := int(req.Limit)
+	if limit <= 0 {
+		limit = readyBatch
+	}
+	if limit > maxDueCrawls {
+		limit = maxDueCrawls
+	}
+	rows, err := r.db.QueryContext(ctx, `
+		UPDATE user_crawl_schedule
+		SET status = 'ENQUEUED', updated_at = NOW()
+		WHERE (user_id, provider) IN (
+			SELECT user_id, provider
+			FROM user_crawl_schedule
+			WHERE next_crawl_at <= NOW()
+			  AND status = 'IDLE'
+			  AND deleted_at IS NULL
+			  AND NOT (provider = ANY($1))
+			LIMIT $2
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING user_id, provider
+	`, pq.Array(*r.held.Load()), limit)
+	if err != nil {
+		return nil, grpcutil.StoreError(ctx, err)
+	}
+	defer rows.Close()
+
+	resp := &topkv1.ListDueCrawlsResponse{}
+	for rows.Next() {
+		t := &topkv1.CrawlTarget{}
+		if err := rows.Scan(&t.UserId, &t.Provider); err != nil {
+			return nil, grpcutil.StoreError(ctx, err)
+		}
+		resp.Targets = append(resp.Targets, t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, grpcutil.StoreError(ctx, err)
+	}
+	return resp, nil
+}
+
+func (r *registryServer) StartCrawl(ctx context.Context, req *topkv1.StartCrawlRequest) (*topkv1.StartCrawlResponse, error) {
+	t := req.Target
+	if t.GetUserId() == "" || t.GetProvider() == "" {
+		return nil, status.Error(codes.InvalidArgument, "target user_id and provider are required")
+	}
+	res, err := r.db.ExecContext(ctx, `
+		UPDATE user_crawl_schedule
+		SET status = 'RUNNING', last_error = NULL, updated_at = NOW()
+		WHERE user_id = $1 AND provider = $2
+	`, t.UserId, t.Provider)
+	if err != nil {
+		return nil, grpcutil.StoreError(ctx, err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return nil, status.Errorf(codes.NotFound, "no schedule for user=%s provider=%s", t.UserId, t.Provider)
+	}
+	return &topkv1.StartCrawlResponse{}, nil
+}
+
+// ReportCrawl completes a crawl that succeeded and puts a failed one back to
+// IDLE, still due, so the next poll claims it again
+func (r *registryServer) ReportCrawl(ctx context.Context, req *topkv1.ReportCrawlRequest) (*topkv1.ReportCrawlResponse, error) {
+	t := req.Target
+	if t.GetUserId() == "" || t.GetProvider() == "" {
+		return nil, status.Error(codes.InvalidArgument, "target user_id and provider are required")
+	}
+	var next sql.NullTime
+	if req.NextCrawlAt != nil {
+		next = sql.NullTime{Time: req.NextCrawlAt.AsTime(), Valid: true}
+	}
+
+	if !req.Ok {
+		metricCrawlReports.Add("failed", 1)
+		res, err := r.db.ExecContext(ctx, `
+			UPDATE user_crawl_schedule
+			SET status = 'IDLE', last_error = $1, next_crawl_at = COALESCE($2, next_crawl_at), updated_at = NOW()
+			WHERE user_id = $3 AND provider = $4
+		`, sql.NullString{String: req.Error, Valid: req.Error != ""}, next, t.UserId, t.Provider)
+		if err != nil {
+			return nil, grpcutil.StoreError(ctx, err)
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return nil, status.Errorf(codes.NotFound, "no schedule for user=%s provider=%s", t.UserId, t.Provider)
+		}
+		return &topkv1.ReportCrawlResponse{}, nil
+	}
+
+	metricCrawlReports.Add("ok", 1)
+	var scheduled time.Time
+	err := r.db.QueryRowContext(ctx, completeCrawlSQL, req.Events, int64(req.Window.AsDuration().Seconds()), t.UserId, t.Provider, next).Scan(&scheduled)
+	if err == sql.ErrNoRows {
+		return nil, status.Errorf(codes.NotFound, "no schedule for user=%s provider=%s", t.UserId, t.Provider)
+	}
+	if err != nil {
+		return nil, grpcutil.StoreError(ctx, err)
+	}
+	return &topkv1.ReportCrawlResponse{NextCrawlAt: timestamppb.New(scheduled)}, nil
+}
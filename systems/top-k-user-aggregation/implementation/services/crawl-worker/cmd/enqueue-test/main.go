@@ -7,6 +7,7 @@ import (
 
 	"github.com/hibiken/asynq"
 	"github.com/system-design-lab/crawl-worker/tasks"
+	"github.com/system-design-lab/pkg/providerhealth"
 )
 
 func main() {
@@ -20,7 +21,7 @@ func main() {
 		log.Fatalf("Failed to create task: %v", err)
 	}
 
-	info, err := client.Enqueue(task, asynq.Queue("crawl"))
+	info, err := client.Enqueue(task, asynq.Queue(providerhealth.Queue("spotify")))
 	if err != nil {
 		log.Fatalf("Failed to enqueue task: %v", err)
 	}
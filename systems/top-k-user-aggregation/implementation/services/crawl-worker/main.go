@@ -1,30 +1,206 @@
 package main
 
 import (
+	"context"
 	"log"
 	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/hibiken/asynq"
+	"github.com/redis/go-redis/v9"
 	"github.com/system-design-lab/crawl-worker/tasks"
+	topkv1 "github.com/system-design-lab/pkg/gen/topk/v1"
+	"github.com/system-design-lab/pkg/grpcutil"
+	"github.com/system-design-lab/pkg/idgen"
+	"github.com/system-design-lab/pkg/providerhealth"
+	"github.com/system-design-lab/pkg/quota"
+	"github.com/system-design-lab/pkg/ratelimit"
+	"github.com/system-design-lab/pkg/runtimecfg"
+	"github.com/system-design-lab/pkg/startup"
+	"github.com/system-design-lab/pkg/storage"
+	"github.com/system-design-lab/pkg/topology"
 )
 
 func main() {
+	if _, err := topology.Apply("crawl-worker"); err != nil {
+		log.Fatalf("Invalid pipeline config: %v", err)
+	}
+
 	redisAddr := getEnv("REDIS_ADDR", "localhost:6379")
+	outboxInterval := getEnvDuration("OUTBOX_POLL_INTERVAL", 1*time.Second)
+	outboxBatch := getEnvInt("OUTBOX_BATCH_SIZE", 500)
+	providerRate := getEnvInt("PROVIDER_RATE_LIMIT", 0)
+	providerBurst := getEnvInt("PROVIDER_RATE_LIMIT_BURST", 0)
+	providers := getEnv("CRAWL_PROVIDERS", "spotify,youtube")
+	crawlShards := getEnvInt("CRAWL_SHARDS", 1)
+	songCatalog := getEnv("SONG_CATALOG", "")
+	providersConfig := getEnv("PROVIDERS_CONFIG", "")
+
+	// Providers declared in PROVIDERS_CONFIG are crawled over their APIs,
+	// and served without being listed in CRAWL_PROVIDERS
+	var declared []tasks.ProviderSpec
+	if providersConfig != "" {
+		var err error
+		if declared, err = tasks.LoadProviders(providersConfig); err != nil {
+			log.Fatalf("Invalid PROVIDERS_CONFIG: %v", err)
+		}
+		tasks.SetProviders(declared, getEnvDuration("PROVIDER_HTTP_TIMEOUT", 10*time.Second))
+		for _, spec := range declared {
+			log.Printf("Provider %s: crawled from %s", spec.Name, spec.BaseURL)
+		}
+	}
+
+	// One queue per provider, so the crawl-scheduler can pause a failing one.
+	// The shared "crawl" queue drains jobs enqueued before the split.
+	queues := map[string]int{"crawl": 1}
+	var providerList []string
+	names := strings.Split(providers, ",")
+	for _, spec := range declared {
+		names = append(names, spec.Name)
+	}
+	for _, p := range names {
+		if p = strings.TrimSpace(p); p != "" && !slices.Contains(providerList, p) {
+			queues[providerhealth.Queue(p)] = 10
+			providerList = append(providerList, p)
+		}
+	}
+	// With CRAWL_SHARDS, one queue per provider and shard of users: this
+	// worker serves CRAWL_WORKER_SHARDS of them, and the unsharded queues
+	// drain the jobs enqueued before
+	if crawlShards < 1 {
+		log.Fatalf("CRAWL_SHARDS must be at least 1")
+	}
+	if crawlShards > 1 {
+		shards, err := parseShards(getEnv("CRAWL_WORKER_SHARDS", ""), crawlShards)
+		if err != nil {
+			log.Fatalf("Invalid CRAWL_WORKER_SHARDS: %v", err)
+		}
+		for _, p := range providerList {
+			queues[providerhealth.Queue(p)] = 1
+			for _, s := range shards {
+				queues[providerhealth.ShardQueue(p, s)] = 10
+			}
+		}
+		log.Printf("Serving crawl shards %v of %d", shards, crawlShards)
+	}
+	// Metadata lookups of songs the materializer found no entry for, behind
+	// the listen crawls
+	if songCatalog != "" {
+		queues[tasks.SongMetadataQueue] = 2
+	}
 
 	srv := asynq.NewServer(
 		asynq.RedisClientOpt{Addr: redisAddr},
 		asynq.Config{
 			Concurrency: 10,
-			Queues: map[string]int{
-				"crawl": 10,
-			},
+			Queues:      queues,
 		},
 	)
 
+	// Event IDs: worker ID from WORKER_ID or a lease in the same Redis
+	rdb := redis.NewClient(&redis.Options{Addr: redisAddr})
+	defer rdb.Close()
+	if err := startup.Redis(context.Background(), rdb); err != nil {
+		log.Fatalf("Failed to connect to Redis: %v", err)
+	}
+	ids, lease, err := idgen.FromEnv(context.Background(), rdb)
+	if err != nil {
+		log.Fatalf("Failed to get a worker ID: %v", err)
+	}
+	if lease != nil {
+		defer lease.Release(context.Background())
+		go func() {
+			// Event IDs could collide with the new holder's: stop as if signalled
+			<-lease.Lost()
+			if p, err := os.FindProcess(os.Getpid()); err == nil {
+				p.Signal(syscall.SIGTERM)
+			}
+		}()
+	}
+	tasks.SetIDGenerator(ids)
+	tasks.SetProviderHealth(rdb)
+
+	// Runtime settings: the provider rate limit and log level, reloaded
+	// from Redis and pipeline.yaml without a restart
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	settings := runtimecfg.New(rdb, "crawl-worker")
+	if err := settings.Start(ctx); err != nil {
+		log.Printf("Warning: runtime config not loaded, using env values until Redis answers: %v", err)
+	}
+
+	// Provider calls: one limit per provider across every worker, kept in
+	// Redis so adding workers doesn't multiply it; provider_rate_limit and
+	// provider_rate_limit_burst at runtime
+	providerLimiter := ratelimit.NewSwitch(rdb, "provider")
+	setProviderLimit := func() error {
+		r := settings.Int("provider_rate_limit", providerRate)
+		changed, err := providerLimiter.Configure(ratelimit.Config{
+			Backend: ratelimit.Redis,
+			Rate:    float64(r),
+			Burst:   settings.Int("provider_rate_limit_burst", providerBurst),
+		})
+		if changed && r > 0 {
+			log.Printf("Provider rate limit: %d/s per provider", r)
+		}
+		return err
+	}
+	if err := setProviderLimit(); err != nil {
+		log.Fatalf("Invalid provider rate limit: %v", err)
+	}
+	settings.OnChange(func() {
+		if err := setProviderLimit(); err != nil {
+			log.Printf("Warning: invalid provider rate limit, keeping the last one: %v", err)
+		}
+	})
+	tasks.SetProviderLimiter(providerLimiter)
+
+	// Events per user and day, counted in the same Redis as ingest's
+	quotaCfg, err := quota.ConfigFromEnv()
+	if err != nil {
+		log.Fatalf("Invalid quota: %v", err)
+	}
+	tasks.SetQuota(quota.New(quotaCfg, rdb, "crawl"))
+
+	// Crawl status goes to the crawl-scheduler's UserRegistry over gRPC
+	// with REGISTRY_ADDR; without it, to user_crawl_schedule in Postgres
+	registryCfg, err := grpcutil.ConfigFromEnv("REGISTRY", "")
+	if err != nil {
+		log.Fatalf("Invalid registry config: %v", err)
+	}
+	if registryCfg.Addr != "" {
+		conn, err := registryCfg.Dial(tasks.RegistryRetried...)
+		if err != nil {
+			log.Fatalf("Failed to dial the registry: %v", err)
+		}
+		defer conn.Close()
+		tasks.SetRegistry(topkv1.NewUserRegistryClient(conn))
+		log.Printf("Reporting crawls to the registry at %s", registryCfg)
+	}
+
 	mux := asynq.NewServeMux()
 	mux.HandleFunc(tasks.TypeCrawlUser, tasks.HandleCrawlUserTask)
+	if songCatalog != "" {
+		session, err := startup.Cassandra(context.Background())
+		if err != nil {
+			log.Fatalf("Failed to connect to Cassandra: %v", err)
+		}
+		defer session.Close()
+		if err := tasks.SetSongMetadata(storage.NewSongMetadataRepo(session), providerList, songCatalog); err != nil {
+			log.Fatalf("Failed to load SONG_CATALOG: %v", err)
+		}
+		mux.HandleFunc(tasks.TypeCrawlSongMetadata, tasks.HandleCrawlSongMetadataTask)
+		log.Printf("Looking up song metadata from %s (simulated catalogs of %v)", songCatalog, providerList)
+	}
 
-	log.Printf("Starting crawl-worker, redis=%s", redisAddr)
+	// Outbox publisher runs alongside the asynq server and stops when it exits
+	go tasks.RunOutboxPublisher(ctx, outboxInterval, outboxBatch)
+
+	log.Printf("Starting crawl-worker, redis=%s worker=%d providers=%s quota=%s", redisAddr, ids.Worker(), strings.Join(providerList, ","), quotaCfg)
 	if err := srv.Run(mux); err != nil {
 		log.Fatalf("could not start server: %v", err)
 	}
@@ -36,3 +212,23 @@ func getEnv(key, fallback string) string {
 	}
 	return fallback
 }
+
+func getEnvDuration(key string, fallback time.Duration) time.Duration {
+	if v := os.Getenv(key); v != "" {
+		d, err := time.ParseDuration(v)
+		if err == nil {
+			return d
+		}
+	}
+	return fallback
+}
+
+func getEnvInt(key string, fallback int) int {
+	if v := os.Getenv(key); v != "" {
+		i, err := strconv.Atoi(v)
+		if err == nil {
+			return i
+		}
+	}
+	return fallback
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// parseShards reads CRAWL_WORKER_SHARDS, the shards a worker serves out of
+// shards: shard numbers and ranges, like "0-3,8". Empty is all of them.
+func parseShards(list string, shards int) ([]int, error) {
+	if strings.TrimSpace(list) == "" {
+		out := make([]int, shards)
+		for i := range out {
+			out[i] = i
+		}
+		return out, nil
+	}
+	seen := make(map[int]bool)
+	var out []int
+	for _, part := range strings.Split(list, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		a, b, isRange := strings.Cut(part, "-")
+		lo, err := strconv.Atoi(a)
+		hi := lo
+		if err == nil && isRange {
+			hi, err = strconv.Atoi(b)
+		}
+		if err != nil || lo > hi {
+			return nil, fmt.Errorf("invalid shard %q (want N or lo-hi)", part)
+		}
+		if lo < 0 || hi >= shards {
+			return nil, fmt.Errorf("shard %q out of 0-%d", part, shards-1)
+		}
+		for s := lo; s <= hi; s++ {
+			if !seen[s] {
+				seen[s] = true
+				out = append(out, s)
+			}
+		}
+	}
+	return out, nil
+}
@@ -5,13 +5,22 @@ import (
 	"database/sql"
 	"encoding/json"
 	"fmt"
+	"hash/fnv"
 	"log"
 	"os"
 	"time"
 
 	"github.com/hibiken/asynq"
 	_ "github.com/lib/pq"
+	"github.com/redis/go-redis/v9"
 	"github.com/segmentio/kafka-go"
+	"github.com/system-design-lab/pkg/chaos"
+	"github.com/system-design-lab/pkg/events"
+	"github.com/system-design-lab/pkg/idgen"
+	"github.com/system-design-lab/pkg/kafkautil"
+	"github.com/system-design-lab/pkg/providerhealth"
+	"github.com/system-design-lab/pkg/quota"
+	"github.com/system-design-lab/pkg/ratelimit"
 )
 
 const TypeCrawlUser = "crawl:user"
@@ -48,14 +57,56 @@ type CrawlUserPayload struct {
 	Since    int64  `json:"since"` // unix timestamp
 }
 
-// ListenEvent is the normalized event we publish to Kafka
-type ListenEvent struct {
-	EventID    string `json:"event_id"`
-	UserID     string `json:"user_id"`
-	SongID     string `json:"song_id"`
-	Provider   string `json:"provider"`
-	ListenedAt int64  `json:"listened_at"`
-}
+// completeCrawlSQL marks a crawl complete and schedules the next one after
+// the user's crawl interval. The crawl's event count and window are kept for
+// the crawl-scheduler, which retunes the interval from them (cadence_pending)
+// and suspends users whose crawls keep coming back empty (empty_crawls).
+const completeCrawlSQL = `
+	UPDATE user_crawl_schedule
+	SET status = 'IDLE',
+	    next_crawl_at = NOW() + crawl_interval_secs * INTERVAL '1 second',
+	    last_crawled_at = NOW(),
+	    last_crawl_events = $1,
+	    last_crawl_window_secs = $2,
+	    cadence_pending = TRUE,
+	    empty_crawls = CASE WHEN $1 = 0 THEN empty_crawls + 1 ELSE 0 END,
+	    last_error = NULL
+	WHERE user_id = $3 AND provider = $4
+	RETURNING next_crawl_at
+`
+
+// eventIDs generates event IDs; set by main before the server starts
+var eventIDs *idgen.Generator
+
+// SetIDGenerator sets the generator for event IDs
+func SetIDGenerator(g *idgen.Generator) { eventIDs = g }
+
+// providerLimits limits calls to each provider's API, keyed by provider;
+// nil means unlimited
+var providerLimits ratelimit.Limiter
+
+// SetProviderLimiter sets the limiter for provider API calls
+func SetProviderLimiter(l ratelimit.Limiter) { providerLimits = l }
+
+// healthRedis records provider call outcomes for the crawl-scheduler's
+// health monitor; nil means not recorded
+var healthRedis *redis.Client
+
+// SetProviderHealth sets the Redis provider call outcomes are recorded in
+func SetProviderHealth(rdb *redis.Client) { healthRedis = rdb }
+
+// userQuota caps the events published per user and listen day; nil means
+// no quota
+var userQuota *quota.Quota
+
+// SetQuota sets the daily quota crawled events count against
+func SetQuota(q *quota.Quota) { userQuota = q }
+
+// eventFormat is the wire format of published events (json, proto or avro)
+var eventFormat = events.Format(getEnv("EVENT_FORMAT", string(events.FormatJSON)))
+
+// eventVersion is the schema version of published events (EVENT_SCHEMA_VERSION)
+var eventVersion = events.VersionFromEnv()
 
 // NewCrawlUserTask creates a new crawl task
 func NewCrawlUserTask(userID, provider string, since time.Time) (*asynq.Task, error) {
@@ -77,76 +128,200 @@ func HandleCrawlUserTask(ctx context.Context, t *asynq.Task) error {
 		return fmt.Errorf("unmarshal payload: %w", err)
 	}
 
-	log.Printf("Crawling user=%s provider=%s since=%d", p.UserID, p.Provider, p.Since)
+	// One trace ID per crawl, carried on every event it publishes
+	traceID := kafkautil.NewTraceID()
+	ctx = kafkautil.ContextWithTrace(ctx, traceID)
+	log.Printf("Crawling user=%s provider=%s since=%d trace=%s", p.UserID, p.Provider, p.Since, traceID)
+
+	// A job enqueued before its user was soft-deleted isn't crawled
+	if userDeleted(ctx, p.UserID) {
+		log.Printf("Skipping crawl of deleted user=%s provider=%s", p.UserID, p.Provider)
+		updateStatus(ctx, p.UserID, p.Provider, "IDLE", "")
+		return nil
+	}
 
 	// 1. Update status to RUNNING (if DB available)
-	updateStatus(p.UserID, p.Provider, "RUNNING", "")
+	updateStatus(ctx, p.UserID, p.Provider, "RUNNING", "")
+
+	// 2. Fetch listen history from provider (simulated for now), waiting for
+	//    the provider's rate limit. A limiter error lets the call through.
+	if providerLimits != nil {
+		if err := ratelimit.Wait(ctx, providerLimits, p.Provider); err != nil {
+			if ctx.Err() != nil {
+				updateStatusWithError(ctx, p.UserID, p.Provider, "IDLE", fmt.Sprintf("rate limit wait: %v", err))
+				return fmt.Errorf("wait for %s rate limit: %w", p.Provider, err)
+			}
+			log.Printf("Warning: %s rate limit unavailable: %v", p.Provider, err)
+		}
+	}
+	listens, err := fetchListenHistory(ctx, p.UserID, p.Provider, p.Since)
+	recordProviderCall(ctx, p.Provider, err)
+	if err != nil {
+		updateStatusWithError(ctx, p.UserID, p.Provider, "IDLE", fmt.Sprintf("fetch error: %v", err))
+		return fmt.Errorf("fetch from %s: %w", p.Provider, err)
+	}
 
-	// 2. Fetch listen history from provider (simulated for now)
-	events := fetchListenHistory(p.UserID, p.Provider, p.Since)
+	// 3. Events past the user's daily quota don't go to user.listen.raw. The
+	//    schedule still counts every fetched listen: the cadence follows the
+	//    user's habit, not the quota.
+	fetched := len(listens)
+	listens, over := takeQuota(ctx, listens)
+
+	// 4. With a DB: write events to the outbox and advance the schedule in one
+	//    transaction (with a registry, report to it once the outbox rows are
+	//    in). The outbox publisher drains them to Kafka in the background.
+	window := time.Since(time.Unix(p.Since, 0))
+	if db != nil {
+		if err := writeOutboxAndComplete(ctx, p.UserID, p.Provider, listens, fetched, window); err != nil {
+			refundQuota(listens)
+			updateStatusWithError(ctx, p.UserID, p.Provider, "IDLE", fmt.Sprintf("outbox error: %v", err))
+			return fmt.Errorf("write outbox: %w", err)
+		}
+		throttle(ctx, over)
+		log.Printf("Crawl complete: user=%s events=%d over_quota=%d (queued in outbox)", p.UserID, len(listens), len(over))
+		return nil
+	}
 
-	// 3. Publish events to Kafka
-	if err := publishEvents(ctx, events); err != nil {
+	// 4. Without a DB: publish events to Kafka directly
+	if err := publishEvents(ctx, listens); err != nil {
+		refundQuota(listens)
 		// Mark as IDLE so scheduler can retry
-		updateStatusWithError(p.UserID, p.Provider, "IDLE", fmt.Sprintf("publish error: %v", err))
+		updateStatusWithError(ctx, p.UserID, p.Provider, "IDLE", fmt.Sprintf("publish error: %v", err))
 		return fmt.Errorf("publish events: %w", err)
 	}
+	throttle(ctx, over)
 
-	// 4. Update DB: status=IDLE, next_crawl_at=tomorrow
-	//    Scheduler will pick it up tomorrow
-	markCrawlComplete(p.UserID, p.Provider)
+	// 5. Update DB: status=IDLE, next_crawl_at after the user's crawl interval
+	//    Scheduler will pick it up then
+	markCrawlComplete(ctx, p.UserID, p.Provider, fetched, window)
 
-	log.Printf("Crawl complete: user=%s events=%d", p.UserID, len(events))
+	log.Printf("Crawl complete: user=%s events=%d over_quota=%d", p.UserID, len(listens), len(over))
 	return nil
 }
 
-// fetchListenHistory simulates fetching from a provider API
-// TODO: replace with real provider API calls
-func fetchListenHistory(userID, provider string, since int64) []ListenEvent {
-	// Simulated: generate some fake events
-	var events []ListenEvent
-	now := time.Now().Unix()
-	for i := 0; i < 10; i++ {
-		events = append(events, ListenEvent{
-			EventID:    fmt.Sprintf("%s-%s-%d-%d", userID, provider, now, i),
-			UserID:     userID,
-			SongID:     fmt.Sprintf("song-%d", i%100),
-			Provider:   provider,
-			ListenedAt: since + int64(i*3600), // 1 hour apart
-		})
+// maxFetchEvents is the most listens one provider call returns, like a
+// provider API's page of recently played tracks
+const maxFetchEvents = 200
+
+// simulatedListensPerDay gives each simulated user a steady habit, from
+// dormant to heavy, so crawl cadences have something to adapt to
+var simulatedListensPerDay = []int{0, 4, 20, 100}
+
+// fetchListenHistory fetches the user's listens since the last crawl. A
+// provider declared in PROVIDERS_CONFIG is called over its API
+// (providers.go); the others are simulated, listens spread evenly up to now.
+// The call fails or slows down with the PROVIDER_FETCH chaos point.
+func fetchListenHistory(ctx context.Context, userID, provider string, since int64) ([]events.ListenEvent, error) {
+	if err := chaos.Inject(ctx, chaos.ProviderFetch); err != nil {
+		return nil, err
+	}
+	if spec, ok := providers[provider]; ok {
+		return fetchDeclared(ctx, spec, userID, since)
+	}
+	h := fnv.New32a()
+	h.Write([]byte(userID))
+	perDay := simulatedListensPerDay[h.Sum32()%uint32(len(simulatedListensPerDay))]
+	elapsed := time.Now().Unix() - since
+	n := int(int64(perDay) * elapsed / 86400)
+	if n > maxFetchEvents {
+		n = maxFetchEvents
 	}
-	return events
+
+	var listens []events.ListenEvent
+	for i := 0; i < n; i++ {
+		e := events.New(
+			eventIDs.NextString(),
+			userID,
+			fmt.Sprintf("song-%d", i%100),
+			provider,
+			time.Unix(since+int64(i)*elapsed/int64(n), 0),
+		)
+		e.ArtistID = fmt.Sprintf("artist-%d", i%100/10) // ten songs per artist
+		e.DurationMs = int64(150+(i%100*37)%150) * 1000 // played whole; matches metadata/songs.jsonl
+		listens = append(listens, e)
+	}
+	return listens, nil
 }
 
-// publishEvents sends events to Kafka topic user.listen.raw
-func publishEvents(ctx context.Context, events []ListenEvent) error {
-	kafkaBroker := getEnv("KAFKA_BROKER", "localhost:29092")
-	topic := "user.listen.raw"
+// recordProviderCall counts a provider API call for the health monitor. Only
+// the provider's own failures count: ours (Kafka, the outbox) say nothing
+// about its health. A failed record is logged and the crawl goes on.
+func recordProviderCall(ctx context.Context, provider string, err error) {
+	if healthRedis == nil || ctx.Err() != nil {
+		return
+	}
+	if rerr := providerhealth.Record(ctx, healthRedis, provider, err != nil); rerr != nil {
+		log.Printf("Warning: failed to record %s health: %v", provider, rerr)
+	}
+}
 
-	w := &kafka.Writer{
-		Addr:     kafka.TCP(kafkaBroker),
-		Topic:    topic,
-		Balancer: &kafka.Hash{}, // partition by key (user_id)
+// newWriter creates a Kafka writer for TOPIC (user.listen.raw)
+func newWriter() *kafka.Writer {
+	return newProducer(getEnv("TOPIC", "user.listen.raw"))
+}
+
+// publishEvents sends events to Kafka topic user.listen.raw
+func publishEvents(ctx context.Context, listens []events.ListenEvent) error {
+	if len(listens) == 0 {
+		return nil
 	}
+	w := newWriter()
 	defer w.Close()
+	return writeEvents(ctx, w, listens)
+}
 
+// writeEvents encodes listens and writes them with w
+func writeEvents(ctx context.Context, w *kafka.Writer, listens []events.ListenEvent) error {
 	var msgs []kafka.Message
-	for _, e := range events {
-		data, err := json.Marshal(e)
+	for _, e := range listens {
+		data, err := events.MarshalVersion(e, eventFormat, eventVersion)
 		if err != nil {
 			return err
 		}
+		if !fits(e.UserID, data) {
+			continue
+		}
 		msgs = append(msgs, kafka.Message{
 			Key:   []byte(e.UserID),
 			Value: data,
 		})
 	}
+	kafkautil.Stamp(msgs, kafkautil.Headers{SchemaVersion: eventVersion, PublishedAt: time.Now()})
+	kafkautil.Inject(ctx, msgs)
 
 	return w.WriteMessages(ctx, msgs...)
 }
 
-// updateStatus updates the job status in PostgreSQL
-func updateStatus(userID, provider, status, lastError string) {
+// userDeleted reports whether the crawl-scheduler soft-deleted the user. A
+// failed check lets the crawl run: a deleted user's data is kept anyway.
+func userDeleted(ctx context.Context, userID string) bool {
+	if registry != nil {
+		return registryDeleted(ctx, userID)
+	}
+	if db == nil {
+		return false
+	}
+	var deleted bool
+	err := db.QueryRowContext(ctx, `
+		SELECT EXISTS (SELECT 1 FROM user_crawl_schedule WHERE user_id = $1 AND deleted_at IS NOT NULL)
+	`, userID).Scan(&deleted)
+	if err != nil {
+		log.Printf("Warning: failed to check whether user=%s is deleted: %v", userID, err)
+		return false
+	}
+	return deleted
+}
+
+// updateStatus updates the job status in the registry or PostgreSQL
+func updateStatus(ctx context.Context, userID, provider, status, lastError string) {
+	if registry != nil {
+		if status == "RUNNING" {
+			registryStart(ctx, userID, provider)
+		} else {
+			registryFail(ctx, userID, provider, lastError)
+		}
+		return
+	}
 	if db == nil {
 		return
 	}
@@ -163,7 +338,11 @@ func updateStatus(userID, provider, status, lastError string) {
 }
 
 // updateStatusWithError updates status and records error
-func updateStatusWithError(userID, provider, status, lastError string) {
+func updateStatusWithError(ctx context.Context, userID, provider, status, lastError string) {
+	if registry != nil {
+		registryFail(ctx, userID, provider, lastError)
+		return
+	}
 	if db == nil {
 		return
 	}
@@ -179,26 +358,24 @@ func updateStatusWithError(userID, provider, status, lastError string) {
 	}
 }
 
-// markCrawlComplete sets status=IDLE and schedules next crawl for tomorrow
-func markCrawlComplete(userID, provider string) {
+// markCrawlComplete sets status=IDLE and schedules the next crawl after the
+// user's crawl interval
+func markCrawlComplete(ctx context.Context, userID, provider string, eventCount int, window time.Duration) {
+	if registry != nil {
+		registryComplete(ctx, userID, provider, eventCount, window)
+		return
+	}
 	if db == nil {
 		return
 	}
 
-	tomorrow := time.Now().Add(24 * time.Hour)
+	var next time.Time
+	err := db.QueryRow(completeCrawlSQL, eventCount, int64(window.Seconds()), userID, provider).Scan(&next)
 
-	_, err := db.Exec(`
-		UPDATE user_crawl_schedule 
-		SET status = 'IDLE', 
-		    next_crawl_at = $1,
-		    last_error = NULL
-		WHERE user_id = $2 AND provider = $3
-	`, tomorrow, userID, provider)
-
-	if err != nil {
+	if err != nil && err != sql.ErrNoRows {
 		log.Printf("Warning: failed to mark crawl complete: %v", err)
-	} else {
-		log.Printf("Scheduled next crawl for user=%s provider=%s at %v", userID, provider, tomorrow)
+	} else if err == nil {
+		log.Printf("Scheduled next crawl for user=%s provider=%s at %v", userID, provider, next)
 	}
 }
 
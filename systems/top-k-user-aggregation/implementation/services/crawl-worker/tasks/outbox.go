@@ -0,0 +1,198 @@
+package tasks
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/segmentio/kafka-go"
+	"github.com/system-design-lab/pkg/events"
+	"github.com/system-design-lab/pkg/kafkautil"
+)
+
+const maxOutboxBackoff = 30 * time.Second
+
+// writeOutboxAndComplete stores the crawled events in crawl_outbox and marks
+// the crawl complete in the same transaction, so either both happen or neither.
+// eventCount is what the crawl fetched, listens what of it is published.
+func writeOutboxAndComplete(ctx context.Context, userID, provider string, listens []events.ListenEvent, eventCount int, window time.Duration) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx, `
+		INSERT INTO crawl_outbox (msg_key, payload, trace_id)
+		VALUES ($1, $2, $3)
+	`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	traceID := kafkautil.TraceFromContext(ctx)
+	for _, e := range listens {
+		data, err := events.MarshalVersion(e, eventFormat, eventVersion)
+		if err != nil {
+			return err
+		}
+		if !fits(e.UserID, data) {
+			continue
+		}
+		if _, err := stmt.ExecContext(ctx, e.UserID, data, traceID); err != nil {
+			return fmt.Errorf("insert outbox row: %w", err)
+		}
+	}
+
+	// The registry's schedule isn't in this database: the crawl is reported
+	// after the commit (retried, RegistryRetried). A report lost past the
+	// retries leaves the row RUNNING, as a worker crashing here would.
+	if registry != nil {
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+		registryComplete(ctx, userID, provider, eventCount, window)
+		return nil
+	}
+
+	var next time.Time
+	err = tx.QueryRowContext(ctx, completeCrawlSQL, eventCount, int64(window.Seconds()), userID, provider).Scan(&next)
+	if err != nil && err != sql.ErrNoRows {
+		return fmt.Errorf("update schedule: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	log.Printf("Scheduled next crawl for user=%s provider=%s at %v", userID, provider, next)
+	return nil
+}
+
+// RunOutboxPublisher drains crawl_outbox to Kafka until ctx is cancelled.
+// Failed batches stay in the outbox and are retried with exponential backoff.
+func RunOutboxPublisher(ctx context.Context, interval time.Duration, batchSize int) {
+	if db == nil {
+		log.Println("Outbox publisher disabled (no DB)")
+		return
+	}
+
+	w := newWriter()
+	defer w.Close()
+
+	log.Printf("Outbox publisher started: interval=%s batch=%d", interval, batchSize)
+
+	wait := interval
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+
+		published, err := drainOutbox(ctx, w, batchSize)
+		if err != nil {
+			wait *= 2
+			if wait > maxOutboxBackoff {
+				wait = maxOutboxBackoff
+			}
+			log.Printf("Outbox publish failed (retry in %s): %v", wait, err)
+			continue
+		}
+		wait = interval
+		if published > 0 {
+			log.Printf("Outbox published %d events", published)
+		}
+	}
+}
+
+// drainOutbox publishes pending outbox rows in batches until none are left.
+func drainOutbox(ctx context.Context, w *kafka.Writer, batchSize int) (int, error) {
+	total := 0
+	for {
+		n, err := publishOutboxBatch(ctx, w, batchSize)
+		total += n
+		if err != nil || n < batchSize {
+			return total, err
+		}
+	}
+}
+
+// publishOutboxBatch locks up to batchSize rows, publishes them and deletes
+// them on success. SKIP LOCKED lets several workers drain the outbox at once.
+// A crash between the Kafka write and the delete republishes the batch, which
+// downstream dedup (stable event IDs) absorbs.
+func publishOutboxBatch(ctx context.Context, w *kafka.Writer, batchSize int) (int, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, `
+		SELECT id, msg_key, payload, COALESCE(trace_id, '')
+		FROM crawl_outbox
+		ORDER BY id
+		LIMIT $1
+		FOR UPDATE SKIP LOCKED
+	`, batchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	var ids []int64
+	var msgs []kafka.Message
+	for rows.Next() {
+		var id int64
+		var key string
+		var payload []byte
+		var traceID string
+		if err := rows.Scan(&id, &key, &payload, &traceID); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		ids = append(ids, id)
+		if !fits(key, payload) {
+			continue // deleted with the batch: it would fail every write
+		}
+		msg := kafka.Message{Key: []byte(key), Value: payload}
+		if traceID != "" {
+			msg.Headers = []kafka.Header{{Key: kafkautil.HeaderTraceID, Value: []byte(traceID)}}
+		}
+		msgs = append(msgs, msg)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	if len(ids) == 0 {
+		return 0, nil
+	}
+	kafkautil.Stamp(msgs, kafkautil.Headers{SchemaVersion: eventVersion, PublishedAt: time.Now()})
+	kafkautil.Inject(ctx, msgs)
+
+	if err := w.WriteMessages(ctx, msgs...); err != nil {
+		_, uerr := tx.ExecContext(ctx, `
+			UPDATE crawl_outbox
+			SET attempts = attempts + 1, last_error = $1
+			WHERE id = ANY($2)
+		`, err.Error(), pq.Array(ids))
+		if uerr != nil {
+			log.Printf("Warning: failed to record outbox error: %v", uerr)
+		} else if cerr := tx.Commit(); cerr != nil {
+			log.Printf("Warning: failed to commit outbox error: %v", cerr)
+		}
+		return 0, fmt.Errorf("publish %d events: %w", len(msgs), err)
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM crawl_outbox WHERE id = ANY($1)`, pq.Array(ids)); err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return len(ids), nil
+}
@@ -0,0 +1,52 @@
+package tasks
+
+import (
+	"log"
+	"sync"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/system-design-lab/pkg/kafkautil"
+)
+
+var (
+	producerOnce   sync.Once
+	kafkaCfg       kafkautil.Config
+	producerWriter kafkautil.WriterConfig
+)
+
+// loadProducerConfig reads the Kafka connection and producer settings from
+// the environment once (see pkg/kafkautil). A bad TLS/SASL setting is fatal.
+func loadProducerConfig() (kafkautil.Config, kafkautil.WriterConfig) {
+	producerOnce.Do(func() {
+		var err error
+		kafkaCfg, err = kafkautil.ConfigFromEnv("localhost:29092")
+		if err != nil {
+			log.Fatalf("Invalid Kafka config: %v", err)
+		}
+		producerWriter = kafkautil.WriterConfigFromEnv()
+		if producerWriter.RequiredAcks != kafka.RequireAll {
+			log.Printf("Warning: KAFKA_REQUIRED_ACKS=%s, events can be lost if a broker fails", producerWriter.RequiredAcks)
+		}
+		log.Printf("Kafka producer: brokers=%v secure=%t %s", kafkaCfg.Brokers, kafkaCfg.Secure(), producerWriter)
+	})
+	return kafkaCfg, producerWriter
+}
+
+// fits reports whether an encoded event fits in one Kafka message. kafka-go
+// fails a whole write on one message over the limit, so an oversized event
+// (a provider bug, never a real listen) is logged and dropped instead of
+// blocking the crawl or the outbox from publishing.
+func fits(key string, data []byte) bool {
+	_, wc := loadProducerConfig()
+	if err := kafkautil.CheckPayload([]byte(key), data, wc.MaxMessageBytes); err != nil {
+		log.Printf("ALERT: dropping event of user=%s: %v", key, err)
+		return false
+	}
+	return true
+}
+
+// newProducer creates a synchronous Kafka writer for the given topic
+func newProducer(topic string) *kafka.Writer {
+	cfg, wc := loadProducerConfig()
+	return cfg.NewWriter(topic, wc)
+}
@@ -0,0 +1,347 @@
+package tasks
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/system-design-lab/pkg/events"
+)
+
+// ProviderSpec declares a provider whose listen history is a plain JSON API:
+// one GET per page, a list of listens in the response, an optional cursor to
+// the next page. Providers in PROVIDERS_CONFIG are crawled with it; the rest
+// stay simulated (fetchListenHistory).
+type ProviderSpec struct {
+	Name string `json:"name"`
+	// URL of the first page; {user_id} and {since} (unix seconds) are
+	// replaced, escaped
+	BaseURL string   `json:"base_url"`
+	Auth    AuthSpec `json:"auth"`
+
+	// Dot paths into the response ("data.items") and into each listen
+	// ("track.id"); a number steps into a list ("artists.0.id")
+	Items      string `json:"items"` // the listens; empty = the response is the list
+	TrackID    string `json:"track_id"`
+	Timestamp  string `json:"timestamp"`
+	ArtistID   string `json:"artist_id,omitempty"`
+	DurationMs string `json:"duration_ms,omitempty"`
+	// TimestampFormat is unix, unix_ms or rfc3339; empty guesses per value: a
+	// string is RFC 3339, a number seconds, or milliseconds past 1e12
+	TimestampFormat string `json:"timestamp_format,omitempty"`
+
+	Cursor   CursorSpec `json:"cursor"`
+	MaxPages int        `json:"max_pages,omitempty"` // pages per crawl, default 10
+}
+
+// AuthSpec says how requests are authenticated. The secret is read from the
+// environment, TokenEnv, so the config file holds none.
+type AuthSpec struct {
+	Type     string `json:"type"`           // none (default), bearer, header, query or basic
+	TokenEnv string `json:"token_env"`      // env var of the token (basic: user:password)
+	Name     string `json:"name,omitempty"` // header: the header; query: the parameter
+}
+
+// CursorSpec follows pagination. Field is the dot path of the next page's
+// cursor in a response; none, or an empty one, ends the crawl. The cursor
+// is sent as the Param query parameter, or followed as the next page's URL
+// when Param is empty.
+type CursorSpec struct {
+	Field string `json:"field,omitempty"`
+	Param string `json:"param,omitempty"`
+}
+
+const defaultMaxPages = 10
+
+// maxProviderResponse bounds a page read from a provider
+const maxProviderResponse = 10 << 20
+
+// providers are the declared providers by name; set by main before the
+// server starts
+var providers map[string]ProviderSpec
+
+// providerClient calls the declared providers
+var providerClient = &http.Client{Timeout: 10 * time.Second}
+
+// LoadProviders reads a PROVIDERS_CONFIG file, a JSON list of ProviderSpec,
+// and checks every entry, including that its token is set
+func LoadProviders(path string) ([]ProviderSpec, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var specs []ProviderSpec
+	if err := json.Unmarshal(data, &specs); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	seen := make(map[string]bool)
+	for i, s := range specs {
+		if err := s.validate(); err != nil {
+			return nil, fmt.Errorf("%s: provider %d (%q): %w", path, i, s.Name, err)
+		}
+		if seen[s.Name] {
+			return nil, fmt.Errorf("%s: provider %q declared twice", path, s.Name)
+		}
+		seen[s.Name] = true
+	}
+	return specs, nil
+}
+
+func (s ProviderSpec) validate() error {
+	if s.Name == "" || strings.ContainsAny(s.Name, ", ") {
+		return errors.New("name must be set, without commas or spaces")
+	}
+	u, err := url.Parse(strings.NewReplacer("{user_id}", "u", "{since}", "0").Replace(s.BaseURL))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("base_url %q isn't an http(s) URL", s.BaseURL)
+	}
+	if s.TrackID == "" || s.Timestamp == "" {
+		return errors.New("track_id and timestamp paths are required")
+	}
+	switch s.TimestampFormat {
+	case "", "unix", "unix_ms", "rfc3339":
+	default:
+		return fmt.Errorf("timestamp_format %q: want unix, unix_ms or rfc3339", s.TimestampFormat)
+	}
+	switch s.Auth.Type {
+	case "", "none":
+		return nil
+	case "header", "query":
+		if s.Auth.Name == "" {
+			return fmt.Errorf("auth %s needs a name", s.Auth.Type)
+		}
+	case "bearer", "basic":
+	default:
+		return fmt.Errorf("auth type %q: want none, bearer, header, query or basic", s.Auth.Type)
+	}
+	if s.Auth.TokenEnv == "" || os.Getenv(s.Auth.TokenEnv) == "" {
+		return fmt.Errorf("auth %s: token_env %q is not set", s.Auth.Type, s.Auth.TokenEnv)
+	}
+	return nil
+}
+
+// SetProviders makes the declared providers crawled over their APIs, with
+// calls timing out after timeout
+func SetProviders(specs []ProviderSpec, timeout time.Duration) {
+	providers = make(map[string]ProviderSpec, len(specs))
+	for _, s := range specs {
+		providers[s.Name] = s
+	}
+	providerClient.Timeout = timeout
+}
+
+// fetchDeclared reads a user's listens after since from a declared
+// provider, page by page up to MaxPages. Listens at or before since (APIs
+// that ignore it), and ones without a track or a readable time, are left out.
+func fetchDeclared(ctx context.Context, spec ProviderSpec, userID string, since int64) ([]events.ListenEvent, error) {
+	maxPages := spec.MaxPages
+	if maxPages <= 0 {
+		maxPages = defaultMaxPages
+	}
+	pageURL := strings.NewReplacer(
+		"{user_id}", url.PathEscape(userID),
+		"{since}", strconv.FormatInt(since, 10),
+	).Replace(spec.BaseURL)
+
+	var listens []events.ListenEvent
+	for page := 0; page < maxPages && pageURL != ""; page++ {
+		body, err := spec.get(ctx, pageURL)
+		if err != nil {
+			return nil, err
+		}
+		items, ok := lookup(body, spec.Items)
+		list, isList := items.([]interface{})
+		if !ok || !isList {
+			return nil, fmt.Errorf("%s: no list at %q", spec.Name, spec.Items)
+		}
+		for _, item := range list {
+			e, ok := spec.listen(item, userID)
+			if ok && e.ListenedAt > since {
+				listens = append(listens, e)
+			}
+		}
+
+		next := ""
+		if spec.Cursor.Field != "" {
+			if v, ok := lookup(body, spec.Cursor.Field); ok && v != nil {
+				next = fmt.Sprint(v)
+			}
+		}
+		pageURL, err = spec.nextPage(pageURL, next)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return listens, nil
+}
+
+// get fetches and decodes one page. Numbers are kept as json.Number, so IDs
+// and millisecond times don't lose digits.
+func (s ProviderSpec) get(ctx context.Context, pageURL string) (interface{}, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, pageURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	token := os.Getenv(s.Auth.TokenEnv)
+	switch s.Auth.Type {
+	case "bearer":
+		req.Header.Set("Authorization", "Bearer "+token)
+	case "header":
+		req.Header.Set(s.Auth.Name, token)
+	case "query":
+		q := req.URL.Query()
+		q.Set(s.Auth.Name, token)
+		req.URL.RawQuery = q.Encode()
+	case "basic":
+		user, password, _ := strings.Cut(token, ":")
+		req.SetBasicAuth(user, password)
+	}
+
+	resp, err := providerClient.Do(req)
+	if err != nil {
+		// The error quotes the URL, logged and kept in last_error: not with
+		// a query token in it
+		var uerr *url.Error
+		if s.Auth.Type == "query" && errors.As(err, &uerr) {
+			uerr.URL = pageURL
+		}
+		return nil, fmt.Errorf("%s: %w", s.Name, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		io.Copy(io.Discard, io.LimitReader(resp.Body, maxProviderResponse))
+		return nil, fmt.Errorf("%s: HTTP %d", s.Name, resp.StatusCode)
+	}
+	dec := json.NewDecoder(io.LimitReader(resp.Body, maxProviderResponse))
+	dec.UseNumber()
+	var body interface{}
+	if err := dec.Decode(&body); err != nil {
+		return nil, fmt.Errorf("%s: decode response: %w", s.Name, err)
+	}
+	return body, nil
+}
+
+// nextPage is the URL after pageURL for cursor; "" when there is none
+func (s ProviderSpec) nextPage(pageURL, cursor string) (string, error) {
+	if cursor == "" {
+		return "", nil
+	}
+	if s.Cursor.Param == "" {
+		// A next-page link, possibly relative
+		base, err := url.Parse(pageURL)
+		if err != nil {
+			return "", err
+		}
+		next, err := base.Parse(cursor)
+		if err != nil {
+			return "", fmt.Errorf("%s: next page %q: %w", s.Name, cursor, err)
+		}
+		// The credentials go with every page: only to the provider
+		if next.Scheme != base.Scheme || next.Host != base.Host {
+			return "", fmt.Errorf("%s: next page %q is on another host", s.Name, cursor)
+		}
+		return next.String(), nil
+	}
+	u, err := url.Parse(pageURL)
+	if err != nil {
+		return "", err
+	}
+	q := u.Query()
+	q.Set(s.Cursor.Param, cursor)
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+// listen maps one item of a response to a listen event
+func (s ProviderSpec) listen(item interface{}, userID string) (events.ListenEvent, bool) {
+	track, ok := lookup(item, s.TrackID)
+	if !ok || track == nil || fmt.Sprint(track) == "" {
+		return events.ListenEvent{}, false
+	}
+	raw, _ := lookup(item, s.Timestamp)
+	at, ok := parseTimestamp(raw, s.TimestampFormat)
+	if !ok {
+		return events.ListenEvent{}, false
+	}
+	e := events.New(eventIDs.NextString(), userID, fmt.Sprint(track), s.Name, at)
+	if s.ArtistID != "" {
+		if v, ok := lookup(item, s.ArtistID); ok && v != nil {
+			e.ArtistID = fmt.Sprint(v)
+		}
+	}
+	if s.DurationMs != "" {
+		if v, ok := lookup(item, s.DurationMs); ok {
+			if n, ok := v.(json.Number); ok {
+				e.DurationMs, _ = n.Int64()
+			}
+		}
+	}
+	return e, true
+}
+
+// parseTimestamp reads a listen time in format ("" = guess)
+func parseTimestamp(v interface{}, format string) (time.Time, bool) {
+	switch v := v.(type) {
+	case json.Number:
+		n, err := v.Int64()
+		if err != nil {
+			f, ferr := v.Float64()
+			if ferr != nil {
+				return time.Time{}, false
+			}
+			n = int64(f)
+		}
+		if format == "unix_ms" || (format == "" && n > 1e12) {
+			return time.UnixMilli(n), true
+		}
+		if format == "" || format == "unix" {
+			return time.Unix(n, 0), true
+		}
+	case string:
+		if format == "" || format == "rfc3339" {
+			t, err := time.Parse(time.RFC3339, v)
+			return t, err == nil
+		}
+		// Numbers sent as strings
+		if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+			return parseTimestamp(json.Number(strconv.FormatInt(n, 10)), format)
+		}
+	}
+	return time.Time{}, false
+}
+
+// lookup follows a dot path through decoded JSON: keys of objects, indexes
+// of lists. The empty path is v itself.
+func lookup(v interface{}, path string) (interface{}, bool) {
+	if path == "" {
+		return v, true
+	}
+	for _, step := range strings.Split(path, ".") {
+		switch node := v.(type) {
+		case map[string]interface{}:
+			next, ok := node[step]
+			if !ok {
+				return nil, false
+			}
+			v = next
+		case []interface{}:
+			i, err := strconv.Atoi(step)
+			if err != nil || i < 0 || i >= len(node) {
+				return nil, false
+			}
+			v = node[i]
+		default:
+			return nil, false
+		}
+	}
+	return v, true
+}
@@ -0,0 +1,54 @@
+package tasks
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/system-design-lab/pkg/events"
+)
+
+// takeQuota splits a crawl's listens into those within the user's daily
+// quota and those over it. Without Redis every listen is let through.
+func takeQuota(ctx context.Context, listens []events.ListenEvent) (within, over []events.ListenEvent) {
+	flags, err := userQuota.Take(ctx, listens)
+	if err != nil {
+		log.Printf("Warning: %v (letting the events through)", err)
+	}
+	if flags == nil {
+		return listens, nil
+	}
+	for i, e := range listens {
+		if flags[i] {
+			over = append(over, e)
+		} else {
+			within = append(within, e)
+		}
+	}
+	return within, over
+}
+
+// refundQuota gives back the quota of listens a failed crawl didn't publish,
+// so its retry isn't counted twice
+func refundQuota(listens []events.ListenEvent) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := userQuota.Refund(ctx, listens); err != nil {
+		log.Printf("Warning: %v", err)
+	}
+}
+
+// throttle publishes listens over the quota to the throttled topic, or drops
+// them with QUOTA_ACTION=drop. They are written directly, not through the
+// outbox: a failed write loses them, which for events over the quota is
+// logged rather than failing the crawl.
+func throttle(ctx context.Context, over []events.ListenEvent) {
+	if len(over) == 0 || !userQuota.Throttled() {
+		return
+	}
+	w := newProducer(userQuota.Config().Topic)
+	defer w.Close()
+	if err := writeEvents(ctx, w, over); err != nil {
+		log.Printf("Warning: failed to publish %d throttled events: %v", len(over), err)
+	}
+}
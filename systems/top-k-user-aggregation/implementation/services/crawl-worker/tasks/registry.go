@@ -0,0 +1,84 @@
+package tasks
+
+import (
+	"context"
+	"log"
+	"time"
+
+	topkv1 "github.com/system-design-lab/pkg/gen/topk/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// RegistryRetried are the UserRegistry calls the worker makes, all safe to
+// repeat: a retried report rewrites the same row
+var RegistryRetried = []string{
+	"topk.v1.UserRegistry/GetUser",
+	"topk.v1.UserRegistry/StartCrawl",
+	"topk.v1.UserRegistry/ReportCrawl",
+}
+
+// registry is the crawl-scheduler's UserRegistry; nil means the schedule is
+// written in Postgres directly
+var registry topkv1.UserRegistryClient
+
+// SetRegistry sets the UserRegistry crawls are reported to
+func SetRegistry(c topkv1.UserRegistryClient) { registry = c }
+
+// registryCtx outlives the task: a crawl cancelled by asynq still reports.
+// The client's per-call deadline (REGISTRY_TIMEOUT) bounds each call.
+func registryCtx(ctx context.Context) context.Context {
+	return context.WithoutCancel(ctx)
+}
+
+func target(userID, provider string) *topkv1.CrawlTarget {
+	return &topkv1.CrawlTarget{UserId: userID, Provider: provider}
+}
+
+// registryDeleted is userDeleted over the registry
+func registryDeleted(ctx context.Context, userID string) bool {
+	u, err := registry.GetUser(registryCtx(ctx), &topkv1.GetUserRequest{UserId: userID})
+	if status.Code(err) == codes.NotFound {
+		return false
+	}
+	if err != nil {
+		log.Printf("Warning: failed to check whether user=%s is deleted: %v", userID, err)
+		return false
+	}
+	return u.DeletedAt != nil
+}
+
+// registryStart moves the crawl to RUNNING
+func registryStart(ctx context.Context, userID, provider string) {
+	_, err := registry.StartCrawl(registryCtx(ctx), &topkv1.StartCrawlRequest{Target: target(userID, provider)})
+	if err != nil {
+		log.Printf("Warning: failed to update status: %v", err)
+	}
+}
+
+// registryFail puts the crawl back to IDLE, still due, with lastError
+func registryFail(ctx context.Context, userID, provider, lastError string) {
+	_, err := registry.ReportCrawl(registryCtx(ctx), &topkv1.ReportCrawlRequest{
+		Target: target(userID, provider),
+		Error:  lastError,
+	})
+	if err != nil {
+		log.Printf("Warning: failed to update status with error: %v", err)
+	}
+}
+
+// registryComplete reports a crawl done; the registry schedules the next one
+func registryComplete(ctx context.Context, userID, provider string, eventCount int, window time.Duration) {
+	resp, err := registry.ReportCrawl(registryCtx(ctx), &topkv1.ReportCrawlRequest{
+		Target: target(userID, provider),
+		Ok:     true,
+		Events: int32(eventCount),
+		Window: durationpb.New(window),
+	})
+	if err != nil {
+		log.Printf("Warning: failed to mark crawl complete: %v", err)
+		return
+	}
+	log.Printf("Scheduled next crawl for user=%s provider=%s at %v", userID, provider, resp.NextCrawlAt.AsTime())
+}
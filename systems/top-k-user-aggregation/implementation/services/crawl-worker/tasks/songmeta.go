@@ -0,0 +1,145 @@
+package tasks
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/hibiken/asynq"
+	"github.com/system-design-lab/pkg/chaos"
+	"github.com/system-design-lab/pkg/ratelimit"
+	"github.com/system-design-lab/pkg/storage"
+)
+
+const TypeCrawlSongMetadata = "crawl:song-metadata"
+
+// SongMetadataQueue is the asynq queue of metadata lookups, served at a
+// lower priority than the listen crawls
+const SongMetadataQueue = "song-metadata"
+
+// SongMetadataPayload is the job payload: songs seen in aggregates without
+// a song_metadata entry
+type SongMetadataPayload struct {
+	SongIDs []string `json:"song_ids"`
+}
+
+// NewCrawlSongMetadataTask creates a metadata lookup for songIDs
+func NewCrawlSongMetadataTask(songIDs []string) (*asynq.Task, error) {
+	payload, err := json.Marshal(SongMetadataPayload{SongIDs: songIDs})
+	if err != nil {
+		return nil, err
+	}
+	return asynq.NewTask(TypeCrawlSongMetadata, payload), nil
+}
+
+// metadataRepo stores what the lookups find; set by main with a catalog
+var metadataRepo *storage.SongMetadataRepo
+
+// catalogProviders are asked for songs in turn, until one knows them
+var catalogProviders []string
+
+// catalog stands in for the providers' catalog APIs, like
+// fetchListenHistory does for their listen histories
+var catalog map[string]storage.SongMetadata
+
+// SetSongMetadata enables metadata lookups: providers are asked in order,
+// their catalog simulated by the entries of catalogFile (JSON lines of
+// storage.SongMetadata, like metadata/songs.jsonl), and found songs written
+// to repo
+func SetSongMetadata(repo *storage.SongMetadataRepo, providers []string, catalogFile string) error {
+	data, err := os.ReadFile(catalogFile)
+	if err != nil {
+		return err
+	}
+	entries := make(map[string]storage.SongMetadata)
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; sc.Scan(); n++ {
+		line := bytes.TrimSpace(sc.Bytes())
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+		var m storage.SongMetadata
+		if err := json.Unmarshal(line, &m); err != nil || m.SongID == "" {
+			return fmt.Errorf("%s:%d: not a song entry", catalogFile, n)
+		}
+		entries[m.SongID] = m
+	}
+	if err := sc.Err(); err != nil {
+		return err
+	}
+	metadataRepo, catalogProviders, catalog = repo, providers, entries
+	return nil
+}
+
+// HandleCrawlSongMetadataTask looks songs up in the providers' catalogs and
+// writes the entries found to song_metadata. Songs no provider knows are
+// left out; the materializer asks again for them after its retry interval.
+func HandleCrawlSongMetadataTask(ctx context.Context, t *asynq.Task) error {
+	var p SongMetadataPayload
+	if err := json.Unmarshal(t.Payload(), &p); err != nil {
+		return fmt.Errorf("unmarshal payload: %w: %w", err, asynq.SkipRetry)
+	}
+	if metadataRepo == nil {
+		return fmt.Errorf("song metadata lookups are disabled (no SONG_CATALOG): %w", asynq.SkipRetry)
+	}
+
+	missing := p.SongIDs
+	found := 0
+	for _, provider := range catalogProviders {
+		if len(missing) == 0 {
+			break
+		}
+		// Waits for the same per-provider limit as the listen crawls
+		if providerLimits != nil {
+			if err := ratelimit.Wait(ctx, providerLimits, provider); err != nil {
+				if ctx.Err() != nil {
+					return fmt.Errorf("wait for %s rate limit: %w", provider, err)
+				}
+				log.Printf("Warning: %s rate limit unavailable: %v", provider, err)
+			}
+		}
+		entries, err := fetchSongMetadata(ctx, provider, missing)
+		if err != nil {
+			return fmt.Errorf("look up %d songs at %s: %w", len(missing), provider, err)
+		}
+		var rest []string
+		for _, id := range missing {
+			m, ok := entries[id]
+			if !ok {
+				rest = append(rest, id)
+				continue
+			}
+			// Written one by one: a retry after a failure rewrites the
+			// same entries, which is harmless
+			if err := metadataRepo.Put(ctx, m); err != nil {
+				return fmt.Errorf("put metadata of %s: %w", id, err)
+			}
+			found++
+		}
+		missing = rest
+	}
+
+	log.Printf("Song metadata: %d of %d songs found, %d unknown to %v", found, len(p.SongIDs), len(missing), catalogProviders)
+	return nil
+}
+
+// fetchSongMetadata simulates a provider's catalog API: the catalog
+// entries of songIDs that have one. The call fails or slows down with the
+// PROVIDER_FETCH chaos point.
+// TODO: replace with real provider API calls
+func fetchSongMetadata(ctx context.Context, provider string, songIDs []string) (map[string]storage.SongMetadata, error) {
+	if err := chaos.Inject(ctx, chaos.ProviderFetch); err != nil {
+		return nil, err
+	}
+	entries := make(map[string]storage.SongMetadata)
+	for _, id := range songIDs {
+		if m, ok := catalog[id]; ok {
+			entries[id] = m
+		}
+	}
+	return entries, nil
+}
@@ -0,0 +1,331 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/system-design-lab/pkg/events"
+	"github.com/system-design-lab/pkg/kafkautil"
+)
+
+// Failure categories, from the most specific
+const (
+	categoryOversized  = "oversized"  // over MAX_EVENT_BYTES, whatever else is wrong with it
+	categoryBadJSON    = "bad_json"   // doesn't decode at all (JSON, protobuf or Avro)
+	categoryValidation = "validation" // decodes, but fails events.Validate
+	categoryWrite      = "write"      // a valid event the sink kept failing on
+	categoryOther      = "other"      // valid, dead-lettered for a reason this build doesn't know
+)
+
+// unknown stands in for a producer or provider the message doesn't name
+const unknown = "unknown"
+
+// failure is one classified DLQ message
+type failure struct {
+	Category string
+	Producer string // the origin header (pkg/kafkautil), unknown before it existed
+	Provider string // from the payload, unknown when unreadable
+	EventID  string
+	Error    string // dlq.error, or the decode error
+}
+
+// classify re-decodes a DLQ message to tell why it failed and who sent it.
+// The provider and event ID of a payload events.Unmarshal rejects are read
+// leniently, so a producer sending malformed events is still named.
+func classify(msg kafka.Message, maxBytes int) failure {
+	f := failure{Producer: unknown, Provider: unknown}
+	if h := kafkautil.ParseHeaders(msg); h.Origin != "" {
+		f.Producer = h.Origin
+	}
+	f.Error, _ = kafkautil.Header(msg, kafkautil.HeaderDLQError)
+
+	v, _ := kafkautil.Header(msg, kafkautil.HeaderDLQTruncated)
+	truncated := v == "true" // dead-lettered as oversized, only the start kept
+
+	event, err := events.Unmarshal(msg.Value)
+	if err == nil {
+		f.Provider, f.EventID = orUnknown(event.Provider), event.EventID
+		err = event.Validate()
+	} else {
+		var partial struct {
+			EventID  string `json:"event_id"`
+			Provider string `json:"provider"`
+		}
+		if json.Unmarshal(msg.Value, &partial) == nil {
+			f.Provider, f.EventID = orUnknown(partial.Provider), partial.EventID
+		} else if truncated {
+			// Only the start of the value was kept: read the fields it still has
+			f.Provider, f.EventID = orUnknown(jsonField(msg.Value, "provider")), jsonField(msg.Value, "event_id")
+		}
+	}
+	if f.Error == "" && err != nil {
+		f.Error = err.Error()
+	}
+
+	reason, _ := kafkautil.Header(msg, kafkautil.HeaderDLQReason)
+	switch {
+	case reason == "oversized" || truncated || len(msg.Value) > maxBytes:
+		f.Category = categoryOversized
+	case err != nil && errors.Is(err, events.ErrInvalid):
+		f.Category = categoryValidation
+	case err != nil:
+		f.Category = categoryBadJSON
+	case reason == "write":
+		f.Category = categoryWrite
+	default:
+		f.Category = categoryOther
+	}
+	return f
+}
+
+// fieldPattern matches a JSON string field, for payloads cut short
+var fieldPattern = regexp.MustCompile(`"(event_id|provider)"\s*:\s*"([^"\\]*)"`)
+
+// jsonField is the first value of a string field in a possibly incomplete
+// JSON object, empty when it isn't there
+func jsonField(data []byte, name string) string {
+	for _, m := range fieldPattern.FindAllSubmatch(data, -1) {
+		if string(m[1]) == name {
+			return string(m[2])
+		}
+	}
+	return ""
+}
+
+func orUnknown(s string) string {
+	if s == "" {
+		return unknown
+	}
+	return s
+}
+
+// source is a report line: who sent what kind of failure
+type source struct {
+	Producer string `json:"producer"`
+	Provider string `json:"provider"`
+	Category string `json:"category"`
+}
+
+type sourceCounts struct {
+	Count      int64     `json:"count"`
+	Duplicates int64     `json:"duplicates"` // event IDs already dead-lettered in the window
+	LastError  string    `json:"last_error,omitempty"`
+	LastSeen   time.Time `json:"last_seen"`
+}
+
+// Analyzer keeps per-minute counts of DLQ failures by source over a window,
+// plus the event IDs dead-lettered recently. An event ID showing up again is
+// a duplicate: a producer re-sending an event that can't succeed, or the same
+// poison message replayed into the pipeline.
+type Analyzer struct {
+	maxBytes  int
+	window    time.Duration
+	threshold int64 // failures per producer per hour that raise an alert (0 = off)
+
+	mu      sync.Mutex
+	minutes map[int64]map[source]*sourceCounts // by unix minute
+	seen    *recentIDs
+	alerted map[string]time.Time // producer -> last alert
+}
+
+func NewAnalyzer(maxBytes int, window time.Duration, recent int, threshold int64) *Analyzer {
+	return &Analyzer{
+		maxBytes:  maxBytes,
+		window:    window,
+		threshold: threshold,
+		minutes:   make(map[int64]map[source]*sourceCounts),
+		seen:      newRecentIDs(recent),
+		alerted:   make(map[string]time.Time),
+	}
+}
+
+// Add counts one DLQ message at the time it was dead-lettered. Messages older
+// than the window are skipped: on start the whole topic is read again.
+func (a *Analyzer) Add(msg kafka.Message) {
+	at := msg.Time
+	if at.IsZero() {
+		at = time.Now()
+	}
+	if time.Since(at) > a.window {
+		metricSkippedOld.Add(1)
+		return
+	}
+	f := classify(msg, a.maxBytes)
+	src := source{f.Producer, f.Provider, f.Category}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	minute := at.Unix() / 60
+	bySource, ok := a.minutes[minute]
+	if !ok {
+		bySource = make(map[source]*sourceCounts)
+		a.minutes[minute] = bySource
+	}
+	c, ok := bySource[src]
+	if !ok {
+		c = &sourceCounts{}
+		bySource[src] = c
+	}
+	c.Count++
+	if f.EventID != "" && !a.seen.add(f.EventID) {
+		c.Duplicates++
+		metricDuplicates.Add(1)
+	}
+	c.LastError, c.LastSeen = f.Error, at
+
+	metricAnalyzed.Add(1)
+	metricByCategory.Add(f.Category, 1)
+}
+
+// Report is the response of GET /report
+type Report struct {
+	Window     string           `json:"window"`
+	Since      time.Time        `json:"since"`
+	Total      int64            `json:"total"`
+	Duplicates int64            `json:"duplicates"`
+	ByCategory map[string]int64 `json:"by_category"`
+	ByProducer map[string]int64 `json:"by_producer"`
+	ByProvider map[string]int64 `json:"by_provider"`
+	Sources    []SourceReport   `json:"sources"` // most failures first
+}
+
+type SourceReport struct {
+	source
+	sourceCounts
+}
+
+// Report sums the last window (at most the analyzer's) into the top limit
+// sources
+func (a *Analyzer) Report(window time.Duration, limit int) Report {
+	since := time.Now().Add(-window).Truncate(time.Minute)
+	r := Report{
+		Window:     window.String(),
+		Since:      since,
+		ByCategory: make(map[string]int64),
+		ByProducer: make(map[string]int64),
+		ByProvider: make(map[string]int64),
+	}
+	totals := a.sum(since)
+	for src, c := range totals {
+		r.Total += c.Count
+		r.Duplicates += c.Duplicates
+		r.ByCategory[src.Category] += c.Count
+		r.ByProducer[src.Producer] += c.Count
+		r.ByProvider[src.Provider] += c.Count
+		r.Sources = append(r.Sources, SourceReport{src, *c})
+	}
+	sort.Slice(r.Sources, func(i, j int) bool {
+		if r.Sources[i].Count != r.Sources[j].Count {
+			return r.Sources[i].Count > r.Sources[j].Count
+		}
+		return r.Sources[i].LastSeen.After(r.Sources[j].LastSeen)
+	})
+	if len(r.Sources) > limit {
+		r.Sources = r.Sources[:limit]
+	}
+	return r
+}
+
+func (a *Analyzer) sum(since time.Time) map[source]*sourceCounts {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	from := since.Unix() / 60
+	out := make(map[source]*sourceCounts)
+	for minute, bySource := range a.minutes {
+		if minute < from {
+			continue
+		}
+		for src, c := range bySource {
+			t, ok := out[src]
+			if !ok {
+				t = &sourceCounts{}
+				out[src] = t
+			}
+			t.Count += c.Count
+			t.Duplicates += c.Duplicates
+			if c.LastSeen.After(t.LastSeen) {
+				t.LastError, t.LastSeen = c.LastError, c.LastSeen
+			}
+		}
+	}
+	return out
+}
+
+// Tick drops the minutes past the window and alerts on producers over the
+// threshold in the last hour, once an hour each
+func (a *Analyzer) Tick(now time.Time) {
+	a.mu.Lock()
+	oldest := now.Add(-a.window).Unix() / 60
+	for minute := range a.minutes {
+		if minute < oldest {
+			delete(a.minutes, minute)
+		}
+	}
+	metricMinutesKept.Set(int64(len(a.minutes)))
+	a.mu.Unlock()
+
+	if a.threshold <= 0 {
+		return
+	}
+	type producerHour struct {
+		total, duplicates int64
+		top               source
+		topCount          int64
+	}
+	producers := make(map[string]*producerHour)
+	for src, c := range a.sum(now.Add(-time.Hour)) {
+		p, ok := producers[src.Producer]
+		if !ok {
+			p = &producerHour{}
+			producers[src.Producer] = p
+		}
+		p.total += c.Count
+		p.duplicates += c.Duplicates
+		if c.Count > p.topCount {
+			p.top, p.topCount = src, c.Count
+		}
+	}
+	for producer, p := range producers {
+		if p.total < a.threshold || now.Sub(a.alerted[producer]) < time.Hour {
+			continue
+		}
+		a.alerted[producer] = now
+		metricAlerts.Add(1)
+		log.Printf("ALERT: producer %s had %d events dead-lettered in the last hour (%d duplicates), mostly %s from provider %s (%d)",
+			producer, p.total, p.duplicates, p.top.Category, p.top.Provider, p.topCount)
+	}
+}
+
+// recentIDs remembers the last n event IDs added
+type recentIDs struct {
+	ids  map[string]struct{}
+	ring []string
+	next int
+}
+
+func newRecentIDs(n int) *recentIDs {
+	return &recentIDs{ids: make(map[string]struct{}, n), ring: make([]string, n)}
+}
+
+// add records id and reports whether it was new
+func (r *recentIDs) add(id string) bool {
+	if len(r.ring) == 0 {
+		return true
+	}
+	if _, ok := r.ids[id]; ok {
+		return false
+	}
+	if old := r.ring[r.next]; old != "" {
+		delete(r.ids, old)
+	}
+	r.ring[r.next] = id
+	r.ids[id] = struct{}{}
+	r.next = (r.next + 1) % len(r.ring)
+	return true
+}
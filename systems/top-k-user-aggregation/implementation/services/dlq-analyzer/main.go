@@ -0,0 +1,167 @@
+// Command dlq-analyzer reads the raw-event-processor's dead-letter queue,
+// classifies every message (bad JSON, validation, oversized, write) and
+// counts them by producer and provider, so a bad upstream shows up in one
+// report instead of in a grep through the DLQ. Event IDs dead-lettered again
+// are counted as duplicates.
+//
+// It never commits offsets: on start it reads the whole DLQ retention again
+// and keeps the last REPORT_WINDOW, so the report survives restarts.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/system-design-lab/pkg/kafkautil"
+	"github.com/system-design-lab/pkg/runtimecfg"
+	"github.com/system-design-lab/pkg/startup"
+	"github.com/system-design-lab/pkg/topology"
+)
+
+func main() {
+	if _, err := topology.Apply("dlq-analyzer"); err != nil {
+		log.Fatalf("Invalid pipeline config: %v", err)
+	}
+
+	topic := getEnv("DLQ_TOPIC", "user.listen.raw.dlq")
+	consumerGroup := getEnv("CONSUMER_GROUP", "dlq-analyzer")
+	maxBytes := getEnvInt("MAX_EVENT_BYTES", 8192)
+	window := getEnvDuration("REPORT_WINDOW", 24*time.Hour)
+	recent := getEnvInt("RECENT_IDS", 100000)
+	threshold := getEnvInt("ALERT_THRESHOLD", 100)
+	port := getEnv("PORT", "9110")
+
+	kafkaCfg, err := kafkautil.ConfigFromEnv("localhost:29092")
+	if err != nil {
+		log.Fatalf("Invalid Kafka config: %v", err)
+	}
+
+	log.Printf("Starting dlq-analyzer: kafka=%v topic=%s group=%s max_event_bytes=%d window=%s alert_threshold=%d/h",
+		kafkaCfg.Brokers, topic, consumerGroup, maxBytes, window, threshold)
+
+	if err := startup.Kafka(context.Background(), kafkaCfg); err != nil {
+		log.Fatalf("Failed to connect to Kafka: %v", err)
+	}
+
+	reader := kafkaCfg.NewReader(kafkautil.ReaderConfig{Topic: topic, GroupID: consumerGroup})
+	defer reader.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-sigChan
+		log.Println("Shutting down...")
+		cancel()
+	}()
+
+	// log_level and SIGHUP reloads of pipeline.yaml's settings (pkg/runtimecfg)
+	if err := runtimecfg.New(nil, "dlq-analyzer").Start(ctx); err != nil {
+		log.Printf("Warning: runtime config not loaded: %v", err)
+	}
+
+	a := NewAnalyzer(maxBytes, window, recent, int64(threshold))
+	go func() {
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				a.Tick(now)
+			}
+		}
+	}()
+
+	http.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+	http.HandleFunc("/report", reportHandler(a, window))
+	go func() {
+		log.Printf("Report on http://:%s/report, metrics on /debug/vars", port)
+		if err := http.ListenAndServe(":"+port, nil); err != nil {
+			log.Printf("HTTP server error: %v", err)
+		}
+	}()
+
+	for {
+		msg, err := reader.FetchMessage(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				break
+			}
+			log.Printf("Error fetching message: %v", err)
+			continue
+		}
+		a.Add(msg)
+	}
+
+	log.Println("Shutdown complete")
+}
+
+// reportHandler serves GET /report?window=1h&limit=50
+func reportHandler(a *Analyzer, maxWindow time.Duration) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		window := time.Hour
+		if v := r.URL.Query().Get("window"); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil || d <= 0 || d > maxWindow {
+				http.Error(w, "window must be a duration up to "+maxWindow.String(), http.StatusBadRequest)
+				return
+			}
+			window = d
+		}
+		limit := 50
+		if v := r.URL.Query().Get("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 || n > 1000 {
+				http.Error(w, "limit must be 1-1000", http.StatusBadRequest)
+				return
+			}
+			limit = n
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(a.Report(window, limit))
+	}
+}
+
+func getEnv(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
+
+func getEnvInt(key string, fallback int) int {
+	if v := os.Getenv(key); v != "" {
+		i, err := strconv.Atoi(v)
+		if err == nil {
+			return i
+		}
+	}
+	return fallback
+}
+
+func getEnvDuration(key string, fallback time.Duration) time.Duration {
+	if v := os.Getenv(key); v != "" {
+		d, err := time.ParseDuration(v)
+		if err == nil {
+			return d
+		}
+	}
+	return fallback
+}
@@ -0,0 +1,13 @@
+package main
+
+import "expvar"
+
+// DLQ analyzer metrics, served as JSON on PORT/debug/vars
+var (
+	metricAnalyzed    = expvar.NewInt("dlq_messages_analyzed")
+	metricByCategory  = expvar.NewMap("dlq_by_category")
+	metricDuplicates  = expvar.NewInt("dlq_duplicates")  // event IDs dead-lettered again
+	metricSkippedOld  = expvar.NewInt("dlq_skipped_old") // older than REPORT_WINDOW
+	metricMinutesKept = expvar.NewInt("report_minutes")
+	metricAlerts      = expvar.NewInt("producer_alerts")
+)
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/system-design-lab/pkg/events"
+)
+
+// listen is the message pushed for every matching event: the event's public
+// fields, without the play context
+type listen struct {
+	Type       string `json:"type"` // "listen"
+	EventID    string `json:"event_id"`
+	UserID     string `json:"user_id"`
+	SongID     string `json:"song_id"`
+	ArtistID   string `json:"artist_id,omitempty"`
+	Provider   string `json:"provider"`
+	ListenedAt int64  `json:"listened_at"`
+}
+
+// dropped tells a client how many events it missed while it was behind
+type dropped struct {
+	Type  string `json:"type"` // "dropped"
+	Count int64  `json:"count"`
+}
+
+// filter is a client's subscription. An empty set matches everything, so a
+// client with no filter gets the whole firehose.
+type filter struct {
+	Users map[string]bool
+	Songs map[string]bool
+}
+
+func newFilter(users, songs []string) *filter {
+	f := &filter{}
+	if len(users) > 0 {
+		f.Users = make(map[string]bool, len(users))
+		for _, u := range users {
+			f.Users[u] = true
+		}
+	}
+	if len(songs) > 0 {
+		f.Songs = make(map[string]bool, len(songs))
+		for _, s := range songs {
+			f.Songs[s] = true
+		}
+	}
+	return f
+}
+
+func (f *filter) match(e events.ListenEvent) bool {
+	return (f.Users == nil || f.Users[e.UserID]) && (f.Songs == nil || f.Songs[e.SongID])
+}
+
+// client is one WebSocket subscriber. The hub never blocks on it: events that
+// don't fit in its buffer are dropped and counted, and a client whose buffer
+// stays full for slowTimeout is disconnected.
+type client struct {
+	send   chan []byte
+	filter atomic.Pointer[filter]
+
+	dropped   atomic.Int64 // since the last notice
+	fullSince atomic.Int64 // unix nanos the buffer has been full since, 0 = not full
+
+	done     chan struct{}
+	doneOnce sync.Once
+	reason   atomic.Value // why it was closed, for clients_disconnected
+}
+
+func newClient(buffer int, f *filter) *client {
+	c := &client{send: make(chan []byte, buffer), done: make(chan struct{})}
+	c.filter.Store(f)
+	return c
+}
+
+// close disconnects the client, recording the first reason given
+func (c *client) close(reason string) {
+	c.doneOnce.Do(func() {
+		c.reason.Store(reason)
+		close(c.done)
+	})
+}
+
+// offer queues data without blocking and reports whether the client is now
+// too slow to keep
+func (c *client) offer(data []byte, now time.Time, slowTimeout time.Duration) bool {
+	select {
+	case c.send <- data:
+		c.fullSince.Store(0)
+		return false
+	default:
+	}
+	c.dropped.Add(1)
+	metricEventsDropped.Add(1)
+	since := c.fullSince.Load()
+	if since == 0 {
+		c.fullSince.CompareAndSwap(0, now.UnixNano())
+		return false
+	}
+	return now.Sub(time.Unix(0, since)) > slowTimeout
+}
+
+// hub fans events out to the connected clients
+type hub struct {
+	mu          sync.RWMutex
+	clients     map[*client]struct{}
+	max         int
+	slowTimeout time.Duration
+}
+
+func newHub(max int, slowTimeout time.Duration) *hub {
+	return &hub{clients: make(map[*client]struct{}), max: max, slowTimeout: slowTimeout}
+}
+
+// add registers c, or returns false when the hub is full
+func (h *hub) add(c *client) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.clients) >= h.max {
+		return false
+	}
+	h.clients[c] = struct{}{}
+	metricClients.Set(int64(len(h.clients)))
+	return true
+}
+
+func (h *hub) remove(c *client) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.clients, c)
+	metricClients.Set(int64(len(h.clients)))
+}
+
+// broadcast offers an event to every client whose filter matches. data is
+// the encoded listen, shared by all of them.
+func (h *hub) broadcast(e events.ListenEvent, data []byte) {
+	now := time.Now()
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for c := range h.clients {
+		if !c.filter.Load().match(e) {
+			continue
+		}
+		if c.offer(data, now, h.slowTimeout) {
+			c.close(closeSlow)
+		}
+	}
+}
+
+// closeAll disconnects every client, on shutdown
+func (h *hub) closeAll() {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for c := range h.clients {
+		c.close(closeShutdown)
+	}
+}
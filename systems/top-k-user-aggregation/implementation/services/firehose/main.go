@@ -0,0 +1,175 @@
+// Command firehose pushes the listen events of user.listen.raw to WebSocket
+// clients as they arrive, for live "now playing across the platform" views.
+// Clients subscribe to everything or to some users and songs.
+//
+// It is best effort, not a delivery channel: each instance reads from the
+// end of the topic under its own consumer group and never commits, and a
+// client that can't keep up misses events (it is told how many) and is
+// disconnected if it stays behind.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/segmentio/kafka-go"
+	"github.com/system-design-lab/pkg/events"
+	"github.com/system-design-lab/pkg/kafkautil"
+	"github.com/system-design-lab/pkg/runtimecfg"
+	"github.com/system-design-lab/pkg/startup"
+	"github.com/system-design-lab/pkg/topology"
+	"github.com/system-design-lab/pkg/userstate"
+)
+
+func main() {
+	if _, err := topology.Apply("firehose"); err != nil {
+		log.Fatalf("Invalid pipeline config: %v", err)
+	}
+
+	hostname, _ := os.Hostname()
+	topic := getEnv("TOPIC", "user.listen.raw")
+	consumerGroup := getEnv("CONSUMER_GROUP", "firehose-"+hostname)
+	redisAddr := getEnv("REDIS_ADDR", "localhost:6379")
+	port := getEnv("PORT", "9111")
+	maxClients := getEnvInt("MAX_CLIENTS", 1000)
+	buffer := getEnvInt("CLIENT_BUFFER", 256)
+	slowTimeout := getEnvDuration("SLOW_CLIENT_TIMEOUT", 10*time.Second)
+	writeTimeout := getEnvDuration("WRITE_TIMEOUT", 10*time.Second)
+	maxIDs := getEnvInt("MAX_FILTER_IDS", 100)
+	origins := getEnv("ALLOWED_ORIGINS", "")
+
+	kafkaCfg, err := kafkautil.ConfigFromEnv("localhost:29092")
+	if err != nil {
+		log.Fatalf("Invalid Kafka config: %v", err)
+	}
+
+	log.Printf("Starting firehose: kafka=%v topic=%s group=%s max_clients=%d buffer=%d slow_timeout=%s",
+		kafkaCfg.Brokers, topic, consumerGroup, maxClients, buffer, slowTimeout)
+
+	if err := startup.Kafka(context.Background(), kafkaCfg); err != nil {
+		log.Fatalf("Failed to connect to Kafka: %v", err)
+	}
+
+	rdb := redis.NewClient(&redis.Options{Addr: redisAddr})
+	defer rdb.Close()
+	if err := startup.Redis(context.Background(), rdb); err != nil {
+		log.Fatalf("Failed to connect to Redis: %v", err)
+	}
+	log.Println("Connected to Redis")
+
+	// Only new events: a replay of the backlog isn't live
+	reader := kafkaCfg.NewReader(kafkautil.ReaderConfig{Topic: topic, GroupID: consumerGroup, StartOffset: kafka.LastOffset})
+	defer reader.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-sigChan
+		log.Println("Shutting down...")
+		cancel()
+	}()
+
+	// log_level and SIGHUP reloads of pipeline.yaml's settings (pkg/runtimecfg)
+	if err := runtimecfg.New(rdb, "firehose").Start(ctx); err != nil {
+		log.Printf("Warning: runtime config not loaded: %v", err)
+	}
+
+	// Soft-deleted users' listens aren't pushed, as the API hides them
+	deletedUsers := userstate.NewSet(rdb)
+	if err := deletedUsers.Start(ctx); err != nil {
+		log.Printf("Warning: failed to load deleted users, retrying every %s: %v", userstate.Refresh, err)
+	}
+
+	h := newHub(maxClients, slowTimeout)
+	srv := &server{hub: h, buffer: buffer, writeTimeout: writeTimeout, maxIDs: maxIDs, origins: make(map[string]bool)}
+	for _, o := range splitIDs(origins) {
+		srv.origins[o] = true
+	}
+
+	http.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+	http.Handle("/ws", srv)
+	go func() {
+		log.Printf("WebSocket on ws://:%s/ws, metrics on /debug/vars", port)
+		if err := http.ListenAndServe(":"+port, nil); err != nil {
+			log.Printf("HTTP server error: %v", err)
+		}
+	}()
+
+	for {
+		msg, err := reader.FetchMessage(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				break
+			}
+			log.Printf("Error fetching message: %v", err)
+			continue
+		}
+		metricEventsConsumed.Add(1)
+
+		e, err := events.Unmarshal(msg.Value)
+		if err != nil {
+			metricDecodeErrors.Add(1)
+			continue
+		}
+		if _, deleted := deletedUsers.Deleted(e.UserID); deleted {
+			metricEventsHidden.Add(1)
+			continue
+		}
+		data, err := json.Marshal(listen{
+			Type:       "listen",
+			EventID:    e.EventID,
+			UserID:     e.UserID,
+			SongID:     e.SongID,
+			ArtistID:   e.ArtistID,
+			Provider:   e.Provider,
+			ListenedAt: e.ListenedAt,
+		})
+		if err != nil {
+			continue
+		}
+		h.broadcast(e, data)
+	}
+
+	h.closeAll()
+	log.Println("Shutdown complete")
+}
+
+func getEnv(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
+
+func getEnvInt(key string, fallback int) int {
+	if v := os.Getenv(key); v != "" {
+		i, err := strconv.Atoi(v)
+		if err == nil {
+			return i
+		}
+	}
+	return fallback
+}
+
+func getEnvDuration(key string, fallback time.Duration) time.Duration {
+	if v := os.Getenv(key); v != "" {
+		d, err := time.ParseDuration(v)
+		if err == nil {
+			return d
+		}
+	}
+	return fallback
+}
@@ -0,0 +1,16 @@
+package main
+
+import "expvar"
+
+// Firehose metrics, served as JSON on PORT/debug/vars
+var (
+	metricClients             = expvar.NewInt("firehose_clients")
+	metricClientsRejected     = expvar.NewInt("clients_rejected")     // over MAX_CLIENTS
+	metricDisconnected        = expvar.NewMap("clients_disconnected") // by reason
+	metricSubscriptionUpdates = expvar.NewInt("subscription_updates")
+	metricEventsConsumed      = expvar.NewInt("events_consumed")
+	metricEventsHidden        = expvar.NewInt("events_hidden") // of soft-deleted users
+	metricDecodeErrors        = expvar.NewInt("decode_errors")
+	metricEventsSent          = expvar.NewInt("events_sent")    // one per client
+	metricEventsDropped       = expvar.NewInt("events_dropped") // client buffer full
+)
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+// Why a client was disconnected, the keys of clients_disconnected
+const (
+	closeClient     = "client"      // the client closed the connection
+	closeSlow       = "slow"        // buffer full for SLOW_CLIENT_TIMEOUT
+	closeWriteError = "write_error" // a write failed or timed out
+	closeBadMessage = "bad_message" // an invalid subscription update
+	closeShutdown   = "shutdown"
+)
+
+// subscription is what a client sends to replace its filter
+type subscription struct {
+	Users []string `json:"users"`
+	Songs []string `json:"songs"`
+}
+
+// server upgrades GET /ws to a WebSocket subscribed to the firehose
+type server struct {
+	hub          *hub
+	buffer       int           // events queued per client
+	writeTimeout time.Duration // per message
+	maxIDs       int           // users or songs per filter
+	origins      map[string]bool
+}
+
+// ServeHTTP serves GET /ws[?users=a,b][&songs=x,y]
+func (s *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	sub := subscription{Users: splitIDs(r.URL.Query().Get("users")), Songs: splitIDs(r.URL.Query().Get("songs"))}
+	if err := s.check(sub); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	c := newClient(s.buffer, newFilter(sub.Users, sub.Songs))
+	if !s.hub.add(c) {
+		metricClientsRejected.Add(1)
+		http.Error(w, "too many clients", http.StatusServiceUnavailable)
+		return
+	}
+	ws := websocket.Server{
+		Handshake: s.handshake,
+		Handler:   func(conn *websocket.Conn) { s.serve(conn, c) },
+	}
+	ws.ServeHTTP(w, r) // returns when the connection is done
+	s.hub.remove(c)
+	c.close(closeClient)
+	metricDisconnected.Add(c.reason.Load().(string), 1)
+}
+
+// check bounds a filter, so one client can't make every broadcast slow
+func (s *server) check(sub subscription) error {
+	if len(sub.Users) > s.maxIDs || len(sub.Songs) > s.maxIDs {
+		return fmt.Errorf("at most %d users and %d songs per subscription", s.maxIDs, s.maxIDs)
+	}
+	return nil
+}
+
+// handshake refuses origins outside ALLOWED_ORIGINS, when it is set
+func (s *server) handshake(cfg *websocket.Config, r *http.Request) error {
+	if len(s.origins) == 0 {
+		return nil
+	}
+	if !s.origins[r.Header.Get("Origin")] {
+		return fmt.Errorf("origin %q not allowed", r.Header.Get("Origin"))
+	}
+	return nil
+}
+
+// serve writes the client's events until it is closed. Subscription updates
+// are read alongside; a read error means the client went away.
+func (s *server) serve(conn *websocket.Conn, c *client) {
+	defer conn.Close()
+	go func() {
+		for {
+			var sub subscription
+			if err := websocket.JSON.Receive(conn, &sub); err != nil {
+				var syntax *json.SyntaxError
+				var typ *json.UnmarshalTypeError
+				if errors.As(err, &syntax) || errors.As(err, &typ) {
+					c.close(closeBadMessage)
+				} else {
+					c.close(closeClient)
+				}
+				return
+			}
+			if err := s.check(sub); err != nil {
+				c.close(closeBadMessage)
+				return
+			}
+			c.filter.Store(newFilter(sub.Users, sub.Songs))
+			metricSubscriptionUpdates.Add(1)
+		}
+	}()
+
+	for {
+		var data []byte
+		select {
+		case <-c.done:
+			return
+		case data = <-c.send:
+		}
+		if n := c.dropped.Swap(0); n > 0 {
+			if !s.write(conn, c, dropped{Type: "dropped", Count: n}) {
+				return
+			}
+		}
+		conn.SetWriteDeadline(time.Now().Add(s.writeTimeout))
+		if err := websocket.Message.Send(conn, string(data)); err != nil {
+			c.close(closeWriteError)
+			return
+		}
+		metricEventsSent.Add(1)
+	}
+}
+
+// write sends a notice to the client, closing it on failure
+func (s *server) write(conn *websocket.Conn, c *client, v interface{}) bool {
+	conn.SetWriteDeadline(time.Now().Add(s.writeTimeout))
+	if err := websocket.JSON.Send(conn, v); err != nil {
+		log.Printf("Error writing to client %s: %v", conn.Request().RemoteAddr, err)
+		c.close(closeWriteError)
+		return false
+	}
+	return true
+}
+
+// splitIDs reads a comma-separated list, ignoring empty items
+func splitIDs(v string) []string {
+	var ids []string
+	for _, id := range strings.Split(v, ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Charts is the state of every window plus the Kafka offsets it covers
+type Charts struct {
+	mu      sync.Mutex
+	windows []*Window
+	width   int
+	depth   int
+	offsets map[int]int64 // partition -> last offset counted
+}
+
+func NewCharts(specs []WindowSpec, width, depth, topN int) *Charts {
+	c := &Charts{width: width, depth: depth, offsets: make(map[int]int64)}
+	for _, spec := range specs {
+		c.windows = append(c.windows, NewWindow(spec, width, depth, topN))
+	}
+	return c
+}
+
+// Add counts one listen in every window. Messages at or below the offset a
+// checkpoint already covers are skipped (reported false), which makes
+// restore-then-replay count each message once.
+func (c *Charts) Add(partition int, offset int64, songID string, t time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if last, ok := c.offsets[partition]; ok && offset <= last {
+		return false
+	}
+	c.offsets[partition] = offset
+
+	now := time.Now()
+	for _, w := range c.windows {
+		if !w.Add(songID, t, now) {
+			metricLate.Add(w.spec.Name, 1)
+		}
+	}
+	return true
+}
+
+// Skip advances the offset of a message that isn't counted (e.g. undecodable)
+func (c *Charts) Skip(partition int, offset int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if last, ok := c.offsets[partition]; !ok || offset > last {
+		c.offsets[partition] = offset
+	}
+}
+
+// Expire slides every window to now
+func (c *Charts) Expire(now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, w := range c.windows {
+		w.Expire(now)
+	}
+}
+
+// Chart is one window's top list as published to Redis
+type Chart struct {
+	Window     string    `json:"window"`
+	ComputedAt time.Time `json:"computed_at"`
+	Events     int64     `json:"events"` // listens in the window
+	Songs      []Ranked  `json:"songs"`
+}
+
+// Ranked is a chart entry; Count is the sketch estimate, an upper bound
+type Ranked struct {
+	Rank   int    `json:"rank"`
+	SongID string `json:"song_id"`
+	Count  uint32 `json:"count"`
+}
+
+// Charts returns every window's current top list
+func (c *Charts) Charts() []Chart {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	out := make([]Chart, len(c.windows))
+	for i, w := range c.windows {
+		ch := Chart{Window: w.spec.Name, ComputedAt: now, Events: w.Events(), Songs: []Ranked{}}
+		for j, e := range w.top.Sorted() {
+			ch.Songs = append(ch.Songs, Ranked{Rank: j + 1, SongID: e.SongID, Count: e.Count})
+		}
+		out[i] = ch
+	}
+	return out
+}
+
+// ChartKey is the Redis key a window's chart is published under; the
+// api-server reads the same key
+func ChartKey(window string) string {
+	return "charts:global:" + window
+}
+
+// Publish writes every chart to Redis. Charts expire after ttl so readers
+// notice when this service stops.
+func (c *Charts) Publish(ctx context.Context, rdb *redis.Client, ttl time.Duration) error {
+	pipe := rdb.Pipeline()
+	for _, ch := range c.Charts() {
+		data, err := json.Marshal(ch)
+		if err != nil {
+			return err
+		}
+		pipe.Set(ctx, ChartKey(ch.Window), data, ttl)
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// checkpoint is the gob-encoded, gzipped state stored in Redis
+type checkpoint struct {
+	Width, Depth int
+	Offsets      map[int]int64
+	Windows      []windowState
+	SavedAt      time.Time
+}
+
+type windowState struct {
+	Name         string
+	Span, Bucket time.Duration
+	Slots        []slotState
+	Top          []Entry
+}
+
+type slotState struct {
+	Start  int64
+	Events int64
+	Counts []uint32
+}
+
+// Checkpoint saves the state and returns the offsets it covers, which are
+// then safe to commit
+func (c *Charts) Checkpoint(ctx context.Context, rdb *redis.Client, key string) (map[int]int64, int, error) {
+	c.mu.Lock()
+	cp := checkpoint{Width: c.width, Depth: c.depth, Offsets: make(map[int]int64, len(c.offsets)), SavedAt: time.Now()}
+	for p, o := range c.offsets {
+		cp.Offsets[p] = o
+	}
+	for _, w := range c.windows {
+		ws := windowState{Name: w.spec.Name, Span: w.spec.Span, Bucket: w.spec.Bucket, Top: append([]Entry(nil), w.top.items...)}
+		for _, s := range w.slots {
+			st := slotState{Start: s.start, Events: s.events}
+			if s.start != 0 {
+				st.Counts = append([]uint32(nil), s.sketch.counts...)
+			}
+			ws.Slots = append(ws.Slots, st)
+		}
+		cp.Windows = append(cp.Windows, ws)
+	}
+	c.mu.Unlock()
+
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if err := gob.NewEncoder(zw).Encode(cp); err != nil {
+		return nil, 0, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, 0, err
+	}
+	if err := rdb.Set(ctx, key, buf.Bytes(), 0).Err(); err != nil {
+		return nil, 0, err
+	}
+	return cp.Offsets, buf.Len(), nil
+}
+
+// errCheckpointMismatch means the checkpoint was written with other sketch
+// or window settings and can't be loaded into this state
+var errCheckpointMismatch = errors.New("checkpoint has different sketch or window settings")
+
+// Restore loads a checkpoint; ok is false if there is none
+func (c *Charts) Restore(ctx context.Context, rdb *redis.Client, key string) (savedAt time.Time, ok bool, err error) {
+	data, err := rdb.Get(ctx, key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return savedAt, false, nil
+	}
+	if err != nil {
+		return savedAt, false, err
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return savedAt, false, err
+	}
+	var cp checkpoint
+	if err := gob.NewDecoder(zr).Decode(&cp); err != nil {
+		return savedAt, false, fmt.Errorf("decode checkpoint: %w", err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if cp.Width != c.width || cp.Depth != c.depth || len(cp.Windows) != len(c.windows) {
+		return savedAt, false, errCheckpointMismatch
+	}
+	for i, ws := range cp.Windows {
+		w := c.windows[i]
+		if ws.Name != w.spec.Name || ws.Span != w.spec.Span || ws.Bucket != w.spec.Bucket || len(ws.Slots) != len(w.slots) {
+			return savedAt, false, errCheckpointMismatch
+		}
+	}
+
+	for i, ws := range cp.Windows {
+		w := c.windows[i]
+		w.total.Reset()
+		for j, st := range ws.Slots {
+			s := &w.slots[j]
+			s.sketch.Reset()
+			s.start, s.events = st.Start, st.Events
+			if st.Start != 0 {
+				copy(s.sketch.counts, st.Counts)
+				w.total.Merge(s.sketch)
+			}
+		}
+		w.top = NewTopN(w.top.n)
+		for _, e := range ws.Top {
+			w.top.Offer(e.SongID, e.Count)
+		}
+	}
+	c.offsets = cp.Offsets
+	return cp.SavedAt, true, nil
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Exclusions is the set of users the anomaly-detector flagged during the
+// longest window, refreshed from its anomalies:users:<day> sets. Their events
+// are skipped from the next refresh on; what they added before stays in the
+// sketches until it slides out of the windows.
+type Exclusions struct {
+	rdb   *redis.Client
+	days  int
+	users atomic.Pointer[map[string]bool]
+}
+
+func newExclusions(rdb *redis.Client, span time.Duration) *Exclusions {
+	e := &Exclusions{rdb: rdb, days: int(span/(24*time.Hour)) + 1}
+	e.users.Store(&map[string]bool{})
+	return e
+}
+
+// Excluded reports whether userID's events are left out; a nil *Exclusions
+// excludes no one
+func (e *Exclusions) Excluded(userID string) bool {
+	if e == nil {
+		return false
+	}
+	return (*e.users.Load())[userID]
+}
+
+// Run refreshes the set every interval until ctx ends. On a failed refresh
+// the previous set stays in use.
+func (e *Exclusions) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := e.refresh(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("Warning: refreshing excluded users: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (e *Exclusions) refresh(ctx context.Context) error {
+	now := time.Now().UTC()
+	keys := make([]string, e.days)
+	for i := range keys {
+		keys[i] = "anomalies:users:" + now.AddDate(0, 0, -i).Format("2006-01-02")
+	}
+	members, err := e.rdb.SUnion(ctx, keys...).Result()
+	if err != nil {
+		return err
+	}
+	users := make(map[string]bool, len(members))
+	for _, u := range members {
+		users[u] = true
+	}
+	if len(users) != len(*e.users.Load()) {
+		log.Printf("Excluding %d users flagged by the anomaly-detector", len(users))
+	}
+	e.users.Store(&users)
+	metricExcludedUsers.Set(int64(len(users)))
+	return nil
+}
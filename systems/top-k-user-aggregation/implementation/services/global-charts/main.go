@@ -0,0 +1,237 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/segmentio/kafka-go"
+	"github.com/system-design-lab/pkg/chaos"
+	"github.com/system-design-lab/pkg/events"
+	"github.com/system-design-lab/pkg/kafkautil"
+	"github.com/system-design-lab/pkg/runtimecfg"
+	"github.com/system-design-lab/pkg/startup"
+	"github.com/system-design-lab/pkg/topology"
+)
+
+func main() {
+	if _, err := topology.Apply("global-charts"); err != nil {
+		log.Fatalf("Invalid pipeline config: %v", err)
+	}
+
+	topic := getEnv("TOPIC", "user.listen.raw")
+	consumerGroup := getEnv("CONSUMER_GROUP", "global-charts")
+	redisAddr := getEnv("REDIS_ADDR", "localhost:6379")
+	width := getEnvInt("CMS_WIDTH", 8192)
+	depth := getEnvInt("CMS_DEPTH", 4)
+	topN := getEnvInt("TOP_N", 100)
+	publishInterval := getEnvDuration("PUBLISH_INTERVAL", 5*time.Second)
+	checkpointInterval := getEnvDuration("CHECKPOINT_INTERVAL", 30*time.Second)
+	checkpointKey := getEnv("CHECKPOINT_KEY", "charts:checkpoint")
+	addr := getEnv("HTTP_ADDR", ":9106")
+	excludeAnomalies := getEnv("EXCLUDE_ANOMALIES", "false") == "true"
+	exclusionRefresh := getEnvDuration("EXCLUSION_REFRESH", 30*time.Second)
+
+	kafkaCfg, err := kafkautil.ConfigFromEnv("localhost:29092")
+	if err != nil {
+		log.Fatalf("Invalid Kafka config: %v", err)
+	}
+
+	log.Printf("Starting global-charts: kafka=%v topic=%s group=%s cms=%dx%d top=%d checkpoint=%s",
+		kafkaCfg.Brokers, topic, consumerGroup, width, depth, topN, checkpointInterval)
+
+	if err := startup.Kafka(context.Background(), kafkaCfg); err != nil {
+		log.Fatalf("Failed to connect to Kafka: %v", err)
+	}
+
+	rdb := redis.NewClient(&redis.Options{Addr: redisAddr})
+	defer rdb.Close()
+	if err := startup.Redis(context.Background(), rdb); err != nil {
+		log.Fatalf("Failed to connect to Redis: %v", err)
+	}
+	log.Println("Connected to Redis")
+	if chaos.Enabled() {
+		rdb.AddHook(chaos.RedisHook{})
+	}
+
+	charts := NewCharts(DefaultWindows, width, depth, topN)
+	savedAt, ok, err := charts.Restore(context.Background(), rdb, checkpointKey)
+	switch {
+	case err != nil:
+		// Starting empty undercounts until the windows refill; refusing to
+		// start would take the charts down instead
+		log.Printf("Warning: ignoring checkpoint %s: %v", checkpointKey, err)
+	case ok:
+		log.Printf("Restored checkpoint from %s", savedAt.Format(time.RFC3339))
+	default:
+		log.Println("No checkpoint, starting empty")
+	}
+
+	reader := kafkaCfg.NewReader(kafkautil.ReaderConfig{Topic: topic, GroupID: consumerGroup})
+	defer reader.Close()
+	topic = reader.Config().Topic // commits name the topic consumed, mirrored or not
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-sigChan
+		log.Println("Shutting down...")
+		cancel()
+	}()
+
+	// log_level and SIGHUP reloads of pipeline.yaml's settings (pkg/runtimecfg)
+	if err := runtimecfg.New(rdb, "global-charts").Start(ctx); err != nil {
+		log.Printf("Warning: runtime config not loaded: %v", err)
+	}
+
+	http.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+	http.HandleFunc("/charts", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(charts.Charts())
+	})
+	go func() {
+		log.Printf("Charts on http://%s/charts, metrics on /debug/vars", addr)
+		if err := http.ListenAndServe(addr, nil); err != nil {
+			log.Printf("HTTP server error: %v", err)
+		}
+	}()
+
+	go tick(ctx, charts, rdb, reader, topic, checkpointKey, publishInterval, checkpointInterval)
+
+	var excluded *Exclusions
+	if excludeAnomalies {
+		excluded = newExclusions(rdb, DefaultWindows[len(DefaultWindows)-1].Span)
+		go excluded.Run(ctx, exclusionRefresh)
+		log.Println("Excluding users flagged by the anomaly-detector")
+	}
+
+	for {
+		msg, err := reader.FetchMessage(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				break
+			}
+			log.Printf("Error fetching message: %v", err)
+			continue
+		}
+		event, err := events.Unmarshal(msg.Value)
+		if err == nil {
+			err = event.Validate()
+		}
+		if err != nil {
+			log.Printf("Error decoding event (partition=%d offset=%d): %v", msg.Partition, msg.Offset, err)
+			metricDecodeErrors.Add(1)
+			charts.Skip(msg.Partition, msg.Offset)
+			continue
+		}
+		if excluded.Excluded(event.UserID) {
+			metricEventsExcluded.Add(1)
+			charts.Skip(msg.Partition, msg.Offset)
+			continue
+		}
+		if charts.Add(msg.Partition, msg.Offset, event.SongID, event.Time()) {
+			metricEventsCounted.Add(1)
+		} else {
+			metricEventsReplayed.Add(1)
+		}
+	}
+
+	// Final checkpoint so a restart doesn't replay what's been counted
+	shutdownCtx, stop := context.WithTimeout(context.Background(), 10*time.Second)
+	defer stop()
+	checkpointAndCommit(shutdownCtx, charts, rdb, reader, topic, checkpointKey)
+	log.Println("Shutdown complete")
+}
+
+// tick slides the windows every second, publishes charts every
+// publishInterval and checkpoints every checkpointInterval
+func tick(ctx context.Context, charts *Charts, rdb *redis.Client, reader *kafka.Reader, topic, key string, publishInterval, checkpointInterval time.Duration) {
+	slide := time.NewTicker(time.Second)
+	defer slide.Stop()
+	publish := time.NewTicker(publishInterval)
+	defer publish.Stop()
+	checkpoint := time.NewTicker(checkpointInterval)
+	defer checkpoint.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-slide.C:
+			charts.Expire(now)
+		case <-publish.C:
+			if err := charts.Publish(ctx, rdb, 10*publishInterval); err != nil {
+				log.Printf("Error publishing charts: %v", err)
+				metricPublishErrors.Add(1)
+			}
+		case <-checkpoint.C:
+			checkpointAndCommit(ctx, charts, rdb, reader, topic, key)
+		}
+	}
+}
+
+// checkpointAndCommit saves the state, then commits the offsets it covers. A
+// crash between the two replays those messages, and the checkpoint's offsets
+// make Add skip them, so nothing is counted twice.
+func checkpointAndCommit(ctx context.Context, charts *Charts, rdb *redis.Client, reader *kafka.Reader, topic, key string) {
+	offsets, size, err := charts.Checkpoint(ctx, rdb, key)
+	if err != nil {
+		log.Printf("Error checkpointing: %v", err)
+		metricCheckpointErrors.Add(1)
+		return
+	}
+	metricCheckpoints.Add(1)
+	metricCheckpointBytes.Set(int64(size))
+	metricLastCheckpoint.Set(time.Now().Unix())
+
+	msgs := make([]kafka.Message, 0, len(offsets))
+	for p, o := range offsets {
+		msgs = append(msgs, kafka.Message{Topic: topic, Partition: p, Offset: o})
+	}
+	if len(msgs) == 0 {
+		return
+	}
+	if err := kafkautil.CommitWithRetry(ctx, reader, 3, msgs...); err != nil {
+		log.Printf("Error committing offsets: %v", err)
+		metricCommitErrors.Add(1)
+	}
+}
+
+func getEnv(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
+
+func getEnvInt(key string, fallback int) int {
+	if v := os.Getenv(key); v != "" {
+		i, err := strconv.Atoi(v)
+		if err == nil {
+			return i
+		}
+	}
+	return fallback
+}
+
+func getEnvDuration(key string, fallback time.Duration) time.Duration {
+	if v := os.Getenv(key); v != "" {
+		d, err := time.ParseDuration(v)
+		if err == nil {
+			return d
+		}
+	}
+	return fallback
+}
@@ -0,0 +1,21 @@
+package main
+
+import (
+	"expvar"
+)
+
+// Processor metrics, served as JSON on /debug/vars
+var (
+	metricEventsCounted    = expvar.NewInt("events_counted")
+	metricEventsReplayed   = expvar.NewInt("events_replayed") // already in the restored checkpoint
+	metricDecodeErrors     = expvar.NewInt("decode_errors")
+	metricLate             = expvar.NewMap("events_late") // older than the window, by window
+	metricCheckpoints      = expvar.NewInt("checkpoints")
+	metricCheckpointErrors = expvar.NewInt("checkpoint_errors")
+	metricCheckpointBytes  = expvar.NewInt("checkpoint_bytes")
+	metricLastCheckpoint   = expvar.NewInt("last_checkpoint_unix")
+	metricPublishErrors    = expvar.NewInt("publish_errors")
+	metricCommitErrors     = expvar.NewInt("commit_errors")
+	metricEventsExcluded   = expvar.NewInt("events_excluded") // from users flagged as anomalies
+	metricExcludedUsers    = expvar.NewInt("excluded_users")
+)
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"hash/fnv"
+)
+
+// Sketch is a count-min sketch: depth rows of width counters. Estimates never
+// undercount; with width w they overcount by at most e/w of the total with
+// probability 1 - e^-depth. Sketches of the same shape add and subtract
+// counter-wise, which is what lets windows be built from buckets.
+type Sketch struct {
+	width, depth int
+	counts       []uint32 // row-major, depth*width
+}
+
+func NewSketch(width, depth int) *Sketch {
+	return &Sketch{width: width, depth: depth, counts: make([]uint32, width*depth)}
+}
+
+// index picks row's counter from the key's two hashes
+func (s *Sketch) index(row int, h1, h2 uint32) int {
+	return row*s.width + int((h1+uint32(row)*h2)%uint32(s.width))
+}
+
+// hashKey splits one 64-bit FNV-1a hash into the two hashes of
+// Kirsch-Mitzenmacher double hashing. FNV is stable across processes, so
+// checkpointed sketches stay valid after a restart.
+func hashKey(key string) (uint32, uint32) {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	sum := h.Sum64()
+	return uint32(sum), uint32(sum>>32) | 1
+}
+
+func (s *Sketch) Add(key string, n uint32) {
+	h1, h2 := hashKey(key)
+	for row := 0; row < s.depth; row++ {
+		s.counts[s.index(row, h1, h2)] += n
+	}
+}
+
+func (s *Sketch) Estimate(key string) uint32 {
+	h1, h2 := hashKey(key)
+	var min uint32
+	for row := 0; row < s.depth; row++ {
+		c := s.counts[s.index(row, h1, h2)]
+		if row == 0 || c < min {
+			min = c
+		}
+	}
+	return min
+}
+
+// Merge adds other's counters into s
+func (s *Sketch) Merge(other *Sketch) {
+	for i, c := range other.counts {
+		s.counts[i] += c
+	}
+}
+
+// Subtract removes other's counters from s; other must have been merged in
+func (s *Sketch) Subtract(other *Sketch) {
+	for i, c := range other.counts {
+		s.counts[i] -= c
+	}
+}
+
+func (s *Sketch) Reset() {
+	clear(s.counts)
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"container/heap"
+	"sort"
+)
+
+// Entry is a song and its estimated count
+type Entry struct {
+	SongID string `json:"song_id"`
+	Count  uint32 `json:"count"`
+}
+
+// TopN keeps the n songs with the highest estimates in a min-heap, so the
+// weakest candidate is evicted in O(log n) when a stronger one arrives
+type TopN struct {
+	n     int
+	items []Entry
+	pos   map[string]int // song -> index in items
+}
+
+func NewTopN(n int) *TopN {
+	return &TopN{n: n, pos: make(map[string]int, n)}
+}
+
+// Offer records a song's latest estimate
+func (t *TopN) Offer(songID string, count uint32) {
+	if i, ok := t.pos[songID]; ok {
+		t.items[i].Count = count
+		heap.Fix(t, i)
+		return
+	}
+	if len(t.items) < t.n {
+		heap.Push(t, Entry{SongID: songID, Count: count})
+		return
+	}
+	if count > t.items[0].Count {
+		delete(t.pos, t.items[0].SongID)
+		t.items[0] = Entry{SongID: songID, Count: count}
+		t.pos[songID] = 0
+		heap.Fix(t, 0)
+	}
+}
+
+// Rescore re-estimates every candidate, e.g. after a bucket left the window
+func (t *TopN) Rescore(estimate func(string) uint32) {
+	kept := t.items[:0]
+	for _, e := range t.items {
+		if e.Count = estimate(e.SongID); e.Count > 0 {
+			kept = append(kept, e)
+		}
+	}
+	t.items = kept
+	clear(t.pos)
+	for i, e := range t.items {
+		t.pos[e.SongID] = i
+	}
+	heap.Init(t)
+}
+
+// Sorted returns the candidates, highest first (ties by song ID)
+func (t *TopN) Sorted() []Entry {
+	out := append([]Entry(nil), t.items...)
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Count != out[j].Count {
+			return out[i].Count > out[j].Count
+		}
+		return out[i].SongID < out[j].SongID
+	})
+	return out
+}
+
+// heap.Interface; use Offer and Rescore instead of calling these
+func (t *TopN) Len() int           { return len(t.items) }
+func (t *TopN) Less(i, j int) bool { return t.items[i].Count < t.items[j].Count }
+func (t *TopN) Swap(i, j int) {
+	t.items[i], t.items[j] = t.items[j], t.items[i]
+	t.pos[t.items[i].SongID] = i
+	t.pos[t.items[j].SongID] = j
+}
+func (t *TopN) Push(x any) {
+	e := x.(Entry)
+	t.pos[e.SongID] = len(t.items)
+	t.items = append(t.items, e)
+}
+func (t *TopN) Pop() any {
+	e := t.items[len(t.items)-1]
+	t.items = t.items[:len(t.items)-1]
+	delete(t.pos, e.SongID)
+	return e
+}
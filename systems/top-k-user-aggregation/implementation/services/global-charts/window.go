@@ -0,0 +1,122 @@
+package main
+
+import (
+	"time"
+)
+
+// WindowSpec is a sliding window made of fixed buckets, e.g. 24h of 1h
+// buckets: the window slides one bucket at a time
+type WindowSpec struct {
+	Name   string        // e.g. "24h", used in keys and URLs
+	Span   time.Duration // window length
+	Bucket time.Duration // slide granularity; Span must be a multiple
+}
+
+// DefaultWindows are the charts this service keeps
+var DefaultWindows = []WindowSpec{
+	{Name: "1h", Span: time.Hour, Bucket: 5 * time.Minute},
+	{Name: "24h", Span: 24 * time.Hour, Bucket: time.Hour},
+	{Name: "7d", Span: 7 * 24 * time.Hour, Bucket: 6 * time.Hour},
+}
+
+// slot is one bucket of a window's ring
+type slot struct {
+	start  int64 // bucket start, unix seconds; 0 = empty
+	events int64
+	sketch *Sketch
+}
+
+// Window counts songs over a sliding window. Each bucket has its own sketch
+// and total is their sum, kept incrementally: an add goes to both, an expired
+// bucket is subtracted from total. Estimates for the whole window are then a
+// single sketch lookup.
+type Window struct {
+	spec  WindowSpec
+	slots []slot
+	total *Sketch
+	top   *TopN
+}
+
+func NewWindow(spec WindowSpec, width, depth, topN int) *Window {
+	w := &Window{
+		spec:  spec,
+		slots: make([]slot, int(spec.Span/spec.Bucket)),
+		total: NewSketch(width, depth),
+		top:   NewTopN(topN),
+	}
+	for i := range w.slots {
+		w.slots[i].sketch = NewSketch(width, depth)
+	}
+	return w
+}
+
+func (w *Window) bucketStart(t time.Time) int64 {
+	return t.Truncate(w.spec.Bucket).Unix()
+}
+
+// oldest is the start of the oldest bucket still in the window at now
+func (w *Window) oldest(now time.Time) int64 {
+	return w.bucketStart(now) - int64(w.spec.Span/time.Second) + int64(w.spec.Bucket/time.Second)
+}
+
+// Add counts a listen at event time t. Listens older than the window are
+// dropped (reported false); ones from the future count as now.
+func (w *Window) Add(songID string, t, now time.Time) bool {
+	if t.After(now) {
+		t = now
+	}
+	start := w.bucketStart(t)
+	if start < w.oldest(now) {
+		return false
+	}
+
+	s := &w.slots[(start/int64(w.spec.Bucket/time.Second))%int64(len(w.slots))]
+	if s.start != start {
+		if s.start != 0 {
+			// The slot still holds a bucket a whole span older
+			w.evict(s)
+			w.top.Rescore(w.total.Estimate)
+		}
+		s.start = start
+	}
+	s.sketch.Add(songID, 1)
+	s.events++
+	w.total.Add(songID, 1)
+	w.top.Offer(songID, w.total.Estimate(songID))
+	return true
+}
+
+// Expire drops buckets that slid out of the window and re-scores the top list
+// if anything changed
+func (w *Window) Expire(now time.Time) {
+	oldest := w.oldest(now)
+	expired := false
+	for i := range w.slots {
+		if s := &w.slots[i]; s.start != 0 && s.start < oldest {
+			w.evict(s)
+			expired = true
+		}
+	}
+	if expired {
+		w.top.Rescore(w.total.Estimate)
+	}
+}
+
+func (w *Window) evict(s *slot) {
+	if s.start == 0 {
+		return
+	}
+	w.total.Subtract(s.sketch)
+	s.sketch.Reset()
+	s.start = 0
+	s.events = 0
+}
+
+// Events is the number of listens in the window
+func (w *Window) Events() int64 {
+	var n int64
+	for _, s := range w.slots {
+		n += s.events
+	}
+	return n
+}
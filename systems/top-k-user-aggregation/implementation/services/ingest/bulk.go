@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/system-design-lab/pkg/events"
+)
+
+// Item statuses of a bulk report
+const (
+	itemAccepted = "accepted"   // published
+	itemRejected = "rejected"   // invalid: fix it before sending it again
+	itemFailed   = "failed"     // valid, but Kafka didn't take it: send it again
+	itemOver     = "over_quota" // over the user's daily quota: throttled or dropped
+)
+
+// BulkRequest is the body of POST /events:batch. Events are kept raw so one
+// malformed event is reported on its own instead of failing the whole body.
+type BulkRequest struct {
+	Events []json.RawMessage `json:"events"`
+}
+
+// BulkResponse reports every event of a bulk request, in request order
+type BulkResponse struct {
+	Accepted  int          `json:"accepted"`
+	Rejected  int          `json:"rejected"`
+	Failed    int          `json:"failed"`
+	OverQuota int          `json:"over_quota"`
+	Results   []ItemResult `json:"results"`
+}
+
+// ItemResult is the outcome of one event
+type ItemResult struct {
+	Index   int    `json:"index"`
+	Status  string `json:"status"`
+	EventID string `json:"event_id,omitempty"`
+	Reason  string `json:"reason,omitempty"`
+}
+
+// publishBulk validates each event of a history dump on its own, publishes
+// the valid ones and reports per event. The report is the answer even when
+// some events failed to publish: the client resends those in a new request,
+// and a retry of this one (same Idempotency-Key) replays the report rather
+// than publishing the accepted events again.
+func (s *ingestServer) publishBulk(ctx context.Context, body []byte) (int, []byte) {
+	var req BulkRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		return http.StatusBadRequest, errorBody(fmt.Sprintf("invalid JSON: %v", err))
+	}
+	if len(req.Events) == 0 {
+		return http.StatusBadRequest, errorBody("no events")
+	}
+	if len(req.Events) > s.maxBulkEvents {
+		return http.StatusRequestEntityTooLarge, errorBody(fmt.Sprintf("%d events, at most %d per request", len(req.Events), s.maxBulkEvents))
+	}
+
+	resp := BulkResponse{Results: make([]ItemResult, len(req.Events))}
+	var (
+		valid    []kafka.Message
+		validEvs []events.ListenEvent
+		validIdx []int // valid[i] is req.Events[validIdx[i]]
+	)
+	for i, raw := range req.Events {
+		resp.Results[i] = ItemResult{Index: i}
+		msg, e, err := s.message(raw)
+		if err != nil {
+			resp.Results[i].Status = itemRejected
+			resp.Results[i].Reason = err.Error()
+			resp.Rejected++
+			continue
+		}
+		resp.Results[i].EventID = e.EventID
+		valid = append(valid, msg)
+		validEvs = append(validEvs, e)
+		validIdx = append(validIdx, i)
+	}
+
+	var (
+		msgs  []kafka.Message
+		evs   []events.ListenEvent
+		items []int // msgs[i] is req.Events[items[i]]
+		over  []kafka.Message
+	)
+	overQuota := s.overQuota(ctx, validEvs)
+	for j, i := range validIdx {
+		if overQuota != nil && overQuota[j] {
+			resp.Results[i].Status = itemOver
+			resp.Results[i].Reason = s.overReason()
+			resp.OverQuota++
+			over = append(over, valid[j])
+			continue
+		}
+		msgs = append(msgs, valid[j])
+		evs = append(evs, validEvs[j])
+		items = append(items, i)
+	}
+
+	var werrs kafka.WriteErrors
+	if len(msgs) > 0 {
+		if err := s.write(ctx, s.writer, msgs); err != nil {
+			log.Printf("Error publishing bulk of %d events: %v", len(msgs), err)
+			metricPublishErrors.Add(1)
+			if !errors.As(err, &werrs) || len(werrs) != len(msgs) {
+				// Not per message (e.g. the context ended): none is known
+				// to be published, so the request can run again
+				s.refundQuota(evs)
+				return http.StatusBadGateway, errorBody("publish failed")
+			}
+		}
+	}
+	var failed []events.ListenEvent
+	for j, i := range items {
+		if werrs != nil && werrs[j] != nil {
+			resp.Results[i].Status = itemFailed
+			resp.Results[i].Reason = werrs[j].Error()
+			resp.Failed++
+			failed = append(failed, evs[j])
+			continue
+		}
+		resp.Results[i].Status = itemAccepted
+		resp.Accepted++
+	}
+	s.refundQuota(failed)
+	s.throttle(ctx, over)
+	metricEventsAccepted.Add(int64(resp.Accepted))
+	metricEventsRejected.Add(int64(resp.Rejected))
+	metricEventsFailed.Add(int64(resp.Failed))
+
+	data, err := json.Marshal(resp)
+	if err != nil {
+		return http.StatusInternalServerError, errorBody(err.Error())
+	}
+	return http.StatusOK, data
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/system-design-lab/pkg/events"
+	"github.com/system-design-lab/pkg/idgen"
+	"github.com/system-design-lab/pkg/kafkautil"
+	"github.com/system-design-lab/pkg/quota"
+)
+
+// EventsRequest is the body of POST /events. Events are decoded one by one
+// with events.Unmarshal, so clients may send any schema version.
+type EventsRequest struct {
+	Events []json.RawMessage `json:"events"`
+}
+
+// EventsResponse is the answer to an accepted batch
+type EventsResponse struct {
+	Accepted  int      `json:"accepted"`
+	OverQuota int      `json:"over_quota,omitempty"` // throttled or dropped, not counted in Accepted
+	EventIDs  []string `json:"event_ids"`            // in request order
+}
+
+// ingestServer publishes the event batches clients POST
+type ingestServer struct {
+	writer        *kafka.Writer
+	throttled     *kafka.Writer // of events over the quota; nil drops them
+	quota         *quota.Quota  // nil: no quota
+	format        events.Format
+	version       int // schema version of published events
+	ids           *idgen.Generator
+	idem          *idempotencyStore
+	maxEvents     int
+	maxBytes      int64
+	maxBulkEvents int
+	maxBulkBytes  int64
+	maxEventBytes int // encoded size of one event
+}
+
+// publishFunc handles a request body, returning the status and body to
+// answer with
+type publishFunc func(ctx context.Context, body []byte) (int, []byte)
+
+// handler serves POSTs of at most maxBytes with publish, honouring
+// Idempotency-Key
+func (s *ingestServer) handler(maxBytes int64, publish publishFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.serve(w, r, maxBytes, publish)
+	}
+}
+
+func (s *ingestServer) serve(w http.ResponseWriter, r *http.Request, maxBytes int64, publish publishFunc) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		s.reply(w, http.StatusMethodNotAllowed, errorBody("method not allowed"))
+		return
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBytes))
+	if err != nil {
+		s.reply(w, http.StatusRequestEntityTooLarge, errorBody(fmt.Sprintf("body over %d bytes", maxBytes)))
+		return
+	}
+
+	key := r.Header.Get("Idempotency-Key")
+	if key == "" {
+		status, resp := publish(r.Context(), body)
+		s.reply(w, status, resp)
+		return
+	}
+	if len(key) > maxKeyLen {
+		s.reply(w, http.StatusBadRequest, errorBody(fmt.Sprintf("Idempotency-Key over %d characters", maxKeyLen)))
+		return
+	}
+
+	hash := bodyHash(body)
+	prev, claimed, err := s.idem.claim(r.Context(), key, hash)
+	if err != nil {
+		// Without the store a retry could publish twice: let the client
+		// retry later with the same key instead
+		log.Printf("Error claiming idempotency key: %v", err)
+		metricIdempotencyError.Add(1)
+		w.Header().Set("Retry-After", "1")
+		s.reply(w, http.StatusServiceUnavailable, errorBody("idempotency store unavailable"))
+		return
+	}
+	if !claimed {
+		switch {
+		case prev.Hash != hash:
+			metricKeyConflicts.Add("mismatch", 1)
+			s.reply(w, http.StatusUnprocessableEntity, errorBody("Idempotency-Key was used for a different request"))
+		case prev.Pending:
+			metricKeyConflicts.Add("in_progress", 1)
+			w.Header().Set("Retry-After", "1")
+			s.reply(w, http.StatusConflict, errorBody("a request with this Idempotency-Key is in progress"))
+		default:
+			metricReplays.Add(1)
+			w.Header().Set("Idempotent-Replayed", "true")
+			s.reply(w, prev.Status, prev.Body)
+		}
+		return
+	}
+
+	status, resp := publish(r.Context(), body)
+	// Server errors are not final: release the key so the retry runs again.
+	// Anything else (accepted, or a request that will never be valid) is
+	// the answer to every retry until the TTL.
+	//
+	// The claim outlives a cancelled request, so both use a fresh context.
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if status >= 500 {
+		err = s.idem.release(ctx, key)
+	} else {
+		err = s.idem.complete(ctx, key, hash, status, resp)
+	}
+	if err != nil {
+		// The pending claim expires on its own; repeats get a 409 until then
+		log.Printf("Error storing idempotency key: %v", err)
+		metricIdempotencyError.Add(1)
+	}
+	s.reply(w, status, resp)
+}
+
+// publish decodes, validates and publishes a batch, returning the status and
+// body to answer with
+func (s *ingestServer) publish(ctx context.Context, body []byte) (int, []byte) {
+	var req EventsRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		return http.StatusBadRequest, errorBody(fmt.Sprintf("invalid JSON: %v", err))
+	}
+	if len(req.Events) == 0 {
+		return http.StatusBadRequest, errorBody("no events")
+	}
+	if len(req.Events) > s.maxEvents {
+		return http.StatusRequestEntityTooLarge, errorBody(fmt.Sprintf("%d events, at most %d per request", len(req.Events), s.maxEvents))
+	}
+
+	resp := EventsResponse{EventIDs: make([]string, 0, len(req.Events))}
+	msgs := make([]kafka.Message, 0, len(req.Events))
+	evs := make([]events.ListenEvent, 0, len(req.Events))
+	for i, raw := range req.Events {
+		msg, e, err := s.message(raw)
+		if err != nil {
+			return http.StatusBadRequest, errorBody(fmt.Sprintf("events[%d]: %v", i, err))
+		}
+		msgs = append(msgs, msg)
+		evs = append(evs, e)
+		resp.EventIDs = append(resp.EventIDs, e.EventID)
+	}
+
+	var within, over []kafka.Message
+	var withinEvs []events.ListenEvent
+	overQuota := s.overQuota(ctx, evs)
+	for i, msg := range msgs {
+		if overQuota != nil && overQuota[i] {
+			over = append(over, msg)
+			continue
+		}
+		within = append(within, msg)
+		withinEvs = append(withinEvs, evs[i])
+	}
+	if len(within) > 0 {
+		if err := s.write(ctx, s.writer, within); err != nil {
+			log.Printf("Error publishing %d events: %v", len(within), err)
+			metricPublishErrors.Add(1)
+			s.refundQuota(withinEvs)
+			return http.StatusBadGateway, errorBody("publish failed")
+		}
+	}
+	s.throttle(ctx, over)
+	metricEventsAccepted.Add(int64(len(within)))
+
+	resp.Accepted = len(within)
+	resp.OverQuota = len(over)
+	data, err := json.Marshal(resp)
+	if err != nil {
+		return http.StatusInternalServerError, errorBody(err.Error())
+	}
+	return http.StatusAccepted, data
+}
+
+// message decodes an event in any schema version, fills in its ID,
+// validates it and encodes it in the version this service publishes
+func (s *ingestServer) message(raw json.RawMessage) (kafka.Message, events.ListenEvent, error) {
+	e, err := events.Unmarshal(raw)
+	if err != nil {
+		return kafka.Message{}, e, err
+	}
+	if e.EventID == "" {
+		e.EventID = s.ids.NextString()
+	}
+	if err := e.Validate(); err != nil {
+		return kafka.Message{}, e, err
+	}
+	data, err := events.MarshalVersion(e, s.format, s.version)
+	if err != nil {
+		return kafka.Message{}, e, err
+	}
+	// Refused here rather than by the broker, which would fail the whole batch
+	if len(data) > s.maxEventBytes {
+		metricEventsOversized.Add(1)
+		return kafka.Message{}, e, fmt.Errorf("event of %d bytes, at most %d", len(data), s.maxEventBytes)
+	}
+	return kafka.Message{Key: []byte(e.UserID), Value: data}, e, nil
+}
+
+// write publishes msgs to w under one trace ID, with the standard headers
+func (s *ingestServer) write(ctx context.Context, w *kafka.Writer, msgs []kafka.Message) error {
+	ctx = kafkautil.ContextWithTrace(ctx, kafkautil.NewTraceID())
+	kafkautil.Stamp(msgs, kafkautil.Headers{SchemaVersion: s.version})
+	kafkautil.Inject(ctx, msgs)
+	return w.WriteMessages(ctx, msgs...)
+}
+
+func (s *ingestServer) reply(w http.ResponseWriter, status int, body []byte) {
+	metricRequests.Add(strconv.Itoa(status), 1)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(body)
+}
+
+func errorBody(msg string) []byte {
+	data, _ := json.Marshal(map[string]string{"error": msg})
+	return data
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// maxKeyLen bounds Idempotency-Key values
+const maxKeyLen = 255
+
+// storedResponse is what Redis keeps under an idempotency key: the request's
+// body hash, and once it has completed, its response
+type storedResponse struct {
+	Hash    string `json:"hash"`
+	Pending bool   `json:"pending,omitempty"`
+	Status  int    `json:"status,omitempty"`
+	Body    []byte `json:"body,omitempty"`
+}
+
+// idempotencyStore remembers responses by Idempotency-Key, so a client that
+// retries a batch (timeout, dropped connection) gets the original response
+// instead of publishing the events again.
+//
+// A key is first claimed with SET NX as pending, for pendingTTL: long enough
+// for a request to publish. Completed responses are kept for ttl. A request
+// that fails before publishing everything releases the key so the retry can
+// run.
+type idempotencyStore struct {
+	rdb        *redis.Client
+	ttl        time.Duration
+	pendingTTL time.Duration
+}
+
+func redisKey(key string) string {
+	return "ingest:idem:" + key
+}
+
+// bodyHash identifies a request's body, so a key reused for another batch is
+// refused rather than answered with the wrong response
+func bodyHash(body []byte) string {
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:])
+}
+
+// claim takes key for a request with the given body hash. If the key is
+// already taken it returns what is stored under it (pending or completed)
+// and claimed is false.
+func (s *idempotencyStore) claim(ctx context.Context, key, hash string) (prev storedResponse, claimed bool, err error) {
+	pending, err := json.Marshal(storedResponse{Hash: hash, Pending: true})
+	if err != nil {
+		return prev, false, err
+	}
+	ok, err := s.rdb.SetNX(ctx, redisKey(key), pending, s.pendingTTL).Result()
+	if err != nil {
+		return prev, false, fmt.Errorf("claim %s: %w", key, err)
+	}
+	if ok {
+		return prev, true, nil
+	}
+
+	data, err := s.rdb.Get(ctx, redisKey(key)).Bytes()
+	if err == redis.Nil {
+		// Released or expired since the SET NX: try once more
+		ok, err = s.rdb.SetNX(ctx, redisKey(key), pending, s.pendingTTL).Result()
+		if err != nil {
+			return prev, false, fmt.Errorf("claim %s: %w", key, err)
+		}
+		if ok {
+			return prev, true, nil
+		}
+		return storedResponse{Hash: hash, Pending: true}, false, nil
+	}
+	if err != nil {
+		return prev, false, fmt.Errorf("read %s: %w", key, err)
+	}
+	if err := json.Unmarshal(data, &prev); err != nil {
+		return prev, false, fmt.Errorf("decode %s: %w", key, err)
+	}
+	return prev, false, nil
+}
+
+// complete stores the response of a claimed key for the TTL
+func (s *idempotencyStore) complete(ctx context.Context, key, hash string, status int, body []byte) error {
+	data, err := json.Marshal(storedResponse{Hash: hash, Status: status, Body: body})
+	if err != nil {
+		return err
+	}
+	return s.rdb.Set(ctx, redisKey(key), data, s.ttl).Err()
+}
+
+// release gives up a claimed key, so a retry runs the request again
+func (s *idempotencyStore) release(ctx context.Context, key string) error {
+	return s.rdb.Del(ctx, redisKey(key)).Err()
+}
@@ -0,0 +1,191 @@
+// Command ingest accepts listen events over HTTP and publishes them to the
+// raw events topic, for clients that push events rather than being crawled.
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/segmentio/kafka-go"
+	"github.com/system-design-lab/pkg/chaos"
+	"github.com/system-design-lab/pkg/events"
+	"github.com/system-design-lab/pkg/idgen"
+	"github.com/system-design-lab/pkg/kafkautil"
+	"github.com/system-design-lab/pkg/quota"
+	"github.com/system-design-lab/pkg/ratelimit"
+	"github.com/system-design-lab/pkg/runtimecfg"
+	"github.com/system-design-lab/pkg/startup"
+	"github.com/system-design-lab/pkg/topology"
+)
+
+func main() {
+	if _, err := topology.Apply("ingest"); err != nil {
+		log.Fatalf("Invalid pipeline config: %v", err)
+	}
+
+	topic := getEnv("TOPIC", "user.listen.raw")
+	redisAddr := getEnv("REDIS_ADDR", "localhost:6379")
+	port := getEnv("PORT", "8082")
+	format := events.Format(getEnv("EVENT_FORMAT", string(events.FormatJSON)))
+	maxEvents := getEnvInt("MAX_EVENTS", 1000)
+	maxBytes := getEnvInt("MAX_BODY_BYTES", 4<<20)
+	maxBulkEvents := getEnvInt("MAX_BULK_EVENTS", 10000)
+	maxBulkBytes := getEnvInt("MAX_BULK_BODY_BYTES", 32<<20)
+	maxEventBytes := getEnvInt("MAX_EVENT_BYTES", 8192)
+	idemTTL := getEnvDuration("IDEMPOTENCY_TTL", 24*time.Hour)
+	idemPendingTTL := getEnvDuration("IDEMPOTENCY_PENDING_TTL", 30*time.Second)
+	rateLimit := getEnvInt("RATE_LIMIT", 0)
+	rateLimitBurst := getEnvInt("RATE_LIMIT_BURST", 0)
+
+	quotaCfg, err := quota.ConfigFromEnv()
+	if err != nil {
+		log.Fatalf("Invalid quota: %v", err)
+	}
+
+	kafkaCfg, err := kafkautil.ConfigFromEnv("localhost:29092")
+	if err != nil {
+		log.Fatalf("Invalid Kafka config: %v", err)
+	}
+	wc := kafkautil.WriterConfigFromEnv()
+	if wc.RequiredAcks != kafka.RequireAll {
+		log.Printf("Warning: KAFKA_REQUIRED_ACKS=%s, events can be lost if a broker fails", wc.RequiredAcks)
+	}
+	if limit := int(wc.MaxMessageBytes) - kafkautil.HeaderAllowance; maxEventBytes > limit {
+		log.Printf("Warning: MAX_EVENT_BYTES %d over what KAFKA_MAX_MESSAGE_BYTES allows, using %d", maxEventBytes, limit)
+		maxEventBytes = limit
+	}
+
+	log.Printf("Starting ingest: kafka=%v topic=%s redis=%s port=%s max_events=%d idempotency_ttl=%s quota=%s",
+		kafkaCfg.Brokers, topic, redisAddr, port, maxEvents, idemTTL, quotaCfg)
+
+	if err := startup.Kafka(context.Background(), kafkaCfg); err != nil {
+		log.Fatalf("Failed to connect to Kafka: %v", err)
+	}
+
+	rdb := redis.NewClient(&redis.Options{Addr: redisAddr})
+	defer rdb.Close()
+	if err := startup.Redis(context.Background(), rdb); err != nil {
+		log.Fatalf("Failed to connect to Redis: %v", err)
+	}
+	log.Println("Connected to Redis")
+	if chaos.Enabled() {
+		rdb.AddHook(chaos.RedisHook{})
+	}
+
+	// Event IDs for events sent without one: worker ID from WORKER_ID or a
+	// lease in the same Redis
+	ids, lease, err := idgen.FromEnv(context.Background(), rdb)
+	if err != nil {
+		log.Fatalf("Failed to get a worker ID: %v", err)
+	}
+	if lease != nil {
+		defer lease.Release(context.Background())
+		go func() {
+			// Event IDs could collide with the new holder's: stop as if signalled
+			<-lease.Lost()
+			if p, err := os.FindProcess(os.Getpid()); err == nil {
+				p.Signal(syscall.SIGTERM)
+			}
+		}()
+	}
+
+	writer := kafkaCfg.NewWriter(topic, wc)
+	defer writer.Close()
+
+	s := &ingestServer{
+		writer:        writer,
+		quota:         quota.New(quotaCfg, rdb, "ingest"),
+		format:        format,
+		version:       events.VersionFromEnv(),
+		ids:           ids,
+		idem:          &idempotencyStore{rdb: rdb, ttl: idemTTL, pendingTTL: idemPendingTTL},
+		maxEvents:     maxEvents,
+		maxBytes:      int64(maxBytes),
+		maxBulkEvents: maxBulkEvents,
+		maxBulkBytes:  int64(maxBulkBytes),
+		maxEventBytes: maxEventBytes,
+	}
+	if quotaCfg.Enabled() && quotaCfg.Action == quota.Throttle {
+		s.throttled = kafkaCfg.NewWriter(quotaCfg.Topic, wc)
+		defer s.throttled.Close()
+	}
+
+	// Runtime settings: the rate limit and log level, reloaded from Redis
+	// and pipeline.yaml without a restart
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	settings := runtimecfg.New(rdb, "ingest")
+	if err := settings.Start(ctx); err != nil {
+		log.Printf("Warning: runtime config not loaded, using env values until Redis answers: %v", err)
+	}
+
+	// Per-client rate limit, rate_limit and rate_limit_burst at runtime
+	limiter := ratelimit.NewSwitch(rdb, "ingest")
+	setRateLimit := func() error {
+		r := settings.Int("rate_limit", rateLimit)
+		changed, err := limiter.Configure(ratelimit.Config{
+			Backend: ratelimit.Redis,
+			Rate:    float64(r),
+			Burst:   settings.Int("rate_limit_burst", rateLimitBurst),
+		})
+		if changed && r > 0 {
+			log.Printf("Rate limit: %d requests/s per client", r)
+		}
+		return err
+	}
+	if err := setRateLimit(); err != nil {
+		log.Fatalf("Invalid rate limit: %v", err)
+	}
+	settings.OnChange(func() {
+		if err := setRateLimit(); err != nil {
+			log.Printf("Warning: invalid rate limit, keeping the last one: %v", err)
+		}
+	})
+	limit := func(h http.HandlerFunc) http.Handler {
+		return ratelimit.Middleware(limiter, ratelimit.ClientIP, h)
+	}
+
+	http.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+	http.Handle("/events", limit(s.handler(s.maxBytes, s.publish)))
+	http.Handle("/events:batch", limit(s.handler(s.maxBulkBytes, s.publishBulk)))
+
+	log.Printf("Listening on :%s (metrics on /debug/vars)", port)
+	if err := http.ListenAndServe(":"+port, nil); err != nil {
+		log.Fatalf("Server error: %v", err)
+	}
+}
+
+func getEnv(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
+
+func getEnvDuration(key string, fallback time.Duration) time.Duration {
+	if v := os.Getenv(key); v != "" {
+		d, err := time.ParseDuration(v)
+		if err == nil {
+			return d
+		}
+	}
+	return fallback
+}
+
+func getEnvInt(key string, fallback int) int {
+	if v := os.Getenv(key); v != "" {
+		i, err := strconv.Atoi(v)
+		if err == nil {
+			return i
+		}
+	}
+	return fallback
+}
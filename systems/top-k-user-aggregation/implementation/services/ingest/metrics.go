@@ -0,0 +1,17 @@
+package main
+
+import "expvar"
+
+// Ingest metrics, served as JSON on /debug/vars of the API port
+var (
+	metricRequests         = expvar.NewMap("requests") // by status code
+	metricEventsAccepted   = expvar.NewInt("events_accepted")
+	metricEventsRejected   = expvar.NewInt("events_rejected")  // invalid events of bulk requests
+	metricEventsFailed     = expvar.NewInt("events_failed")    // bulk events Kafka didn't take
+	metricEventsOversized  = expvar.NewInt("events_oversized") // over MAX_EVENT_BYTES once encoded
+	metricPublishErrors    = expvar.NewInt("publish_errors")
+	metricThrottleErrors   = expvar.NewInt("throttle_errors") // writes to THROTTLED_TOPIC that failed
+	metricReplays          = expvar.NewInt("idempotent_replays")
+	metricKeyConflicts     = expvar.NewMap("idempotency_conflicts") // in_progress, mismatch
+	metricIdempotencyError = expvar.NewInt("idempotency_errors")
+)
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/system-design-lab/pkg/events"
+)
+
+// overQuota counts evs against their users' daily quota and reports which
+// are over it (nil: none). Without Redis every event is let through.
+func (s *ingestServer) overQuota(ctx context.Context, evs []events.ListenEvent) []bool {
+	over, err := s.quota.Take(ctx, evs)
+	if err != nil {
+		log.Printf("Warning: %v (letting the events through)", err)
+	}
+	return over
+}
+
+// refundQuota gives back the quota of events that weren't published, so the
+// client's retry isn't counted twice
+func (s *ingestServer) refundQuota(evs []events.ListenEvent) {
+	// Like the idempotency key, this outlives a cancelled request
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.quota.Refund(ctx, evs); err != nil {
+		log.Printf("Warning: %v", err)
+	}
+}
+
+// throttle publishes events over the quota to the throttled topic, or drops
+// them with QUOTA_ACTION=drop. They were over the quota anyway: a failed
+// write is logged, not reported to the client.
+func (s *ingestServer) throttle(ctx context.Context, msgs []kafka.Message) {
+	if s.throttled == nil || len(msgs) == 0 {
+		return
+	}
+	if err := s.write(ctx, s.throttled, msgs); err != nil {
+		log.Printf("Warning: failed to publish %d throttled events: %v", len(msgs), err)
+		metricThrottleErrors.Add(1)
+	}
+}
+
+// overReason is the reason of over-quota items in a bulk report
+func (s *ingestServer) overReason() string {
+	cfg := s.quota.Config()
+	if s.throttled == nil {
+		return fmt.Sprintf("over the daily quota of %d events for this user and day, dropped", cfg.Daily)
+	}
+	return fmt.Sprintf("over the daily quota of %d events for this user and day, sent to %s", cfg.Daily, cfg.Topic)
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/rand"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/system-design-lab/pkg/events"
+	"github.com/system-design-lab/pkg/kafkautil"
+	"golang.org/x/time/rate"
+)
+
+// GeneratorConfig shapes the synthetic workload
+type GeneratorConfig struct {
+	Topic       string        `json:"topic"`
+	Rate        float64       `json:"rate"`     // average events/s
+	Duration    time.Duration `json:"duration"` // 0 = until interrupted
+	Users       int           `json:"users"`
+	Songs       int           `json:"songs"`
+	ZipfS       float64       `json:"zipf_s"`       // song popularity skew (> 1; higher = more skewed)
+	DupRatio    float64       `json:"dup_ratio"`    // fraction of sends that re-send an earlier event
+	BurstFactor float64       `json:"burst_factor"` // 1 = steady; N = N× rate for 1/N of each period, idle otherwise
+	BurstPeriod time.Duration `json:"burst_period"`
+	BatchSize   int           `json:"batch_size"`
+	Provider    string        `json:"provider"`
+	Experiments []string      `json:"experiments"`
+	Version     int           `json:"schema_version"` // of generated events
+}
+
+func (c GeneratorConfig) validate() error {
+	switch {
+	case c.Rate <= 0:
+		return fmt.Errorf("RATE must be > 0")
+	case c.Users < 1 || c.Songs < 1:
+		return fmt.Errorf("USERS and SONGS must be >= 1")
+	case c.ZipfS <= 1:
+		return fmt.Errorf("ZIPF_S must be > 1")
+	case c.DupRatio < 0 || c.DupRatio >= 1:
+		return fmt.Errorf("DUP_RATIO must be in [0, 1)")
+	case c.BurstFactor < 1:
+		return fmt.Errorf("BURST_FACTOR must be >= 1")
+	case c.BurstFactor > 1 && c.BurstPeriod <= 0:
+		return fmt.Errorf("BURST_PERIOD must be > 0 with BURST_FACTOR > 1")
+	case c.BatchSize < 1:
+		return fmt.Errorf("BATCH_SIZE must be >= 1")
+	}
+	for _, name := range c.Experiments {
+		if len(name) > events.MaxExperimentLen {
+			return fmt.Errorf("EXPERIMENTS names must be at most %d bytes", events.MaxExperimentLen)
+		}
+	}
+	return nil
+}
+
+// Stats are the generator's counters for the report
+type Stats struct {
+	Start      time.Time `json:"start"`
+	End        time.Time `json:"end"`
+	Sent       int64     `json:"sent"`
+	Duplicates int64     `json:"duplicates"`
+	Errors     int64     `json:"errors"` // events in failed writes
+}
+
+func (s Stats) Elapsed() time.Duration { return s.End.Sub(s.Start) }
+
+// AchievedRate is events/s actually acked by Kafka
+func (s Stats) AchievedRate() float64 {
+	if sec := s.Elapsed().Seconds(); sec > 0 {
+		return float64(s.Sent) / sec
+	}
+	return 0
+}
+
+// recentSize bounds the pool duplicates are drawn from
+const recentSize = 10000
+
+type generator struct {
+	cfg     GeneratorConfig
+	w       *kafka.Writer
+	rng     *rand.Rand
+	zipf    *rand.Zipf
+	limiter *rate.Limiter
+	runID   string
+	seq     int64
+	recent  []kafka.Message // ring buffer of sent messages, for duplicates
+}
+
+func newGenerator(cfg GeneratorConfig, w *kafka.Writer) *generator {
+	rng := rand.New(rand.NewSource(time.Now().UnixNano()))
+	return &generator{
+		cfg:     cfg,
+		w:       w,
+		rng:     rng,
+		zipf:    rand.NewZipf(rng, cfg.ZipfS, 1, uint64(cfg.Songs-1)),
+		limiter: rate.NewLimiter(rate.Limit(cfg.Rate*cfg.BurstFactor), cfg.BatchSize),
+		runID:   fmt.Sprintf("%x", time.Now().UnixNano()),
+	}
+}
+
+// Run publishes until Duration elapses or ctx is cancelled
+func (g *generator) Run(ctx context.Context) Stats {
+	if g.cfg.Duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, g.cfg.Duration)
+		defer cancel()
+	}
+
+	stats := Stats{Start: time.Now()}
+	progress := time.NewTicker(10 * time.Second)
+	defer progress.Stop()
+	lastSent := int64(0)
+
+	for ctx.Err() == nil {
+		g.waitForBurstWindow(ctx, stats.Start)
+		if err := g.limiter.WaitN(ctx, g.cfg.BatchSize); err != nil {
+			break
+		}
+
+		batch, dups := g.nextBatch()
+		kafkautil.Stamp(batch, kafkautil.Headers{SchemaVersion: g.cfg.Version})
+		kafkautil.Inject(ctx, batch)
+		// Writes aren't cut short by the duration deadline: a started batch finishes
+		if err := g.w.WriteMessages(context.WithoutCancel(ctx), batch...); err != nil {
+			stats.Errors += int64(len(batch))
+			log.Printf("Write failed (%d events): %v", len(batch), err)
+		} else {
+			stats.Sent += int64(len(batch))
+			stats.Duplicates += int64(dups)
+		}
+
+		select {
+		case <-progress.C:
+			log.Printf("Progress: sent=%d (%.0f/s last 10s) dups=%d errors=%d",
+				stats.Sent, float64(stats.Sent-lastSent)/10, stats.Duplicates, stats.Errors)
+			lastSent = stats.Sent
+		default:
+		}
+	}
+
+	stats.End = time.Now()
+	return stats
+}
+
+// waitForBurstWindow sleeps through the idle part of each burst period. The
+// limiter runs at Rate×BurstFactor, so the average stays at Rate.
+func (g *generator) waitForBurstWindow(ctx context.Context, start time.Time) {
+	if g.cfg.BurstFactor <= 1 {
+		return
+	}
+	period := g.cfg.BurstPeriod
+	active := time.Duration(float64(period) / g.cfg.BurstFactor)
+	pos := time.Since(start) % period
+	if pos < active {
+		return
+	}
+	select {
+	case <-ctx.Done():
+	case <-time.After(period - pos):
+	}
+}
+
+// nextBatch builds BatchSize messages, re-sending earlier events at DupRatio
+func (g *generator) nextBatch() ([]kafka.Message, int) {
+	batch := make([]kafka.Message, 0, g.cfg.BatchSize)
+	dups := 0
+	for len(batch) < g.cfg.BatchSize {
+		if len(g.recent) > 0 && g.rng.Float64() < g.cfg.DupRatio {
+			batch = append(batch, g.recent[g.rng.Intn(len(g.recent))])
+			dups++
+			continue
+		}
+		msg := g.newMessage()
+		batch = append(batch, msg)
+		if len(g.recent) < recentSize {
+			g.recent = append(g.recent, msg)
+		} else {
+			g.recent[g.seq%recentSize] = msg
+		}
+	}
+	return batch, dups
+}
+
+func (g *generator) newMessage() kafka.Message {
+	g.seq++
+	user := g.rng.Intn(g.cfg.Users)
+	userID := fmt.Sprintf("loaduser-%d", user)
+	song := g.zipf.Uint64()
+	e := events.New(
+		fmt.Sprintf("loadgen-%s-%d", g.runID, g.seq),
+		userID,
+		fmt.Sprintf("song-%d", song),
+		g.cfg.Provider,
+		time.Now(),
+	)
+	e.ArtistID = fmt.Sprintf("artist-%d", song/10) // ten songs per artist
+	e.DurationMs = int64(150+(song*37)%150) * 1000 // played whole; matches metadata/songs.jsonl
+	if n := len(g.cfg.Experiments); n > 0 {
+		e.Experiment = g.cfg.Experiments[user%n] // a user stays in one arm
+	}
+	data, _ := events.MarshalVersion(e, events.FormatJSON, g.cfg.Version)
+	return kafka.Message{Key: []byte(userID), Value: data}
+}
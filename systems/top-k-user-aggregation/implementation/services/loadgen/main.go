@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/system-design-lab/pkg/events"
+	"github.com/system-design-lab/pkg/kafkautil"
+	"github.com/system-design-lab/pkg/runtimecfg"
+	"github.com/system-design-lab/pkg/startup"
+	"github.com/system-design-lab/pkg/topology"
+)
+
+func main() {
+	if _, err := topology.Apply("loadgen"); err != nil {
+		log.Fatalf("Invalid pipeline config: %v", err)
+	}
+
+	cfg := GeneratorConfig{
+		Topic:       getEnv("TOPIC", "user.listen.raw"),
+		Rate:        getEnvFloat("RATE", 1000),
+		Duration:    getEnvDuration("DURATION", time.Minute),
+		Users:       getEnvInt("USERS", 10000),
+		Songs:       getEnvInt("SONGS", 50000),
+		ZipfS:       getEnvFloat("ZIPF_S", 1.1),
+		DupRatio:    getEnvFloat("DUP_RATIO", 0.02),
+		BurstFactor: getEnvFloat("BURST_FACTOR", 1),
+		BurstPeriod: getEnvDuration("BURST_PERIOD", 10*time.Second),
+		BatchSize:   getEnvInt("BATCH_SIZE", 200),
+		Provider:    getEnv("PROVIDER", "loadgen"),
+		Experiments: splitList(getEnv("EXPERIMENTS", "")),
+		Version:     events.VersionFromEnv(),
+	}
+	lagGroups := splitList(getEnv("LAG_GROUPS", "raw-event-processor,aggregator"))
+	drainTimeout := getEnvDuration("DRAIN_TIMEOUT", 2*time.Minute)
+	reportFile := getEnv("REPORT_FILE", "")
+
+	if err := cfg.validate(); err != nil {
+		log.Fatalf("Invalid config: %v", err)
+	}
+
+	kafkaCfg, err := kafkautil.ConfigFromEnv("localhost:29092")
+	if err != nil {
+		log.Fatalf("Invalid Kafka config: %v", err)
+	}
+
+	log.Printf("Starting loadgen: kafka=%v topic=%s rate=%.0f/s duration=%s users=%d songs=%d zipf=%.2f dup=%.2f burst=%.1fx/%s",
+		kafkaCfg.Brokers, cfg.Topic, cfg.Rate, cfg.Duration, cfg.Users, cfg.Songs, cfg.ZipfS, cfg.DupRatio, cfg.BurstFactor, cfg.BurstPeriod)
+
+	if err := startup.Kafka(context.Background(), kafkaCfg); err != nil {
+		log.Fatalf("Failed to connect to Kafka: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-sigChan
+		log.Println("Stopping...")
+		cancel()
+	}()
+
+	// log_level and SIGHUP reloads of pipeline.yaml's settings (pkg/runtimecfg)
+	if err := runtimecfg.New(nil, "loadgen").Start(ctx); err != nil {
+		log.Printf("Warning: runtime config not loaded: %v", err)
+	}
+
+	w := kafkaCfg.NewWriter(cfg.Topic, kafkautil.WriterConfigFromEnv())
+	defer w.Close()
+
+	gen := newGenerator(cfg, w)
+	stats := gen.Run(ctx)
+
+	report := Report{Config: cfg, Stats: stats}
+	if len(lagGroups) > 0 {
+		// A fresh context: draining should still run after Ctrl-C on the generator
+		drainCtx, drainCancel := context.WithTimeout(context.Background(), drainTimeout)
+		report.Lag = measureDrain(drainCtx, kafkaCfg.Client(), cfg.Topic, lagGroups, stats.End, stats.AchievedRate())
+		drainCancel()
+	}
+
+	report.Print()
+	if reportFile != "" {
+		if err := report.WriteJSON(reportFile); err != nil {
+			log.Printf("Warning: failed to write report: %v", err)
+		} else {
+			log.Printf("Report written to %s", reportFile)
+		}
+	}
+}
+
+func splitList(v string) []string {
+	var out []string
+	for _, s := range strings.Split(v, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+func getEnv(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
+
+func getEnvInt(key string, fallback int) int {
+	if v := os.Getenv(key); v != "" {
+		i, err := strconv.Atoi(v)
+		if err == nil {
+			return i
+		}
+	}
+	return fallback
+}
+
+func getEnvFloat(key string, fallback float64) float64 {
+	if v := os.Getenv(key); v != "" {
+		f, err := strconv.ParseFloat(v, 64)
+		if err == nil {
+			return f
+		}
+	}
+	return fallback
+}
+
+func getEnvDuration(key string, fallback time.Duration) time.Duration {
+	if v := os.Getenv(key); v != "" {
+		d, err := time.ParseDuration(v)
+		if err == nil {
+			return d
+		}
+	}
+	return fallback
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/system-design-lab/pkg/kafkautil"
+)
+
+// GroupDrain is how a consumer group kept up with the run
+type GroupDrain struct {
+	Group      string        `json:"group"`
+	LagAtEnd   int64         `json:"lag_at_end"`  // messages behind when generation stopped
+	Drained    bool          `json:"drained"`     // lag reached 0 before DRAIN_TIMEOUT
+	DrainTime  time.Duration `json:"drain_time"`  // end of generation -> lag 0
+	FinalLag   int64         `json:"final_lag"`   // lag when measurement stopped
+	LagSeconds float64       `json:"lag_seconds"` // LagAtEnd expressed in time at the achieved rate
+	Error      string        `json:"error,omitempty"`
+}
+
+// Report is the run summary
+type Report struct {
+	Config GeneratorConfig `json:"config"`
+	Stats  Stats           `json:"stats"`
+	Lag    []GroupDrain    `json:"lag,omitempty"`
+}
+
+// measureDrain polls each group's lag until it reaches zero or ctx expires.
+// DrainTime bounds the end-to-end latency of the last events generated.
+func measureDrain(ctx context.Context, client *kafka.Client, topic string, groups []string, end time.Time, achievedRate float64) []GroupDrain {
+	out := make([]GroupDrain, len(groups))
+	pending := len(groups)
+	for i, g := range groups {
+		out[i] = GroupDrain{Group: g, LagAtEnd: -1}
+	}
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for pending > 0 {
+		for i := range out {
+			d := &out[i]
+			if d.Drained || d.Error != "" {
+				continue
+			}
+			lag, err := kafkautil.GroupLag(ctx, client, topic, d.Group)
+			if err != nil {
+				if ctx.Err() != nil {
+					break
+				}
+				d.Error = err.Error()
+				pending--
+				continue
+			}
+			if d.LagAtEnd < 0 {
+				d.LagAtEnd = lag.Total
+				if achievedRate > 0 {
+					d.LagSeconds = float64(lag.Total) / achievedRate
+				}
+			}
+			d.FinalLag = lag.Total
+			if lag.Total == 0 {
+				d.Drained = true
+				d.DrainTime = time.Since(end)
+				pending--
+			}
+		}
+		if pending == 0 {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return out
+		case <-ticker.C:
+		}
+	}
+	return out
+}
+
+func (r Report) Print() {
+	s := r.Stats
+	log.Printf("=== loadgen report ===")
+	log.Printf("elapsed=%s sent=%d duplicates=%d errors=%d", s.Elapsed().Round(time.Millisecond), s.Sent, s.Duplicates, s.Errors)
+	log.Printf("target rate=%.0f/s achieved=%.0f/s (%.0f%%)", r.Config.Rate, s.AchievedRate(), 100*s.AchievedRate()/r.Config.Rate)
+	for _, d := range r.Lag {
+		switch {
+		case d.Error != "":
+			log.Printf("group %s: lag unavailable: %s", d.Group, d.Error)
+		case d.Drained:
+			log.Printf("group %s: lag at end=%d (~%.1fs), drained in %s", d.Group, d.LagAtEnd, d.LagSeconds, d.DrainTime.Round(time.Millisecond))
+		default:
+			log.Printf("group %s: lag at end=%d (~%.1fs), NOT drained (still %d behind)", d.Group, d.LagAtEnd, d.LagSeconds, d.FinalLag)
+		}
+	}
+}
+
+func (r Report) WriteJSON(path string) error {
+	data, err := json.MarshalIndent(struct {
+		Report
+		AchievedRate float64 `json:"achieved_rate"`
+	}{r, r.Stats.AchievedRate()}, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0o644); err != nil {
+		return fmt.Errorf("write %s: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,377 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/hibiken/asynq"
+	"github.com/segmentio/kafka-go"
+	"github.com/system-design-lab/pkg/dc"
+	"github.com/system-design-lab/pkg/events"
+	"github.com/system-design-lab/pkg/freshness"
+	"github.com/system-design-lab/pkg/kafkautil"
+	"github.com/system-design-lab/pkg/redisutil"
+	"github.com/system-design-lab/pkg/runtimecfg"
+	"github.com/system-design-lab/pkg/sqlstore"
+	"github.com/system-design-lab/pkg/startup"
+	"github.com/system-design-lab/pkg/storage"
+	"github.com/system-design-lab/pkg/topology"
+)
+
+func main() {
+	if _, err := topology.Apply("materializer"); err != nil {
+		log.Fatalf("Invalid pipeline config: %v", err)
+	}
+
+	topic := getEnv("TOPIC", "user.listen.agg")
+	consumerGroup := getEnv("CONSUMER_GROUP", "materializer")
+	snapshotK := getEnvInt("SNAPSHOT_K", 100)
+	snapshotTTL := getEnvDuration("SNAPSHOT_TTL", 48*time.Hour)
+	rankedK := getEnvInt("RANKED_K", 1000)
+	concurrency := getEnvInt("CONCURRENCY", 8)
+	batchSize := getEnvInt("BATCH_SIZE", 1000)
+	batchTimeout := getEnvDuration("BATCH_TIMEOUT", 2*time.Second)
+	metricsAddr := getEnv("METRICS_ADDR", ":9105")
+	tagRollups := getEnv("TAG_ROLLUPS", "true") == "true"
+	writeThroughOn := getEnv("WRITE_THROUGH", "false") == "true"
+	metadataCrawl := getEnv("METADATA_CRAWL", "true") == "true"
+	metadataRetry := getEnvDuration("METADATA_RETRY", time.Hour)
+
+	windows, err := parseWindows(getEnv("WINDOWS", "1,7,30"))
+	if err != nil {
+		log.Fatalf("Invalid WINDOWS: %v", err)
+	}
+
+	kafkaCfg, err := kafkautil.ConfigFromEnv("localhost:29092")
+	if err != nil {
+		log.Fatalf("Invalid Kafka config: %v", err)
+	}
+
+	log.Printf("Starting materializer: kafka=%v topic=%s group=%s windows=%v k=%d ttl=%s",
+		kafkaCfg.Brokers, topic, consumerGroup, windows, snapshotK, snapshotTTL)
+
+	if err := startup.Kafka(context.Background(), kafkaCfg); err != nil {
+		log.Fatalf("Failed to connect to Kafka: %v", err)
+	}
+
+	// Cassandra, or the STORAGE_BACKEND database (pkg/sqlstore): snapshots
+	// only there, as ranked lists and tag rollups are Cassandra tables
+	storageCfg, err := sqlstore.ConfigFromEnv()
+	if err != nil {
+		log.Fatalf("Invalid storage config: %v", err)
+	}
+	var (
+		session      *storage.Session
+		topk         dayCountsReader
+		snapshots    snapshotWriter
+		takedownRepo *storage.TakedownRepo // nil = nothing is taken down
+	)
+	if storageCfg.SQL() {
+		db, err := startup.SQL(context.Background(), storageCfg)
+		if err != nil {
+			log.Fatalf("Failed to connect to %s: %v", storageCfg.Backend, err)
+		}
+		defer db.Close()
+		log.Printf("Connected to %s", storageCfg)
+		topk, snapshots = sqlstore.NewDailyTopKRepo(db), sqlstore.NewSnapshotRepo(db)
+		rankedK, tagRollups = 0, false
+	} else {
+		if session, err = startup.Cassandra(context.Background()); err != nil {
+			log.Fatalf("Failed to connect to Cassandra: %v", err)
+		}
+		defer session.Close()
+		log.Println("Connected to Cassandra")
+		topk, snapshots = storage.NewDailyTopKRepo(session), storage.NewSnapshotRepo(session)
+		takedownRepo = storage.NewTakedownRepo(session)
+	}
+
+	reader := kafkaCfg.NewReader(kafkautil.ReaderConfig{Topic: topic, GroupID: consumerGroup})
+	defer reader.Close()
+
+	startMetricsServer(metricsAddr)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-sigChan
+		log.Println("Shutting down...")
+		cancel()
+	}()
+
+	// log_level and SIGHUP reloads of pipeline.yaml's settings (pkg/runtimecfg)
+	if err := runtimecfg.New(nil, "materializer").Start(ctx); err != nil {
+		log.Printf("Warning: runtime config not loaded: %v", err)
+	}
+
+	m := &Materializer{
+		topk:      topk,
+		snapshots: snapshots,
+		windows:   windows,
+		k:         snapshotK,
+		ttl:       snapshotTTL,
+		takedowns: storage.NewTakedownSet(takedownRepo),
+	}
+	if err := m.takedowns.Start(ctx); err != nil {
+		log.Printf("Warning: failed to load song takedowns, retrying every %s: %v", storage.TakedownRefresh, err)
+	}
+	if rankedK > 0 {
+		if rankedK < snapshotK {
+			rankedK = snapshotK
+		}
+		m.ranked = storage.NewRankedRepo(session)
+		m.rankedK = rankedK
+		log.Printf("Writing ranked lists of up to %d songs for cursor pagination", rankedK)
+	}
+	if tagRollups {
+		m.metadata = storage.NewSongMetadataRepo(session)
+		m.tags = storage.NewTagTopKRepo(session)
+		log.Println("Rolling touched days up per genre and mood from song_metadata")
+
+		// Songs without metadata are looked up by the crawl-worker, through
+		// the crawl jobs' Redis
+		if metadataCrawl {
+			client := asynq.NewClient(asynq.RedisClientOpt{Addr: getEnv("REDIS_ADDR", "localhost:6379")})
+			defer client.Close()
+			requester := newMetadataRequester(client, metadataRetry)
+			m.metadata.OnMiss(requester.miss)
+			go requester.run(ctx, 5*time.Second)
+			log.Printf("Requesting metadata of unknown songs (crawl:song-metadata, again after %s)", metadataRetry)
+		}
+	}
+	if writeThroughOn {
+		// The api-server's response cache: same address, DB and key prefix
+		cacheAddr := getEnv("CACHE_REDIS_ADDR", getEnv("REDIS_ADDR", "localhost:6379"))
+		redisCfg, err := redisutil.ConfigFromEnv(cacheAddr, getEnvInt("CACHE_REDIS_DB", 0))
+		if err != nil {
+			log.Fatalf("Invalid Redis config: %v", err)
+		}
+		rdb := redisutil.NewClient(redisCfg, "cache")
+		defer rdb.Close()
+		if err := startup.Redis(ctx, rdb); err != nil {
+			log.Fatalf("Failed to connect to cache Redis: %v", err)
+		}
+		m.cache = &writeThrough{rdb: rdb, prefix: getEnv("CACHE_KEY_PREFIX", dc.Prefix()), takedowns: m.takedowns}
+		log.Printf("Writing materialized top-K through to the response cache (%s)", redisCfg)
+	}
+
+	c := &Consumer{
+		reader:       reader,
+		materializer: m,
+		concurrency:  concurrency,
+		batchSize:    batchSize,
+		batchTimeout: batchTimeout,
+	}
+	c.Run(ctx)
+
+	log.Println("Shutdown complete")
+}
+
+// Consumer reads aggregate deltas in batches and materializes each touched
+// user once per batch. A batch's offsets are committed only when every user
+// in it was written, so a failure re-delivers the batch instead of leaving a
+// stale snapshot behind.
+type Consumer struct {
+	reader       *kafka.Reader
+	materializer *Materializer
+	concurrency  int
+	batchSize    int
+	batchTimeout time.Duration
+}
+
+func (c *Consumer) Run(ctx context.Context) {
+	for {
+		batch, err := c.fetchBatch(ctx)
+		if ctx.Err() != nil {
+			return // an unprocessed batch is redelivered on restart
+		}
+		if len(batch) > 0 {
+			c.process(ctx, batch)
+		}
+		if err != nil {
+			log.Printf("Error fetching message: %v", err)
+		}
+	}
+}
+
+// fetchBatch blocks for the first message, then collects more until the
+// batch is full or batchTimeout has passed
+func (c *Consumer) fetchBatch(ctx context.Context) ([]kafka.Message, error) {
+	msg, err := c.reader.FetchMessage(ctx)
+	if err != nil {
+		return nil, err
+	}
+	batch := []kafka.Message{msg}
+
+	fetchCtx, cancel := context.WithTimeout(ctx, c.batchTimeout)
+	defer cancel()
+	for len(batch) < c.batchSize {
+		msg, err := c.reader.FetchMessage(fetchCtx)
+		if err != nil {
+			if errors.Is(err, context.DeadlineExceeded) {
+				break
+			}
+			return batch, err
+		}
+		batch = append(batch, msg)
+	}
+	return batch, nil
+}
+
+func (c *Consumer) process(ctx context.Context, batch []kafka.Message) {
+	start := time.Now()
+
+	seen := make(map[string]bool)
+	var users []string
+	days := make(map[string][]string) // user -> days its deltas touched
+	published := make(freshness.Published)
+	for _, msg := range batch {
+		delta, err := events.UnmarshalDelta(msg.Value)
+		if err != nil {
+			log.Printf("Error decoding delta (partition=%d offset=%d): %v", msg.Partition, msg.Offset, err)
+			metricDecodeErrors.Add(1)
+			continue
+		}
+		metricDeltasConsumed.Add(1)
+		if !seen[delta.UserID] {
+			seen[delta.UserID] = true
+			users = append(users, delta.UserID)
+		}
+		if !contains(days[delta.UserID], delta.Day) {
+			days[delta.UserID] = append(days[delta.UserID], delta.Day)
+		}
+		published.Merge(delta.Published)
+	}
+
+	backoff := 500 * time.Millisecond
+	for len(users) > 0 {
+		users = c.materializeAll(ctx, users, days)
+		if len(users) == 0 {
+			break
+		}
+		log.Printf("Retrying %d users in %s", len(users), backoff)
+		select {
+		case <-ctx.Done():
+			log.Printf("Shutdown during retry, batch left uncommitted")
+			return
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > 10*time.Second {
+			backoff = 10 * time.Second
+		}
+	}
+	// Every snapshot of the batch is written: its listens are queryable
+	published.Observe(metricEventToQueryable, time.Now())
+
+	if err := kafkautil.CommitWithRetry(ctx, c.reader, 3, kafkautil.LatestPerPartition(batch)...); err != nil {
+		log.Printf("Error committing offsets: %v", err)
+		metricCommitErrors.Add(1)
+	}
+
+	metricLastBatchUsers.Set(int64(len(seen)))
+	metricLastBatchMillis.Set(time.Since(start).Milliseconds())
+	log.Printf("Materialized %d users from %d deltas in %s", len(seen), len(batch), time.Since(start).Round(time.Millisecond))
+}
+
+// materializeAll rebuilds users' snapshots (and the tag rollups of their
+// touched days) concurrently and returns the ones that failed
+func (c *Consumer) materializeAll(ctx context.Context, users []string, days map[string][]string) []string {
+	var (
+		mu     sync.Mutex
+		failed []string
+		wg     sync.WaitGroup
+	)
+	sem := make(chan struct{}, c.concurrency)
+	for _, userID := range users {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(userID string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			if err := c.materializer.Materialize(ctx, userID, days[userID]); err != nil {
+				log.Printf("Error materializing %s: %v", userID, err)
+				metricErrors.Add(1)
+				mu.Lock()
+				failed = append(failed, userID)
+				mu.Unlock()
+				return
+			}
+			metricUsersMaterialized.Add(1)
+		}(userID)
+	}
+	wg.Wait()
+	return failed
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// parseWindows parses "1,7,30" into sorted, distinct day counts
+func parseWindows(s string) ([]int, error) {
+	seen := make(map[int]bool)
+	var out []int
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("%q is not a positive number of days", part)
+		}
+		if !seen[n] {
+			seen[n] = true
+			out = append(out, n)
+		}
+	}
+	if len(out) == 0 {
+		return nil, fmt.Errorf("no windows")
+	}
+	sort.Ints(out)
+	return out, nil
+}
+
+func getEnv(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
+
+func getEnvInt(key string, fallback int) int {
+	if v := os.Getenv(key); v != "" {
+		i, err := strconv.Atoi(v)
+		if err == nil {
+			return i
+		}
+	}
+	return fallback
+}
+
+func getEnvDuration(key string, fallback time.Duration) time.Duration {
+	if v := os.Getenv(key); v != "" {
+		d, err := time.ParseDuration(v)
+		if err == nil {
+			return d
+		}
+	}
+	return fallback
+}
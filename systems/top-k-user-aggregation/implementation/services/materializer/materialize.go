@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/system-design-lab/pkg/storage"
+)
+
+// Materializer rebuilds a user's user_topk_snapshot rows, one per window
+type Materializer struct {
+	topk      dayCountsReader
+	snapshots snapshotWriter
+	windows   []int // ascending, e.g. 1, 7, 30
+	k         int
+	ttl       time.Duration
+	takedowns *storage.TakedownSet // songs left out until the purge deletes them
+
+	// Ranked lists for cursor pagination (RANKED_K); nil = off
+	ranked  *storage.RankedRepo
+	rankedK int // >= k
+
+	// Genre/mood rollups (TAG_ROLLUPS); nil = off
+	metadata *storage.SongMetadataRepo
+	tags     *storage.TagTopKRepo
+
+	// api-server response cache write-through (WRITE_THROUGH); nil = off
+	cache *writeThrough
+}
+
+// The daily counts read and snapshots written: the storage repos over
+// Cassandra, or the sqlstore ones with STORAGE_BACKEND
+type (
+	dayCountsReader interface {
+		DayCounts(ctx context.Context, userID, day string) (map[string]int64, error)
+	}
+	snapshotWriter interface {
+		Put(ctx context.Context, snap storage.Snapshot, ttl time.Duration) error
+	}
+)
+
+// Materialize reads the user's daily counts once for the longest window and
+// writes a snapshot for every window on the way, so 1/7/30 costs 30 partition
+// reads rather than 38. The touched days (the deltas' days) then get their
+// genre and mood rollups rewritten.
+//
+// With ranked lists on, each window's top rankedK songs are also written as
+// a new user_topk_ranked version before the snapshot that points at it. With
+// write-through on, the user's cached responses are rewritten from the new
+// snapshots.
+func (m *Materializer) Materialize(ctx context.Context, userID string, touched []string) error {
+	now := time.Now()
+	depth := m.k
+	if m.ranked != nil {
+		depth = m.rankedK
+	}
+	days := storage.LastDays(m.windows[len(m.windows)-1])
+
+	total := make(map[string]int64)
+	written := make(map[int][]storage.SongCount) // window -> snapshot songs
+	next := 0
+	for i, day := range days {
+		counts, err := m.topk.DayCounts(ctx, userID, day)
+		if err != nil {
+			return err
+		}
+		metricTakedownsDropped.Add(int64(storage.DropTakedowns(m.takedowns, counts)))
+		for song, c := range counts {
+			total[song] += c
+		}
+
+		if i+1 != m.windows[next] {
+			continue
+		}
+		songs := topSongs(total, depth)
+		snap := storage.Snapshot{UserID: userID, WindowDays: m.windows[next], ComputedAt: now, Songs: songs}
+		if len(songs) > m.k {
+			snap.Songs = songs[:m.k]
+		}
+		if m.ranked != nil {
+			snap.RankedVersion, snap.RankedTotal = now.UnixMilli(), len(songs)
+			if err := m.ranked.Put(ctx, userID, snap.WindowDays, snap.RankedVersion, songs, m.ttl); err != nil {
+				return fmt.Errorf("put %d-day ranked list: %w", snap.WindowDays, err)
+			}
+			metricRankedRows.Add(int64(len(songs)))
+		}
+		if err := m.snapshots.Put(ctx, snap, m.ttl); err != nil {
+			return fmt.Errorf("put %d-day snapshot: %w", snap.WindowDays, err)
+		}
+		metricSnapshotsWritten.Add(1)
+		written[snap.WindowDays] = snap.Songs
+		next++
+	}
+	if m.cache != nil {
+		m.cache.refresh(ctx, userID, days[0], written, m.k)
+	}
+	return m.rollupTags(ctx, userID, touched)
+}
+
+// rollupTags rebuilds user_daily_tag_topk for the given days of a user
+func (m *Materializer) rollupTags(ctx context.Context, userID string, days []string) error {
+	if m.tags == nil {
+		return nil
+	}
+	for _, day := range days {
+		counts, err := m.topk.DayCounts(ctx, userID, day)
+		if err != nil {
+			return fmt.Errorf("read counts of %s: %w", day, err)
+		}
+		storage.DropTakedowns(m.takedowns, counts)
+		if err := storage.RebuildTagsFrom(ctx, m.metadata, m.tags, userID, day, counts); err != nil {
+			return fmt.Errorf("rebuild tags of %s: %w", day, err)
+		}
+		metricTagDaysWritten.Add(1)
+	}
+	return nil
+}
+
+// topSongs returns the k highest counts, ties broken by song ID so rewrites
+// of unchanged counts produce the same list
+func topSongs(counts map[string]int64, k int) []storage.SongCount {
+	songs := make([]storage.SongCount, 0, len(counts))
+	for id, c := range counts {
+		if c > 0 {
+			songs = append(songs, storage.SongCount{SongID: id, Count: c})
+		}
+	}
+	sort.Slice(songs, func(i, j int) bool {
+		if songs[i].Count != songs[j].Count {
+			return songs[i].Count > songs[j].Count
+		}
+		return songs[i].SongID < songs[j].SongID
+	})
+	if len(songs) > k {
+		songs = songs[:k]
+	}
+	return songs
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"expvar"
+	"log"
+	"net/http"
+
+	"github.com/system-design-lab/pkg/freshness"
+)
+
+// Materializer metrics, served as JSON on METRICS_ADDR/debug/vars
+var (
+	metricDeltasConsumed    = expvar.NewInt("deltas_consumed")
+	metricDecodeErrors      = expvar.NewInt("decode_errors")
+	metricUsersMaterialized = expvar.NewInt("users_materialized")
+	metricSnapshotsWritten  = expvar.NewInt("snapshots_written")
+	metricRankedRows        = expvar.NewInt("ranked_rows_written")
+	metricTagDaysWritten    = expvar.NewInt("tag_days_written")
+	metricTakedownsDropped  = expvar.NewInt("takedown_rows_dropped") // taken-down songs' day counts left out
+	metricErrors            = expvar.NewInt("materialize_errors")
+	metricCommitErrors      = expvar.NewInt("commit_errors")
+	metricLastBatchUsers    = expvar.NewInt("last_batch_users")
+	metricLastBatchMillis   = expvar.NewInt("last_batch_ms")
+)
+
+// Write-through metrics (writethrough.go)
+var (
+	metricCacheRefreshed   = expvar.NewInt("cache_entries_refreshed") // cached responses rewritten from a snapshot
+	metricCacheDropped     = expvar.NewInt("cache_entries_dropped")   // cached responses a snapshot can't rebuild, deleted
+	metricCacheWriteErrors = expvar.NewInt("cache_write_errors")
+)
+
+// Song metadata lookup metrics (songmeta.go)
+var (
+	metricMetadataRequested = expvar.NewInt("metadata_songs_requested") // songs sent to the crawl-worker for lookup
+	metricMetadataErrors    = expvar.NewInt("metadata_request_errors")
+)
+
+// metricEventToQueryable is the pipeline's SLO: listens from their producer's
+// publish to their snapshot being written (see pkg/freshness)
+var metricEventToQueryable = freshness.NewHistogram("event_to_queryable_latency")
+
+// startMetricsServer exposes expvar metrics over HTTP
+func startMetricsServer(addr string) {
+	go func() {
+		log.Printf("Metrics on http://%s/debug/vars", addr)
+		if err := http.ListenAndServe(addr, nil); err != nil {
+			log.Printf("Metrics server error: %v", err)
+		}
+	}()
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/hibiken/asynq"
+)
+
+// Song metadata lookups, served by the crawl-worker
+const (
+	TypeCrawlSongMetadata = "crawl:song-metadata"
+	songMetadataQueue     = "song-metadata"
+)
+
+// SongMetadataPayload matches the crawl-worker's expected payload
+type SongMetadataPayload struct {
+	SongIDs []string `json:"song_ids"`
+}
+
+// metadataBatch bounds the songs of one lookup, like a provider catalog
+// API's batch of track IDs
+const metadataBatch = 50
+
+// metadataRequester enqueues crawl:song-metadata jobs for the songs the tag
+// rollups found no metadata for. Each song is asked for at most once per
+// retry interval, which also covers songs no catalog knows.
+type metadataRequester struct {
+	client *asynq.Client
+	retry  time.Duration
+
+	mu        sync.Mutex
+	pending   map[string]bool
+	requested map[string]time.Time
+}
+
+func newMetadataRequester(client *asynq.Client, retry time.Duration) *metadataRequester {
+	return &metadataRequester{
+		client:    client,
+		retry:     retry,
+		pending:   make(map[string]bool),
+		requested: make(map[string]time.Time),
+	}
+}
+
+// miss queues songs without metadata for the next lookup; it is the
+// SongMetadataRepo's OnMiss hook, called by every materializing goroutine
+func (r *metadataRequester) miss(songIDs []string) {
+	now := time.Now()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, id := range songIDs {
+		if at, ok := r.requested[id]; ok && now.Sub(at) < r.retry {
+			continue
+		}
+		r.requested[id] = now
+		r.pending[id] = true
+	}
+}
+
+// run enqueues the pending songs every interval until ctx is cancelled
+func (r *metadataRequester) run(ctx context.Context, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			r.flush()
+		}
+	}
+}
+
+// flush enqueues one job per batch of pending songs. Songs of a failed
+// enqueue are forgotten, so the next miss asks for them again.
+func (r *metadataRequester) flush() {
+	r.mu.Lock()
+	now := time.Now()
+	for id, at := range r.requested {
+		if now.Sub(at) >= r.retry {
+			delete(r.requested, id)
+		}
+	}
+	songs := make([]string, 0, len(r.pending))
+	for id := range r.pending {
+		songs = append(songs, id)
+	}
+	r.pending = make(map[string]bool)
+	r.mu.Unlock()
+
+	sort.Strings(songs)
+	for start := 0; start < len(songs); start += metadataBatch {
+		end := start + metadataBatch
+		if end > len(songs) {
+			end = len(songs)
+		}
+		if err := r.enqueue(songs[start:end]); err != nil {
+			log.Printf("Warning: failed to request metadata of %d songs: %v", end-start, err)
+			metricMetadataErrors.Add(1)
+			r.mu.Lock()
+			for _, id := range songs[start:end] {
+				delete(r.requested, id)
+			}
+			r.mu.Unlock()
+			continue
+		}
+		metricMetadataRequested.Add(int64(end - start))
+	}
+}
+
+// enqueue asks the crawl-worker to look songIDs up. An identical batch
+// still queued (from another materializer) is not enqueued twice.
+func (r *metadataRequester) enqueue(songIDs []string) error {
+	payload, err := json.Marshal(SongMetadataPayload{SongIDs: songIDs})
+	if err != nil {
+		return err
+	}
+	task := asynq.NewTask(TypeCrawlSongMetadata, payload)
+	_, err = r.client.Enqueue(task, asynq.Queue(songMetadataQueue), asynq.MaxRetry(5), asynq.Unique(r.retry))
+	if errors.Is(err, asynq.ErrDuplicateTask) {
+		return nil
+	}
+	return err
+}
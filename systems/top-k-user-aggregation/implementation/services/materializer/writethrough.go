@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/system-design-lab/pkg/storage"
+)
+
+// cacheKBuckets are the api-server's kBuckets: the k its responses are
+// cached at
+var cacheKBuckets = []int{10, 25, 50, 100}
+
+// maxCachedDays is the api-server's longest days window
+const maxCachedDays = 30
+
+// cachedResult and cachedTopK are the api-server's TopKResult and
+// TopKResponse as it caches a days window ranked by count
+type cachedResult struct {
+	SongID      string `json:"song_id"`
+	ListenCount int64  `json:"listen_count"`
+	Rank        int    `json:"rank"`
+}
+
+type cachedTopK struct {
+	UserID  string         `json:"user_id"`
+	Days    int            `json:"days"`
+	K       int            `json:"k"`
+	RankBy  string         `json:"rank_by"`
+	Results []cachedResult `json:"results"`
+	Cached  bool           `json:"cached"`
+}
+
+// writeThrough brings the api-server's cached top-K of a materialized user up
+// to date (WRITE_THROUGH), so a listen shows at the user's next call instead
+// of when the entry expires. The snapshot windows' entries are rewritten
+// from the snapshots, only where one is cached (SET XX) and keeping its TTL,
+// which the api-server caps at midnight; the user's other days windows of
+// today, and rank_by=time, can't be rebuilt from a snapshot and are deleted.
+// Sliding (hours=), experiment, rank_by=recent and rollup entries are left
+// to expire.
+type writeThrough struct {
+	rdb       *redis.Client
+	prefix    string // CACHE_KEY_PREFIX, as the api-server's
+	takedowns *storage.TakedownSet
+}
+
+// keyPrefix is the api-server's songsKeyPrefix
+func (c *writeThrough) keyPrefix() string {
+	if v := c.takedowns.Version(); v > 0 {
+		return fmt.Sprintf("%std%d:", c.prefix, v)
+	}
+	return c.prefix
+}
+
+// refresh rewrites userID's cached days windows ending on lastDay from snaps
+// (window days -> its snapshot songs, at most k of them). A failure is
+// logged and counted: the entries then keep their old response until it
+// expires.
+func (c *writeThrough) refresh(ctx context.Context, userID, lastDay string, snaps map[int][]storage.SongCount, k int) {
+	prefix := c.keyPrefix()
+	var (
+		stale []string
+		sets  []*redis.StatusCmd
+		pipe  = c.rdb.Pipeline()
+	)
+	for days := 1; days <= maxCachedDays; days++ {
+		key := fmt.Sprintf("%stopk:%s:%d@%s", prefix, userID, days, lastDay)
+		songs, ok := snaps[days]
+		for _, kb := range cacheKBuckets {
+			bucketKey := fmt.Sprintf("%s:%d", key, kb)
+			stale = append(stale, bucketKey+":time")
+			// A snapshot cut at k can't tell a larger bucket's list
+			if !ok || (kb > k && len(songs) == k) {
+				stale = append(stale, bucketKey)
+				continue
+			}
+			value, err := json.Marshal(cachedResponse(userID, days, kb, songs))
+			if err != nil {
+				log.Printf("Error encoding cached top-K of %s: %v", userID, err)
+				metricCacheWriteErrors.Add(1)
+				return
+			}
+			sets = append(sets, pipe.SetArgs(ctx, bucketKey, value, redis.SetArgs{Mode: "XX", KeepTTL: true}))
+		}
+	}
+	unlinked := pipe.Unlink(ctx, stale...)
+	pipe.Exec(ctx) // errors are per command: redis.Nil is a SET XX with nothing cached
+
+	failed := unlinked.Err()
+	for _, set := range sets {
+		switch err := set.Err(); err {
+		case nil:
+			metricCacheRefreshed.Add(1)
+		case redis.Nil:
+		default:
+			failed = err
+		}
+	}
+	metricCacheDropped.Add(unlinked.Val())
+	if failed != nil {
+		log.Printf("Error writing through the cached top-K of %s: %v", userID, failed)
+		metricCacheWriteErrors.Add(1)
+	}
+}
+
+// cachedResponse is the api-server's response for a window from its
+// snapshot songs, cut to k
+func cachedResponse(userID string, days, k int, songs []storage.SongCount) cachedTopK {
+	resp := cachedTopK{UserID: userID, Days: days, K: k, RankBy: "count", Results: []cachedResult{}}
+	for i, s := range songs {
+		if i == k {
+			break
+		}
+		resp.Results = append(resp.Results, cachedResult{SongID: s.SongID, ListenCount: s.Count, Rank: i + 1})
+	}
+	return resp
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	kindEnteredTopK = "entered_top_k"
+	kindRankChanged = "rank_changed"
+)
+
+// Notification is one change to a user's top-K, published on the
+// notifications topic and POSTed to the webhook
+type Notification struct {
+	Kind       string `json:"kind"` // entered_top_k or rank_changed
+	UserID     string `json:"user_id"`
+	SongID     string `json:"song_id"`
+	Rank       int    `json:"rank"`
+	PrevRank   int    `json:"prev_rank,omitempty"` // 0 = wasn't in the top K
+	Count      int64  `json:"listen_count"`
+	WindowDays int    `json:"window_days"`
+	At         int64  `json:"at"` // unix seconds
+}
+
+// RankedSong is one entry of a stored ranking
+type RankedSong struct {
+	SongID string `json:"song_id"`
+	Count  int64  `json:"count"`
+}
+
+// Detector reads a user's top-K, from Cassandra or the api-server, and diffs
+// it against the last ranking it saw, kept in Redis so restarts don't
+// re-notify
+type Detector struct {
+	ranking    rankingReader
+	redis      *redis.Client
+	k          int
+	windowDays int
+	rankChange int // minimum rank move to notify (0 = only new entries)
+	stateTTL   time.Duration
+}
+
+func stateKey(userID string, windowDays, k int) string {
+	return fmt.Sprintf("notify:topk:%s:%d:%d", userID, windowDays, k)
+}
+
+// Evaluate returns the notifications for userID's current top-K and the
+// ranking to Save once they're delivered. A user seen for the first time is a
+// baseline: no notifications, just the ranking.
+func (d *Detector) Evaluate(ctx context.Context, userID string) ([]Notification, []RankedSong, error) {
+	current, err := d.ranking.Ranking(ctx, userID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	prev, ok, err := d.load(ctx, userID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !ok {
+		metricBaselines.Add(1)
+		return nil, current, nil
+	}
+	return d.diff(userID, prev, current), current, nil
+}
+
+// Save stores ranking as the last one seen for userID
+func (d *Detector) Save(ctx context.Context, userID string, ranking []RankedSong) error {
+	data, err := json.Marshal(ranking)
+	if err != nil {
+		return err
+	}
+	return d.redis.Set(ctx, stateKey(userID, d.windowDays, d.k), data, d.stateTTL).Err()
+}
+
+func (d *Detector) load(ctx context.Context, userID string) ([]RankedSong, bool, error) {
+	data, err := d.redis.Get(ctx, stateKey(userID, d.windowDays, d.k)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("load state: %w", err)
+	}
+	var ranking []RankedSong
+	if err := json.Unmarshal(data, &ranking); err != nil {
+		return nil, false, fmt.Errorf("decode state: %w", err)
+	}
+	return ranking, true, nil
+}
+
+func (d *Detector) diff(userID string, prev, current []RankedSong) []Notification {
+	prevRank := make(map[string]int, len(prev))
+	for i, s := range prev {
+		prevRank[s.SongID] = i + 1
+	}
+
+	now := time.Now().Unix()
+	var out []Notification
+	for i, s := range current {
+		n := Notification{
+			UserID:     userID,
+			SongID:     s.SongID,
+			Rank:       i + 1,
+			PrevRank:   prevRank[s.SongID],
+			Count:      s.Count,
+			WindowDays: d.windowDays,
+			At:         now,
+		}
+		switch {
+		case n.PrevRank == 0:
+			n.Kind = kindEnteredTopK
+		case d.rankChange > 0 && abs(n.Rank-n.PrevRank) >= d.rankChange:
+			n.Kind = kindRankChanged
+		default:
+			continue
+		}
+		out = append(out, n)
+	}
+	return out
+}
+
+// rank returns the top k songs by count. Ties break on song ID so the same
+// counts always give the same ranking (otherwise ties would flap).
+func rank(counts map[string]int64, k int) []RankedSong {
+	songs := make([]RankedSong, 0, len(counts))
+	for id, c := range counts {
+		if c > 0 {
+			songs = append(songs, RankedSong{SongID: id, Count: c})
+		}
+	}
+	sort.Slice(songs, func(i, j int) bool {
+		if songs[i].Count != songs[j].Count {
+			return songs[i].Count > songs[j].Count
+		}
+		return songs[i].SongID < songs[j].SongID
+	})
+	if len(songs) > k {
+		songs = songs[:k]
+	}
+	return songs
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
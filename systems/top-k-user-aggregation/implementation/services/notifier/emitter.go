@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/system-design-lab/pkg/kafkautil"
+)
+
+// Emitter delivers a user's notifications somewhere
+type Emitter interface {
+	Name() string
+	Emit(ctx context.Context, userID string, ns []Notification) error
+	Close() error
+}
+
+// KafkaEmitter publishes one message per notification, keyed by user_id
+type KafkaEmitter struct {
+	w *kafka.Writer
+}
+
+func newKafkaEmitter(cfg kafkautil.Config, topic string) *KafkaEmitter {
+	return &KafkaEmitter{w: cfg.NewWriter(topic, kafkautil.WriterConfigFromEnv())}
+}
+
+func (e *KafkaEmitter) Name() string { return "kafka:" + e.w.Topic }
+
+func (e *KafkaEmitter) Emit(ctx context.Context, userID string, ns []Notification) error {
+	msgs := make([]kafka.Message, 0, len(ns))
+	for _, n := range ns {
+		value, err := json.Marshal(n)
+		if err != nil {
+			return err
+		}
+		msgs = append(msgs, kafka.Message{Key: []byte(userID), Value: value})
+	}
+	kafkautil.Inject(ctx, msgs)
+	return e.w.WriteMessages(ctx, msgs...)
+}
+
+func (e *KafkaEmitter) Close() error { return e.w.Close() }
+
+// WebhookEmitter POSTs {"user_id": ..., "notifications": [...]} to a URL,
+// retrying failed deliveries a few times
+type WebhookEmitter struct {
+	url      string
+	client   *http.Client
+	attempts int
+}
+
+func newWebhookEmitter(url string, timeout time.Duration) *WebhookEmitter {
+	return &WebhookEmitter{url: url, client: &http.Client{Timeout: timeout}, attempts: 3}
+}
+
+func (e *WebhookEmitter) Name() string { return "webhook" }
+
+func (e *WebhookEmitter) Emit(ctx context.Context, userID string, ns []Notification) error {
+	body, err := json.Marshal(struct {
+		UserID        string         `json:"user_id"`
+		Notifications []Notification `json:"notifications"`
+	}{userID, ns})
+	if err != nil {
+		return err
+	}
+
+	backoff := 500 * time.Millisecond
+	for attempt := 1; ; attempt++ {
+		err = e.post(ctx, body)
+		if err == nil || attempt >= e.attempts {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+func (e *WebhookEmitter) post(ctx context.Context, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if trace := kafkautil.TraceFromContext(ctx); trace != "" {
+		req.Header.Set("X-Trace-Id", trace)
+	}
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+func (e *WebhookEmitter) Close() error { return nil }
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/segmentio/kafka-go"
+	"github.com/system-design-lab/pkg/chaos"
+	"github.com/system-design-lab/pkg/events"
+	"github.com/system-design-lab/pkg/kafkautil"
+	"github.com/system-design-lab/pkg/runtimecfg"
+	"github.com/system-design-lab/pkg/startup"
+	"github.com/system-design-lab/pkg/storage"
+	"github.com/system-design-lab/pkg/topkclient"
+	"github.com/system-design-lab/pkg/topology"
+)
+
+func main() {
+	if _, err := topology.Apply("notifier"); err != nil {
+		log.Fatalf("Invalid pipeline config: %v", err)
+	}
+
+	topic := getEnv("TOPIC", "user.listen.agg")
+	consumerGroup := getEnv("CONSUMER_GROUP", "notifier")
+	redisAddr := getEnv("REDIS_ADDR", "localhost:6379")
+	notifyTopic := getEnv("NOTIFY_TOPIC", "user.topk.notifications")
+	webhookURL := getEnv("WEBHOOK_URL", "")
+	webhookTimeout := getEnvDuration("WEBHOOK_TIMEOUT", 5*time.Second)
+	topK := getEnvInt("TOP_K", 10)
+	windowDays := getEnvInt("WINDOW_DAYS", 7)
+	rankChange := getEnvInt("RANK_CHANGE", 3)
+	stateTTL := getEnvDuration("STATE_TTL", 30*24*time.Hour)
+	batchSize := getEnvInt("BATCH_SIZE", 500)
+	batchTimeout := getEnvDuration("BATCH_TIMEOUT", time.Second)
+	metricsAddr := getEnv("METRICS_ADDR", ":9104")
+
+	kafkaCfg, err := kafkautil.ConfigFromEnv("localhost:29092")
+	if err != nil {
+		log.Fatalf("Invalid Kafka config: %v", err)
+	}
+
+	log.Printf("Starting notifier: kafka=%v topic=%s group=%s top_k=%d window=%dd rank_change=%d",
+		kafkaCfg.Brokers, topic, consumerGroup, topK, windowDays, rankChange)
+
+	if err := startup.Kafka(context.Background(), kafkaCfg); err != nil {
+		log.Fatalf("Failed to connect to Kafka: %v", err)
+	}
+
+	// With TOPK_API_URL the rankings come from the api-server (pkg/topkclient),
+	// without it from Cassandra's daily counters
+	var ranking rankingReader
+	if os.Getenv("TOPK_API_URL") != "" {
+		apiCfg, err := topkclient.ConfigFromEnv("")
+		if err != nil {
+			log.Fatalf("Invalid api-server config: %v", err)
+		}
+		if topK > 100 || windowDays > 30 {
+			log.Fatalf("TOP_K (%d) and WINDOW_DAYS (%d) must be at most 100 and 30 to read top-Ks from the api-server", topK, windowDays)
+		}
+		ranking = apiRanking{client: topkclient.New(apiCfg), windowDays: windowDays, k: topK}
+		log.Printf("Reading rankings from the api-server at %s", apiCfg)
+	} else {
+		session, err := startup.Cassandra(context.Background())
+		if err != nil {
+			log.Fatalf("Failed to connect to Cassandra: %v", err)
+		}
+		defer session.Close()
+		log.Println("Connected to Cassandra")
+		ranking = cassandraRanking{topk: storage.NewDailyTopKRepo(session), windowDays: windowDays, k: topK}
+	}
+
+	rdb := redis.NewClient(&redis.Options{Addr: redisAddr})
+	defer rdb.Close()
+	if err := startup.Redis(context.Background(), rdb); err != nil {
+		log.Fatalf("Failed to connect to Redis: %v", err)
+	}
+	log.Println("Connected to Redis")
+	if chaos.Enabled() {
+		rdb.AddHook(chaos.RedisHook{})
+	}
+
+	var emitters []Emitter
+	if notifyTopic != "" {
+		emitters = append(emitters, newKafkaEmitter(kafkaCfg, notifyTopic))
+	}
+	if webhookURL != "" {
+		emitters = append(emitters, newWebhookEmitter(webhookURL, webhookTimeout))
+	}
+	if len(emitters) == 0 {
+		log.Fatalf("Nothing to notify: set NOTIFY_TOPIC and/or WEBHOOK_URL")
+	}
+	for _, e := range emitters {
+		defer e.Close()
+		log.Printf("Emitting notifications to %s", e.Name())
+	}
+
+	reader := kafkaCfg.NewReader(kafkautil.ReaderConfig{Topic: topic, GroupID: consumerGroup})
+	defer reader.Close()
+
+	startMetricsServer(metricsAddr)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-sigChan
+		log.Println("Shutting down...")
+		cancel()
+	}()
+
+	// log_level and SIGHUP reloads of pipeline.yaml's settings (pkg/runtimecfg)
+	if err := runtimecfg.New(rdb, "notifier").Start(ctx); err != nil {
+		log.Printf("Warning: runtime config not loaded: %v", err)
+	}
+
+	n := &Notifier{
+		reader: reader,
+		detector: &Detector{
+			ranking:    ranking,
+			redis:      rdb,
+			k:          topK,
+			windowDays: windowDays,
+			rankChange: rankChange,
+			stateTTL:   stateTTL,
+		},
+		emitters:     emitters,
+		batchSize:    batchSize,
+		batchTimeout: batchTimeout,
+	}
+	n.Run(ctx)
+
+	log.Println("Shutdown complete")
+}
+
+// Notifier consumes aggregate deltas in batches and evaluates each touched
+// user once per batch, however many deltas it had
+type Notifier struct {
+	reader       *kafka.Reader
+	detector     *Detector
+	emitters     []Emitter
+	batchSize    int
+	batchTimeout time.Duration
+}
+
+func (n *Notifier) Run(ctx context.Context) {
+	for {
+		batch, err := n.fetchBatch(ctx)
+		if ctx.Err() != nil {
+			return // an unprocessed batch is redelivered on restart
+		}
+		if len(batch) > 0 {
+			n.process(ctx, batch)
+		}
+		if err != nil {
+			log.Printf("Error fetching message: %v", err)
+		}
+	}
+}
+
+// fetchBatch blocks for the first message, then collects more until the
+// batch is full or batchTimeout has passed
+func (n *Notifier) fetchBatch(ctx context.Context) ([]kafka.Message, error) {
+	msg, err := n.reader.FetchMessage(ctx)
+	if err != nil {
+		return nil, err
+	}
+	batch := []kafka.Message{msg}
+
+	fetchCtx, cancel := context.WithTimeout(ctx, n.batchTimeout)
+	defer cancel()
+	for len(batch) < n.batchSize {
+		msg, err := n.reader.FetchMessage(fetchCtx)
+		if err != nil {
+			if errors.Is(err, context.DeadlineExceeded) {
+				break
+			}
+			return batch, err
+		}
+		batch = append(batch, msg)
+	}
+	return batch, nil
+}
+
+func (n *Notifier) process(ctx context.Context, batch []kafka.Message) {
+	// Last message per user, which carries the trace of the newest delta
+	latest := make(map[string]kafka.Message)
+	var order []string
+	for _, msg := range batch {
+		delta, err := events.UnmarshalDelta(msg.Value)
+		if err != nil {
+			log.Printf("Error decoding delta (partition=%d offset=%d): %v", msg.Partition, msg.Offset, err)
+			metricDecodeErrors.Add(1)
+			continue
+		}
+		metricDeltasConsumed.Add(1)
+		if _, seen := latest[delta.UserID]; !seen {
+			order = append(order, delta.UserID)
+		}
+		latest[delta.UserID] = msg
+	}
+
+	sent := 0
+	for _, userID := range order {
+		sent += n.evaluate(kafkautil.ExtractHeaders(ctx, latest[userID]), userID)
+	}
+
+	if err := kafkautil.CommitWithRetry(ctx, n.reader, 3, kafkautil.LatestPerPartition(batch)...); err != nil {
+		log.Printf("Error committing offsets: %v", err)
+		metricCommitErrors.Add(1)
+	}
+	if sent > 0 {
+		log.Printf("Processed %d deltas for %d users: %d notifications", len(batch), len(order), sent)
+	}
+}
+
+// evaluate diffs one user's top-K and delivers the changes. The new ranking
+// is only saved once every emitter succeeded, so a failed delivery is
+// detected again on the user's next delta.
+func (n *Notifier) evaluate(ctx context.Context, userID string) int {
+	metricUsersEvaluated.Add(1)
+	ns, ranking, err := n.detector.Evaluate(ctx, userID)
+	if errors.Is(err, errNoRanking) {
+		metricNoRanking.Add(1)
+		return 0
+	}
+	if err != nil {
+		log.Printf("Error evaluating %s: %v", userID, err)
+		metricEvalErrors.Add(1)
+		return 0
+	}
+
+	delivered := true
+	if len(ns) > 0 {
+		for _, e := range n.emitters {
+			if err := e.Emit(ctx, userID, ns); err != nil {
+				log.Printf("Error emitting %d notifications for %s to %s (trace=%s): %v",
+					len(ns), userID, e.Name(), kafkautil.TraceFromContext(ctx), err)
+				metricEmitErrors.Add(e.Name(), 1)
+				delivered = false
+			}
+		}
+	}
+	if !delivered {
+		return 0
+	}
+	for _, nt := range ns {
+		metricNotifications.Add(nt.Kind, 1)
+	}
+
+	if err := n.detector.Save(ctx, userID, ranking); err != nil {
+		log.Printf("Error saving ranking for %s: %v", userID, err)
+	}
+	return len(ns)
+}
+
+func getEnv(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
+
+func getEnvInt(key string, fallback int) int {
+	if v := os.Getenv(key); v != "" {
+		i, err := strconv.Atoi(v)
+		if err == nil {
+			return i
+		}
+	}
+	return fallback
+}
+
+func getEnvDuration(key string, fallback time.Duration) time.Duration {
+	if v := os.Getenv(key); v != "" {
+		d, err := time.ParseDuration(v)
+		if err == nil {
+			return d
+		}
+	}
+	return fallback
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"expvar"
+	"log"
+	"net/http"
+)
+
+// Notifier metrics, served as JSON on METRICS_ADDR/debug/vars
+var (
+	metricDeltasConsumed = expvar.NewInt("deltas_consumed")
+	metricDecodeErrors   = expvar.NewInt("decode_errors")
+	metricUsersEvaluated = expvar.NewInt("users_evaluated")
+	metricEvalErrors     = expvar.NewInt("evaluate_errors")
+	metricBaselines      = expvar.NewInt("baselines")             // first ranking of a user, nothing to diff
+	metricNoRanking      = expvar.NewInt("users_without_ranking") // deleted or unknown to the api-server
+	metricNotifications  = expvar.NewMap("notifications")         // by kind
+	metricEmitErrors     = expvar.NewMap("emit_errors")           // by emitter
+	metricCommitErrors   = expvar.NewInt("commit_errors")
+)
+
+// startMetricsServer exposes expvar metrics over HTTP
+func startMetricsServer(addr string) {
+	go func() {
+		log.Printf("Metrics on http://%s/debug/vars", addr)
+		if err := http.ListenAndServe(addr, nil); err != nil {
+			log.Printf("Metrics server error: %v", err)
+		}
+	}()
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/system-design-lab/pkg/storage"
+	"github.com/system-design-lab/pkg/topkclient"
+)
+
+// errNoRanking is a user without a top-K to diff: deleted, or unknown to
+// the api-server. Nothing is notified or saved.
+var errNoRanking = errors.New("no ranking")
+
+// rankingReader reads a user's current top-K: cassandraRanking sums the
+// daily counters, apiRanking asks the api-server
+type rankingReader interface {
+	Ranking(ctx context.Context, userID string) ([]RankedSong, error)
+}
+
+// cassandraRanking sums the window's user_daily_topk counters
+type cassandraRanking struct {
+	topk       *storage.DailyTopKRepo
+	windowDays int
+	k          int
+}
+
+func (r cassandraRanking) Ranking(ctx context.Context, userID string) ([]RankedSong, error) {
+	counts, err := r.topk.SumCounts(ctx, userID, storage.LastDays(r.windowDays))
+	if err != nil {
+		return nil, fmt.Errorf("read counts: %w", err)
+	}
+	return rank(counts, r.k), nil
+}
+
+// apiRanking reads GET /users/{id}/topk through pkg/topkclient
+// (TOPK_API_URL), which leaves out taken-down songs and deleted users and
+// revalidates the ranking it last saw with its ETag
+type apiRanking struct {
+	client     *topkclient.Client
+	windowDays int
+	k          int
+}
+
+func (r apiRanking) Ranking(ctx context.Context, userID string) ([]RankedSong, error) {
+	top, err := r.client.TopK(ctx, userID, topkclient.TopKQuery{Days: r.windowDays, K: r.k})
+	if topkclient.IsNotFound(err) {
+		return nil, errNoRanking
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read top-K: %w", err)
+	}
+	// Ranked by count, ties by song ID, as rank does
+	ranking := make([]RankedSong, 0, len(top.Results))
+	for _, s := range top.Results {
+		ranking = append(ranking, RankedSong{SongID: s.SongID, Count: s.ListenCount})
+	}
+	return ranking, nil
+}
@@ -0,0 +1,355 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hibiken/asynq"
+	"github.com/segmentio/kafka-go"
+	"github.com/system-design-lab/pkg/kafkautil"
+)
+
+// GroupTopic is a consumer group to report lag for
+type GroupTopic struct {
+	Topic string
+	Group string
+}
+
+// Target is a service whose expvar endpoint is scraped
+type Target struct {
+	Name string
+	URL  string
+}
+
+// Status is one snapshot of the whole pipeline, served on /status
+type Status struct {
+	CollectedAt time.Time       `json:"collected_at"`
+	Healthy     bool            `json:"healthy"`
+	Problems    []string        `json:"problems"`
+	Lag         []GroupLag      `json:"consumer_lag"`
+	Queues      []QueueDepth    `json:"queues"`
+	QueueError  string          `json:"queue_error,omitempty"`
+	Services    []ServiceStatus `json:"services"`
+}
+
+// GroupLag is a consumer group's backlog on a topic
+type GroupLag struct {
+	Topic      string        `json:"topic"`
+	Group      string        `json:"group"`
+	Total      int64         `json:"total"`
+	Partitions map[int]int64 `json:"partitions,omitempty"`
+	Error      string        `json:"error,omitempty"`
+}
+
+// QueueDepth is an asynq queue's task counts
+type QueueDepth struct {
+	Queue          string `json:"queue"`
+	Size           int    `json:"size"`
+	Pending        int    `json:"pending"`
+	Active         int    `json:"active"`
+	Scheduled      int    `json:"scheduled"`
+	Retry          int    `json:"retry"`
+	Archived       int    `json:"archived"`
+	ProcessedToday int    `json:"processed_today"`
+	FailedToday    int    `json:"failed_today"`
+	LatencyMs      int64  `json:"latency_ms"` // age of the oldest pending task
+	Paused         bool   `json:"paused"`
+}
+
+// ServiceStatus is a scraped service's expvar metrics plus the summaries
+// derived from them
+type ServiceStatus struct {
+	Name    string                     `json:"name"`
+	URL     string                     `json:"url"`
+	Up      bool                       `json:"up"`
+	Error   string                     `json:"error,omitempty"`
+	Flush   *FlushStats                `json:"flush,omitempty"`
+	Cache   *CacheStats                `json:"cache,omitempty"`
+	Metrics map[string]json.RawMessage `json:"metrics,omitempty"`
+}
+
+// FlushStats summarises the aggregator's flush metrics
+type FlushStats struct {
+	Flushes        int64     `json:"flushes"`
+	Errors         int64     `json:"errors"`
+	CommitErrors   int64     `json:"commit_errors"`
+	LastAggregates int64     `json:"last_aggregates"`
+	LastFlush      time.Time `json:"last_flush"`
+	LastFlushMs    int64     `json:"last_flush_ms"`
+}
+
+// CacheStats summarises the api-server's cache metrics
+type CacheStats struct {
+	Hits    int64   `json:"hits"`
+	Misses  int64   `json:"misses"`
+	Errors  int64   `json:"errors"`
+	HitRate float64 `json:"hit_rate"`
+
+	Keys      int64 `json:"keys"`
+	MaxKeys   int64 `json:"max_keys"`
+	Evictions int64 `json:"evictions"`
+}
+
+// Collector periodically gathers a Status from Kafka, asynq and the
+// services' metrics endpoints
+type Collector struct {
+	kafka     *kafka.Client
+	groups    []GroupTopic
+	inspector *asynq.Inspector
+	targets   []Target
+	http      *http.Client
+	lagWarn   int64         // lag above this marks the pipeline unhealthy
+	stale     time.Duration // no flush for this long marks it unhealthy
+
+	mu     sync.RWMutex
+	status Status
+}
+
+// Run collects every interval until ctx is cancelled
+func (c *Collector) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		c.collect(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Status returns the latest snapshot
+func (c *Collector) Status() Status {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.status
+}
+
+func (c *Collector) collect(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	st := Status{CollectedAt: time.Now()}
+	var wg sync.WaitGroup
+	wg.Add(3)
+	go func() {
+		defer wg.Done()
+		st.Lag = c.collectLag(ctx)
+	}()
+	go func() {
+		defer wg.Done()
+		queues, err := c.collectQueues()
+		st.Queues = queues
+		if err != nil {
+			st.QueueError = err.Error()
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		st.Services = c.collectServices(ctx)
+	}()
+	wg.Wait()
+
+	st.Problems = c.problems(st)
+	st.Healthy = len(st.Problems) == 0
+
+	c.mu.Lock()
+	prev := c.status
+	c.status = st
+	c.mu.Unlock()
+
+	// Log transitions only, not every refresh
+	now, before := strings.Join(st.Problems, "; "), strings.Join(prev.Problems, "; ")
+	switch {
+	case now != before && !st.Healthy:
+		log.Printf("Pipeline unhealthy: %s", now)
+	case st.Healthy && !prev.Healthy && !prev.CollectedAt.IsZero():
+		log.Println("Pipeline healthy again")
+	}
+}
+
+func (c *Collector) collectLag(ctx context.Context) []GroupLag {
+	out := make([]GroupLag, len(c.groups))
+	for i, g := range c.groups {
+		out[i] = GroupLag{Topic: g.Topic, Group: g.Group}
+		lag, err := kafkautil.GroupLag(ctx, c.kafka, g.Topic, g.Group)
+		if err != nil {
+			out[i].Error = err.Error()
+			continue
+		}
+		out[i].Total = lag.Total
+		out[i].Partitions = lag.Partitions
+	}
+	return out
+}
+
+func (c *Collector) collectQueues() ([]QueueDepth, error) {
+	names, err := c.inspector.Queues()
+	if err != nil {
+		return nil, fmt.Errorf("list queues: %w", err)
+	}
+	sort.Strings(names)
+	var out []QueueDepth
+	for _, name := range names {
+		info, err := c.inspector.GetQueueInfo(name)
+		if err != nil {
+			return out, fmt.Errorf("queue %s: %w", name, err)
+		}
+		out = append(out, QueueDepth{
+			Queue:          info.Queue,
+			Size:           info.Size,
+			Pending:        info.Pending,
+			Active:         info.Active,
+			Scheduled:      info.Scheduled,
+			Retry:          info.Retry,
+			Archived:       info.Archived,
+			ProcessedToday: info.Processed,
+			FailedToday:    info.Failed,
+			LatencyMs:      info.Latency.Milliseconds(),
+			Paused:         info.Paused,
+		})
+	}
+	return out, nil
+}
+
+func (c *Collector) collectServices(ctx context.Context) []ServiceStatus {
+	out := make([]ServiceStatus, len(c.targets))
+	var wg sync.WaitGroup
+	for i, t := range c.targets {
+		wg.Add(1)
+		go func(i int, t Target) {
+			defer wg.Done()
+			out[i] = c.scrape(ctx, t)
+		}(i, t)
+	}
+	wg.Wait()
+	return out
+}
+
+// scrape fetches a service's /debug/vars. The Go runtime's memstats and
+// cmdline are dropped to keep the status readable.
+func (c *Collector) scrape(ctx context.Context, t Target) ServiceStatus {
+	s := ServiceStatus{Name: t.Name, URL: t.URL}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, t.URL, nil)
+	if err != nil {
+		s.Error = err.Error()
+		return s
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		s.Error = err.Error()
+		return s
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		s.Error = resp.Status
+		return s
+	}
+
+	var vars map[string]json.RawMessage
+	if err := json.NewDecoder(resp.Body).Decode(&vars); err != nil {
+		s.Error = fmt.Sprintf("decode metrics: %v", err)
+		return s
+	}
+	delete(vars, "memstats")
+	delete(vars, "cmdline")
+	s.Up = true
+	s.Metrics = vars
+
+	if _, ok := vars["flushes"]; ok {
+		s.Flush = &FlushStats{
+			Flushes:        metricInt(vars, "flushes"),
+			Errors:         metricInt(vars, "flush_errors"),
+			CommitErrors:   metricInt(vars, "commit_errors"),
+			LastAggregates: metricInt(vars, "last_flush_aggregates"),
+			LastFlushMs:    metricInt(vars, "last_flush_ms"),
+		}
+		if unix := metricInt(vars, "last_flush_unix"); unix > 0 {
+			s.Flush.LastFlush = time.Unix(unix, 0)
+		}
+	}
+	if _, ok := vars["cache_hits"]; ok {
+		s.Cache = &CacheStats{
+			Hits:   metricInt(vars, "cache_hits"),
+			Misses: metricInt(vars, "cache_misses"),
+			Errors: metricInt(vars, "cache_errors"),
+
+			Keys:      metricInt(vars, "cache_keys"),
+			MaxKeys:   metricInt(vars, "cache_max_keys"),
+			Evictions: metricInt(vars, "cache_evictions"),
+		}
+		if total := s.Cache.Hits + s.Cache.Misses; total > 0 {
+			s.Cache.HitRate = float64(s.Cache.Hits) / float64(total)
+		}
+	}
+	return s
+}
+
+// problems lists what makes a status unhealthy
+func (c *Collector) problems(st Status) []string {
+	var out []string
+	for _, l := range st.Lag {
+		switch {
+		case l.Error != "":
+			out = append(out, fmt.Sprintf("lag %s/%s: %s", l.Topic, l.Group, l.Error))
+		case c.lagWarn > 0 && l.Total > c.lagWarn:
+			out = append(out, fmt.Sprintf("group %s is %d messages behind on %s", l.Group, l.Total, l.Topic))
+		}
+	}
+	if st.QueueError != "" {
+		out = append(out, "asynq: "+st.QueueError)
+	}
+	for _, s := range st.Services {
+		if !s.Up {
+			out = append(out, fmt.Sprintf("%s down: %s", s.Name, s.Error))
+			continue
+		}
+		if s.Flush != nil && !s.Flush.LastFlush.IsZero() && c.stale > 0 && st.CollectedAt.Sub(s.Flush.LastFlush) > c.stale {
+			out = append(out, fmt.Sprintf("%s has not flushed since %s", s.Name, s.Flush.LastFlush.Format(time.RFC3339)))
+		}
+	}
+	return out
+}
+
+// metricInt reads an integer expvar, 0 if missing or not a number
+func metricInt(vars map[string]json.RawMessage, key string) int64 {
+	var v float64
+	if err := json.Unmarshal(vars[key], &v); err != nil {
+		return 0
+	}
+	return int64(v)
+}
+
+// parseGroups parses "topic:group,topic:group"
+func parseGroups(s string) ([]GroupTopic, error) {
+	var out []GroupTopic
+	for _, item := range splitList(s) {
+		topic, group, ok := strings.Cut(item, ":")
+		if !ok || topic == "" || group == "" {
+			return nil, fmt.Errorf("%q is not topic:group", item)
+		}
+		out = append(out, GroupTopic{Topic: topic, Group: group})
+	}
+	return out, nil
+}
+
+// parseTargets parses "name=url,name=url"
+func parseTargets(s string) ([]Target, error) {
+	var out []Target
+	for _, item := range splitList(s) {
+		name, url, ok := strings.Cut(item, "=")
+		if !ok || name == "" || url == "" {
+			return nil, fmt.Errorf("%q is not name=url", item)
+		}
+		out = append(out, Target{Name: name, URL: url})
+	}
+	return out, nil
+}
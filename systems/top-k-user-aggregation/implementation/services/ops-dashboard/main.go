@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/hibiken/asynq"
+	"github.com/system-design-lab/pkg/kafkautil"
+	"github.com/system-design-lab/pkg/runtimecfg"
+	"github.com/system-design-lab/pkg/topkclient"
+	"github.com/system-design-lab/pkg/topology"
+)
+
+func main() {
+	topo, err := topology.Apply("ops-dashboard")
+	if err != nil {
+		log.Fatalf("Invalid pipeline config: %v", err)
+	}
+
+	port := getEnv("PORT", "8090")
+	redisAddr := getEnv("REDIS_ADDR", "localhost:6379")
+	refresh := getEnvDuration("REFRESH_INTERVAL", 10*time.Second)
+	firehoseURL := getEnv("FIREHOSE_URL", "ws://localhost:9111/ws")
+	if firehoseURL == "off" {
+		firehoseURL = ""
+	}
+
+	// Every group and metrics port in the pipeline config, when there is one
+	lagGroups := "user.listen.raw:raw-event-processor,user.listen.raw:aggregator,user.listen.agg:notifier,user.listen.agg:materializer,user.listen.raw:global-charts,user.listen.agg:anomaly-detector,user.listen.agg:compactor,user.listen.raw:verifier"
+	metricsTargets := "raw-event-processor=http://localhost:9102/debug/vars,aggregator=http://localhost:9103/debug/vars,api-server=http://localhost:8080/debug/vars,notifier=http://localhost:9104/debug/vars,materializer=http://localhost:9105/debug/vars,global-charts=http://localhost:9106/debug/vars,anomaly-detector=http://localhost:9107/debug/vars,compactor=http://localhost:9108/debug/vars,verifier=http://localhost:9109/debug/vars,dlq-analyzer=http://localhost:9110/debug/vars,firehose=http://localhost:9111/debug/vars,recommender=http://localhost:9112/debug/vars"
+	if topo != nil {
+		lagGroups, metricsTargets = topo.LagGroups(), topo.MetricsTargets()
+	}
+	groups, err := parseGroups(getEnv("LAG_GROUPS", lagGroups))
+	if err != nil {
+		log.Fatalf("Invalid LAG_GROUPS: %v", err)
+	}
+	targets, err := parseTargets(getEnv("METRICS_TARGETS", metricsTargets))
+	if err != nil {
+		log.Fatalf("Invalid METRICS_TARGETS: %v", err)
+	}
+
+	kafkaCfg, err := kafkautil.ConfigFromEnv("localhost:29092")
+	if err != nil {
+		log.Fatalf("Invalid Kafka config: %v", err)
+	}
+	apiCfg, err := topkclient.ConfigFromEnv("http://localhost:8080")
+	if err != nil {
+		log.Fatalf("Invalid api-server config: %v", err)
+	}
+
+	log.Printf("Starting ops-dashboard: kafka=%v redis=%s port=%s refresh=%s groups=%d targets=%d api=%s",
+		kafkaCfg.Brokers, redisAddr, port, refresh, len(groups), len(targets), apiCfg.BaseURL)
+
+	inspector := asynq.NewInspector(asynq.RedisClientOpt{Addr: redisAddr})
+	defer inspector.Close()
+
+	c := &Collector{
+		kafka:     kafkaCfg.Client(),
+		groups:    groups,
+		inspector: inspector,
+		targets:   targets,
+		http:      &http.Client{Timeout: 5 * time.Second},
+		lagWarn:   int64(getEnvInt("LAG_WARN", 100000)),
+		stale:     getEnvDuration("FLUSH_STALE_AFTER", 5*time.Minute),
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-sigChan
+		log.Println("Shutting down...")
+		cancel()
+	}()
+
+	// log_level and SIGHUP reloads of pipeline.yaml's settings (pkg/runtimecfg)
+	if err := runtimecfg.New(nil, "ops-dashboard").Start(ctx); err != nil {
+		log.Printf("Warning: runtime config not loaded: %v", err)
+	}
+
+	// Collect in the background so page loads never hit Kafka or Redis
+	go c.Run(ctx, refresh)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(c.Status())
+	})
+	ui := &adminUI{collector: c, api: topkclient.New(apiCfg), firehoseURL: firehoseURL, refresh: refresh}
+	ui.register(mux)
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := page.Execute(w, c.Status()); err != nil {
+			log.Printf("Error rendering page: %v", err)
+		}
+	})
+
+	srv := &http.Server{Addr: ":" + port, Handler: mux}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdownCtx)
+	}()
+
+	log.Printf("Listening on :%s", port)
+	if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		log.Fatalf("Server error: %v", err)
+	}
+	log.Println("Shutdown complete")
+}
+
+func getEnv(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
+
+func getEnvInt(key string, fallback int) int {
+	if v := os.Getenv(key); v != "" {
+		i, err := strconv.Atoi(v)
+		if err == nil {
+			return i
+		}
+	}
+	return fallback
+}
+
+func getEnvDuration(key string, fallback time.Duration) time.Duration {
+	if v := os.Getenv(key); v != "" {
+		d, err := time.ParseDuration(v)
+		if err == nil {
+			return d
+		}
+	}
+	return fallback
+}
+
+// splitList splits a comma-separated list, dropping empty entries
+func splitList(s string) []string {
+	var out []string
+	for _, part := range strings.Split(s, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			out = append(out, part)
+		}
+	}
+	return out
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"time"
+)
+
+// funcs are the helpers of the status page and the admin UI
+var funcs = template.FuncMap{
+	"ago": func(t time.Time) string {
+		if t.IsZero() {
+			return "never"
+		}
+		return time.Since(t).Round(time.Second).String() + " ago"
+	},
+	"pct": func(f float64) string {
+		return fmt.Sprintf("%.1f%%", f*100)
+	},
+}
+
+var page = template.Must(template.New("page").Funcs(funcs).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta http-equiv="refresh" content="10">
+<title>Top-K pipeline status</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; margin-bottom: 1.5em; }
+th, td { border: 1px solid #ccc; padding: 4px 10px; text-align: left; }
+th { background: #f4f4f4; }
+.ok { color: #2a7d2a; }
+.bad { color: #c0392b; }
+</style>
+</head>
+<body>
+<h1>Top-K pipeline status</h1>
+{{if .CollectedAt.IsZero}}
+<p>Collecting&hellip;</p>
+{{else}}
+<p>Collected {{ago .CollectedAt}} &middot; <a href="/status">JSON</a> &middot; <a href="/ui/">Admin UI</a></p>
+{{if .Healthy}}<p class="ok"><b>Healthy</b></p>{{else}}
+<p class="bad"><b>Unhealthy</b></p>
+<ul>{{range .Problems}}<li class="bad">{{.}}</li>{{end}}</ul>
+{{end}}
+
+<h2>Consumer lag</h2>
+<table>
+<tr><th>Topic</th><th>Group</th><th>Lag</th><th>Partitions</th></tr>
+{{range .Lag}}<tr><td>{{.Topic}}</td><td>{{.Group}}</td>
+{{if .Error}}<td colspan="2" class="bad">{{.Error}}</td>{{else}}<td>{{.Total}}</td><td>{{len .Partitions}}</td>{{end}}</tr>
+{{end}}</table>
+
+<h2>Asynq queues</h2>
+{{if .QueueError}}<p class="bad">{{.QueueError}}</p>{{end}}
+<table>
+<tr><th>Queue</th><th>Size</th><th>Pending</th><th>Active</th><th>Scheduled</th><th>Retry</th><th>Archived</th><th>Processed today</th><th>Failed today</th><th>Latency</th></tr>
+{{range .Queues}}<tr><td>{{.Queue}}{{if .Paused}} (paused){{end}}</td><td>{{.Size}}</td><td>{{.Pending}}</td><td>{{.Active}}</td><td>{{.Scheduled}}</td><td>{{.Retry}}</td><td>{{.Archived}}</td><td>{{.ProcessedToday}}</td><td>{{.FailedToday}}</td><td>{{.LatencyMs}}ms</td></tr>
+{{end}}</table>
+
+<h2>Services</h2>
+<table>
+<tr><th>Service</th><th>Status</th><th>Summary</th></tr>
+{{range .Services}}<tr><td><a href="{{.URL}}">{{.Name}}</a></td>
+{{if .Up}}<td class="ok">up</td>{{else}}<td class="bad">down: {{.Error}}</td>{{end}}
+<td>{{with .Flush}}flushes={{.Flushes}} last={{ago .LastFlush}} ({{.LastAggregates}} aggregates, {{.LastFlushMs}}ms) errors={{.Errors}} commit_errors={{.CommitErrors}}{{end}}
+{{with .Cache}}cache hit rate={{pct .HitRate}} hits={{.Hits}} misses={{.Misses}} errors={{.Errors}} keys={{.Keys}}/{{.MaxKeys}} evictions={{.Evictions}}{{end}}</td></tr>
+{{end}}</table>
+{{end}}
+</body>
+</html>
+`))
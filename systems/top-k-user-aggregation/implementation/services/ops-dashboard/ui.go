@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"embed"
+	"html/template"
+	"io/fs"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/system-design-lab/pkg/topkclient"
+)
+
+//go:embed ui
+var uiFiles embed.FS
+
+var uiTemplates = template.Must(template.New("ui").Funcs(funcs).Funcs(template.FuncMap{
+	"ms": func(ms int64) string {
+		return (time.Duration(ms) * time.Millisecond).Round(time.Second).String()
+	},
+}).ParseFS(uiFiles, "ui/*.html"))
+
+// adminUI serves /ui/: a user's top-K from the api-server, the collector's
+// lag and queue snapshot, and recent events pushed by the firehose straight
+// to the browser, so a demo needs nothing but a browser
+type adminUI struct {
+	collector   *Collector
+	api         *topkclient.Client
+	firehoseURL string        // as the browser reaches it; empty hides the panel
+	refresh     time.Duration // of the live panels
+}
+
+// uiPage is what index.html renders
+type uiPage struct {
+	Status
+
+	UserID   string
+	Days     int
+	K        int
+	RankBy   string
+	TopK     *topkclient.TopKResponse
+	TopKNote string // why there is no list
+
+	FirehoseURL string
+	RefreshMs   int64
+}
+
+func (u *adminUI) register(mux *http.ServeMux) {
+	static, _ := fs.Sub(uiFiles, "ui/static")
+	mux.Handle("/ui/static/", http.StripPrefix("/ui/static/", http.FileServer(http.FS(static))))
+	mux.HandleFunc("/ui/live", u.live)
+	mux.HandleFunc("/ui/", u.index)
+}
+
+// index renders the whole page, with the top-K of ?user= when given
+func (u *adminUI) index(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/ui/" {
+		http.NotFound(w, r)
+		return
+	}
+	q := r.URL.Query()
+	p := uiPage{
+		Status:      u.collector.Status(),
+		UserID:      q.Get("user"),
+		Days:        formInt(q.Get("days"), 7),
+		K:           formInt(q.Get("k"), 10),
+		RankBy:      q.Get("rank_by"),
+		FirehoseURL: u.firehoseURL,
+		RefreshMs:   u.refresh.Milliseconds(),
+	}
+	if p.RankBy == "" {
+		p.RankBy = "count"
+	}
+	if p.UserID != "" {
+		ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+		defer cancel()
+		top, err := u.api.TopK(ctx, p.UserID, topkclient.TopKQuery{Days: p.Days, K: p.K, RankBy: p.RankBy})
+		switch {
+		case err == nil && len(top.Results) == 0:
+			p.TopKNote = "No listens in the window."
+		case err == nil:
+			p.TopK = top
+		case topkclient.IsUserDeleted(err):
+			p.TopKNote = "This user is deleted."
+		case topkclient.IsNotFound(err):
+			p.TopKNote = "Unknown user."
+		default:
+			p.TopKNote = "The api-server didn't answer: " + err.Error()
+			log.Printf("Warning: admin UI top-K of %s failed: %v", p.UserID, err)
+		}
+	}
+	u.render(w, "index.html", p)
+}
+
+// live renders the lag and queue panels alone, which the page reloads
+// every refresh instead of reloading itself (and dropping the firehose)
+func (u *adminUI) live(w http.ResponseWriter, r *http.Request) {
+	u.render(w, "live", u.collector.Status())
+}
+
+func (u *adminUI) render(w http.ResponseWriter, name string, data any) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := uiTemplates.ExecuteTemplate(w, name, data); err != nil {
+		log.Printf("Error rendering %s: %v", name, err)
+	}
+}
+
+// formInt parses a form value, falling back to def when empty or invalid
+func formInt(s string, def int) int {
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 1 {
+		return def
+	}
+	return n
+}
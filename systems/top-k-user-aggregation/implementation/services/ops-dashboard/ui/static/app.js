@@ -0,0 +1,70 @@
+// Admin UI: reloads the pipeline panel every refresh and shows the events
+// the firehose pushes, newest first, filtered to the selected user.
+(function () {
+  "use strict";
+
+  var body = document.body;
+  var refreshMs = parseInt(body.dataset.refreshMs, 10) || 10000;
+  var maxEvents = 50;
+
+  // Pipeline panel: the server renders it, the page only swaps it in
+  setInterval(function () {
+    fetch("/ui/live")
+      .then(function (resp) {
+        if (!resp.ok) throw new Error(resp.status);
+        return resp.text();
+      })
+      .then(function (html) {
+        document.getElementById("live").innerHTML = html;
+      })
+      .catch(function () { /* keep the last panel; the next tick retries */ });
+  }, refreshMs);
+
+  var firehose = body.dataset.firehose;
+  if (!firehose) return;
+  var rows = document.getElementById("events");
+  var state = document.getElementById("firehose-state");
+  var url = firehose + (body.dataset.user ? "?users=" + encodeURIComponent(body.dataset.user) : "");
+  var backoff = 1000;
+
+  function cell(tr, text) {
+    var td = document.createElement("td");
+    td.textContent = text;
+    tr.appendChild(td);
+  }
+
+  function connect() {
+    var ws = new WebSocket(url);
+    ws.onopen = function () {
+      backoff = 1000;
+      state.textContent = "Live from " + firehose + (body.dataset.user ? " (this user only)" : "");
+    };
+    ws.onmessage = function (msg) {
+      var ev = JSON.parse(msg.data);
+      var tr = document.createElement("tr");
+      if (ev.type === "dropped") {
+        var td = document.createElement("td");
+        td.colSpan = 5;
+        td.className = "muted";
+        td.textContent = ev.count + " events dropped (too many to show)";
+        tr.appendChild(td);
+      } else if (ev.type === "listen") {
+        cell(tr, new Date(ev.listened_at * 1000).toISOString().replace("T", " ").slice(0, 19));
+        cell(tr, ev.user_id);
+        cell(tr, ev.song_id);
+        cell(tr, ev.artist_id || "");
+        cell(tr, ev.provider);
+      } else {
+        return;
+      }
+      rows.insertBefore(tr, rows.firstChild);
+      while (rows.children.length > maxEvents) rows.removeChild(rows.lastChild);
+    };
+    ws.onclose = function () {
+      state.textContent = "Disconnected from " + firehose + ", retrying in " + backoff / 1000 + "s";
+      setTimeout(connect, backoff);
+      backoff = Math.min(backoff * 2, 30000);
+    };
+  }
+  connect();
+})();
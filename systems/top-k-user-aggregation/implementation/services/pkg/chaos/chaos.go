@@ -0,0 +1,161 @@
+// Package chaos injects faults for lab experiments: artificial latency,
+// errors and silently dropped writes at named points in the shared clients.
+// Everything is off unless CHAOS_ENABLED=true.
+//
+// Each point is configured with CHAOS_<POINT>_<KNOB>, e.g.
+//
+//	CHAOS_ENABLED=true
+//	CHAOS_CASSANDRA_WRITE_DROP_PROB=0.01    1% of writes report success but aren't sent
+//	CHAOS_REDIS_ERROR_PROB=0.05             5% of Redis commands fail
+//	CHAOS_KAFKA_COMMIT_ERROR_PROB=0.2       20% of offset commits fail
+//	CHAOS_CASSANDRA_READ_LATENCY=200ms      extra latency...
+//	CHAOS_CASSANDRA_READ_LATENCY_PROB=0.5   ...on half the reads
+package chaos
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
+	"log"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Point is a place where faults can be injected
+type Point string
+
+const (
+	CassandraWrite Point = "CASSANDRA_WRITE"
+	CassandraRead  Point = "CASSANDRA_READ"
+	Redis          Point = "REDIS"
+	KafkaCommit    Point = "KAFKA_COMMIT"
+	ProviderFetch  Point = "PROVIDER_FETCH"
+)
+
+var allPoints = []Point{CassandraWrite, CassandraRead, Redis, KafkaCommit, ProviderFetch}
+
+// ErrInjected is returned (wrapped) by every injected failure
+var ErrInjected = errors.New("chaos: injected fault")
+
+// metricInjected counts faults by "<point>.<kind>"
+var metricInjected = expvar.NewMap("chaos_injected")
+
+// Fault is the configuration of one point
+type Fault struct {
+	Latency     time.Duration
+	LatencyProb float64
+	ErrorProb   float64
+	DropProb    float64 // only meaningful for writes
+}
+
+func (f Fault) active() bool {
+	return (f.Latency > 0 && f.LatencyProb > 0) || f.ErrorProb > 0 || f.DropProb > 0
+}
+
+var (
+	loadOnce sync.Once
+	faults   map[Point]Fault
+	rngMu    sync.Mutex
+	rng      = rand.New(rand.NewSource(time.Now().UnixNano()))
+)
+
+func load() {
+	faults = make(map[Point]Fault)
+	if v, _ := strconv.ParseBool(os.Getenv("CHAOS_ENABLED")); !v {
+		return
+	}
+	for _, p := range allPoints {
+		prefix := "CHAOS_" + string(p) + "_"
+		f := Fault{
+			Latency:     envDuration(prefix + "LATENCY"),
+			LatencyProb: envProb(prefix + "LATENCY_PROB"),
+			ErrorProb:   envProb(prefix + "ERROR_PROB"),
+			DropProb:    envProb(prefix + "DROP_PROB"),
+		}
+		if f.Latency > 0 && f.LatencyProb == 0 {
+			f.LatencyProb = 1 // a latency without a probability means always
+		}
+		if f.active() {
+			faults[p] = f
+			log.Printf("WARNING: chaos enabled at %s: latency=%s@%.2f error=%.2f drop=%.2f",
+				p, f.Latency, f.LatencyProb, f.ErrorProb, f.DropProb)
+		}
+	}
+}
+
+// Enabled reports whether any fault is configured
+func Enabled() bool {
+	loadOnce.Do(load)
+	return len(faults) > 0
+}
+
+func roll(prob float64) bool {
+	if prob <= 0 {
+		return false
+	}
+	rngMu.Lock()
+	defer rngMu.Unlock()
+	return rng.Float64() < prob
+}
+
+// Inject applies latency and error faults for p. It returns a wrapped
+// ErrInjected if the operation should fail, or ctx's error if ctx ends
+// during the injected delay.
+func Inject(ctx context.Context, p Point) error {
+	loadOnce.Do(load)
+	f, ok := faults[p]
+	if !ok {
+		return nil
+	}
+	if f.Latency > 0 && roll(f.LatencyProb) {
+		metricInjected.Add(string(p)+".latency", 1)
+		t := time.NewTimer(f.Latency)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+	if roll(f.ErrorProb) {
+		metricInjected.Add(string(p)+".error", 1)
+		return fmt.Errorf("%w (%s)", ErrInjected, strings.ToLower(string(p)))
+	}
+	return nil
+}
+
+// Drop reports whether a write at p should be skipped while reporting
+// success: the "acknowledged but lost" failure mode
+func Drop(p Point) bool {
+	loadOnce.Do(load)
+	f, ok := faults[p]
+	if !ok || !roll(f.DropProb) {
+		return false
+	}
+	metricInjected.Add(string(p)+".drop", 1)
+	return true
+}
+
+func envProb(key string) float64 {
+	v, err := strconv.ParseFloat(os.Getenv(key), 64)
+	if err != nil || v < 0 {
+		return 0
+	}
+	if v > 1 {
+		return 1
+	}
+	return v
+}
+
+func envDuration(key string) time.Duration {
+	d, err := time.ParseDuration(os.Getenv(key))
+	if err != nil {
+		return 0
+	}
+	return d
+}
@@ -0,0 +1,53 @@
+// Package dc holds the datacenter identity shared by the storage and Kafka
+// clients, so one set of environment variables can run the same service in
+// several datacenters: LOCAL_DC names the datacenter, and any per-DC
+// setting can be overridden with a _<DC> suffixed variable.
+package dc
+
+import (
+	"os"
+	"strings"
+)
+
+// Local returns LOCAL_DC, the datacenter this process runs in ("" = a
+// single-datacenter deployment)
+func Local() string {
+	return os.Getenv("LOCAL_DC")
+}
+
+// Env returns key's value for the local datacenter: KEY_<DC> (e.g.
+// CASSANDRA_HOSTS_DC2 with LOCAL_DC=dc2) if set, else KEY, else fallback
+func Env(key, fallback string) string {
+	if local := Local(); local != "" {
+		if v := os.Getenv(key + "_" + envSuffix(local)); v != "" {
+			return v
+		}
+	}
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
+
+// Prefix returns "<dc>:" for keys in stores shared between datacenters, or
+// "" when LOCAL_DC is unset so single-DC keys keep their names
+func Prefix() string {
+	if local := Local(); local != "" {
+		return local + ":"
+	}
+	return ""
+}
+
+// envSuffix upper-cases a DC name and maps characters that can't appear in
+// variable names to _ (us-east-1 -> US_EAST_1)
+func envSuffix(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_':
+			return r
+		}
+		return '_'
+	}, name)
+}
@@ -0,0 +1,156 @@
+package events
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// AvroSchema is the writer schema of FormatAvro, in Parsing Canonical Form
+// (the bytes its fingerprint is taken over). Optional fields are empty
+// strings and zero longs rather than unions, as in the protobuf encoding.
+// Adding a field means a new schema, with its own fingerprint, next to this
+// one in avroSchemas: events already written keep decoding.
+const AvroSchema = `{"name":"topk.events.ListenEvent","type":"record","fields":[` +
+	`{"name":"schema_version","type":"int"},` +
+	`{"name":"event_id","type":"string"},` +
+	`{"name":"user_id","type":"string"},` +
+	`{"name":"song_id","type":"string"},` +
+	`{"name":"provider","type":"string"},` +
+	`{"name":"listened_at","type":"long"},` +
+	`{"name":"source","type":"string"},` +
+	`{"name":"context","type":"string"},` +
+	`{"name":"artist_id","type":"string"},` +
+	`{"name":"duration_ms","type":"long"},` +
+	`{"name":"experiment","type":"string"}]}`
+
+// avroMagic starts Avro's single-object encoding, followed by the writer
+// schema's 8-byte CRC-64-AVRO fingerprint (little-endian) and the binary
+// record. Unmarshal tells it from JSON ('{') and from protobuf by it: as a
+// protobuf tag, 0xC3 0x01 is a group (wire type 3) on field 24, which
+// marshalProto never writes.
+var avroMagic = []byte{0xC3, 0x01}
+
+// AvroFingerprint is the CRC-64-AVRO fingerprint of AvroSchema, written in
+// every FormatAvro event so readers can pick the schema without a registry
+var AvroFingerprint = avroCRC64([]byte(AvroSchema))
+
+// avroSchemas are the writer schemas Unmarshal reads, by fingerprint
+var avroSchemas = map[uint64]func(b []byte) (ListenEvent, error){
+	AvroFingerprint: unmarshalAvroRecord,
+}
+
+// avroCRC64Empty is the Rabin fingerprint of the empty input, from the Avro spec
+const avroCRC64Empty = 0xc15d213aa4d7a795
+
+var avroCRC64Table = func() (t [256]uint64) {
+	for i := range t {
+		fp := uint64(i)
+		for range 8 {
+			fp = fp>>1 ^ (avroCRC64Empty & -(fp & 1))
+		}
+		t[i] = fp
+	}
+	return t
+}()
+
+func avroCRC64(data []byte) uint64 {
+	fp := uint64(avroCRC64Empty)
+	for _, b := range data {
+		fp = fp>>8 ^ avroCRC64Table[byte(fp)^b]
+	}
+	return fp
+}
+
+// Avro ints and longs are zigzag varints, as encoding/binary writes them;
+// strings are a long length and the bytes
+func marshalAvro(e ListenEvent) []byte {
+	b := append([]byte(nil), avroMagic...)
+	b = binary.LittleEndian.AppendUint64(b, AvroFingerprint)
+	b = binary.AppendVarint(b, int64(e.SchemaVersion))
+	for _, s := range []string{e.EventID, e.UserID, e.SongID, e.Provider} {
+		b = appendAvroString(b, s)
+	}
+	b = binary.AppendVarint(b, e.ListenedAt)
+	for _, s := range []string{e.Source, e.Context, e.ArtistID} {
+		b = appendAvroString(b, s)
+	}
+	b = binary.AppendVarint(b, e.DurationMs)
+	return appendAvroString(b, e.Experiment)
+}
+
+func appendAvroString(b []byte, s string) []byte {
+	b = binary.AppendVarint(b, int64(len(s)))
+	return append(b, s...)
+}
+
+// isAvro reports whether data is single-object encoded
+func isAvro(data []byte) bool {
+	return bytes.HasPrefix(data, avroMagic)
+}
+
+func unmarshalAvro(data []byte) (ListenEvent, error) {
+	header := len(avroMagic) + 8
+	if len(data) < header {
+		return ListenEvent{}, errors.New("avro: truncated header")
+	}
+	fp := binary.LittleEndian.Uint64(data[len(avroMagic):header])
+	decode, ok := avroSchemas[fp]
+	if !ok {
+		return ListenEvent{}, fmt.Errorf("avro: unknown writer schema %016x", fp)
+	}
+	return decode(data[header:])
+}
+
+// unmarshalAvroRecord reads a record written with AvroSchema
+func unmarshalAvroRecord(b []byte) (ListenEvent, error) {
+	r := avroReader{b: b}
+	var e ListenEvent
+	e.SchemaVersion = int(r.long())
+	e.EventID, e.UserID, e.SongID, e.Provider = r.string(), r.string(), r.string(), r.string()
+	e.ListenedAt = r.long()
+	e.Source, e.Context, e.ArtistID = r.string(), r.string(), r.string()
+	e.DurationMs = r.long()
+	e.Experiment = r.string()
+	if r.err == nil && len(r.b) > 0 {
+		r.err = fmt.Errorf("%d trailing bytes", len(r.b))
+	}
+	if r.err != nil {
+		return ListenEvent{}, fmt.Errorf("avro: %w", r.err)
+	}
+	return e, nil
+}
+
+// avroReader consumes a binary record, keeping the first error
+type avroReader struct {
+	b   []byte
+	err error
+}
+
+func (r *avroReader) long() int64 {
+	if r.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(r.b)
+	if n <= 0 {
+		r.err = errors.New("bad varint")
+		return 0
+	}
+	r.b = r.b[n:]
+	return v
+}
+
+func (r *avroReader) string() string {
+	n := r.long()
+	if r.err != nil {
+		return ""
+	}
+	if n < 0 || n > int64(len(r.b)) {
+		r.err = fmt.Errorf("string length %d out of range", n)
+		return ""
+	}
+	s := string(r.b[:n])
+	r.b = r.b[n:]
+	return s
+}
@@ -0,0 +1,42 @@
+package events
+
+import (
+	"encoding/json"
+	"errors"
+)
+
+// AggregateDelta is what one aggregator flush added to a user's day, published
+// on user.listen.agg keyed by user_id. Deltas are increments, not totals: a
+// consumer that needs the current counts reads them from Cassandra.
+type AggregateDelta struct {
+	UserID    string      `json:"user_id"`
+	Day       string      `json:"day"` // YYYY-MM-DD
+	Songs     []SongDelta `json:"songs"`
+	FlushedAt int64       `json:"flushed_at"` // unix seconds
+	// Published counts the delta's listens by publish time (unix ms), for
+	// those whose producer stamped it (see pkg/freshness)
+	Published map[int64]int64 `json:"published,omitempty"`
+}
+
+// SongDelta is the count added to one song
+type SongDelta struct {
+	SongID string `json:"song_id"`
+	Delta  int64  `json:"delta"`
+}
+
+// MarshalDelta encodes d as JSON
+func MarshalDelta(d AggregateDelta) ([]byte, error) {
+	return json.Marshal(d)
+}
+
+// UnmarshalDelta decodes and validates a delta
+func UnmarshalDelta(data []byte) (AggregateDelta, error) {
+	var d AggregateDelta
+	if err := json.Unmarshal(data, &d); err != nil {
+		return d, err
+	}
+	if d.UserID == "" || d.Day == "" {
+		return d, errors.New("delta without user_id or day")
+	}
+	return d, nil
+}
@@ -0,0 +1,286 @@
+// Package events defines the events shared between services: the listen
+// event on user.listen.raw, with its versioned wire formats, the
+// aggregator's count deltas on user.listen.agg, its partial aggregates on
+// user.listen.partial and its partition watermarks on user.listen.watermarks.
+//
+// Listen events are JSON, protobuf or Avro. Avro events use the
+// single-object encoding, whose schema fingerprint stands in for a registry.
+package events
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Schema versions. Version 1 is the flat JSON event; version 2 groups the
+// play details (duration_ms, source, context) under "playback". Both decode
+// to the same ListenEvent, so consumers read them side by side while
+// producers move to 2. Events without a schema_version are version 1.
+const (
+	// SchemaVersion is the newest version this package reads and writes
+	SchemaVersion = 2
+	// DefaultVersion is what producers write unless EVENT_SCHEMA_VERSION
+	// says otherwise: the newest version every consumer is known to read
+	DefaultVersion = 1
+)
+
+// metricVersions counts decoded events by schema version, in every consumer
+var metricVersions = expvar.NewMap("events_schema_versions")
+
+// Format is a wire encoding of ListenEvent
+type Format string
+
+const (
+	FormatJSON  Format = "json"
+	FormatProto Format = "proto" // see listen_event.proto
+	FormatAvro  Format = "avro"  // see AvroSchema
+)
+
+// ErrInvalid wraps every validation failure
+var ErrInvalid = errors.New("invalid listen event")
+
+// ListenEvent is one play of a song by a user on a provider
+type ListenEvent struct {
+	SchemaVersion int    `json:"schema_version"`
+	EventID       string `json:"event_id"`
+	UserID        string `json:"user_id"`
+	SongID        string `json:"song_id"`
+	Provider      string `json:"provider"`
+	ListenedAt    int64  `json:"listened_at"`           // unix seconds
+	Source        string `json:"source,omitempty"`      // e.g. playlist, album, radio
+	Context       string `json:"context,omitempty"`     // e.g. playlist/album ID
+	ArtistID      string `json:"artist_id,omitempty"`   // the song's primary artist, if the provider reports it
+	DurationMs    int64  `json:"duration_ms,omitempty"` // time played, if the provider reports it
+	Experiment    string `json:"experiment,omitempty"`  // the experiment arm the listen was served under, e.g. provider-v2-b
+}
+
+// MaxExperimentLen bounds the experiment tag, a clustering key of the
+// experiment counters
+const MaxExperimentLen = 64
+
+// knownFields are the JSON keys of ListenEvent, in any schema version
+var knownFields = map[string]bool{
+	"schema_version": true,
+	"event_id":       true,
+	"user_id":        true,
+	"song_id":        true,
+	"provider":       true,
+	"listened_at":    true,
+	"source":         true,
+	"context":        true,
+	"artist_id":      true,
+	"duration_ms":    true,
+	"experiment":     true,
+	"playback":       true, // version 2
+}
+
+// New returns an event stamped with the default schema version
+func New(eventID, userID, songID, provider string, listenedAt time.Time) ListenEvent {
+	return ListenEvent{
+		SchemaVersion: DefaultVersion,
+		EventID:       eventID,
+		UserID:        userID,
+		SongID:        songID,
+		Provider:      provider,
+		ListenedAt:    listenedAt.Unix(),
+	}
+}
+
+// Time returns listened_at as a time.Time
+func (e ListenEvent) Time() time.Time {
+	return time.Unix(e.ListenedAt, 0)
+}
+
+// Day returns the YYYY-MM-DD day partition of the event, in UTC like the
+// days the read path asks for (storage.DaysEnding)
+func (e ListenEvent) Day() string {
+	return e.Time().UTC().Format("2006-01-02")
+}
+
+// Hour returns the UTC hour (0-23) of listened_at, the hourly counter bucket
+func (e ListenEvent) Hour() int {
+	return e.Time().UTC().Hour()
+}
+
+// ShortPlay reports whether the listen played under minMs: a skip rather
+// than a listen. Events without a duration_ms never are.
+func (e ListenEvent) ShortPlay(minMs int64) bool {
+	return minMs > 0 && e.DurationMs > 0 && e.DurationMs < minMs
+}
+
+// Validate checks required fields and the schema version
+func (e ListenEvent) Validate() error {
+	switch {
+	case e.SchemaVersion < 0 || e.SchemaVersion > SchemaVersion:
+		return fmt.Errorf("%w: unsupported schema_version %d", ErrInvalid, e.SchemaVersion)
+	case e.EventID == "":
+		return fmt.Errorf("%w: missing event_id", ErrInvalid)
+	case e.UserID == "":
+		return fmt.Errorf("%w: missing user_id", ErrInvalid)
+	case e.SongID == "":
+		return fmt.Errorf("%w: missing song_id", ErrInvalid)
+	case e.Provider == "":
+		return fmt.Errorf("%w: missing provider", ErrInvalid)
+	case e.ListenedAt <= 0:
+		return fmt.Errorf("%w: missing listened_at", ErrInvalid)
+	case e.DurationMs < 0:
+		return fmt.Errorf("%w: negative duration_ms", ErrInvalid)
+	case len(e.Experiment) > MaxExperimentLen:
+		return fmt.Errorf("%w: experiment over %d bytes", ErrInvalid, MaxExperimentLen)
+	}
+	return nil
+}
+
+// playback is the "playback" object of version 2 JSON
+type playback struct {
+	DurationMs int64  `json:"duration_ms,omitempty"`
+	Source     string `json:"source,omitempty"`
+	Context    string `json:"context,omitempty"`
+}
+
+// jsonV2 is the version 2 JSON shape of ListenEvent
+type jsonV2 struct {
+	SchemaVersion int       `json:"schema_version"`
+	EventID       string    `json:"event_id"`
+	UserID        string    `json:"user_id"`
+	SongID        string    `json:"song_id"`
+	Provider      string    `json:"provider"`
+	ListenedAt    int64     `json:"listened_at"`
+	ArtistID      string    `json:"artist_id,omitempty"`
+	Experiment    string    `json:"experiment,omitempty"`
+	Playback      *playback `json:"playback,omitempty"`
+}
+
+// Marshal encodes the event in the given format, in the event's schema
+// version (DefaultVersion if unset)
+func Marshal(e ListenEvent, format Format) ([]byte, error) {
+	if e.SchemaVersion == 0 {
+		e.SchemaVersion = DefaultVersion
+	}
+	return MarshalVersion(e, format, e.SchemaVersion)
+}
+
+// MarshalVersion encodes the event in the given format and schema version,
+// whatever version it was decoded from. The protobuf and Avro encodings are
+// the same in every version; only their schema_version differs.
+func MarshalVersion(e ListenEvent, format Format, version int) ([]byte, error) {
+	if version < 1 || version > SchemaVersion {
+		return nil, fmt.Errorf("unknown schema version %d", version)
+	}
+	e.SchemaVersion = version
+	switch format {
+	case FormatJSON, "":
+		if version == 1 {
+			return json.Marshal(e)
+		}
+		v2 := jsonV2{
+			SchemaVersion: e.SchemaVersion,
+			EventID:       e.EventID,
+			UserID:        e.UserID,
+			SongID:        e.SongID,
+			Provider:      e.Provider,
+			ListenedAt:    e.ListenedAt,
+			ArtistID:      e.ArtistID,
+			Experiment:    e.Experiment,
+		}
+		if e.DurationMs != 0 || e.Source != "" || e.Context != "" {
+			v2.Playback = &playback{DurationMs: e.DurationMs, Source: e.Source, Context: e.Context}
+		}
+		return json.Marshal(v2)
+	case FormatProto:
+		return marshalProto(e), nil
+	case FormatAvro:
+		return marshalAvro(e), nil
+	}
+	return nil, fmt.Errorf("unknown event format %q", format)
+}
+
+// VersionFromEnv returns the schema version producers should write,
+// EVENT_SCHEMA_VERSION or DefaultVersion. Invalid values are logged and
+// replaced by the default.
+func VersionFromEnv() int {
+	v := os.Getenv("EVENT_SCHEMA_VERSION")
+	if v == "" {
+		return DefaultVersion
+	}
+	version, err := strconv.Atoi(v)
+	if err != nil || version < 1 || version > SchemaVersion {
+		log.Printf("Warning: invalid EVENT_SCHEMA_VERSION %q (want 1-%d), using %d", v, SchemaVersion, DefaultVersion)
+		return DefaultVersion
+	}
+	return version
+}
+
+// Unmarshal decodes an event of any known schema version, detecting JSON
+// (starts with '{'), Avro (avroMagic) or protobuf. It does not validate;
+// call Validate on the result.
+func Unmarshal(data []byte) (ListenEvent, error) {
+	var e ListenEvent
+	trimmed := bytes.TrimLeft(data, " \t\r\n")
+	if len(trimmed) > 0 && trimmed[0] == '{' {
+		var err error
+		if e, err = unmarshalJSON(trimmed); err != nil {
+			return e, err
+		}
+	} else if isAvro(data) {
+		var err error
+		if e, err = unmarshalAvro(data); err != nil {
+			return e, err
+		}
+	} else {
+		var err error
+		if e, err = unmarshalProto(data); err != nil {
+			return e, err
+		}
+	}
+	if e.SchemaVersion == 0 {
+		e.SchemaVersion = 1 // pre-versioning producers
+	}
+	metricVersions.Add(strconv.Itoa(e.SchemaVersion), 1)
+	return e, nil
+}
+
+// unmarshalJSON reads the play details from where the event's version keeps
+// them: top-level in version 1, under "playback" from version 2
+func unmarshalJSON(data []byte) (ListenEvent, error) {
+	var w struct {
+		ListenEvent
+		Playback *playback `json:"playback"`
+	}
+	if err := json.Unmarshal(data, &w); err != nil {
+		return ListenEvent{}, err
+	}
+	e := w.ListenEvent
+	if e.SchemaVersion >= 2 {
+		e.DurationMs, e.Source, e.Context = 0, "", ""
+		if p := w.Playback; p != nil {
+			e.DurationMs, e.Source, e.Context = p.DurationMs, p.Source, p.Context
+		}
+	}
+	return e, nil
+}
+
+// UnknownJSONFields returns the top-level keys of a JSON event that aren't
+// part of ListenEvent, so consumers can keep fields added by newer producers
+func UnknownJSONFields(data []byte) map[string]json.RawMessage {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil
+	}
+	for k := range fields {
+		if knownFields[k] {
+			delete(fields, k)
+		}
+	}
+	if len(fields) == 0 {
+		return nil
+	}
+	return fields
+}
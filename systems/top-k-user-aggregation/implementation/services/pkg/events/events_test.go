@@ -0,0 +1,305 @@
+package events
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+func fullEvent() ListenEvent {
+	return ListenEvent{
+		EventID:    "e1",
+		UserID:     "u1",
+		SongID:     "s1",
+		Provider:   "spotify",
+		ListenedAt: 1715600000,
+		Source:     "playlist",
+		Context:    "pl-9",
+		ArtistID:   "a1",
+		DurationMs: 212000,
+		Experiment: "provider-v2-b",
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	minimal := ListenEvent{EventID: "e2", UserID: "u2", SongID: "s2", Provider: "apple", ListenedAt: 1}
+	tests := []struct {
+		name    string
+		event   ListenEvent
+		format  Format
+		version int
+	}{
+		{"json v1", fullEvent(), FormatJSON, 1},
+		{"json v2", fullEvent(), FormatJSON, 2},
+		{"json v2 without playback", minimal, FormatJSON, 2},
+		{"proto v1", fullEvent(), FormatProto, 1},
+		{"proto v2", fullEvent(), FormatProto, 2},
+		{"proto minimal", minimal, FormatProto, 1},
+		{"avro v1", fullEvent(), FormatAvro, 1},
+		{"avro v2", fullEvent(), FormatAvro, 2},
+		{"avro minimal", minimal, FormatAvro, 1},
+		{"default format", fullEvent(), "", 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := MarshalVersion(tt.event, tt.format, tt.version)
+			if err != nil {
+				t.Fatalf("MarshalVersion: %v", err)
+			}
+			got, err := Unmarshal(data)
+			if err != nil {
+				t.Fatalf("Unmarshal: %v", err)
+			}
+			want := tt.event
+			want.SchemaVersion = tt.version
+			if got != want {
+				t.Errorf("round trip:\n got %+v\nwant %+v", got, want)
+			}
+			if err := got.Validate(); err != nil {
+				t.Errorf("Validate: %v", err)
+			}
+		})
+	}
+}
+
+func TestMarshalDefaultVersion(t *testing.T) {
+	data, err := Marshal(fullEvent(), FormatJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err := Unmarshal(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.SchemaVersion != DefaultVersion {
+		t.Errorf("schema_version = %d, want DefaultVersion %d", e.SchemaVersion, DefaultVersion)
+	}
+}
+
+func TestMarshalRejectsUnknownVersionAndFormat(t *testing.T) {
+	for _, v := range []int{0, -1, SchemaVersion + 1} {
+		if _, err := MarshalVersion(fullEvent(), FormatJSON, v); err == nil {
+			t.Errorf("MarshalVersion(version %d): want an error", v)
+		}
+	}
+	if _, err := MarshalVersion(fullEvent(), "thrift", 1); err == nil {
+		t.Error("MarshalVersion(thrift): want an error")
+	}
+}
+
+// Unmarshal tells JSON by the first non-blank byte and Avro by its marker
+// from protobuf
+func TestUnmarshalDetectsFormat(t *testing.T) {
+	js, _ := MarshalVersion(fullEvent(), FormatJSON, 1)
+	pb, _ := MarshalVersion(fullEvent(), FormatProto, 1)
+	av, _ := MarshalVersion(fullEvent(), FormatAvro, 1)
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"json", js},
+		{"json with leading whitespace", append([]byte(" \n\t\r"), js...)},
+		{"proto", pb},
+		{"avro", av},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, err := Unmarshal(tt.data)
+			if err != nil {
+				t.Fatalf("Unmarshal: %v", err)
+			}
+			if e.EventID != "e1" || e.DurationMs != 212000 {
+				t.Errorf("decoded %+v", e)
+			}
+		})
+	}
+	if pb[0] == '{' {
+		t.Fatalf("proto encoding starts with '{', detection can't tell it from JSON")
+	}
+	if isAvro(pb) {
+		t.Fatalf("proto encoding starts with the Avro marker")
+	}
+}
+
+// The writer schema's fingerprint follows the marker, checked against the
+// Avro spec's CRC-64-AVRO
+func TestAvroFingerprint(t *testing.T) {
+	for schema, want := range map[string]uint64{`"null"`: 7195948357588979594, `"int"`: 8247732601305521295} {
+		if got := avroCRC64([]byte(schema)); got != want {
+			t.Errorf("fingerprint of %s = %d, want %d", schema, got, want)
+		}
+	}
+	av, _ := MarshalVersion(fullEvent(), FormatAvro, 1)
+	if got := binary.LittleEndian.Uint64(av[2:10]); got != AvroFingerprint {
+		t.Errorf("header fingerprint %016x, want %016x", got, AvroFingerprint)
+	}
+	var schema struct {
+		Fields []struct{ Name string }
+	}
+	if err := json.Unmarshal([]byte(AvroSchema), &schema); err != nil {
+		t.Fatalf("AvroSchema: %v", err)
+	}
+	if schema.Fields[0].Name != "schema_version" {
+		t.Errorf("first field %q, want schema_version", schema.Fields[0].Name)
+	}
+}
+
+func TestUnmarshalMalformed(t *testing.T) {
+	pb, _ := MarshalVersion(fullEvent(), FormatProto, 1)
+	av, _ := MarshalVersion(fullEvent(), FormatAvro, 1)
+	unknownSchema := bytes.Clone(av)
+	unknownSchema[2] ^= 0xff
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"truncated proto", pb[:len(pb)-3]},
+		{"truncated avro", av[:len(av)-3]},
+		{"avro with trailing bytes", append(bytes.Clone(av), 0)},
+		{"avro header only", av[:6]},
+		{"unknown avro schema", unknownSchema},
+		{"bad tag", []byte{0xff}},
+		{"broken json", []byte(`{"event_id": "e1",`)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Unmarshal(tt.data); err == nil {
+				t.Error("want an error")
+			}
+		})
+	}
+}
+
+// Fields added by newer producers are skipped by the proto decoder and
+// surfaced by UnknownJSONFields
+func TestUnknownFields(t *testing.T) {
+	pb, _ := MarshalVersion(fullEvent(), FormatProto, 1)
+	pb = protowire.AppendTag(pb, 20, protowire.BytesType)
+	pb = protowire.AppendString(pb, "from the future")
+	pb = protowire.AppendTag(pb, 21, protowire.VarintType)
+	pb = protowire.AppendVarint(pb, 42)
+	pb = protowire.AppendTag(pb, 22, protowire.Fixed64Type)
+	pb = protowire.AppendFixed64(pb, 7)
+	// A known field number with an unexpected wire type is skipped too
+	pb = protowire.AppendTag(pb, fieldUserID, protowire.VarintType)
+	pb = protowire.AppendVarint(pb, 1)
+
+	e, err := Unmarshal(pb)
+	if err != nil {
+		t.Fatalf("Unmarshal proto with unknown fields: %v", err)
+	}
+	want := fullEvent()
+	want.SchemaVersion = 1
+	if e != want {
+		t.Errorf("proto:\n got %+v\nwant %+v", e, want)
+	}
+
+	js := []byte(`{"schema_version":1,"event_id":"e1","user_id":"u1","song_id":"s1","provider":"spotify",
+		"listened_at":1715600000,"mood":"happy","device":{"os":"ios"}}`)
+	e, err = Unmarshal(js)
+	if err != nil {
+		t.Fatalf("Unmarshal JSON with unknown fields: %v", err)
+	}
+	if err := e.Validate(); err != nil {
+		t.Errorf("Validate: %v", err)
+	}
+	extra := UnknownJSONFields(js)
+	if len(extra) != 2 || string(extra["mood"]) != `"happy"` || string(extra["device"]) != `{"os":"ios"}` {
+		t.Errorf("UnknownJSONFields = %v", extra)
+	}
+	known, _ := MarshalVersion(fullEvent(), FormatJSON, 2)
+	if extra := UnknownJSONFields(known); extra != nil {
+		t.Errorf("UnknownJSONFields of a v2 event = %v, want nil", extra)
+	}
+}
+
+// Consumers and producers a version apart: each version reads its play
+// details from its own place, and newer versions decode but don't validate
+func TestVersionSkew(t *testing.T) {
+	t.Run("missing version is 1", func(t *testing.T) {
+		e, err := Unmarshal([]byte(`{"event_id":"e1","user_id":"u1","song_id":"s1","provider":"p","listened_at":1,"duration_ms":5}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if e.SchemaVersion != 1 || e.DurationMs != 5 {
+			t.Errorf("got version %d duration %d, want 1 and 5", e.SchemaVersion, e.DurationMs)
+		}
+	})
+	t.Run("v2 ignores top-level play details", func(t *testing.T) {
+		e, err := Unmarshal([]byte(`{"schema_version":2,"event_id":"e1","user_id":"u1","song_id":"s1","provider":"p",
+			"listened_at":1,"duration_ms":5,"source":"radio","playback":{"duration_ms":9}}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if e.DurationMs != 9 || e.Source != "" {
+			t.Errorf("got duration %d source %q, want 9 and none", e.DurationMs, e.Source)
+		}
+	})
+	t.Run("v1 ignores playback", func(t *testing.T) {
+		e, err := Unmarshal([]byte(`{"schema_version":1,"event_id":"e1","user_id":"u1","song_id":"s1","provider":"p",
+			"listened_at":1,"duration_ms":5,"playback":{"duration_ms":9}}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if e.DurationMs != 5 {
+			t.Errorf("got duration %d, want 5", e.DurationMs)
+		}
+	})
+	t.Run("proto versions differ only in schema_version", func(t *testing.T) {
+		v1, _ := MarshalVersion(fullEvent(), FormatProto, 1)
+		v2, _ := MarshalVersion(fullEvent(), FormatProto, 2)
+		if len(v1) != len(v2) || bytes.Equal(v1, v2) {
+			t.Errorf("v1 %x and v2 %x should differ only in the version byte", v1, v2)
+		}
+	})
+
+	newer := SchemaVersion + 1
+	future := fullEvent()
+	future.SchemaVersion = newer
+	futureJSON, _ := json.Marshal(future)
+	futureProto := marshalProto(future)
+	futureAvro := marshalAvro(future)
+	for name, data := range map[string][]byte{"json": futureJSON, "proto": futureProto, "avro": futureAvro} {
+		t.Run("newer version in "+name, func(t *testing.T) {
+			e, err := Unmarshal(data)
+			if err != nil {
+				t.Fatalf("Unmarshal: %v", err)
+			}
+			if e.SchemaVersion != newer {
+				t.Errorf("schema_version = %d, want %d", e.SchemaVersion, newer)
+			}
+			if err := e.Validate(); !errors.Is(err, ErrInvalid) {
+				t.Errorf("Validate = %v, want ErrInvalid", err)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(*ListenEvent)
+	}{
+		{"missing event_id", func(e *ListenEvent) { e.EventID = "" }},
+		{"missing user_id", func(e *ListenEvent) { e.UserID = "" }},
+		{"missing song_id", func(e *ListenEvent) { e.SongID = "" }},
+		{"missing provider", func(e *ListenEvent) { e.Provider = "" }},
+		{"missing listened_at", func(e *ListenEvent) { e.ListenedAt = 0 }},
+		{"negative duration_ms", func(e *ListenEvent) { e.DurationMs = -1 }},
+		{"negative schema_version", func(e *ListenEvent) { e.SchemaVersion = -1 }},
+		{"long experiment", func(e *ListenEvent) { e.Experiment = string(make([]byte, MaxExperimentLen+1)) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := fullEvent()
+			tt.modify(&e)
+			if err := e.Validate(); !errors.Is(err, ErrInvalid) {
+				t.Errorf("Validate = %v, want ErrInvalid", err)
+			}
+		})
+	}
+}
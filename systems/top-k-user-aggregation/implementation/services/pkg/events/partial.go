@@ -0,0 +1,50 @@
+package events
+
+import (
+	"encoding/json"
+	"errors"
+)
+
+// PartialAggregate is what one aggregator flush counted in a user's day under
+// SINK_MODE=reduce, published on user.listen.partial keyed by user_id. A
+// reducer merges the partials of its partitions and writes them to
+// Cassandra; ID is unique per partial, so a redelivered one is skipped.
+type PartialAggregate struct {
+	ID        string         `json:"id"`
+	UserID    string         `json:"user_id"`
+	Day       string         `json:"day"` // YYYY-MM-DD
+	Counts    []PartialCount `json:"counts"`
+	FlushedAt int64          `json:"flushed_at"` // unix seconds
+	// Published counts the partial's listens by publish time (unix ms), as
+	// in AggregateDelta
+	Published map[int64]int64 `json:"published,omitempty"`
+}
+
+// PartialCount is the listens of one song in one hour of the day, split like
+// the aggregator's counts: by artist, running experiment and recompute
+type PartialCount struct {
+	Hour       int    `json:"hour"` // UTC
+	SongID     string `json:"song_id"`
+	ArtistID   string `json:"artist_id,omitempty"`
+	Experiment string `json:"experiment,omitempty"`
+	Recompute  bool   `json:"recompute,omitempty"`
+	Count      int64  `json:"count"`
+	ListenMs   int64  `json:"listen_ms,omitempty"`
+}
+
+// MarshalPartial encodes p as JSON
+func MarshalPartial(p PartialAggregate) ([]byte, error) {
+	return json.Marshal(p)
+}
+
+// UnmarshalPartial decodes and validates a partial
+func UnmarshalPartial(data []byte) (PartialAggregate, error) {
+	var p PartialAggregate
+	if err := json.Unmarshal(data, &p); err != nil {
+		return p, err
+	}
+	if p.ID == "" || p.UserID == "" || p.Day == "" {
+		return p, errors.New("partial without id, user_id or day")
+	}
+	return p, nil
+}
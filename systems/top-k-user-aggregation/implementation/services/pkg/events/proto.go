@@ -0,0 +1,113 @@
+package events
+
+import (
+	"fmt"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Protobuf field numbers, matching listen_event.proto
+const (
+	fieldEventID       = 1
+	fieldUserID        = 2
+	fieldSongID        = 3
+	fieldProvider      = 4
+	fieldListenedAt    = 5
+	fieldSource        = 6
+	fieldContext       = 7
+	fieldArtistID      = 8
+	fieldDurationMs    = 9
+	fieldExperiment    = 10
+	fieldSchemaVersion = 15
+)
+
+func marshalProto(e ListenEvent) []byte {
+	var b []byte
+	b = appendString(b, fieldEventID, e.EventID)
+	b = appendString(b, fieldUserID, e.UserID)
+	b = appendString(b, fieldSongID, e.SongID)
+	b = appendString(b, fieldProvider, e.Provider)
+	if e.ListenedAt != 0 {
+		b = protowire.AppendTag(b, fieldListenedAt, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(e.ListenedAt))
+	}
+	b = appendString(b, fieldSource, e.Source)
+	b = appendString(b, fieldContext, e.Context)
+	b = appendString(b, fieldArtistID, e.ArtistID)
+	if e.DurationMs != 0 {
+		b = protowire.AppendTag(b, fieldDurationMs, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(e.DurationMs))
+	}
+	b = appendString(b, fieldExperiment, e.Experiment)
+	b = protowire.AppendTag(b, fieldSchemaVersion, protowire.VarintType)
+	b = protowire.AppendVarint(b, uint64(e.SchemaVersion))
+	return b
+}
+
+func appendString(b []byte, num protowire.Number, v string) []byte {
+	if v == "" {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, v)
+}
+
+func unmarshalProto(b []byte) (ListenEvent, error) {
+	var e ListenEvent
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return e, fmt.Errorf("proto: bad tag: %w", protowire.ParseError(n))
+		}
+		b = b[n:]
+
+		switch {
+		case typ == protowire.BytesType && (num >= fieldEventID && num <= fieldArtistID && num != fieldListenedAt || num == fieldExperiment):
+			v, n := protowire.ConsumeString(b)
+			if n < 0 {
+				return e, fmt.Errorf("proto: field %d: %w", num, protowire.ParseError(n))
+			}
+			b = b[n:]
+			switch num {
+			case fieldEventID:
+				e.EventID = v
+			case fieldUserID:
+				e.UserID = v
+			case fieldSongID:
+				e.SongID = v
+			case fieldProvider:
+				e.Provider = v
+			case fieldSource:
+				e.Source = v
+			case fieldContext:
+				e.Context = v
+			case fieldArtistID:
+				e.ArtistID = v
+			case fieldExperiment:
+				e.Experiment = v
+			}
+		case typ == protowire.VarintType && (num == fieldListenedAt || num == fieldDurationMs || num == fieldSchemaVersion):
+			v, n := protowire.ConsumeVarint(b)
+			if n < 0 {
+				return e, fmt.Errorf("proto: field %d: %w", num, protowire.ParseError(n))
+			}
+			b = b[n:]
+			switch num {
+			case fieldListenedAt:
+				e.ListenedAt = int64(v)
+			case fieldDurationMs:
+				e.DurationMs = int64(v)
+			default:
+				e.SchemaVersion = int(v)
+			}
+		default:
+			// Unknown field from a newer schema: skip it
+			n := protowire.ConsumeFieldValue(num, typ, b)
+			if n < 0 {
+				return e, fmt.Errorf("proto: field %d: %w", num, protowire.ParseError(n))
+			}
+			b = b[n:]
+		}
+	}
+	return e, nil
+}
@@ -0,0 +1,32 @@
+package events
+
+import (
+	"encoding/json"
+	"errors"
+)
+
+// SongTakedown asks every aggregate to drop a song, published on the
+// compacted song.takedown keyed by song_id. The song_takedowns table is the
+// record; the message tells the aggregator to purge the counters.
+type SongTakedown struct {
+	SongID      string `json:"song_id"`
+	Reason      string `json:"reason,omitempty"`
+	RequestedAt int64  `json:"requested_at"` // unix seconds
+}
+
+// MarshalTakedown encodes t as JSON
+func MarshalTakedown(t SongTakedown) ([]byte, error) {
+	return json.Marshal(t)
+}
+
+// UnmarshalTakedown decodes and validates a takedown
+func UnmarshalTakedown(data []byte) (SongTakedown, error) {
+	var t SongTakedown
+	if err := json.Unmarshal(data, &t); err != nil {
+		return t, err
+	}
+	if t.SongID == "" {
+		return t, errors.New("takedown without song_id")
+	}
+	return t, nil
+}
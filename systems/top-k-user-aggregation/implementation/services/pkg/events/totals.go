@@ -0,0 +1,41 @@
+package events
+
+import (
+	"encoding/json"
+	"errors"
+)
+
+// SongTotal is the absolute count of a song in a user's day, published on
+// the compacted user.listen.totals keyed by TotalKey. Compaction keeps the
+// latest total per key, so reading the topic from the start gives every
+// day's current counts without replaying the deltas.
+type SongTotal struct {
+	UserID    string `json:"user_id"`
+	Day       string `json:"day"` // YYYY-MM-DD
+	SongID    string `json:"song_id"`
+	Count     int64  `json:"listen_count"`
+	ListenMs  int64  `json:"listen_ms,omitempty"`
+	UpdatedAt int64  `json:"updated_at"` // unix seconds
+}
+
+// TotalKey is the message key of a total: user, day and song
+func TotalKey(userID, day, songID string) string {
+	return userID + "|" + day + "|" + songID
+}
+
+// MarshalTotal encodes t as JSON
+func MarshalTotal(t SongTotal) ([]byte, error) {
+	return json.Marshal(t)
+}
+
+// UnmarshalTotal decodes and validates a total
+func UnmarshalTotal(data []byte) (SongTotal, error) {
+	var t SongTotal
+	if err := json.Unmarshal(data, &t); err != nil {
+		return t, err
+	}
+	if t.UserID == "" || t.Day == "" || t.SongID == "" {
+		return t, errors.New("total without user_id, day or song_id")
+	}
+	return t, nil
+}
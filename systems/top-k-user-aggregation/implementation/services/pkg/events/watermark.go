@@ -0,0 +1,69 @@
+package events
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"time"
+)
+
+// PartitionWatermark is how far a consumer group has got through one
+// partition in event time: every listen of the partition up to Offset has
+// been flushed, and EventTime is the latest listened_at among them. The
+// aggregator publishes one per partition it owns on the compacted
+// user.listen.watermarks, keyed by WatermarkKey, and keeps the last in Redis.
+//
+// Listens arrive out of order, so a watermark says what was seen, not that
+// nothing older will come: consumers closing windows still allow for
+// lateness. An Idle partition has had nothing to read for a while and
+// shouldn't hold a window open.
+type PartitionWatermark struct {
+	Group     string `json:"group"`
+	Topic     string `json:"topic"`
+	Partition int    `json:"partition"`
+	EventTime int64  `json:"event_time"` // unix seconds; 0 = nothing flushed yet
+	Offset    int64  `json:"offset"`     // after the last flushed message
+	Idle      bool   `json:"idle,omitempty"`
+	Instance  string `json:"instance"`   // the group member that owns it
+	UpdatedAt int64  `json:"updated_at"` // unix seconds
+}
+
+// WatermarkKey is the message key of a watermark: group, topic and partition
+func WatermarkKey(group, topic string, partition int) string {
+	return group + "|" + topic + "|" + strconv.Itoa(partition)
+}
+
+// MarshalWatermark encodes w as JSON
+func MarshalWatermark(w PartitionWatermark) ([]byte, error) {
+	return json.Marshal(w)
+}
+
+// UnmarshalWatermark decodes and validates a watermark
+func UnmarshalWatermark(data []byte) (PartitionWatermark, error) {
+	var w PartitionWatermark
+	if err := json.Unmarshal(data, &w); err != nil {
+		return w, err
+	}
+	if w.Group == "" || w.Topic == "" {
+		return w, errors.New("watermark without group or topic")
+	}
+	return w, nil
+}
+
+// LowWatermark is the event time every partition in marks has reached, the
+// point a window can close at. Idle partitions are left out, as are ones not
+// updated within stale (their owner stopped reporting, e.g. after a
+// rebalance gave an idle partition to a member that hasn't read from it);
+// 0 keeps them all. ok is false when no partition counts.
+func LowWatermark(marks []PartitionWatermark, now time.Time, stale time.Duration) (low time.Time, ok bool) {
+	for _, w := range marks {
+		if w.Idle || (stale > 0 && now.Sub(time.Unix(w.UpdatedAt, 0)) > stale) {
+			continue
+		}
+		t := time.Unix(w.EventTime, 0)
+		if !ok || t.Before(low) {
+			low, ok = t, true
+		}
+	}
+	return low, ok
+}
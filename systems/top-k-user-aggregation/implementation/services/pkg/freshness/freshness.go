@@ -0,0 +1,149 @@
+// Package freshness measures how long listen events take to get through the
+// pipeline, the pipeline's SLO. Producers stamp the publish time
+// (kafkautil.HeaderPublishedAt); each stage notes the publish times of the
+// events it has taken through (Published) and observes their age in a
+// Histogram once they are done: flushed by the aggregator, queryable after
+// the materializer.
+package freshness
+
+import (
+	"encoding/json"
+	"expvar"
+	"sync"
+	"time"
+)
+
+// Bounds are the upper bounds of a Histogram's buckets
+var Bounds = []time.Duration{
+	250 * time.Millisecond, 500 * time.Millisecond,
+	time.Second, 2 * time.Second, 5 * time.Second, 10 * time.Second, 20 * time.Second, 30 * time.Second,
+	time.Minute, 2 * time.Minute, 5 * time.Minute, 10 * time.Minute, 30 * time.Minute, time.Hour,
+}
+
+// Histogram counts latencies in the Bounds buckets, plus one for longer
+// ones. It is an expvar.Var.
+type Histogram struct {
+	mu      sync.Mutex
+	buckets []int64
+	count   int64
+	sum     time.Duration
+	max     time.Duration
+}
+
+// NewHistogram returns a Histogram published through expvar as name
+func NewHistogram(name string) *Histogram {
+	h := &Histogram{buckets: make([]int64, len(Bounds)+1)}
+	expvar.Publish(name, h)
+	return h
+}
+
+// Observe counts n events that took d
+func (h *Histogram) Observe(d time.Duration, n int64) {
+	if n <= 0 {
+		return
+	}
+	if d < 0 {
+		d = 0 // producer clock ahead of ours
+	}
+	i := 0
+	for i < len(Bounds) && d > Bounds[i] {
+		i++
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.buckets[i] += n
+	h.count += n
+	h.sum += d * time.Duration(n)
+	if d > h.max {
+		h.max = d
+	}
+}
+
+// Quantile estimates the q-quantile (0-1) as the bound of the bucket it
+// falls in, or the longest latency seen when it is past the last bound
+func (h *Histogram) Quantile(q float64) time.Duration {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.quantile(q)
+}
+
+func (h *Histogram) quantile(q float64) time.Duration {
+	if h.count == 0 {
+		return 0
+	}
+	rank := int64(q * float64(h.count))
+	if rank < 1 {
+		rank = 1
+	}
+	var seen int64
+	for i, b := range Bounds {
+		if seen += h.buckets[i]; seen >= rank {
+			return min(b, h.max)
+		}
+	}
+	return h.max
+}
+
+// String renders the histogram as JSON: count, avg, max and quantiles in
+// milliseconds, and the bucket counts by upper bound ("le_ms"; the last has
+// none)
+func (h *Histogram) String() string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	type bucket struct {
+		LeMs  int64 `json:"le_ms,omitempty"`
+		Count int64 `json:"count"`
+	}
+	out := struct {
+		Count   int64    `json:"count"`
+		AvgMs   int64    `json:"avg_ms"`
+		MaxMs   int64    `json:"max_ms"`
+		P50Ms   int64    `json:"p50_ms"`
+		P95Ms   int64    `json:"p95_ms"`
+		P99Ms   int64    `json:"p99_ms"`
+		Buckets []bucket `json:"buckets"`
+	}{
+		Count: h.count,
+		MaxMs: h.max.Milliseconds(),
+		P50Ms: h.quantile(0.50).Milliseconds(),
+		P95Ms: h.quantile(0.95).Milliseconds(),
+		P99Ms: h.quantile(0.99).Milliseconds(),
+	}
+	if h.count > 0 {
+		out.AvgMs = (h.sum / time.Duration(h.count)).Milliseconds()
+	}
+	for i, n := range h.buckets {
+		b := bucket{Count: n}
+		if i < len(Bounds) {
+			b.LeMs = Bounds[i].Milliseconds()
+		}
+		out.Buckets = append(out.Buckets, b)
+	}
+	data, _ := json.Marshal(out)
+	return string(data)
+}
+
+// Published counts events by publish time, in unix milliseconds
+type Published map[int64]int64
+
+// Add notes an event published at t; a zero t (not stamped) is ignored
+func (p Published) Add(t time.Time) {
+	if !t.IsZero() {
+		p[t.UnixMilli()]++
+	}
+}
+
+// Merge adds o's events to p
+func (p Published) Merge(o Published) {
+	for ms, n := range o {
+		p[ms] += n
+	}
+}
+
+// Observe counts p's events in h by their age at now
+func (p Published) Observe(h *Histogram, now time.Time) {
+	for ms, n := range p {
+		h.Observe(now.Sub(time.UnixMilli(ms)), n)
+	}
+}
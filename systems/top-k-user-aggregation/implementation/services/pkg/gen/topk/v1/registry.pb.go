@@ -0,0 +1,948 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.34.2
+// 	protoc        (unknown)
+// source: topk/v1/registry.proto
+
+package topkv1
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	durationpb "google.golang.org/protobuf/types/known/durationpb"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
+	reflect "reflect"
+	sync "sync"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type CrawlStatus int32
+
+const (
+	CrawlStatus_CRAWL_STATUS_UNSPECIFIED CrawlStatus = 0
+	CrawlStatus_CRAWL_STATUS_IDLE        CrawlStatus = 1
+	CrawlStatus_CRAWL_STATUS_ENQUEUED    CrawlStatus = 2
+	CrawlStatus_CRAWL_STATUS_RUNNING     CrawlStatus = 3
+	CrawlStatus_CRAWL_STATUS_SUSPENDED   CrawlStatus = 4 // dormant, until reactivated
+)
+
+// Enum value maps for CrawlStatus.
+var (
+	CrawlStatus_name = map[int32]string{
+		0: "CRAWL_STATUS_UNSPECIFIED",
+		1: "CRAWL_STATUS_IDLE",
+		2: "CRAWL_STATUS_ENQUEUED",
+		3: "CRAWL_STATUS_RUNNING",
+		4: "CRAWL_STATUS_SUSPENDED",
+	}
+	CrawlStatus_value = map[string]int32{
+		"CRAWL_STATUS_UNSPECIFIED": 0,
+		"CRAWL_STATUS_IDLE":        1,
+		"CRAWL_STATUS_ENQUEUED":    2,
+		"CRAWL_STATUS_RUNNING":     3,
+		"CRAWL_STATUS_SUSPENDED":   4,
+	}
+)
+
+func (x CrawlStatus) Enum() *CrawlStatus {
+	p := new(CrawlStatus)
+	*p = x
+	return p
+}
+
+func (x CrawlStatus) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (CrawlStatus) Descriptor() protoreflect.EnumDescriptor {
+	return file_topk_v1_registry_proto_enumTypes[0].Descriptor()
+}
+
+func (CrawlStatus) Type() protoreflect.EnumType {
+	return &file_topk_v1_registry_proto_enumTypes[0]
+}
+
+func (x CrawlStatus) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use CrawlStatus.Descriptor instead.
+func (CrawlStatus) EnumDescriptor() ([]byte, []int) {
+	return file_topk_v1_registry_proto_rawDescGZIP(), []int{0}
+}
+
+type User struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	UserId    string                 `protobuf:"bytes,1,opt,name=user_id,json=userId,proto3" json:"user_id,omitempty"`
+	Providers []*Provider            `protobuf:"bytes,2,rep,name=providers,proto3" json:"providers,omitempty"`
+	CreatedAt *timestamppb.Timestamp `protobuf:"bytes,3,opt,name=created_at,json=createdAt,proto3" json:"created_at,omitempty"`
+	DeletedAt *timestamppb.Timestamp `protobuf:"bytes,4,opt,name=deleted_at,json=deletedAt,proto3" json:"deleted_at,omitempty"` // set once soft-deleted
+}
+
+func (x *User) Reset() {
+	*x = User{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_topk_v1_registry_proto_msgTypes[0]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *User) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*User) ProtoMessage() {}
+
+func (x *User) ProtoReflect() protoreflect.Message {
+	mi := &file_topk_v1_registry_proto_msgTypes[0]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use User.ProtoReflect.Descriptor instead.
+func (*User) Descriptor() ([]byte, []int) {
+	return file_topk_v1_registry_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *User) GetUserId() string {
+	if x != nil {
+		return x.UserId
+	}
+	return ""
+}
+
+func (x *User) GetProviders() []*Provider {
+	if x != nil {
+		return x.Providers
+	}
+	return nil
+}
+
+func (x *User) GetCreatedAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.CreatedAt
+	}
+	return nil
+}
+
+func (x *User) GetDeletedAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.DeletedAt
+	}
+	return nil
+}
+
+type Provider struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Name        string                 `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"` // e.g. "spotify"
+	Status      CrawlStatus            `protobuf:"varint,2,opt,name=status,proto3,enum=topk.v1.CrawlStatus" json:"status,omitempty"`
+	NextCrawlAt *timestamppb.Timestamp `protobuf:"bytes,3,opt,name=next_crawl_at,json=nextCrawlAt,proto3" json:"next_crawl_at,omitempty"`
+	LastError   string                 `protobuf:"bytes,4,opt,name=last_error,json=lastError,proto3" json:"last_error,omitempty"`
+}
+
+func (x *Provider) Reset() {
+	*x = Provider{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_topk_v1_registry_proto_msgTypes[1]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *Provider) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Provider) ProtoMessage() {}
+
+func (x *Provider) ProtoReflect() protoreflect.Message {
+	mi := &file_topk_v1_registry_proto_msgTypes[1]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Provider.ProtoReflect.Descriptor instead.
+func (*Provider) Descriptor() ([]byte, []int) {
+	return file_topk_v1_registry_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *Provider) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *Provider) GetStatus() CrawlStatus {
+	if x != nil {
+		return x.Status
+	}
+	return CrawlStatus_CRAWL_STATUS_UNSPECIFIED
+}
+
+func (x *Provider) GetNextCrawlAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.NextCrawlAt
+	}
+	return nil
+}
+
+func (x *Provider) GetLastError() string {
+	if x != nil {
+		return x.LastError
+	}
+	return ""
+}
+
+type GetUserRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	UserId string `protobuf:"bytes,1,opt,name=user_id,json=userId,proto3" json:"user_id,omitempty"`
+}
+
+func (x *GetUserRequest) Reset() {
+	*x = GetUserRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_topk_v1_registry_proto_msgTypes[2]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *GetUserRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetUserRequest) ProtoMessage() {}
+
+func (x *GetUserRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_topk_v1_registry_proto_msgTypes[2]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetUserRequest.ProtoReflect.Descriptor instead.
+func (*GetUserRequest) Descriptor() ([]byte, []int) {
+	return file_topk_v1_registry_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *GetUserRequest) GetUserId() string {
+	if x != nil {
+		return x.UserId
+	}
+	return ""
+}
+
+type ListDueCrawlsRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Limit int32 `protobuf:"varint,1,opt,name=limit,proto3" json:"limit,omitempty"`
+}
+
+func (x *ListDueCrawlsRequest) Reset() {
+	*x = ListDueCrawlsRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_topk_v1_registry_proto_msgTypes[3]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *ListDueCrawlsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListDueCrawlsRequest) ProtoMessage() {}
+
+func (x *ListDueCrawlsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_topk_v1_registry_proto_msgTypes[3]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListDueCrawlsRequest.ProtoReflect.Descriptor instead.
+func (*ListDueCrawlsRequest) Descriptor() ([]byte, []int) {
+	return file_topk_v1_registry_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *ListDueCrawlsRequest) GetLimit() int32 {
+	if x != nil {
+		return x.Limit
+	}
+	return 0
+}
+
+type ListDueCrawlsResponse struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Targets []*CrawlTarget `protobuf:"bytes,1,rep,name=targets,proto3" json:"targets,omitempty"`
+}
+
+func (x *ListDueCrawlsResponse) Reset() {
+	*x = ListDueCrawlsResponse{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_topk_v1_registry_proto_msgTypes[4]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *ListDueCrawlsResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListDueCrawlsResponse) ProtoMessage() {}
+
+func (x *ListDueCrawlsResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_topk_v1_registry_proto_msgTypes[4]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListDueCrawlsResponse.ProtoReflect.Descriptor instead.
+func (*ListDueCrawlsResponse) Descriptor() ([]byte, []int) {
+	return file_topk_v1_registry_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *ListDueCrawlsResponse) GetTargets() []*CrawlTarget {
+	if x != nil {
+		return x.Targets
+	}
+	return nil
+}
+
+type CrawlTarget struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	UserId   string `protobuf:"bytes,1,opt,name=user_id,json=userId,proto3" json:"user_id,omitempty"`
+	Provider string `protobuf:"bytes,2,opt,name=provider,proto3" json:"provider,omitempty"`
+}
+
+func (x *CrawlTarget) Reset() {
+	*x = CrawlTarget{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_topk_v1_registry_proto_msgTypes[5]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *CrawlTarget) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CrawlTarget) ProtoMessage() {}
+
+func (x *CrawlTarget) ProtoReflect() protoreflect.Message {
+	mi := &file_topk_v1_registry_proto_msgTypes[5]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CrawlTarget.ProtoReflect.Descriptor instead.
+func (*CrawlTarget) Descriptor() ([]byte, []int) {
+	return file_topk_v1_registry_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *CrawlTarget) GetUserId() string {
+	if x != nil {
+		return x.UserId
+	}
+	return ""
+}
+
+func (x *CrawlTarget) GetProvider() string {
+	if x != nil {
+		return x.Provider
+	}
+	return ""
+}
+
+type StartCrawlRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Target *CrawlTarget `protobuf:"bytes,1,opt,name=target,proto3" json:"target,omitempty"`
+}
+
+func (x *StartCrawlRequest) Reset() {
+	*x = StartCrawlRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_topk_v1_registry_proto_msgTypes[6]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *StartCrawlRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*StartCrawlRequest) ProtoMessage() {}
+
+func (x *StartCrawlRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_topk_v1_registry_proto_msgTypes[6]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use StartCrawlRequest.ProtoReflect.Descriptor instead.
+func (*StartCrawlRequest) Descriptor() ([]byte, []int) {
+	return file_topk_v1_registry_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *StartCrawlRequest) GetTarget() *CrawlTarget {
+	if x != nil {
+		return x.Target
+	}
+	return nil
+}
+
+type StartCrawlResponse struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+}
+
+func (x *StartCrawlResponse) Reset() {
+	*x = StartCrawlResponse{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_topk_v1_registry_proto_msgTypes[7]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *StartCrawlResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*StartCrawlResponse) ProtoMessage() {}
+
+func (x *StartCrawlResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_topk_v1_registry_proto_msgTypes[7]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use StartCrawlResponse.ProtoReflect.Descriptor instead.
+func (*StartCrawlResponse) Descriptor() ([]byte, []int) {
+	return file_topk_v1_registry_proto_rawDescGZIP(), []int{7}
+}
+
+// ReportCrawlRequest is a crawl's outcome. A failed one (ok false) goes back
+// to IDLE, still due, with error as last_error (none when it was skipped).
+type ReportCrawlRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Target      *CrawlTarget           `protobuf:"bytes,1,opt,name=target,proto3" json:"target,omitempty"`
+	Ok          bool                   `protobuf:"varint,2,opt,name=ok,proto3" json:"ok,omitempty"`
+	Error       string                 `protobuf:"bytes,3,opt,name=error,proto3" json:"error,omitempty"`                                  // set when ok is false
+	NextCrawlAt *timestamppb.Timestamp `protobuf:"bytes,4,opt,name=next_crawl_at,json=nextCrawlAt,proto3" json:"next_crawl_at,omitempty"` // unset = the registry's default
+	Events      int32                  `protobuf:"varint,5,opt,name=events,proto3" json:"events,omitempty"`                               // listens fetched, over quota included
+	Window      *durationpb.Duration   `protobuf:"bytes,6,opt,name=window,proto3" json:"window,omitempty"`                                // time the crawl covered
+}
+
+func (x *ReportCrawlRequest) Reset() {
+	*x = ReportCrawlRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_topk_v1_registry_proto_msgTypes[8]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *ReportCrawlRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ReportCrawlRequest) ProtoMessage() {}
+
+func (x *ReportCrawlRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_topk_v1_registry_proto_msgTypes[8]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ReportCrawlRequest.ProtoReflect.Descriptor instead.
+func (*ReportCrawlRequest) Descriptor() ([]byte, []int) {
+	return file_topk_v1_registry_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *ReportCrawlRequest) GetTarget() *CrawlTarget {
+	if x != nil {
+		return x.Target
+	}
+	return nil
+}
+
+func (x *ReportCrawlRequest) GetOk() bool {
+	if x != nil {
+		return x.Ok
+	}
+	return false
+}
+
+func (x *ReportCrawlRequest) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
+func (x *ReportCrawlRequest) GetNextCrawlAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.NextCrawlAt
+	}
+	return nil
+}
+
+func (x *ReportCrawlRequest) GetEvents() int32 {
+	if x != nil {
+		return x.Events
+	}
+	return 0
+}
+
+func (x *ReportCrawlRequest) GetWindow() *durationpb.Duration {
+	if x != nil {
+		return x.Window
+	}
+	return nil
+}
+
+type ReportCrawlResponse struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	NextCrawlAt *timestamppb.Timestamp `protobuf:"bytes,1,opt,name=next_crawl_at,json=nextCrawlAt,proto3" json:"next_crawl_at,omitempty"` // unset after a failure
+}
+
+func (x *ReportCrawlResponse) Reset() {
+	*x = ReportCrawlResponse{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_topk_v1_registry_proto_msgTypes[9]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *ReportCrawlResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ReportCrawlResponse) ProtoMessage() {}
+
+func (x *ReportCrawlResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_topk_v1_registry_proto_msgTypes[9]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ReportCrawlResponse.ProtoReflect.Descriptor instead.
+func (*ReportCrawlResponse) Descriptor() ([]byte, []int) {
+	return file_topk_v1_registry_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *ReportCrawlResponse) GetNextCrawlAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.NextCrawlAt
+	}
+	return nil
+}
+
+var File_topk_v1_registry_proto protoreflect.FileDescriptor
+
+var file_topk_v1_registry_proto_rawDesc = []byte{
+	0x0a, 0x16, 0x74, 0x6f, 0x70, 0x6b, 0x2f, 0x76, 0x31, 0x2f, 0x72, 0x65, 0x67, 0x69, 0x73, 0x74,
+	0x72, 0x79, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x12, 0x07, 0x74, 0x6f, 0x70, 0x6b, 0x2e, 0x76,
+	0x31, 0x1a, 0x1e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2f, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62,
+	0x75, 0x66, 0x2f, 0x64, 0x75, 0x72, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x2e, 0x70, 0x72, 0x6f, 0x74,
+	0x6f, 0x1a, 0x1f, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2f, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62,
+	0x75, 0x66, 0x2f, 0x74, 0x69, 0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d, 0x70, 0x2e, 0x70, 0x72, 0x6f,
+	0x74, 0x6f, 0x22, 0xc6, 0x01, 0x0a, 0x04, 0x55, 0x73, 0x65, 0x72, 0x12, 0x17, 0x0a, 0x07, 0x75,
+	0x73, 0x65, 0x72, 0x5f, 0x69, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x06, 0x75, 0x73,
+	0x65, 0x72, 0x49, 0x64, 0x12, 0x2f, 0x0a, 0x09, 0x70, 0x72, 0x6f, 0x76, 0x69, 0x64, 0x65, 0x72,
+	0x73, 0x18, 0x02, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x11, 0x2e, 0x74, 0x6f, 0x70, 0x6b, 0x2e, 0x76,
+	0x31, 0x2e, 0x50, 0x72, 0x6f, 0x76, 0x69, 0x64, 0x65, 0x72, 0x52, 0x09, 0x70, 0x72, 0x6f, 0x76,
+	0x69, 0x64, 0x65, 0x72, 0x73, 0x12, 0x39, 0x0a, 0x0a, 0x63, 0x72, 0x65, 0x61, 0x74, 0x65, 0x64,
+	0x5f, 0x61, 0x74, 0x18, 0x03, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x1a, 0x2e, 0x67, 0x6f, 0x6f, 0x67,
+	0x6c, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x54, 0x69, 0x6d, 0x65,
+	0x73, 0x74, 0x61, 0x6d, 0x70, 0x52, 0x09, 0x63, 0x72, 0x65, 0x61, 0x74, 0x65, 0x64, 0x41, 0x74,
+	0x12, 0x39, 0x0a, 0x0a, 0x64, 0x65, 0x6c, 0x65, 0x74, 0x65, 0x64, 0x5f, 0x61, 0x74, 0x18, 0x04,
+	0x20, 0x01, 0x28, 0x0b, 0x32, 0x1a, 0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70, 0x72,
+	0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x54, 0x69, 0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d, 0x70,
+	0x52, 0x09, 0x64, 0x65, 0x6c, 0x65, 0x74, 0x65, 0x64, 0x41, 0x74, 0x22, 0xab, 0x01, 0x0a, 0x08,
+	0x50, 0x72, 0x6f, 0x76, 0x69, 0x64, 0x65, 0x72, 0x12, 0x12, 0x0a, 0x04, 0x6e, 0x61, 0x6d, 0x65,
+	0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x04, 0x6e, 0x61, 0x6d, 0x65, 0x12, 0x2c, 0x0a, 0x06,
+	0x73, 0x74, 0x61, 0x74, 0x75, 0x73, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0e, 0x32, 0x14, 0x2e, 0x74,
+	0x6f, 0x70, 0x6b, 0x2e, 0x76, 0x31, 0x2e, 0x43, 0x72, 0x61, 0x77, 0x6c, 0x53, 0x74, 0x61, 0x74,
+	0x75, 0x73, 0x52, 0x06, 0x73, 0x74, 0x61, 0x74, 0x75, 0x73, 0x12, 0x3e, 0x0a, 0x0d, 0x6e, 0x65,
+	0x78, 0x74, 0x5f, 0x63, 0x72, 0x61, 0x77, 0x6c, 0x5f, 0x61, 0x74, 0x18, 0x03, 0x20, 0x01, 0x28,
+	0x0b, 0x32, 0x1a, 0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f,
+	0x62, 0x75, 0x66, 0x2e, 0x54, 0x69, 0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d, 0x70, 0x52, 0x0b, 0x6e,
+	0x65, 0x78, 0x74, 0x43, 0x72, 0x61, 0x77, 0x6c, 0x41, 0x74, 0x12, 0x1d, 0x0a, 0x0a, 0x6c, 0x61,
+	0x73, 0x74, 0x5f, 0x65, 0x72, 0x72, 0x6f, 0x72, 0x18, 0x04, 0x20, 0x01, 0x28, 0x09, 0x52, 0x09,
+	0x6c, 0x61, 0x73, 0x74, 0x45, 0x72, 0x72, 0x6f, 0x72, 0x22, 0x29, 0x0a, 0x0e, 0x47, 0x65, 0x74,
+	0x55, 0x73, 0x65, 0x72, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x17, 0x0a, 0x07, 0x75,
+	0x73, 0x65, 0x72, 0x5f, 0x69, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x06, 0x75, 0x73,
+	0x65, 0x72, 0x49, 0x64, 0x22, 0x2c, 0x0a, 0x14, 0x4c, 0x69, 0x73, 0x74, 0x44, 0x75, 0x65, 0x43,
+	0x72, 0x61, 0x77, 0x6c, 0x73, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x14, 0x0a, 0x05,
+	0x6c, 0x69, 0x6d, 0x69, 0x74, 0x18, 0x01, 0x20, 0x01, 0x28, 0x05, 0x52, 0x05, 0x6c, 0x69, 0x6d,
+	0x69, 0x74, 0x22, 0x47, 0x0a, 0x15, 0x4c, 0x69, 0x73, 0x74, 0x44, 0x75, 0x65, 0x43, 0x72, 0x61,
+	0x77, 0x6c, 0x73, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12, 0x2e, 0x0a, 0x07, 0x74,
+	0x61, 0x72, 0x67, 0x65, 0x74, 0x73, 0x18, 0x01, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x14, 0x2e, 0x74,
+	0x6f, 0x70, 0x6b, 0x2e, 0x76, 0x31, 0x2e, 0x43, 0x72, 0x61, 0x77, 0x6c, 0x54, 0x61, 0x72, 0x67,
+	0x65, 0x74, 0x52, 0x07, 0x74, 0x61, 0x72, 0x67, 0x65, 0x74, 0x73, 0x22, 0x42, 0x0a, 0x0b, 0x43,
+	0x72, 0x61, 0x77, 0x6c, 0x54, 0x61, 0x72, 0x67, 0x65, 0x74, 0x12, 0x17, 0x0a, 0x07, 0x75, 0x73,
+	0x65, 0x72, 0x5f, 0x69, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x06, 0x75, 0x73, 0x65,
+	0x72, 0x49, 0x64, 0x12, 0x1a, 0x0a, 0x08, 0x70, 0x72, 0x6f, 0x76, 0x69, 0x64, 0x65, 0x72, 0x18,
+	0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x08, 0x70, 0x72, 0x6f, 0x76, 0x69, 0x64, 0x65, 0x72, 0x22,
+	0x41, 0x0a, 0x11, 0x53, 0x74, 0x61, 0x72, 0x74, 0x43, 0x72, 0x61, 0x77, 0x6c, 0x52, 0x65, 0x71,
+	0x75, 0x65, 0x73, 0x74, 0x12, 0x2c, 0x0a, 0x06, 0x74, 0x61, 0x72, 0x67, 0x65, 0x74, 0x18, 0x01,
+	0x20, 0x01, 0x28, 0x0b, 0x32, 0x14, 0x2e, 0x74, 0x6f, 0x70, 0x6b, 0x2e, 0x76, 0x31, 0x2e, 0x43,
+	0x72, 0x61, 0x77, 0x6c, 0x54, 0x61, 0x72, 0x67, 0x65, 0x74, 0x52, 0x06, 0x74, 0x61, 0x72, 0x67,
+	0x65, 0x74, 0x22, 0x14, 0x0a, 0x12, 0x53, 0x74, 0x61, 0x72, 0x74, 0x43, 0x72, 0x61, 0x77, 0x6c,
+	0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x22, 0xf3, 0x01, 0x0a, 0x12, 0x52, 0x65, 0x70,
+	0x6f, 0x72, 0x74, 0x43, 0x72, 0x61, 0x77, 0x6c, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12,
+	0x2c, 0x0a, 0x06, 0x74, 0x61, 0x72, 0x67, 0x65, 0x74, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0b, 0x32,
+	0x14, 0x2e, 0x74, 0x6f, 0x70, 0x6b, 0x2e, 0x76, 0x31, 0x2e, 0x43, 0x72, 0x61, 0x77, 0x6c, 0x54,
+	0x61, 0x72, 0x67, 0x65, 0x74, 0x52, 0x06, 0x74, 0x61, 0x72, 0x67, 0x65, 0x74, 0x12, 0x0e, 0x0a,
+	0x02, 0x6f, 0x6b, 0x18, 0x02, 0x20, 0x01, 0x28, 0x08, 0x52, 0x02, 0x6f, 0x6b, 0x12, 0x14, 0x0a,
+	0x05, 0x65, 0x72, 0x72, 0x6f, 0x72, 0x18, 0x03, 0x20, 0x01, 0x28, 0x09, 0x52, 0x05, 0x65, 0x72,
+	0x72, 0x6f, 0x72, 0x12, 0x3e, 0x0a, 0x0d, 0x6e, 0x65, 0x78, 0x74, 0x5f, 0x63, 0x72, 0x61, 0x77,
+	0x6c, 0x5f, 0x61, 0x74, 0x18, 0x04, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x1a, 0x2e, 0x67, 0x6f, 0x6f,
+	0x67, 0x6c, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x54, 0x69, 0x6d,
+	0x65, 0x73, 0x74, 0x61, 0x6d, 0x70, 0x52, 0x0b, 0x6e, 0x65, 0x78, 0x74, 0x43, 0x72, 0x61, 0x77,
+	0x6c, 0x41, 0x74, 0x12, 0x16, 0x0a, 0x06, 0x65, 0x76, 0x65, 0x6e, 0x74, 0x73, 0x18, 0x05, 0x20,
+	0x01, 0x28, 0x05, 0x52, 0x06, 0x65, 0x76, 0x65, 0x6e, 0x74, 0x73, 0x12, 0x31, 0x0a, 0x06, 0x77,
+	0x69, 0x6e, 0x64, 0x6f, 0x77, 0x18, 0x06, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x19, 0x2e, 0x67, 0x6f,
+	0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x44, 0x75,
+	0x72, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x52, 0x06, 0x77, 0x69, 0x6e, 0x64, 0x6f, 0x77, 0x22, 0x55,
+	0x0a, 0x13, 0x52, 0x65, 0x70, 0x6f, 0x72, 0x74, 0x43, 0x72, 0x61, 0x77, 0x6c, 0x52, 0x65, 0x73,
+	0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12, 0x3e, 0x0a, 0x0d, 0x6e, 0x65, 0x78, 0x74, 0x5f, 0x63, 0x72,
+	0x61, 0x77, 0x6c, 0x5f, 0x61, 0x74, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x1a, 0x2e, 0x67,
+	0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x54,
+	0x69, 0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d, 0x70, 0x52, 0x0b, 0x6e, 0x65, 0x78, 0x74, 0x43, 0x72,
+	0x61, 0x77, 0x6c, 0x41, 0x74, 0x2a, 0x93, 0x01, 0x0a, 0x0b, 0x43, 0x72, 0x61, 0x77, 0x6c, 0x53,
+	0x74, 0x61, 0x74, 0x75, 0x73, 0x12, 0x1c, 0x0a, 0x18, 0x43, 0x52, 0x41, 0x57, 0x4c, 0x5f, 0x53,
+	0x54, 0x41, 0x54, 0x55, 0x53, 0x5f, 0x55, 0x4e, 0x53, 0x50, 0x45, 0x43, 0x49, 0x46, 0x49, 0x45,
+	0x44, 0x10, 0x00, 0x12, 0x15, 0x0a, 0x11, 0x43, 0x52, 0x41, 0x57, 0x4c, 0x5f, 0x53, 0x54, 0x41,
+	0x54, 0x55, 0x53, 0x5f, 0x49, 0x44, 0x4c, 0x45, 0x10, 0x01, 0x12, 0x19, 0x0a, 0x15, 0x43, 0x52,
+	0x41, 0x57, 0x4c, 0x5f, 0x53, 0x54, 0x41, 0x54, 0x55, 0x53, 0x5f, 0x45, 0x4e, 0x51, 0x55, 0x45,
+	0x55, 0x45, 0x44, 0x10, 0x02, 0x12, 0x18, 0x0a, 0x14, 0x43, 0x52, 0x41, 0x57, 0x4c, 0x5f, 0x53,
+	0x54, 0x41, 0x54, 0x55, 0x53, 0x5f, 0x52, 0x55, 0x4e, 0x4e, 0x49, 0x4e, 0x47, 0x10, 0x03, 0x12,
+	0x1a, 0x0a, 0x16, 0x43, 0x52, 0x41, 0x57, 0x4c, 0x5f, 0x53, 0x54, 0x41, 0x54, 0x55, 0x53, 0x5f,
+	0x53, 0x55, 0x53, 0x50, 0x45, 0x4e, 0x44, 0x45, 0x44, 0x10, 0x04, 0x32, 0xa2, 0x02, 0x0a, 0x0c,
+	0x55, 0x73, 0x65, 0x72, 0x52, 0x65, 0x67, 0x69, 0x73, 0x74, 0x72, 0x79, 0x12, 0x31, 0x0a, 0x07,
+	0x47, 0x65, 0x74, 0x55, 0x73, 0x65, 0x72, 0x12, 0x17, 0x2e, 0x74, 0x6f, 0x70, 0x6b, 0x2e, 0x76,
+	0x31, 0x2e, 0x47, 0x65, 0x74, 0x55, 0x73, 0x65, 0x72, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74,
+	0x1a, 0x0d, 0x2e, 0x74, 0x6f, 0x70, 0x6b, 0x2e, 0x76, 0x31, 0x2e, 0x55, 0x73, 0x65, 0x72, 0x12,
+	0x4e, 0x0a, 0x0d, 0x4c, 0x69, 0x73, 0x74, 0x44, 0x75, 0x65, 0x43, 0x72, 0x61, 0x77, 0x6c, 0x73,
+	0x12, 0x1d, 0x2e, 0x74, 0x6f, 0x70, 0x6b, 0x2e, 0x76, 0x31, 0x2e, 0x4c, 0x69, 0x73, 0x74, 0x44,
+	0x75, 0x65, 0x43, 0x72, 0x61, 0x77, 0x6c, 0x73, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a,
+	0x1e, 0x2e, 0x74, 0x6f, 0x70, 0x6b, 0x2e, 0x76, 0x31, 0x2e, 0x4c, 0x69, 0x73, 0x74, 0x44, 0x75,
+	0x65, 0x43, 0x72, 0x61, 0x77, 0x6c, 0x73, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12,
+	0x45, 0x0a, 0x0a, 0x53, 0x74, 0x61, 0x72, 0x74, 0x43, 0x72, 0x61, 0x77, 0x6c, 0x12, 0x1a, 0x2e,
+	0x74, 0x6f, 0x70, 0x6b, 0x2e, 0x76, 0x31, 0x2e, 0x53, 0x74, 0x61, 0x72, 0x74, 0x43, 0x72, 0x61,
+	0x77, 0x6c, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x1b, 0x2e, 0x74, 0x6f, 0x70, 0x6b,
+	0x2e, 0x76, 0x31, 0x2e, 0x53, 0x74, 0x61, 0x72, 0x74, 0x43, 0x72, 0x61, 0x77, 0x6c, 0x52, 0x65,
+	0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12, 0x48, 0x0a, 0x0b, 0x52, 0x65, 0x70, 0x6f, 0x72, 0x74,
+	0x43, 0x72, 0x61, 0x77, 0x6c, 0x12, 0x1b, 0x2e, 0x74, 0x6f, 0x70, 0x6b, 0x2e, 0x76, 0x31, 0x2e,
+	0x52, 0x65, 0x70, 0x6f, 0x72, 0x74, 0x43, 0x72, 0x61, 0x77, 0x6c, 0x52, 0x65, 0x71, 0x75, 0x65,
+	0x73, 0x74, 0x1a, 0x1c, 0x2e, 0x74, 0x6f, 0x70, 0x6b, 0x2e, 0x76, 0x31, 0x2e, 0x52, 0x65, 0x70,
+	0x6f, 0x72, 0x74, 0x43, 0x72, 0x61, 0x77, 0x6c, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65,
+	0x42, 0x35, 0x5a, 0x33, 0x67, 0x69, 0x74, 0x68, 0x75, 0x62, 0x2e, 0x63, 0x6f, 0x6d, 0x2f, 0x73,
+	0x79, 0x73, 0x74, 0x65, 0x6d, 0x2d, 0x64, 0x65, 0x73, 0x69, 0x67, 0x6e, 0x2d, 0x6c, 0x61, 0x62,
+	0x2f, 0x70, 0x6b, 0x67, 0x2f, 0x67, 0x65, 0x6e, 0x2f, 0x74, 0x6f, 0x70, 0x6b, 0x2f, 0x76, 0x31,
+	0x3b, 0x74, 0x6f, 0x70, 0x6b, 0x76, 0x31, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
+}
+
+var (
+	file_topk_v1_registry_proto_rawDescOnce sync.Once
+	file_topk_v1_registry_proto_rawDescData = file_topk_v1_registry_proto_rawDesc
+)
+
+func file_topk_v1_registry_proto_rawDescGZIP() []byte {
+	file_topk_v1_registry_proto_rawDescOnce.Do(func() {
+		file_topk_v1_registry_proto_rawDescData = protoimpl.X.CompressGZIP(file_topk_v1_registry_proto_rawDescData)
+	})
+	return file_topk_v1_registry_proto_rawDescData
+}
+
+var file_topk_v1_registry_proto_enumTypes = make([]protoimpl.EnumInfo, 1)
+var file_topk_v1_registry_proto_msgTypes = make([]protoimpl.MessageInfo, 10)
+var file_topk_v1_registry_proto_goTypes = []any{
+	(CrawlStatus)(0),              // 0: topk.v1.CrawlStatus
+	(*User)(nil),                  // 1: topk.v1.User
+	(*Provider)(nil),              // 2: topk.v1.Provider
+	(*GetUserRequest)(nil),        // 3: topk.v1.GetUserRequest
+	(*ListDueCrawlsRequest)(nil),  // 4: topk.v1.ListDueCrawlsRequest
+	(*ListDueCrawlsResponse)(nil), // 5: topk.v1.ListDueCrawlsResponse
+	(*CrawlTarget)(nil),           // 6: topk.v1.CrawlTarget
+	(*StartCrawlRequest)(nil),     // 7: topk.v1.StartCrawlRequest
+	(*StartCrawlResponse)(nil),    // 8: topk.v1.StartCrawlResponse
+	(*ReportCrawlRequest)(nil),    // 9: topk.v1.ReportCrawlRequest
+	(*ReportCrawlResponse)(nil),   // 10: topk.v1.ReportCrawlResponse
+	(*timestamppb.Timestamp)(nil), // 11: google.protobuf.Timestamp
+	(*durationpb.Duration)(nil),   // 12: google.protobuf.Duration
+}
+var file_topk_v1_registry_proto_depIdxs = []int32{
+	2,  // 0: topk.v1.User.providers:type_name -> topk.v1.Provider
+	11, // 1: topk.v1.User.created_at:type_name -> google.protobuf.Timestamp
+	11, // 2: topk.v1.User.deleted_at:type_name -> google.protobuf.Timestamp
+	0,  // 3: topk.v1.Provider.status:type_name -> topk.v1.CrawlStatus
+	11, // 4: topk.v1.Provider.next_crawl_at:type_name -> google.protobuf.Timestamp
+	6,  // 5: topk.v1.ListDueCrawlsResponse.targets:type_name -> topk.v1.CrawlTarget
+	6,  // 6: topk.v1.StartCrawlRequest.target:type_name -> topk.v1.CrawlTarget
+	6,  // 7: topk.v1.ReportCrawlRequest.target:type_name -> topk.v1.CrawlTarget
+	11, // 8: topk.v1.ReportCrawlRequest.next_crawl_at:type_name -> google.protobuf.Timestamp
+	12, // 9: topk.v1.ReportCrawlRequest.window:type_name -> google.protobuf.Duration
+	11, // 10: topk.v1.ReportCrawlResponse.next_crawl_at:type_name -> google.protobuf.Timestamp
+	3,  // 11: topk.v1.UserRegistry.GetUser:input_type -> topk.v1.GetUserRequest
+	4,  // 12: topk.v1.UserRegistry.ListDueCrawls:input_type -> topk.v1.ListDueCrawlsRequest
+	7,  // 13: topk.v1.UserRegistry.StartCrawl:input_type -> topk.v1.StartCrawlRequest
+	9,  // 14: topk.v1.UserRegistry.ReportCrawl:input_type -> topk.v1.ReportCrawlRequest
+	1,  // 15: topk.v1.UserRegistry.GetUser:output_type -> topk.v1.User
+	5,  // 16: topk.v1.UserRegistry.ListDueCrawls:output_type -> topk.v1.ListDueCrawlsResponse
+	8,  // 17: topk.v1.UserRegistry.StartCrawl:output_type -> topk.v1.StartCrawlResponse
+	10, // 18: topk.v1.UserRegistry.ReportCrawl:output_type -> topk.v1.ReportCrawlResponse
+	15, // [15:19] is the sub-list for method output_type
+	11, // [11:15] is the sub-list for method input_type
+	11, // [11:11] is the sub-list for extension type_name
+	11, // [11:11] is the sub-list for extension extendee
+	0,  // [0:11] is the sub-list for field type_name
+}
+
+func init() { file_topk_v1_registry_proto_init() }
+func file_topk_v1_registry_proto_init() {
+	if File_topk_v1_registry_proto != nil {
+		return
+	}
+	if !protoimpl.UnsafeEnabled {
+		file_topk_v1_registry_proto_msgTypes[0].Exporter = func(v any, i int) any {
+			switch v := v.(*User); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_topk_v1_registry_proto_msgTypes[1].Exporter = func(v any, i int) any {
+			switch v := v.(*Provider); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_topk_v1_registry_proto_msgTypes[2].Exporter = func(v any, i int) any {
+			switch v := v.(*GetUserRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_topk_v1_registry_proto_msgTypes[3].Exporter = func(v any, i int) any {
+			switch v := v.(*ListDueCrawlsRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_topk_v1_registry_proto_msgTypes[4].Exporter = func(v any, i int) any {
+			switch v := v.(*ListDueCrawlsResponse); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_topk_v1_registry_proto_msgTypes[5].Exporter = func(v any, i int) any {
+			switch v := v.(*CrawlTarget); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_topk_v1_registry_proto_msgTypes[6].Exporter = func(v any, i int) any {
+			switch v := v.(*StartCrawlRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_topk_v1_registry_proto_msgTypes[7].Exporter = func(v any, i int) any {
+			switch v := v.(*StartCrawlResponse); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_topk_v1_registry_proto_msgTypes[8].Exporter = func(v any, i int) any {
+			switch v := v.(*ReportCrawlRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_topk_v1_registry_proto_msgTypes[9].Exporter = func(v any, i int) any {
+			switch v := v.(*ReportCrawlResponse); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: file_topk_v1_registry_proto_rawDesc,
+			NumEnums:      1,
+			NumMessages:   10,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_topk_v1_registry_proto_goTypes,
+		DependencyIndexes: file_topk_v1_registry_proto_depIdxs,
+		EnumInfos:         file_topk_v1_registry_proto_enumTypes,
+		MessageInfos:      file_topk_v1_registry_proto_msgTypes,
+	}.Build()
+	File_topk_v1_registry_proto = out.File
+	file_topk_v1_registry_proto_rawDesc = nil
+	file_topk_v1_registry_proto_goTypes = nil
+	file_topk_v1_registry_proto_depIdxs = nil
+}
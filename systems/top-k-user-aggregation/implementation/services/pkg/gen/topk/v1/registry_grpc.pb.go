@@ -0,0 +1,246 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.4.0
+// - protoc             (unknown)
+// source: topk/v1/registry.proto
+
+package topkv1
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.62.0 or later.
+const _ = grpc.SupportPackageIsVersion8
+
+const (
+	UserRegistry_GetUser_FullMethodName       = "/topk.v1.UserRegistry/GetUser"
+	UserRegistry_ListDueCrawls_FullMethodName = "/topk.v1.UserRegistry/ListDueCrawls"
+	UserRegistry_StartCrawl_FullMethodName    = "/topk.v1.UserRegistry/StartCrawl"
+	UserRegistry_ReportCrawl_FullMethodName   = "/topk.v1.UserRegistry/ReportCrawl"
+)
+
+// UserRegistryClient is the client API for UserRegistry service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// UserRegistry owns users and their connected providers. crawl-scheduler
+// serves it over user_crawl_schedule in Postgres; crawl-worker reports its
+// crawls to it instead of writing the table itself.
+type UserRegistryClient interface {
+	// GetUser returns a user and the providers they have connected.
+	// NOT_FOUND if the user doesn't exist.
+	GetUser(ctx context.Context, in *GetUserRequest, opts ...grpc.CallOption) (*User, error)
+	// ListDueCrawls claims up to limit (user, provider) pairs whose next crawl
+	// is due, moving them to ENQUEUED. Claims are exclusive across callers.
+	ListDueCrawls(ctx context.Context, in *ListDueCrawlsRequest, opts ...grpc.CallOption) (*ListDueCrawlsResponse, error)
+	// StartCrawl moves a claimed pair to RUNNING. NOT_FOUND if the pair isn't
+	// in the registry.
+	StartCrawl(ctx context.Context, in *StartCrawlRequest, opts ...grpc.CallOption) (*StartCrawlResponse, error)
+	// ReportCrawl records the outcome of a crawl and schedules the next one.
+	ReportCrawl(ctx context.Context, in *ReportCrawlRequest, opts ...grpc.CallOption) (*ReportCrawlResponse, error)
+}
+
+type userRegistryClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewUserRegistryClient(cc grpc.ClientConnInterface) UserRegistryClient {
+	return &userRegistryClient{cc}
+}
+
+func (c *userRegistryClient) GetUser(ctx context.Context, in *GetUserRequest, opts ...grpc.CallOption) (*User, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(User)
+	err := c.cc.Invoke(ctx, UserRegistry_GetUser_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *userRegistryClient) ListDueCrawls(ctx context.Context, in *ListDueCrawlsRequest, opts ...grpc.CallOption) (*ListDueCrawlsResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListDueCrawlsResponse)
+	err := c.cc.Invoke(ctx, UserRegistry_ListDueCrawls_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *userRegistryClient) StartCrawl(ctx context.Context, in *StartCrawlRequest, opts ...grpc.CallOption) (*StartCrawlResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(StartCrawlResponse)
+	err := c.cc.Invoke(ctx, UserRegistry_StartCrawl_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *userRegistryClient) ReportCrawl(ctx context.Context, in *ReportCrawlRequest, opts ...grpc.CallOption) (*ReportCrawlResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ReportCrawlResponse)
+	err := c.cc.Invoke(ctx, UserRegistry_ReportCrawl_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// UserRegistryServer is the server API for UserRegistry service.
+// All implementations must embed UnimplementedUserRegistryServer
+// for forward compatibility
+//
+// UserRegistry owns users and their connected providers. crawl-scheduler
+// serves it over user_crawl_schedule in Postgres; crawl-worker reports its
+// crawls to it instead of writing the table itself.
+type UserRegistryServer interface {
+	// GetUser returns a user and the providers they have connected.
+	// NOT_FOUND if the user doesn't exist.
+	GetUser(context.Context, *GetUserRequest) (*User, error)
+	// ListDueCrawls claims up to limit (user, provider) pairs whose next crawl
+	// is due, moving them to ENQUEUED. Claims are exclusive across callers.
+	ListDueCrawls(context.Context, *ListDueCrawlsRequest) (*ListDueCrawlsResponse, error)
+	// StartCrawl moves a claimed pair to RUNNING. NOT_FOUND if the pair isn't
+	// in the registry.
+	StartCrawl(context.Context, *StartCrawlRequest) (*StartCrawlResponse, error)
+	// ReportCrawl records the outcome of a crawl and schedules the next one.
+	ReportCrawl(context.Context, *ReportCrawlRequest) (*ReportCrawlResponse, error)
+	mustEmbedUnimplementedUserRegistryServer()
+}
+
+// UnimplementedUserRegistryServer must be embedded to have forward compatible implementations.
+type UnimplementedUserRegistryServer struct {
+}
+
+func (UnimplementedUserRegistryServer) GetUser(context.Context, *GetUserRequest) (*User, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetUser not implemented")
+}
+func (UnimplementedUserRegistryServer) ListDueCrawls(context.Context, *ListDueCrawlsRequest) (*ListDueCrawlsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListDueCrawls not implemented")
+}
+func (UnimplementedUserRegistryServer) StartCrawl(context.Context, *StartCrawlRequest) (*StartCrawlResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method StartCrawl not implemented")
+}
+func (UnimplementedUserRegistryServer) ReportCrawl(context.Context, *ReportCrawlRequest) (*ReportCrawlResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ReportCrawl not implemented")
+}
+func (UnimplementedUserRegistryServer) mustEmbedUnimplementedUserRegistryServer() {}
+
+// UnsafeUserRegistryServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to UserRegistryServer will
+// result in compilation errors.
+type UnsafeUserRegistryServer interface {
+	mustEmbedUnimplementedUserRegistryServer()
+}
+
+func RegisterUserRegistryServer(s grpc.ServiceRegistrar, srv UserRegistryServer) {
+	s.RegisterService(&UserRegistry_ServiceDesc, srv)
+}
+
+func _UserRegistry_GetUser_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetUserRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UserRegistryServer).GetUser(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: UserRegistry_GetUser_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UserRegistryServer).GetUser(ctx, req.(*GetUserRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _UserRegistry_ListDueCrawls_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListDueCrawlsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UserRegistryServer).ListDueCrawls(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: UserRegistry_ListDueCrawls_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UserRegistryServer).ListDueCrawls(ctx, req.(*ListDueCrawlsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _UserRegistry_StartCrawl_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(StartCrawlRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UserRegistryServer).StartCrawl(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: UserRegistry_StartCrawl_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UserRegistryServer).StartCrawl(ctx, req.(*StartCrawlRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _UserRegistry_ReportCrawl_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ReportCrawlRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UserRegistryServer).ReportCrawl(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: UserRegistry_ReportCrawl_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UserRegistryServer).ReportCrawl(ctx, req.(*ReportCrawlRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// UserRegistry_ServiceDesc is the grpc.ServiceDesc for UserRegistry service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var UserRegistry_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "topk.v1.UserRegistry",
+	HandlerType: (*UserRegistryServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "GetUser",
+			Handler:    _UserRegistry_GetUser_Handler,
+		},
+		{
+			MethodName: "ListDueCrawls",
+			Handler:    _UserRegistry_ListDueCrawls_Handler,
+		},
+		{
+			MethodName: "StartCrawl",
+			Handler:    _UserRegistry_StartCrawl_Handler,
+		},
+		{
+			MethodName: "ReportCrawl",
+			Handler:    _UserRegistry_ReportCrawl_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "topk/v1/registry.proto",
+}
@@ -0,0 +1,194 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.4.0
+// - protoc             (unknown)
+// source: topk/v1/snapshot.proto
+
+package topkv1
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.62.0 or later.
+const _ = grpc.SupportPackageIsVersion8
+
+const (
+	SnapshotReader_GetSnapshot_FullMethodName   = "/topk.v1.SnapshotReader/GetSnapshot"
+	SnapshotReader_ListSnapshots_FullMethodName = "/topk.v1.SnapshotReader/ListSnapshots"
+)
+
+// SnapshotReaderClient is the client API for SnapshotReader service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// SnapshotReader serves precomputed top-K lists (user_topk_snapshot, kept by
+// the materializer), so readers other than the api-server don't query Cassandra
+// themselves.
+type SnapshotReaderClient interface {
+	// GetSnapshot returns a user's snapshot for a window. NOT_FOUND if there is
+	// none; a snapshot computed before today (UTC) is returned with stale set.
+	GetSnapshot(ctx context.Context, in *GetSnapshotRequest, opts ...grpc.CallOption) (*Snapshot, error)
+	// ListSnapshots streams every user's snapshot of a window, for batch jobs
+	// (the recommender's index). It reads the whole table: not for the request
+	// path.
+	ListSnapshots(ctx context.Context, in *ListSnapshotsRequest, opts ...grpc.CallOption) (SnapshotReader_ListSnapshotsClient, error)
+}
+
+type snapshotReaderClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewSnapshotReaderClient(cc grpc.ClientConnInterface) SnapshotReaderClient {
+	return &snapshotReaderClient{cc}
+}
+
+func (c *snapshotReaderClient) GetSnapshot(ctx context.Context, in *GetSnapshotRequest, opts ...grpc.CallOption) (*Snapshot, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Snapshot)
+	err := c.cc.Invoke(ctx, SnapshotReader_GetSnapshot_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *snapshotReaderClient) ListSnapshots(ctx context.Context, in *ListSnapshotsRequest, opts ...grpc.CallOption) (SnapshotReader_ListSnapshotsClient, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &SnapshotReader_ServiceDesc.Streams[0], SnapshotReader_ListSnapshots_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &snapshotReaderListSnapshotsClient{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type SnapshotReader_ListSnapshotsClient interface {
+	Recv() (*Snapshot, error)
+	grpc.ClientStream
+}
+
+type snapshotReaderListSnapshotsClient struct {
+	grpc.ClientStream
+}
+
+func (x *snapshotReaderListSnapshotsClient) Recv() (*Snapshot, error) {
+	m := new(Snapshot)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// SnapshotReaderServer is the server API for SnapshotReader service.
+// All implementations must embed UnimplementedSnapshotReaderServer
+// for forward compatibility
+//
+// SnapshotReader serves precomputed top-K lists (user_topk_snapshot, kept by
+// the materializer), so readers other than the api-server don't query Cassandra
+// themselves.
+type SnapshotReaderServer interface {
+	// GetSnapshot returns a user's snapshot for a window. NOT_FOUND if there is
+	// none; a snapshot computed before today (UTC) is returned with stale set.
+	GetSnapshot(context.Context, *GetSnapshotRequest) (*Snapshot, error)
+	// ListSnapshots streams every user's snapshot of a window, for batch jobs
+	// (the recommender's index). It reads the whole table: not for the request
+	// path.
+	ListSnapshots(*ListSnapshotsRequest, SnapshotReader_ListSnapshotsServer) error
+	mustEmbedUnimplementedSnapshotReaderServer()
+}
+
+// UnimplementedSnapshotReaderServer must be embedded to have forward compatible implementations.
+type UnimplementedSnapshotReaderServer struct {
+}
+
+func (UnimplementedSnapshotReaderServer) GetSnapshot(context.Context, *GetSnapshotRequest) (*Snapshot, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetSnapshot not implemented")
+}
+func (UnimplementedSnapshotReaderServer) ListSnapshots(*ListSnapshotsRequest, SnapshotReader_ListSnapshotsServer) error {
+	return status.Errorf(codes.Unimplemented, "method ListSnapshots not implemented")
+}
+func (UnimplementedSnapshotReaderServer) mustEmbedUnimplementedSnapshotReaderServer() {}
+
+// UnsafeSnapshotReaderServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to SnapshotReaderServer will
+// result in compilation errors.
+type UnsafeSnapshotReaderServer interface {
+	mustEmbedUnimplementedSnapshotReaderServer()
+}
+
+func RegisterSnapshotReaderServer(s grpc.ServiceRegistrar, srv SnapshotReaderServer) {
+	s.RegisterService(&SnapshotReader_ServiceDesc, srv)
+}
+
+func _SnapshotReader_GetSnapshot_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetSnapshotRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SnapshotReaderServer).GetSnapshot(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: SnapshotReader_GetSnapshot_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SnapshotReaderServer).GetSnapshot(ctx, req.(*GetSnapshotRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _SnapshotReader_ListSnapshots_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(ListSnapshotsRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(SnapshotReaderServer).ListSnapshots(m, &snapshotReaderListSnapshotsServer{ServerStream: stream})
+}
+
+type SnapshotReader_ListSnapshotsServer interface {
+	Send(*Snapshot) error
+	grpc.ServerStream
+}
+
+type snapshotReaderListSnapshotsServer struct {
+	grpc.ServerStream
+}
+
+func (x *snapshotReaderListSnapshotsServer) Send(m *Snapshot) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+// SnapshotReader_ServiceDesc is the grpc.ServiceDesc for SnapshotReader service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var SnapshotReader_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "topk.v1.SnapshotReader",
+	HandlerType: (*SnapshotReaderServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "GetSnapshot",
+			Handler:    _SnapshotReader_GetSnapshot_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "ListSnapshots",
+			Handler:       _SnapshotReader_ListSnapshots_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "topk/v1/snapshot.proto",
+}
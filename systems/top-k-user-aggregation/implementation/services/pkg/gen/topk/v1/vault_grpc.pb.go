@@ -0,0 +1,162 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.4.0
+// - protoc             (unknown)
+// source: topk/v1/vault.proto
+
+package topkv1
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.62.0 or later.
+const _ = grpc.SupportPackageIsVersion8
+
+const (
+	TokenVault_GetToken_FullMethodName    = "/topk.v1.TokenVault/GetToken"
+	TokenVault_RevokeToken_FullMethodName = "/topk.v1.TokenVault/RevokeToken"
+)
+
+// TokenVaultClient is the client API for TokenVault service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// TokenVault holds provider OAuth tokens so crawl-worker never stores them.
+// Refresh happens inside the vault: callers always get a usable token.
+type TokenVaultClient interface {
+	// GetToken returns a valid access token for a user's provider, refreshing
+	// it first if it expires within min_validity_seconds. NOT_FOUND if the user
+	// never connected the provider; FAILED_PRECONDITION if the grant was revoked.
+	GetToken(ctx context.Context, in *GetTokenRequest, opts ...grpc.CallOption) (*Token, error)
+	// RevokeToken forgets a user's provider grant.
+	RevokeToken(ctx context.Context, in *RevokeTokenRequest, opts ...grpc.CallOption) (*RevokeTokenResponse, error)
+}
+
+type tokenVaultClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewTokenVaultClient(cc grpc.ClientConnInterface) TokenVaultClient {
+	return &tokenVaultClient{cc}
+}
+
+func (c *tokenVaultClient) GetToken(ctx context.Context, in *GetTokenRequest, opts ...grpc.CallOption) (*Token, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Token)
+	err := c.cc.Invoke(ctx, TokenVault_GetToken_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *tokenVaultClient) RevokeToken(ctx context.Context, in *RevokeTokenRequest, opts ...grpc.CallOption) (*RevokeTokenResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(RevokeTokenResponse)
+	err := c.cc.Invoke(ctx, TokenVault_RevokeToken_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// TokenVaultServer is the server API for TokenVault service.
+// All implementations must embed UnimplementedTokenVaultServer
+// for forward compatibility
+//
+// TokenVault holds provider OAuth tokens so crawl-worker never stores them.
+// Refresh happens inside the vault: callers always get a usable token.
+type TokenVaultServer interface {
+	// GetToken returns a valid access token for a user's provider, refreshing
+	// it first if it expires within min_validity_seconds. NOT_FOUND if the user
+	// never connected the provider; FAILED_PRECONDITION if the grant was revoked.
+	GetToken(context.Context, *GetTokenRequest) (*Token, error)
+	// RevokeToken forgets a user's provider grant.
+	RevokeToken(context.Context, *RevokeTokenRequest) (*RevokeTokenResponse, error)
+	mustEmbedUnimplementedTokenVaultServer()
+}
+
+// UnimplementedTokenVaultServer must be embedded to have forward compatible implementations.
+type UnimplementedTokenVaultServer struct {
+}
+
+func (UnimplementedTokenVaultServer) GetToken(context.Context, *GetTokenRequest) (*Token, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetToken not implemented")
+}
+func (UnimplementedTokenVaultServer) RevokeToken(context.Context, *RevokeTokenRequest) (*RevokeTokenResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RevokeToken not implemented")
+}
+func (UnimplementedTokenVaultServer) mustEmbedUnimplementedTokenVaultServer() {}
+
+// UnsafeTokenVaultServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to TokenVaultServer will
+// result in compilation errors.
+type UnsafeTokenVaultServer interface {
+	mustEmbedUnimplementedTokenVaultServer()
+}
+
+func RegisterTokenVaultServer(s grpc.ServiceRegistrar, srv TokenVaultServer) {
+	s.RegisterService(&TokenVault_ServiceDesc, srv)
+}
+
+func _TokenVault_GetToken_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetTokenRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TokenVaultServer).GetToken(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: TokenVault_GetToken_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TokenVaultServer).GetToken(ctx, req.(*GetTokenRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TokenVault_RevokeToken_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RevokeTokenRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TokenVaultServer).RevokeToken(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: TokenVault_RevokeToken_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TokenVaultServer).RevokeToken(ctx, req.(*RevokeTokenRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// TokenVault_ServiceDesc is the grpc.ServiceDesc for TokenVault service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var TokenVault_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "topk.v1.TokenVault",
+	HandlerType: (*TokenVaultServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "GetToken",
+			Handler:    _TokenVault_GetToken_Handler,
+		},
+		{
+			MethodName: "RevokeToken",
+			Handler:    _TokenVault_RevokeToken_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "topk/v1/vault.proto",
+}
@@ -0,0 +1,233 @@
+// Package grpcutil dials and serves the topk.v1 services (pkg/gen/topk/v1)
+// the way proto/README.md asks: a deadline on every call, retries with
+// backoff of the calls that are safe to repeat, and errors as status codes.
+package grpcutil
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	metricClientCalls  = expvar.NewMap("grpc_client_calls")  // by method
+	metricClientErrors = expvar.NewMap("grpc_client_errors") // by method.code
+	metricServerCalls  = expvar.NewMap("grpc_server_calls")  // by method
+	metricServerErrors = expvar.NewMap("grpc_server_errors") // by method.code
+)
+
+// retryableCodes are the codes a retried call is tried again on, as the
+// service config spells them. The documented outcomes of an RPC
+// (NOT_FOUND, FAILED_PRECONDITION, ...) are answers, not failures, and
+// aren't retried.
+var retryableCodes = []string{"UNAVAILABLE", "RESOURCE_EXHAUSTED", "ABORTED"}
+
+// Config is a client's connection to one service
+type Config struct {
+	Addr    string
+	Timeout time.Duration // of a unary call whose context has no deadline
+	// MaxAttempts bounds the tries of a retried call, the first included
+	// (1 = no retries; gRPC caps it at 5), with a backoff growing from
+	// InitialBackoff to MaxBackoff between them
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+}
+
+// ConfigFromEnv returns the settings of a client of the service at
+// <prefix>_ADDR (addr if unset) from <prefix>_TIMEOUT (2s),
+// <prefix>_MAX_ATTEMPTS (4), <prefix>_INITIAL_BACKOFF (100ms) and
+// <prefix>_MAX_BACKOFF (2s). An empty address means no client.
+func ConfigFromEnv(prefix, addr string) (Config, error) {
+	cfg := Config{
+		Addr:           addr,
+		Timeout:        2 * time.Second,
+		MaxAttempts:    4,
+		InitialBackoff: 100 * time.Millisecond,
+		MaxBackoff:     2 * time.Second,
+	}
+	if v := os.Getenv(prefix + "_ADDR"); v != "" {
+		cfg.Addr = v
+	}
+	if v := os.Getenv(prefix + "_MAX_ATTEMPTS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 5 {
+			return cfg, fmt.Errorf("invalid %s_MAX_ATTEMPTS %q (want 1-5)", prefix, v)
+		}
+		cfg.MaxAttempts = n
+	}
+	for key, p := range map[string]*time.Duration{
+		prefix + "_TIMEOUT":         &cfg.Timeout,
+		prefix + "_INITIAL_BACKOFF": &cfg.InitialBackoff,
+		prefix + "_MAX_BACKOFF":     &cfg.MaxBackoff,
+	} {
+		if v := os.Getenv(key); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil || d <= 0 {
+				return cfg, fmt.Errorf("invalid %s %q", key, v)
+			}
+			*p = d
+		}
+	}
+	if cfg.InitialBackoff > cfg.MaxBackoff {
+		return cfg, fmt.Errorf("%s_INITIAL_BACKOFF %s is more than %s_MAX_BACKOFF %s", prefix, cfg.InitialBackoff, prefix, cfg.MaxBackoff)
+	}
+	return cfg, nil
+}
+
+func (cfg Config) String() string {
+	return fmt.Sprintf("%s timeout=%s attempts=%d backoff=%s-%s", cfg.Addr, cfg.Timeout, cfg.MaxAttempts, cfg.InitialBackoff, cfg.MaxBackoff)
+}
+
+// Dial returns a client connection for cfg. retried names the methods that
+// are safe to repeat, as "topk.v1.UserRegistry/GetUser", or a whole service
+// as "topk.v1.SnapshotReader"; the others are tried once. A retried stream
+// is only retried until its first response.
+func (cfg Config) Dial(retried ...string) (*grpc.ClientConn, error) {
+	if cfg.Addr == "" {
+		return nil, errors.New("no address")
+	}
+	serviceConfig, err := cfg.serviceConfig(retried)
+	if err != nil {
+		return nil, err
+	}
+	return grpc.NewClient(cfg.Addr,
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithDefaultServiceConfig(serviceConfig),
+		grpc.WithChainUnaryInterceptor(cfg.unaryDeadline, countUnary),
+		grpc.WithChainStreamInterceptor(countStream),
+	)
+}
+
+// serviceConfig is the gRPC service config retrying the retried methods
+func (cfg Config) serviceConfig(retried []string) (string, error) {
+	type name struct {
+		Service string `json:"service"`
+		Method  string `json:"method,omitempty"`
+	}
+	var names []name
+	for _, m := range retried {
+		service, method, _ := strings.Cut(m, "/")
+		names = append(names, name{Service: service, Method: method})
+	}
+	methodConfig := []map[string]interface{}{}
+	if len(names) > 0 && cfg.MaxAttempts > 1 {
+		methodConfig = append(methodConfig, map[string]interface{}{
+			"name": names,
+			"retryPolicy": map[string]interface{}{
+				"maxAttempts":          cfg.MaxAttempts,
+				"initialBackoff":       fmt.Sprintf("%.3fs", cfg.InitialBackoff.Seconds()),
+				"maxBackoff":           fmt.Sprintf("%.3fs", cfg.MaxBackoff.Seconds()),
+				"backoffMultiplier":    2,
+				"retryableStatusCodes": retryableCodes,
+			},
+		})
+	}
+	b, err := json.Marshal(map[string]interface{}{"methodConfig": methodConfig})
+	return string(b), err
+}
+
+// unaryDeadline gives a call without a deadline cfg.Timeout; the retries of
+// a call share its deadline
+func (cfg Config) unaryDeadline(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	if _, ok := ctx.Deadline(); !ok && cfg.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cfg.Timeout)
+		defer cancel()
+	}
+	return invoker(ctx, method, req, reply, cc, opts...)
+}
+
+func countUnary(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	err := invoker(ctx, method, req, reply, cc, opts...)
+	count(metricClientCalls, metricClientErrors, method, err)
+	return err
+}
+
+func countStream(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	s, err := streamer(ctx, desc, cc, method, opts...)
+	count(metricClientCalls, metricClientErrors, method, err)
+	return s, err
+}
+
+// count adds a call of method ("/topk.v1.UserRegistry/GetUser") to calls
+// as UserRegistry.GetUser, and a failed one to errs by code
+func count(calls, errs *expvar.Map, method string, err error) {
+	service, rpc, _ := strings.Cut(strings.TrimPrefix(method, "/"), "/")
+	key := service[strings.LastIndex(service, ".")+1:] + "." + rpc
+	calls.Add(key, 1)
+	if err != nil {
+		errs.Add(key+"."+status.Code(err).String(), 1)
+	}
+}
+
+// NewServer returns a server counting its calls (grpc_server_calls and
+// grpc_server_errors) with the standard health service registered
+func NewServer() *grpc.Server {
+	srv := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+			resp, err := handler(ctx, req)
+			count(metricServerCalls, metricServerErrors, info.FullMethod, err)
+			return resp, err
+		}),
+		grpc.ChainStreamInterceptor(func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+			err := handler(srv, ss)
+			count(metricServerCalls, metricServerErrors, info.FullMethod, err)
+			return err
+		}),
+	)
+	healthpb.RegisterHealthServer(srv, health.NewServer())
+	return srv
+}
+
+// Serve serves srv on addr until ctx is done, then stops it gracefully,
+// waiting up to 5s for calls in flight
+func Serve(ctx context.Context, srv *grpc.Server, addr string) error {
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	go func() {
+		<-ctx.Done()
+		done := make(chan struct{})
+		go func() {
+			srv.GracefulStop()
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			srv.Stop()
+		}
+	}()
+	log.Printf("gRPC listening on %s", addr)
+	return srv.Serve(lis)
+}
+
+// StoreError is the status of a failed read or write of a server's store:
+// UNAVAILABLE, which clients retry, unless the call itself was cancelled or
+// ran out of time
+func StoreError(ctx context.Context, err error) error {
+	switch {
+	case errors.Is(ctx.Err(), context.Canceled):
+		return status.Error(codes.Canceled, err.Error())
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		return status.Error(codes.DeadlineExceeded, err.Error())
+	}
+	return status.Error(codes.Unavailable, err.Error())
+}
@@ -0,0 +1,102 @@
+// Package idgen generates snowflake-style IDs: 64 bits made of 41 bits of
+// milliseconds since Epoch, a 10-bit worker ID and a 12-bit sequence. IDs
+// from one generator strictly increase, so they sort by creation time, and
+// IDs from different workers never collide as long as no two live processes
+// share a worker ID (see Lease).
+package idgen
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	workerBits = 10
+	seqBits    = 12
+
+	// MaxWorker is the highest worker ID
+	MaxWorker = 1<<workerBits - 1
+	maxSeq    = 1<<seqBits - 1
+)
+
+// Epoch is time zero of the IDs; 41 bits of milliseconds last until 2093
+var Epoch = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// Generator hands out IDs for one worker ID. It is safe for concurrent use.
+type Generator struct {
+	worker int64
+
+	mu     sync.Mutex
+	lastMs int64
+	seq    int64
+	now    func() time.Time
+}
+
+// New returns a generator for worker (0-MaxWorker)
+func New(worker int64) (*Generator, error) {
+	if worker < 0 || worker > MaxWorker {
+		return nil, fmt.Errorf("worker ID %d out of range 0-%d", worker, MaxWorker)
+	}
+	return &Generator{worker: worker, lastMs: -1, now: time.Now}, nil
+}
+
+// Worker returns the generator's worker ID
+func (g *Generator) Worker() int64 { return g.worker }
+
+// Next returns a new ID. Up to 4096 IDs fit in a millisecond; past that, and
+// whenever the clock steps back, the generator runs on from the last
+// millisecond it used rather than ever repeating one.
+func (g *Generator) Next() int64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	ms := g.now().Sub(Epoch).Milliseconds()
+	if ms < g.lastMs {
+		ms = g.lastMs // clock went back
+	}
+	if ms == g.lastMs {
+		g.seq++
+		if g.seq > maxSeq {
+			ms++ // borrow the next millisecond
+			g.seq = 0
+		}
+	} else {
+		g.seq = 0
+	}
+	g.lastMs = ms
+	return ms<<(workerBits+seqBits) | g.worker<<seqBits | g.seq
+}
+
+// NextString is Format(Next())
+func (g *Generator) NextString() string {
+	return Format(g.Next())
+}
+
+// Format renders id as 16 hex digits, so string order is ID order
+func Format(id int64) string {
+	return fmt.Sprintf("%016x", uint64(id))
+}
+
+// Parse reads an ID written by Format
+func Parse(s string) (int64, error) {
+	if len(s) != 16 {
+		return 0, fmt.Errorf("invalid ID %q: want 16 hex digits", s)
+	}
+	u, err := strconv.ParseUint(s, 16, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid ID %q: %w", s, err)
+	}
+	return int64(u), nil
+}
+
+// Time returns when id was generated (to the millisecond)
+func Time(id int64) time.Time {
+	return Epoch.Add(time.Duration(id>>(workerBits+seqBits)) * time.Millisecond)
+}
+
+// WorkerOf returns the worker ID that generated id
+func WorkerOf(id int64) int64 {
+	return id >> seqBits & MaxWorker
+}
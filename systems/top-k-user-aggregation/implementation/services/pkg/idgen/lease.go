@@ -0,0 +1,157 @@
+package idgen
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Lease is a worker ID claimed in Redis (key idgen:worker:<id>) and renewed in
+// the background until Release. If a renewal finds the key gone or taken, the
+// ID may be in use elsewhere: Lost is closed and the holder should stop
+// generating.
+type Lease struct {
+	ID int64
+
+	rdb   *redis.Client
+	key   string
+	token string
+	ttl   time.Duration
+	stop  chan struct{}
+	done  chan struct{}
+	lost  chan struct{}
+}
+
+// Only touch the key while it still holds our token
+var (
+	renewScript   = redis.NewScript(`if redis.call("GET", KEYS[1]) == ARGV[1] then return redis.call("PEXPIRE", KEYS[1], ARGV[2]) end return 0`)
+	releaseScript = redis.NewScript(`if redis.call("GET", KEYS[1]) == ARGV[1] then return redis.call("DEL", KEYS[1]) end return 0`)
+)
+
+// LeaseWorker claims a free worker ID between lo and hi (inclusive), trying
+// them from a random start so restarts don't all race for the same ID
+func LeaseWorker(ctx context.Context, rdb *redis.Client, lo, hi int64, ttl time.Duration) (*Lease, error) {
+	if lo < 0 || hi > MaxWorker || lo > hi {
+		return nil, fmt.Errorf("invalid worker range %d-%d", lo, hi)
+	}
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return nil, err
+	}
+	token := hex.EncodeToString(buf)
+
+	n := hi - lo + 1
+	start, err := rand.Int(rand.Reader, big.NewInt(n))
+	if err != nil {
+		return nil, err
+	}
+	for i := int64(0); i < n; i++ {
+		id := lo + (start.Int64()+i)%n
+		key := fmt.Sprintf("idgen:worker:%d", id)
+		ok, err := rdb.SetNX(ctx, key, token, ttl).Result()
+		if err != nil {
+			return nil, fmt.Errorf("lease worker ID: %w", err)
+		}
+		if ok {
+			l := &Lease{ID: id, rdb: rdb, key: key, token: token, ttl: ttl,
+				stop: make(chan struct{}), done: make(chan struct{}), lost: make(chan struct{})}
+			go l.renew()
+			return l, nil
+		}
+	}
+	return nil, fmt.Errorf("no free worker ID in %d-%d", lo, hi)
+}
+
+// renew extends the lease every ttl/3
+func (l *Lease) renew() {
+	defer close(l.done)
+	ticker := time.NewTicker(l.ttl / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-ticker.C:
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), l.ttl/3)
+		n, err := renewScript.Run(ctx, l.rdb, []string{l.key}, l.token, l.ttl.Milliseconds()).Int()
+		cancel()
+		if err != nil {
+			// Transient: the lease survives until its TTL runs out
+			log.Printf("Warning: renewing worker ID %d lease: %v", l.ID, err)
+			continue
+		}
+		if n == 0 {
+			log.Printf("ALERT: worker ID %d lease lost", l.ID)
+			close(l.lost)
+			return
+		}
+	}
+}
+
+// Lost is closed if the lease expired or was taken over
+func (l *Lease) Lost() <-chan struct{} { return l.lost }
+
+// Release stops renewing and frees the ID
+func (l *Lease) Release(ctx context.Context) error {
+	close(l.stop)
+	<-l.done
+	return releaseScript.Run(ctx, l.rdb, []string{l.key}, l.token).Err()
+}
+
+// FromEnv builds a generator from WORKER_ID if set, otherwise from a Redis
+// lease on an ID in WORKER_ID_RANGE (default 0-1023) renewed every
+// WORKER_LEASE_TTL/3 (default 30s). rdb may be nil when WORKER_ID is set. The
+// lease is nil with WORKER_ID; otherwise release it on shutdown.
+func FromEnv(ctx context.Context, rdb *redis.Client) (*Generator, *Lease, error) {
+	if v := os.Getenv("WORKER_ID"); v != "" {
+		id, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid WORKER_ID %q", v)
+		}
+		g, err := New(id)
+		return g, nil, err
+	}
+	if rdb == nil {
+		return nil, nil, fmt.Errorf("WORKER_ID not set and no Redis to lease one from")
+	}
+
+	lo, hi := int64(0), int64(MaxWorker)
+	if v := os.Getenv("WORKER_ID_RANGE"); v != "" {
+		a, b, ok := strings.Cut(v, "-")
+		var errA, errB error
+		lo, errA = strconv.ParseInt(a, 10, 64)
+		hi, errB = strconv.ParseInt(b, 10, 64)
+		if !ok || errA != nil || errB != nil {
+			return nil, nil, fmt.Errorf("invalid WORKER_ID_RANGE %q (want lo-hi)", v)
+		}
+	}
+	ttl := 30 * time.Second
+	if v := os.Getenv("WORKER_LEASE_TTL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 3*time.Millisecond {
+			return nil, nil, fmt.Errorf("invalid WORKER_LEASE_TTL %q", v)
+		}
+		ttl = d
+	}
+
+	lease, err := LeaseWorker(ctx, rdb, lo, hi, ttl)
+	if err != nil {
+		return nil, nil, err
+	}
+	g, err := New(lease.ID)
+	if err != nil {
+		lease.Release(ctx)
+		return nil, nil, err
+	}
+	return g, lease, nil
+}
@@ -0,0 +1,86 @@
+package kafkautil
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// DLQ header keys, set on every dead-lettered message
+const (
+	HeaderDLQReason          = "dlq.reason"
+	HeaderDLQError           = "dlq.error"
+	HeaderDLQAttempts        = "dlq.attempts"
+	HeaderDLQSourceTopic     = "dlq.source.topic"
+	HeaderDLQSourcePartition = "dlq.source.partition"
+	HeaderDLQSourceOffset    = "dlq.source.offset"
+	HeaderDLQFailedAt        = "dlq.failed_at"
+	HeaderDLQTruncated       = "dlq.truncated"      // "true" when the value was cut to DeadLetter.Truncate
+	HeaderDLQOriginalBytes   = "dlq.original_bytes" // value size before the cut
+)
+
+// DeadLetter is a message that gave up on normal processing
+type DeadLetter struct {
+	Msg      kafka.Message
+	Reason   string
+	Err      error
+	Attempts int
+	Truncate int // keep at most this many bytes of the value (0 = all)
+}
+
+// DeadLetterQueue publishes the original message (key, value and headers,
+// so trace IDs survive) plus failure context headers to a DLQ topic
+type DeadLetterQueue struct {
+	w *kafka.Writer
+}
+
+func (c Config) NewDeadLetterQueue(topic string) *DeadLetterQueue {
+	return &DeadLetterQueue{w: c.NewWriter(topic, WriterConfigFromEnv())}
+}
+
+func (d *DeadLetterQueue) Topic() string { return d.w.Topic }
+
+func (d *DeadLetterQueue) Publish(ctx context.Context, letters ...DeadLetter) error {
+	now := time.Now().UTC().Format(time.RFC3339)
+	msgs := make([]kafka.Message, len(letters))
+	for i, l := range letters {
+		errText := ""
+		if l.Err != nil {
+			errText = l.Err.Error()
+		}
+		headers := make([]kafka.Header, 0, len(l.Msg.Headers)+9)
+		for _, h := range l.Msg.Headers {
+			if !isDLQHeader(h.Key) {
+				headers = append(headers, h)
+			}
+		}
+		headers = append(headers,
+			kafka.Header{Key: HeaderDLQReason, Value: []byte(l.Reason)},
+			kafka.Header{Key: HeaderDLQError, Value: []byte(errText)},
+			kafka.Header{Key: HeaderDLQAttempts, Value: []byte(strconv.Itoa(l.Attempts))},
+			kafka.Header{Key: HeaderDLQSourceTopic, Value: []byte(l.Msg.Topic)},
+			kafka.Header{Key: HeaderDLQSourcePartition, Value: []byte(strconv.Itoa(l.Msg.Partition))},
+			kafka.Header{Key: HeaderDLQSourceOffset, Value: []byte(strconv.FormatInt(l.Msg.Offset, 10))},
+			kafka.Header{Key: HeaderDLQFailedAt, Value: []byte(now)},
+		)
+		value, truncated := truncate(l.Msg.Value, l.Truncate)
+		if truncated {
+			headers = append(headers,
+				kafka.Header{Key: HeaderDLQTruncated, Value: []byte("true")},
+				kafka.Header{Key: HeaderDLQOriginalBytes, Value: []byte(strconv.Itoa(len(l.Msg.Value)))},
+			)
+		}
+		msgs[i] = kafka.Message{Key: l.Msg.Key, Value: value, Headers: headers}
+	}
+	return d.w.WriteMessages(ctx, msgs...)
+}
+
+func (d *DeadLetterQueue) Close() error { return d.w.Close() }
+
+// isDLQHeader drops failure context from an earlier trip through a DLQ, so a
+// replayed-and-failed-again message carries only the latest failure
+func isDLQHeader(key string) bool {
+	return len(key) > 4 && key[:4] == "dlq."
+}
@@ -0,0 +1,227 @@
+package kafkautil
+
+import (
+	"fmt"
+	"hash/fnv"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// Balancer names of KAFKA_GROUP_BALANCERS
+const (
+	BalancerRange          = "range"
+	BalancerRoundRobin     = "round-robin"
+	BalancerRackAffinity   = "rack-affinity"
+	BalancerInstanceSticky = "instance-sticky"
+)
+
+// GroupConfig is how readers take part in their consumer group. Zero values
+// keep kafka-go's defaults.
+//
+// kafka-go joins groups with JoinGroup v1 and the eager protocol: there is
+// no broker-side static membership (group.instance.id) and no cooperative
+// rebalancing, every member gives up all its partitions on each rebalance.
+// What can be tuned is which partitions come back (the balancers) and how
+// long the group waits for members to rejoin (the timeouts).
+type GroupConfig struct {
+	// In order of preference: the group uses the first one every member lists
+	Balancers []string
+	// Rack of this client, for rack-affinity
+	Rack string
+	// Stable identity of this member across restarts, for instance-sticky
+	InstanceID string
+
+	SessionTimeout    time.Duration // default 30s
+	RebalanceTimeout  time.Duration // default 30s
+	HeartbeatInterval time.Duration // default 3s
+	JoinGroupBackoff  time.Duration // default 5s
+}
+
+// groupConfigFromEnv reads:
+//
+//	KAFKA_GROUP_BALANCERS     range, round-robin, rack-affinity, instance-sticky (comma-separated)
+//	KAFKA_CLIENT_RACK         rack of this client (rack-affinity)
+//	KAFKA_GROUP_INSTANCE_ID   identity kept across restarts (instance-sticky, default hostname)
+//	KAFKA_SESSION_TIMEOUT, KAFKA_REBALANCE_TIMEOUT, KAFKA_HEARTBEAT_INTERVAL, KAFKA_JOIN_GROUP_BACKOFF
+func groupConfigFromEnv() (GroupConfig, error) {
+	hostname, _ := os.Hostname()
+	g := GroupConfig{
+		Rack:              os.Getenv("KAFKA_CLIENT_RACK"),
+		InstanceID:        getEnv("KAFKA_GROUP_INSTANCE_ID", hostname),
+		SessionTimeout:    getEnvDuration("KAFKA_SESSION_TIMEOUT", 0),
+		RebalanceTimeout:  getEnvDuration("KAFKA_REBALANCE_TIMEOUT", 0),
+		HeartbeatInterval: getEnvDuration("KAFKA_HEARTBEAT_INTERVAL", 0),
+		JoinGroupBackoff:  getEnvDuration("KAFKA_JOIN_GROUP_BACKOFF", 0),
+	}
+	for _, name := range strings.Split(os.Getenv("KAFKA_GROUP_BALANCERS"), ",") {
+		if name = strings.ToLower(strings.TrimSpace(name)); name == "" {
+			continue
+		}
+		switch name {
+		case BalancerRange, BalancerRoundRobin, BalancerInstanceSticky:
+		case BalancerRackAffinity:
+			if g.Rack == "" {
+				return g, fmt.Errorf("KAFKA_GROUP_BALANCERS %s needs KAFKA_CLIENT_RACK", name)
+			}
+		default:
+			return g, fmt.Errorf("invalid KAFKA_GROUP_BALANCERS %q", name)
+		}
+		g.Balancers = append(g.Balancers, name)
+	}
+	session, heartbeat := g.SessionTimeout, g.HeartbeatInterval
+	if session == 0 {
+		session = 30 * time.Second
+	}
+	if heartbeat == 0 {
+		heartbeat = 3 * time.Second
+	}
+	if heartbeat >= session {
+		return g, fmt.Errorf("KAFKA_HEARTBEAT_INTERVAL %s must be shorter than KAFKA_SESSION_TIMEOUT %s", heartbeat, session)
+	}
+	return g, nil
+}
+
+// balancers returns the kafka-go balancers; member tells several members of
+// one process apart in instance-sticky. nil = kafka-go's range, round-robin.
+func (g GroupConfig) balancers(member string) []kafka.GroupBalancer {
+	var out []kafka.GroupBalancer
+	for _, name := range g.Balancers {
+		switch name {
+		case BalancerRange:
+			out = append(out, kafka.RangeGroupBalancer{})
+		case BalancerRoundRobin:
+			out = append(out, kafka.RoundRobinGroupBalancer{})
+		case BalancerRackAffinity:
+			out = append(out, kafka.RackAffinityGroupBalancer{Rack: g.Rack})
+		case BalancerInstanceSticky:
+			id := g.InstanceID
+			if member != "" {
+				id += "/" + member
+			}
+			out = append(out, InstanceStickyBalancer{InstanceID: id})
+		}
+	}
+	return out
+}
+
+func (g GroupConfig) String() string {
+	balancers := strings.Join(g.Balancers, ",")
+	if balancers == "" {
+		balancers = BalancerRange + "," + BalancerRoundRobin
+	}
+	s := "balancers=" + balancers
+	for _, name := range g.Balancers {
+		switch name {
+		case BalancerRackAffinity:
+			s += " rack=" + g.Rack
+		case BalancerInstanceSticky:
+			s += " instance=" + g.InstanceID
+		}
+	}
+	show := func(name string, d, def time.Duration) {
+		if d == 0 {
+			d = def
+		}
+		s += fmt.Sprintf(" %s=%s", name, d)
+	}
+	show("session", g.SessionTimeout, 30*time.Second)
+	show("rebalance", g.RebalanceTimeout, 30*time.Second)
+	show("heartbeat", g.HeartbeatInterval, 3*time.Second)
+	show("join_backoff", g.JoinGroupBackoff, 5*time.Second)
+	return s
+}
+
+// InstanceStickyBalancer assigns partitions by the members' instance IDs
+// rather than their member IDs, which the coordinator hands out anew on
+// every restart. Each partition goes to the member ranking it highest
+// (rendezvous hashing of instance ID and partition), capped at
+// ceil(partitions/members) per member, so the assignment depends only on
+// which instances are in the group: when one restarts in a rolling deploy,
+// mostly its own partitions move while it is gone, and the same ones come
+// back when it rejoins. Range and round-robin can reshuffle most of the
+// topic, twice.
+type InstanceStickyBalancer struct {
+	InstanceID string
+}
+
+func (b InstanceStickyBalancer) ProtocolName() string { return BalancerInstanceSticky }
+
+func (b InstanceStickyBalancer) UserData() ([]byte, error) { return []byte(b.InstanceID), nil }
+
+func (b InstanceStickyBalancer) AssignGroups(members []kafka.GroupMember, partitions []kafka.Partition) kafka.GroupMemberAssignments {
+	assignments := make(kafka.GroupMemberAssignments, len(members))
+	for _, m := range members {
+		assignments[m.ID] = make(map[string][]int)
+	}
+
+	byTopic := make(map[string][]int)
+	for _, p := range partitions {
+		byTopic[p.Topic] = append(byTopic[p.Topic], p.ID)
+	}
+	for topic, ids := range byTopic {
+		type candidate struct{ memberID, key string }
+		var candidates []candidate
+		for _, m := range members {
+			for _, t := range m.Topics {
+				if t == topic {
+					// Members without an instance ID (or sharing one) are still
+					// assigned, just not sticky across restarts
+					key := string(m.UserData)
+					if key == "" {
+						key = m.ID
+					}
+					candidates = append(candidates, candidate{m.ID, key})
+					break
+				}
+			}
+		}
+		if len(candidates) == 0 {
+			continue
+		}
+		sort.Slice(candidates, func(i, j int) bool {
+			if candidates[i].key != candidates[j].key {
+				return candidates[i].key < candidates[j].key
+			}
+			return candidates[i].memberID < candidates[j].memberID
+		})
+		sort.Ints(ids)
+
+		limit := (len(ids) + len(candidates) - 1) / len(candidates)
+		load := make([]int, len(candidates))
+		order := make([]int, len(candidates))
+		score := make([]uint64, len(candidates))
+		for _, id := range ids {
+			for i, c := range candidates {
+				order[i] = i
+				h := fnv.New64a()
+				fmt.Fprintf(h, "%s/%s/%d", c.key, topic, id)
+				score[i] = mix(h.Sum64())
+			}
+			sort.SliceStable(order, func(i, j int) bool { return score[order[i]] > score[order[j]] })
+			for _, i := range order {
+				if load[i] < limit {
+					load[i]++
+					c := candidates[i].memberID
+					assignments[c][topic] = append(assignments[c][topic], id)
+					break
+				}
+			}
+		}
+	}
+	return assignments
+}
+
+// mix spreads FNV's output (splitmix64's finalizer): keys differing only in
+// the partition number would otherwise rank members alike
+func mix(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
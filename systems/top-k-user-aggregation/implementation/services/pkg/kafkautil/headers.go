@@ -0,0 +1,135 @@
+package kafkautil
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// Standard headers, next to HeaderTraceID and HeaderReplayID. Producers set
+// them with Inject (and Stamp for the payload's schema version); consumers
+// read them with ParseHeaders, or ExtractHeaders to pass them on to what
+// they produce in turn.
+const (
+	// HeaderSchemaVersion is the schema version of the payload, so a
+	// consumer can tell what it got before decoding
+	HeaderSchemaVersion = "schema_version"
+	// HeaderOrigin names the service that produced the message
+	HeaderOrigin = "origin"
+	// HeaderTenant names the tenant the message belongs to
+	HeaderTenant = "tenant"
+	// HeaderPublishedAt is when the producer published the event (unix
+	// milliseconds), so consumers can tell how fresh what they serve is
+	HeaderPublishedAt = "published_at"
+)
+
+// Headers are a message's standard headers. Empty fields aren't written.
+type Headers struct {
+	TraceID       string
+	ReplayID      string // set on events republished by tools/cmd/replay
+	SchemaVersion int    // 0 = not stated
+	Origin        string
+	Tenant        string
+	PublishedAt   time.Time // zero = not stamped
+}
+
+// IsReplay reports whether the message was republished from history
+func (h Headers) IsReplay() bool {
+	return h.ReplayID != ""
+}
+
+// ParseHeaders reads msg's standard headers. A malformed schema version
+// reads as 0, a malformed publish time as zero.
+func ParseHeaders(msg kafka.Message) Headers {
+	var h Headers
+	h.TraceID, _ = Header(msg, HeaderTraceID)
+	h.ReplayID, _ = Header(msg, HeaderReplayID)
+	h.Origin, _ = Header(msg, HeaderOrigin)
+	h.Tenant, _ = Header(msg, HeaderTenant)
+	if v, ok := Header(msg, HeaderSchemaVersion); ok {
+		h.SchemaVersion, _ = strconv.Atoi(v)
+	}
+	if v, ok := Header(msg, HeaderPublishedAt); ok {
+		if ms, err := strconv.ParseInt(v, 10, 64); err == nil {
+			h.PublishedAt = time.UnixMilli(ms)
+		}
+	}
+	return h
+}
+
+// origin is this process's HeaderOrigin: KAFKA_ORIGIN, or the binary's name,
+// which is the service's in every image
+var origin = getEnv("KAFKA_ORIGIN", filepath.Base(os.Args[0]))
+
+// tenant is the HeaderTenant of messages whose context has none (KAFKA_TENANT;
+// empty = single-tenant, not written)
+var tenant = getEnv("KAFKA_TENANT", "")
+
+type headersKey struct{}
+
+// ExtractHeaders returns ctx carrying msg's trace, replay and tenant
+// headers, which Inject then sets on the messages produced under ctx. The
+// schema version, origin and publish time are the producer's own and aren't
+// carried.
+func ExtractHeaders(ctx context.Context, msg kafka.Message) context.Context {
+	h := ParseHeaders(msg)
+	ctx = context.WithValue(ctx, headersKey{}, Headers{ReplayID: h.ReplayID, Tenant: h.Tenant})
+	if h.TraceID != "" {
+		ctx = ContextWithTrace(ctx, h.TraceID)
+	}
+	return ctx
+}
+
+// HeadersFromContext returns the headers Inject would set under ctx
+func HeadersFromContext(ctx context.Context) Headers {
+	h, _ := ctx.Value(headersKey{}).(Headers)
+	h.TraceID = TraceFromContext(ctx)
+	h.Origin = origin
+	if h.Tenant == "" {
+		h.Tenant = tenant
+	}
+	return h
+}
+
+// Inject sets the standard headers on msgs: the trace as InjectTrace does,
+// this process as the origin, and the replay and tenant carried by ctx.
+// Headers a message already has are kept.
+func Inject(ctx context.Context, msgs []kafka.Message) {
+	InjectTrace(ctx, msgs)
+	h := HeadersFromContext(ctx)
+	h.TraceID = ""
+	Stamp(msgs, h)
+}
+
+// Stamp sets h's non-empty fields on msgs, except those a message already
+// has
+func Stamp(msgs []kafka.Message, h Headers) {
+	var add []kafka.Header
+	set := func(key, value string) {
+		if value != "" {
+			add = append(add, kafka.Header{Key: key, Value: []byte(value)})
+		}
+	}
+	set(HeaderTraceID, h.TraceID)
+	set(HeaderReplayID, h.ReplayID)
+	set(HeaderOrigin, h.Origin)
+	set(HeaderTenant, h.Tenant)
+	if h.SchemaVersion > 0 {
+		set(HeaderSchemaVersion, strconv.Itoa(h.SchemaVersion))
+	}
+	if !h.PublishedAt.IsZero() {
+		set(HeaderPublishedAt, strconv.FormatInt(h.PublishedAt.UnixMilli(), 10))
+	}
+
+	for i := range msgs {
+		for _, hdr := range add {
+			if _, ok := Header(msgs[i], hdr.Key); !ok {
+				msgs[i].Headers = append(msgs[i].Headers, hdr)
+			}
+		}
+	}
+}
@@ -0,0 +1,287 @@
+// Package kafkautil builds kafka-go readers and writers with the settings
+// every service should share: brokers, TLS/SASL, producer durability and
+// batching, dead-lettering, trace headers and commit retries. Settings come
+// from the environment so each service is configured the same way.
+package kafkautil
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"math/rand"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/sasl"
+	"github.com/segmentio/kafka-go/sasl/plain"
+	"github.com/segmentio/kafka-go/sasl/scram"
+	"github.com/system-design-lab/pkg/dc"
+)
+
+// Config is the cluster connection shared by readers and writers
+type Config struct {
+	Brokers []string
+	TLS     *tls.Config    // nil = plaintext
+	SASL    sasl.Mechanism // nil = no authentication
+
+	// SourceDC makes readers consume another datacenter's topics as mirrored
+	// into this cluster by MirrorMaker 2 ("<dc>.<topic>"); "" = local topics
+	SourceDC string
+
+	// Consumer group membership of readers, see GroupConfig
+	Group GroupConfig
+
+	// Connection and metadata refresh timings, see ConfigFromEnv
+	DialTimeout            time.Duration
+	IdleTimeout            time.Duration
+	MetadataTTL            time.Duration
+	PartitionWatchInterval time.Duration // 0 = readers don't watch
+
+	rt *kafka.Transport // shared by the writers and clients of this config
+}
+
+// ConfigFromEnv reads the connection settings:
+//
+//	KAFKA_BROKER                    comma-separated brokers (KAFKA_BROKER_<DC> first)
+//	KAFKA_TLS                       true to connect over TLS
+//	KAFKA_TLS_CA_FILE               PEM bundle to verify brokers (default: system roots)
+//	KAFKA_TLS_INSECURE_SKIP_VERIFY  true to skip verification (dev only)
+//	KAFKA_SASL_MECHANISM            plain, scram-sha-256 or scram-sha-512
+//	KAFKA_SASL_USERNAME / KAFKA_SASL_PASSWORD
+//	KAFKA_SOURCE_DC                 consume this DC's mirrored topics (failover)
+//	KAFKA_GROUP_BALANCERS, ...      consumer group membership, see groupConfigFromEnv
+//	KAFKA_DIAL_TIMEOUT              per broker tried (default 5s)
+//	KAFKA_IDLE_TIMEOUT              closes unused writer connections (default 30s)
+//	KAFKA_METADATA_TTL              writer/client metadata refresh (default 6s)
+//	KAFKA_PARTITION_WATCH_INTERVAL  group readers' partition refresh (default 0 = off)
+func ConfigFromEnv(defaultBroker string) (Config, error) {
+	brokers, err := ParseBrokers(dc.Env("KAFKA_BROKER", defaultBroker))
+	if err != nil {
+		return Config{}, fmt.Errorf("KAFKA_BROKER: %w", err)
+	}
+	cfg := Config{
+		Brokers:                brokers,
+		SourceDC:               os.Getenv("KAFKA_SOURCE_DC"),
+		DialTimeout:            getEnvDuration("KAFKA_DIAL_TIMEOUT", 5*time.Second),
+		IdleTimeout:            getEnvDuration("KAFKA_IDLE_TIMEOUT", 30*time.Second),
+		MetadataTTL:            getEnvDuration("KAFKA_METADATA_TTL", 6*time.Second),
+		PartitionWatchInterval: getEnvDuration("KAFKA_PARTITION_WATCH_INTERVAL", 0),
+	}
+	if cfg.SourceDC == dc.Local() {
+		cfg.SourceDC = ""
+	}
+	if cfg.DialTimeout <= 0 || cfg.IdleTimeout <= 0 || cfg.MetadataTTL <= 0 || cfg.PartitionWatchInterval < 0 {
+		return cfg, fmt.Errorf("KAFKA_DIAL_TIMEOUT, KAFKA_IDLE_TIMEOUT and KAFKA_METADATA_TTL must be positive, KAFKA_PARTITION_WATCH_INTERVAL not negative")
+	}
+	group, err := groupConfigFromEnv()
+	if err != nil {
+		return cfg, err
+	}
+	cfg.Group = group
+
+	if getEnvBool("KAFKA_TLS", false) {
+		tc := &tls.Config{
+			MinVersion:         tls.VersionTLS12,
+			InsecureSkipVerify: getEnvBool("KAFKA_TLS_INSECURE_SKIP_VERIFY", false),
+		}
+		if path := os.Getenv("KAFKA_TLS_CA_FILE"); path != "" {
+			pem, err := os.ReadFile(path)
+			if err != nil {
+				return cfg, fmt.Errorf("read KAFKA_TLS_CA_FILE: %w", err)
+			}
+			pool := x509.NewCertPool()
+			if !pool.AppendCertsFromPEM(pem) {
+				return cfg, fmt.Errorf("no certificates in KAFKA_TLS_CA_FILE %s", path)
+			}
+			tc.RootCAs = pool
+		}
+		cfg.TLS = tc
+	}
+
+	user, pass := os.Getenv("KAFKA_SASL_USERNAME"), os.Getenv("KAFKA_SASL_PASSWORD")
+	switch m := strings.ToLower(os.Getenv("KAFKA_SASL_MECHANISM")); m {
+	case "":
+	case "plain":
+		cfg.SASL = plain.Mechanism{Username: user, Password: pass}
+	case "scram-sha-256", "scram-sha-512":
+		algo := scram.SHA256
+		if m == "scram-sha-512" {
+			algo = scram.SHA512
+		}
+		mech, err := scram.Mechanism(algo, user, pass)
+		if err != nil {
+			return cfg, fmt.Errorf("sasl %s: %w", m, err)
+		}
+		cfg.SASL = mech
+	default:
+		return cfg, fmt.Errorf("invalid KAFKA_SASL_MECHANISM %q", m)
+	}
+	cfg.rt = cfg.newTransport()
+	return cfg, nil
+}
+
+// ParseBrokers splits a comma-separated host:port list, ignoring blanks and
+// duplicates
+func ParseBrokers(s string) ([]string, error) {
+	var brokers []string
+	seen := make(map[string]bool)
+	for _, b := range strings.Split(s, ",") {
+		b = strings.TrimSpace(b)
+		if b == "" || seen[b] {
+			continue
+		}
+		if host, port, err := net.SplitHostPort(b); err != nil || host == "" || port == "" {
+			return nil, fmt.Errorf("invalid broker %q, want host:port", b)
+		}
+		seen[b] = true
+		brokers = append(brokers, b)
+	}
+	if len(brokers) == 0 {
+		return nil, fmt.Errorf("no brokers in %q", s)
+	}
+	return brokers, nil
+}
+
+// Topic returns the name topic has in this cluster for the source DC: topic
+// itself for local data, "<dc>.<topic>" (MirrorMaker 2's default replication
+// policy) for a remote DC's mirror. Producers always write local names.
+func (c Config) Topic(topic string) string {
+	if c.SourceDC == "" {
+		return topic
+	}
+	return c.SourceDC + "." + topic
+}
+
+// Secure reports whether TLS or SASL is configured
+func (c Config) Secure() bool {
+	return c.TLS != nil || c.SASL != nil
+}
+
+// transport is used by writers and clients
+func (c Config) transport() kafka.RoundTripper {
+	if c.rt != nil {
+		return c.rt
+	}
+	return c.newTransport()
+}
+
+// newTransport bootstraps from the brokers in random order until one
+// answers. kafka-go gives the whole bootstrap a single DialTimeout, so it
+// gets one per broker and each dial gets c.DialTimeout: a broker that
+// doesn't answer leaves time to try the others.
+func (c Config) newTransport() *kafka.Transport {
+	dialTimeout := c.dialTimeout()
+	dialer := &net.Dialer{Timeout: dialTimeout, KeepAlive: 30 * time.Second}
+	return &kafka.Transport{
+		Dial:        dialer.DialContext,
+		DialTimeout: dialTimeout * time.Duration(len(c.Brokers)),
+		IdleTimeout: c.IdleTimeout,
+		MetadataTTL: c.MetadataTTL,
+		TLS:         c.TLS,
+		SASL:        c.SASL,
+	}
+}
+
+func (c Config) dialTimeout() time.Duration {
+	if c.DialTimeout > 0 {
+		return c.DialTimeout
+	}
+	return 5 * time.Second
+}
+
+// Client returns an admin/protocol client for the cluster
+func (c Config) Client() *kafka.Client {
+	return &kafka.Client{
+		Addr:      kafka.TCP(c.Brokers...),
+		Transport: c.transport(),
+		Timeout:   30 * time.Second,
+	}
+}
+
+// Dialer is used by readers and admin connections
+func (c Config) Dialer() *kafka.Dialer {
+	return &kafka.Dialer{
+		Timeout:       c.dialTimeout(),
+		DualStack:     true,
+		TLS:           c.TLS,
+		SASLMechanism: c.SASL,
+	}
+}
+
+// shuffledBrokers returns the brokers in random order. Readers try them in
+// order, so each process starts from a different one instead of every
+// reader waiting on the first while it is down.
+func (c Config) shuffledBrokers() []string {
+	brokers := append([]string(nil), c.Brokers...)
+	rand.Shuffle(len(brokers), func(i, j int) { brokers[i], brokers[j] = brokers[j], brokers[i] })
+	return brokers
+}
+
+// UnreachableBrokers dials every broker and returns the error of each one
+// that doesn't answer; empty when all do
+func (c Config) UnreachableBrokers(ctx context.Context) map[string]error {
+	var (
+		mu     sync.Mutex
+		wg     sync.WaitGroup
+		failed = make(map[string]error)
+	)
+	for _, b := range c.Brokers {
+		wg.Add(1)
+		go func(b string) {
+			defer wg.Done()
+			conn, err := c.Dialer().DialContext(ctx, "tcp", b)
+			if err != nil {
+				mu.Lock()
+				failed[b] = err
+				mu.Unlock()
+				return
+			}
+			conn.Close()
+		}(b)
+	}
+	wg.Wait()
+	return failed
+}
+
+func getEnv(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
+
+func getEnvInt(key string, fallback int) int {
+	if v := os.Getenv(key); v != "" {
+		i, err := strconv.Atoi(v)
+		if err == nil {
+			return i
+		}
+	}
+	return fallback
+}
+
+func getEnvBool(key string, fallback bool) bool {
+	if v := os.Getenv(key); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err == nil {
+			return b
+		}
+	}
+	return fallback
+}
+
+func getEnvDuration(key string, fallback time.Duration) time.Duration {
+	if v := os.Getenv(key); v != "" {
+		d, err := time.ParseDuration(v)
+		if err == nil {
+			return d
+		}
+	}
+	return fallback
+}
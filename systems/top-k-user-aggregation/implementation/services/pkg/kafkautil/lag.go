@@ -0,0 +1,90 @@
+package kafkautil
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// Lag is a consumer group's backlog on one topic
+type Lag struct {
+	Topic      string
+	Group      string
+	Total      int64
+	Partitions map[int]int64 // partition -> messages behind the log end
+}
+
+// GroupLag compares a group's committed offsets with the log end offsets.
+// Partitions the group never committed count from the earliest offset, which
+// is where kafkautil readers start.
+func GroupLag(ctx context.Context, client *kafka.Client, topic, group string) (Lag, error) {
+	lag := Lag{Topic: topic, Group: group, Partitions: make(map[int]int64)}
+
+	meta, err := client.Metadata(ctx, &kafka.MetadataRequest{Topics: []string{topic}})
+	if err != nil {
+		return lag, fmt.Errorf("metadata: %w", err)
+	}
+	if len(meta.Topics) != 1 || meta.Topics[0].Error != nil {
+		return lag, fmt.Errorf("metadata %s: %v", topic, meta.Topics)
+	}
+	var (
+		ids         []int
+		first, last []kafka.OffsetRequest
+	)
+	for _, p := range meta.Topics[0].Partitions {
+		ids = append(ids, p.ID)
+		first = append(first, kafka.FirstOffsetOf(p.ID))
+		last = append(last, kafka.LastOffsetOf(p.ID))
+	}
+
+	committed, err := client.OffsetFetch(ctx, &kafka.OffsetFetchRequest{
+		GroupID: group,
+		Topics:  map[string][]int{topic: ids},
+	})
+	if err != nil {
+		return lag, fmt.Errorf("offset fetch %s: %w", group, err)
+	}
+	commits := make(map[int]int64)
+	for _, p := range committed.Topics[topic] {
+		commits[p.Partition] = p.CommittedOffset
+	}
+
+	ends, err := listOffsets(ctx, client, topic, last, func(p kafka.PartitionOffsets) int64 { return p.LastOffset })
+	if err != nil {
+		return lag, err
+	}
+	starts, err := listOffsets(ctx, client, topic, first, func(p kafka.PartitionOffsets) int64 { return p.FirstOffset })
+	if err != nil {
+		return lag, err
+	}
+
+	for _, id := range ids {
+		pos, ok := commits[id]
+		if !ok || pos < 0 {
+			pos = starts[id]
+		}
+		behind := ends[id] - pos
+		if behind < 0 {
+			behind = 0
+		}
+		lag.Partitions[id] = behind
+		lag.Total += behind
+	}
+	return lag, nil
+}
+
+func listOffsets(ctx context.Context, client *kafka.Client, topic string, reqs []kafka.OffsetRequest, pick func(kafka.PartitionOffsets) int64) (map[int]int64, error) {
+	resp, err := client.ListOffsets(ctx, &kafka.ListOffsetsRequest{Topics: map[string][]kafka.OffsetRequest{topic: reqs}})
+	if err != nil {
+		return nil, fmt.Errorf("list offsets %s: %w", topic, err)
+	}
+	out := make(map[int]int64)
+	for _, p := range resp.Topics[topic] {
+		if p.Error != nil {
+			return nil, fmt.Errorf("list offsets %s[%d]: %w", topic, p.Partition, p.Error)
+		}
+		out[p.Partition] = pick(p)
+	}
+	return out, nil
+}
@@ -0,0 +1,122 @@
+package kafkautil
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/system-design-lab/pkg/chaos"
+)
+
+// ReaderConfig holds consumer-group settings for NewReader
+type ReaderConfig struct {
+	Topic    string
+	GroupID  string
+	MinBytes int           // default 1
+	MaxBytes int           // default 10MB
+	MaxWait  time.Duration // default KAFKA_FETCH_MAX_WAIT or 10s
+	// Where a group without commits starts: kafka.FirstOffset (the default)
+	// or kafka.LastOffset, for consumers that only want new messages
+	StartOffset int64
+	// Tells several group members of one process apart, appended to
+	// KAFKA_GROUP_INSTANCE_ID for the instance-sticky balancer
+	Member string
+}
+
+// NewReader creates a consumer-group reader with explicit commits
+// (CommitInterval 0): callers commit after their writes succeed. rc.Topic is
+// mapped through Topic, so a reader follows KAFKA_SOURCE_DC; use
+// reader.Config().Topic for the name actually consumed. Group readers take
+// their balancers and timeouts from c.Group, and with
+// c.PartitionWatchInterval rebalance when the topic gains partitions.
+func (c Config) NewReader(rc ReaderConfig) *kafka.Reader {
+	if rc.MinBytes == 0 {
+		rc.MinBytes = 1
+	}
+	if rc.MaxBytes == 0 {
+		rc.MaxBytes = getEnvInt("KAFKA_FETCH_MAX_BYTES", 10e6)
+	}
+	if rc.MaxWait == 0 {
+		rc.MaxWait = getEnvDuration("KAFKA_FETCH_MAX_WAIT", 10*time.Second)
+	}
+	cfg := kafka.ReaderConfig{
+		Brokers:  c.shuffledBrokers(),
+		Topic:    c.Topic(rc.Topic),
+		GroupID:  rc.GroupID,
+		MinBytes: rc.MinBytes,
+		MaxBytes: rc.MaxBytes,
+		MaxWait:  rc.MaxWait,
+
+		StartOffset: rc.StartOffset, // FirstOffset when 0
+	}
+	if rc.GroupID != "" {
+		cfg.GroupBalancers = c.Group.balancers(rc.Member)
+		cfg.SessionTimeout = c.Group.SessionTimeout
+		cfg.RebalanceTimeout = c.Group.RebalanceTimeout
+		cfg.HeartbeatInterval = c.Group.HeartbeatInterval
+		cfg.JoinGroupBackoff = c.Group.JoinGroupBackoff
+		if c.PartitionWatchInterval > 0 {
+			cfg.WatchPartitionChanges = true
+			cfg.PartitionWatchInterval = c.PartitionWatchInterval
+		}
+	}
+	cfg.Dialer = c.Dialer()
+	return kafka.NewReader(cfg)
+}
+
+// CommitWithRetry commits msgs, retrying transient failures (e.g. a group
+// rebalance in progress) with backoff. It gives up after attempts tries or when
+// ctx is done; the messages are then redelivered, so consumers must be
+// idempotent either way.
+func CommitWithRetry(ctx context.Context, r *kafka.Reader, attempts int, msgs ...kafka.Message) error {
+	if len(msgs) == 0 {
+		return nil
+	}
+	backoff := 100 * time.Millisecond
+	var err error
+	for i := 1; i <= attempts; i++ {
+		if err = chaos.Inject(ctx, chaos.KafkaCommit); err == nil {
+			err = r.CommitMessages(ctx, msgs...)
+		}
+		if err == nil {
+			return nil
+		}
+		if i == attempts {
+			break
+		}
+		log.Printf("Commit failed (attempt %d/%d, retry in %s): %v", i, attempts, backoff, err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > 2*time.Second {
+			backoff = 2 * time.Second
+		}
+	}
+	return fmt.Errorf("commit %d messages: %w", len(msgs), err)
+}
+
+// LatestPerPartition returns the highest-offset message of each partition.
+// Committing those covers everything before them, and unlike committing only
+// the last message seen it doesn't leave other partitions behind.
+func LatestPerPartition(msgs []kafka.Message) []kafka.Message {
+	type tp struct {
+		topic     string
+		partition int
+	}
+	latest := make(map[tp]kafka.Message)
+	for _, m := range msgs {
+		k := tp{m.Topic, m.Partition}
+		if cur, ok := latest[k]; !ok || m.Offset > cur.Offset {
+			latest[k] = m
+		}
+	}
+	out := make([]kafka.Message, 0, len(latest))
+	for _, m := range latest {
+		out = append(out, m)
+	}
+	return out
+}
@@ -0,0 +1,145 @@
+package kafkautil
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// Retry header keys, set on messages sent to a retry topic
+const (
+	HeaderRetryAttempt     = "retry.attempt"      // retry topics gone through, the current one included
+	HeaderRetryDue         = "retry.due"          // when to redeliver it, unix milliseconds
+	HeaderRetrySourceTopic = "retry.source.topic" // where it goes back to
+	HeaderRetryError       = "retry.error"        // the failure that sent it
+)
+
+// RetryTier is a retry topic and how long its messages wait there
+type RetryTier struct {
+	Topic string
+	Delay time.Duration
+}
+
+// ParseRetryTopics reads a comma-separated list of retry topics, shortest
+// delay first, each named after its delay: user.listen.retry.5m waits 5
+// minutes
+func ParseRetryTopics(list string) ([]RetryTier, error) {
+	var tiers []RetryTier
+	for _, topic := range strings.Split(list, ",") {
+		topic = strings.TrimSpace(topic)
+		if topic == "" {
+			continue
+		}
+		i := strings.LastIndexByte(topic, '.')
+		d, err := time.ParseDuration(topic[i+1:])
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("retry topic %q: want a name ending in its delay, like user.listen.retry.5m", topic)
+		}
+		if n := len(tiers); n > 0 && d <= tiers[n-1].Delay {
+			return nil, fmt.Errorf("retry topic %q: delays must grow, %s after %s", topic, d, tiers[n-1].Delay)
+		}
+		tiers = append(tiers, RetryTier{Topic: topic, Delay: d})
+	}
+	return tiers, nil
+}
+
+// RetryAttempt returns how many retry topics msg went through
+func RetryAttempt(msg kafka.Message) int {
+	v, _ := Header(msg, HeaderRetryAttempt)
+	n, _ := strconv.Atoi(v)
+	return n
+}
+
+// RetryDue returns when a retry topic's message is due back, zero when it
+// has no retry.due
+func RetryDue(msg kafka.Message) time.Time {
+	v, ok := Header(msg, HeaderRetryDue)
+	if !ok {
+		return time.Time{}
+	}
+	ms, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return time.Time{}
+	}
+	return time.UnixMilli(ms)
+}
+
+// RetryQueue delays failed messages through tiered retry topics: the first
+// failure goes to the first tier, a failure after it to the next, and so
+// on. A redelivery service moves them back to their source topic once due.
+type RetryQueue struct {
+	tiers []RetryTier
+	w     []*kafka.Writer // per tier
+}
+
+func (c Config) NewRetryQueue(tiers []RetryTier) *RetryQueue {
+	q := &RetryQueue{tiers: tiers}
+	for _, t := range tiers {
+		q.w = append(q.w, c.NewWriter(t.Topic, WriterConfigFromEnv()))
+	}
+	return q
+}
+
+// Tiers returns the retry topics, shortest delay first
+func (q *RetryQueue) Tiers() []RetryTier { return q.tiers }
+
+// Publish sends each letter to the tier after the ones its message went
+// through, keeping its key, value and headers. Letters whose message went
+// through every tier are returned, for the DLQ.
+func (q *RetryQueue) Publish(ctx context.Context, letters ...DeadLetter) ([]DeadLetter, error) {
+	now := time.Now()
+	byTier := make([][]kafka.Message, len(q.tiers))
+	var exhausted []DeadLetter
+	for _, l := range letters {
+		attempt := RetryAttempt(l.Msg)
+		if attempt >= len(q.tiers) {
+			exhausted = append(exhausted, l)
+			continue
+		}
+		errText := ""
+		if l.Err != nil {
+			errText = l.Err.Error()
+		}
+		source := l.Msg.Topic
+		if v, ok := Header(l.Msg, HeaderRetrySourceTopic); ok {
+			source = v
+		}
+		headers := make([]kafka.Header, 0, len(l.Msg.Headers)+4)
+		for _, h := range l.Msg.Headers {
+			if !isRetryHeader(h.Key) && !isDLQHeader(h.Key) {
+				headers = append(headers, h)
+			}
+		}
+		headers = append(headers,
+			kafka.Header{Key: HeaderRetryAttempt, Value: []byte(strconv.Itoa(attempt + 1))},
+			kafka.Header{Key: HeaderRetryDue, Value: []byte(strconv.FormatInt(now.Add(q.tiers[attempt].Delay).UnixMilli(), 10))},
+			kafka.Header{Key: HeaderRetrySourceTopic, Value: []byte(source)},
+			kafka.Header{Key: HeaderRetryError, Value: []byte(errText)},
+		)
+		byTier[attempt] = append(byTier[attempt], kafka.Message{Key: l.Msg.Key, Value: l.Msg.Value, Headers: headers})
+	}
+	for i, msgs := range byTier {
+		if len(msgs) == 0 {
+			continue
+		}
+		if err := q.w[i].WriteMessages(ctx, msgs...); err != nil {
+			return nil, fmt.Errorf("publish %d messages to %s: %w", len(msgs), q.tiers[i].Topic, err)
+		}
+	}
+	return exhausted, nil
+}
+
+func (q *RetryQueue) Close() error {
+	for _, w := range q.w {
+		w.Close()
+	}
+	return nil
+}
+
+func isRetryHeader(key string) bool {
+	return strings.HasPrefix(key, "retry.")
+}
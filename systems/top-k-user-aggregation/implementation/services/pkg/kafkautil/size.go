@@ -0,0 +1,37 @@
+package kafkautil
+
+import "fmt"
+
+// DefaultMaxMessageBytes is a little under the broker's default
+// message.max.bytes (1048588), which bounds a whole record batch
+const DefaultMaxMessageBytes = 1000000
+
+// HeaderAllowance is the room left for the standard headers (Inject, Stamp)
+// when a payload is checked before they are set
+const HeaderAllowance = 512
+
+// recordOverhead is a record's framing in a batch: length, attributes,
+// timestamp and offset deltas, key and value lengths
+const recordOverhead = 21
+
+// CheckPayload returns an error when a message with key and value would
+// exceed max once the standard headers are added. Producers check each
+// payload before batching it, so one oversized event is refused on its own
+// instead of failing the write of every message batched with it.
+func CheckPayload(key, value []byte, max int64) error {
+	if max <= 0 {
+		return nil
+	}
+	if n := int64(recordOverhead+len(key)+len(value)) + HeaderAllowance; n > max {
+		return fmt.Errorf("message of %d bytes over the %d-byte limit (KAFKA_MAX_MESSAGE_BYTES)", n, max)
+	}
+	return nil
+}
+
+// truncate cuts value to max bytes, for a copy kept for inspection only
+func truncate(value []byte, max int) ([]byte, bool) {
+	if max <= 0 || len(value) <= max {
+		return value, false
+	}
+	return value[:max], true
+}
@@ -0,0 +1,71 @@
+package kafkautil
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// HeaderTraceID carries a trace ID from producer to every consumer (and the
+// DLQ), so one crawl can be followed through the logs of each service
+const HeaderTraceID = "trace_id"
+
+// HeaderReplayID marks messages republished from Cassandra history by
+// tools/cmd/replay; the value names the replay run
+const HeaderReplayID = "replay_id"
+
+type traceKey struct{}
+
+// NewTraceID returns a random 16-byte hex ID
+func NewTraceID() string {
+	var b [16]byte
+	_, _ = rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// ContextWithTrace returns ctx carrying trace ID id
+func ContextWithTrace(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, traceKey{}, id)
+}
+
+// TraceFromContext returns the trace ID in ctx, or ""
+func TraceFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(traceKey{}).(string)
+	return id
+}
+
+// InjectTrace sets the trace header on msgs from ctx, generating one trace ID
+// for the whole call if ctx has none. Messages that already carry a trace
+// keep it.
+func InjectTrace(ctx context.Context, msgs []kafka.Message) {
+	id := TraceFromContext(ctx)
+	for i := range msgs {
+		if _, ok := Header(msgs[i], HeaderTraceID); ok {
+			continue
+		}
+		if id == "" {
+			id = NewTraceID()
+		}
+		msgs[i].Headers = append(msgs[i].Headers, kafka.Header{Key: HeaderTraceID, Value: []byte(id)})
+	}
+}
+
+// ExtractTrace returns ctx carrying msg's trace ID, if it has one
+func ExtractTrace(ctx context.Context, msg kafka.Message) context.Context {
+	if id, ok := Header(msg, HeaderTraceID); ok {
+		return ContextWithTrace(ctx, id)
+	}
+	return ctx
+}
+
+// Header returns the value of the first header named key
+func Header(msg kafka.Message, key string) (string, bool) {
+	for _, h := range msg.Headers {
+		if h.Key == key {
+			return string(h.Value), true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,111 @@
+package kafkautil
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// WriterConfig holds producer settings. Defaults target at-least-once
+// delivery: every write waits for all in-sync replicas and is retried before
+// the error reaches the caller.
+type WriterConfig struct {
+	RequiredAcks    kafka.RequiredAcks
+	MaxAttempts     int
+	BatchSize       int
+	BatchBytes      int64
+	MaxMessageBytes int64 // largest message a producer sends; see CheckPayload
+	BatchTimeout    time.Duration
+	WriteTimeout    time.Duration
+	WriteBackoffMin time.Duration
+	WriteBackoffMax time.Duration
+	Compression     kafka.Compression
+}
+
+// WriterConfigFromEnv reads producer settings (KAFKA_REQUIRED_ACKS,
+// KAFKA_MAX_ATTEMPTS, KAFKA_BATCH_*, KAFKA_MAX_MESSAGE_BYTES, KAFKA_WRITE_*,
+// KAFKA_COMPRESSION). Invalid values are logged and replaced by the default.
+// BatchBytes is capped at MaxMessageBytes: the broker rejects a larger batch
+// whole, mid-way through a write.
+func WriterConfigFromEnv() WriterConfig {
+	cfg := WriterConfig{
+		RequiredAcks:    kafka.RequireAll,
+		MaxAttempts:     getEnvInt("KAFKA_MAX_ATTEMPTS", 10),
+		BatchSize:       getEnvInt("KAFKA_BATCH_SIZE", 100),
+		MaxMessageBytes: int64(getEnvInt("KAFKA_MAX_MESSAGE_BYTES", DefaultMaxMessageBytes)),
+		BatchTimeout:    getEnvDuration("KAFKA_BATCH_TIMEOUT", 10*time.Millisecond),
+		WriteTimeout:    getEnvDuration("KAFKA_WRITE_TIMEOUT", 10*time.Second),
+		WriteBackoffMin: getEnvDuration("KAFKA_WRITE_BACKOFF_MIN", 100*time.Millisecond),
+		WriteBackoffMax: getEnvDuration("KAFKA_WRITE_BACKOFF_MAX", 1*time.Second),
+		Compression:     kafka.Snappy,
+	}
+
+	if v := os.Getenv("KAFKA_REQUIRED_ACKS"); v != "" {
+		acks, err := ParseRequiredAcks(v)
+		if err != nil {
+			log.Printf("Warning: %v, using all", err)
+		} else {
+			cfg.RequiredAcks = acks
+		}
+	}
+	if v := os.Getenv("KAFKA_COMPRESSION"); v != "" {
+		var codec kafka.Compression
+		if err := codec.UnmarshalText([]byte(strings.ToLower(v))); err != nil {
+			log.Printf("Warning: invalid KAFKA_COMPRESSION %q, using snappy", v)
+		} else {
+			cfg.Compression = codec
+		}
+	}
+	if cfg.MaxMessageBytes <= 0 {
+		log.Printf("Warning: invalid KAFKA_MAX_MESSAGE_BYTES %d, using %d", cfg.MaxMessageBytes, DefaultMaxMessageBytes)
+		cfg.MaxMessageBytes = DefaultMaxMessageBytes
+	}
+	cfg.BatchBytes = int64(getEnvInt("KAFKA_BATCH_BYTES", int(cfg.MaxMessageBytes)))
+	if cfg.BatchBytes > cfg.MaxMessageBytes {
+		log.Printf("Warning: KAFKA_BATCH_BYTES %d over KAFKA_MAX_MESSAGE_BYTES, using %d", cfg.BatchBytes, cfg.MaxMessageBytes)
+		cfg.BatchBytes = cfg.MaxMessageBytes
+	}
+	return cfg
+}
+
+// ParseRequiredAcks accepts all/one/none or -1/1/0
+func ParseRequiredAcks(v string) (kafka.RequiredAcks, error) {
+	switch strings.ToLower(v) {
+	case "all", "-1":
+		return kafka.RequireAll, nil
+	case "one", "1":
+		return kafka.RequireOne, nil
+	case "none", "0":
+		return kafka.RequireNone, nil
+	}
+	return 0, fmt.Errorf("invalid KAFKA_REQUIRED_ACKS %q", v)
+}
+
+func (w WriterConfig) String() string {
+	return fmt.Sprintf("acks=%s attempts=%d batch=%d/%dB max_message=%dB linger=%s compression=%s",
+		w.RequiredAcks, w.MaxAttempts, w.BatchSize, w.BatchBytes, w.MaxMessageBytes, w.BatchTimeout, w.Compression)
+}
+
+// NewWriter creates a synchronous writer for topic, partitioning by key
+func (c Config) NewWriter(topic string, wc WriterConfig) *kafka.Writer {
+	return &kafka.Writer{
+		Addr:            kafka.TCP(c.Brokers...),
+		Topic:           topic,
+		Transport:       c.transport(),
+		Balancer:        &kafka.Hash{}, // partition by key (user_id)
+		RequiredAcks:    wc.RequiredAcks,
+		MaxAttempts:     wc.MaxAttempts,
+		BatchSize:       wc.BatchSize,
+		BatchBytes:      wc.BatchBytes,
+		BatchTimeout:    wc.BatchTimeout,
+		WriteTimeout:    wc.WriteTimeout,
+		WriteBackoffMin: wc.WriteBackoffMin,
+		WriteBackoffMax: wc.WriteBackoffMax,
+		Compression:     wc.Compression,
+		Async:           false, // WriteMessages returns only after the broker acks
+	}
+}
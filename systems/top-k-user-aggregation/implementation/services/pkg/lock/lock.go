@@ -0,0 +1,191 @@
+// Package lock runs singleton jobs: work that every replica of a service
+// schedules but only one should do at a time, like folding crawls into
+// cadences. A lock is a Redis key (lock:<name>) set with NX and a TTL,
+// renewed in the background while its job runs.
+//
+// Each acquisition of a name also draws a fencing token from a counter that
+// never goes back (lock:<name>:fence). A holder that paused past its TTL
+// (GC, a stalled VM) may still act after another replica took the lock;
+// stores that record the highest token they've seen can turn its writes
+// down. Stores that can't should call Held before anything they can't undo.
+package lock
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"expvar"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// ErrHeld is returned by Acquire when another holder has the lock
+var ErrHeld = errors.New("lock held elsewhere")
+
+var (
+	metricAcquired = expvar.NewMap("locks_acquired") // by name
+	metricBusy     = expvar.NewMap("locks_busy")     // held elsewhere, job skipped
+	metricLost     = expvar.NewMap("locks_lost")     // expired or taken over while held
+	metricHeld     = expvar.NewMap("locks_held")     // 1 while this process holds it
+	metricErrors   = expvar.NewMap("lock_errors")    // Redis errors acquiring, renewing or releasing
+)
+
+// Set the key and draw a token in one step, and only touch the key while it
+// still holds our value
+var (
+	acquireScript = redis.NewScript(`if redis.call("SET", KEYS[1], ARGV[1], "NX", "PX", ARGV[2]) then return redis.call("INCR", KEYS[2]) end return 0`)
+	renewScript   = redis.NewScript(`if redis.call("GET", KEYS[1]) == ARGV[1] then return redis.call("PEXPIRE", KEYS[1], ARGV[2]) end return 0`)
+	releaseScript = redis.NewScript(`if redis.call("GET", KEYS[1]) == ARGV[1] then return redis.call("DEL", KEYS[1]) end return 0`)
+)
+
+// Lock is a held lock, renewed every TTL/3 until Release. If a renewal finds
+// the key gone or taken, or renewals fail until the key may have expired,
+// Lost is closed and the holder should stop.
+type Lock struct {
+	Name  string
+	Fence int64 // fencing token: higher for every later acquisition of Name
+
+	rdb   *redis.Client
+	key   string
+	value string
+	ttl   time.Duration
+	stop  chan struct{}
+	done  chan struct{}
+	lost  chan struct{}
+}
+
+// Acquire takes the lock name for ttl, renewed until Release. It returns
+// ErrHeld when another holder has it.
+func Acquire(ctx context.Context, rdb *redis.Client, name string, ttl time.Duration) (*Lock, error) {
+	if ttl < 3*time.Millisecond {
+		return nil, fmt.Errorf("lock %s: ttl %s too short", name, ttl)
+	}
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return nil, err
+	}
+	l := &Lock{Name: name, rdb: rdb, key: "lock:" + name, value: hex.EncodeToString(buf), ttl: ttl,
+		stop: make(chan struct{}), done: make(chan struct{}), lost: make(chan struct{})}
+
+	fence, err := acquireScript.Run(ctx, rdb, []string{l.key, l.key + ":fence"}, l.value, ttl.Milliseconds()).Int64()
+	if err != nil {
+		metricErrors.Add(name, 1)
+		return nil, fmt.Errorf("acquire lock %s: %w", name, err)
+	}
+	if fence == 0 {
+		metricBusy.Add(name, 1)
+		return nil, ErrHeld
+	}
+	l.Fence = fence
+	metricAcquired.Add(name, 1)
+	metricHeld.Add(name, 1)
+	go l.renew(time.Now().Add(ttl))
+	return l, nil
+}
+
+// renew extends the lock every ttl/3. valid is when the key expires at the
+// latest, counted from before the last successful call; once the next
+// renewal would come after it, the lock counts as lost.
+func (l *Lock) renew(valid time.Time) {
+	defer close(l.done)
+	ticker := time.NewTicker(l.ttl / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-ticker.C:
+		}
+		start := time.Now()
+		ctx, cancel := context.WithTimeout(context.Background(), l.ttl/10)
+		n, err := renewScript.Run(ctx, l.rdb, []string{l.key}, l.value, l.ttl.Milliseconds()).Int()
+		cancel()
+		switch {
+		case err == nil && n == 1:
+			valid = start.Add(l.ttl)
+			continue
+		case err != nil:
+			metricErrors.Add(l.Name, 1)
+			if time.Until(valid) >= l.ttl/3 {
+				// Transient: the key outlives the next attempt
+				log.Printf("Warning: renewing lock %s: %v", l.Name, err)
+				continue
+			}
+			log.Printf("ALERT: lock %s may have expired, renewals failing: %v", l.Name, err)
+		default:
+			log.Printf("ALERT: lock %s (fence %d) lost", l.Name, l.Fence)
+		}
+		metricLost.Add(l.Name, 1)
+		metricHeld.Add(l.Name, -1)
+		close(l.lost)
+		return
+	}
+}
+
+// Lost is closed if the lock expired or was taken over
+func (l *Lock) Lost() <-chan struct{} { return l.lost }
+
+// Held checks in Redis that the lock is still ours, for a holder about to do
+// something a fencing token can't guard
+func (l *Lock) Held(ctx context.Context) (bool, error) {
+	select {
+	case <-l.lost:
+		return false, nil
+	default:
+	}
+	v, err := l.rdb.Get(ctx, l.key).Result()
+	if err == redis.Nil {
+		return false, nil
+	}
+	if err != nil {
+		metricErrors.Add(l.Name, 1)
+		return false, err
+	}
+	return v == l.value, nil
+}
+
+// Release stops renewing and frees the lock, unless it was already lost
+func (l *Lock) Release(ctx context.Context) error {
+	close(l.stop)
+	<-l.done
+	select {
+	case <-l.lost:
+		return nil
+	default:
+	}
+	metricHeld.Add(l.Name, -1)
+	if err := releaseScript.Run(ctx, l.rdb, []string{l.key}, l.value).Err(); err != nil {
+		// The key expires after its TTL anyway
+		metricErrors.Add(l.Name, 1)
+		return fmt.Errorf("release lock %s: %w", l.Name, err)
+	}
+	return nil
+}
+
+// Run runs job under the lock name if no one else holds it, and reports
+// whether it ran. job's context is canceled if the lock is lost.
+func Run(ctx context.Context, rdb *redis.Client, name string, ttl time.Duration, job func(ctx context.Context, l *Lock) error) (bool, error) {
+	l, err := Acquire(ctx, rdb, name, ttl)
+	if err == ErrHeld {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer l.Release(context.Background())
+
+	jobCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		select {
+		case <-l.Lost():
+			cancel()
+		case <-jobCtx.Done():
+		}
+	}()
+	return true, job(jobCtx, l)
+}
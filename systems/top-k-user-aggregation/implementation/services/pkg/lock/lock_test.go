@@ -0,0 +1,202 @@
+package lock
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func newRedis(t *testing.T) (*miniredis.Miniredis, *redis.Client) {
+	m := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: m.Addr()})
+	t.Cleanup(func() { rdb.Close() })
+	return m, rdb
+}
+
+func acquire(t *testing.T, rdb *redis.Client, name string, ttl time.Duration) *Lock {
+	t.Helper()
+	l, err := Acquire(context.Background(), rdb, name, ttl)
+	if err != nil {
+		t.Fatalf("Acquire(%s): %v", name, err)
+	}
+	return l
+}
+
+// waitLost waits for l to be lost, up to a few renewals
+func waitLost(t *testing.T, l *Lock) {
+	t.Helper()
+	select {
+	case <-l.Lost():
+	case <-time.After(2 * l.ttl):
+		t.Fatalf("lock %s not lost after %s", l.Name, 2*l.ttl)
+	}
+}
+
+func TestAcquireBusy(t *testing.T) {
+	m, rdb := newRedis(t)
+	ctx := context.Background()
+
+	l := acquire(t, rdb, "job", time.Minute)
+	if _, err := Acquire(ctx, rdb, "job", time.Minute); !errors.Is(err, ErrHeld) {
+		t.Fatalf("second Acquire = %v, want ErrHeld", err)
+	}
+	// Other names are separate locks
+	other := acquire(t, rdb, "other", time.Minute)
+	defer other.Release(ctx)
+
+	if held, err := l.Held(ctx); err != nil || !held {
+		t.Errorf("Held = %v, %v; want true", held, err)
+	}
+	if err := l.Release(ctx); err != nil {
+		t.Fatalf("Release: %v", err)
+	}
+	if m.Exists("lock:job") {
+		t.Errorf("lock:job still set after Release")
+	}
+	again := acquire(t, rdb, "job", time.Minute)
+	again.Release(ctx)
+}
+
+func TestAcquireRejectsShortTTL(t *testing.T) {
+	_, rdb := newRedis(t)
+	if _, err := Acquire(context.Background(), rdb, "job", time.Millisecond); err == nil {
+		t.Errorf("Acquire with a 1ms TTL succeeded")
+	}
+}
+
+func TestRenewal(t *testing.T) {
+	m, rdb := newRedis(t)
+	const ttl = 300 * time.Millisecond
+	l := acquire(t, rdb, "job", ttl)
+	defer l.Release(context.Background())
+
+	// miniredis only expires keys when told to: take the key close to
+	// expiring and let a renewal (every ttl/3) extend it again
+	m.FastForward(250 * time.Millisecond)
+	time.Sleep(ttl/3 + 50*time.Millisecond)
+	if got := m.TTL("lock:job"); got <= 250*time.Millisecond {
+		t.Fatalf("TTL after a renewal = %s, want close to %s", got, ttl)
+	}
+	select {
+	case <-l.Lost():
+		t.Fatalf("lock lost while renewed")
+	default:
+	}
+}
+
+func TestLostOnTakeover(t *testing.T) {
+	m, rdb := newRedis(t)
+	ctx := context.Background()
+	l := acquire(t, rdb, "job", 150*time.Millisecond)
+
+	// Another holder's value, as after the key expired and was taken
+	m.Set("lock:job", "someone-else")
+	waitLost(t, l)
+	if held, err := l.Held(ctx); err != nil || held {
+		t.Errorf("Held after takeover = %v, %v; want false", held, err)
+	}
+	// Releasing a lost lock leaves the new holder's key alone
+	if err := l.Release(ctx); err != nil {
+		t.Fatalf("Release: %v", err)
+	}
+	if v, _ := m.Get("lock:job"); v != "someone-else" {
+		t.Errorf("lock:job = %q after releasing a lost lock, want the new holder's", v)
+	}
+}
+
+func TestLostOnExpiry(t *testing.T) {
+	m, rdb := newRedis(t)
+	l := acquire(t, rdb, "job", 150*time.Millisecond)
+	defer l.Release(context.Background())
+	m.FastForward(time.Second)
+	waitLost(t, l)
+}
+
+func TestFenceGrows(t *testing.T) {
+	m, rdb := newRedis(t)
+	ctx := context.Background()
+
+	var last int64
+	next := func(how string, l *Lock) {
+		t.Helper()
+		if l.Fence <= last {
+			t.Errorf("fence after %s = %d, want more than %d", how, l.Fence, last)
+		}
+		last = l.Fence
+	}
+
+	l := acquire(t, rdb, "job", time.Minute)
+	next("the first acquisition", l)
+	l.Release(ctx)
+
+	l = acquire(t, rdb, "job", time.Minute)
+	next("a release", l)
+
+	// A holder that stalled past its TTL: the next one gets a higher token,
+	// which a store can hold against the stalled one's writes
+	m.FastForward(2 * time.Minute)
+	stalled := l
+	l = acquire(t, rdb, "job", time.Minute)
+	next("an expiry", l)
+	if stalled.Fence >= l.Fence {
+		t.Errorf("stalled holder's fence %d not below the new holder's %d", stalled.Fence, l.Fence)
+	}
+	l.Release(ctx)
+	stalled.Release(ctx)
+
+	// Busy attempts don't use tokens up, and other names count their own
+	l = acquire(t, rdb, "job", time.Minute)
+	if _, err := Acquire(ctx, rdb, "job", time.Minute); !errors.Is(err, ErrHeld) {
+		t.Fatalf("Acquire while held = %v, want ErrHeld", err)
+	}
+	l.Release(ctx)
+	l = acquire(t, rdb, "job", time.Minute)
+	if l.Fence != last+2 {
+		t.Errorf("fence after a busy attempt = %d, want %d", l.Fence, last+2)
+	}
+	l.Release(ctx)
+	if other := acquire(t, rdb, "other", time.Minute); other.Fence != 1 {
+		t.Errorf("first fence of another name = %d, want 1", other.Fence)
+	}
+}
+
+func TestRun(t *testing.T) {
+	m, rdb := newRedis(t)
+	ctx := context.Background()
+
+	ran, err := Run(ctx, rdb, "job", time.Minute, func(ctx context.Context, l *Lock) error {
+		// Busy: another replica's tick is skipped, not an error
+		ran, err := Run(ctx, rdb, "job", time.Minute, func(context.Context, *Lock) error {
+			t.Errorf("job ran under a held lock")
+			return nil
+		})
+		if ran || err != nil {
+			t.Errorf("Run while held = %v, %v; want false, nil", ran, err)
+		}
+		return errors.New("job failed")
+	})
+	if !ran || err == nil || err.Error() != "job failed" {
+		t.Errorf("Run = %v, %v; want true and the job's error", ran, err)
+	}
+	if m.Exists("lock:job") {
+		t.Errorf("lock:job still set after Run")
+	}
+
+	// Losing the lock cancels the job
+	ran, err = Run(ctx, rdb, "job", 150*time.Millisecond, func(ctx context.Context, l *Lock) error {
+		m.Set("lock:job", "someone-else")
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Second):
+			return errors.New("job not canceled")
+		}
+	})
+	if !ran || !errors.Is(err, context.Canceled) {
+		t.Errorf("Run losing its lock = %v, %v; want true, context.Canceled", ran, err)
+	}
+}
@@ -0,0 +1,104 @@
+// Package providerhealth tracks how a provider's crawls fare, so a failing
+// provider can be paused instead of burning its crawl jobs' retries. Crawl
+// workers record each provider call in per-minute Redis counters shared by
+// every worker; the crawl-scheduler's monitor reads them back over a window.
+//
+// Each provider's crawl jobs have their own asynq queue (Queue), so pausing
+// one provider leaves the others running.
+package providerhealth
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Queue is the asynq queue of a provider's crawl jobs
+func Queue(provider string) string {
+	return "crawl-" + provider
+}
+
+// Retention is how long the per-minute counters are kept: the longest
+// window Counts can sum
+const Retention = time.Hour
+
+const (
+	keyPrefix    = "provider:health:"
+	providersKey = keyPrefix + "providers" // every provider ever recorded
+)
+
+func bucketKey(provider string, minute int64) string {
+	return keyPrefix + provider + ":" + strconv.FormatInt(minute, 10)
+}
+
+// Record counts one call to provider's API, failed or not
+func Record(ctx context.Context, rdb *redis.Client, provider string, failed bool) error {
+	field := "ok"
+	if failed {
+		field = "error"
+	}
+	key := bucketKey(provider, time.Now().Unix()/60)
+	_, err := rdb.Pipelined(ctx, func(p redis.Pipeliner) error {
+		p.SAdd(ctx, providersKey, provider)
+		p.HIncrBy(ctx, key, field, 1)
+		p.Expire(ctx, key, Retention+time.Minute)
+		return nil
+	})
+	return err
+}
+
+// Providers returns every provider a crawl was recorded for
+func Providers(ctx context.Context, rdb *redis.Client) ([]string, error) {
+	return rdb.SMembers(ctx, providersKey).Result()
+}
+
+// Counts are a provider's recorded calls over a window
+type Counts struct {
+	Calls  int64
+	Errors int64
+}
+
+// ErrorRate is the failed fraction of the calls, 0 without any
+func (c Counts) ErrorRate() float64 {
+	if c.Calls == 0 {
+		return 0
+	}
+	return float64(c.Errors) / float64(c.Calls)
+}
+
+// Window sums provider's counters from since (at most Retention ago) to
+// now, in whole minutes
+func Window(ctx context.Context, rdb *redis.Client, provider string, since time.Time) (Counts, error) {
+	now := time.Now()
+	if oldest := now.Add(-Retention); since.Before(oldest) {
+		since = oldest
+	}
+	var cmds []*redis.SliceCmd
+	_, err := rdb.Pipelined(ctx, func(p redis.Pipeliner) error {
+		for m := since.Unix() / 60; m <= now.Unix()/60; m++ {
+			cmds = append(cmds, p.HMGet(ctx, bucketKey(provider, m), "ok", "error"))
+		}
+		return nil
+	})
+	if err != nil {
+		return Counts{}, fmt.Errorf("read %s health: %w", provider, err)
+	}
+
+	var c Counts
+	for _, cmd := range cmds {
+		vals := cmd.Val()
+		ok, errs := toInt(vals[0]), toInt(vals[1])
+		c.Calls += ok + errs
+		c.Errors += errs
+	}
+	return c, nil
+}
+
+func toInt(v interface{}) int64 {
+	s, _ := v.(string)
+	n, _ := strconv.ParseInt(s, 10, 64)
+	return n
+}
@@ -0,0 +1,41 @@
+package providerhealth
+
+import (
+	"fmt"
+	"hash/fnv"
+)
+
+// UserShard is the crawl shard of a user among shards, from a hash of the
+// user ID, so a user's crawls always go to the same queue
+func UserShard(userID string, shards int) int {
+	h := fnv.New32a()
+	h.Write([]byte(userID))
+	return int(h.Sum32() % uint32(shards))
+}
+
+// ShardQueue is the asynq queue of a provider's crawl jobs in shard. The
+// name doesn't depend on the shard count: a worker serving shard 3 keeps
+// draining it when the count changes.
+func ShardQueue(provider string, shard int) string {
+	return fmt.Sprintf("%s-%d", Queue(provider), shard)
+}
+
+// UserQueue is the queue of a user's crawl jobs for provider: the
+// provider's Queue unsharded (shards <= 1), its shard's otherwise
+func UserQueue(provider, userID string, shards int) string {
+	if shards <= 1 {
+		return Queue(provider)
+	}
+	return ShardQueue(provider, UserShard(userID, shards))
+}
+
+// Queues lists every queue holding a provider's crawl jobs with shards:
+// Queue, which also drains the jobs enqueued before sharding, then each
+// shard's
+func Queues(provider string, shards int) []string {
+	queues := []string{Queue(provider)}
+	for s := 0; s < shards && shards > 1; s++ {
+		queues = append(queues, ShardQueue(provider, s))
+	}
+	return queues
+}
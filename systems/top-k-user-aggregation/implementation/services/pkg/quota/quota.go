@@ -0,0 +1,246 @@
+// Package quota caps how many listen events a user may send per day, so a
+// runaway client can't flood the user's aggregates. The counters live in
+// Redis, one per user and listen day, shared by every producer: ingest and
+// the crawl-workers count against the same quota.
+//
+// Events over the quota are either throttled (published to a separate
+// topic, kept for inspection or a later replay) or dropped; either way they
+// never reach user.listen.raw.
+package quota
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/system-design-lab/pkg/events"
+)
+
+// Actions on events over the quota
+const (
+	Throttle = "throttle" // publish to Config.Topic
+	Drop     = "drop"
+)
+
+// DefaultTopic is where throttled events go unless THROTTLED_TOPIC says
+// otherwise
+const DefaultTopic = "user.listen.throttled"
+
+// ttl is how long a day's counter outlives its last event: long enough for
+// late events of yesterday to still count
+const ttl = 48 * time.Hour
+
+// Config describes a quota
+type Config struct {
+	Daily  int64  // events per user and listen day; 0 disables the quota
+	Action string // Throttle (default) or Drop
+	Topic  string // of throttled events
+}
+
+// Enabled reports whether the quota limits anything
+func (c Config) Enabled() bool { return c.Daily > 0 }
+
+func (c Config) String() string {
+	if !c.Enabled() {
+		return "off"
+	}
+	if c.Action == Drop {
+		return fmt.Sprintf("%d/user/day, drop", c.Daily)
+	}
+	return fmt.Sprintf("%d/user/day, throttle to %s", c.Daily, c.Topic)
+}
+
+// ConfigFromEnv reads USER_DAILY_QUOTA, QUOTA_ACTION and THROTTLED_TOPIC
+func ConfigFromEnv() (Config, error) {
+	cfg := Config{Action: Throttle, Topic: DefaultTopic}
+	if v := os.Getenv("USER_DAILY_QUOTA"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n < 0 {
+			return cfg, fmt.Errorf("USER_DAILY_QUOTA=%q: want a count of events, 0 for none", v)
+		}
+		cfg.Daily = n
+	}
+	if v := os.Getenv("QUOTA_ACTION"); v != "" {
+		if v != Throttle && v != Drop {
+			return cfg, fmt.Errorf("QUOTA_ACTION=%q: want %s or %s", v, Throttle, Drop)
+		}
+		cfg.Action = v
+	}
+	if v := os.Getenv("THROTTLED_TOPIC"); v != "" {
+		cfg.Topic = v
+	}
+	return cfg, nil
+}
+
+// Per-quota metrics, keyed by name
+var (
+	metricAllowed   = expvar.NewMap("quota_allowed")   // events within the quota
+	metricThrottled = expvar.NewMap("quota_throttled") // over it, sent to the throttled topic
+	metricDropped   = expvar.NewMap("quota_dropped")   // over it, dropped
+	metricErrors    = expvar.NewMap("quota_errors")    // Redis errors, events let through
+)
+
+// takeScript takes up to ARGV[i+2] events for each KEYS[i], never past the
+// quota. ARGV: quota, TTL in seconds, then the counts. Returns the events
+// taken and the count before, per key.
+var takeScript = redis.NewScript(`
+local quota = tonumber(ARGV[1])
+local res = {}
+for i, key in ipairs(KEYS) do
+  local n = tonumber(ARGV[i + 2])
+  local used = tonumber(redis.call('GET', key)) or 0
+  local take = math.max(0, math.min(n, quota - used))
+  if take > 0 then
+    redis.call('INCRBY', key, take)
+  end
+  redis.call('EXPIRE', key, ARGV[2])
+  res[#res + 1] = take
+  res[#res + 1] = used
+end
+return res
+`)
+
+// Quota counts events against the daily quota. A nil *Quota allows
+// everything.
+type Quota struct {
+	rdb  *redis.Client
+	cfg  Config
+	name string
+}
+
+// New returns the quota cfg describes, nil when it's disabled. name labels
+// its metrics.
+func New(cfg Config, rdb *redis.Client, name string) *Quota {
+	if !cfg.Enabled() {
+		return nil
+	}
+	return &Quota{rdb: rdb, cfg: cfg, name: name}
+}
+
+// Config returns the quota's settings
+func (q *Quota) Config() Config { return q.cfg }
+
+// usage is one counter a batch adds to
+type usage struct {
+	key    string
+	userID string
+	day    string
+	n      int64
+}
+
+func counterKey(userID, day string) string {
+	return "quota:" + userID + ":" + day
+}
+
+// group counts evs per user and listen day, in order of first appearance
+func group(evs []events.ListenEvent) []*usage {
+	var order []*usage
+	byKey := make(map[string]*usage)
+	for _, e := range evs {
+		k := counterKey(e.UserID, e.Day())
+		u, ok := byKey[k]
+		if !ok {
+			u = &usage{key: k, userID: e.UserID, day: e.Day()}
+			byKey[k] = u
+			order = append(order, u)
+		}
+		u.n++
+	}
+	return order
+}
+
+// Take counts evs against their users' quotas and reports which ones are
+// over it (over[i] for evs[i]); within a user's day, the first events are
+// the ones allowed. over is nil when every event is allowed.
+//
+// When Redis fails every event is allowed and the error returned: the quota
+// is a guard rail, not worth losing listens over.
+func (q *Quota) Take(ctx context.Context, evs []events.ListenEvent) ([]bool, error) {
+	if q == nil || len(evs) == 0 {
+		return nil, nil
+	}
+	order := group(evs)
+	keys := make([]string, len(order))
+	args := []interface{}{q.cfg.Daily, int64(ttl.Seconds())}
+	for i, u := range order {
+		keys[i] = u.key
+		args = append(args, u.n)
+	}
+	res, err := takeScript.Run(ctx, q.rdb, keys, args...).Int64Slice()
+	if err == nil && len(res) != 2*len(order) {
+		err = fmt.Errorf("quota script returned %d values for %d counters", len(res), len(order))
+	}
+	if err != nil {
+		metricErrors.Add(q.name, 1)
+		metricAllowed.Add(q.name, int64(len(evs)))
+		return nil, fmt.Errorf("quota: %w", err)
+	}
+
+	left := make(map[string]int64, len(order))
+	for i, u := range order {
+		take, used := res[2*i], res[2*i+1]
+		left[u.key] = take
+		if take < u.n && used < q.cfg.Daily {
+			// Logged once per user and day, by whoever crosses the line
+			crossed := "dropped"
+			if q.cfg.Action != Drop {
+				crossed = "sent to " + q.cfg.Topic
+			}
+			log.Printf("Warning: user=%s reached its quota of %d events on %s, further events are %s",
+				u.userID, q.cfg.Daily, u.day, crossed)
+		}
+	}
+
+	var over []bool
+	allowed := int64(0)
+	for i, e := range evs {
+		k := counterKey(e.UserID, e.Day())
+		if left[k] > 0 {
+			left[k]--
+			allowed++
+			continue
+		}
+		if over == nil {
+			over = make([]bool, len(evs))
+		}
+		over[i] = true
+	}
+	metricAllowed.Add(q.name, allowed)
+	if n := int64(len(evs)) - allowed; n > 0 {
+		if q.cfg.Action == Drop {
+			metricDropped.Add(q.name, n)
+		} else {
+			metricThrottled.Add(q.name, n)
+		}
+	}
+	return over, nil
+}
+
+// Refund gives back the quota evs took, for events Take allowed that were
+// not published after all, so a retry isn't counted twice. Errors are
+// returned but leave the counters as they were.
+func (q *Quota) Refund(ctx context.Context, evs []events.ListenEvent) error {
+	if q == nil || len(evs) == 0 {
+		return nil
+	}
+	order := group(evs)
+	_, err := q.rdb.Pipelined(ctx, func(p redis.Pipeliner) error {
+		for _, u := range order {
+			p.DecrBy(ctx, u.key, u.n)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("quota refund: %w", err)
+	}
+	return nil
+}
+
+// Throttled reports whether over-quota events go to the throttled topic
+// rather than being dropped
+func (q *Quota) Throttled() bool { return q != nil && q.cfg.Action != Drop }
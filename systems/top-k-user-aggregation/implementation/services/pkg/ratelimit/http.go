@@ -0,0 +1,48 @@
+package ratelimit
+
+import (
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Middleware limits next per key(r). Denied requests get a 429 with
+// Retry-After. When the limiter fails (Redis down) the request goes through:
+// the limit is a guard rail, not worth an outage.
+func Middleware(l Limiter, key func(*http.Request) string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		d, err := l.Allow(r.Context(), key(r))
+		if err != nil {
+			log.Printf("ratelimit: %v (letting the request through)", err)
+			next.ServeHTTP(w, r)
+			return
+		}
+		if !d.Allowed {
+			secs := int((d.RetryAfter + time.Second - 1) / time.Second)
+			w.Header().Set("Retry-After", strconv.Itoa(secs))
+			http.Error(w, "rate limit exceeded", http.StatusTooManyRequests)
+			return
+		}
+		if d.Remaining >= 0 {
+			w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(d.Remaining))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// ClientIP keys a request by the client's address: the first X-Forwarded-For
+// hop when a proxy set one, else the connection's peer
+func ClientIP(r *http.Request) string {
+	if fwd := r.Header.Get("X-Forwarded-For"); fwd != "" {
+		first, _, _ := strings.Cut(fwd, ",")
+		return strings.TrimSpace(first)
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,159 @@
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// sweepEvery is how often the local limiters drop the state of idle keys
+const sweepEvery = time.Minute
+
+// LocalTokenBucket is a token bucket per key in process memory. It is safe
+// for concurrent use.
+type LocalTokenBucket struct {
+	rate  float64
+	burst float64
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// NewLocalTokenBucket allows rate per second and key, up to burst at once
+func NewLocalTokenBucket(rate float64, burst int) *LocalTokenBucket {
+	return &LocalTokenBucket{rate: rate, burst: float64(burst), buckets: make(map[string]*bucket), now: time.Now}
+}
+
+func (l *LocalTokenBucket) Allow(_ context.Context, key string) (Decision, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.sweep(now)
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+	// A clock that steps back refills nothing until it passes the last
+	// request again, rather than refilling twice
+	if now.After(b.last) {
+		b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+		b.last = now
+	}
+
+	if b.tokens < 1 {
+		wait := (1 - b.tokens) / l.rate
+		return Decision{RetryAfter: time.Duration(wait * float64(time.Second))}, nil
+	}
+	b.tokens--
+	return Decision{Allowed: true, Remaining: int(b.tokens)}, nil
+}
+
+// sweep drops buckets that have refilled completely; l.mu must be held
+func (l *LocalTokenBucket) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < sweepEvery {
+		return
+	}
+	l.lastSweep = now
+	full := time.Duration(l.burst / l.rate * float64(time.Second))
+	for key, b := range l.buckets {
+		if now.Sub(b.last) > full {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// LocalSlidingWindow allows limit requests per window and key in process
+// memory, counting fixed windows and weighting the previous one by its
+// overlap with the sliding window. It is safe for concurrent use.
+type LocalSlidingWindow struct {
+	limit  int
+	window time.Duration
+
+	mu        sync.Mutex
+	counters  map[string]*windowCount
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+type windowCount struct {
+	start     time.Time // of the current fixed window
+	cur, prev int
+}
+
+// NewLocalSlidingWindow allows limit requests per window and key
+func NewLocalSlidingWindow(limit int, window time.Duration) *LocalSlidingWindow {
+	return &LocalSlidingWindow{limit: limit, window: window, counters: make(map[string]*windowCount), now: time.Now}
+}
+
+func (l *LocalSlidingWindow) Allow(_ context.Context, key string) (Decision, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.sweep(now)
+	start := now.Truncate(l.window)
+	c, ok := l.counters[key]
+	switch {
+	case !ok:
+		c = &windowCount{start: start}
+		l.counters[key] = c
+	case start.Before(c.start):
+		// Clock stepped back: count into the window already open
+		now, start = c.start, c.start
+	case start.Equal(c.start.Add(l.window)):
+		c.start, c.prev, c.cur = start, c.cur, 0
+	case start.After(c.start):
+		c.start, c.prev, c.cur = start, 0, 0
+	}
+
+	d := slidingDecision(l.limit, l.window, now.Sub(start), c.prev, c.cur)
+	if d.Allowed {
+		c.cur++
+	}
+	return d, nil
+}
+
+// sweep drops counters idle for two windows; l.mu must be held
+func (l *LocalSlidingWindow) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < sweepEvery {
+		return
+	}
+	l.lastSweep = now
+	for key, c := range l.counters {
+		if now.Sub(c.start) > 2*l.window {
+			delete(l.counters, key)
+		}
+	}
+}
+
+// slidingDecision decides a request elapsed into the current fixed window,
+// with prev and cur counted in the previous and current ones
+func slidingDecision(limit int, window, elapsed time.Duration, prev, cur int) Decision {
+	overlap := 1 - float64(elapsed)/float64(window)
+	used := float64(prev)*overlap + float64(cur)
+	if used+1 <= float64(limit) {
+		return Decision{Allowed: true, Remaining: int(float64(limit) - used - 1)}
+	}
+
+	// Denied: wait until the previous window's share has shrunk enough, or
+	// for the next window if the current one alone is full
+	next := window - elapsed
+	if cur+1 <= limit && prev > 0 {
+		// prev × (1 - t/window) + cur + 1 <= limit
+		t := time.Duration((1 - float64(limit-cur-1)/float64(prev)) * float64(window))
+		if t > elapsed && t-elapsed < next {
+			next = t - elapsed
+		}
+	}
+	return Decision{RetryAfter: next}
+}
@@ -0,0 +1,189 @@
+// Package ratelimit limits how often a key (a client, a user, a provider)
+// may act. Two algorithms: a token bucket (a steady rate, with bursts up to
+// the bucket size) and a sliding window (at most N per window, weighting the
+// previous window by how much of it still overlaps). Each runs in process or
+// in Redis, where every instance shares the limit and the Redis clock, so
+// clock skew between hosts doesn't change it.
+package ratelimit
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Decision is a limiter's answer for one request
+type Decision struct {
+	Allowed   bool
+	Remaining int // requests still allowed right now; -1 when unlimited
+	// RetryAfter is, when denied, how long until the next request would be
+	// allowed
+	RetryAfter time.Duration
+}
+
+// Limiter decides whether key may act now. Allowed requests count against
+// the limit; denied ones don't.
+type Limiter interface {
+	Allow(ctx context.Context, key string) (Decision, error)
+}
+
+// Algorithms and backends of Config
+const (
+	TokenBucket   = "token-bucket"
+	SlidingWindow = "sliding-window"
+
+	Local = "local"
+	Redis = "redis"
+)
+
+// Config describes a limiter
+type Config struct {
+	Algorithm string  // TokenBucket (default) or SlidingWindow
+	Backend   string  // Local (default) or Redis
+	Rate      float64 // allowed per second and key
+	// Burst is the token bucket's size: how many requests may come at once
+	// after a quiet spell (default: Rate, at least 1)
+	Burst int
+	// Window is the sliding window's length (default 1s); it allows
+	// Rate × Window requests per window
+	Window time.Duration
+}
+
+// Per-limiter metrics, keyed by name
+var (
+	metricAllowed = expvar.NewMap("ratelimit_allowed")
+	metricDenied  = expvar.NewMap("ratelimit_denied")
+	metricErrors  = expvar.NewMap("ratelimit_errors")
+)
+
+// New builds the limiter a Config describes. name labels its metrics and,
+// with the Redis backend, prefixes its keys (ratelimit:<name>:<key>).
+func New(cfg Config, rdb *redis.Client, name string) (Limiter, error) {
+	if cfg.Rate <= 0 {
+		return nil, fmt.Errorf("rate must be > 0")
+	}
+	if cfg.Burst <= 0 {
+		cfg.Burst = int(cfg.Rate)
+		if cfg.Burst < 1 {
+			cfg.Burst = 1
+		}
+	}
+	if cfg.Window <= 0 {
+		cfg.Window = time.Second
+	}
+	if cfg.Backend == Redis && rdb == nil {
+		return nil, fmt.Errorf("the redis backend needs a client")
+	}
+
+	var l Limiter
+	prefix := "ratelimit:" + name + ":"
+	limit := int(cfg.Rate * cfg.Window.Seconds())
+	if limit < 1 {
+		limit = 1
+	}
+	switch {
+	case (cfg.Algorithm == "" || cfg.Algorithm == TokenBucket) && (cfg.Backend == "" || cfg.Backend == Local):
+		l = NewLocalTokenBucket(cfg.Rate, cfg.Burst)
+	case (cfg.Algorithm == "" || cfg.Algorithm == TokenBucket) && cfg.Backend == Redis:
+		l = NewRedisTokenBucket(rdb, prefix, cfg.Rate, cfg.Burst)
+	case cfg.Algorithm == SlidingWindow && (cfg.Backend == "" || cfg.Backend == Local):
+		l = NewLocalSlidingWindow(limit, cfg.Window)
+	case cfg.Algorithm == SlidingWindow && cfg.Backend == Redis:
+		l = NewRedisSlidingWindow(rdb, prefix, limit, cfg.Window)
+	default:
+		return nil, fmt.Errorf("unknown algorithm %q or backend %q", cfg.Algorithm, cfg.Backend)
+	}
+	return instrumented{l, name}, nil
+}
+
+// instrumented counts a limiter's decisions
+type instrumented struct {
+	Limiter
+	name string
+}
+
+func (i instrumented) Allow(ctx context.Context, key string) (Decision, error) {
+	d, err := i.Limiter.Allow(ctx, key)
+	switch {
+	case err != nil:
+		metricErrors.Add(i.name, 1)
+	case d.Allowed:
+		metricAllowed.Add(i.name, 1)
+	default:
+		metricDenied.Add(i.name, 1)
+	}
+	return d, err
+}
+
+// Switch is a Limiter rebuilt whenever its Config changes, for a rate set
+// at runtime. With a Rate of 0 it allows everything.
+type Switch struct {
+	rdb  *redis.Client
+	name string
+
+	mu  sync.Mutex
+	cfg Config
+	l   atomic.Pointer[Limiter]
+}
+
+// NewSwitch returns a Switch allowing everything until Configure; rdb and
+// name are New's
+func NewSwitch(rdb *redis.Client, name string) *Switch {
+	return &Switch{rdb: rdb, name: name}
+}
+
+// Configure makes cfg the limit, a Rate of 0 lifting it, and reports
+// whether it changed. An unchanged cfg keeps the limiter and its counts; an
+// invalid one keeps the limit in effect.
+func (s *Switch) Configure(cfg Config) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if cfg == s.cfg {
+		return false, nil
+	}
+	if cfg.Rate <= 0 {
+		s.l.Store(nil)
+		s.cfg = cfg
+		return true, nil
+	}
+	l, err := New(cfg, s.rdb, s.name)
+	if err != nil {
+		return false, err
+	}
+	s.l.Store(&l)
+	s.cfg = cfg
+	return true, nil
+}
+
+func (s *Switch) Allow(ctx context.Context, key string) (Decision, error) {
+	l := s.l.Load()
+	if l == nil {
+		return Decision{Allowed: true, Remaining: -1}, nil
+	}
+	return (*l).Allow(ctx, key)
+}
+
+// Wait blocks until l allows key, or ctx is done
+func Wait(ctx context.Context, l Limiter, key string) error {
+	for {
+		d, err := l.Allow(ctx, key)
+		if err != nil {
+			return err
+		}
+		if d.Allowed {
+			return nil
+		}
+		t := time.NewTimer(d.RetryAfter)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+}
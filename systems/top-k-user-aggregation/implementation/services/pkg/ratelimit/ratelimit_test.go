@@ -0,0 +1,273 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+// t0 starts a fixed window of every length the tests use
+var t0 = time.Unix(1_700_000_000, 0)
+
+// clock is the time a test sets: the local limiters' now, or the Redis
+// server's (miniredis SetTime), whose TIME the scripts read
+type clock interface {
+	set(time.Time)
+}
+
+type localClock struct{ t time.Time }
+
+func (c *localClock) set(t time.Time) { c.t = t }
+func (c *localClock) now() time.Time  { return c.t }
+
+type redisClock struct{ m *miniredis.Miniredis }
+
+func (c redisClock) set(t time.Time) { c.m.SetTime(t) }
+
+// backend builds a limiter for a test along with the clock driving it
+type backend struct {
+	name        string
+	tokenBucket func(t *testing.T, rate float64, burst int) (Limiter, clock)
+	window      func(t *testing.T, limit int, window time.Duration) (Limiter, clock)
+}
+
+func newRedis(t *testing.T) (*miniredis.Miniredis, *redis.Client) {
+	m := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: m.Addr()})
+	t.Cleanup(func() { rdb.Close() })
+	return m, rdb
+}
+
+var backends = []backend{
+	{
+		name: Local,
+		tokenBucket: func(t *testing.T, rate float64, burst int) (Limiter, clock) {
+			c := &localClock{}
+			l := NewLocalTokenBucket(rate, burst)
+			l.now = c.now
+			return l, c
+		},
+		window: func(t *testing.T, limit int, window time.Duration) (Limiter, clock) {
+			c := &localClock{}
+			l := NewLocalSlidingWindow(limit, window)
+			l.now = c.now
+			return l, c
+		},
+	},
+	{
+		name: Redis,
+		tokenBucket: func(t *testing.T, rate float64, burst int) (Limiter, clock) {
+			m, rdb := newRedis(t)
+			return NewRedisTokenBucket(rdb, "ratelimit:test:", rate, burst), redisClock{m}
+		},
+		window: func(t *testing.T, limit int, window time.Duration) (Limiter, clock) {
+			m, rdb := newRedis(t)
+			return NewRedisSlidingWindow(rdb, "ratelimit:test:", limit, window), redisClock{m}
+		},
+	},
+}
+
+// allow asks l for key and checks the answer: remaining when allowed, the
+// wait when not
+func allow(t *testing.T, l Limiter, key string, allowed bool, remaining int, retryAfter time.Duration) {
+	t.Helper()
+	d, err := l.Allow(context.Background(), key)
+	if err != nil {
+		t.Fatalf("Allow(%s): %v", key, err)
+	}
+	if d.Allowed != allowed {
+		t.Fatalf("Allow(%s).Allowed = %v, want %v (%+v)", key, d.Allowed, allowed, d)
+	}
+	if allowed && d.Remaining != remaining {
+		t.Errorf("Allow(%s).Remaining = %d, want %d", key, d.Remaining, remaining)
+	}
+	// The local limiters compute the wait in floating point
+	if diff := d.RetryAfter - retryAfter; !allowed && (diff > time.Microsecond || diff < -time.Microsecond) {
+		t.Errorf("Allow(%s).RetryAfter = %s, want %s", key, d.RetryAfter, retryAfter)
+	}
+}
+
+func TestTokenBucketBurst(t *testing.T) {
+	for _, b := range backends {
+		t.Run(b.name, func(t *testing.T) {
+			l, c := b.tokenBucket(t, 1, 3)
+			c.set(t0)
+			allow(t, l, "a", true, 2, 0)
+			allow(t, l, "a", true, 1, 0)
+			allow(t, l, "a", true, 0, 0)
+			allow(t, l, "a", false, 0, time.Second)
+			allow(t, l, "a", false, 0, time.Second) // denied requests cost nothing
+			// Each key has its own bucket
+			allow(t, l, "b", true, 2, 0)
+		})
+	}
+}
+
+func TestTokenBucketRefill(t *testing.T) {
+	for _, b := range backends {
+		t.Run(b.name, func(t *testing.T) {
+			l, c := b.tokenBucket(t, 2, 2)
+			c.set(t0)
+			allow(t, l, "a", true, 1, 0)
+			allow(t, l, "a", true, 0, 0)
+			allow(t, l, "a", false, 0, 500*time.Millisecond)
+
+			// Half a token by now: the rest comes in 250ms
+			c.set(t0.Add(250 * time.Millisecond))
+			allow(t, l, "a", false, 0, 250*time.Millisecond)
+			c.set(t0.Add(500 * time.Millisecond))
+			allow(t, l, "a", true, 0, 0)
+
+			// A long quiet spell refills up to the burst, no further
+			c.set(t0.Add(time.Hour))
+			allow(t, l, "a", true, 1, 0)
+			allow(t, l, "a", true, 0, 0)
+			allow(t, l, "a", false, 0, 500*time.Millisecond)
+		})
+	}
+}
+
+func TestTokenBucketClockStepsBack(t *testing.T) {
+	for _, b := range backends {
+		t.Run(b.name, func(t *testing.T) {
+			l, c := b.tokenBucket(t, 1, 1)
+			c.set(t0)
+			allow(t, l, "a", true, 0, 0)
+
+			// Back an hour and forward again: nothing refills until the
+			// clock passes the last request, then only what has accrued since
+			c.set(t0.Add(-time.Hour))
+			allow(t, l, "a", false, 0, time.Second)
+			c.set(t0.Add(500 * time.Millisecond))
+			allow(t, l, "a", false, 0, 500*time.Millisecond)
+			c.set(t0.Add(time.Second))
+			allow(t, l, "a", true, 0, 0)
+		})
+	}
+}
+
+func TestSlidingWindowBoundaries(t *testing.T) {
+	for _, b := range backends {
+		t.Run(b.name, func(t *testing.T) {
+			l, c := b.window(t, 10, time.Second)
+			c.set(t0)
+			for i := 9; i >= 0; i-- {
+				allow(t, l, "a", true, i, 0)
+			}
+			// The current window alone is full: wait for the next one
+			allow(t, l, "a", false, 0, time.Second)
+			c.set(t0.Add(900 * time.Millisecond))
+			allow(t, l, "a", false, 0, 100*time.Millisecond)
+
+			// At the boundary the previous window still counts whole, and
+			// its share drops by one request every 100ms
+			c.set(t0.Add(time.Second))
+			allow(t, l, "a", false, 0, 100*time.Millisecond)
+			c.set(t0.Add(time.Second + 100*time.Millisecond))
+			allow(t, l, "a", true, 0, 0)
+			allow(t, l, "a", false, 0, 100*time.Millisecond)
+			c.set(t0.Add(time.Second + 500*time.Millisecond))
+			for i := 3; i >= 0; i-- {
+				allow(t, l, "a", true, i, 0)
+			}
+			allow(t, l, "a", false, 0, 100*time.Millisecond)
+
+			// Two windows on, the old counts are gone
+			c.set(t0.Add(3 * time.Second))
+			allow(t, l, "a", true, 9, 0)
+			allow(t, l, "b", true, 9, 0)
+		})
+	}
+}
+
+func TestSlidingWindowSkipsWindow(t *testing.T) {
+	for _, b := range backends {
+		t.Run(b.name, func(t *testing.T) {
+			l, c := b.window(t, 2, time.Second)
+			c.set(t0)
+			allow(t, l, "a", true, 1, 0)
+			allow(t, l, "a", true, 0, 0)
+			// A window with no requests in between: the full window before
+			// the current one doesn't overlap it
+			c.set(t0.Add(2*time.Second + 900*time.Millisecond))
+			allow(t, l, "a", true, 1, 0)
+		})
+	}
+}
+
+// TestRedisClockSkew puts the Redis server's clock an hour behind the
+// hosts'. The Redis limiters read no host clock, so two hosts share one
+// bucket and one window that only the server's clock moves.
+func TestRedisClockSkew(t *testing.T) {
+	server := time.Now().Add(-time.Hour).Truncate(time.Second)
+
+	t.Run(TokenBucket, func(t *testing.T) {
+		m, rdb := newRedis(t)
+		hostA := NewRedisTokenBucket(rdb, "ratelimit:test:", 1, 2)
+		hostB := NewRedisTokenBucket(rdb, "ratelimit:test:", 1, 2)
+		m.SetTime(server)
+		allow(t, hostA, "a", true, 1, 0)
+		allow(t, hostB, "a", true, 0, 0)
+		allow(t, hostA, "a", false, 0, time.Second)
+		allow(t, hostB, "a", false, 0, time.Second)
+
+		// Only the server's clock refills the bucket
+		m.SetTime(server.Add(time.Second))
+		allow(t, hostB, "a", true, 0, 0)
+		allow(t, hostA, "a", false, 0, time.Second)
+	})
+
+	t.Run(SlidingWindow, func(t *testing.T) {
+		m, rdb := newRedis(t)
+		hostA := NewRedisSlidingWindow(rdb, "ratelimit:test:", 2, time.Second)
+		hostB := NewRedisSlidingWindow(rdb, "ratelimit:test:", 2, time.Second)
+		m.SetTime(server)
+		allow(t, hostA, "a", true, 1, 0)
+		allow(t, hostB, "a", true, 0, 0)
+		allow(t, hostA, "a", false, 0, time.Second)
+
+		m.SetTime(server.Add(2 * time.Second))
+		allow(t, hostB, "a", true, 1, 0)
+		allow(t, hostA, "a", true, 0, 0)
+		allow(t, hostB, "a", false, 0, time.Second)
+	})
+
+	// The local limiters go by each host's clock instead: the same requests
+	// on two skewed hosts get two separate limits
+	t.Run(Local, func(t *testing.T) {
+		clockA, clockB := &localClock{t: server.Add(time.Hour)}, &localClock{t: server.Add(2 * time.Hour)}
+		hostA, hostB := NewLocalTokenBucket(1, 2), NewLocalTokenBucket(1, 2)
+		hostA.now, hostB.now = clockA.now, clockB.now
+		allow(t, hostA, "a", true, 1, 0)
+		allow(t, hostB, "a", true, 1, 0)
+	})
+}
+
+func TestWaitAndSwitch(t *testing.T) {
+	s := NewSwitch(nil, "test")
+	if err := Wait(context.Background(), s, "a"); err != nil {
+		t.Fatalf("Wait without a limit: %v", err)
+	}
+	if changed, err := s.Configure(Config{Rate: 1, Burst: 1}); err != nil || !changed {
+		t.Fatalf("Configure = %v, %v; want changed", changed, err)
+	}
+	if changed, _ := s.Configure(Config{Rate: 1, Burst: 1}); changed {
+		t.Errorf("Configure with the same config reported a change")
+	}
+	allow(t, s, "a", true, 0, 0)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := Wait(ctx, s, "a"); err != context.DeadlineExceeded {
+		t.Errorf("Wait on an empty bucket = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if _, err := s.Configure(Config{Rate: 1, Backend: Redis}); err == nil {
+		t.Errorf("Configure of the redis backend without a client succeeded")
+	}
+	if changed, _ := s.Configure(Config{}); !changed {
+		t.Errorf("Configure with no rate didn't lift the limit")
+	}
+	allow(t, s, "a", true, -1, 0)
+}
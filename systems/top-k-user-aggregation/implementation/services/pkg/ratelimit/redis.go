@@ -0,0 +1,128 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Both scripts read the time with TIME inside the script, so every instance
+// sharing the limit uses the Redis server's clock, not its own.
+
+// tokenBucketScript keeps a key's tokens and last refill in a hash.
+// ARGV: rate per second, burst. Returns {allowed, remaining, retry_ms}.
+var tokenBucketScript = redis.NewScript(`
+local t = redis.call('TIME')
+local now = tonumber(t[1]) + tonumber(t[2]) / 1000000
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+
+local s = redis.call('HMGET', KEYS[1], 'tokens', 'ts')
+local tokens = tonumber(s[1]) or burst
+local ts = tonumber(s[2]) or now
+if now > ts then
+  tokens = math.min(burst, tokens + (now - ts) * rate)
+  ts = now
+end
+
+local allowed, retry = 0, 0
+if tokens >= 1 then
+  tokens = tokens - 1
+  allowed = 1
+else
+  retry = math.ceil((1 - tokens) / rate * 1000)
+end
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'ts', tostring(ts))
+redis.call('PEXPIRE', KEYS[1], math.ceil(burst / rate * 1000) + 1000)
+return {allowed, math.floor(tokens), retry}
+`)
+
+// slidingWindowScript counts a key's fixed windows in a hash, one field per
+// window index. ARGV: limit, window in ms. Returns {allowed, remaining,
+// retry_ms}.
+var slidingWindowScript = redis.NewScript(`
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+local limit = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+
+local idx = math.floor(now / window)
+local elapsed = now - idx * window
+local c = redis.call('HMGET', KEYS[1], tostring(idx), tostring(idx - 1))
+local cur = tonumber(c[1]) or 0
+local prev = tonumber(c[2]) or 0
+
+local used = prev * (1 - elapsed / window) + cur
+if used + 1 <= limit then
+  redis.call('HINCRBY', KEYS[1], tostring(idx), 1)
+  redis.call('HDEL', KEYS[1], tostring(idx - 2))
+  redis.call('PEXPIRE', KEYS[1], 2 * window)
+  return {1, math.floor(limit - used - 1), 0}
+end
+
+local retry = window - elapsed
+if cur + 1 <= limit and prev > 0 then
+  local at = (1 - (limit - cur - 1) / prev) * window
+  if at > elapsed and at - elapsed < retry then
+    retry = at - elapsed
+  end
+end
+return {0, 0, math.ceil(retry)}
+`)
+
+// RedisTokenBucket is a token bucket per key shared through Redis
+type RedisTokenBucket struct {
+	rdb    *redis.Client
+	prefix string
+	rate   float64
+	burst  int
+}
+
+// NewRedisTokenBucket allows rate per second and key, up to burst at once,
+// across every instance using the same prefix
+func NewRedisTokenBucket(rdb *redis.Client, prefix string, rate float64, burst int) *RedisTokenBucket {
+	return &RedisTokenBucket{rdb: rdb, prefix: prefix, rate: rate, burst: burst}
+}
+
+func (l *RedisTokenBucket) Allow(ctx context.Context, key string) (Decision, error) {
+	res, err := tokenBucketScript.Run(ctx, l.rdb, []string{l.prefix + key}, l.rate, l.burst).Int64Slice()
+	if err != nil {
+		return Decision{}, fmt.Errorf("token bucket %s: %w", key, err)
+	}
+	return scriptDecision(res), nil
+}
+
+// RedisSlidingWindow is a sliding window per key shared through Redis
+type RedisSlidingWindow struct {
+	rdb    *redis.Client
+	prefix string
+	limit  int
+	window time.Duration
+}
+
+// NewRedisSlidingWindow allows limit requests per window and key across
+// every instance using the same prefix
+func NewRedisSlidingWindow(rdb *redis.Client, prefix string, limit int, window time.Duration) *RedisSlidingWindow {
+	return &RedisSlidingWindow{rdb: rdb, prefix: prefix, limit: limit, window: window}
+}
+
+func (l *RedisSlidingWindow) Allow(ctx context.Context, key string) (Decision, error) {
+	res, err := slidingWindowScript.Run(ctx, l.rdb, []string{l.prefix + key}, l.limit, l.window.Milliseconds()).Int64Slice()
+	if err != nil {
+		return Decision{}, fmt.Errorf("sliding window %s: %w", key, err)
+	}
+	return scriptDecision(res), nil
+}
+
+func scriptDecision(res []int64) Decision {
+	if len(res) != 3 {
+		return Decision{}
+	}
+	return Decision{
+		Allowed:    res[0] == 1,
+		Remaining:  int(res[1]),
+		RetryAfter: time.Duration(res[2]) * time.Millisecond,
+	}
+}
@@ -0,0 +1,171 @@
+// Package redisutil builds go-redis clients with pool and timeout settings
+// from the environment and exports their connection pool stats.
+package redisutil
+
+import (
+	"expvar"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Config holds a client's connection settings. go-redis' own pool defaults
+// to 10 connections per CPU, which a small container spends quickly once
+// requests pile up: calls then queue for a connection until PoolTimeout.
+type Config struct {
+	Addr         string
+	DB           int
+	PoolSize     int           // connections per client
+	MinIdleConns int           // kept open while idle, so a burst doesn't start with dials
+	PoolTimeout  time.Duration // wait for a free connection before failing
+	DialTimeout  time.Duration
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+	// MaxRetries retries a failed command (0 = never), with a backoff
+	// between MinRetryBackoff and MaxRetryBackoff
+	MaxRetries      int
+	MinRetryBackoff time.Duration
+	MaxRetryBackoff time.Duration
+}
+
+// ConfigFromEnv returns the settings for a client of addr and db from
+// REDIS_POOL_SIZE, REDIS_MIN_IDLE_CONNS, REDIS_POOL_TIMEOUT,
+// REDIS_DIAL_TIMEOUT, REDIS_READ_TIMEOUT, REDIS_WRITE_TIMEOUT,
+// REDIS_MAX_RETRIES, REDIS_MIN_RETRY_BACKOFF and REDIS_MAX_RETRY_BACKOFF.
+// Every client of a process shares them.
+func ConfigFromEnv(addr string, db int) (Config, error) {
+	cfg := Config{
+		Addr:            addr,
+		DB:              db,
+		PoolSize:        100,
+		MinIdleConns:    10,
+		PoolTimeout:     4 * time.Second,
+		DialTimeout:     5 * time.Second,
+		ReadTimeout:     3 * time.Second,
+		WriteTimeout:    3 * time.Second,
+		MaxRetries:      3,
+		MinRetryBackoff: 8 * time.Millisecond,
+		MaxRetryBackoff: 512 * time.Millisecond,
+	}
+	for key, p := range map[string]*int{
+		"REDIS_POOL_SIZE":      &cfg.PoolSize,
+		"REDIS_MIN_IDLE_CONNS": &cfg.MinIdleConns,
+		"REDIS_MAX_RETRIES":    &cfg.MaxRetries,
+	} {
+		if v := os.Getenv(key); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				return cfg, fmt.Errorf("invalid %s %q", key, v)
+			}
+			*p = n
+		}
+	}
+	for key, p := range map[string]*time.Duration{
+		"REDIS_POOL_TIMEOUT":      &cfg.PoolTimeout,
+		"REDIS_DIAL_TIMEOUT":      &cfg.DialTimeout,
+		"REDIS_READ_TIMEOUT":      &cfg.ReadTimeout,
+		"REDIS_WRITE_TIMEOUT":     &cfg.WriteTimeout,
+		"REDIS_MIN_RETRY_BACKOFF": &cfg.MinRetryBackoff,
+		"REDIS_MAX_RETRY_BACKOFF": &cfg.MaxRetryBackoff,
+	} {
+		if v := os.Getenv(key); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil || d <= 0 {
+				return cfg, fmt.Errorf("invalid %s %q", key, v)
+			}
+			*p = d
+		}
+	}
+	if cfg.PoolSize == 0 {
+		return cfg, fmt.Errorf("invalid REDIS_POOL_SIZE 0")
+	}
+	if cfg.MinIdleConns > cfg.PoolSize {
+		return cfg, fmt.Errorf("REDIS_MIN_IDLE_CONNS %d is more than REDIS_POOL_SIZE %d", cfg.MinIdleConns, cfg.PoolSize)
+	}
+	if cfg.MinRetryBackoff > cfg.MaxRetryBackoff {
+		return cfg, fmt.Errorf("REDIS_MIN_RETRY_BACKOFF %s is more than REDIS_MAX_RETRY_BACKOFF %s", cfg.MinRetryBackoff, cfg.MaxRetryBackoff)
+	}
+	return cfg, nil
+}
+
+// Options returns cfg as go-redis options
+func (cfg Config) Options() *redis.Options {
+	retries := cfg.MaxRetries
+	if retries == 0 {
+		retries = -1 // go-redis reads 0 as its default of 3
+	}
+	return &redis.Options{
+		Addr:            cfg.Addr,
+		DB:              cfg.DB,
+		PoolSize:        cfg.PoolSize,
+		MinIdleConns:    cfg.MinIdleConns,
+		PoolTimeout:     cfg.PoolTimeout,
+		DialTimeout:     cfg.DialTimeout,
+		ReadTimeout:     cfg.ReadTimeout,
+		WriteTimeout:    cfg.WriteTimeout,
+		MaxRetries:      retries,
+		MinRetryBackoff: cfg.MinRetryBackoff,
+		MaxRetryBackoff: cfg.MaxRetryBackoff,
+	}
+}
+
+func (cfg Config) String() string {
+	return fmt.Sprintf("pool=%d min_idle=%d pool_timeout=%s dial=%s read=%s write=%s retries=%d backoff=%s-%s",
+		cfg.PoolSize, cfg.MinIdleConns, cfg.PoolTimeout, cfg.DialTimeout, cfg.ReadTimeout, cfg.WriteTimeout,
+		cfg.MaxRetries, cfg.MinRetryBackoff, cfg.MaxRetryBackoff)
+}
+
+// NewClient returns a client for cfg whose pool stats are published under
+// name in the redis_pool expvar
+func NewClient(cfg Config, name string) *redis.Client {
+	c := redis.NewClient(cfg.Options())
+	clientsMu.Lock()
+	clients[name] = c
+	clientsMu.Unlock()
+	return c
+}
+
+var (
+	clientsMu sync.Mutex
+	clients   = make(map[string]*redis.Client)
+)
+
+func init() {
+	expvar.Publish("redis_pool", expvar.Func(poolStats))
+}
+
+// PoolStats is one client's pool in the redis_pool expvar. Hits, misses and
+// timeouts count since start; a rising timeouts means callers gave up
+// waiting for a connection (raise REDIS_POOL_SIZE).
+type PoolStats struct {
+	Hits       uint32 `json:"hits"`     // a free connection was there
+	Misses     uint32 `json:"misses"`   // one had to be dialed
+	Timeouts   uint32 `json:"timeouts"` // none freed up within PoolTimeout
+	TotalConns uint32 `json:"total_conns"`
+	IdleConns  uint32 `json:"idle_conns"`
+	StaleConns uint32 `json:"stale_conns"` // closed for being idle or too old
+	PoolSize   int    `json:"pool_size"`
+}
+
+func poolStats() interface{} {
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
+	out := make(map[string]PoolStats, len(clients))
+	for name, c := range clients {
+		s := c.PoolStats()
+		out[name] = PoolStats{
+			Hits:       s.Hits,
+			Misses:     s.Misses,
+			Timeouts:   s.Timeouts,
+			TotalConns: s.TotalConns,
+			IdleConns:  s.IdleConns,
+			StaleConns: s.StaleConns,
+			PoolSize:   c.Options().PoolSize,
+		}
+	}
+	return out
+}
@@ -0,0 +1,73 @@
+package runtimecfg
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"os"
+	"sync/atomic"
+)
+
+// LogLevelKey is the setting every service reads: info (default) logs
+// everything, warning only the lines that report a problem
+const LogLevelKey = "log_level"
+
+// Log levels of LogLevelKey
+const (
+	LevelInfo    = "info"
+	LevelWarning = "warning"
+)
+
+// quiet is set while log_level=warning
+var quiet atomic.Bool
+
+// keptWords mark the lines kept at the warning level: problems, by the
+// services' log conventions ("Warning: ...", "ALERT: ...", "Error reading
+// ...", "Failed to ...", "... failed: ..."), and this package's audit of
+// setting changes
+var keptWords = [][]byte{
+	[]byte("warning"), []byte("alert"), []byte("error"), []byte("fail"), []byte("panic"),
+	[]byte("runtime config changed"),
+}
+
+// levelWriter drops the lines of the standard logger quiet filters out
+type levelWriter struct{ w io.Writer }
+
+func (lw levelWriter) Write(p []byte) (int, error) {
+	if quiet.Load() && !kept(p) {
+		return len(p), nil
+	}
+	return lw.w.Write(p)
+}
+
+func kept(line []byte) bool {
+	lower := bytes.ToLower(line)
+	for _, w := range keptWords {
+		if bytes.Contains(lower, w) {
+			return true
+		}
+	}
+	return false
+}
+
+// setLogLevel applies a log_level value; unset or unknown means info
+func setLogLevel(level string) {
+	if level != "" && level != LevelInfo && level != LevelWarning {
+		log.Printf("Warning: unknown %s %q, logging everything", LogLevelKey, level)
+		level = LevelInfo
+	}
+	if level == LevelWarning {
+		installOnce()
+	}
+	quiet.Store(level == LevelWarning)
+}
+
+var installed atomic.Bool
+
+// installOnce routes the standard logger through levelWriter, the first
+// time a service turns the level up
+func installOnce() {
+	if installed.CompareAndSwap(false, true) {
+		log.SetOutput(levelWriter{os.Stderr})
+	}
+}
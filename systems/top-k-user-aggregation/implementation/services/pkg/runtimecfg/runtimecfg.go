@@ -0,0 +1,351 @@
+// Package runtimecfg holds settings operators can change without a restart.
+// Values are strings in two Redis hashes, config:global and
+// config:<service>, the service's hash overriding the global one. Set and
+// Delete announce changes on the config:changed channel; services reload on
+// each announcement and also poll, so a missed message only delays a change.
+//
+// Below Redis, the settings sections of pipeline.yaml (PIPELINE_CONFIG) are
+// a layer of their own, re-read on SIGHUP and when the file changes. Every
+// reload that changes a value logs what changed and why.
+//
+// A key that overrides an env var is that var lower-cased (cache_ttl for
+// CACHE_TTL), and the env value stays the fallback: with no key set, nothing
+// changes.
+package runtimecfg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/system-design-lab/pkg/topology"
+)
+
+const (
+	// Global is the scope every service reads
+	Global = "global"
+
+	changedChannel = "config:changed"
+)
+
+// hashKey is the Redis hash of a scope (Global or a service name)
+func hashKey(scope string) string {
+	return "config:" + scope
+}
+
+// Config is one service's view of the runtime settings. A nil *Config
+// returns every fallback, so services can run without one.
+type Config struct {
+	rdb      *redis.Client // nil: the file layer only
+	service  string
+	interval time.Duration
+	file     string // PIPELINE_CONFIG; "" = no file layer
+
+	values atomic.Pointer[map[string]string]
+
+	mu       sync.Mutex
+	watchers []func()
+
+	// Layers as last read, kept when a source fails; guarded by reloadMu
+	reloadMu    sync.Mutex
+	fileValues  map[string]string
+	fileModTime time.Time
+	wiring      *topology.Service // the service's entry at the first read
+	redisValues map[string]string
+}
+
+// Reload triggers, named in the audit log
+const (
+	triggerStart        = "start"
+	triggerAnnouncement = "announcement"
+	triggerPoll         = "poll"
+	triggerFile         = "file change"
+	triggerSIGHUP       = "SIGHUP"
+)
+
+// New returns the config of service. Getters return fallbacks until Start.
+// RUNTIME_CONFIG_POLL_INTERVAL (default 30s) sets the polling interval. With
+// PIPELINE_CONFIG set, the settings sections of that file are a layer below
+// Redis; rdb may be nil for a service with the file layer only.
+func New(rdb *redis.Client, service string) *Config {
+	interval := 30 * time.Second
+	if v := os.Getenv("RUNTIME_CONFIG_POLL_INTERVAL"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d > 0 {
+			interval = d
+		}
+	}
+	c := &Config{rdb: rdb, service: service, interval: interval, file: os.Getenv("PIPELINE_CONFIG")}
+	c.values.Store(&map[string]string{})
+	return c
+}
+
+// Start loads the settings and keeps them fresh until ctx ends. A failed
+// first load is returned but the refresh still runs: the service starts on
+// its fallbacks and picks the settings up once Redis answers.
+func (c *Config) Start(ctx context.Context) error {
+	if c == nil {
+		return nil
+	}
+	err := c.reload(ctx, triggerStart, true)
+	go c.refresh(ctx)
+	return err
+}
+
+// refresh reloads on every change announcement, every interval (re-reading
+// the file if it was modified) and every SIGHUP (re-reading it anyway)
+func (c *Config) refresh(ctx context.Context) {
+	var changed <-chan *redis.Message
+	if c.rdb != nil {
+		sub := c.rdb.Subscribe(ctx, changedChannel)
+		defer sub.Close()
+		changed = sub.Channel()
+	}
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+	for {
+		trigger, readFile := triggerPoll, false
+		select {
+		case <-ctx.Done():
+			return
+		case msg := <-changed:
+			if msg.Payload != Global && msg.Payload != c.service {
+				continue
+			}
+			trigger = triggerAnnouncement
+		case <-hup:
+			trigger, readFile = triggerSIGHUP, true
+		case <-ticker.C:
+		}
+		if err := c.reload(ctx, trigger, readFile); err != nil && ctx.Err() == nil {
+			log.Printf("Warning: runtime config reload failed, keeping last values: %v", err)
+		}
+	}
+}
+
+// reload reads the layers and, if anything changed, swaps them in, logs
+// what changed and runs the OnChange callbacks. The file is read when
+// readFile is set or it changed since the last read. A layer that can't be
+// read keeps its last values; the error is returned once the others are in.
+func (c *Config) reload(ctx context.Context, trigger string, readFile bool) error {
+	c.reloadMu.Lock()
+	defer c.reloadMu.Unlock()
+
+	var errs []error
+	if c.file != "" {
+		if re, err := c.readFile(readFile); err != nil {
+			errs = append(errs, err)
+		} else if re && trigger == triggerPoll {
+			trigger = triggerFile
+		}
+	}
+	if c.rdb != nil {
+		pipe := c.rdb.Pipeline()
+		global := pipe.HGetAll(ctx, hashKey(Global))
+		own := pipe.HGetAll(ctx, hashKey(c.service))
+		if _, err := pipe.Exec(ctx); err != nil {
+			errs = append(errs, err)
+		} else {
+			c.redisValues = global.Val()
+			for k, v := range own.Val() {
+				c.redisValues[k] = v
+			}
+		}
+	}
+
+	values := make(map[string]string, len(c.fileValues)+len(c.redisValues))
+	for k, v := range c.fileValues {
+		values[k] = v
+	}
+	for k, v := range c.redisValues {
+		values[k] = v
+	}
+	old := *c.values.Load()
+	if !equal(old, values) {
+		c.values.Store(&values)
+		log.Printf("Runtime config changed (%s): %s", trigger, describeChanges(old, values))
+		setLogLevel(values[LogLevelKey])
+
+		c.mu.Lock()
+		watchers := append([]func(){}, c.watchers...)
+		c.mu.Unlock()
+		for _, fn := range watchers {
+			fn()
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// readFile re-reads the settings of the file, if force is set or it was
+// modified since the last read, and reports whether it did. Changes to
+// anything but settings are logged: they only take effect on a restart.
+func (c *Config) readFile(force bool) (bool, error) {
+	info, err := os.Stat(c.file)
+	if err != nil {
+		return false, err
+	}
+	if !force && info.ModTime().Equal(c.fileModTime) {
+		return false, nil
+	}
+	t, err := topology.Load(c.file)
+	if err != nil {
+		return false, err
+	}
+	c.fileModTime = info.ModTime()
+	c.fileValues = t.SettingsOf(c.service)
+
+	wiring := t.Services[c.service]
+	wiring.Settings = nil
+	if c.wiring == nil {
+		c.wiring = &wiring
+	} else if !reflect.DeepEqual(*c.wiring, wiring) {
+		log.Printf("Warning: %s's topics, groups, tables, cache, metrics or env changed in %s; they take a restart", c.service, c.file)
+	}
+	return true, nil
+}
+
+// describeChanges lists what differs between two settings, one
+// key: old -> new per key, sorted
+func describeChanges(old, values map[string]string) string {
+	keys := make(map[string]bool)
+	for k := range old {
+		keys[k] = true
+	}
+	for k := range values {
+		keys[k] = true
+	}
+	show := func(m map[string]string, k string) string {
+		if v, ok := m[k]; ok {
+			return strconv.Quote(v)
+		}
+		return "unset"
+	}
+	var changes []string
+	for k := range keys {
+		if o, n := show(old, k), show(values, k); o != n {
+			changes = append(changes, fmt.Sprintf("%s: %s -> %s", k, o, n))
+		}
+	}
+	sort.Strings(changes)
+	return strings.Join(changes, ", ")
+}
+
+func equal(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if w, ok := b[k]; !ok || w != v {
+			return false
+		}
+	}
+	return true
+}
+
+// OnChange registers fn to run after every reload that changed a value, for
+// settings that have to be pushed somewhere (a rate limiter) rather than read
+// on use. fn runs on the refresh goroutine.
+func (c *Config) OnChange(fn func()) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	c.watchers = append(c.watchers, fn)
+	c.mu.Unlock()
+}
+
+func (c *Config) lookup(key string) (string, bool) {
+	if c == nil {
+		return "", false
+	}
+	v, ok := (*c.values.Load())[key]
+	return v, ok
+}
+
+// String returns key's value, or fallback if unset
+func (c *Config) String(key, fallback string) string {
+	if v, ok := c.lookup(key); ok {
+		return v
+	}
+	return fallback
+}
+
+// Bool returns key's value, or fallback if unset or invalid
+func (c *Config) Bool(key string, fallback bool) bool {
+	if v, ok := c.lookup(key); ok {
+		if b, err := strconv.ParseBool(v); err == nil {
+			return b
+		}
+	}
+	return fallback
+}
+
+// Int returns key's value, or fallback if unset or invalid
+func (c *Config) Int(key string, fallback int) int {
+	if v, ok := c.lookup(key); ok {
+		if i, err := strconv.Atoi(v); err == nil {
+			return i
+		}
+	}
+	return fallback
+}
+
+// Duration returns key's value, or fallback if unset or invalid
+func (c *Config) Duration(key string, fallback time.Duration) time.Duration {
+	if v, ok := c.lookup(key); ok {
+		if d, err := time.ParseDuration(v); err == nil {
+			return d
+		}
+	}
+	return fallback
+}
+
+// Values returns the settings in effect
+func (c *Config) Values() map[string]string {
+	out := make(map[string]string)
+	if c == nil {
+		return out
+	}
+	for k, v := range *c.values.Load() {
+		out[k] = v
+	}
+	return out
+}
+
+// Set stores key=value for scope (Global or a service) and announces it
+func Set(ctx context.Context, rdb *redis.Client, scope, key, value string) error {
+	if err := rdb.HSet(ctx, hashKey(scope), key, value).Err(); err != nil {
+		return fmt.Errorf("set %s %s: %w", scope, key, err)
+	}
+	return rdb.Publish(ctx, changedChannel, scope).Err()
+}
+
+// Delete removes key from scope, so services fall back to their env value,
+// and announces it
+func Delete(ctx context.Context, rdb *redis.Client, scope, key string) error {
+	if err := rdb.HDel(ctx, hashKey(scope), key).Err(); err != nil {
+		return fmt.Errorf("delete %s %s: %w", scope, key, err)
+	}
+	return rdb.Publish(ctx, changedChannel, scope).Err()
+}
+
+// List returns the keys set in scope
+func List(ctx context.Context, rdb *redis.Client, scope string) (map[string]string, error) {
+	return rdb.HGetAll(ctx, hashKey(scope)).Result()
+}
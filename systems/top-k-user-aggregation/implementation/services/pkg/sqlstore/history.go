@@ -0,0 +1,164 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/system-design-lab/pkg/events"
+	"github.com/system-design-lab/pkg/storage"
+)
+
+// ListenHistoryRepo reads and writes user_listen_history, keyed by event_id.
+// Neither database has row TTLs: DeleteBefore drops expired days instead.
+type ListenHistoryRepo struct {
+	db *DB
+}
+
+func NewListenHistoryRepo(db *DB) *ListenHistoryRepo {
+	return &ListenHistoryRepo{db: db}
+}
+
+// Insert writes a row; an event already there is left as it is and returns
+// storage.ErrExists, like a Cassandra insert IF NOT EXISTS
+func (r *ListenHistoryRepo) Insert(ctx context.Context, row storage.HistoryRow) (err error) {
+	defer observe("user_listen_history.insert", time.Now(), &err)
+
+	var extra sql.NullString
+	if len(row.Extra) > 0 {
+		data, err := json.Marshal(row.Extra)
+		if err != nil {
+			return err
+		}
+		extra = sql.NullString{String: string(data), Valid: true}
+	}
+	res, err := r.db.db.ExecContext(ctx, `
+		INSERT INTO user_listen_history
+			(event_id, user_id, day, listened_at, song_id, provider,
+			 hour_bucket, hour, weekday, source, context, artist_id, duration_ms, extra)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)
+		ON CONFLICT (event_id) DO NOTHING
+	`, row.EventID, row.UserID, row.Day(), row.Time().UTC(), row.SongID, row.Provider,
+		time.Unix(row.HourBucket, 0).UTC(), row.Hour, row.Weekday,
+		nullString(row.Source), nullString(row.Context), nullString(row.ArtistID), nullInt64(row.DurationMs), extra)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return storage.ErrExists
+	}
+	return nil
+}
+
+// ListDay returns up to limit rows of a user's day, newest first
+func (r *ListenHistoryRepo) ListDay(ctx context.Context, userID, day string, limit int) (rows []storage.HistoryRow, err error) {
+	defer observe("user_listen_history.list_day", time.Now(), &err)
+	err = r.scan(ctx, historySelect+` LIMIT $3`, []interface{}{userID, day, limit}, userID, func(row storage.HistoryRow) error {
+		rows = append(rows, row)
+		return nil
+	})
+	return rows, err
+}
+
+// ScanDay calls fn for every row of a user's day, newest first. An error from
+// fn stops the scan and is returned.
+func (r *ListenHistoryRepo) ScanDay(ctx context.Context, userID, day string, fn func(storage.HistoryRow) error) (err error) {
+	defer observe("user_listen_history.scan_day", time.Now(), &err)
+	return r.scan(ctx, historySelect, []interface{}{userID, day}, userID, fn)
+}
+
+// Partitions calls fn for every (user_id, day) of user_listen_history, the
+// Cassandra partitions. It reads the whole table: meant for repairs and
+// other offline jobs, not the request path.
+func (r *ListenHistoryRepo) Partitions(ctx context.Context, fn func(userID, day string) error) (err error) {
+	defer observe("user_listen_history.partitions", time.Now(), &err)
+
+	rows, err := r.db.db.QueryContext(ctx, `SELECT DISTINCT user_id, day FROM user_listen_history`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var userID string
+		var day dayColumn
+		if err := rows.Scan(&userID, &day); err != nil {
+			return err
+		}
+		if err := fn(userID, string(day)); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// DeleteBefore deletes the days before day (DayFormat) and returns how many
+// rows went
+func (r *ListenHistoryRepo) DeleteBefore(ctx context.Context, day string) (n int64, err error) {
+	defer observe("user_listen_history.delete_before", time.Now(), &err)
+
+	res, err := r.db.db.ExecContext(ctx, `DELETE FROM user_listen_history WHERE day < $1`, day)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+const historySelect = `
+	SELECT event_id, song_id, provider, listened_at, hour_bucket, hour, weekday, source, context, artist_id, duration_ms, extra
+	FROM user_listen_history
+	WHERE user_id = $1 AND day = $2
+	ORDER BY listened_at DESC
+`
+
+func (r *ListenHistoryRepo) scan(ctx context.Context, query string, args []interface{}, userID string, fn func(storage.HistoryRow) error) error {
+	rows, err := r.db.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return fmt.Errorf("query user_listen_history: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			row                    storage.HistoryRow
+			listenedAt, hourBucket timeColumn
+			hour, durationMs       sql.NullInt64
+			weekday, source, extra sql.NullString
+			listenCtx, artistID    sql.NullString
+		)
+		if err := rows.Scan(&row.EventID, &row.SongID, &row.Provider, &listenedAt, &hourBucket,
+			&hour, &weekday, &source, &listenCtx, &artistID, &durationMs, &extra); err != nil {
+			return err
+		}
+		row.SchemaVersion = events.SchemaVersion
+		row.UserID = userID
+		row.ListenedAt = time.Time(listenedAt).Unix()
+		if !time.Time(hourBucket).IsZero() {
+			row.HourBucket = time.Time(hourBucket).Unix()
+		}
+		row.Hour = int(hour.Int64)
+		row.Weekday = weekday.String
+		row.Source = source.String
+		row.Context = listenCtx.String
+		row.ArtistID = artistID.String
+		row.DurationMs = durationMs.Int64
+		if extra.Valid {
+			if err := json.Unmarshal([]byte(extra.String), &row.Extra); err != nil {
+				return fmt.Errorf("extra of event %s: %w", row.EventID, err)
+			}
+		}
+		if err := fn(row); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+func nullString(v string) sql.NullString {
+	return sql.NullString{String: v, Valid: v != ""}
+}
+
+func nullInt64(v int64) sql.NullInt64 {
+	return sql.NullInt64{Int64: v, Valid: v != 0}
+}
@@ -0,0 +1,23 @@
+package sqlstore
+
+import (
+	"expvar"
+	"time"
+)
+
+// Per-operation metrics, keyed "<table>.<op>" like the storage package's
+// cassandra_* maps
+var (
+	metricQueries   = expvar.NewMap("sql_queries")
+	metricErrors    = expvar.NewMap("sql_errors")
+	metricLatencyMs = expvar.NewMap("sql_latency_ms_total")
+)
+
+// observe records one operation; use as defer observe(op, time.Now(), &err)
+func observe(op string, start time.Time, err *error) {
+	metricQueries.Add(op, 1)
+	metricLatencyMs.AddFloat(op, float64(time.Since(start).Microseconds())/1000)
+	if *err != nil {
+		metricErrors.Add(op, 1)
+	}
+}
@@ -0,0 +1,104 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/system-design-lab/pkg/storage"
+)
+
+// SnapshotRepo reads and writes user_topk_snapshot, one row per user and
+// window with the ranked songs as a JSON list. Ranked lists aren't kept, so
+// snapshots never point at one.
+type SnapshotRepo struct {
+	db *DB
+}
+
+func NewSnapshotRepo(db *DB) *SnapshotRepo {
+	return &SnapshotRepo{db: db}
+}
+
+// Put stores a snapshot, replacing the window's last one. It expires after
+// ttl (0 = keep forever): Get stops returning it, and the next Put
+// overwrites the row.
+func (r *SnapshotRepo) Put(ctx context.Context, snap storage.Snapshot, ttl time.Duration) (err error) {
+	defer observe("user_topk_snapshot.put", time.Now(), &err)
+
+	songs, err := json.Marshal(snap.Songs)
+	if err != nil {
+		return err
+	}
+	var expiresAt sql.NullInt64
+	if ttl > 0 {
+		expiresAt = sql.NullInt64{Int64: time.Now().Add(ttl).Unix(), Valid: true}
+	}
+	_, err = r.db.db.ExecContext(ctx, `
+		INSERT INTO user_topk_snapshot (user_id, window_days, computed_at, songs, expires_at)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (user_id, window_days)
+		DO UPDATE SET computed_at = excluded.computed_at, songs = excluded.songs, expires_at = excluded.expires_at
+	`, snap.UserID, snap.WindowDays, snap.ComputedAt.UTC(), string(songs), expiresAt)
+	return err
+}
+
+// Get returns the snapshot for a user and window; ok is false if there is
+// none or it expired
+func (r *SnapshotRepo) Get(ctx context.Context, userID string, windowDays int) (snap storage.Snapshot, ok bool, err error) {
+	defer observe("user_topk_snapshot.get", time.Now(), &err)
+
+	var computedAt timeColumn
+	var songs string
+	err = r.db.db.QueryRowContext(ctx, `
+		SELECT computed_at, songs
+		FROM user_topk_snapshot
+		WHERE user_id = $1 AND window_days = $2 AND (expires_at IS NULL OR expires_at > $3)
+	`, userID, windowDays, time.Now().Unix()).Scan(&computedAt, &songs)
+	if errors.Is(err, sql.ErrNoRows) {
+		return snap, false, nil
+	}
+	if err != nil {
+		return snap, false, err
+	}
+	if err := json.Unmarshal([]byte(songs), &snap.Songs); err != nil {
+		return snap, false, err
+	}
+	snap.UserID = userID
+	snap.WindowDays = windowDays
+	snap.ComputedAt = time.Time(computedAt)
+	return snap, true, nil
+}
+
+// Scan calls fn with every user's unexpired snapshot of windowDays. It reads
+// the whole window: meant for batch jobs, not the request path.
+func (r *SnapshotRepo) Scan(ctx context.Context, windowDays int, fn func(storage.Snapshot) error) (err error) {
+	defer observe("user_topk_snapshot.scan", time.Now(), &err)
+
+	rows, err := r.db.db.QueryContext(ctx, `
+		SELECT user_id, computed_at, songs
+		FROM user_topk_snapshot
+		WHERE window_days = $1 AND (expires_at IS NULL OR expires_at > $2)
+	`, windowDays, time.Now().Unix())
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		snap := storage.Snapshot{WindowDays: windowDays}
+		var computedAt timeColumn
+		var songs string
+		if err := rows.Scan(&snap.UserID, &computedAt, &songs); err != nil {
+			return err
+		}
+		if err := json.Unmarshal([]byte(songs), &snap.Songs); err != nil {
+			return err
+		}
+		snap.ComputedAt = time.Time(computedAt)
+		if err := fn(snap); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
@@ -0,0 +1,8 @@
+//go:build sqlite
+
+package sqlstore
+
+// The SQLite driver isn't linked in by default, to keep cgo-free builds
+// small and the module graph without it. Build with -tags sqlite after
+// go get modernc.org/sqlite (pure Go, registers "sqlite").
+import _ "modernc.org/sqlite"
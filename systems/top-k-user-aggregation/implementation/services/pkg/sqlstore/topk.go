@@ -0,0 +1,167 @@
+package sqlstore
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/system-design-lab/pkg/storage"
+)
+
+// DailyTopKRepo reads and writes user_daily_topk, one row per user, day and
+// song like the Cassandra table, with the counters as plain columns
+type DailyTopKRepo struct {
+	db *DB
+}
+
+func NewDailyTopKRepo(db *DB) *DailyTopKRepo {
+	return &DailyTopKRepo{db: db}
+}
+
+// add upserts delta into one counter column of a song's row. The upsert is
+// atomic, but like a Cassandra counter it isn't idempotent: callers decide
+// whether to re-send.
+func (r *DailyTopKRepo) add(ctx context.Context, column, userID, day, songID string, delta int64) error {
+	_, err := r.db.db.ExecContext(ctx, `
+		INSERT INTO user_daily_topk (user_id, day, song_id, `+column+`)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (user_id, day, song_id)
+		DO UPDATE SET `+column+` = user_daily_topk.`+column+` + excluded.`+column+`
+	`, userID, day, songID, delta)
+	return err
+}
+
+// Increment adds delta to a song's count for a user and day
+func (r *DailyTopKRepo) Increment(ctx context.Context, userID, day, songID string, delta int64) (err error) {
+	defer observe("user_daily_topk.increment", time.Now(), &err)
+	return r.add(ctx, "listen_count", userID, day, songID, delta)
+}
+
+// IncrementTime adds ms to a song's listening time for a user and day
+func (r *DailyTopKRepo) IncrementTime(ctx context.Context, userID, day, songID string, ms int64) (err error) {
+	defer observe("user_daily_topk.increment_time", time.Now(), &err)
+	return r.add(ctx, "listen_ms", userID, day, songID, ms)
+}
+
+// DayCounts returns song -> count for one user and day
+func (r *DailyTopKRepo) DayCounts(ctx context.Context, userID, day string) (counts map[string]int64, err error) {
+	defer observe("user_daily_topk.day_counts", time.Now(), &err)
+	return r.sumCounts(ctx, userID, []string{day}, nil)
+}
+
+// SumCounts adds up a user's counts over several days in one GROUP BY query,
+// where Cassandra reads a partition per day
+func (r *DailyTopKRepo) SumCounts(ctx context.Context, userID string, days []string) (counts map[string]int64, err error) {
+	defer observe("user_daily_topk.sum_counts", time.Now(), &err)
+	return r.sumCounts(ctx, userID, days, nil)
+}
+
+// SumCountsOf is SumCounts restricted to songIDs. Songs the user didn't play
+// are left out.
+func (r *DailyTopKRepo) SumCountsOf(ctx context.Context, userID string, days, songIDs []string) (counts map[string]int64, err error) {
+	defer observe("user_daily_topk.sum_counts_of", time.Now(), &err)
+	if len(songIDs) == 0 {
+		return map[string]int64{}, nil
+	}
+	return r.sumCounts(ctx, userID, days, songIDs)
+}
+
+func (r *DailyTopKRepo) sumCounts(ctx context.Context, userID string, days, songIDs []string) (map[string]int64, error) {
+	totals, err := r.sumTotals(ctx, userID, days, songIDs)
+	if err != nil {
+		return nil, err
+	}
+	counts := make(map[string]int64, len(totals))
+	for song, t := range totals {
+		counts[song] = t.Count
+	}
+	return counts, nil
+}
+
+// DayTotals is DayCounts with listening time
+func (r *DailyTopKRepo) DayTotals(ctx context.Context, userID, day string) (totals map[string]storage.SongTotals, err error) {
+	defer observe("user_daily_topk.day_totals", time.Now(), &err)
+	return r.sumTotals(ctx, userID, []string{day}, nil)
+}
+
+// SumTotals is SumCounts with listening time
+func (r *DailyTopKRepo) SumTotals(ctx context.Context, userID string, days []string) (totals map[string]storage.SongTotals, err error) {
+	defer observe("user_daily_topk.sum_totals", time.Now(), &err)
+	return r.sumTotals(ctx, userID, days, nil)
+}
+
+// sumTotals sums a user's rows over days, of songIDs only unless nil
+func (r *DailyTopKRepo) sumTotals(ctx context.Context, userID string, days, songIDs []string) (map[string]storage.SongTotals, error) {
+	totals := make(map[string]storage.SongTotals)
+	if len(days) == 0 {
+		return totals, nil
+	}
+	args := []interface{}{userID}
+	for _, day := range days {
+		args = append(args, day)
+	}
+	query := `
+		SELECT song_id, SUM(listen_count), SUM(listen_ms)
+		FROM user_daily_topk
+		WHERE user_id = $1 AND day IN (` + placeholders(2, len(days)) + `)`
+	if songIDs != nil {
+		query += ` AND song_id IN (` + placeholders(len(args)+1, len(songIDs)) + `)`
+		for _, id := range songIDs {
+			args = append(args, id)
+		}
+	}
+	rows, err := r.db.db.QueryContext(ctx, query+` GROUP BY song_id`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query user_daily_topk: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var songID string
+		var t storage.SongTotals
+		if err := rows.Scan(&songID, &t.Count, &t.Millis); err != nil {
+			return nil, err
+		}
+		totals[songID] = t
+	}
+	return totals, rows.Err()
+}
+
+// UserTotalsRepo answers the per-user day totals (user_daily_totals in
+// Cassandra) from user_daily_topk, summed per day, so the aggregator keeps
+// no totals table
+type UserTotalsRepo struct {
+	db *DB
+}
+
+func NewUserTotalsRepo(db *DB) *UserTotalsRepo {
+	return &UserTotalsRepo{db: db}
+}
+
+// Range returns day -> total of a user from one day to another (DayFormat,
+// inclusive). Days without listens are absent.
+func (r *UserTotalsRepo) Range(ctx context.Context, userID, from, to string) (totals map[string]int64, err error) {
+	defer observe("user_daily_topk.range", time.Now(), &err)
+
+	rows, err := r.db.db.QueryContext(ctx, `
+		SELECT day, SUM(listen_count)
+		FROM user_daily_topk
+		WHERE user_id = $1 AND day >= $2 AND day <= $3
+		GROUP BY day
+	`, userID, from, to)
+	if err != nil {
+		return nil, fmt.Errorf("query totals: %w", err)
+	}
+	defer rows.Close()
+
+	totals = make(map[string]int64)
+	for rows.Next() {
+		var day dayColumn
+		var n int64
+		if err := rows.Scan(&day, &n); err != nil {
+			return nil, err
+		}
+		totals[string(day)] = n
+	}
+	return totals, rows.Err()
+}
@@ -0,0 +1,126 @@
+// Package startup waits for a service's dependencies to become reachable
+// before it starts, so a service brought up before Kafka, Cassandra or Redis
+// retries instead of exiting and crash-looping its container.
+package startup
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/segmentio/kafka-go"
+	"github.com/system-design-lab/pkg/kafkautil"
+	"github.com/system-design-lab/pkg/sqlstore"
+	"github.com/system-design-lab/pkg/storage"
+)
+
+// Backoff between attempts, doubling from minBackoff
+const (
+	minBackoff = 500 * time.Millisecond
+	maxBackoff = 10 * time.Second
+)
+
+// Timeout is how long Wait keeps trying: STARTUP_TIMEOUT, default 2m
+func Timeout() time.Duration {
+	if v := os.Getenv("STARTUP_TIMEOUT"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d > 0 {
+			return d
+		}
+		log.Printf("Invalid STARTUP_TIMEOUT %q, using 2m", v)
+	}
+	return 2 * time.Minute
+}
+
+// Wait calls try until it succeeds, backing off exponentially between
+// attempts, and returns the last error once Timeout has passed or ctx is
+// done. Each attempt gets the remaining time as its deadline.
+func Wait(ctx context.Context, name string, try func(context.Context) error) error {
+	ctx, cancel := context.WithTimeout(ctx, Timeout())
+	defer cancel()
+
+	backoff := minBackoff
+	for attempt := 1; ; attempt++ {
+		err := try(ctx)
+		if err == nil {
+			if attempt > 1 {
+				log.Printf("%s reachable after %d attempts", name, attempt)
+			}
+			return nil
+		}
+		deadline, _ := ctx.Deadline()
+		if time.Until(deadline) < backoff {
+			return fmt.Errorf("%s not reachable after %d attempts: %w", name, attempt, err)
+		}
+		log.Printf("Waiting for %s (attempt %d, retrying in %s): %v", name, attempt, backoff, err)
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%s not reachable after %d attempts: %w", name, attempt, err)
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+// Cassandra connects with storage.ConnectFromEnv, waiting for the cluster
+func Cassandra(ctx context.Context) (*storage.Session, error) {
+	cfg, err := storage.ConfigFromEnv()
+	if err != nil {
+		return nil, err // configuration, not reachability
+	}
+	var session *storage.Session
+	err = Wait(ctx, "Cassandra", func(context.Context) error {
+		session, err = storage.Connect(cfg)
+		return err
+	})
+	return session, err
+}
+
+// SQL opens the STORAGE_BACKEND database cfg names, waits for it to answer
+// and creates the tables it is missing
+func SQL(ctx context.Context, cfg sqlstore.Config) (*sqlstore.DB, error) {
+	db, err := sqlstore.Open(cfg)
+	if err != nil {
+		return nil, err // a missing driver or bad DSN won't go away
+	}
+	if err := Wait(ctx, cfg.String(), db.Ping); err != nil {
+		db.Close()
+		return nil, err
+	}
+	if err := db.Migrate(ctx); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// Redis waits until rdb answers a PING
+func Redis(ctx context.Context, rdb *redis.Client) error {
+	return Wait(ctx, "Redis "+rdb.Options().Addr, func(ctx context.Context) error {
+		return rdb.Ping(ctx).Err()
+	})
+}
+
+// Kafka waits until a broker answers a metadata request. Readers and writers
+// retry on their own; this only keeps a service from starting its loops
+// against a cluster that isn't up yet.
+func Kafka(ctx context.Context, cfg kafkautil.Config) error {
+	client := cfg.Client()
+	err := Wait(ctx, "Kafka", func(ctx context.Context) error {
+		_, err := client.Metadata(ctx, &kafka.MetadataRequest{})
+		return err
+	})
+	if err != nil || len(cfg.Brokers) == 1 {
+		return err
+	}
+	// Any broker answering is enough to start; say which ones didn't
+	for broker, err := range cfg.UnreachableBrokers(ctx) {
+		log.Printf("Warning: Kafka broker %s unreachable, bootstrapping from the others: %v", broker, err)
+	}
+	return nil
+}
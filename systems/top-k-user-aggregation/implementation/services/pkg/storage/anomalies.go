@@ -0,0 +1,68 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/system-design-lab/pkg/chaos"
+)
+
+// Anomaly is a user (or one of their songs) whose count for a day crossed a
+// detection threshold
+type Anomaly struct {
+	Day        string // DayFormat
+	UserID     string
+	Kind       string
+	SongID     string // empty for user-level kinds
+	Count      int64
+	Threshold  float64
+	Score      float64
+	DetectedAt time.Time
+}
+
+// AnomalyRepo reads and writes the anomalies table
+type AnomalyRepo struct {
+	s *Session
+}
+
+func NewAnomalyRepo(s *Session) *AnomalyRepo {
+	return &AnomalyRepo{s: s}
+}
+
+// Put upserts an anomaly; detecting it again replaces the row
+func (r *AnomalyRepo) Put(ctx context.Context, a Anomaly) (err error) {
+	defer observe("anomalies.put", time.Now(), &err)
+	if drop, err := injectWrite(ctx); drop || err != nil {
+		return err
+	}
+
+	return r.s.s.Query(`
+		INSERT INTO anomalies (day, user_id, kind, song_id, listen_count, threshold, score, detected_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`, a.Day, a.UserID, a.Kind, a.SongID, a.Count, a.Threshold, a.Score, a.DetectedAt).
+		WithContext(ctx).Idempotent(true).Exec()
+}
+
+// ListDay returns a day's anomalies, ordered by user
+func (r *AnomalyRepo) ListDay(ctx context.Context, day string) (out []Anomaly, err error) {
+	defer observe("anomalies.list_day", time.Now(), &err)
+	if err := chaos.Inject(ctx, chaos.CassandraRead); err != nil {
+		return nil, err
+	}
+
+	iter := r.s.s.Query(`
+		SELECT user_id, kind, song_id, listen_count, threshold, score, detected_at
+		FROM anomalies
+		WHERE day = ?
+	`, day).WithContext(ctx).Idempotent(true).Iter()
+
+	a := Anomaly{Day: day}
+	for iter.Scan(&a.UserID, &a.Kind, &a.SongID, &a.Count, &a.Threshold, &a.Score, &a.DetectedAt) {
+		out = append(out, a)
+	}
+	if err := iter.Close(); err != nil {
+		return nil, fmt.Errorf("query error for day %s: %w", day, err)
+	}
+	return out, nil
+}
@@ -0,0 +1,74 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/system-design-lab/pkg/chaos"
+)
+
+// DailyArtistTopKRepo reads and writes the user_daily_artist_topk counter
+// table, user_daily_topk rolled up by the songs' artists
+type DailyArtistTopKRepo struct {
+	s *Session
+}
+
+func NewDailyArtistTopKRepo(s *Session) *DailyArtistTopKRepo {
+	return &DailyArtistTopKRepo{s: s}
+}
+
+// Increment adds delta to an artist's count for a user and day. Like
+// DailyTopKRepo.Increment it is never retried here.
+func (r *DailyArtistTopKRepo) Increment(ctx context.Context, userID, day, artistID string, delta int64) (err error) {
+	defer observe("user_daily_artist_topk.increment", time.Now(), &err)
+	if drop, err := injectWrite(ctx); drop || err != nil {
+		return err
+	}
+
+	return r.s.s.Query(`
+		UPDATE user_daily_artist_topk
+		SET listen_count = listen_count + ?
+		WHERE user_id = ? AND day = ? AND artist_id = ?
+	`, delta, userID, day, artistID).WithContext(ctx).RetryPolicy(nil).Exec()
+}
+
+// DayCounts returns artist -> count for one user and day
+func (r *DailyArtistTopKRepo) DayCounts(ctx context.Context, userID, day string) (counts map[string]int64, err error) {
+	defer observe("user_daily_artist_topk.day_counts", time.Now(), &err)
+	if err := chaos.Inject(ctx, chaos.CassandraRead); err != nil {
+		return nil, err
+	}
+
+	iter := r.s.s.Query(`
+		SELECT artist_id, listen_count
+		FROM user_daily_artist_topk
+		WHERE user_id = ? AND day = ?
+	`, userID, day).WithContext(ctx).Idempotent(true).Iter()
+
+	counts = make(map[string]int64)
+	var artistID string
+	var count int64
+	for iter.Scan(&artistID, &count) {
+		counts[artistID] += count
+	}
+	if err := iter.Close(); err != nil {
+		return nil, fmt.Errorf("query error for day %s: %w", day, err)
+	}
+	return counts, nil
+}
+
+// SumCounts adds up DayCounts over several days
+func (r *DailyArtistTopKRepo) SumCounts(ctx context.Context, userID string, days []string) (map[string]int64, error) {
+	total := make(map[string]int64)
+	for _, day := range days {
+		counts, err := r.DayCounts(ctx, userID, day)
+		if err != nil {
+			return nil, err
+		}
+		for artist, c := range counts {
+			total[artist] += c
+		}
+	}
+	return total, nil
+}
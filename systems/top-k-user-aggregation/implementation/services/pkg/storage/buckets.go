@@ -0,0 +1,132 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"sync"
+	"time"
+
+	"github.com/system-design-lab/pkg/chaos"
+)
+
+// BucketLayout is a user's row of topk_buckets: from FromDay on, the user's
+// daily counters are in user_daily_topk_bucketed, spread over Buckets
+// partitions per day, so one busy day can't grow a single huge partition.
+// Earlier days stay in user_daily_topk.
+type BucketLayout struct {
+	UserID    string
+	Buckets   int
+	FromDay   string // DayFormat
+	UpdatedAt time.Time
+}
+
+// SongBucket is the bucket of a song for a user with n buckets
+func SongBucket(songID string, n int) int {
+	h := fnv.New32a()
+	h.Write([]byte(songID))
+	return int(h.Sum32() % uint32(n))
+}
+
+// BucketRepo reads and writes topk_buckets
+type BucketRepo struct {
+	s *Session
+}
+
+func NewBucketRepo(s *Session) *BucketRepo {
+	return &BucketRepo{s: s}
+}
+
+// Put writes a user's layout, replacing the previous one
+func (r *BucketRepo) Put(ctx context.Context, l BucketLayout) (err error) {
+	defer observe("topk_buckets.put", time.Now(), &err)
+	if drop, err := injectWrite(ctx); drop || err != nil {
+		return err
+	}
+
+	return r.s.s.Query(`
+		INSERT INTO topk_buckets (user_id, buckets, from_day, updated_at)
+		VALUES (?, ?, ?, ?)
+	`, l.UserID, l.Buckets, l.FromDay, time.Now()).WithContext(ctx).Idempotent(true).Exec()
+}
+
+// List returns every layout. The table only holds the outliers, so this is
+// one small read.
+func (r *BucketRepo) List(ctx context.Context) (layouts []BucketLayout, err error) {
+	defer observe("topk_buckets.list", time.Now(), &err)
+	if err := chaos.Inject(ctx, chaos.CassandraRead); err != nil {
+		return nil, err
+	}
+
+	iter := r.s.s.Query(`
+		SELECT user_id, buckets, from_day, updated_at FROM topk_buckets
+	`).WithContext(ctx).Idempotent(true).Iter()
+
+	var (
+		l   BucketLayout
+		day time.Time
+	)
+	for iter.Scan(&l.UserID, &l.Buckets, &day, &l.UpdatedAt) {
+		l.FromDay = day.Format(DayFormat)
+		layouts = append(layouts, l)
+	}
+	if err := iter.Close(); err != nil {
+		return nil, fmt.Errorf("query layouts: %w", err)
+	}
+	return layouts, nil
+}
+
+// BucketRefresh is how often a DailyTopKRepo reloads topk_buckets. A layout
+// must be written at least this long before its FromDay starts, so no
+// service still writes the plain table then: tools buckets enforces it.
+const BucketRefresh = 30 * time.Second
+
+// bucketCache is a DailyTopKRepo's copy of topk_buckets, reloaded every
+// BucketRefresh by whichever call finds it stale
+type bucketCache struct {
+	repo *BucketRepo
+
+	mu      sync.Mutex
+	layouts map[string]BucketLayout
+	loaded  time.Time
+}
+
+// count returns a user's buckets for a day, 0 for the plain table
+func (c *bucketCache) count(ctx context.Context, userID, day string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if time.Since(c.loaded) >= BucketRefresh {
+		c.reload(ctx)
+	}
+	l, ok := c.layouts[userID]
+	if !ok || day < l.FromDay {
+		return 0
+	}
+	return l.Buckets
+}
+
+// reload keeps the previous layouts when the read fails (counted in the
+// topk_buckets.list metrics) and retries after BucketRefresh; c.mu must be
+// held
+func (c *bucketCache) reload(ctx context.Context) {
+	c.loaded = time.Now()
+	layouts, err := c.repo.List(ctx)
+	if err != nil {
+		return
+	}
+	c.layouts = make(map[string]BucketLayout, len(layouts))
+	for _, l := range layouts {
+		if l.Buckets > 0 {
+			c.layouts[l.UserID] = l
+		}
+	}
+}
+
+// bucketList is the IN list of a day's buckets
+func bucketList(n int) []int {
+	buckets := make([]int, n)
+	for i := range buckets {
+		buckets[i] = i
+	}
+	return buckets
+}
@@ -0,0 +1,36 @@
+package storage
+
+import (
+	"context"
+	"time"
+)
+
+// DedupAuditRepo writes dedup_audit, the exact record of the event IDs the
+// aggregator samples to measure its bloom filter's false positives
+type DedupAuditRepo struct {
+	s *Session
+}
+
+func NewDedupAuditRepo(s *Session) *DedupAuditRepo {
+	return &DedupAuditRepo{s: s}
+}
+
+// Record adds an event ID with the bloom's verdict on it, unless it's already
+// there. It is a lightweight transaction: seen reports whether the ID was
+// recorded before, exactly, for as long as ttl keeps it.
+func (r *DedupAuditRepo) Record(ctx context.Context, day, eventID string, bloomDuplicate bool, ttl time.Duration) (seen bool, err error) {
+	defer observe("dedup_audit.record", time.Now(), &err)
+	if drop, err := injectWrite(ctx); drop || err != nil {
+		return false, err
+	}
+
+	applied, err := r.s.s.Query(`
+		INSERT INTO dedup_audit (day, event_id, first_seen, bloom_duplicate)
+		VALUES (?, ?, ?, ?)
+		IF NOT EXISTS USING TTL ?
+	`, day, eventID, time.Now(), bloomDuplicate, int(ttl.Seconds())).WithContext(ctx).MapScanCAS(map[string]interface{}{})
+	if err != nil {
+		return false, err
+	}
+	return !applied, nil
+}
@@ -0,0 +1,101 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/gocql/gocql"
+	"github.com/system-design-lab/pkg/chaos"
+)
+
+// ShadowTopKRepo writes a second copy of the daily counters in the bucketed
+// layout while a migration dual-writes them (aggregator DUAL_WRITE), so the
+// new layout can be checked against user_daily_topk before reads move to it.
+// Its rows are in user_daily_topk_bucketed with no topk_buckets layout over
+// them: DailyTopKRepo ignores them until tools buckets enable switches the
+// user to them.
+type ShadowTopKRepo struct {
+	s       *Session
+	buckets int
+}
+
+func NewShadowTopKRepo(s *Session, buckets int) *ShadowTopKRepo {
+	return &ShadowTopKRepo{s: s, buckets: buckets}
+}
+
+// Buckets is the partitions per day of the copy
+func (r *ShadowTopKRepo) Buckets() int {
+	return r.buckets
+}
+
+func (r *ShadowTopKRepo) update(column, userID, day, songID string, delta int64) *gocql.Query {
+	return r.s.s.Query(`
+		UPDATE user_daily_topk_bucketed
+		SET `+column+` = `+column+` + ?
+		WHERE user_id = ? AND day = ? AND bucket = ? AND song_id = ?
+	`, delta, userID, day, SongBucket(songID, r.buckets), songID)
+}
+
+// Increment adds delta to the copy of a song's count. Like
+// DailyTopKRepo.Increment it is never retried here.
+func (r *ShadowTopKRepo) Increment(ctx context.Context, userID, day, songID string, delta int64) (err error) {
+	defer observe("user_daily_topk_shadow.increment", time.Now(), &err)
+	if drop, err := injectWrite(ctx); drop || err != nil {
+		return err
+	}
+
+	return r.update("listen_count", userID, day, songID, delta).WithContext(ctx).RetryPolicy(nil).Exec()
+}
+
+// IncrementTime adds ms to the copy of a song's listening time
+func (r *ShadowTopKRepo) IncrementTime(ctx context.Context, userID, day, songID string, ms int64) (err error) {
+	defer observe("user_daily_topk_shadow.increment_time", time.Now(), &err)
+	if drop, err := injectWrite(ctx); drop || err != nil {
+		return err
+	}
+
+	return r.update("listen_ms", userID, day, songID, ms).WithContext(ctx).RetryPolicy(nil).Exec()
+}
+
+// DeleteSong removes the copy of a song's row, next to
+// DailyTopKRepo.DeleteSong
+func (r *ShadowTopKRepo) DeleteSong(ctx context.Context, userID, day, songID string) (err error) {
+	defer observe("user_daily_topk_shadow.delete_song", time.Now(), &err)
+	if drop, err := injectWrite(ctx); drop || err != nil {
+		return err
+	}
+
+	return r.s.s.Query(`
+		DELETE FROM user_daily_topk_bucketed
+		WHERE user_id = ? AND day = ? AND bucket = ? AND song_id = ?
+	`, userID, day, SongBucket(songID, r.buckets), songID).WithContext(ctx).Idempotent(true).Exec()
+}
+
+// DayTotals reads the copy of a user's day, like DailyTopKRepo.DayTotals
+func (r *ShadowTopKRepo) DayTotals(ctx context.Context, userID, day string) (totals map[string]SongTotals, err error) {
+	defer observe("user_daily_topk_shadow.day_totals", time.Now(), &err)
+	if err := chaos.Inject(ctx, chaos.CassandraRead); err != nil {
+		return nil, err
+	}
+
+	iter := r.s.s.Query(`
+		SELECT song_id, listen_count, listen_ms
+		FROM user_daily_topk_bucketed
+		WHERE user_id = ? AND day = ? AND bucket IN ?
+	`, userID, day, bucketList(r.buckets)).WithContext(ctx).Idempotent(true).Iter()
+
+	totals = make(map[string]SongTotals)
+	var songID string
+	var count, ms int64
+	for iter.Scan(&songID, &count, &ms) {
+		t := totals[songID]
+		t.Count += count
+		t.Millis += ms
+		totals[songID] = t
+	}
+	if err := iter.Close(); err != nil {
+		return nil, fmt.Errorf("query error for day %s: %w", day, err)
+	}
+	return totals, nil
+}
@@ -0,0 +1,213 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"github.com/system-design-lab/pkg/chaos"
+)
+
+// MaxExperimentDays is the longest an experiment may run: its counters
+// can't expire, so experiments are kept short
+const MaxExperimentDays = 90
+
+// Experiment is an experiments row. Listens tagged with it are counted per
+// experiment on the days from FirstDay to LastDay (DayFormat, inclusive).
+type Experiment struct {
+	Name        string
+	Description string
+	FirstDay    string
+	LastDay     string
+	CreatedAt   time.Time
+}
+
+// Covers reports whether day (DayFormat) is within the experiment
+func (e Experiment) Covers(day string) bool {
+	return day >= e.FirstDay && day <= e.LastDay
+}
+
+// ExperimentRepo reads and writes experiments
+type ExperimentRepo struct {
+	s *Session
+}
+
+func NewExperimentRepo(s *Session) *ExperimentRepo {
+	return &ExperimentRepo{s: s}
+}
+
+// Put registers an experiment, replacing one of the same name
+func (r *ExperimentRepo) Put(ctx context.Context, e Experiment) (err error) {
+	defer observe("experiments.put", time.Now(), &err)
+	if drop, err := injectWrite(ctx); drop || err != nil {
+		return err
+	}
+
+	return r.s.s.Query(`
+		INSERT INTO experiments (name, description, first_day, last_day, created_at)
+		VALUES (?, ?, ?, ?, ?)
+	`, e.Name, e.Description, e.FirstDay, e.LastDay, time.Now()).WithContext(ctx).Idempotent(true).Exec()
+}
+
+// List returns every experiment, one small read
+func (r *ExperimentRepo) List(ctx context.Context) (out []Experiment, err error) {
+	defer observe("experiments.list", time.Now(), &err)
+	if err := chaos.Inject(ctx, chaos.CassandraRead); err != nil {
+		return nil, err
+	}
+
+	iter := r.s.s.Query(`
+		SELECT name, description, first_day, last_day, created_at FROM experiments
+	`).WithContext(ctx).Idempotent(true).Iter()
+
+	var (
+		e           Experiment
+		first, last time.Time
+	)
+	for iter.Scan(&e.Name, &e.Description, &first, &last, &e.CreatedAt) {
+		e.FirstDay, e.LastDay = first.Format(DayFormat), last.Format(DayFormat)
+		out = append(out, e)
+		e = Experiment{}
+	}
+	if err := iter.Close(); err != nil {
+		return nil, fmt.Errorf("query experiments: %w", err)
+	}
+	return out, nil
+}
+
+// ExperimentRefresh is how often an ExperimentSet reloads experiments
+const ExperimentRefresh = 30 * time.Second
+
+// ExperimentSet is a service's copy of the experiments, reloaded every
+// ExperimentRefresh by Start. A set without a repo stays empty.
+type ExperimentSet struct {
+	repo   *ExperimentRepo
+	byName atomic.Pointer[map[string]Experiment]
+}
+
+func NewExperimentSet(repo *ExperimentRepo) *ExperimentSet {
+	s := &ExperimentSet{repo: repo}
+	s.byName.Store(&map[string]Experiment{})
+	return s
+}
+
+// Start loads the set and keeps reloading it until ctx is done. A failed
+// first load is returned; the set is then empty until a reload succeeds.
+func (s *ExperimentSet) Start(ctx context.Context) error {
+	if s.repo == nil {
+		return nil
+	}
+	err := s.reload(ctx)
+	go func() {
+		ticker := time.NewTicker(ExperimentRefresh)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			if err := s.reload(ctx); err != nil {
+				log.Printf("Error reloading experiments, keeping %d: %v", len(*s.byName.Load()), err)
+			}
+		}
+	}()
+	return err
+}
+
+func (s *ExperimentSet) reload(ctx context.Context) error {
+	list, err := s.repo.List(ctx)
+	if err != nil {
+		return err
+	}
+	byName := make(map[string]Experiment, len(list))
+	for _, e := range list {
+		byName[e.Name] = e
+	}
+	s.byName.Store(&byName)
+	return nil
+}
+
+// Get returns a registered experiment
+func (s *ExperimentSet) Get(name string) (Experiment, bool) {
+	e, ok := (*s.byName.Load())[name]
+	return e, ok
+}
+
+// Active reports whether listens tagged name on day are counted for it
+func (s *ExperimentSet) Active(name, day string) bool {
+	e, ok := s.Get(name)
+	return ok && e.Covers(day)
+}
+
+// Len is the number of registered experiments
+func (s *ExperimentSet) Len() int {
+	return len(*s.byName.Load())
+}
+
+// ExperimentTopKRepo reads and writes the user_daily_experiment_topk
+// counter table: user_daily_topk restricted to one experiment's listens
+type ExperimentTopKRepo struct {
+	s *Session
+}
+
+func NewExperimentTopKRepo(s *Session) *ExperimentTopKRepo {
+	return &ExperimentTopKRepo{s: s}
+}
+
+// Increment adds delta to a song's count in an experiment for a user and
+// day. Like DailyTopKRepo.Increment it is never retried here.
+func (r *ExperimentTopKRepo) Increment(ctx context.Context, userID, day, experiment, songID string, delta int64) (err error) {
+	defer observe("user_daily_experiment_topk.increment", time.Now(), &err)
+	if drop, err := injectWrite(ctx); drop || err != nil {
+		return err
+	}
+
+	return r.s.s.Query(`
+		UPDATE user_daily_experiment_topk
+		SET listen_count = listen_count + ?
+		WHERE user_id = ? AND day = ? AND experiment = ? AND song_id = ?
+	`, delta, userID, day, experiment, songID).WithContext(ctx).RetryPolicy(nil).Exec()
+}
+
+// DayCounts returns song -> count for one user, day and experiment
+func (r *ExperimentTopKRepo) DayCounts(ctx context.Context, userID, day, experiment string) (counts map[string]int64, err error) {
+	defer observe("user_daily_experiment_topk.day_counts", time.Now(), &err)
+	if err := chaos.Inject(ctx, chaos.CassandraRead); err != nil {
+		return nil, err
+	}
+
+	iter := r.s.s.Query(`
+		SELECT song_id, listen_count
+		FROM user_daily_experiment_topk
+		WHERE user_id = ? AND day = ? AND experiment = ?
+	`, userID, day, experiment).WithContext(ctx).Idempotent(true).Iter()
+
+	counts = make(map[string]int64)
+	var songID string
+	var count int64
+	for iter.Scan(&songID, &count) {
+		counts[songID] += count
+	}
+	if err := iter.Close(); err != nil {
+		return nil, fmt.Errorf("query error for day %s: %w", day, err)
+	}
+	return counts, nil
+}
+
+// SumCounts adds up DayCounts over several days
+func (r *ExperimentTopKRepo) SumCounts(ctx context.Context, userID, experiment string, days []string) (map[string]int64, error) {
+	total := make(map[string]int64)
+	for _, day := range days {
+		counts, err := r.DayCounts(ctx, userID, day, experiment)
+		if err != nil {
+			return nil, err
+		}
+		for song, c := range counts {
+			total[song] += c
+		}
+	}
+	return total, nil
+}
@@ -0,0 +1,138 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"github.com/gocql/gocql"
+	"github.com/system-design-lab/pkg/chaos"
+)
+
+// AppliedFlush is a partition's row of the apply log
+type AppliedFlush struct {
+	FlushID     string // last applied flush, "" if none yet
+	LastOffset  int64  // offsets up to here are applied; -1 if none yet
+	PendingID   string // claimed but not completed, "" if none
+	PendingLast int64
+	AppliedAt   time.Time
+}
+
+// AppliedFlushRepo reads and writes applied_flushes with lightweight
+// transactions. A flush is claimed (pending), applied by the caller, then
+// completed; both steps are conditional, so a second writer fails instead of
+// applying the same offsets again.
+type AppliedFlushRepo struct {
+	s *Session
+}
+
+func NewAppliedFlushRepo(s *Session) *AppliedFlushRepo {
+	return &AppliedFlushRepo{s: s}
+}
+
+// Get returns a partition's apply log; ok is false if it has none
+func (r *AppliedFlushRepo) Get(ctx context.Context, group, topic string, partition int) (f AppliedFlush, ok bool, err error) {
+	defer observe("applied_flushes.get", time.Now(), &err)
+	if err := chaos.Inject(ctx, chaos.CassandraRead); err != nil {
+		return f, false, err
+	}
+
+	var flushID, pendingID *string
+	var lastOffset, pendingLast *int64
+	var appliedAt *time.Time
+	err = r.s.s.Query(`
+		SELECT flush_id, last_offset, pending_id, pending_last, applied_at
+		FROM applied_flushes
+		WHERE consumer_group = ? AND topic = ? AND partition = ?
+	`, group, topic, partition).WithContext(ctx).Idempotent(true).
+		Consistency(gocql.LocalQuorum). // see committed LWTs
+		Scan(&flushID, &lastOffset, &pendingID, &pendingLast, &appliedAt)
+	if err == gocql.ErrNotFound {
+		return AppliedFlush{LastOffset: -1}, false, nil
+	}
+	if err != nil {
+		return f, false, err
+	}
+
+	f.LastOffset = -1
+	if flushID != nil {
+		f.FlushID = *flushID
+	}
+	if lastOffset != nil {
+		f.LastOffset = *lastOffset
+	}
+	if pendingID != nil {
+		f.PendingID = *pendingID
+	}
+	if pendingLast != nil {
+		f.PendingLast = *pendingLast
+	}
+	if appliedAt != nil {
+		f.AppliedAt = *appliedAt
+	}
+	return f, true, nil
+}
+
+// Claim marks flushID (covering offsets up to last) as pending, provided prev
+// is still the partition's last applied flush and nothing else is pending.
+// exists says whether prev came from an existing row. A false result means
+// another writer got there first.
+func (r *AppliedFlushRepo) Claim(ctx context.Context, group, topic string, partition int, prev AppliedFlush, exists bool, flushID string, last int64) (applied bool, err error) {
+	defer observe("applied_flushes.claim", time.Now(), &err)
+	// Errors only: a dropped LWT would break the claim/complete protocol
+	if err := chaos.Inject(ctx, chaos.CassandraWrite); err != nil {
+		return false, err
+	}
+
+	var q *gocql.Query
+	if exists {
+		q = r.s.s.Query(`
+			UPDATE applied_flushes SET pending_id = ?, pending_last = ?
+			WHERE consumer_group = ? AND topic = ? AND partition = ?
+			IF flush_id = ? AND pending_id = null
+		`, flushID, last, group, topic, partition, prev.FlushID)
+	} else {
+		q = r.s.s.Query(`
+			INSERT INTO applied_flushes (consumer_group, topic, partition, pending_id, pending_last)
+			VALUES (?, ?, ?, ?, ?)
+			IF NOT EXISTS
+		`, group, topic, partition, flushID, last)
+	}
+
+	current := map[string]interface{}{}
+	applied, err = q.WithContext(ctx).MapScanCAS(current)
+	if err != nil {
+		return false, err
+	}
+	if !applied {
+		// A retried claim that did land the first time reads as ours
+		if id, _ := current["pending_id"].(string); id == flushID {
+			return true, nil
+		}
+	}
+	return applied, nil
+}
+
+// Complete makes the pending flushID the partition's last applied flush
+func (r *AppliedFlushRepo) Complete(ctx context.Context, group, topic string, partition int, flushID string, last int64) (applied bool, err error) {
+	defer observe("applied_flushes.complete", time.Now(), &err)
+	if err := chaos.Inject(ctx, chaos.CassandraWrite); err != nil {
+		return false, err
+	}
+
+	applied, err = r.s.s.Query(`
+		UPDATE applied_flushes
+		SET flush_id = ?, last_offset = ?, pending_id = null, pending_last = null, applied_at = ?
+		WHERE consumer_group = ? AND topic = ? AND partition = ?
+		IF pending_id = ?
+	`, flushID, last, time.Now(), group, topic, partition, flushID).WithContext(ctx).MapScanCAS(map[string]interface{}{})
+	if err != nil || applied {
+		return applied, err
+	}
+
+	// Not pending any more: fine if it's because a retry already completed it
+	f, ok, err := r.Get(ctx, group, topic, partition)
+	if err != nil {
+		return false, err
+	}
+	return ok && f.FlushID == flushID, nil
+}
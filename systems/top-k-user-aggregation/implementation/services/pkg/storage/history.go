@@ -0,0 +1,162 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/gocql/gocql"
+	"github.com/system-design-lab/pkg/chaos"
+	"github.com/system-design-lab/pkg/events"
+)
+
+// ErrExists is returned by ListenHistoryRepo.Insert with ifNotExists when the
+// row is already there
+var ErrExists = errors.New("row already exists")
+
+// HistoryRow is one user_listen_history row: the event plus the fields
+// derived at ingest
+type HistoryRow struct {
+	events.ListenEvent
+
+	HourBucket int64 // listened_at truncated to the hour (unix seconds)
+	Hour       int
+	Weekday    string
+	Extra      map[string]string
+}
+
+// ListenHistoryRepo reads and writes user_listen_history
+type ListenHistoryRepo struct {
+	s *Session
+}
+
+func NewListenHistoryRepo(s *Session) *ListenHistoryRepo {
+	return &ListenHistoryRepo{s: s}
+}
+
+const historyInsert = `
+	INSERT INTO user_listen_history
+		(user_id, day, listened_at, event_id, song_id, provider,
+		 hour_bucket, hour, weekday, source, context, artist_id, duration_ms, extra)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+`
+
+// Insert writes a row with an explicit TTL (0 = keep forever). With
+// ifNotExists it's a lightweight transaction and replays of the same event
+// return ErrExists instead of overwriting.
+func (r *ListenHistoryRepo) Insert(ctx context.Context, row HistoryRow, ttl time.Duration, ifNotExists bool) (err error) {
+	defer observe("user_listen_history.insert", time.Now(), &err)
+	if drop, err := injectWrite(ctx); drop || err != nil {
+		return err
+	}
+
+	args := []interface{}{
+		row.UserID,
+		row.Day(),
+		row.Time(),
+		row.EventID,
+		row.SongID,
+		row.Provider,
+		time.Unix(row.HourBucket, 0),
+		row.Hour,
+		row.Weekday,
+		row.Source,
+		row.Context,
+		row.ArtistID,
+		row.DurationMs,
+		row.Extra,
+		int(ttl.Seconds()),
+	}
+
+	if ifNotExists {
+		// The primary key (user_id, day, listened_at, event_id) identifies a replay
+		q := r.s.s.Query(historyInsert+" IF NOT EXISTS USING TTL ?", args...).WithContext(ctx)
+		applied, err := q.MapScanCAS(map[string]interface{}{})
+		if err != nil {
+			return err
+		}
+		if !applied {
+			return ErrExists
+		}
+		return nil
+	}
+	return r.s.s.Query(historyInsert+" USING TTL ?", args...).WithContext(ctx).Idempotent(true).Exec()
+}
+
+// ListDay returns up to limit rows of a user's day, newest first
+func (r *ListenHistoryRepo) ListDay(ctx context.Context, userID, day string, limit int) (rows []HistoryRow, err error) {
+	defer observe("user_listen_history.list_day", time.Now(), &err)
+	if err := chaos.Inject(ctx, chaos.CassandraRead); err != nil {
+		return nil, err
+	}
+
+	q := r.s.s.Query(historySelect+" LIMIT ?", userID, day, limit)
+	err = scanHistory(q.WithContext(ctx).Idempotent(true).Iter(), userID, func(row HistoryRow) error {
+		rows = append(rows, row)
+		return nil
+	})
+	return rows, err
+}
+
+// ScanDay calls fn for every row of a user's day, newest first, paging
+// through the partition instead of loading it at once. An error from fn stops
+// the scan and is returned.
+func (r *ListenHistoryRepo) ScanDay(ctx context.Context, userID, day string, fn func(HistoryRow) error) (err error) {
+	defer observe("user_listen_history.scan_day", time.Now(), &err)
+	if err := chaos.Inject(ctx, chaos.CassandraRead); err != nil {
+		return err
+	}
+
+	q := r.s.s.Query(historySelect, userID, day).PageSize(1000)
+	return scanHistory(q.WithContext(ctx).Idempotent(true).Iter(), userID, fn)
+}
+
+// Partitions calls fn for every (user_id, day) partition of
+// user_listen_history. It reads the partition keys of the whole table: meant
+// for repairs and other offline jobs, not the request path.
+func (r *ListenHistoryRepo) Partitions(ctx context.Context, fn func(userID, day string) error) (err error) {
+	defer observe("user_listen_history.partitions", time.Now(), &err)
+	if err := chaos.Inject(ctx, chaos.CassandraRead); err != nil {
+		return err
+	}
+
+	iter := r.s.s.Query(`SELECT DISTINCT user_id, day FROM user_listen_history`).
+		WithContext(ctx).Idempotent(true).PageSize(5000).Iter()
+	var (
+		userID string
+		day    time.Time
+	)
+	for iter.Scan(&userID, &day) {
+		if err := fn(userID, day.Format(DayFormat)); err != nil {
+			iter.Close()
+			return err
+		}
+	}
+	return iter.Close()
+}
+
+const historySelect = `
+	SELECT event_id, song_id, provider, listened_at, hour_bucket, hour, weekday, source, context, artist_id, duration_ms, extra
+	FROM user_listen_history
+	WHERE user_id = ? AND day = ?
+`
+
+func scanHistory(iter *gocql.Iter, userID string, fn func(HistoryRow) error) error {
+	var (
+		row                    HistoryRow
+		listenedAt, hourBucket time.Time
+	)
+	for iter.Scan(&row.EventID, &row.SongID, &row.Provider, &listenedAt, &hourBucket,
+		&row.Hour, &row.Weekday, &row.Source, &row.Context, &row.ArtistID, &row.DurationMs, &row.Extra) {
+		row.SchemaVersion = events.SchemaVersion
+		row.UserID = userID
+		row.ListenedAt = listenedAt.Unix()
+		row.HourBucket = hourBucket.Unix()
+		if err := fn(row); err != nil {
+			iter.Close()
+			return err
+		}
+		row = HistoryRow{}
+	}
+	return iter.Close()
+}
@@ -0,0 +1,178 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/system-design-lab/pkg/chaos"
+)
+
+// HourlyTopKRepo reads and writes the user_hourly_topk counter table, which
+// backs sliding windows ("last 24 hours") where user_daily_topk can only
+// answer calendar days
+type HourlyTopKRepo struct {
+	s *Session
+}
+
+func NewHourlyTopKRepo(s *Session) *HourlyTopKRepo {
+	return &HourlyTopKRepo{s: s}
+}
+
+// Increment adds delta to a song's count for a user, day and UTC hour. Like
+// DailyTopKRepo.Increment it is never retried here.
+func (r *HourlyTopKRepo) Increment(ctx context.Context, userID, day string, hour int, songID string, delta int64) (err error) {
+	defer observe("user_hourly_topk.increment", time.Now(), &err)
+	if drop, err := injectWrite(ctx); drop || err != nil {
+		return err
+	}
+
+	return r.s.s.Query(`
+		UPDATE user_hourly_topk
+		SET listen_count = listen_count + ?
+		WHERE user_id = ? AND day = ? AND hour = ? AND song_id = ?
+	`, delta, userID, day, hour, songID).WithContext(ctx).RetryPolicy(nil).Exec()
+}
+
+// IncrementTime adds ms to a song's listening time for a user, day and UTC
+// hour. Like Increment it is never retried here.
+func (r *HourlyTopKRepo) IncrementTime(ctx context.Context, userID, day string, hour int, songID string, ms int64) (err error) {
+	defer observe("user_hourly_topk.increment_time", time.Now(), &err)
+	if drop, err := injectWrite(ctx); drop || err != nil {
+		return err
+	}
+
+	return r.s.s.Query(`
+		UPDATE user_hourly_topk
+		SET listen_ms = listen_ms + ?
+		WHERE user_id = ? AND day = ? AND hour = ? AND song_id = ?
+	`, ms, userID, day, hour, songID).WithContext(ctx).RetryPolicy(nil).Exec()
+}
+
+// DeleteSong removes a song's rows from every hour of a user's day, with
+// the caveat of DailyTopKRepo.DeleteSong
+func (r *HourlyTopKRepo) DeleteSong(ctx context.Context, userID, day, songID string) (err error) {
+	defer observe("user_hourly_topk.delete_song", time.Now(), &err)
+	if drop, err := injectWrite(ctx); drop || err != nil {
+		return err
+	}
+
+	return r.s.s.Query(`
+		DELETE FROM user_hourly_topk
+		WHERE user_id = ? AND day = ? AND hour IN ? AND song_id = ?
+	`, userID, day, hoursOfDay, songID).WithContext(ctx).Idempotent(true).Exec()
+}
+
+var hoursOfDay = []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19, 20, 21, 22, 23}
+
+// HourCounts returns song -> count for one user over a span's hours of a day
+func (r *HourlyTopKRepo) HourCounts(ctx context.Context, userID string, span HourSpan) (counts map[string]int64, err error) {
+	defer observe("user_hourly_topk.hour_counts", time.Now(), &err)
+	if err := chaos.Inject(ctx, chaos.CassandraRead); err != nil {
+		return nil, err
+	}
+
+	iter := r.s.s.Query(`
+		SELECT song_id, listen_count
+		FROM user_hourly_topk
+		WHERE user_id = ? AND day = ? AND hour >= ? AND hour <= ?
+	`, userID, span.Day, span.From, span.To).WithContext(ctx).Idempotent(true).Iter()
+
+	counts = make(map[string]int64)
+	var songID string
+	var count int64
+	for iter.Scan(&songID, &count) {
+		counts[songID] += count
+	}
+	if err := iter.Close(); err != nil {
+		return nil, fmt.Errorf("query error for day %s hours %d-%d: %w", span.Day, span.From, span.To, err)
+	}
+	return counts, nil
+}
+
+// SumCounts merges HourCounts over a window's spans
+func (r *HourlyTopKRepo) SumCounts(ctx context.Context, userID string, spans []HourSpan) (map[string]int64, error) {
+	total := make(map[string]int64)
+	for _, span := range spans {
+		counts, err := r.HourCounts(ctx, userID, span)
+		if err != nil {
+			return nil, err
+		}
+		for song, c := range counts {
+			total[song] += c
+		}
+	}
+	return total, nil
+}
+
+// HourTotals is HourCounts with listening time
+func (r *HourlyTopKRepo) HourTotals(ctx context.Context, userID string, span HourSpan) (totals map[string]SongTotals, err error) {
+	defer observe("user_hourly_topk.hour_totals", time.Now(), &err)
+	if err := chaos.Inject(ctx, chaos.CassandraRead); err != nil {
+		return nil, err
+	}
+
+	iter := r.s.s.Query(`
+		SELECT song_id, listen_count, listen_ms
+		FROM user_hourly_topk
+		WHERE user_id = ? AND day = ? AND hour >= ? AND hour <= ?
+	`, userID, span.Day, span.From, span.To).WithContext(ctx).Idempotent(true).Iter()
+
+	totals = make(map[string]SongTotals)
+	var songID string
+	var count, ms int64
+	for iter.Scan(&songID, &count, &ms) {
+		t := totals[songID]
+		t.Count += count
+		t.Millis += ms
+		totals[songID] = t
+	}
+	if err := iter.Close(); err != nil {
+		return nil, fmt.Errorf("query error for day %s hours %d-%d: %w", span.Day, span.From, span.To, err)
+	}
+	return totals, nil
+}
+
+// SumTotals merges HourTotals over a window's spans
+func (r *HourlyTopKRepo) SumTotals(ctx context.Context, userID string, spans []HourSpan) (map[string]SongTotals, error) {
+	total := make(map[string]SongTotals)
+	for _, span := range spans {
+		totals, err := r.HourTotals(ctx, userID, span)
+		if err != nil {
+			return nil, err
+		}
+		mergeTotals(total, totals)
+	}
+	return total, nil
+}
+
+// HourSpan is the hours From through To (inclusive, UTC) of one day partition
+type HourSpan struct {
+	Day      string // DayFormat
+	From, To int
+}
+
+// LastHours splits the sliding window of the n hours ending with the current
+// one (UTC) into one span per day partition it touches, newest first. The
+// current hour counts whole, so the window is n-1 to n hours long.
+func LastHours(n int) []HourSpan {
+	return HoursEnding(time.Now(), n)
+}
+
+// HoursEnding is LastHours for a window ending with end's hour
+func HoursEnding(end time.Time, n int) []HourSpan {
+	end = end.UTC().Truncate(time.Hour)
+	start := end.Add(-time.Duration(n-1) * time.Hour)
+
+	var spans []HourSpan
+	for t := end; !t.Before(start); {
+		dayStart := t.Truncate(24 * time.Hour)
+		span := HourSpan{Day: t.Format(DayFormat), From: 0, To: t.Hour()}
+		if dayStart.Before(start) {
+			span.From = start.Hour()
+		}
+		spans = append(spans, span)
+		t = dayStart.Add(-time.Hour)
+	}
+	return spans
+}
@@ -0,0 +1,220 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/gocql/gocql"
+	"github.com/system-design-lab/pkg/chaos"
+)
+
+// SongMetadata is a song's catalog entry in song_metadata
+type SongMetadata struct {
+	SongID   string   `json:"song_id"`
+	ArtistID string   `json:"artist_id,omitempty"`
+	Genres   []string `json:"genres,omitempty"`
+	Mood     string   `json:"mood,omitempty"`
+	// Length of the song; 0 when unknown
+	DurationSeconds int `json:"duration_seconds,omitempty"`
+}
+
+// SongMetadataRepo reads and writes song_metadata
+type SongMetadataRepo struct {
+	s      *Session
+	onMiss func(songIDs []string)
+}
+
+func NewSongMetadataRepo(s *Session) *SongMetadataRepo {
+	return &SongMetadataRepo{s: s}
+}
+
+// OnMiss has GetMany call fn with the songs it found no entry for, e.g. to
+// have them looked up. fn is called from the reading goroutine and must not
+// block.
+func (r *SongMetadataRepo) OnMiss(fn func(songIDs []string)) { r.onMiss = fn }
+
+// Put writes a song's entry, replacing the previous one
+func (r *SongMetadataRepo) Put(ctx context.Context, m SongMetadata) (err error) {
+	defer observe("song_metadata.put", time.Now(), &err)
+	if drop, err := injectWrite(ctx); drop || err != nil {
+		return err
+	}
+
+	return r.s.s.Query(`
+		INSERT INTO song_metadata (song_id, artist_id, genres, mood, duration_seconds, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`, m.SongID, m.ArtistID, m.Genres, m.Mood, m.DurationSeconds, time.Now()).WithContext(ctx).Idempotent(true).Exec()
+}
+
+// metadataChunk bounds the IN list of one GetMany query
+const metadataChunk = 100
+
+// GetMany returns the entries of the songs that have one
+func (r *SongMetadataRepo) GetMany(ctx context.Context, songIDs []string) (meta map[string]SongMetadata, err error) {
+	defer observe("song_metadata.get_many", time.Now(), &err)
+	if err := chaos.Inject(ctx, chaos.CassandraRead); err != nil {
+		return nil, err
+	}
+
+	meta = make(map[string]SongMetadata, len(songIDs))
+	for start := 0; start < len(songIDs); start += metadataChunk {
+		end := start + metadataChunk
+		if end > len(songIDs) {
+			end = len(songIDs)
+		}
+		iter := r.s.s.Query(`
+			SELECT song_id, artist_id, genres, mood, duration_seconds
+			FROM song_metadata
+			WHERE song_id IN ?
+		`, songIDs[start:end]).WithContext(ctx).Idempotent(true).Iter()
+
+		var m SongMetadata
+		for iter.Scan(&m.SongID, &m.ArtistID, &m.Genres, &m.Mood, &m.DurationSeconds) {
+			meta[m.SongID] = m
+			m = SongMetadata{}
+		}
+		if err := iter.Close(); err != nil {
+			return nil, fmt.Errorf("query metadata: %w", err)
+		}
+	}
+	if r.onMiss != nil && len(meta) < len(songIDs) {
+		var missing []string
+		for _, id := range songIDs {
+			if _, ok := meta[id]; !ok {
+				missing = append(missing, id)
+			}
+		}
+		r.onMiss(missing)
+	}
+	return meta, nil
+}
+
+// Tag kinds of user_daily_tag_topk
+const (
+	TagGenre = "genre"
+	TagMood  = "mood"
+)
+
+// TagKey is one genre or mood
+type TagKey struct {
+	Kind string // TagGenre or TagMood
+	Tag  string
+}
+
+// RollupTags sums a day's song counts per genre and mood. A song with
+// several genres counts toward each of them; songs without metadata count
+// toward none.
+func RollupTags(songCounts map[string]int64, meta map[string]SongMetadata) map[TagKey]int64 {
+	tags := make(map[TagKey]int64)
+	for song, c := range songCounts {
+		m, ok := meta[song]
+		if !ok || c <= 0 {
+			continue
+		}
+		for _, g := range m.Genres {
+			tags[TagKey{TagGenre, g}] += c
+		}
+		if m.Mood != "" {
+			tags[TagKey{TagMood, m.Mood}] += c
+		}
+	}
+	return tags
+}
+
+// TagTopKRepo reads and writes user_daily_tag_topk, the per-genre and
+// per-mood rollup of a user's day. Rows are plain counts, not counters: a
+// (user, day) partition is always rewritten whole from user_daily_topk, so a
+// rewrite after a metadata change replaces what the old metadata produced.
+type TagTopKRepo struct {
+	s *Session
+}
+
+func NewTagTopKRepo(s *Session) *TagTopKRepo {
+	return &TagTopKRepo{s: s}
+}
+
+// Replace makes a user's day hold exactly counts, in one single-partition
+// batch. The old rows are deleted a microsecond before the new ones are
+// written, so the delete can't shadow them.
+func (r *TagTopKRepo) Replace(ctx context.Context, userID, day string, counts map[TagKey]int64) (err error) {
+	defer observe("user_daily_tag_topk.replace", time.Now(), &err)
+	if drop, err := injectWrite(ctx); drop || err != nil {
+		return err
+	}
+
+	ts := time.Now().UnixMicro()
+	b := r.s.s.NewBatch(gocql.UnloggedBatch).WithContext(ctx)
+	b.Query(`DELETE FROM user_daily_tag_topk USING TIMESTAMP ? WHERE user_id = ? AND day = ?`, ts-1, userID, day)
+	for k, c := range counts {
+		b.Query(`
+			INSERT INTO user_daily_tag_topk (user_id, day, kind, tag, listen_count)
+			VALUES (?, ?, ?, ?, ?) USING TIMESTAMP ?
+		`, userID, day, k.Kind, k.Tag, c, ts)
+	}
+	return r.s.s.ExecuteBatch(b)
+}
+
+// DayCounts returns tag -> count of one kind for a user and day
+func (r *TagTopKRepo) DayCounts(ctx context.Context, userID, day, kind string) (counts map[string]int64, err error) {
+	defer observe("user_daily_tag_topk.day_counts", time.Now(), &err)
+	if err := chaos.Inject(ctx, chaos.CassandraRead); err != nil {
+		return nil, err
+	}
+
+	iter := r.s.s.Query(`
+		SELECT tag, listen_count
+		FROM user_daily_tag_topk
+		WHERE user_id = ? AND day = ? AND kind = ?
+	`, userID, day, kind).WithContext(ctx).Idempotent(true).Iter()
+
+	counts = make(map[string]int64)
+	var tag string
+	var count int64
+	for iter.Scan(&tag, &count) {
+		counts[tag] += count
+	}
+	if err := iter.Close(); err != nil {
+		return nil, fmt.Errorf("query error for day %s: %w", day, err)
+	}
+	return counts, nil
+}
+
+// SumCounts adds up DayCounts over several days
+func (r *TagTopKRepo) SumCounts(ctx context.Context, userID, kind string, days []string) (map[string]int64, error) {
+	total := make(map[string]int64)
+	for _, day := range days {
+		counts, err := r.DayCounts(ctx, userID, day, kind)
+		if err != nil {
+			return nil, err
+		}
+		for tag, c := range counts {
+			total[tag] += c
+		}
+	}
+	return total, nil
+}
+
+// RebuildTags rewrites a user's day in user_daily_tag_topk from its song
+// counters and the songs' current metadata. It is the one join step shared
+// by the materializer (after each flush) and the metadata backfill.
+func RebuildTags(ctx context.Context, topk *DailyTopKRepo, meta *SongMetadataRepo, tags *TagTopKRepo, userID, day string) error {
+	counts, err := topk.DayCounts(ctx, userID, day)
+	if err != nil {
+		return err
+	}
+	return RebuildTagsFrom(ctx, meta, tags, userID, day, counts)
+}
+
+// RebuildTagsFrom is RebuildTags with the day's song counts already read
+func RebuildTagsFrom(ctx context.Context, meta *SongMetadataRepo, tags *TagTopKRepo, userID, day string, songCounts map[string]int64) error {
+	songIDs := make([]string, 0, len(songCounts))
+	for song := range songCounts {
+		songIDs = append(songIDs, song)
+	}
+	m, err := meta.GetMany(ctx, songIDs)
+	if err != nil {
+		return err
+	}
+	return tags.Replace(ctx, userID, day, RollupTags(songCounts, m))
+}
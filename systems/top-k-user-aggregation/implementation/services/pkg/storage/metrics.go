@@ -0,0 +1,35 @@
+package storage
+
+import (
+	"context"
+	"expvar"
+	"time"
+
+	"github.com/system-design-lab/pkg/chaos"
+)
+
+// Per-operation metrics, keyed "<table>.<op>" (e.g. user_daily_topk.increment).
+// Services that serve /debug/vars expose them as-is.
+var (
+	metricQueries   = expvar.NewMap("cassandra_queries")
+	metricErrors    = expvar.NewMap("cassandra_errors")
+	metricLatencyMs = expvar.NewMap("cassandra_latency_ms_total")
+)
+
+// observe records one operation; use as defer observe(op, time.Now(), &err)
+func observe(op string, start time.Time, err *error) {
+	metricQueries.Add(op, 1)
+	metricLatencyMs.AddFloat(op, float64(time.Since(start).Microseconds())/1000)
+	if *err != nil {
+		metricErrors.Add(op, 1)
+	}
+}
+
+// injectWrite applies chaos faults to a write; drop means skip it and report
+// success
+func injectWrite(ctx context.Context) (drop bool, err error) {
+	if err := chaos.Inject(ctx, chaos.CassandraWrite); err != nil {
+		return false, err
+	}
+	return chaos.Drop(chaos.CassandraWrite), nil
+}
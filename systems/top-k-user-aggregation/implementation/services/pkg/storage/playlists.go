@@ -0,0 +1,58 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"github.com/gocql/gocql"
+	"github.com/system-design-lab/pkg/chaos"
+)
+
+// Playlist is a playlists row: a named list of songs, in order
+type Playlist struct {
+	PlaylistID string   `json:"playlist_id"`
+	Name       string   `json:"name,omitempty"`
+	SongIDs    []string `json:"song_ids"`
+}
+
+// PlaylistRepo reads and writes playlists
+type PlaylistRepo struct {
+	s *Session
+}
+
+func NewPlaylistRepo(s *Session) *PlaylistRepo {
+	return &PlaylistRepo{s: s}
+}
+
+// Put writes a playlist, replacing the previous one
+func (r *PlaylistRepo) Put(ctx context.Context, p Playlist) (err error) {
+	defer observe("playlists.put", time.Now(), &err)
+	if drop, err := injectWrite(ctx); drop || err != nil {
+		return err
+	}
+
+	return r.s.s.Query(`
+		INSERT INTO playlists (playlist_id, name, song_ids, updated_at)
+		VALUES (?, ?, ?, ?)
+	`, p.PlaylistID, p.Name, p.SongIDs, time.Now()).WithContext(ctx).Idempotent(true).Exec()
+}
+
+// Get returns a playlist; ok is false when there is none
+func (r *PlaylistRepo) Get(ctx context.Context, playlistID string) (p Playlist, ok bool, err error) {
+	defer observe("playlists.get", time.Now(), &err)
+	if err := chaos.Inject(ctx, chaos.CassandraRead); err != nil {
+		return Playlist{}, false, err
+	}
+
+	p.PlaylistID = playlistID
+	err = r.s.s.Query(`
+		SELECT name, song_ids FROM playlists WHERE playlist_id = ?
+	`, playlistID).WithContext(ctx).Idempotent(true).Scan(&p.Name, &p.SongIDs)
+	if err == gocql.ErrNotFound {
+		return Playlist{}, false, nil
+	}
+	if err != nil {
+		return Playlist{}, false, err
+	}
+	return p, true, nil
+}
@@ -0,0 +1,200 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"github.com/gocql/gocql"
+	"github.com/system-design-lab/pkg/chaos"
+)
+
+// SongCount is one ranked entry of a snapshot
+type SongCount struct {
+	SongID string `json:"song_id"`
+	Count  int64  `json:"listen_count"`
+}
+
+// Snapshot is a precomputed top-K list for a user and window
+type Snapshot struct {
+	UserID     string
+	WindowDays int
+	ComputedAt time.Time
+	Songs      []SongCount // ranked, highest first
+	// RankedVersion is the user_topk_ranked list written with the snapshot,
+	// 0 if none; RankedTotal is its length
+	RankedVersion int64
+	RankedTotal   int
+}
+
+// SnapshotRepo reads and writes user_topk_snapshot. A snapshot is a single
+// row, so a rewrite replaces the whole list atomically.
+type SnapshotRepo struct {
+	s *Session
+}
+
+func NewSnapshotRepo(s *Session) *SnapshotRepo {
+	return &SnapshotRepo{s: s}
+}
+
+// Put stores a snapshot with a TTL (0 = keep forever)
+func (r *SnapshotRepo) Put(ctx context.Context, snap Snapshot, ttl time.Duration) (err error) {
+	defer observe("user_topk_snapshot.put", time.Now(), &err)
+	if drop, err := injectWrite(ctx); drop || err != nil {
+		return err
+	}
+
+	songIDs := make([]string, len(snap.Songs))
+	counts := make([]int64, len(snap.Songs))
+	for i, sc := range snap.Songs {
+		songIDs[i] = sc.SongID
+		counts[i] = sc.Count
+	}
+	var version *int64
+	if snap.RankedVersion != 0 {
+		version = &snap.RankedVersion
+	}
+	return r.s.s.Query(`
+		INSERT INTO user_topk_snapshot (user_id, window_days, computed_at, song_ids, counts, ranked_version, ranked_total)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		USING TTL ?
+	`, snap.UserID, snap.WindowDays, snap.ComputedAt, songIDs, counts, version, snap.RankedTotal, int(ttl.Seconds())).
+		WithContext(ctx).Idempotent(true).Exec()
+}
+
+// Get returns the snapshot for a user and window; ok is false if there is none
+func (r *SnapshotRepo) Get(ctx context.Context, userID string, windowDays int) (snap Snapshot, ok bool, err error) {
+	defer observe("user_topk_snapshot.get", time.Now(), &err)
+	if err := chaos.Inject(ctx, chaos.CassandraRead); err != nil {
+		return snap, false, err
+	}
+
+	var songIDs []string
+	var counts []int64
+	err = r.s.s.Query(`
+		SELECT computed_at, song_ids, counts, ranked_version, ranked_total
+		FROM user_topk_snapshot
+		WHERE user_id = ? AND window_days = ?
+	`, userID, windowDays).WithContext(ctx).Idempotent(true).Scan(&snap.ComputedAt, &songIDs, &counts, &snap.RankedVersion, &snap.RankedTotal)
+	if err == gocql.ErrNotFound {
+		return snap, false, nil
+	}
+	if err != nil {
+		return snap, false, err
+	}
+
+	snap.UserID = userID
+	snap.WindowDays = windowDays
+	snap.Songs = make([]SongCount, len(songIDs))
+	for i := range songIDs {
+		snap.Songs[i] = SongCount{SongID: songIDs[i]}
+		if i < len(counts) {
+			snap.Songs[i].Count = counts[i]
+		}
+	}
+	return snap, true, nil
+}
+
+// Scan calls fn with every user's snapshot of windowDays. It reads the whole
+// table: meant for batch jobs, not the request path.
+func (r *SnapshotRepo) Scan(ctx context.Context, windowDays int, fn func(Snapshot) error) (err error) {
+	defer observe("user_topk_snapshot.scan", time.Now(), &err)
+	if err := chaos.Inject(ctx, chaos.CassandraRead); err != nil {
+		return err
+	}
+
+	// window_days is a clustering column: filtering it server-side would
+	// need ALLOW FILTERING over the same rows anyway
+	iter := r.s.s.Query(`
+		SELECT user_id, window_days, computed_at, song_ids, counts
+		FROM user_topk_snapshot
+	`).WithContext(ctx).Idempotent(true).PageSize(1000).Iter()
+	var (
+		snap    Snapshot
+		songIDs []string
+		counts  []int64
+	)
+	for iter.Scan(&snap.UserID, &snap.WindowDays, &snap.ComputedAt, &songIDs, &counts) {
+		if snap.WindowDays != windowDays {
+			continue
+		}
+		snap.Songs = make([]SongCount, len(songIDs))
+		for i := range songIDs {
+			snap.Songs[i] = SongCount{SongID: songIDs[i]}
+			if i < len(counts) {
+				snap.Songs[i].Count = counts[i]
+			}
+		}
+		if err := fn(snap); err != nil {
+			iter.Close()
+			return err
+		}
+	}
+	return iter.Close()
+}
+
+// RankedRepo reads and writes user_topk_ranked, a snapshot's ranked list
+// beyond its top K, one row per rank. Each materialization writes a new
+// version partition, so pages of one version stay consistent.
+type RankedRepo struct {
+	s *Session
+}
+
+func NewRankedRepo(s *Session) *RankedRepo {
+	return &RankedRepo{s: s}
+}
+
+// rankedBatch bounds the rows per single-partition batch of Put
+const rankedBatch = 100
+
+// Put writes a version's ranked list with a TTL (0 = keep forever). It must
+// land before the snapshot pointing at it.
+func (r *RankedRepo) Put(ctx context.Context, userID string, windowDays int, version int64, songs []SongCount, ttl time.Duration) (err error) {
+	defer observe("user_topk_ranked.put", time.Now(), &err)
+	if drop, err := injectWrite(ctx); drop || err != nil {
+		return err
+	}
+
+	for start := 0; start < len(songs); start += rankedBatch {
+		end := start + rankedBatch
+		if end > len(songs) {
+			end = len(songs)
+		}
+		b := r.s.s.NewBatch(gocql.UnloggedBatch).WithContext(ctx)
+		for i, sc := range songs[start:end] {
+			b.Query(`
+				INSERT INTO user_topk_ranked (user_id, window_days, version, rank, song_id, listen_count)
+				VALUES (?, ?, ?, ?, ?, ?)
+				USING TTL ?
+			`, userID, windowDays, version, start+i+1, sc.SongID, sc.Count, int(ttl.Seconds()))
+		}
+		if err := r.s.s.ExecuteBatch(b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Page returns up to limit entries of a version ranked after rank `after`,
+// in rank order. An expired version reads as empty.
+func (r *RankedRepo) Page(ctx context.Context, userID string, windowDays int, version int64, after, limit int) (songs []SongCount, err error) {
+	defer observe("user_topk_ranked.page", time.Now(), &err)
+	if err := chaos.Inject(ctx, chaos.CassandraRead); err != nil {
+		return nil, err
+	}
+
+	iter := r.s.s.Query(`
+		SELECT song_id, listen_count
+		FROM user_topk_ranked
+		WHERE user_id = ? AND window_days = ? AND version = ? AND rank > ?
+		LIMIT ?
+	`, userID, windowDays, version, after, limit).WithContext(ctx).Idempotent(true).Iter()
+
+	var sc SongCount
+	for iter.Scan(&sc.SongID, &sc.Count) {
+		songs = append(songs, sc)
+	}
+	if err := iter.Close(); err != nil {
+		return nil, err
+	}
+	return songs, nil
+}
@@ -0,0 +1,194 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/system-design-lab/pkg/chaos"
+)
+
+// SongDay is one day of a song's stats
+type SongDay struct {
+	Day       string `json:"day"` // DayFormat
+	Listens   int64  `json:"listens"`
+	Listeners int64  `json:"unique_listeners"` // HyperLogLog estimate
+}
+
+// SongStatsRepo reads and writes song_daily_listens (counters) and
+// song_daily_listeners (distinct-listener estimates)
+type SongStatsRepo struct {
+	s *Session
+}
+
+func NewSongStatsRepo(s *Session) *SongStatsRepo {
+	return &SongStatsRepo{s: s}
+}
+
+// IncrementListens adds delta to a song's listens for a day. Like
+// DailyTopKRepo.Increment it is never retried here.
+func (r *SongStatsRepo) IncrementListens(ctx context.Context, songID, day string, delta int64) (err error) {
+	defer observe("song_daily_listens.increment", time.Now(), &err)
+	if drop, err := injectWrite(ctx); drop || err != nil {
+		return err
+	}
+
+	return r.s.s.Query(`
+		UPDATE song_daily_listens
+		SET listen_count = listen_count + ?
+		WHERE song_id = ? AND day = ?
+	`, delta, songID, day).WithContext(ctx).RetryPolicy(nil).Exec()
+}
+
+// SetListeners stores a song's distinct-listener estimate for a day
+func (r *SongStatsRepo) SetListeners(ctx context.Context, songID, day string, listeners int64) (err error) {
+	defer observe("song_daily_listeners.set", time.Now(), &err)
+	if drop, err := injectWrite(ctx); drop || err != nil {
+		return err
+	}
+
+	return r.s.s.Query(`
+		INSERT INTO song_daily_listeners (song_id, day, listeners, updated_at)
+		VALUES (?, ?, ?, ?)
+	`, songID, day, listeners, time.Now()).WithContext(ctx).Idempotent(true).Exec()
+}
+
+// DeleteSong removes every day of a song's stats, with the caveat of
+// DailyTopKRepo.DeleteSong
+func (r *SongStatsRepo) DeleteSong(ctx context.Context, songID string) (err error) {
+	defer observe("song_daily_listens.delete_song", time.Now(), &err)
+	if drop, err := injectWrite(ctx); drop || err != nil {
+		return err
+	}
+
+	for _, table := range []string{"song_daily_listens", "song_daily_listeners"} {
+		if err := r.s.s.Query(`DELETE FROM `+table+` WHERE song_id = ?`, songID).
+			WithContext(ctx).Idempotent(true).Exec(); err != nil {
+			return fmt.Errorf("delete from %s: %w", table, err)
+		}
+	}
+	return nil
+}
+
+// DailyListens returns day -> listens of a song from one day to another
+// (DayFormat, inclusive), one slice of its partition. Days without listens
+// are absent.
+func (r *SongStatsRepo) DailyListens(ctx context.Context, songID, from, to string) (listens map[string]int64, err error) {
+	defer observe("song_daily_listens.range", time.Now(), &err)
+	if err := chaos.Inject(ctx, chaos.CassandraRead); err != nil {
+		return nil, err
+	}
+
+	iter := r.s.s.Query(`
+		SELECT day, listen_count FROM song_daily_listens
+		WHERE song_id = ? AND day >= ? AND day <= ?
+	`, songID, from, to).WithContext(ctx).Idempotent(true).Iter()
+
+	listens = make(map[string]int64)
+	var day string
+	var n int64
+	for iter.Scan(&day, &n) {
+		listens[day] = n
+	}
+	if err := iter.Close(); err != nil {
+		return nil, fmt.Errorf("query listens: %w", err)
+	}
+	return listens, nil
+}
+
+// Days returns a song's stats for days, in the order given. Days without
+// listens are returned with zeros.
+func (r *SongStatsRepo) Days(ctx context.Context, songID string, days []string) (out []SongDay, err error) {
+	defer observe("song_daily_stats.days", time.Now(), &err)
+	if err := chaos.Inject(ctx, chaos.CassandraRead); err != nil {
+		return nil, err
+	}
+
+	byDay := make(map[string]*SongDay, len(days))
+	out = make([]SongDay, len(days))
+	for i, day := range days {
+		out[i].Day = day
+		byDay[day] = &out[i]
+	}
+
+	var day string
+	var n int64
+	iter := r.s.s.Query(`
+		SELECT day, listen_count FROM song_daily_listens
+		WHERE song_id = ? AND day IN ?
+	`, songID, days).WithContext(ctx).Idempotent(true).Iter()
+	for iter.Scan(&day, &n) {
+		if d, ok := byDay[day]; ok {
+			d.Listens = n
+		}
+	}
+	if err := iter.Close(); err != nil {
+		return nil, fmt.Errorf("query listens: %w", err)
+	}
+
+	iter = r.s.s.Query(`
+		SELECT day, listeners FROM song_daily_listeners
+		WHERE song_id = ? AND day IN ?
+	`, songID, days).WithContext(ctx).Idempotent(true).Iter()
+	for iter.Scan(&day, &n) {
+		if d, ok := byDay[day]; ok {
+			d.Listeners = n
+		}
+	}
+	if err := iter.Close(); err != nil {
+		return nil, fmt.Errorf("query listeners: %w", err)
+	}
+	return out, nil
+}
+
+// SongDayRow is one song and day of a SongStatsRepo scan
+type SongDayRow struct {
+	SongID string
+	SongDay
+}
+
+// Scan calls fn with the stats of every song and day from one day to another
+// (DayFormat, inclusive) that has listens. Both tables are read whole and the
+// days filtered here: meant for exports and other offline jobs, not the
+// request path.
+func (r *SongStatsRepo) Scan(ctx context.Context, from, to string, fn func(SongDayRow) error) (err error) {
+	defer observe("song_daily_listens.scan", time.Now(), &err)
+	if err := chaos.Inject(ctx, chaos.CassandraRead); err != nil {
+		return err
+	}
+
+	// Listeners first, so each listens row can carry its estimate
+	type songDay struct{ song, day string }
+	listeners := make(map[songDay]int64)
+	var (
+		row SongDayRow
+		n   int64
+	)
+	iter := r.s.s.Query(`SELECT song_id, day, listeners FROM song_daily_listeners`).
+		WithContext(ctx).Idempotent(true).PageSize(5000).Iter()
+	for iter.Scan(&row.SongID, &row.Day, &n) {
+		if row.Day >= from && row.Day <= to {
+			listeners[songDay{row.SongID, row.Day}] = n
+		}
+	}
+	if err := iter.Close(); err != nil {
+		return fmt.Errorf("scan listeners: %w", err)
+	}
+
+	iter = r.s.s.Query(`SELECT song_id, day, listen_count FROM song_daily_listens`).
+		WithContext(ctx).Idempotent(true).PageSize(5000).Iter()
+	for iter.Scan(&row.SongID, &row.Day, &row.Listens) {
+		if row.Day < from || row.Day > to || row.Listens <= 0 {
+			continue
+		}
+		row.Listeners = listeners[songDay{row.SongID, row.Day}]
+		if err := fn(row); err != nil {
+			iter.Close()
+			return err
+		}
+	}
+	if err := iter.Close(); err != nil {
+		return fmt.Errorf("scan listens: %w", err)
+	}
+	return nil
+}
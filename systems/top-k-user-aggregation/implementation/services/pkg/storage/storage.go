@@ -0,0 +1,216 @@
+// Package storage is the typed Cassandra access layer shared by the
+// services. Repositories wrap gocql with context support, a retry policy for
+// idempotent statements and per-operation metrics; callers never build CQL.
+//
+// Every statement has bind markers, so gocql prepares it once per host and
+// reuses the prepared ID afterwards.
+package storage
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gocql/gocql"
+	"github.com/system-design-lab/pkg/dc"
+)
+
+// DayFormat is the layout of the Cassandra DATE partition key
+const DayFormat = "2006-01-02"
+
+// Host selection policies (CASSANDRA_HOST_POLICY)
+const (
+	HostTokenAware = "token-aware" // a replica of the partition coordinates, round robin over the rest
+	HostRoundRobin = "round-robin" // any node coordinates
+)
+
+// Retry policies of idempotent statements (CASSANDRA_RETRY_POLICY)
+const (
+	RetryExponential = "exponential" // same consistency on the next host, with backoff
+	RetryDowngrading = "downgrading" // the next consistency of DowngradeTo on each retry
+)
+
+// Config holds cluster connection settings
+type Config struct {
+	Hosts       []string
+	Keyspace    string
+	LocalDC     string // route queries to this datacenter's nodes ("" = any)
+	Consistency gocql.Consistency
+	Timeout     time.Duration
+	Retries     int // retries for idempotent statements
+
+	HostPolicy      string // HostTokenAware ("" too) or HostRoundRobin
+	ShuffleReplicas bool   // token-aware: spread a partition's queries over its replicas
+	ConnectTimeout  time.Duration
+	// ReconnectInterval is how often hosts marked down are dialed again
+	// (0 = gocql's 60s)
+	ReconnectInterval time.Duration
+	RetryPolicy       string              // RetryExponential ("" too) or RetryDowngrading
+	DowngradeTo       []gocql.Consistency // downgrading: one per retry, in order
+}
+
+// ConfigFromEnv reads CASSANDRA_HOSTS (comma-separated), CASSANDRA_KEYSPACE,
+// CASSANDRA_CONSISTENCY, CASSANDRA_TIMEOUT and CASSANDRA_RETRIES, and the
+// host and retry policies from CASSANDRA_HOST_POLICY,
+// CASSANDRA_SHUFFLE_REPLICAS, CASSANDRA_CONNECT_TIMEOUT,
+// CASSANDRA_RECONNECT_INTERVAL, CASSANDRA_RETRY_POLICY and
+// CASSANDRA_DOWNGRADE_CONSISTENCY. With LOCAL_DC set, CASSANDRA_HOSTS_<DC>
+// takes precedence for the contact points, CASSANDRA_LOCAL_DC (default
+// LOCAL_DC) names the Cassandra datacenter and the default consistency
+// becomes LOCAL_QUORUM.
+func ConfigFromEnv() (Config, error) {
+	cfg := Config{
+		Hosts:             strings.Split(dc.Env("CASSANDRA_HOSTS", "localhost:9042"), ","),
+		Keyspace:          getEnv("CASSANDRA_KEYSPACE", "topk"),
+		LocalDC:           getEnv("CASSANDRA_LOCAL_DC", dc.Local()),
+		Consistency:       gocql.LocalOne,
+		Timeout:           10 * time.Second,
+		Retries:           3,
+		HostPolicy:        getEnv("CASSANDRA_HOST_POLICY", HostTokenAware),
+		ShuffleReplicas:   getEnv("CASSANDRA_SHUFFLE_REPLICAS", "true") == "true",
+		ConnectTimeout:    5 * time.Second,
+		ReconnectInterval: 10 * time.Second,
+		RetryPolicy:       getEnv("CASSANDRA_RETRY_POLICY", RetryExponential),
+	}
+	if cfg.LocalDC != "" {
+		// Survives a node loss in the local DC and never waits on a remote one
+		cfg.Consistency = gocql.LocalQuorum
+	}
+	if v := os.Getenv("CASSANDRA_CONSISTENCY"); v != "" {
+		if err := cfg.Consistency.UnmarshalText([]byte(strings.ToUpper(v))); err != nil {
+			return cfg, fmt.Errorf("invalid CASSANDRA_CONSISTENCY %q", v)
+		}
+	}
+	if v := os.Getenv("CASSANDRA_TIMEOUT"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return cfg, fmt.Errorf("invalid CASSANDRA_TIMEOUT %q", v)
+		}
+		cfg.Timeout = d
+	}
+	if v := os.Getenv("CASSANDRA_RETRIES"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return cfg, fmt.Errorf("invalid CASSANDRA_RETRIES %q", v)
+		}
+		cfg.Retries = n
+	}
+	if cfg.HostPolicy != HostTokenAware && cfg.HostPolicy != HostRoundRobin {
+		return cfg, fmt.Errorf("invalid CASSANDRA_HOST_POLICY %q (want %s or %s)", cfg.HostPolicy, HostTokenAware, HostRoundRobin)
+	}
+	for key, p := range map[string]*time.Duration{
+		"CASSANDRA_CONNECT_TIMEOUT":    &cfg.ConnectTimeout,
+		"CASSANDRA_RECONNECT_INTERVAL": &cfg.ReconnectInterval,
+	} {
+		if v := os.Getenv(key); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil || d <= 0 {
+				return cfg, fmt.Errorf("invalid %s %q", key, v)
+			}
+			*p = d
+		}
+	}
+	switch cfg.RetryPolicy {
+	case RetryExponential:
+	case RetryDowngrading:
+		// One level down by default: a quorum read still answers with a
+		// replica short, from whichever replica has it
+		levels := getEnv("CASSANDRA_DOWNGRADE_CONSISTENCY", "LOCAL_ONE")
+		for _, l := range strings.Split(levels, ",") {
+			var c gocql.Consistency
+			if err := c.UnmarshalText([]byte(strings.ToUpper(strings.TrimSpace(l)))); err != nil {
+				return cfg, fmt.Errorf("invalid CASSANDRA_DOWNGRADE_CONSISTENCY %q", levels)
+			}
+			cfg.DowngradeTo = append(cfg.DowngradeTo, c)
+		}
+	default:
+		return cfg, fmt.Errorf("invalid CASSANDRA_RETRY_POLICY %q (want %s or %s)", cfg.RetryPolicy, RetryExponential, RetryDowngrading)
+	}
+	return cfg, nil
+}
+
+// Session is a connected cluster session shared by the repositories
+type Session struct {
+	s *gocql.Session
+}
+
+// Connect opens a session with cfg
+func Connect(cfg Config) (*Session, error) {
+	cluster := gocql.NewCluster(cfg.Hosts...)
+	cluster.Keyspace = cfg.Keyspace
+	cluster.Consistency = cfg.Consistency
+	cluster.Timeout = cfg.Timeout
+	if cfg.ConnectTimeout > 0 {
+		cluster.ConnectTimeout = cfg.ConnectTimeout
+	}
+	if cfg.ReconnectInterval > 0 {
+		cluster.ReconnectInterval = cfg.ReconnectInterval
+	}
+	// A host whose connections fail is marked down after three redials over
+	// about 1.5s (gocql's default is 3s), and its queries go to other nodes
+	cluster.ReconnectionPolicy = &gocql.ExponentialReconnectionPolicy{
+		MaxRetries:      3,
+		InitialInterval: 200 * time.Millisecond,
+		MaxInterval:     2 * time.Second,
+	}
+
+	// Coordinators in the local DC only with LOCAL_DC; LWTs then take a
+	// Paxos round among local replicas
+	hosts := gocql.RoundRobinHostPolicy()
+	if cfg.LocalDC != "" {
+		hosts = gocql.DCAwareRoundRobinPolicy(cfg.LocalDC)
+		cluster.SerialConsistency = gocql.LocalSerial
+	}
+	if cfg.HostPolicy != HostRoundRobin {
+		// Skips a coordinator hop; replicas that are down are passed over
+		// for the next one
+		if cfg.ShuffleReplicas {
+			hosts = gocql.TokenAwareHostPolicy(hosts, gocql.ShuffleReplicas())
+		} else {
+			hosts = gocql.TokenAwareHostPolicy(hosts)
+		}
+	}
+	cluster.PoolConfig.HostSelectionPolicy = hosts
+
+	if cfg.RetryPolicy == RetryDowngrading {
+		// Counter increments run without a retry policy, so they are
+		// never downgraded either
+		cluster.RetryPolicy = &gocql.DowngradingConsistencyRetryPolicy{ConsistencyLevelsToTry: cfg.DowngradeTo}
+	} else {
+		cluster.RetryPolicy = &gocql.ExponentialBackoffRetryPolicy{
+			NumRetries: cfg.Retries,
+			Min:        100 * time.Millisecond,
+			Max:        2 * time.Second,
+		}
+	}
+
+	s, err := cluster.CreateSession()
+	if err != nil {
+		return nil, err
+	}
+	return &Session{s: s}, nil
+}
+
+// ConnectFromEnv is Connect(ConfigFromEnv())
+func ConnectFromEnv() (*Session, error) {
+	cfg, err := ConfigFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	return Connect(cfg)
+}
+
+func (s *Session) Close() { s.s.Close() }
+
+// Gocql exposes the underlying session for statements no repository
+// covers yet (schema tooling, ad-hoc scripts)
+func (s *Session) Gocql() *gocql.Session { return s.s }
+
+func getEnv(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
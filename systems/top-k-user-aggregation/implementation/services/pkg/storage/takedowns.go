@@ -0,0 +1,204 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/system-design-lab/pkg/chaos"
+)
+
+// Takedown is a song_takedowns row: a song removed from every aggregate
+type Takedown struct {
+	SongID      string
+	Reason      string
+	RequestedAt time.Time
+	PurgedAt    time.Time // zero until the counters are deleted
+	RowsDeleted int64
+}
+
+// TakedownRepo reads and writes song_takedowns
+type TakedownRepo struct {
+	s *Session
+}
+
+func NewTakedownRepo(s *Session) *TakedownRepo {
+	return &TakedownRepo{s: s}
+}
+
+// Add records a takedown. Taking a song down again replaces the row and
+// clears its purge, so the counters are purged again.
+func (r *TakedownRepo) Add(ctx context.Context, t Takedown) (err error) {
+	defer observe("song_takedowns.add", time.Now(), &err)
+	if drop, err := injectWrite(ctx); drop || err != nil {
+		return err
+	}
+
+	return r.s.s.Query(`
+		INSERT INTO song_takedowns (song_id, reason, requested_at, purged_at, rows_deleted)
+		VALUES (?, ?, ?, null, null)
+	`, t.SongID, t.Reason, t.RequestedAt).WithContext(ctx).Idempotent(true).Exec()
+}
+
+// MarkPurged records that a song's counters are deleted
+func (r *TakedownRepo) MarkPurged(ctx context.Context, songID string, rows int64) (err error) {
+	defer observe("song_takedowns.mark_purged", time.Now(), &err)
+	if drop, err := injectWrite(ctx); drop || err != nil {
+		return err
+	}
+
+	return r.s.s.Query(`
+		UPDATE song_takedowns SET purged_at = ?, rows_deleted = ?
+		WHERE song_id = ?
+	`, time.Now(), rows, songID).WithContext(ctx).Idempotent(true).Exec()
+}
+
+// List returns every takedown. The table only holds taken-down songs, so
+// this is one small read.
+func (r *TakedownRepo) List(ctx context.Context) (out []Takedown, err error) {
+	defer observe("song_takedowns.list", time.Now(), &err)
+	if err := chaos.Inject(ctx, chaos.CassandraRead); err != nil {
+		return nil, err
+	}
+
+	iter := r.s.s.Query(`
+		SELECT song_id, reason, requested_at, purged_at, rows_deleted FROM song_takedowns
+	`).WithContext(ctx).Idempotent(true).Iter()
+
+	var t Takedown
+	for iter.Scan(&t.SongID, &t.Reason, &t.RequestedAt, &t.PurgedAt, &t.RowsDeleted) {
+		out = append(out, t)
+		t = Takedown{}
+	}
+	if err := iter.Close(); err != nil {
+		return nil, fmt.Errorf("query takedowns: %w", err)
+	}
+	return out, nil
+}
+
+// TakedownRefresh is how often a TakedownSet reloads song_takedowns: how
+// long a new takedown can take to reach every service
+const TakedownRefresh = 30 * time.Second
+
+// TakedownSet is a service's copy of the taken-down songs, reloaded every
+// TakedownRefresh by Start. A set without a repo stays empty, except for
+// what Add puts in it.
+type TakedownSet struct {
+	repo *TakedownRepo
+
+	songs   atomic.Pointer[map[string]bool]
+	version atomic.Int64
+
+	mu    sync.Mutex
+	added map[string]bool // by Add, kept across reloads until listed
+}
+
+func NewTakedownSet(repo *TakedownRepo) *TakedownSet {
+	s := &TakedownSet{repo: repo, added: make(map[string]bool)}
+	s.songs.Store(&map[string]bool{})
+	return s
+}
+
+// Start loads the set and keeps reloading it until ctx is done. A failed
+// first load is returned; the set is then empty until a reload succeeds.
+func (s *TakedownSet) Start(ctx context.Context) error {
+	if s.repo == nil {
+		return nil
+	}
+	err := s.reload(ctx)
+	go func() {
+		ticker := time.NewTicker(TakedownRefresh)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			if err := s.reload(ctx); err != nil {
+				log.Printf("Error reloading song takedowns, keeping %d: %v", len(*s.songs.Load()), err)
+			}
+		}
+	}()
+	return err
+}
+
+// reload keeps the previous set when the read fails
+func (s *TakedownSet) reload(ctx context.Context) error {
+	list, err := s.repo.List(ctx)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	songs := make(map[string]bool, len(list)+len(s.added))
+	var version int64
+	for _, t := range list {
+		songs[t.SongID] = true
+		delete(s.added, t.SongID)
+		if v := t.RequestedAt.UnixMilli(); v > version {
+			version = v
+		}
+	}
+	for song := range s.added {
+		songs[song] = true
+	}
+	s.songs.Store(&songs)
+	s.version.Store(version)
+	return nil
+}
+
+// Has reports whether a song is taken down
+func (s *TakedownSet) Has(songID string) bool {
+	return (*s.songs.Load())[songID]
+}
+
+// Len is the number of taken-down songs
+func (s *TakedownSet) Len() int {
+	return len(*s.songs.Load())
+}
+
+// Version changes whenever a song is taken down: the newest takedown's
+// requested_at in unix milliseconds, 0 for none. Caches of results that
+// could hold a song key on it, so a takedown invalidates them.
+func (s *TakedownSet) Version() int64 {
+	return s.version.Load()
+}
+
+// Add takes a song down in this set right away, ahead of the next reload,
+// as when a service learns of it from song.takedown
+func (s *TakedownSet) Add(songID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.added[songID] = true
+	cur := *s.songs.Load()
+	if cur[songID] {
+		return
+	}
+	songs := make(map[string]bool, len(cur)+1)
+	for song := range cur {
+		songs[song] = true
+	}
+	songs[songID] = true
+	s.songs.Store(&songs)
+}
+
+// DropTakedowns deletes the taken-down songs from counts and returns how
+// many it dropped
+func DropTakedowns[V any](s *TakedownSet, counts map[string]V) int {
+	songs := *s.songs.Load()
+	if len(songs) == 0 {
+		return 0
+	}
+	dropped := 0
+	for song := range counts {
+		if songs[song] {
+			delete(counts, song)
+			dropped++
+		}
+	}
+	return dropped
+}
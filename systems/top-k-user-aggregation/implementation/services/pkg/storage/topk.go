@@ -0,0 +1,332 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/gocql/gocql"
+	"github.com/system-design-lab/pkg/chaos"
+)
+
+// DailyTopKRepo reads and writes the user_daily_topk counter table. Days of
+// users in topk_buckets are in user_daily_topk_bucketed instead; every method
+// routes by the cached layouts, so callers don't see the difference.
+type DailyTopKRepo struct {
+	s       *Session
+	buckets *bucketCache
+}
+
+func NewDailyTopKRepo(s *Session) *DailyTopKRepo {
+	return &DailyTopKRepo{s: s, buckets: &bucketCache{repo: NewBucketRepo(s)}}
+}
+
+// update adds delta to one counter column of a song's row for a user and day
+func (r *DailyTopKRepo) update(ctx context.Context, column, userID, day, songID string, delta int64) *gocql.Query {
+	if n := r.buckets.count(ctx, userID, day); n > 0 {
+		return r.s.s.Query(`
+			UPDATE user_daily_topk_bucketed
+			SET `+column+` = `+column+` + ?
+			WHERE user_id = ? AND day = ? AND bucket = ? AND song_id = ?
+		`, delta, userID, day, SongBucket(songID, n), songID)
+	}
+	return r.s.s.Query(`
+		UPDATE user_daily_topk
+		SET `+column+` = `+column+` + ?
+		WHERE user_id = ? AND day = ? AND song_id = ?
+	`, delta, userID, day, songID)
+}
+
+// selectDay reads columns of every song row of a user's day, across its
+// buckets when it has them
+func (r *DailyTopKRepo) selectDay(ctx context.Context, columns, userID, day string) *gocql.Query {
+	if n := r.buckets.count(ctx, userID, day); n > 0 {
+		return r.s.s.Query(`
+			SELECT `+columns+`
+			FROM user_daily_topk_bucketed
+			WHERE user_id = ? AND day = ? AND bucket IN ?
+		`, userID, day, bucketList(n))
+	}
+	return r.s.s.Query(`
+		SELECT `+columns+`
+		FROM user_daily_topk
+		WHERE user_id = ? AND day = ?
+	`, userID, day)
+}
+
+// Bucketed reports whether a user's day is in user_daily_topk_bucketed
+func (r *DailyTopKRepo) Bucketed(ctx context.Context, userID, day string) bool {
+	return r.buckets.count(ctx, userID, day) > 0
+}
+
+// Increment adds delta to a song's count for a user and day. Counter updates
+// aren't idempotent, so they're never retried here: a retry after a timeout
+// could apply twice. Callers decide whether to re-send.
+func (r *DailyTopKRepo) Increment(ctx context.Context, userID, day, songID string, delta int64) (err error) {
+	defer observe("user_daily_topk.increment", time.Now(), &err)
+	if drop, err := injectWrite(ctx); drop || err != nil {
+		return err
+	}
+
+	return r.update(ctx, "listen_count", userID, day, songID, delta).WithContext(ctx).RetryPolicy(nil).Exec()
+}
+
+// IncrementTime adds ms to a song's listening time for a user and day. Like
+// Increment it is never retried here.
+func (r *DailyTopKRepo) IncrementTime(ctx context.Context, userID, day, songID string, ms int64) (err error) {
+	defer observe("user_daily_topk.increment_time", time.Now(), &err)
+	if drop, err := injectWrite(ctx); drop || err != nil {
+		return err
+	}
+
+	return r.update(ctx, "listen_ms", userID, day, songID, ms).WithContext(ctx).RetryPolicy(nil).Exec()
+}
+
+// DeleteSong removes a song's row from a user's day (see tools takedown).
+// A deleted counter must not be incremented again: Cassandra may lose the
+// increments, so writers drop the song first.
+func (r *DailyTopKRepo) DeleteSong(ctx context.Context, userID, day, songID string) (err error) {
+	defer observe("user_daily_topk.delete_song", time.Now(), &err)
+	if drop, err := injectWrite(ctx); drop || err != nil {
+		return err
+	}
+
+	q := r.s.s.Query(`
+		DELETE FROM user_daily_topk
+		WHERE user_id = ? AND day = ? AND song_id = ?
+	`, userID, day, songID)
+	if n := r.buckets.count(ctx, userID, day); n > 0 {
+		q = r.s.s.Query(`
+			DELETE FROM user_daily_topk_bucketed
+			WHERE user_id = ? AND day = ? AND bucket = ? AND song_id = ?
+		`, userID, day, SongBucket(songID, n), songID)
+	}
+	return q.WithContext(ctx).Idempotent(true).Exec()
+}
+
+// DayCounts returns song -> count for one user and day
+func (r *DailyTopKRepo) DayCounts(ctx context.Context, userID, day string) (counts map[string]int64, err error) {
+	defer observe("user_daily_topk.day_counts", time.Now(), &err)
+	if err := chaos.Inject(ctx, chaos.CassandraRead); err != nil {
+		return nil, err
+	}
+
+	iter := r.selectDay(ctx, "song_id, listen_count", userID, day).WithContext(ctx).Idempotent(true).Iter()
+
+	counts = make(map[string]int64)
+	var songID string
+	var count int64
+	for iter.Scan(&songID, &count) {
+		counts[songID] += count
+	}
+	if err := iter.Close(); err != nil {
+		return nil, fmt.Errorf("query error for day %s: %w", day, err)
+	}
+	return counts, nil
+}
+
+// DayCountsOf is DayCounts restricted to songIDs, read by clustering key
+// (IN queries of 100, per bucket on a bucketed day) instead of the whole
+// partition. Songs the user didn't play that day are left out.
+func (r *DailyTopKRepo) DayCountsOf(ctx context.Context, userID, day string, songIDs []string) (counts map[string]int64, err error) {
+	defer observe("user_daily_topk.day_counts_of", time.Now(), &err)
+	if err := chaos.Inject(ctx, chaos.CassandraRead); err != nil {
+		return nil, err
+	}
+
+	// Bucket -> its songs; a day that isn't bucketed is bucket -1, the table
+	// without buckets
+	byBucket := make(map[int][]string)
+	if n := r.buckets.count(ctx, userID, day); n > 0 {
+		for _, id := range songIDs {
+			b := SongBucket(id, n)
+			byBucket[b] = append(byBucket[b], id)
+		}
+	} else {
+		byBucket[-1] = songIDs
+	}
+
+	counts = make(map[string]int64)
+	for bucket, ids := range byBucket {
+		for start := 0; start < len(ids); start += metadataChunk {
+			end := start + metadataChunk
+			if end > len(ids) {
+				end = len(ids)
+			}
+			q := r.s.s.Query(`
+				SELECT song_id, listen_count
+				FROM user_daily_topk
+				WHERE user_id = ? AND day = ? AND song_id IN ?
+			`, userID, day, ids[start:end])
+			if bucket >= 0 {
+				q = r.s.s.Query(`
+					SELECT song_id, listen_count
+					FROM user_daily_topk_bucketed
+					WHERE user_id = ? AND day = ? AND bucket = ? AND song_id IN ?
+				`, userID, day, bucket, ids[start:end])
+			}
+			iter := q.WithContext(ctx).Idempotent(true).Iter()
+			var songID string
+			var count int64
+			for iter.Scan(&songID, &count) {
+				counts[songID] += count
+			}
+			if err := iter.Close(); err != nil {
+				return nil, fmt.Errorf("query error for day %s: %w", day, err)
+			}
+		}
+	}
+	return counts, nil
+}
+
+// SumCountsOf adds up DayCountsOf over several days
+func (r *DailyTopKRepo) SumCountsOf(ctx context.Context, userID string, days, songIDs []string) (map[string]int64, error) {
+	total := make(map[string]int64)
+	for _, day := range days {
+		counts, err := r.DayCountsOf(ctx, userID, day, songIDs)
+		if err != nil {
+			return nil, err
+		}
+		for song, c := range counts {
+			total[song] += c
+		}
+	}
+	return total, nil
+}
+
+// SongTotals is a song's listen count and listening time. Millis only covers
+// listens whose events carried a duration_ms.
+type SongTotals struct {
+	Count  int64
+	Millis int64
+}
+
+// DayTotals is DayCounts with listening time
+func (r *DailyTopKRepo) DayTotals(ctx context.Context, userID, day string) (totals map[string]SongTotals, err error) {
+	defer observe("user_daily_topk.day_totals", time.Now(), &err)
+	if err := chaos.Inject(ctx, chaos.CassandraRead); err != nil {
+		return nil, err
+	}
+
+	iter := r.selectDay(ctx, "song_id, listen_count, listen_ms", userID, day).WithContext(ctx).Idempotent(true).Iter()
+
+	totals = make(map[string]SongTotals)
+	var songID string
+	var count, ms int64
+	for iter.Scan(&songID, &count, &ms) {
+		t := totals[songID]
+		t.Count += count
+		t.Millis += ms
+		totals[songID] = t
+	}
+	if err := iter.Close(); err != nil {
+		return nil, fmt.Errorf("query error for day %s: %w", day, err)
+	}
+	return totals, nil
+}
+
+// SumTotals adds up DayTotals over several days
+func (r *DailyTopKRepo) SumTotals(ctx context.Context, userID string, days []string) (map[string]SongTotals, error) {
+	total := make(map[string]SongTotals)
+	for _, day := range days {
+		totals, err := r.DayTotals(ctx, userID, day)
+		if err != nil {
+			return nil, err
+		}
+		mergeTotals(total, totals)
+	}
+	return total, nil
+}
+
+func mergeTotals(into, from map[string]SongTotals) {
+	for song, t := range from {
+		sum := into[song]
+		sum.Count += t.Count
+		sum.Millis += t.Millis
+		into[song] = sum
+	}
+}
+
+// CounterRow is one user_daily_topk row
+type CounterRow struct {
+	UserID string
+	Day    string // DayFormat
+	SongID string
+	Count  int64
+	// Bucketed rows come from user_daily_topk_bucketed, where a day is
+	// split over several partitions
+	Bucketed bool
+}
+
+// Scan calls fn for every row of user_daily_topk, then of
+// user_daily_topk_bucketed, paging in token order so rows of one partition
+// arrive together. A bucketed day is several partitions, so its rows arrive
+// in one group per bucket. Each user-day comes from the table DayCounts
+// reads, so a day is never emitted twice: bucketed rows outside a layout
+// are a dual-write copy (ShadowTopKRepo), and plain rows of a day with one
+// are what "buckets enable -dual-written" moved, and both are skipped. It
+// reads both tables whole: meant for backups and other offline jobs, not
+// the request path.
+func (r *DailyTopKRepo) Scan(ctx context.Context, fn func(CounterRow) error) (err error) {
+	defer observe("user_daily_topk.scan", time.Now(), &err)
+	if err := chaos.Inject(ctx, chaos.CassandraRead); err != nil {
+		return err
+	}
+
+	if err := r.scan(ctx, "user_daily_topk", false, fn); err != nil {
+		return err
+	}
+	return r.scan(ctx, "user_daily_topk_bucketed", true, fn)
+}
+
+func (r *DailyTopKRepo) scan(ctx context.Context, table string, bucketed bool, fn func(CounterRow) error) error {
+	iter := r.s.s.Query(`
+		SELECT user_id, day, song_id, listen_count
+		FROM ` + table).WithContext(ctx).Idempotent(true).PageSize(5000).Iter()
+
+	var (
+		row = CounterRow{Bucketed: bucketed}
+		day time.Time
+	)
+	for iter.Scan(&row.UserID, &day, &row.SongID, &row.Count) {
+		row.Day = day.Format(DayFormat)
+		if (r.buckets.count(ctx, row.UserID, row.Day) > 0) != bucketed {
+			continue
+		}
+		if err := fn(row); err != nil {
+			iter.Close()
+			return err
+		}
+	}
+	return iter.Close()
+}
+
+// SumCounts adds up DayCounts over several days
+func (r *DailyTopKRepo) SumCounts(ctx context.Context, userID string, days []string) (map[string]int64, error) {
+	total := make(map[string]int64)
+	for _, day := range days {
+		counts, err := r.DayCounts(ctx, userID, day)
+		if err != nil {
+			return nil, err
+		}
+		for song, c := range counts {
+			total[song] += c
+		}
+	}
+	return total, nil
+}
+
+// LastDays returns the n day keys ending today (UTC), newest first
+func LastDays(n int) []string {
+	return DaysEnding(time.Now(), n)
+}
+
+// DaysEnding returns the n day keys ending on last's day (UTC), newest first
+func DaysEnding(last time.Time, n int) []string {
+	end := last.UTC().Truncate(24 * time.Hour)
+	days := make([]string, n)
+	for i := range days {
+		days[i] = end.AddDate(0, 0, -i).Format(DayFormat)
+	}
+	return days
+}
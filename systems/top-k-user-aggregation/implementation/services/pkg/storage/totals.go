@@ -0,0 +1,59 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/system-design-lab/pkg/chaos"
+)
+
+// UserTotalsRepo reads and writes user_daily_totals, a user's listens per
+// day across all songs
+type UserTotalsRepo struct {
+	s *Session
+}
+
+func NewUserTotalsRepo(s *Session) *UserTotalsRepo {
+	return &UserTotalsRepo{s: s}
+}
+
+// Increment adds delta to a user's total for a day. Like
+// DailyTopKRepo.Increment it is never retried here.
+func (r *UserTotalsRepo) Increment(ctx context.Context, userID, day string, delta int64) (err error) {
+	defer observe("user_daily_totals.increment", time.Now(), &err)
+	if drop, err := injectWrite(ctx); drop || err != nil {
+		return err
+	}
+
+	return r.s.s.Query(`
+		UPDATE user_daily_totals
+		SET listen_count = listen_count + ?
+		WHERE user_id = ? AND day = ?
+	`, delta, userID, day).WithContext(ctx).RetryPolicy(nil).Exec()
+}
+
+// Range returns day -> total of a user from one day to another (DayFormat,
+// inclusive), one slice of its partition. Days without listens are absent.
+func (r *UserTotalsRepo) Range(ctx context.Context, userID, from, to string) (totals map[string]int64, err error) {
+	defer observe("user_daily_totals.range", time.Now(), &err)
+	if err := chaos.Inject(ctx, chaos.CassandraRead); err != nil {
+		return nil, err
+	}
+
+	iter := r.s.s.Query(`
+		SELECT day, listen_count FROM user_daily_totals
+		WHERE user_id = ? AND day >= ? AND day <= ?
+	`, userID, from, to).WithContext(ctx).Idempotent(true).Iter()
+
+	totals = make(map[string]int64)
+	var day string
+	var n int64
+	for iter.Scan(&day, &n) {
+		totals[day] = n
+	}
+	if err := iter.Close(); err != nil {
+		return nil, fmt.Errorf("query totals: %w", err)
+	}
+	return totals, nil
+}
@@ -0,0 +1,101 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/gocql/gocql"
+	"github.com/system-design-lab/pkg/chaos"
+)
+
+// YearReview is a user's year-in-review report, compiled from the daily
+// counters by tools year-review and stored as a JSON document
+type YearReview struct {
+	UserID     string    `json:"user_id"`
+	Year       int       `json:"year"`
+	ComputedAt time.Time `json:"computed_at"`
+	// Through is the last day counted: Dec 31, or the day the report was
+	// compiled for the current year
+	Through string `json:"through"`
+
+	Listens      int64  `json:"listens"`
+	DaysListened int    `json:"days_listened"`
+	TopDay       DayTop `json:"top_day"`
+	// Minutes listened, from song_metadata durations; omitted when no
+	// listened song has one. MinutesCoverage is the share of listens whose
+	// song has a duration, so a partial catalog reads as a lower bound.
+	Minutes         *int64  `json:"minutes,omitempty"`
+	MinutesCoverage float64 `json:"minutes_coverage,omitempty"`
+
+	TopSongs      []SongCount   `json:"top_songs"`
+	TopArtists    []ArtistCount `json:"top_artists"`
+	LongestStreak Streak        `json:"longest_streak"`
+}
+
+// ArtistCount is one ranked artist of a report
+type ArtistCount struct {
+	ArtistID string `json:"artist_id"`
+	Count    int64  `json:"listen_count"`
+}
+
+// DayTop is the day with the most listens
+type DayTop struct {
+	Day     string `json:"day,omitempty"`
+	Listens int64  `json:"listens"`
+}
+
+// Streak is a run of consecutive days with at least one listen
+type Streak struct {
+	Days int    `json:"days"`
+	From string `json:"from,omitempty"`
+	To   string `json:"to,omitempty"`
+}
+
+// YearReviewRepo reads and writes user_year_review
+type YearReviewRepo struct {
+	s *Session
+}
+
+func NewYearReviewRepo(s *Session) *YearReviewRepo {
+	return &YearReviewRepo{s: s}
+}
+
+// Put stores a report, replacing the user's previous one for the year
+func (r *YearReviewRepo) Put(ctx context.Context, rev YearReview) (err error) {
+	defer observe("user_year_review.put", time.Now(), &err)
+	if drop, err := injectWrite(ctx); drop || err != nil {
+		return err
+	}
+
+	doc, err := json.Marshal(rev)
+	if err != nil {
+		return fmt.Errorf("encode report: %w", err)
+	}
+	return r.s.s.Query(`
+		INSERT INTO user_year_review (user_id, year, report, computed_at)
+		VALUES (?, ?, ?, ?)
+	`, rev.UserID, rev.Year, string(doc), rev.ComputedAt).WithContext(ctx).Idempotent(true).Exec()
+}
+
+// Get returns a user's report for a year as stored; ok is false if none was
+// compiled
+func (r *YearReviewRepo) Get(ctx context.Context, userID string, year int) (doc []byte, ok bool, err error) {
+	defer observe("user_year_review.get", time.Now(), &err)
+	if err := chaos.Inject(ctx, chaos.CassandraRead); err != nil {
+		return nil, false, err
+	}
+
+	var report string
+	err = r.s.s.Query(`
+		SELECT report FROM user_year_review WHERE user_id = ? AND year = ?
+	`, userID, year).WithContext(ctx).Idempotent(true).Scan(&report)
+	if err == gocql.ErrNotFound {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return []byte(report), true, nil
+}
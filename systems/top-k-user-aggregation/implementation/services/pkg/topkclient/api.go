@@ -0,0 +1,308 @@
+package topkclient
+
+import (
+	"context"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// TopKResult is one ranked song of a TopKResponse
+type TopKResult struct {
+	SongID      string  `json:"song_id"`
+	ListenCount int64   `json:"listen_count"`
+	ListenMs    int64   `json:"listen_ms,omitempty"` // RankBy time only
+	Score       float64 `json:"score,omitempty"`     // RankBy recent only
+	Rank        int     `json:"rank"`
+}
+
+// TopKResponse is a user's top songs over a window, or one page of them
+type TopKResponse struct {
+	UserID     string       `json:"user_id"`
+	Days       int          `json:"days,omitempty"`
+	Hours      int          `json:"hours,omitempty"`
+	K          int          `json:"k"`
+	RankBy     string       `json:"rank_by"`
+	Results    []TopKResult `json:"results"`
+	Experiment string       `json:"experiment,omitempty"`
+	AsOf       string       `json:"as_of,omitempty"`
+	HalfLife   int          `json:"half_life,omitempty"`
+	// Pages only: the ranked list's length, and the next page's cursor
+	// unless this is the last
+	Total      int    `json:"total,omitempty"`
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// TopKQuery selects a /users/{id}/topk window; zero fields take the
+// server's defaults (7 days, k=10, ranked by count)
+type TopKQuery struct {
+	Days       int
+	Hours      int // sliding window instead of Days
+	K          int
+	RankBy     string // "count", "time" or "recent"
+	HalfLife   int    // days, RankBy recent only
+	Experiment string
+	AsOf       string // last day of the Days window, YYYY-MM-DD
+}
+
+func (q TopKQuery) values() url.Values {
+	v := url.Values{}
+	setInt(v, "days", q.Days)
+	setInt(v, "hours", q.Hours)
+	setInt(v, "k", q.K)
+	setString(v, "rank_by", q.RankBy)
+	setInt(v, "half_life", q.HalfLife)
+	setString(v, "experiment", q.Experiment)
+	setString(v, "as_of", q.AsOf)
+	return v
+}
+
+// TopK returns userID's top songs for q
+func (c *Client) TopK(ctx context.Context, userID string, q TopKQuery) (*TopKResponse, error) {
+	var resp TopKResponse
+	if err := c.get(ctx, userPath(userID, "topk"), q.values(), true, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// TopKPage returns the page of pageSize songs of userID's days window that
+// cursor points at ("" for the first). Pages are ranked by count and only
+// exist for materialized windows: 404 without a ranked list from today, 410
+// once the cursor's list expired.
+func (c *Client) TopKPage(ctx context.Context, userID string, days, pageSize int, cursor string) (*TopKResponse, error) {
+	v := url.Values{"cursor": {cursor}}
+	setInt(v, "days", days)
+	setInt(v, "k", pageSize)
+	var resp TopKResponse
+	// Cursors name one page each: nothing to revalidate
+	if err := c.get(ctx, userPath(userID, "topk"), v, false, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// ArtistResult is one ranked artist of an ArtistTopKResponse
+type ArtistResult struct {
+	ArtistID    string `json:"artist_id"`
+	ListenCount int64  `json:"listen_count"`
+	Rank        int    `json:"rank"`
+}
+
+// ArtistTopKResponse is a user's top artists over a days window
+type ArtistTopKResponse struct {
+	UserID  string         `json:"user_id"`
+	Days    int            `json:"days"`
+	K       int            `json:"k"`
+	Results []ArtistResult `json:"results"`
+}
+
+// ArtistTopK returns userID's top k artists over days (0 = server default)
+func (c *Client) ArtistTopK(ctx context.Context, userID string, days, k int) (*ArtistTopKResponse, error) {
+	var resp ArtistTopKResponse
+	if err := c.get(ctx, userPath(userID, "topk/artists"), daysK(days, k), true, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Tag kinds of TagTopK
+const (
+	Genres = "genres"
+	Moods  = "moods"
+)
+
+// TagResult is one ranked genre or mood of a TagTopKResponse
+type TagResult struct {
+	Tag         string `json:"tag"`
+	ListenCount int64  `json:"listen_count"`
+	Rank        int    `json:"rank"`
+}
+
+// TagTopKResponse is a user's top genres or moods over a days window
+type TagTopKResponse struct {
+	UserID  string      `json:"user_id"`
+	Kind    string      `json:"kind"` // genre or mood
+	Days    int         `json:"days"`
+	K       int         `json:"k"`
+	Results []TagResult `json:"results"`
+}
+
+// TagTopK returns userID's top k Genres or Moods over days
+func (c *Client) TagTopK(ctx context.Context, userID, kind string, days, k int) (*TagTopKResponse, error) {
+	var resp TagTopKResponse
+	if err := c.get(ctx, userPath(userID, "topk/"+kind), daysK(days, k), true, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Streak is a run of consecutive days with listens
+type Streak struct {
+	Days int    `json:"days"`
+	From string `json:"from,omitempty"`
+	To   string `json:"to,omitempty"`
+}
+
+// DayListens is one day's listens
+type DayListens struct {
+	Day     string `json:"day"`
+	Listens int64  `json:"listens"`
+}
+
+// Activity is a user's listens per day, oldest first, with their streaks
+type Activity struct {
+	UserID        string       `json:"user_id"`
+	Days          int          `json:"days"`
+	Listens       int64        `json:"listens"`
+	ActiveDays    int          `json:"active_days"`
+	CurrentStreak int          `json:"current_streak"`
+	LongestStreak Streak       `json:"longest_streak"`
+	Daily         []DayListens `json:"daily"`
+}
+
+// Activity returns userID's last days of listens (0 = server default, 90)
+func (c *Client) Activity(ctx context.Context, userID string, days int) (*Activity, error) {
+	v := url.Values{}
+	setInt(v, "days", days)
+	var resp Activity
+	if err := c.get(ctx, userPath(userID, "activity"), v, true, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// YearReview is a user's year-in-review report
+type YearReview struct {
+	UserID          string        `json:"user_id"`
+	Year            int           `json:"year"`
+	ComputedAt      time.Time     `json:"computed_at"`
+	Through         string        `json:"through"`
+	Listens         int64         `json:"listens"`
+	DaysListened    int           `json:"days_listened"`
+	TopDay          DayListens    `json:"top_day"`
+	Minutes         *int64        `json:"minutes,omitempty"`
+	MinutesCoverage float64       `json:"minutes_coverage,omitempty"`
+	TopSongs        []SongCount   `json:"top_songs"`
+	TopArtists      []ArtistCount `json:"top_artists"`
+	LongestStreak   Streak        `json:"longest_streak"`
+}
+
+// SongCount is one song of a YearReview
+type SongCount struct {
+	SongID      string `json:"song_id"`
+	ListenCount int64  `json:"listen_count"`
+}
+
+// ArtistCount is one artist of a YearReview
+type ArtistCount struct {
+	ArtistID    string `json:"artist_id"`
+	ListenCount int64  `json:"listen_count"`
+}
+
+// YearReview returns userID's report of year (0 = last year); 404 until
+// tools year-review compiled it
+func (c *Client) YearReview(ctx context.Context, userID string, year int) (*YearReview, error) {
+	v := url.Values{}
+	setInt(v, "year", year)
+	var resp YearReview
+	if err := c.get(ctx, userPath(userID, "year-review"), v, true, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// ChartSong is one ranked song of a Chart
+type ChartSong struct {
+	Rank   int    `json:"rank"`
+	SongID string `json:"song_id"`
+	Count  int64  `json:"count"` // count-min sketch estimate
+}
+
+// Chart is the global top songs over a window
+type Chart struct {
+	Window     string      `json:"window"`
+	ComputedAt time.Time   `json:"computed_at"`
+	Events     int64       `json:"events"`
+	Songs      []ChartSong `json:"songs"`
+}
+
+// Chart returns the top n songs of window (1h, 24h or 7d)
+func (c *Client) Chart(ctx context.Context, window string, n int) (*Chart, error) {
+	v := url.Values{}
+	setInt(v, "n", n)
+	var resp Chart
+	if err := c.get(ctx, "/charts/"+url.PathEscape(window), v, true, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// SongDay is one day of SongStats, newest first
+type SongDay struct {
+	Day             string `json:"day"`
+	Listens         int64  `json:"listens"`
+	UniqueListeners int64  `json:"unique_listeners"` // the day's estimate
+}
+
+// SongStats is a song's listens and listeners over a days window
+type SongStats struct {
+	SongID  string `json:"song_id"`
+	Days    int    `json:"days"`
+	Listens int64  `json:"listens"`
+	// Across the window; nil above 7 days or when the server's Redis failed
+	UniqueListeners *int64    `json:"unique_listeners,omitempty"`
+	Daily           []SongDay `json:"daily"`
+}
+
+// SongStats returns songID's last days of stats; 410 once taken down
+func (c *Client) SongStats(ctx context.Context, songID string, days int) (*SongStats, error) {
+	v := url.Values{}
+	setInt(v, "days", days)
+	var resp SongStats
+	if err := c.get(ctx, "/songs/"+url.PathEscape(songID)+"/stats", v, true, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// SongTimeseries is a song's listens per day, oldest first
+type SongTimeseries struct {
+	SongID string       `json:"song_id"`
+	Days   int          `json:"days"`
+	Points []DayListens `json:"points"`
+}
+
+// SongTimeseries returns songID's last days of listens
+func (c *Client) SongTimeseries(ctx context.Context, songID string, days int) (*SongTimeseries, error) {
+	v := url.Values{}
+	setInt(v, "days", days)
+	var resp SongTimeseries
+	if err := c.get(ctx, "/songs/"+url.PathEscape(songID)+"/timeseries", v, true, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+func userPath(userID, route string) string {
+	return "/users/" + url.PathEscape(userID) + "/" + route
+}
+
+func daysK(days, k int) url.Values {
+	v := url.Values{}
+	setInt(v, "days", days)
+	setInt(v, "k", k)
+	return v
+}
+
+func setInt(v url.Values, key string, n int) {
+	if n != 0 {
+		v.Set(key, strconv.Itoa(n))
+	}
+}
+
+func setString(v url.Values, key, s string) {
+	if s != "" {
+		v.Set(key, s)
+	}
+}
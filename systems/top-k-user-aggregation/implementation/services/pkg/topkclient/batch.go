@@ -0,0 +1,69 @@
+package topkclient
+
+import (
+	"context"
+	"sync"
+)
+
+// BatchResult is one ID's outcome of a Batch
+type BatchResult[T any] struct {
+	ID    string
+	Value T
+	Err   error
+}
+
+// Batch calls call for every ID, at most c's Concurrency at once, and
+// returns the results in the order of ids. The api-server charges each
+// client IP's reads against its read budget, so a wider fan-out mostly
+// earns 429s (retried after their Retry-After) rather than speed.
+func Batch[T any](ctx context.Context, c *Client, ids []string, call func(ctx context.Context, id string) (T, error)) []BatchResult[T] {
+	results := make([]BatchResult[T], len(ids))
+	sem := make(chan struct{}, c.cfg.Concurrency)
+	var wg sync.WaitGroup
+	for i, id := range ids {
+		results[i].ID = id
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+		}
+		// Both may be ready, and select picks either
+		if err := ctx.Err(); err != nil {
+			results[i].Err = err
+			continue
+		}
+		wg.Add(1)
+		go func(r *BatchResult[T]) {
+			defer func() { <-sem; wg.Done() }()
+			r.Value, r.Err = call(ctx, r.ID)
+		}(&results[i])
+	}
+	wg.Wait()
+	return results
+}
+
+// BatchTopK returns the top songs of every user for q
+func (c *Client) BatchTopK(ctx context.Context, userIDs []string, q TopKQuery) []BatchResult[*TopKResponse] {
+	return Batch(ctx, c, userIDs, func(ctx context.Context, userID string) (*TopKResponse, error) {
+		return c.TopK(ctx, userID, q)
+	})
+}
+
+// AllPages calls fn with every page of userID's ranked days window, pageSize
+// songs each, until the last or fn's first error. A 410 means the list
+// expired midway: start over.
+func (c *Client) AllPages(ctx context.Context, userID string, days, pageSize int, fn func(*TopKResponse) error) error {
+	cursor := ""
+	for {
+		page, err := c.TopKPage(ctx, userID, days, pageSize, cursor)
+		if err != nil {
+			return err
+		}
+		if err := fn(page); err != nil {
+			return err
+		}
+		if page.NextCursor == "" {
+			return nil
+		}
+		cursor = page.NextCursor
+	}
+}
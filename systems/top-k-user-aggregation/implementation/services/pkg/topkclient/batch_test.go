@@ -0,0 +1,177 @@
+package topkclient
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestBatchOrderAndConcurrency(t *testing.T) {
+	c := New(Config{Concurrency: 3})
+	ids := []string{"u0", "u1", "u2", "u3", "u4", "u5", "u6", "u7", "u8", "u9"}
+
+	var inFlight, peak atomic.Int32
+	results := Batch(context.Background(), c, ids, func(ctx context.Context, id string) (string, error) {
+		n := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for p := peak.Load(); n > p && !peak.CompareAndSwap(p, n); p = peak.Load() {
+		}
+		// Early IDs take longest, so calls finish out of order
+		i := int(id[1] - '0')
+		time.Sleep(time.Duration(10-i) * 2 * time.Millisecond)
+		if i == 4 {
+			return "", errors.New("failed u4")
+		}
+		return "top of " + id, nil
+	})
+
+	if got := peak.Load(); got != 3 {
+		t.Errorf("peak calls in flight = %d, want Concurrency (3)", got)
+	}
+	if len(results) != len(ids) {
+		t.Fatalf("%d results for %d IDs", len(results), len(ids))
+	}
+	for i, r := range results {
+		if r.ID != ids[i] {
+			t.Errorf("results[%d].ID = %s, want %s", i, r.ID, ids[i])
+		}
+		switch {
+		case r.ID == "u4":
+			if r.Err == nil || r.Value != "" {
+				t.Errorf("u4 = %q, %v; want its error", r.Value, r.Err)
+			}
+		case r.Err != nil || r.Value != "top of "+r.ID:
+			t.Errorf("%s = %q, %v", r.ID, r.Value, r.Err)
+		}
+	}
+}
+
+func TestBatchCanceled(t *testing.T) {
+	c := New(Config{Concurrency: 1})
+	ctx, cancel := context.WithCancel(context.Background())
+	var calls atomic.Int32
+	results := Batch(ctx, c, []string{"a", "b", "c"}, func(ctx context.Context, id string) (int, error) {
+		calls.Add(1)
+		cancel()
+		return 1, nil
+	})
+	if got := calls.Load(); got != 1 {
+		t.Errorf("%d calls after the first canceled the batch, want 1", got)
+	}
+	if results[0].Err != nil || results[0].Value != 1 {
+		t.Errorf("first result = %+v", results[0])
+	}
+	for _, r := range results[1:] {
+		if !errors.Is(r.Err, context.Canceled) || r.ID == "" {
+			t.Errorf("result not started = %+v, want its ID and context.Canceled", r)
+		}
+	}
+}
+
+func TestBatchTopK(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		user := strings.Split(r.URL.Path, "/")[2]
+		if user == "gone" {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"code": "user_deleted", "error": "user is deleted"}`)
+			return
+		}
+		json.NewEncoder(w).Encode(TopKResponse{UserID: user, Days: 30})
+	})
+	results := c.BatchTopK(context.Background(), []string{"a", "gone", "b"}, TopKQuery{Days: 30})
+	if results[0].Value.UserID != "a" || results[2].Value.UserID != "b" {
+		t.Errorf("results = %+v, %+v", results[0].Value, results[2].Value)
+	}
+	if !IsUserDeleted(results[1].Err) || results[1].Value != nil {
+		t.Errorf("deleted user's result = %+v", results[1])
+	}
+}
+
+// pagesServer serves a ranked list of total songs in pages, the cursor
+// being the offset of the next page
+func pagesServer(t *testing.T, total int, cursors *[]string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		cursor := q.Get("cursor")
+		*cursors = append(*cursors, cursor)
+		if cursor == "expired" {
+			w.WriteHeader(http.StatusGone)
+			fmt.Fprint(w, "cursor expired")
+			return
+		}
+		offset := 0
+		fmt.Sscan(cursor, &offset)
+		size := 0
+		fmt.Sscan(q.Get("k"), &size)
+		page := TopKResponse{UserID: "u1", Days: 30, K: size, Total: total}
+		for i := offset; i < min(offset+size, total); i++ {
+			page.Results = append(page.Results, TopKResult{SongID: fmt.Sprintf("s%d", i), Rank: i + 1})
+		}
+		if offset+size < total {
+			page.NextCursor = fmt.Sprint(offset + size)
+		}
+		json.NewEncoder(w).Encode(page)
+	}
+}
+
+func TestAllPages(t *testing.T) {
+	var cursors []string
+	c := newTestClient(t, pagesServer(t, 25, &cursors))
+
+	var songs []string
+	err := c.AllPages(context.Background(), "u1", 30, 10, func(page *TopKResponse) error {
+		for _, r := range page.Results {
+			songs = append(songs, r.SongID)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("AllPages: %v", err)
+	}
+	// Three pages, the last without a next_cursor, then no more requests
+	if want := []string{"", "10", "20"}; fmt.Sprint(cursors) != fmt.Sprint(want) {
+		t.Errorf("cursors = %q, want %q", cursors, want)
+	}
+	if len(songs) != 25 || songs[0] != "s0" || songs[24] != "s24" {
+		t.Errorf("%d songs, %v", len(songs), songs)
+	}
+}
+
+func TestAllPagesStops(t *testing.T) {
+	var cursors []string
+	c := newTestClient(t, pagesServer(t, 25, &cursors))
+
+	// fn's error ends the walk
+	stop := errors.New("enough")
+	pages := 0
+	err := c.AllPages(context.Background(), "u1", 30, 10, func(*TopKResponse) error {
+		if pages++; pages == 2 {
+			return stop
+		}
+		return nil
+	})
+	if !errors.Is(err, stop) || len(cursors) != 2 {
+		t.Errorf("AllPages = %v after %d requests, want fn's error after 2", err, len(cursors))
+	}
+
+	// A 410 midway comes back as it is, unretried
+	cursors = nil
+	c = newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("cursor") == "" {
+			json.NewEncoder(w).Encode(TopKResponse{NextCursor: "expired"})
+			return
+		}
+		pagesServer(t, 25, &cursors)(w, r)
+	})
+	err = c.AllPages(context.Background(), "u1", 30, 10, func(*TopKResponse) error { return nil })
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusGone || len(cursors) != 1 {
+		t.Errorf("AllPages = %v after %d requests of the expired cursor, want one 410", err, len(cursors))
+	}
+}
@@ -0,0 +1,279 @@
+// Package topkclient is the Go client of the api-server's public API (see
+// api-server/openapi.yaml), for services that read top-K lists instead of
+// Cassandra. Calls time out per attempt, retry what the server marks as
+// transient (429, 5xx, connection errors) with backoff and Retry-After, and
+// revalidate responses already held with If-None-Match, so an unchanged
+// top-K costs the server a cache read and no body.
+package topkclient
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// userAgent names the calling binary, as the api-server's logs see it
+var userAgent = "topkclient/" + filepath.Base(os.Args[0])
+
+// Config holds a client's settings
+type Config struct {
+	BaseURL     string        // api-server, e.g. http://api-server:8080
+	Timeout     time.Duration // per attempt
+	MaxAttempts int           // tries per call, the first included
+	BackoffMin  time.Duration // between attempts, doubling up to BackoffMax
+	BackoffMax  time.Duration
+	ETagEntries int // responses kept to revalidate (0 = none)
+	Concurrency int // calls in flight of one Batch
+	UserAgent   string
+}
+
+// ConfigFromEnv reads TOPK_API_URL (defaultURL when unset),
+// TOPK_API_TIMEOUT, TOPK_API_MAX_ATTEMPTS, TOPK_API_BACKOFF_MIN,
+// TOPK_API_BACKOFF_MAX, TOPK_API_ETAG_ENTRIES and TOPK_API_CONCURRENCY
+func ConfigFromEnv(defaultURL string) (Config, error) {
+	cfg := Config{
+		BaseURL:     defaultURL,
+		Timeout:     5 * time.Second,
+		MaxAttempts: 3,
+		BackoffMin:  100 * time.Millisecond,
+		BackoffMax:  2 * time.Second,
+		ETagEntries: 1000,
+		Concurrency: 4,
+		UserAgent:   userAgent,
+	}
+	if v := os.Getenv("TOPK_API_URL"); v != "" {
+		cfg.BaseURL = v
+	}
+	if _, err := url.ParseRequestURI(cfg.BaseURL); err != nil {
+		return cfg, fmt.Errorf("invalid TOPK_API_URL %q", cfg.BaseURL)
+	}
+	for key, p := range map[string]*int{
+		"TOPK_API_MAX_ATTEMPTS": &cfg.MaxAttempts,
+		"TOPK_API_ETAG_ENTRIES": &cfg.ETagEntries,
+		"TOPK_API_CONCURRENCY":  &cfg.Concurrency,
+	} {
+		if v := os.Getenv(key); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				return cfg, fmt.Errorf("invalid %s %q", key, v)
+			}
+			*p = n
+		}
+	}
+	for key, p := range map[string]*time.Duration{
+		"TOPK_API_TIMEOUT":     &cfg.Timeout,
+		"TOPK_API_BACKOFF_MIN": &cfg.BackoffMin,
+		"TOPK_API_BACKOFF_MAX": &cfg.BackoffMax,
+	} {
+		if v := os.Getenv(key); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil || d <= 0 {
+				return cfg, fmt.Errorf("invalid %s %q", key, v)
+			}
+			*p = d
+		}
+	}
+	if cfg.MaxAttempts < 1 || cfg.Concurrency < 1 {
+		return cfg, fmt.Errorf("TOPK_API_MAX_ATTEMPTS and TOPK_API_CONCURRENCY must be at least 1")
+	}
+	return cfg, nil
+}
+
+func (c Config) String() string {
+	return fmt.Sprintf("%s timeout=%s attempts=%d backoff=%s-%s etags=%d concurrency=%d",
+		c.BaseURL, c.Timeout, c.MaxAttempts, c.BackoffMin, c.BackoffMax, c.ETagEntries, c.Concurrency)
+}
+
+// Client calls the api-server. It is safe for concurrent use.
+type Client struct {
+	cfg   Config
+	http  *http.Client
+	etags *etagCache // nil without ETagEntries
+}
+
+// New returns a client for cfg; zero settings take ConfigFromEnv's defaults
+func New(cfg Config) *Client {
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 5 * time.Second
+	}
+	if cfg.MaxAttempts < 1 {
+		cfg.MaxAttempts = 3
+	}
+	if cfg.BackoffMin <= 0 {
+		cfg.BackoffMin = 100 * time.Millisecond
+	}
+	if cfg.BackoffMax < cfg.BackoffMin {
+		cfg.BackoffMax = max(2*time.Second, cfg.BackoffMin)
+	}
+	if cfg.Concurrency < 1 {
+		cfg.Concurrency = 4
+	}
+	if cfg.UserAgent == "" {
+		cfg.UserAgent = userAgent
+	}
+	cfg.BaseURL = strings.TrimRight(cfg.BaseURL, "/")
+	c := &Client{cfg: cfg, http: &http.Client{}}
+	if cfg.ETagEntries > 0 {
+		c.etags = newETagCache(cfg.ETagEntries)
+	}
+	return c
+}
+
+// APIError is a response other than 200 (or 304 to a revalidation)
+type APIError struct {
+	StatusCode int
+	// Code of the JSON errors: user_deleted (404), client_budget (429),
+	// query_budget (503); empty for plain-text errors
+	Code       string
+	Message    string
+	RetryAfter time.Duration // from Retry-After, 0 without
+}
+
+func (e *APIError) Error() string {
+	if e.Code != "" {
+		return fmt.Sprintf("api-server: %d %s: %s", e.StatusCode, e.Code, e.Message)
+	}
+	return fmt.Sprintf("api-server: %d: %s", e.StatusCode, e.Message)
+}
+
+// Temporary reports whether the call may succeed if retried later
+func (e *APIError) Temporary() bool {
+	switch e.StatusCode {
+	case http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// IsNotFound reports whether err is a 404: an unknown user, route or
+// experiment, a missing report or chart, or a deleted user
+func IsNotFound(err error) bool {
+	var e *APIError
+	return errors.As(err, &e) && e.StatusCode == http.StatusNotFound
+}
+
+// IsUserDeleted reports whether err is the 404 of a soft-deleted user
+func IsUserDeleted(err error) bool {
+	var e *APIError
+	return errors.As(err, &e) && e.Code == "user_deleted"
+}
+
+// get decodes path's response into out. Failed attempts are retried while
+// they are temporary, ctx isn't done and attempts are left. With revalidate
+// the response is kept and sent back as If-None-Match next time.
+func (c *Client) get(ctx context.Context, path string, query url.Values, revalidate bool, out any) error {
+	u := c.cfg.BaseURL + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	backoff := c.cfg.BackoffMin
+	for attempt := 1; ; attempt++ {
+		body, err := c.fetch(ctx, u, revalidate)
+		if err == nil {
+			if err := json.Unmarshal(body, out); err != nil {
+				return fmt.Errorf("decode %s: %w", path, err)
+			}
+			return nil
+		}
+		if attempt == c.cfg.MaxAttempts || ctx.Err() != nil || !temporary(err) {
+			return err
+		}
+
+		// Jittered, so callers failing together don't come back together
+		wait := backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+		var apiErr *APIError
+		if errors.As(err, &apiErr) && apiErr.RetryAfter > wait {
+			wait = apiErr.RetryAfter
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(wait):
+		}
+		backoff = min(2*backoff, c.cfg.BackoffMax)
+	}
+}
+
+// fetch makes one attempt at u and returns the body to decode
+func (c *Client) fetch(ctx context.Context, u string, revalidate bool) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.cfg.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("User-Agent", c.cfg.UserAgent)
+	var held *etagEntry
+	if revalidate && c.etags != nil {
+		if held = c.etags.get(u); held != nil {
+			req.Header.Set("If-None-Match", held.etag)
+		}
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("read response: %w", err)
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusOK:
+		if etag := resp.Header.Get("ETag"); revalidate && c.etags != nil && etag != "" {
+			c.etags.put(u, etag, body)
+		}
+		return body, nil
+	case resp.StatusCode == http.StatusNotModified && held != nil:
+		return held.body, nil
+	}
+	return nil, newAPIError(resp, body)
+}
+
+func newAPIError(resp *http.Response, body []byte) *APIError {
+	e := &APIError{StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(body))}
+	var fields struct {
+		Code  string `json:"code"`
+		Error string `json:"error"`
+	}
+	if json.Unmarshal(body, &fields) == nil && fields.Error != "" {
+		e.Code, e.Message = fields.Code, fields.Error
+	}
+	if e.Message == "" {
+		e.Message = http.StatusText(resp.StatusCode)
+	}
+	if v := resp.Header.Get("Retry-After"); v != "" {
+		if secs, err := strconv.Atoi(v); err == nil {
+			e.RetryAfter = time.Duration(secs) * time.Second
+		} else if t, err := http.ParseTime(v); err == nil {
+			e.RetryAfter = time.Until(t)
+		}
+	}
+	return e
+}
+
+// temporary reports whether a failed attempt is worth repeating: a
+// temporary APIError, or a transport error such as a refused connection, a
+// body cut short or the attempt's own timeout
+func temporary(err error) bool {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.Temporary()
+	}
+	return true
+}
@@ -0,0 +1,252 @@
+package topkclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newTestClient serves handler and returns a client of it that backs off
+// for a millisecond or two between attempts
+func newTestClient(t *testing.T, handler http.HandlerFunc) *Client {
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+	return New(Config{
+		BaseURL:     srv.URL,
+		Timeout:     time.Second,
+		MaxAttempts: 3,
+		BackoffMin:  time.Millisecond,
+		BackoffMax:  2 * time.Millisecond,
+		ETagEntries: 10,
+	})
+}
+
+// failing answers status to the first fails requests and a top-K after
+func failing(attempts *atomic.Int32, fails int32, status int, header http.Header) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if attempts.Add(1) <= fails {
+			for k, v := range header {
+				w.Header()[k] = v
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(status)
+			fmt.Fprintf(w, `{"code": "query_budget", "error": "busy"}`)
+			return
+		}
+		fmt.Fprint(w, `{"user_id": "u1", "k": 10, "results": [{"song_id": "s1", "listen_count": 3, "rank": 1}]}`)
+	}
+}
+
+func TestRetryTransient(t *testing.T) {
+	for _, status := range []int{429, 500, 502, 503, 504} {
+		t.Run(fmt.Sprint(status), func(t *testing.T) {
+			var attempts atomic.Int32
+			c := newTestClient(t, failing(&attempts, 2, status, nil))
+			top, err := c.TopK(context.Background(), "u1", TopKQuery{})
+			if err != nil {
+				t.Fatalf("TopK after two %ds: %v", status, err)
+			}
+			if got := attempts.Load(); got != 3 {
+				t.Errorf("attempts = %d, want 3", got)
+			}
+			if len(top.Results) != 1 || top.Results[0].SongID != "s1" {
+				t.Errorf("results = %+v", top.Results)
+			}
+		})
+	}
+}
+
+func TestRetryGivesUp(t *testing.T) {
+	var attempts atomic.Int32
+	c := newTestClient(t, failing(&attempts, 100, http.StatusServiceUnavailable, nil))
+	_, err := c.TopK(context.Background(), "u1", TopKQuery{})
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("err = %v, want an APIError", err)
+	}
+	if apiErr.StatusCode != 503 || apiErr.Code != "query_budget" || apiErr.Message != "busy" {
+		t.Errorf("APIError = %+v", apiErr)
+	}
+	if got := attempts.Load(); got != 3 {
+		t.Errorf("attempts = %d, want MaxAttempts (3)", got)
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	var attempts atomic.Int32
+	c := newTestClient(t, failing(&attempts, 1, http.StatusTooManyRequests, http.Header{"Retry-After": {"1"}}))
+	start := time.Now()
+	if _, err := c.TopK(context.Background(), "u1", TopKQuery{}); err != nil {
+		t.Fatalf("TopK: %v", err)
+	}
+	// The backoff alone would be a millisecond
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Errorf("retried after %s, before the 1s Retry-After", elapsed)
+	}
+
+	// A wait longer than the caller's deadline gives up instead
+	attempts.Store(0)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err := c.TopK(ctx, "u1", TopKQuery{})
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.RetryAfter != time.Second {
+		t.Errorf("err = %v, want the 429 with its Retry-After", err)
+	}
+	if got := attempts.Load(); got != 1 {
+		t.Errorf("attempts = %d, want 1", got)
+	}
+}
+
+func TestNoRetryOn4xx(t *testing.T) {
+	tests := []struct {
+		status      int
+		body        string
+		notFound    bool
+		userDeleted bool
+	}{
+		{http.StatusBadRequest, "days must be between 1 and 30", false, false},
+		{http.StatusNotFound, "not found", true, false},
+		{http.StatusNotFound, `{"code": "user_deleted", "error": "user is deleted", "user_id": "u1"}`, true, true},
+		{http.StatusGone, "cursor expired", false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.body, func(t *testing.T) {
+			var attempts atomic.Int32
+			c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+				attempts.Add(1)
+				w.WriteHeader(tt.status)
+				fmt.Fprintln(w, tt.body)
+			})
+			_, err := c.TopK(context.Background(), "u1", TopKQuery{})
+			var apiErr *APIError
+			if !errors.As(err, &apiErr) || apiErr.StatusCode != tt.status {
+				t.Fatalf("err = %v, want a %d", err, tt.status)
+			}
+			if apiErr.Temporary() {
+				t.Errorf("%d is Temporary", tt.status)
+			}
+			if got := attempts.Load(); got != 1 {
+				t.Errorf("attempts = %d, want 1", got)
+			}
+			if IsNotFound(err) != tt.notFound || IsUserDeleted(err) != tt.userDeleted {
+				t.Errorf("IsNotFound = %v, IsUserDeleted = %v; want %v, %v",
+					IsNotFound(err), IsUserDeleted(err), tt.notFound, tt.userDeleted)
+			}
+		})
+	}
+}
+
+func TestAttemptTimeout(t *testing.T) {
+	var attempts atomic.Int32
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if attempts.Add(1) == 1 {
+			<-r.Context().Done() // hangs until the client gives up
+			return
+		}
+		fmt.Fprint(w, `{"user_id": "u1"}`)
+	})
+	c.cfg.Timeout = 20 * time.Millisecond
+	if _, err := c.TopK(context.Background(), "u1", TopKQuery{}); err != nil {
+		t.Fatalf("TopK after a timed out attempt: %v", err)
+	}
+	if got := attempts.Load(); got != 2 {
+		t.Errorf("attempts = %d, want 2", got)
+	}
+}
+
+func TestRevalidate(t *testing.T) {
+	var (
+		body        = `{"user_id": "u1", "results": [{"song_id": "s1", "listen_count": 3, "rank": 1}]}`
+		etag        = `"v1"`
+		ifNoneMatch []string
+		served      []int
+	)
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		ifNoneMatch = append(ifNoneMatch, r.Header.Get("If-None-Match"))
+		w.Header().Set("ETag", etag)
+		if r.Header.Get("If-None-Match") == etag {
+			served = append(served, http.StatusNotModified)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		served = append(served, http.StatusOK)
+		fmt.Fprint(w, body)
+	})
+	ctx := context.Background()
+	q := TopKQuery{Days: 7}
+
+	first, err := c.TopK(ctx, "u1", q)
+	if err != nil {
+		t.Fatalf("TopK: %v", err)
+	}
+	// Unchanged: a 304 with no body decodes the copy held
+	second, err := c.TopK(ctx, "u1", q)
+	if err != nil {
+		t.Fatalf("TopK revalidated: %v", err)
+	}
+	if len(second.Results) != 1 || second.Results[0] != first.Results[0] {
+		t.Errorf("revalidated results = %+v, want %+v", second.Results, first.Results)
+	}
+
+	// Changed: the new body replaces the one held
+	body, etag = `{"user_id": "u1", "results": [{"song_id": "s2", "listen_count": 5, "rank": 1}]}`, `"v2"`
+	third, err := c.TopK(ctx, "u1", q)
+	if err != nil {
+		t.Fatalf("TopK changed: %v", err)
+	}
+	if third.Results[0].SongID != "s2" {
+		t.Errorf("results after a change = %+v", third.Results)
+	}
+	fourth, _ := c.TopK(ctx, "u1", q)
+	if fourth == nil || fourth.Results[0].SongID != "s2" {
+		t.Errorf("results revalidated after a change = %+v", fourth)
+	}
+
+	wantTags := []string{"", `"v1"`, `"v1"`, `"v2"`}
+	wantServed := []int{200, 304, 200, 304}
+	for i := range wantTags {
+		if ifNoneMatch[i] != wantTags[i] || served[i] != wantServed[i] {
+			t.Errorf("request %d: If-None-Match %q answered %d, want %q answered %d",
+				i+1, ifNoneMatch[i], served[i], wantTags[i], wantServed[i])
+		}
+	}
+
+	// Other windows are other URLs, and cursor pages are never revalidated
+	if _, err := c.TopK(ctx, "u1", TopKQuery{Days: 30}); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := c.TopKPage(ctx, "u1", 7, 10, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i, tag := range ifNoneMatch[4:] {
+		if tag != "" {
+			t.Errorf("request %d sent If-None-Match %q, want none", i+5, tag)
+		}
+	}
+}
+
+func TestRevalidateWithoutCache(t *testing.T) {
+	var tags []string
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		tags = append(tags, r.Header.Get("If-None-Match"))
+		w.Header().Set("ETag", `"v1"`)
+		fmt.Fprint(w, `{"user_id": "u1"}`)
+	})
+	c.etags = nil // ETagEntries 0
+	for i := 0; i < 2; i++ {
+		if _, err := c.TopK(context.Background(), "u1", TopKQuery{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if tags[1] != "" {
+		t.Errorf("If-None-Match %q sent without an ETag cache", tags[1])
+	}
+}
@@ -0,0 +1,53 @@
+package topkclient
+
+import (
+	"container/list"
+	"sync"
+)
+
+// etagEntry is a response body and the ETag it was served with
+type etagEntry struct {
+	url  string
+	etag string
+	body []byte
+}
+
+// etagCache keeps the last responses by URL, least recently used out first
+type etagCache struct {
+	mu    sync.Mutex
+	max   int
+	order *list.List // front = most recently used
+	byURL map[string]*list.Element
+}
+
+func newETagCache(max int) *etagCache {
+	return &etagCache{max: max, order: list.New(), byURL: make(map[string]*list.Element)}
+}
+
+func (c *etagCache) get(url string) *etagEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.byURL[url]
+	if !ok {
+		return nil
+	}
+	c.order.MoveToFront(el)
+	return el.Value.(*etagEntry)
+}
+
+func (c *etagCache) put(url, etag string, body []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry := &etagEntry{url: url, etag: etag, body: body}
+	if el, ok := c.byURL[url]; ok {
+		el.Value = entry
+		c.order.MoveToFront(el)
+		return
+	}
+	c.byURL[url] = c.order.PushFront(entry)
+	for c.order.Len() > c.max {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.byURL, oldest.Value.(*etagEntry).url)
+	}
+}
@@ -0,0 +1,39 @@
+package topkclient
+
+import "testing"
+
+func TestETagCacheLRU(t *testing.T) {
+	c := newETagCache(2)
+	c.put("/a", `"a1"`, []byte("a"))
+	c.put("/b", `"b1"`, []byte("b"))
+
+	// Reading /a makes /b the least recently used
+	if e := c.get("/a"); e == nil || e.etag != `"a1"` {
+		t.Fatalf("get(/a) = %+v", e)
+	}
+	c.put("/c", `"c1"`, []byte("c"))
+	if e := c.get("/b"); e != nil {
+		t.Errorf("/b kept past the limit: %+v", e)
+	}
+	for _, url := range []string{"/a", "/c"} {
+		if c.get(url) == nil {
+			t.Errorf("%s evicted", url)
+		}
+	}
+
+	// Replacing an entry moves it to the front and evicts nothing
+	c.put("/a", `"a2"`, []byte("a2"))
+	if c.order.Len() != 2 || len(c.byURL) != 2 {
+		t.Errorf("%d entries and %d URLs after a replace, want 2", c.order.Len(), len(c.byURL))
+	}
+	if e := c.get("/a"); e == nil || e.etag != `"a2"` || string(e.body) != "a2" {
+		t.Errorf("get(/a) after replace = %+v", e)
+	}
+	c.put("/d", `"d1"`, []byte("d"))
+	if c.get("/c") != nil {
+		t.Errorf("/c kept, though /a was used since")
+	}
+	if c.get("/a") == nil || c.get("/d") == nil {
+		t.Errorf("/a or /d evicted")
+	}
+}
@@ -0,0 +1,206 @@
+package topkclient
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// specPath is the api-server's OpenAPI spec, which the client is written
+// against instead of generated from
+const specPath = "../../api-server/openapi.yaml"
+
+type spec struct {
+	Paths      map[string]map[string]operation
+	Components struct {
+		Parameters map[string]parameter
+		Schemas    map[string]*schema
+	}
+}
+
+type operation struct {
+	Parameters []parameter
+}
+
+type parameter struct {
+	Ref  string `yaml:"$ref"`
+	Name string
+	In   string
+}
+
+type schema struct {
+	Ref        string `yaml:"$ref"`
+	Type       string
+	Properties map[string]*schema
+	Items      *schema
+}
+
+func loadSpec(t *testing.T) *spec {
+	t.Helper()
+	data, err := os.ReadFile(specPath)
+	if err != nil {
+		t.Fatalf("read the spec: %v", err)
+	}
+	var s spec
+	if err := yaml.Unmarshal(data, &s); err != nil {
+		t.Fatalf("parse %s: %v", specPath, err)
+	}
+	return &s
+}
+
+func (s *spec) resolve(sc *schema) *schema {
+	for sc != nil && sc.Ref != "" {
+		sc = s.Components.Schemas[strings.TrimPrefix(sc.Ref, "#/components/schemas/")]
+	}
+	return sc
+}
+
+// route finds the GET operation whose path template matches path, and
+// returns the names of its query parameters
+func (s *spec) route(path string) (string, map[string]bool) {
+	for tmpl, methods := range s.Paths {
+		op, ok := methods["get"]
+		if !ok {
+			continue
+		}
+		re := regexp.MustCompile("^" + regexp.MustCompile(`\\\{[a-z_]+\\\}`).
+			ReplaceAllString(regexp.QuoteMeta(tmpl), `[^/]+`) + "$")
+		if !re.MatchString(path) {
+			continue
+		}
+		query := make(map[string]bool)
+		for _, p := range op.Parameters {
+			if p.Ref != "" {
+				p = s.Components.Parameters[strings.TrimPrefix(p.Ref, "#/components/parameters/")]
+			}
+			if p.In == "query" {
+				query[p.Name] = true
+			}
+		}
+		return tmpl, query
+	}
+	return "", nil
+}
+
+// checkFields returns the JSON fields of typ that sc doesn't have, at every
+// depth. The spec may have fields the client leaves out.
+func (s *spec) checkFields(where string, typ reflect.Type, sc *schema) []string {
+	sc = s.resolve(sc)
+	for typ.Kind() == reflect.Pointer {
+		typ = typ.Elem()
+	}
+	switch {
+	case sc == nil:
+		return []string{where + ": not in the spec"}
+	case typ.Kind() == reflect.Slice:
+		if sc.Type != "array" {
+			return []string{fmt.Sprintf("%s: a list in the client, %s in the spec", where, sc.Type)}
+		}
+		return s.checkFields(where+"[]", typ.Elem(), sc.Items)
+	case typ.Kind() == reflect.Struct && typ != reflect.TypeOf(time.Time{}):
+		if sc.Type != "object" {
+			return []string{fmt.Sprintf("%s: an object in the client, %s in the spec", where, sc.Type)}
+		}
+		var problems []string
+		for i := 0; i < typ.NumField(); i++ {
+			name, _, _ := strings.Cut(typ.Field(i).Tag.Get("json"), ",")
+			if name == "" || name == "-" {
+				continue
+			}
+			problems = append(problems, s.checkFields(where+"."+name, typ.Field(i).Type, sc.Properties[name])...)
+		}
+		return problems
+	}
+	return nil
+}
+
+func ref(name string) *schema { return &schema{Ref: "#/components/schemas/" + name} }
+
+// TestSpec calls every method against a server that records the requests,
+// and checks each request's route and query parameters, and each response
+// type's fields, against the spec
+func TestSpec(t *testing.T) {
+	s := loadSpec(t)
+	var last *http.Request
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		last = r
+		w.Write([]byte("{}"))
+	})
+	ctx := context.Background()
+
+	tests := []struct {
+		name   string
+		call   func() error
+		out    any
+		schema string
+	}{
+		{"TopK", func() error {
+			_, err := c.TopK(ctx, "u1", TopKQuery{Days: 7, K: 10, RankBy: "recent", HalfLife: 3, Experiment: "e1", AsOf: "2026-10-01"})
+			return err
+		}, TopKResponse{}, "TopKResponse"},
+		{"TopK hours", func() error { _, err := c.TopK(ctx, "u1", TopKQuery{Hours: 24}); return err }, TopKResponse{}, "TopKResponse"},
+		{"TopKPage", func() error { _, err := c.TopKPage(ctx, "u1", 30, 100, "c1"); return err }, TopKResponse{}, "TopKResponse"},
+		{"ArtistTopK", func() error { _, err := c.ArtistTopK(ctx, "u1", 7, 10); return err }, ArtistTopKResponse{}, "ArtistTopKResponse"},
+		{"TagTopK", func() error { _, err := c.TagTopK(ctx, "u1", Moods, 7, 10); return err }, TagTopKResponse{}, "TagTopKResponse"},
+		{"Activity", func() error { _, err := c.Activity(ctx, "u1", 90); return err }, Activity{}, "Activity"},
+		{"YearReview", func() error { _, err := c.YearReview(ctx, "u1", 2025); return err }, YearReview{}, "YearReview"},
+		{"Chart", func() error { _, err := c.Chart(ctx, "24h", 10); return err }, Chart{}, "Chart"},
+		{"SongStats", func() error { _, err := c.SongStats(ctx, "s1", 7); return err }, SongStats{}, "SongStats"},
+		{"SongTimeseries", func() error { _, err := c.SongTimeseries(ctx, "s1", 30); return err }, SongTimeseries{}, "SongTimeseries"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.call(); err != nil {
+				t.Fatalf("call: %v", err)
+			}
+			tmpl, params := s.route(last.URL.Path)
+			if tmpl == "" {
+				t.Fatalf("GET %s is not in the spec", last.URL.Path)
+			}
+			for name := range last.URL.Query() {
+				if !params[name] {
+					t.Errorf("GET %s: query parameter %q is not in the spec", tmpl, name)
+				}
+			}
+			for _, p := range s.checkFields(tt.schema, reflect.TypeOf(tt.out), ref(tt.schema)) {
+				t.Error(p)
+			}
+		})
+	}
+
+	// The JSON error body APIError decodes
+	errorBody := struct {
+		Code  string `json:"code"`
+		Error string `json:"error"`
+	}{}
+	for _, p := range s.checkFields("Error", reflect.TypeOf(errorBody), ref("Error")) {
+		t.Error(p)
+	}
+}
+
+// TestSpecCheck makes sure TestSpec's checks can fail
+func TestSpecCheck(t *testing.T) {
+	s := loadSpec(t)
+	if tmpl, _ := s.route("/users/u1/nowhere"); tmpl != "" {
+		t.Errorf("unknown route matched %s", tmpl)
+	}
+	if tmpl, params := s.route("/users/u1/topk"); tmpl != "/users/{user_id}/topk" || !params["days"] || params["window"] {
+		t.Errorf("route(/users/u1/topk) = %s, %v", tmpl, params)
+	}
+	bogus := struct {
+		Results []struct {
+			Bogus string `json:"bogus"`
+		} `json:"results"`
+	}{}
+	if got := s.checkFields("TopKResponse", reflect.TypeOf(bogus), ref("TopKResponse")); len(got) != 1 {
+		t.Errorf("checkFields of a field the spec lacks = %q, want one problem", got)
+	}
+}
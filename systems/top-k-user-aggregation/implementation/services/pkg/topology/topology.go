@@ -0,0 +1,325 @@
+// Package topology reads pipeline.yaml, the declarative description of the
+// pipeline: its Kafka topics, consumer groups, Cassandra tables and Redis
+// caches, and which service uses which under what env var. Services call
+// Apply at the top of main to turn their part of it into env defaults, so
+// the wiring lives in one file instead of every deployment's env.
+package topology
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Topology is a parsed pipeline.yaml
+type Topology struct {
+	Topics   map[string]string  `yaml:"topics"` // name → what flows on it
+	Tables   []string           `yaml:"tables"`
+	Caches   map[string]Cache   `yaml:"caches"`
+	Services map[string]Service `yaml:"services"`
+	// Settings are runtime settings of every service, see Service.Settings
+	Settings map[string]string `yaml:"settings"`
+}
+
+// Cache is a Redis database holding a cache
+type Cache struct {
+	DB          int    `yaml:"db"`
+	Prefix      string `yaml:"prefix"` // key prefix; empty keeps the service's (the DC's)
+	Description string `yaml:"description"`
+}
+
+// Service is one binary's wiring. Topics and groups are keyed by the env
+// var the service reads them from.
+type Service struct {
+	Topics  map[string]string `yaml:"topics"`
+	Groups  map[string]Group  `yaml:"groups"`
+	Tables  []string          `yaml:"tables"`
+	Cache   string            `yaml:"cache"` // sets CACHE_REDIS_DB (and CACHE_KEY_PREFIX)
+	Metrics *Metrics          `yaml:"metrics"`
+	Env     map[string]string `yaml:"env"` // any other defaults
+	// Settings are pkg/runtimecfg keys (cache_ttl, flush_interval), unlike
+	// the rest reloaded without a restart
+	Settings map[string]string `yaml:"settings"`
+}
+
+// Group is a consumer group and the topic it reads
+type Group struct {
+	ID    string `yaml:"id"`
+	Topic string `yaml:"topic"`
+}
+
+// Metrics is where a service serves /debug/vars: port, set through env (an
+// _ADDR var gets ":port", any other the bare port)
+type Metrics struct {
+	Env  string `yaml:"env"`
+	Port int    `yaml:"port"`
+}
+
+// GroupRef is a consumer group with the service it belongs to
+type GroupRef struct {
+	Service string
+	Group
+}
+
+// Load reads and validates the file at path. Unknown keys are errors, so a
+// typo can't silently drop a setting.
+func Load(path string) (*Topology, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	dec := yaml.NewDecoder(f)
+	dec.KnownFields(true)
+	var t Topology
+	if err := dec.Decode(&t); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if err := t.Validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &t, nil
+}
+
+// Validate checks that every topic, table and cache a service names is
+// declared, that no consumer group or metrics port is shared by two
+// services and that no service sets a var twice. All problems are returned.
+func (t *Topology) Validate() error {
+	var errs []error
+	fail := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	tables := make(map[string]bool, len(t.Tables))
+	for _, table := range t.Tables {
+		if tables[table] {
+			fail("table %s declared twice", table)
+		}
+		tables[table] = true
+	}
+	if len(t.Services) == 0 {
+		fail("no services")
+	}
+
+	groups := make(map[string]string) // ID → service
+	ports := make(map[int]string)
+	for _, name := range t.ServiceNames() {
+		s := t.Services[name]
+		vars := make(map[string]string) // var → what sets it
+		setVar := func(key, by string) {
+			if prev, ok := vars[key]; ok {
+				fail("service %s: %s set by both %s and %s", name, key, prev, by)
+			}
+			vars[key] = by
+		}
+		for _, key := range sortedKeys(s.Topics) {
+			setVar(key, "topics")
+			if _, ok := t.Topics[s.Topics[key]]; !ok {
+				fail("service %s: %s: undeclared topic %q", name, key, s.Topics[key])
+			}
+		}
+		for _, key := range sortedKeys(s.Groups) {
+			setVar(key, "groups")
+			g := s.Groups[key]
+			if g.ID == "" {
+				fail("service %s: %s: group without an id", name, key)
+			} else if other, ok := groups[g.ID]; ok {
+				fail("service %s: group %s is %s's too", name, g.ID, other)
+			} else {
+				groups[g.ID] = name
+			}
+			if _, ok := t.Topics[g.Topic]; !ok {
+				fail("service %s: group %s: undeclared topic %q", name, g.ID, g.Topic)
+			}
+		}
+		for _, table := range s.Tables {
+			if !tables[table] {
+				fail("service %s: undeclared table %s", name, table)
+			}
+		}
+		if s.Cache != "" {
+			if _, ok := t.Caches[s.Cache]; !ok {
+				fail("service %s: undeclared cache %s", name, s.Cache)
+			}
+			setVar("CACHE_REDIS_DB", "cache")
+			if t.Caches[s.Cache].Prefix != "" {
+				setVar("CACHE_KEY_PREFIX", "cache")
+			}
+		}
+		if m := s.Metrics; m != nil {
+			if m.Env == "" || m.Port < 1 || m.Port > 65535 {
+				fail("service %s: metrics needs an env var and a port (1-65535)", name)
+			} else if other, ok := ports[m.Port]; ok {
+				fail("service %s: metrics port %d is %s's too", name, m.Port, other)
+			} else {
+				ports[m.Port] = name
+			}
+			setVar(m.Env, "metrics")
+		}
+		for _, key := range sortedKeys(s.Env) {
+			setVar(key, "env")
+		}
+		for _, key := range sortedKeys(s.Settings) {
+			if !isSettingKey(key) {
+				fail("service %s: setting %q: want a lower-case key like cache_ttl", name, key)
+			}
+		}
+	}
+	for _, key := range sortedKeys(t.Settings) {
+		if !isSettingKey(key) {
+			fail("setting %q: want a lower-case key like cache_ttl", key)
+		}
+	}
+	for name, c := range t.Caches {
+		if c.DB < 0 {
+			fail("cache %s: negative db", name)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Env returns the env defaults of service
+func (t *Topology) Env(service string) (map[string]string, error) {
+	s, ok := t.Services[service]
+	if !ok {
+		return nil, fmt.Errorf("service %s isn't in the pipeline config", service)
+	}
+	env := make(map[string]string)
+	for key, topic := range s.Topics {
+		env[key] = topic
+	}
+	for key, g := range s.Groups {
+		env[key] = g.ID
+	}
+	if c, ok := t.Caches[s.Cache]; ok {
+		env["CACHE_REDIS_DB"] = strconv.Itoa(c.DB)
+		if c.Prefix != "" {
+			env["CACHE_KEY_PREFIX"] = c.Prefix
+		}
+	}
+	if m := s.Metrics; m != nil {
+		env[m.Env] = strconv.Itoa(m.Port)
+		if strings.HasSuffix(m.Env, "_ADDR") {
+			env[m.Env] = ":" + env[m.Env]
+		}
+	}
+	for key, v := range s.Env {
+		env[key] = v
+	}
+	return env, nil
+}
+
+// SettingsOf returns service's runtime settings: the global ones, overridden
+// by its own
+func (t *Topology) SettingsOf(service string) map[string]string {
+	out := make(map[string]string)
+	for k, v := range t.Settings {
+		out[k] = v
+	}
+	for k, v := range t.Services[service].Settings {
+		out[k] = v
+	}
+	return out
+}
+
+// isSettingKey reports whether key looks like a runtimecfg key: an env var
+// lower-cased
+func isSettingKey(key string) bool {
+	if key == "" {
+		return false
+	}
+	for _, r := range key {
+		if (r < 'a' || r > 'z') && (r < '0' || r > '9') && r != '_' {
+			return false
+		}
+	}
+	return true
+}
+
+// ServiceNames returns the services, sorted
+func (t *Topology) ServiceNames() []string {
+	return sortedKeys(t.Services)
+}
+
+// Groups returns every consumer group, by service and env var
+func (t *Topology) Groups() []GroupRef {
+	var refs []GroupRef
+	for _, name := range t.ServiceNames() {
+		s := t.Services[name]
+		for _, key := range sortedKeys(s.Groups) {
+			refs = append(refs, GroupRef{Service: name, Group: s.Groups[key]})
+		}
+	}
+	return refs
+}
+
+// LagGroups returns every consumer group as the ops-dashboard's LAG_GROUPS
+// (topic:group, comma-separated)
+func (t *Topology) LagGroups() string {
+	var parts []string
+	for _, g := range t.Groups() {
+		parts = append(parts, g.Topic+":"+g.ID)
+	}
+	return strings.Join(parts, ",")
+}
+
+// MetricsTargets returns every service with metrics as the ops-dashboard's
+// METRICS_TARGETS, each reached by its service name (as in compose)
+func (t *Topology) MetricsTargets() string {
+	var parts []string
+	for _, name := range t.ServiceNames() {
+		if m := t.Services[name].Metrics; m != nil {
+			parts = append(parts, fmt.Sprintf("%s=http://%s:%d/debug/vars", name, name, m.Port))
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+// Apply loads the file at PIPELINE_CONFIG and sets service's env defaults,
+// leaving every var already set (even to "") alone. An overridden topic the
+// file doesn't declare is logged. Without PIPELINE_CONFIG it does nothing
+// and returns a nil *Topology: services keep their built-in defaults.
+func Apply(service string) (*Topology, error) {
+	path := os.Getenv("PIPELINE_CONFIG")
+	if path == "" {
+		return nil, nil
+	}
+	t, err := Load(path)
+	if err != nil {
+		return nil, err
+	}
+	env, err := t.Env(service)
+	if err != nil {
+		return nil, err
+	}
+	applied := 0
+	for _, key := range sortedKeys(env) {
+		if v, set := os.LookupEnv(key); set {
+			if _, isTopic := t.Services[service].Topics[key]; isTopic && v != "" && v != env[key] {
+				if _, ok := t.Topics[v]; !ok {
+					log.Printf("Warning: %s=%s overrides the pipeline config with an undeclared topic", key, v)
+				}
+			}
+			continue
+		}
+		os.Setenv(key, env[key])
+		applied++
+	}
+	log.Printf("Pipeline config %s: %d of %s's %d settings applied, the rest set in the environment", path, applied, service, len(env))
+	return t, nil
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,110 @@
+// Package userstate shares which users are soft-deleted. The crawl-scheduler
+// owns the state (deleted_at on the user's user_crawl_schedule rows) and
+// mirrors it to a Redis hash; the api-server keeps a copy, reloaded every
+// Refresh, and hides those users.
+package userstate
+
+import (
+	"context"
+	"log"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// DeletedKey is the hash of soft-deleted users: user_id -> unix time of the
+// deletion
+const DeletedKey = "users:deleted"
+
+// Refresh is how often a Set reloads, so how long a deletion or restore takes
+// to show in the API at most
+const Refresh = 10 * time.Second
+
+// MarkDeleted records a user's soft delete
+func MarkDeleted(ctx context.Context, rdb *redis.Client, userID string, at time.Time) error {
+	return rdb.HSet(ctx, DeletedKey, userID, at.Unix()).Err()
+}
+
+// Restore removes a user's soft delete
+func Restore(ctx context.Context, rdb *redis.Client, userID string) error {
+	return rdb.HDel(ctx, DeletedKey, userID).Err()
+}
+
+// Sync replaces the hash with deleted, e.g. after a MarkDeleted or Restore
+// was lost. Readers see the old hash or the new one.
+func Sync(ctx context.Context, rdb *redis.Client, deleted map[string]time.Time) error {
+	_, err := rdb.TxPipelined(ctx, func(p redis.Pipeliner) error {
+		p.Del(ctx, DeletedKey)
+		if len(deleted) > 0 {
+			fields := make(map[string]interface{}, len(deleted))
+			for user, at := range deleted {
+				fields[user] = at.Unix()
+			}
+			p.HSet(ctx, DeletedKey, fields)
+		}
+		return nil
+	})
+	return err
+}
+
+// Set is a service's copy of the soft-deleted users, reloaded every Refresh
+// by Start
+type Set struct {
+	rdb   *redis.Client
+	users atomic.Pointer[map[string]time.Time]
+}
+
+func NewSet(rdb *redis.Client) *Set {
+	s := &Set{rdb: rdb}
+	s.users.Store(&map[string]time.Time{})
+	return s
+}
+
+// Start loads the set and keeps reloading it until ctx is done. A failed
+// first load is returned; the set is then empty until a reload succeeds.
+func (s *Set) Start(ctx context.Context) error {
+	err := s.reload(ctx)
+	go func() {
+		ticker := time.NewTicker(Refresh)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			if err := s.reload(ctx); err != nil {
+				log.Printf("Error reloading deleted users, keeping %d: %v", s.Len(), err)
+			}
+		}
+	}()
+	return err
+}
+
+// reload keeps the previous set when the read fails
+func (s *Set) reload(ctx context.Context) error {
+	fields, err := s.rdb.HGetAll(ctx, DeletedKey).Result()
+	if err != nil {
+		return err
+	}
+	users := make(map[string]time.Time, len(fields))
+	for user, v := range fields {
+		sec, _ := strconv.ParseInt(v, 10, 64)
+		users[user] = time.Unix(sec, 0)
+	}
+	s.users.Store(&users)
+	return nil
+}
+
+// Deleted reports whether a user is soft-deleted, and since when
+func (s *Set) Deleted(userID string) (time.Time, bool) {
+	at, ok := (*s.users.Load())[userID]
+	return at, ok
+}
+
+// Len is the number of soft-deleted users
+func (s *Set) Len() int {
+	return len(*s.users.Load())
+}
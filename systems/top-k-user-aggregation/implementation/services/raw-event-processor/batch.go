@@ -0,0 +1,409 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/system-design-lab/pkg/kafkautil"
+	"github.com/system-design-lab/pkg/runtimecfg"
+	"golang.org/x/time/rate"
+)
+
+const (
+	retryBackoffMin = 500 * time.Millisecond
+	retryBackoffMax = 10 * time.Second
+)
+
+// BatchConfig controls how messages are grouped before writing
+type BatchConfig struct {
+	Size        int           // flush after this many messages
+	Timeout     time.Duration // or after this long since the first buffered message
+	Concurrency int           // parallel sink writes per batch
+	MaxAttempts int           // write attempts per event before it goes to a retry topic or the DLQ
+	MaxBytes    int           // larger values are dead-lettered undecoded (0 = no limit)
+	KeepBytes   int           // bytes of an oversized value kept in the DLQ
+}
+
+// record is a decoded event with its source message and write attempts
+type record struct {
+	msg      kafka.Message
+	event    ListenEvent
+	attempts int
+	lastErr  error
+}
+
+// BatchProcessor accumulates messages and writes them to the sink in batches,
+// committing the batch's offsets once all of its events are stored
+type BatchProcessor struct {
+	cfg     BatchConfig
+	dlq     *DeadLetterQueue      // nil = retry failed writes forever
+	retry   *kafkautil.RetryQueue // nil = failed writes go to the DLQ at once
+	catch   *CatchUp              // nil = catch-up mode off
+	limit   *rate.Limiter         // nil = unlimited sink writes
+	sink    Sink
+	readers []*kafka.Reader // one per batch worker, each a member of the group
+	dedup   bool            // drop duplicate event IDs (DEDUP_MODE != off)
+	bloom   *bloomDeduper   // set when DEDUP_MODE=bloom
+
+	settings *runtimecfg.Config // dedup_enabled, dry_run; nil = env only
+}
+
+// dedupOn reports whether duplicates are dropped: DEDUP_MODE, unless an
+// operator switched it off at runtime
+func (p *BatchProcessor) dedupOn() bool {
+	return p.dedup && p.settings.Bool("dedup_enabled", true)
+}
+
+// Run consumes messages until ctx is cancelled, with one batch worker per
+// reader. Each reader is a member of the group with partitions of its own,
+// so partitions are processed in parallel while each keeps its order, and
+// a worker stuck on its sink writes stops fetching only its own partitions.
+func (p *BatchProcessor) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, reader := range p.readers {
+		wg.Add(1)
+		go func(reader *kafka.Reader) {
+			defer wg.Done()
+			p.runWorker(ctx, reader)
+		}(reader)
+	}
+	wg.Wait()
+}
+
+// fetch reads messages from reader into msgs until ctx is cancelled, then
+// closes it
+func (p *BatchProcessor) fetch(ctx context.Context, reader *kafka.Reader, msgs chan<- kafka.Message) {
+	defer close(msgs)
+	for {
+		msg, err := reader.FetchMessage(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return // context cancelled, shutdown
+			}
+			log.Printf("Error fetching message: %v", err)
+			continue
+		}
+		metricQueuedMessages.Add(1)
+		msgs <- msg
+	}
+}
+
+// runWorker batches the messages of reader's partitions and commits them
+// through it. The final partial batch is flushed with a fresh context so
+// shutdown doesn't drop it.
+func (p *BatchProcessor) runWorker(ctx context.Context, reader *kafka.Reader) {
+	msgs := make(chan kafka.Message, p.cfg.Size)
+	go p.fetch(ctx, reader, msgs)
+
+	batch := make([]kafka.Message, 0, p.cfg.Size)
+	timer := time.NewTimer(p.cfg.Timeout)
+	stopTimer(timer)
+
+	for {
+		select {
+		case msg, ok := <-msgs:
+			if !ok {
+				if len(batch) > 0 {
+					shutdownCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+					p.flush(shutdownCtx, reader, batch)
+					cancel()
+				}
+				return
+			}
+			metricQueuedMessages.Add(-1)
+			if len(batch) == 0 {
+				timer.Reset(p.cfg.Timeout)
+			}
+			batch = append(batch, msg)
+			if len(batch) >= p.cfg.Size {
+				stopTimer(timer)
+				p.flush(ctx, reader, batch)
+				batch = batch[:0]
+			}
+		case <-timer.C:
+			if len(batch) > 0 {
+				p.flush(ctx, reader, batch)
+				batch = batch[:0]
+			}
+		}
+	}
+}
+
+// stopTimer stops t and drains a pending tick so a later Reset starts clean
+func stopTimer(t *time.Timer) {
+	if !t.Stop() {
+		select {
+		case <-t.C:
+		default:
+		}
+	}
+}
+
+// flush writes every event in the batch, retrying failed writes with backoff,
+// flushes the sink, then commits the batch's offsets in one call. Events that
+// fail MaxAttempts times are dead-lettered so they can't block the partition.
+func (p *BatchProcessor) flush(ctx context.Context, reader *kafka.Reader, batch []kafka.Message) {
+	start := time.Now()
+
+	var records []*record
+	for _, msg := range batch {
+		if p.cfg.MaxBytes > 0 && len(msg.Value) > p.cfg.MaxBytes {
+			log.Printf("Skipping oversized event of %d bytes (partition=%d offset=%d)", len(msg.Value), msg.Partition, msg.Offset)
+			metricEventsOversized.Add(1)
+			p.deadLetterOversized(ctx, msg)
+			continue
+		}
+		event, err := decodeEvent(msg.Value)
+		if err != nil {
+			log.Printf("Error unmarshaling event (partition=%d offset=%d): %v", msg.Partition, msg.Offset, err)
+			metricDecodeErrors.Add(1)
+			p.deadLetterDecode(ctx, msg, err)
+			continue // skipped, but its offset is still committed with the batch
+		}
+		if kafkautil.ParseHeaders(msg).IsReplay() {
+			metricEventsReplayed.Add(1)
+		}
+		if kafkautil.RetryAttempt(msg) > 0 {
+			metricEventsRedelivered.Add(1)
+		}
+		records = append(records, &record{msg: msg, event: event})
+	}
+	if p.dedupOn() {
+		records = dedupBatch(records)
+	}
+	if p.settings.Bool("dry_run", false) && len(records) > 0 {
+		// Writes are switched off: the batch is only decoded and committed
+		log.Printf("Dry run: not writing %d events", len(records))
+		metricEventsDryRun.Add(int64(len(records)))
+		records = nil
+	}
+
+	// Catch-up: write recent days now, push older events to the backfill topic
+	records, deferred := p.catch.Split(records)
+	if len(deferred) > 0 {
+		if err := p.catch.Defer(ctx, deferred); err != nil {
+			log.Printf("Error deferring %d old events, writing them now: %v", len(deferred), err)
+			records = append(records, deferred...)
+			deferred = nil
+		} else {
+			log.Printf("Catch-up: deferred %d events older than %s to %s", len(deferred), p.catch.window, p.catch.backfill.Topic)
+		}
+	}
+
+	backoff := retryBackoffMin
+	pending := records
+	dead := 0 // sent to a retry topic or the DLQ instead
+	for {
+		pending = p.writeAll(ctx, pending)
+		pending, dead = p.deadLetterExhausted(ctx, pending, dead)
+		if len(pending) == 0 {
+			err := p.sink.Flush(ctx)
+			if err == nil {
+				break
+			}
+			log.Printf("Error flushing %s sink: %v", p.sink.Name(), err)
+			metricWriteErrors.Add(1)
+			log.Printf("Retrying sink flush in %s", backoff)
+		} else {
+			log.Printf("Retrying %d failed writes in %s", len(pending), backoff)
+		}
+		select {
+		case <-ctx.Done():
+			// Don't commit — the batch is redelivered on restart
+			log.Printf("Shutdown during retry, batch left uncommitted")
+			return
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > retryBackoffMax {
+			backoff = retryBackoffMax
+		}
+	}
+
+	if err := kafkautil.CommitWithRetry(ctx, reader, 3, kafkautil.LatestPerPartition(batch)...); err != nil {
+		log.Printf("Error committing offsets: %v", err)
+		metricCommitErrors.Add(1)
+	}
+
+	written := len(records) - dead
+	elapsed := time.Since(start)
+	metricBatchesFlushed.Add(1)
+	metricLastBatchSize.Set(int64(len(batch)))
+	metricEventsWritten.Add(int64(written))
+	metricFlushLatency.Observe(elapsed)
+	if elapsed > 0 {
+		metricThroughput.Set(float64(written) / elapsed.Seconds())
+	}
+
+	last := batch[len(batch)-1]
+	log.Printf("Flushed batch: messages=%d events=%d deferred=%d set_aside=%d latency=%s last_partition=%d last_offset=%d",
+		len(batch), written, len(deferred), dead, elapsed.Round(time.Millisecond), last.Partition, last.Offset)
+}
+
+// deadLetterExhausted publishes records that used up their retry budget to
+// the next retry topic, or the DLQ once they went through every retry topic,
+// and returns the ones still to retry. If a publish fails, its records stay
+// pending: nothing is committed without being stored somewhere.
+func (p *BatchProcessor) deadLetterExhausted(ctx context.Context, pending []*record, dead int) ([]*record, int) {
+	if (p.dlq == nil && p.retry == nil) || len(pending) == 0 {
+		return pending, dead
+	}
+
+	var retry, later, poison []*record
+	for _, r := range pending {
+		switch {
+		case r.attempts < p.cfg.MaxAttempts:
+			retry = append(retry, r)
+		case p.retry != nil && kafkautil.RetryAttempt(r.msg) < len(p.retry.Tiers()):
+			later = append(later, r)
+		case p.dlq != nil:
+			poison = append(poison, r)
+		default:
+			retry = append(retry, r) // no DLQ: retried forever
+		}
+	}
+
+	if len(later) > 0 {
+		if _, err := p.retry.Publish(ctx, letters(later, reasonWrite)...); err != nil {
+			metricRetryPublishErrors.Add(1)
+			log.Printf("Error publishing %d failed writes to the retry topics: %v (will retry)", len(later), err)
+			retry = append(retry, later...)
+		} else {
+			metricRetried.Add(int64(len(later)))
+			log.Printf("Warning: %d failed writes sent to retry topics after %d attempts, first to %s (last error: %v)",
+				len(later), p.cfg.MaxAttempts, p.retry.Tiers()[kafkautil.RetryAttempt(later[0].msg)].Topic, later[0].lastErr)
+			dead += len(later)
+		}
+	}
+	if len(poison) > 0 {
+		if err := p.dlq.Publish(ctx, letters(poison, reasonWrite)...); err != nil {
+			log.Printf("Error publishing %d poison messages to %s: %v (will retry)", len(poison), p.dlq.Topic(), err)
+			retry = append(retry, poison...)
+		} else {
+			log.Printf("ALERT: %d poison messages sent to %s after %d attempts (last error: %v)",
+				len(poison), p.dlq.Topic(), p.cfg.MaxAttempts, poison[0].lastErr)
+			dead += len(poison)
+		}
+	}
+	return retry, dead
+}
+
+// letters wraps records for the retry topics or the DLQ
+func letters(records []*record, reason string) []kafkautil.DeadLetter {
+	out := make([]kafkautil.DeadLetter, len(records))
+	for i, r := range records {
+		out[i] = kafkautil.DeadLetter{Msg: r.msg, Reason: reason, Err: r.lastErr, Attempts: r.attempts}
+	}
+	return out
+}
+
+// deadLetterDecode sends an undecodable message to the DLQ (best effort —
+// it's skipped either way, so bad input can't stall the partition)
+func (p *BatchProcessor) deadLetterDecode(ctx context.Context, msg kafka.Message, err error) {
+	if p.dlq == nil {
+		return
+	}
+	if perr := p.dlq.Publish(ctx, kafkautil.DeadLetter{Msg: msg, Reason: reasonDecode, Err: err, Attempts: 1}); perr != nil {
+		trace, _ := kafkautil.Header(msg, kafkautil.HeaderTraceID)
+		log.Printf("Error publishing undecodable message to %s (trace=%s): %v", p.dlq.Topic(), trace, perr)
+	}
+}
+
+// deadLetterOversized sends an oversized message to the DLQ with its value
+// cut to KeepBytes, enough to tell what produced it. It's never parsed: a
+// message that large isn't a listen event.
+func (p *BatchProcessor) deadLetterOversized(ctx context.Context, msg kafka.Message) {
+	if p.dlq == nil {
+		return
+	}
+	letter := kafkautil.DeadLetter{
+		Msg:      msg,
+		Reason:   reasonOversized,
+		Err:      fmt.Errorf("value of %d bytes over the %d-byte limit (MAX_EVENT_BYTES)", len(msg.Value), p.cfg.MaxBytes),
+		Attempts: 1,
+		Truncate: p.cfg.KeepBytes,
+	}
+	if perr := p.dlq.Publish(ctx, letter); perr != nil {
+		trace, _ := kafkautil.Header(msg, kafkautil.HeaderTraceID)
+		log.Printf("Error publishing oversized message to %s (trace=%s): %v", p.dlq.Topic(), trace, perr)
+	}
+}
+
+// writeAll writes records concurrently and returns the ones that failed
+func (p *BatchProcessor) writeAll(ctx context.Context, records []*record) []*record {
+	var (
+		mu     sync.Mutex
+		failed []*record
+		wg     sync.WaitGroup
+	)
+	concurrency := p.cfg.Concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	sem := make(chan struct{}, concurrency)
+
+	for _, r := range records {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(r *record) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			if p.limit != nil {
+				if err := p.limit.Wait(ctx); err != nil {
+					r.lastErr = err
+					mu.Lock()
+					failed = append(failed, r)
+					mu.Unlock()
+					return
+				}
+			}
+			r.attempts++
+			if err := p.write(ctx, r.event); err != nil {
+				log.Printf("Error writing to %s (event=%s attempt=%d): %v", p.sink.Name(), r.event.EventID, r.attempts, err)
+				metricWriteErrors.Add(1)
+				r.lastErr = err
+				mu.Lock()
+				failed = append(failed, r)
+				mu.Unlock()
+			}
+		}(r)
+	}
+	wg.Wait()
+
+	return failed
+}
+
+// write stores one event, skipping it if the dedup mode says it's a duplicate
+func (p *BatchProcessor) write(ctx context.Context, event ListenEvent) error {
+	if p.bloom != nil && p.dedupOn() {
+		seen, err := p.bloom.Seen(ctx, event)
+		if err != nil {
+			// Dedup is best effort: on Redis errors, write (inserts are upserts)
+			log.Printf("Warning: bloom check failed: %v (writing anyway)", err)
+		} else if seen {
+			metricDuplicatesSuppressed.Add(1)
+			return nil
+		}
+	}
+
+	err := p.sink.Write(ctx, event)
+	if errors.Is(err, errDuplicate) {
+		metricDuplicatesSuppressed.Add(1)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if p.bloom != nil {
+		if err := p.bloom.Mark(ctx, event); err != nil {
+			log.Printf("Warning: bloom add failed: %v", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/system-design-lab/pkg/kafkautil"
+)
+
+// Catch-up modes (CATCHUP_MODE)
+const (
+	catchUpOff  = "off"
+	catchUpOn   = "on"
+	catchUpAuto = "auto" // on while consumer lag is above CATCHUP_LAG_THRESHOLD
+)
+
+// CatchUp decides which events are written now and which are deferred to the
+// backfill topic while the processor is behind. Recent days are written
+// first; older events are replayed later from the backfill topic (run a second
+// instance with TOPIC set to it and CATCHUP_MODE=off).
+type CatchUp struct {
+	mode         string
+	window       time.Duration // events newer than now-window are written immediately
+	lagThreshold int64
+	active       atomic.Bool
+	backfill     *kafka.Writer
+}
+
+func newCatchUp(mode string, window time.Duration, lagThreshold int64, cfg kafkautil.Config, backfillTopic string) *CatchUp {
+	c := &CatchUp{
+		mode:         mode,
+		window:       window,
+		lagThreshold: lagThreshold,
+		backfill:     cfg.NewWriter(backfillTopic, kafkautil.WriterConfigFromEnv()),
+	}
+	c.setActive(mode == catchUpOn)
+	return c
+}
+
+func (c *CatchUp) setActive(on bool) {
+	changed := c.active.Swap(on) != on
+	if on {
+		metricCatchUpActive.Set(1)
+		if changed {
+			log.Printf("Catch-up mode ON: deferring events older than %s to %s", c.window, c.backfill.Topic)
+		}
+	} else {
+		metricCatchUpActive.Set(0)
+		if changed {
+			log.Printf("Catch-up mode OFF")
+		}
+	}
+}
+
+// Monitor samples the readers' total consumer lag and toggles catch-up in
+// auto mode
+func (c *CatchUp) Monitor(ctx context.Context, readers []*kafka.Reader, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			var lag int64
+			for _, r := range readers {
+				lag += r.Stats().Lag
+			}
+			metricConsumerLag.Set(lag)
+			if c.mode == catchUpAuto {
+				c.setActive(lag > c.lagThreshold)
+			}
+		}
+	}
+}
+
+// Split separates records to write now from old ones to defer. It returns
+// everything as "now" when catch-up is inactive.
+func (c *CatchUp) Split(records []*record) (now, deferred []*record) {
+	if c == nil || !c.active.Load() {
+		return records, nil
+	}
+	cutoff := time.Now().Add(-c.window).Unix()
+	for _, r := range records {
+		if r.event.ListenedAt < cutoff {
+			deferred = append(deferred, r)
+		} else {
+			now = append(now, r)
+		}
+	}
+	return now, deferred
+}
+
+// Defer publishes old events (original bytes) to the backfill topic
+func (c *CatchUp) Defer(ctx context.Context, records []*record) error {
+	msgs := make([]kafka.Message, len(records))
+	for i, r := range records {
+		msgs[i] = kafka.Message{Key: r.msg.Key, Value: r.msg.Value, Headers: r.msg.Headers}
+	}
+	if err := c.backfill.WriteMessages(ctx, msgs...); err != nil {
+		return err
+	}
+	metricEventsDeferred.Add(int64(len(records)))
+	return nil
+}
+
+func (c *CatchUp) Close() error { return c.backfill.Close() }
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/system-design-lab/pkg/chaos"
+	"github.com/system-design-lab/pkg/startup"
+)
+
+// Dedup modes (DEDUP_MODE)
+const (
+	dedupOff   = "off"   // store every message (duplicates on replay)
+	dedupKey   = "key"   // conditional insert on the primary key (LWT / ON CONFLICT)
+	dedupBloom = "bloom" // Redis bloom filter on event_id before writing
+)
+
+// errDuplicate is returned by a sink when a dedup-mode write found the row
+// already present. The batch treats it as success.
+var errDuplicate = errors.New("duplicate event")
+
+const (
+	rawBloomCapacity  = 10_000_000
+	rawBloomErrorRate = 0.001
+	rawBloomTTL       = 8 * 24 * time.Hour
+)
+
+// bloomDeduper checks event IDs against a per-day Redis bloom filter.
+// It uses its own key namespace: the aggregator adds every event to
+// dedup:{day} as it consumes, so sharing that filter would make this
+// independent consumer skip everything.
+type bloomDeduper struct {
+	rdb *redis.Client
+}
+
+func newBloomDeduper(addr string) (*bloomDeduper, error) {
+	rdb := redis.NewClient(&redis.Options{Addr: addr})
+	if err := startup.Redis(context.Background(), rdb); err != nil {
+		return nil, fmt.Errorf("connect to Redis: %w", err)
+	}
+	if chaos.Enabled() {
+		rdb.AddHook(chaos.RedisHook{})
+	}
+	return &bloomDeduper{rdb: rdb}, nil
+}
+
+func rawBloomKey(event ListenEvent) string {
+	return fmt.Sprintf("rawdedup:%s", event.Day())
+}
+
+// Seen reports whether the event ID is (probably) already stored.
+// A false positive drops a new event from history, never duplicates it.
+func (d *bloomDeduper) Seen(ctx context.Context, event ListenEvent) (bool, error) {
+	// BF.EXISTS on a missing key returns 0
+	res, err := d.rdb.Do(ctx, "BF.EXISTS", rawBloomKey(event), event.EventID).Int64()
+	if err != nil {
+		return false, err
+	}
+	return res == 1, nil
+}
+
+// Mark records the event ID after it was stored. Marking only after a
+// successful write means a failed write is retried instead of being skipped.
+func (d *bloomDeduper) Mark(ctx context.Context, event ListenEvent) error {
+	key := rawBloomKey(event)
+	err := d.rdb.Do(ctx, "BF.RESERVE", key, rawBloomErrorRate, rawBloomCapacity, "NONSCALING").Err()
+	if err == nil {
+		d.rdb.Expire(ctx, key, rawBloomTTL)
+		log.Printf("Created bloom filter: %s (TTL: %v)", key, rawBloomTTL)
+	} else if !strings.Contains(err.Error(), "item exists") {
+		return err
+	}
+	return d.rdb.Do(ctx, "BF.ADD", key, event.EventID).Err()
+}
+
+func (d *bloomDeduper) Close() error { return d.rdb.Close() }
+
+// dedupBatch drops repeated event IDs within one batch
+func dedupBatch(records []*record) []*record {
+	seen := make(map[string]bool, len(records))
+	out := records[:0]
+	for _, r := range records {
+		if seen[r.event.EventID] {
+			metricDuplicatesSuppressed.Add(1)
+			continue
+		}
+		seen[r.event.EventID] = true
+		out = append(out, r)
+	}
+	return out
+}
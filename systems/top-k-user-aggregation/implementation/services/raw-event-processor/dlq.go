@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+
+	"github.com/system-design-lab/pkg/kafkautil"
+)
+
+// Dead-letter reasons
+const (
+	reasonDecode    = "decode"    // message doesn't decode or fails events.Validate
+	reasonOversized = "oversized" // value over MAX_EVENT_BYTES, not decoded
+	reasonWrite     = "write"     // sink write failed MAX_WRITE_ATTEMPTS times
+)
+
+// DeadLetterQueue wraps the shared DLQ publisher (see pkg/kafkautil for the
+// header layout) with this service's metrics
+type DeadLetterQueue struct {
+	q *kafkautil.DeadLetterQueue
+}
+
+func newDeadLetterQueue(cfg kafkautil.Config, topic string) *DeadLetterQueue {
+	return &DeadLetterQueue{q: cfg.NewDeadLetterQueue(topic)}
+}
+
+func (d *DeadLetterQueue) Topic() string { return d.q.Topic() }
+
+func (d *DeadLetterQueue) Publish(ctx context.Context, letters ...kafkautil.DeadLetter) error {
+	if err := d.q.Publish(ctx, letters...); err != nil {
+		metricDLQPublishErrors.Add(1)
+		return err
+	}
+	for _, l := range letters {
+		metricDLQMessages.Add(l.Reason, 1)
+	}
+	return nil
+}
+
+func (d *DeadLetterQueue) Close() error { return d.q.Close() }
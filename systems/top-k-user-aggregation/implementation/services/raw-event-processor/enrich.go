@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/system-design-lab/pkg/events"
+)
+
+// decodeEvent parses and validates a message (JSON, protobuf or Avro, see
+// pkg/events) and fills the derived fields. Unknown JSON fields don't fail
+// decoding: they're kept in Extra (as raw JSON text) so new producer fields
+// land in the history before this service learns about them.
+func decodeEvent(data []byte) (ListenEvent, error) {
+	base, err := events.Unmarshal(data)
+	if err != nil {
+		return ListenEvent{}, err
+	}
+	if err := base.Validate(); err != nil {
+		return ListenEvent{}, err
+	}
+
+	event := ListenEvent{ListenEvent: base}
+	for k, v := range events.UnknownJSONFields(data) {
+		if event.Extra == nil {
+			event.Extra = make(map[string]string)
+		}
+		event.Extra[k] = rawText(v)
+	}
+	// The experiment tag has no column of its own: it's short-lived
+	if base.Experiment != "" {
+		if event.Extra == nil {
+			event.Extra = make(map[string]string)
+		}
+		event.Extra["experiment"] = base.Experiment
+	}
+
+	enrich(&event)
+	return event, nil
+}
+
+// enrich derives time buckets from listened_at, in UTC like the day
+// partition (events.Day) and the read path's days, so hour and day always
+// agree whatever the process's time zone
+func enrich(event *ListenEvent) {
+	listenedAt := time.Unix(event.ListenedAt, 0).UTC()
+	event.HourBucket = listenedAt.Truncate(time.Hour).Unix()
+	event.Hour = listenedAt.Hour()
+	event.Weekday = strings.ToLower(listenedAt.Weekday().String()[:3])
+}
+
+// rawText unquotes JSON strings and keeps other values as JSON
+func rawText(v json.RawMessage) string {
+	var s string
+	if err := json.Unmarshal(v, &s); err == nil {
+		return s
+	}
+	return string(v)
+}
@@ -2,59 +2,182 @@ package main
 
 import (
 	"context"
-	"encoding/json"
+	"fmt"
 	"log"
 	"os"
 	"os/signal"
-	"strings"
+	"strconv"
 	"syscall"
 	"time"
 
-	"github.com/gocql/gocql"
+	"github.com/redis/go-redis/v9"
 	"github.com/segmentio/kafka-go"
+	"github.com/system-design-lab/pkg/events"
+	"github.com/system-design-lab/pkg/kafkautil"
+	"github.com/system-design-lab/pkg/runtimecfg"
+	"github.com/system-design-lab/pkg/startup"
+	"github.com/system-design-lab/pkg/topology"
+	"golang.org/x/time/rate"
 )
 
-// ListenEvent matches the event published by crawl-worker
+// ListenEvent is the shared event published by crawl-worker plus fields
+// derived at ingest (see enrich.go)
 type ListenEvent struct {
-	EventID    string `json:"event_id"`
-	UserID     string `json:"user_id"`
-	SongID     string `json:"song_id"`
-	Provider   string `json:"provider"`
-	ListenedAt int64  `json:"listened_at"`
+	events.ListenEvent
+
+	// Derived
+	HourBucket int64             `json:"hour_bucket"` // listened_at truncated to the hour (unix seconds)
+	Hour       int               `json:"hour"`        // 0-23
+	Weekday    string            `json:"weekday"`     // mon..sun
+	Extra      map[string]string `json:"extra,omitempty"`
 }
 
 func main() {
-	kafkaBroker := getEnv("KAFKA_BROKER", "localhost:29092")
-	cassandraHosts := getEnv("CASSANDRA_HOSTS", "localhost:9042")
+	if _, err := topology.Apply("raw-event-processor"); err != nil {
+		log.Fatalf("Invalid pipeline config: %v", err)
+	}
+
+	sinkKind := getEnv("SINK", "cassandra")
+	archiveKind := getEnv("ARCHIVE_SINK", "")
+	dedupMode := getEnv("DEDUP_MODE", dedupOff)
+	redisAddr := getEnv("REDIS_ADDR", "localhost:6379")
 	consumerGroup := getEnv("CONSUMER_GROUP", "raw-event-processor")
-	topic := "user.listen.raw"
+	batchSize := getEnvInt("BATCH_SIZE", 500)
+	batchTimeout := getEnvDuration("BATCH_TIMEOUT", 200*time.Millisecond)
+	writeConcurrency := getEnvInt("WRITE_CONCURRENCY", 16)
+	partitionWorkers := getEnvInt("PARTITION_WORKERS", 4)
+	maxWriteAttempts := getEnvInt("MAX_WRITE_ATTEMPTS", 5)
+	dlqTopic := getEnv("DLQ_TOPIC", "user.listen.raw.dlq")
+	retryTopics := getEnv("RETRY_TOPICS", "user.listen.retry.5m,user.listen.retry.1h")
+	maxEventBytes := getEnvInt("MAX_EVENT_BYTES", 8192)
+	dlqKeepBytes := getEnvInt("DLQ_TRUNCATE_BYTES", 1024)
+	metricsAddr := getEnv("METRICS_ADDR", ":9102")
+	maxInsertRate := getEnvInt("MAX_INSERT_RATE", 0)
+	catchUpMode := getEnv("CATCHUP_MODE", catchUpOff)
+	catchUpWindow := getEnvDuration("CATCHUP_WINDOW", 48*time.Hour)
+	catchUpLag := getEnvInt("CATCHUP_LAG_THRESHOLD", 100000)
+	backfillTopic := getEnv("BACKFILL_TOPIC", "user.listen.raw.backfill")
+	topic := getEnv("TOPIC", "user.listen.raw")
+
+	// A zero-capacity write semaphore (and limiter burst) would block the
+	// first write forever
+	if writeConcurrency < 1 {
+		log.Printf("Warning: WRITE_CONCURRENCY=%d, using 1", writeConcurrency)
+		writeConcurrency = 1
+	}
 
-	log.Printf("Starting raw-event-processor: kafka=%s cassandra=%s group=%s",
-		kafkaBroker, cassandraHosts, consumerGroup)
+	kafkaCfg, err := kafkautil.ConfigFromEnv("localhost:29092")
+	if err != nil {
+		log.Fatalf("Invalid Kafka config: %v", err)
+	}
 
-	// Connect to Cassandra
-	cluster := gocql.NewCluster(strings.Split(cassandraHosts, ",")...)
-	cluster.Keyspace = "topk"
-	cluster.Consistency = gocql.LocalOne
-	cluster.Timeout = 10 * time.Second
+	log.Printf("Starting raw-event-processor: kafka=%v sink=%s group=%s batch=%d/%s concurrency=%d workers=%d",
+		kafkaCfg.Brokers, sinkKind, consumerGroup, batchSize, batchTimeout, writeConcurrency, partitionWorkers)
+	log.Printf("Consumer group membership: %s", kafkaCfg.Group)
 
-	session, err := cluster.CreateSession()
+	if err := startup.Kafka(context.Background(), kafkaCfg); err != nil {
+		log.Fatalf("Failed to connect to Kafka: %v", err)
+	}
+
+	// Connect to the storage backend
+	switch dedupMode {
+	case dedupOff, dedupKey, dedupBloom:
+	default:
+		log.Fatalf("Invalid DEDUP_MODE %q (want off, key or bloom)", dedupMode)
+	}
+	if dedupMode == dedupKey && sinkKind != "cassandra" && sinkKind != "postgres" && sinkKind != "sqlite" {
+		log.Fatalf("DEDUP_MODE=key needs a keyed sink (cassandra, postgres or sqlite), got %s", sinkKind)
+	}
+
+	// Runtime settings and the retention jobs' locks
+	rdb := redis.NewClient(&redis.Options{Addr: redisAddr})
+	defer rdb.Close()
+
+	sink, err := newSink(sinkKind, dedupMode, rdb)
 	if err != nil {
-		log.Fatalf("Failed to connect to Cassandra: %v", err)
-	}
-	defer session.Close()
-	log.Println("Connected to Cassandra")
-
-	// Create Kafka reader (consumer group)
-	reader := kafka.NewReader(kafka.ReaderConfig{
-		Brokers:  []string{kafkaBroker},
-		Topic:    topic,
-		GroupID:  consumerGroup,
-		MinBytes: 1,
-		MaxBytes: 10e6, // 10MB
+		log.Fatalf("Failed to create sink: %v", err)
+	}
+	if archiveKind != "" {
+		archive, err := newSink(archiveKind, dedupOff, rdb)
+		if err != nil {
+			log.Fatalf("Failed to create archive sink: %v", err)
+		}
+		sink = &MultiSink{sinks: []Sink{sink, archive}}
+	}
+	defer sink.Close()
+	log.Printf("Writing raw events to %s sink", sink.Name())
+
+	var bloom *bloomDeduper
+	if dedupMode == dedupBloom {
+		bloom, err = newBloomDeduper(redisAddr)
+		if err != nil {
+			log.Fatalf("Failed to create bloom deduper: %v", err)
+		}
+		defer bloom.Close()
+	}
+	log.Printf("Dedup mode: %s", dedupMode)
+
+	// Runtime config: dedup_enabled, dry_run and max_insert_rate can change
+	// without a restart (pkg/runtimecfg)
+	settings := runtimecfg.New(rdb, "raw-event-processor")
+
+	// One reader per batch worker, each a member of the consumer group with
+	// partitions of its own
+	if partitionWorkers < 1 {
+		partitionWorkers = 1
+	}
+	readers := make([]*kafka.Reader, partitionWorkers)
+	for i := range readers {
+		readers[i] = kafkaCfg.NewReader(kafkautil.ReaderConfig{Topic: topic, GroupID: consumerGroup, Member: fmt.Sprintf("worker-%d", i)})
+		defer readers[i].Close()
+	}
+	log.Printf("Listening on topic: %s (%d group members)", topic, partitionWorkers)
+
+	var dlq *DeadLetterQueue
+	if dlqTopic != "" {
+		dlq = newDeadLetterQueue(kafkaCfg, dlqTopic)
+		defer dlq.Close()
+		log.Printf("Poison messages go to %s after %d write attempts, events over %d bytes at once", dlqTopic, maxWriteAttempts, maxEventBytes)
+	} else {
+		log.Println("DLQ disabled, failed writes are retried forever")
+	}
+
+	// Writes still failing after their attempts wait in the retry topics
+	// (the redelivery service brings them back) before the DLQ
+	var retry *kafkautil.RetryQueue
+	if retryTopics != "off" {
+		tiers, err := kafkautil.ParseRetryTopics(retryTopics)
+		if err != nil {
+			log.Fatalf("Invalid RETRY_TOPICS: %v", err)
+		}
+		retry = kafkaCfg.NewRetryQueue(tiers)
+		defer retry.Close()
+		log.Printf("Failed writes wait in %s before the DLQ", retryTopics)
+	}
+
+	// Always built, so max_insert_rate can switch limiting on at runtime
+	limiter := rate.NewLimiter(insertLimit(maxInsertRate), writeConcurrency)
+	metricMaxInsertRate.Set(float64(maxInsertRate))
+	if maxInsertRate > 0 {
+		log.Printf("Sink writes limited to %d/s", maxInsertRate)
+	}
+	settings.OnChange(func() {
+		r := settings.Int("max_insert_rate", maxInsertRate)
+		limiter.SetLimit(insertLimit(r))
+		metricMaxInsertRate.Set(float64(r))
 	})
-	defer reader.Close()
-	log.Printf("Listening on topic: %s", topic)
+
+	var catchUp *CatchUp
+	switch catchUpMode {
+	case catchUpOff:
+	case catchUpOn, catchUpAuto:
+		catchUp = newCatchUp(catchUpMode, catchUpWindow, int64(catchUpLag), kafkaCfg, backfillTopic)
+		defer catchUp.Close()
+	default:
+		log.Fatalf("Invalid CATCHUP_MODE %q (want off, on or auto)", catchUpMode)
+	}
+
+	startMetricsServer(metricsAddr)
 
 	// Handle shutdown gracefully
 	ctx, cancel := context.WithCancel(context.Background())
@@ -68,63 +191,46 @@ func main() {
 		cancel()
 	}()
 
-	// Process messages
-	for {
-		msg, err := reader.FetchMessage(ctx)
-		if err != nil {
-			if ctx.Err() != nil {
-				break // context cancelled, shutdown
-			}
-			log.Printf("Error fetching message: %v", err)
-			continue
-		}
-
-		var event ListenEvent
-		if err := json.Unmarshal(msg.Value, &event); err != nil {
-			log.Printf("Error unmarshaling event: %v", err)
-			// Commit anyway to skip bad message
-			reader.CommitMessages(ctx, msg)
-			continue
-		}
+	if err := settings.Start(ctx); err != nil {
+		log.Printf("Warning: runtime config not loaded, using env values until Redis answers: %v", err)
+	}
 
-		// Write to Cassandra
-		if err := writeEvent(session, event); err != nil {
-			log.Printf("Error writing to Cassandra: %v", err)
-			// Don't commit — will retry on restart
-			continue
-		}
+	if catchUp != nil {
+		go catchUp.Monitor(ctx, readers, 10*time.Second)
+	}
 
-		// Commit offset after successful write
-		if err := reader.CommitMessages(ctx, msg); err != nil {
-			log.Printf("Error committing offset: %v", err)
-		}
+	processor := &BatchProcessor{
+		cfg: BatchConfig{
+			Size:        batchSize,
+			Timeout:     batchTimeout,
+			Concurrency: writeConcurrency,
+			MaxAttempts: maxWriteAttempts,
+			MaxBytes:    maxEventBytes,
+			KeepBytes:   dlqKeepBytes,
+		},
+		dlq:     dlq,
+		retry:   retry,
+		catch:   catchUp,
+		limit:   limiter,
+		sink:    sink,
+		readers: readers,
+		dedup:   dedupMode != dedupOff,
+		bloom:   bloom,
 
-		log.Printf("Processed: user=%s song=%s listened_at=%d",
-			event.UserID, event.SongID, event.ListenedAt)
+		settings: settings,
 	}
+	processor.Run(ctx)
 
 	log.Println("Shutdown complete")
 }
 
-func writeEvent(session *gocql.Session, event ListenEvent) error {
-	// Convert unix timestamp to time.Time
-	listenedAt := time.Unix(event.ListenedAt, 0)
-	day := listenedAt.Format("2006-01-02") // Cassandra DATE format
-
-	query := `
-		INSERT INTO user_listen_history 
-			(user_id, day, listened_at, event_id, song_id, provider)
-		VALUES (?, ?, ?, ?, ?, ?)
-	`
-
-	return session.Query(query,
-		event.UserID,
-		day,
-		listenedAt,
-		event.EventID,
-		event.SongID,
-		event.Provider,
-	).Exec()
+// insertLimit is the limiter rate for perSec sink writes per second, 0 or
+// less for unlimited
+func insertLimit(perSec int) rate.Limit {
+	if perSec <= 0 {
+		return rate.Inf
+	}
+	return rate.Limit(perSec)
 }
 
 func getEnv(key, fallback string) string {
@@ -133,3 +239,23 @@ func getEnv(key, fallback string) string {
 	}
 	return fallback
 }
+
+func getEnvInt(key string, fallback int) int {
+	if v := os.Getenv(key); v != "" {
+		i, err := strconv.Atoi(v)
+		if err == nil {
+			return i
+		}
+	}
+	return fallback
+}
+
+func getEnvDuration(key string, fallback time.Duration) time.Duration {
+	if v := os.Getenv(key); v != "" {
+		d, err := time.ParseDuration(v)
+		if err == nil {
+			return d
+		}
+	}
+	return fallback
+}
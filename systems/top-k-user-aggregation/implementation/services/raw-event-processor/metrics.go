@@ -0,0 +1,94 @@
+package main
+
+import (
+	"expvar"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Pipeline metrics, served as JSON on METRICS_ADDR/debug/vars
+var (
+	metricEventsWritten   = expvar.NewInt("events_written")
+	metricDecodeErrors    = expvar.NewInt("decode_errors")
+	metricEventsOversized = expvar.NewInt("events_oversized") // over MAX_EVENT_BYTES, dead-lettered undecoded
+	metricWriteErrors     = expvar.NewInt("write_errors")
+	metricBatchesFlushed  = expvar.NewInt("batches_flushed")
+	metricCommitErrors    = expvar.NewInt("commit_errors")
+	metricLastBatchSize   = expvar.NewInt("last_batch_size")
+	metricQueuedMessages  = expvar.NewInt("queued_messages")
+	metricThroughput      = expvar.NewFloat("events_per_second")
+	metricFlushLatency    = newLatencyStats("flush_latency_ms")
+
+	metricDuplicatesSuppressed = expvar.NewInt("duplicates_suppressed")
+	metricEventsDryRun         = expvar.NewInt("events_dry_run")
+	metricEventsReplayed       = expvar.NewInt("events_replayed") // republished by tools/cmd/replay
+
+	metricCatchUpActive  = expvar.NewInt("catchup_active")
+	metricEventsDeferred = expvar.NewInt("events_deferred")
+	metricConsumerLag    = expvar.NewInt("consumer_lag")
+	metricMaxInsertRate  = expvar.NewFloat("max_insert_rate")
+
+	metricDLQMessages      = expvar.NewMap("dlq_messages") // by reason
+	metricDLQPublishErrors = expvar.NewInt("dlq_publish_errors")
+
+	metricRetried            = expvar.NewInt("events_retried_later") // failed writes sent to a retry topic
+	metricRetryPublishErrors = expvar.NewInt("retry_publish_errors")
+	metricEventsRedelivered  = expvar.NewInt("events_redelivered") // back from a retry topic
+
+	metricHistoryRetention   = expvar.NewInt("history_retention_seconds")
+	metricHistoryRowsExpired = expvar.NewInt("history_rows_expired")
+)
+
+// latencyStats tracks count/avg/max/last of a duration, published through expvar
+type latencyStats struct {
+	mu    sync.Mutex
+	count int64
+	sum   time.Duration
+	max   time.Duration
+	last  time.Duration
+}
+
+func newLatencyStats(name string) *latencyStats {
+	l := &latencyStats{}
+	expvar.Publish(name, l)
+	return l
+}
+
+func (l *latencyStats) Observe(d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.count++
+	l.sum += d
+	l.last = d
+	if d > l.max {
+		l.max = d
+	}
+}
+
+// String implements expvar.Var
+func (l *latencyStats) String() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var avg float64
+	if l.count > 0 {
+		avg = ms(l.sum) / float64(l.count)
+	}
+	return fmt.Sprintf(`{"count":%d,"avg":%.2f,"max":%.2f,"last":%.2f}`, l.count, avg, ms(l.max), ms(l.last))
+}
+
+func ms(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// startMetricsServer exposes expvar metrics over HTTP
+func startMetricsServer(addr string) {
+	go func() {
+		log.Printf("Metrics on http://%s/debug/vars", addr)
+		if err := http.ListenAndServe(addr, nil); err != nil {
+			log.Printf("Metrics server error: %v", err)
+		}
+	}()
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/system-design-lab/pkg/lock"
+	"github.com/system-design-lab/pkg/storage"
+)
+
+// retention is the cleanup job of the sinks without a row TTL (Postgres,
+// SQLite). Every replica schedules it; a pkg/lock lock on name lets one of
+// them run each tick, so replicas don't race the same DELETE.
+type retention struct {
+	rdb      *redis.Client
+	name     string        // lock name
+	keep     time.Duration // 0 = keep forever
+	interval time.Duration
+	lockTTL  time.Duration
+
+	// deleteBefore deletes the days before cutoff and returns the rows gone
+	deleteBefore func(ctx context.Context, cutoff string) (int64, error)
+}
+
+func newRetention(rdb *redis.Client, name string, keep time.Duration) retention {
+	return retention{
+		rdb:      rdb,
+		name:     name,
+		keep:     keep,
+		interval: getEnvDuration("HISTORY_CLEANUP_INTERVAL", time.Hour),
+		lockTTL:  getEnvDuration("HISTORY_CLEANUP_LOCK_TTL", time.Minute),
+	}
+}
+
+// run deletes expired days every interval until stop is closed, which
+// also cancels a DELETE in progress
+func (r retention) run(stop <-chan struct{}) {
+	if r.keep <= 0 {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-stop
+		cancel()
+	}()
+
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+	for {
+		_, err := lock.Run(ctx, r.rdb, r.name, r.lockTTL, func(ctx context.Context, l *lock.Lock) error {
+			cutoff := time.Now().Add(-r.keep).Format(storage.DayFormat)
+			n, err := r.deleteBefore(ctx, cutoff)
+			if err != nil {
+				log.Printf("Error deleting history before %s: %v", cutoff, err)
+			} else if n > 0 {
+				metricHistoryRowsExpired.Add(n)
+				log.Printf("Retention: deleted %d history rows before %s (lock fence %d)", n, cutoff, l.Fence)
+			}
+			return nil
+		})
+		if err != nil {
+			log.Printf("Warning: skipping history cleanup: %v", err)
+		}
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
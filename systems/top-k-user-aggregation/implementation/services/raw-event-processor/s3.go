@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// S3Config points at an S3-compatible bucket (AWS or MinIO)
+type S3Config struct {
+	Endpoint  string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	UseSSL    bool
+}
+
+func s3ConfigFromEnv() S3Config {
+	return S3Config{
+		Endpoint:  getEnv("S3_ENDPOINT", ""),
+		Bucket:    getEnv("S3_BUCKET", ""),
+		AccessKey: getEnv("S3_ACCESS_KEY", ""),
+		SecretKey: getEnv("S3_SECRET_KEY", ""),
+		UseSSL:    getEnv("S3_USE_SSL", "false") == "true",
+	}
+}
+
+// s3Uploader uploads local files to one bucket
+type s3Uploader struct {
+	client *minio.Client
+	bucket string
+}
+
+func newS3Uploader(cfg S3Config) (*s3Uploader, error) {
+	client, err := minio.New(cfg.Endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(cfg.AccessKey, cfg.SecretKey, ""),
+		Secure: cfg.UseSSL,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("create S3 client: %w", err)
+	}
+	return &s3Uploader{client: client, bucket: cfg.Bucket}, nil
+}
+
+func (u *s3Uploader) Upload(ctx context.Context, path, key, contentType string) error {
+	_, err := u.client.FPutObject(ctx, u.bucket, key, path, minio.PutObjectOptions{
+		ContentType: contentType,
+	})
+	if err != nil {
+		return fmt.Errorf("upload %s to s3://%s/%s: %w", path, u.bucket, key, err)
+	}
+	return nil
+}
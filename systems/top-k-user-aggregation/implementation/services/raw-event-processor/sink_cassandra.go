@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/system-design-lab/pkg/startup"
+	"github.com/system-design-lab/pkg/storage"
+)
+
+// CassandraSink writes events to user_listen_history. Every row is written
+// with an explicit TTL so retention follows HISTORY_TTL, not the table default.
+type CassandraSink struct {
+	session *storage.Session
+	history *storage.ListenHistoryRepo
+	ttl     time.Duration // 0 = keep forever
+	dedup   bool          // INSERT ... IF NOT EXISTS, errDuplicate if the row exists
+}
+
+func newCassandraSink(ttl time.Duration, dedup bool) (*CassandraSink, error) {
+	session, err := startup.Cassandra(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	return &CassandraSink{
+		session: session,
+		history: storage.NewListenHistoryRepo(session),
+		ttl:     ttl,
+		dedup:   dedup,
+	}, nil
+}
+
+func (s *CassandraSink) Name() string { return "cassandra" }
+
+func (s *CassandraSink) Write(ctx context.Context, event ListenEvent) error {
+	row := storage.HistoryRow{
+		ListenEvent: event.ListenEvent,
+		HourBucket:  event.HourBucket,
+		Hour:        event.Hour,
+		Weekday:     event.Weekday,
+		Extra:       event.Extra,
+	}
+	// With dedup the insert is a lightweight transaction: replays of the same
+	// event hit the existing primary key and aren't applied
+	err := s.history.Insert(ctx, row, s.ttl, s.dedup)
+	if errors.Is(err, storage.ErrExists) {
+		return errDuplicate
+	}
+	return err
+}
+
+// Flush is a no-op: each insert is acknowledged by Cassandra before Write returns
+func (s *CassandraSink) Flush(ctx context.Context) error { return nil }
+
+func (s *CassandraSink) Close() error {
+	s.session.Close()
+	return nil
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// FileSinkConfig configures the NDJSON file sink
+type FileSinkConfig struct {
+	Dir    string        // local directory for NDJSON files
+	Rotate time.Duration // start a new file every Rotate
+
+	// OnRotate, if set, is called (in the background) with each completed
+	// file. The file is deleted if it returns nil and kept otherwise; kept
+	// files are handed to OnRotate again on the next start. Dir may be
+	// shared by several instances: each file is locked (claim) while it's
+	// written or handed over, so only one instance ever hands it over.
+	OnRotate func(path string) error
+}
+
+// FileSink appends events as newline-delimited JSON to local files, one per
+// rotation period.
+type FileSink struct {
+	cfg    FileSinkConfig
+	mu     sync.Mutex
+	file   *os.File
+	w      *bufio.Writer
+	bucket time.Time // start of the current rotation period
+	wg     sync.WaitGroup
+}
+
+func NewFileSink(cfg FileSinkConfig) (*FileSink, error) {
+	if err := os.MkdirAll(cfg.Dir, 0o755); err != nil {
+		return nil, fmt.Errorf("create %s: %w", cfg.Dir, err)
+	}
+
+	s := &FileSink{cfg: cfg}
+	if cfg.OnRotate != nil {
+		// Files left behind by a previous run are complete — process them now.
+		// Ones another instance is writing or handing over are locked and
+		// skipped.
+		leftovers, _ := filepath.Glob(filepath.Join(cfg.Dir, "*.ndjson"))
+		for _, path := range leftovers {
+			s.rotated(path)
+		}
+	}
+	return s, nil
+}
+
+// newS3FileSink is the `file` sink: NDJSON files, uploaded to
+// s3://bucket/prefix/day=YYYY-MM-DD/ after rotation when S3_BUCKET is set
+func newS3FileSink(dir string, rotate time.Duration, s3cfg S3Config, prefix string) (*FileSink, error) {
+	cfg := FileSinkConfig{Dir: dir, Rotate: rotate}
+	if s3cfg.Bucket != "" {
+		uploader, err := newS3Uploader(s3cfg)
+		if err != nil {
+			return nil, err
+		}
+		cfg.OnRotate = func(path string) error {
+			name := filepath.Base(path)
+			key := fmt.Sprintf("%s/day=%s/%s", prefix, fileDay(name), name)
+			if err := uploader.Upload(context.Background(), path, key, "application/x-ndjson"); err != nil {
+				return err
+			}
+			log.Printf("Uploaded %s to s3://%s/%s", name, s3cfg.Bucket, key)
+			return nil
+		}
+	}
+	return NewFileSink(cfg)
+}
+
+func (s *FileSink) Name() string { return "file" }
+
+func (s *FileSink) Write(ctx context.Context, event ListenEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.rotateIfNeeded(time.Now()); err != nil {
+		return err
+	}
+	if _, err := s.w.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Flush writes buffered lines and fsyncs the current file
+func (s *FileSink) Flush(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.file == nil {
+		return nil
+	}
+	if err := s.w.Flush(); err != nil {
+		return err
+	}
+	return s.file.Sync()
+}
+
+func (s *FileSink) Close() error {
+	s.mu.Lock()
+	err := s.closeCurrent()
+	s.mu.Unlock()
+
+	s.wg.Wait() // let in-flight OnRotate calls finish
+	return err
+}
+
+// rotateIfNeeded opens a new file when the rotation period changes.
+// Caller must hold s.mu.
+func (s *FileSink) rotateIfNeeded(now time.Time) error {
+	bucket := now.Truncate(s.cfg.Rotate)
+	if s.file != nil && bucket.Equal(s.bucket) {
+		return nil
+	}
+	if err := s.closeCurrent(); err != nil {
+		return err
+	}
+
+	// Nanosecond suffix keeps files from different runs/instances apart
+	name := fmt.Sprintf("events-%s-%d.ndjson", bucket.Format("2006-01-02T15-04"), now.UnixNano())
+	f, err := os.OpenFile(filepath.Join(s.cfg.Dir, name), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("open %s: %w", name, err)
+	}
+	// Held until the file is closed, so another instance starting on the
+	// same Dir doesn't take it for a leftover
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		f.Close()
+		return fmt.Errorf("lock %s: %w", name, err)
+	}
+	s.file = f
+	s.w = bufio.NewWriterSize(f, 1<<20)
+	s.bucket = bucket
+	log.Printf("File sink writing to %s", f.Name())
+	return nil
+}
+
+// closeCurrent flushes and closes the current file and hands it to OnRotate.
+// Caller must hold s.mu.
+func (s *FileSink) closeCurrent() error {
+	if s.file == nil {
+		return nil
+	}
+	path := s.file.Name()
+	if err := s.w.Flush(); err != nil {
+		return err
+	}
+	if err := s.file.Sync(); err != nil {
+		return err
+	}
+	if err := s.file.Close(); err != nil {
+		return err
+	}
+	s.file, s.w = nil, nil
+
+	if s.cfg.OnRotate != nil {
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.rotated(path)
+		}()
+	}
+	return nil
+}
+
+// rotated runs OnRotate for a completed file and removes it on success,
+// unless another instance has claimed it
+func (s *FileSink) rotated(path string) {
+	f, err := claim(path)
+	if err != nil {
+		log.Printf("Warning: %v (keeping %s for next start)", err, path)
+		return
+	}
+	if f == nil {
+		log.Printf("Skipping %s: written or handed over by another instance", path)
+		return
+	}
+	defer f.Close() // releases the lock, after the remove
+
+	if err := s.cfg.OnRotate(path); err != nil {
+		log.Printf("Warning: %v (keeping %s for next start)", err, path)
+		return
+	}
+	if err := os.Remove(path); err != nil {
+		log.Printf("Warning: remove %s: %v", path, err)
+	}
+}
+
+// claim takes an exclusive lock (flock) on path without waiting, held
+// until the returned file is closed or the process exits. It returns nil
+// for a file locked by another instance, and for one removed since it was
+// listed (handed over by the instance that held it). Locks are per host:
+// instances sharing Dir over NFS aren't kept apart.
+func claim(path string) (*os.File, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		f.Close()
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("lock %s: %w", path, err)
+	}
+	// The previous holder may have removed it between our open and lock
+	held, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if now, err := os.Stat(path); err != nil || !os.SameFile(held, now) {
+		f.Close()
+		return nil, nil
+	}
+	return f, nil
+}
+
+// fileDay extracts YYYY-MM-DD from a file name like events-2026-01-30T14-00-<nanos>.ndjson
+func fileDay(name string) string {
+	day := strings.TrimPrefix(name, "events-")
+	if len(day) < len("2006-01-02") {
+		return "unknown"
+	}
+	return day[:len("2006-01-02")]
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// archiveRow is the Parquet schema of archived listen events
+type archiveRow struct {
+	EventID    string `parquet:"event_id"`
+	UserID     string `parquet:"user_id,dict"`
+	SongID     string `parquet:"song_id,dict"`
+	Provider   string `parquet:"provider,dict"`
+	ListenedAt int64  `parquet:"listened_at"` // unix seconds
+	HourBucket int64  `parquet:"hour_bucket"` // unix seconds
+	Hour       int32  `parquet:"hour"`
+	Weekday    string `parquet:"weekday,dict"`
+	Source     string `parquet:"source,dict,optional"`
+	Context    string `parquet:"context,optional"`
+	ArtistID   string `parquet:"artist_id,dict,optional"`
+	DurationMs int64  `parquet:"duration_ms,optional"`
+}
+
+// ParquetArchiveConfig configures the Parquet archival sink
+type ParquetArchiveConfig struct {
+	SpoolDir string        // local NDJSON spool, fsynced on every batch
+	Rotate   time.Duration // one Parquet file per day per Rotate period
+	OutDir   string        // local Parquet output when S3 isn't configured
+	S3       S3Config
+	S3Prefix string
+}
+
+// newParquetArchiveSink spools events to local NDJSON (so offsets can be
+// committed safely) and converts each rotated spool file to Parquet, one file
+// per event day, uploaded to s3://bucket/prefix/day=YYYY-MM-DD/.
+func newParquetArchiveSink(cfg ParquetArchiveConfig) (Sink, error) {
+	var uploader *s3Uploader
+	if cfg.S3.Bucket != "" {
+		var err error
+		if uploader, err = newS3Uploader(cfg.S3); err != nil {
+			return nil, err
+		}
+	}
+
+	spool, err := NewFileSink(FileSinkConfig{
+		Dir:    cfg.SpoolDir,
+		Rotate: cfg.Rotate,
+		OnRotate: func(path string) error {
+			return archiveSpoolFile(path, cfg, uploader)
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &namedSink{Sink: spool, name: "parquet"}, nil
+}
+
+// archiveSpoolFile converts one NDJSON spool file into Parquet files and
+// stores them under day=YYYY-MM-DD, deduplicating replayed events by event_id
+func archiveSpoolFile(path string, cfg ParquetArchiveConfig, uploader *s3Uploader) error {
+	byDay, err := readSpoolFile(path)
+	if err != nil {
+		return err
+	}
+
+	base := strings.TrimSuffix(filepath.Base(path), ".ndjson")
+	for day, rows := range byDay {
+		sort.Slice(rows, func(i, j int) bool {
+			if rows[i].UserID != rows[j].UserID {
+				return rows[i].UserID < rows[j].UserID
+			}
+			return rows[i].ListenedAt < rows[j].ListenedAt
+		})
+
+		rel := filepath.Join(fmt.Sprintf("day=%s", day), base+".parquet")
+		local := filepath.Join(cfg.OutDir, rel)
+		if uploader != nil {
+			local = path + "." + day + ".parquet.tmp"
+		}
+		if err := writeParquet(local, rows); err != nil {
+			return err
+		}
+
+		if uploader != nil {
+			key := cfg.S3Prefix + "/" + filepath.ToSlash(rel)
+			err := uploader.Upload(context.Background(), local, key, "application/vnd.apache.parquet")
+			os.Remove(local)
+			if err != nil {
+				return err
+			}
+			log.Printf("Archived %d events to s3://%s/%s", len(rows), cfg.S3.Bucket, key)
+		} else {
+			log.Printf("Archived %d events to %s", len(rows), local)
+		}
+	}
+	return nil
+}
+
+func readSpoolFile(path string) (map[string][]archiveRow, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	seen := make(map[string]bool)
+	byDay := make(map[string][]archiveRow)
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 10<<20)
+	for scanner.Scan() {
+		var e ListenEvent
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			log.Printf("Warning: skipping bad spool line in %s: %v", path, err)
+			continue
+		}
+		if seen[e.EventID] {
+			continue
+		}
+		seen[e.EventID] = true
+
+		day := e.Day()
+		byDay[day] = append(byDay[day], archiveRow{
+			EventID:    e.EventID,
+			UserID:     e.UserID,
+			SongID:     e.SongID,
+			Provider:   e.Provider,
+			ListenedAt: e.ListenedAt,
+			HourBucket: e.HourBucket,
+			Hour:       int32(e.Hour),
+			Weekday:    e.Weekday,
+			Source:     e.Source,
+			Context:    e.Context,
+			ArtistID:   e.ArtistID,
+			DurationMs: e.DurationMs,
+		})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read %s: %w", path, err)
+	}
+	return byDay, nil
+}
+
+func writeParquet(path string, rows []archiveRow) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := parquet.NewGenericWriter[archiveRow](f, parquet.Compression(&parquet.Snappy))
+	if _, err := w.Write(rows); err != nil {
+		return fmt.Errorf("write parquet %s: %w", path, err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("close parquet %s: %w", path, err)
+	}
+	return f.Sync()
+}
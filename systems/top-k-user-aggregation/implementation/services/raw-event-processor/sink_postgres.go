@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	_ "github.com/lib/pq"
+	"github.com/system-design-lab/pkg/startup"
+)
+
+// PostgresSink writes events to the user_listen_history table in PostgreSQL.
+// PostgreSQL has no row TTL, so a background job deletes days older than the
+// retention period instead (retention.go).
+type PostgresSink struct {
+	db    *sql.DB
+	dedup bool // report rows skipped by ON CONFLICT as errDuplicate
+	stop  chan struct{}
+	done  chan struct{}
+}
+
+func NewPostgresSink(url string, cleanup retention, dedup bool) (*PostgresSink, error) {
+	db, err := sql.Open("postgres", url)
+	if err != nil {
+		return nil, fmt.Errorf("open postgres: %w", err)
+	}
+	if err := startup.Wait(context.Background(), "PostgreSQL", db.PingContext); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("ping postgres: %w", err)
+	}
+
+	s := &PostgresSink{
+		db:    db,
+		dedup: dedup,
+		stop:  make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+	cleanup.deleteBefore = s.deleteBefore
+	go func() {
+		defer close(s.done)
+		cleanup.run(s.stop)
+	}()
+	return s, nil
+}
+
+func (s *PostgresSink) deleteBefore(ctx context.Context, cutoff string) (int64, error) {
+	res, err := s.db.ExecContext(ctx, `DELETE FROM user_listen_history WHERE day < $1`, cutoff)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+func (s *PostgresSink) Name() string { return "postgres" }
+
+func (s *PostgresSink) Write(ctx context.Context, event ListenEvent) error {
+	listenedAt := time.Unix(event.ListenedAt, 0)
+
+	var extra sql.NullString
+	if len(event.Extra) > 0 {
+		data, err := json.Marshal(event.Extra)
+		if err != nil {
+			return err
+		}
+		extra = sql.NullString{String: string(data), Valid: true}
+	}
+
+	// event_id is the primary key, so replays are no-ops
+	res, err := s.db.ExecContext(ctx, `
+		INSERT INTO user_listen_history
+			(event_id, user_id, day, listened_at, song_id, provider,
+			 hour_bucket, hour, weekday, source, context, artist_id, duration_ms, extra)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)
+		ON CONFLICT (event_id) DO NOTHING
+	`, event.EventID, event.UserID, event.Day(), listenedAt, event.SongID, event.Provider,
+		time.Unix(event.HourBucket, 0), event.Hour, event.Weekday,
+		nullString(event.Source), nullString(event.Context), nullString(event.ArtistID), nullInt64(event.DurationMs), extra)
+	if err != nil {
+		return err
+	}
+	if s.dedup {
+		if n, err := res.RowsAffected(); err == nil && n == 0 {
+			return errDuplicate
+		}
+	}
+	return nil
+}
+
+// Flush is a no-op: each INSERT is committed before Write returns
+func (s *PostgresSink) Flush(ctx context.Context) error { return nil }
+
+func nullString(v string) sql.NullString {
+	return sql.NullString{String: v, Valid: v != ""}
+}
+
+func nullInt64(v int64) sql.NullInt64 {
+	return sql.NullInt64{Int64: v, Valid: v != 0}
+}
+
+func (s *PostgresSink) Close() error {
+	close(s.stop)
+	<-s.done
+	return s.db.Close()
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"errors"
+
+	"github.com/system-design-lab/pkg/sqlstore"
+	"github.com/system-design-lab/pkg/startup"
+	"github.com/system-design-lab/pkg/storage"
+)
+
+// SQLiteSink writes events to user_listen_history in the SQLite file of
+// STORAGE_DSN (pkg/sqlstore), where the aggregator and api-server of a
+// STORAGE_BACKEND=sqlite stack read it. Like PostgresSink it keys rows by
+// event_id and deletes expired days in the background.
+type SQLiteSink struct {
+	db      *sqlstore.DB
+	history *sqlstore.ListenHistoryRepo
+	dedup   bool // report events already stored as errDuplicate
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+func newSQLiteSink(path string, cleanup retention, dedup bool) (*SQLiteSink, error) {
+	db, err := startup.SQL(context.Background(), sqlstore.Config{Backend: sqlstore.SQLite, DSN: path})
+	if err != nil {
+		return nil, err
+	}
+	s := &SQLiteSink{
+		db:      db,
+		history: sqlstore.NewListenHistoryRepo(db),
+		dedup:   dedup,
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	cleanup.deleteBefore = s.history.DeleteBefore
+	go func() {
+		defer close(s.done)
+		cleanup.run(s.stop)
+	}()
+	return s, nil
+}
+
+func (s *SQLiteSink) Name() string { return "sqlite" }
+
+func (s *SQLiteSink) Write(ctx context.Context, event ListenEvent) error {
+	err := s.history.Insert(ctx, storage.HistoryRow{
+		ListenEvent: event.ListenEvent,
+		HourBucket:  event.HourBucket,
+		Hour:        event.Hour,
+		Weekday:     event.Weekday,
+		Extra:       event.Extra,
+	})
+	if errors.Is(err, storage.ErrExists) {
+		if s.dedup {
+			return errDuplicate
+		}
+		return nil // a replay: the row is already there
+	}
+	return err
+}
+
+// Flush is a no-op: each INSERT is committed before Write returns
+func (s *SQLiteSink) Flush(ctx context.Context) error { return nil }
+
+func (s *SQLiteSink) Close() error {
+	close(s.stop)
+	<-s.done
+	return s.db.Close()
+}
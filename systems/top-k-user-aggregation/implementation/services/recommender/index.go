@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/system-design-lab/pkg/storage"
+	"github.com/system-design-lab/pkg/userstate"
+)
+
+// user is one indexed user: the top songs of its snapshot
+type user struct {
+	id     string
+	songs  []string // ranked, highest first
+	hashes []uint64 // songHashes(songs)
+}
+
+// neighbour is a similar user, by index into index.users
+type neighbour struct {
+	user       int32
+	similarity float64 // Jaccard of the top song sets
+	shared     int     // songs in both
+}
+
+// index is the outcome of one run, replaced whole by the next
+type index struct {
+	computedAt time.Time
+	users      []user
+	byID       map[string]int32
+	similar    [][]neighbour      // by user, most similar first
+	buckets    map[uint64][]int32 // band key to its users, for live lookups
+}
+
+// builder computes an index from the snapshots of one window
+type builder struct {
+	snapshots    snapshotScanner
+	takedowns    *storage.TakedownSet
+	deletedUsers *userstate.Set
+	hasher       *minHasher
+
+	windowDays    int
+	topSongs      int // songs of a snapshot compared
+	minSongs      int // fewer and the user isn't indexed
+	neighbours    int // kept per user
+	minSimilarity float64
+	maxBucket     int // users sharing a band past which it's skipped
+	concurrency   int
+}
+
+func (b *builder) String() string {
+	return fmt.Sprintf("window=%dd top=%d min_songs=%d neighbours=%d min_similarity=%.2f minhash=%dx%d (threshold %.2f) max_bucket=%d",
+		b.windowDays, b.topSongs, b.minSongs, b.neighbours, b.minSimilarity, b.hasher.bands, b.hasher.rows, b.hasher.threshold(), b.maxBucket)
+}
+
+// build reads every snapshot of the window, finds candidate pairs by their
+// MinHash bands and keeps each user's most similar candidates by the exact
+// Jaccard similarity of their top songs
+func (b *builder) build(ctx context.Context) (*index, error) {
+	idx := &index{computedAt: time.Now().UTC(), byID: make(map[string]int32)}
+	err := b.snapshots.Scan(ctx, b.windowDays, func(snap storage.Snapshot) error {
+		if _, deleted := b.deletedUsers.Deleted(snap.UserID); deleted {
+			return nil
+		}
+		songs := make([]string, 0, b.topSongs)
+		for _, s := range snap.Songs {
+			if len(songs) == b.topSongs {
+				break
+			}
+			if !b.takedowns.Has(s.SongID) {
+				songs = append(songs, s.SongID)
+			}
+		}
+		if len(songs) < b.minSongs {
+			metricUsersSkipped.Add(1)
+			return nil
+		}
+		idx.byID[snap.UserID] = int32(len(idx.users))
+		idx.users = append(idx.users, user{id: snap.UserID, songs: songs, hashes: songHashes(songs)})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("scan snapshots: %w", err)
+	}
+
+	// Band keys of every user, then the users of each band bucket
+	keys := make([][]uint64, len(idx.users))
+	b.parallel(len(idx.users), func(i int) {
+		keys[i] = b.hasher.bandKeys(b.hasher.signature(idx.users[i].hashes))
+	})
+	idx.buckets = make(map[uint64][]int32)
+	for i, ks := range keys {
+		for _, k := range ks {
+			idx.buckets[k] = append(idx.buckets[k], int32(i))
+		}
+	}
+	// Buckets of one user are kept: they pair no indexed users, but a user
+	// looked up live may share them
+	shared := 0
+	for k, members := range idx.buckets {
+		switch {
+		case len(members) > b.maxBucket:
+			// A band most users share (a handful of hit songs) says little
+			// and would make its every pair a candidate
+			metricBucketsSkipped.Add(1)
+			delete(idx.buckets, k)
+		case len(members) > 1:
+			shared++
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	var scored atomic.Int64
+	idx.similar = make([][]neighbour, len(idx.users))
+	b.parallel(len(idx.users), func(i int) {
+		best, n := idx.nearest(idx.users[i].hashes, keys[i], int32(i), b.minSimilarity, b.neighbours)
+		scored.Add(int64(n))
+		idx.similar[i] = best
+	})
+
+	metricPairsScored.Add(scored.Load())
+	metricIndexedUsers.Set(int64(len(idx.users)))
+	metricBuckets.Set(int64(shared))
+	return idx, ctx.Err()
+}
+
+// nearest scores the indexed users sharing a band key with hashes, other
+// than self (-1 for a user not indexed), and returns the n most similar of
+// at least minSimilarity and how many were scored
+func (idx *index) nearest(hashes, keys []uint64, self int32, minSimilarity float64, n int) ([]neighbour, int) {
+	seen := make(map[int32]bool)
+	var best []neighbour
+	for _, k := range keys {
+		for _, j := range idx.buckets[k] {
+			if j == self || seen[j] {
+				continue
+			}
+			seen[j] = true
+			sim, shared := jaccard(hashes, idx.users[j].hashes)
+			if sim >= minSimilarity {
+				best = append(best, neighbour{user: j, similarity: sim, shared: shared})
+			}
+		}
+	}
+	slices.SortFunc(best, func(x, y neighbour) int {
+		switch {
+		case x.similarity != y.similarity:
+			if x.similarity > y.similarity {
+				return -1
+			}
+			return 1
+		case x.shared != y.shared:
+			return y.shared - x.shared
+		}
+		return int(x.user - y.user)
+	})
+	if len(best) > n {
+		best = best[:n]
+	}
+	return slices.Clip(best), len(seen)
+}
+
+// parallel calls fn for 0..n-1 on b.concurrency goroutines
+func (b *builder) parallel(n int, fn func(i int)) {
+	var (
+		next atomic.Int64
+		wg   sync.WaitGroup
+	)
+	for w := 0; w < b.concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := int(next.Add(1) - 1); i < n; i = int(next.Add(1) - 1) {
+				fn(i)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// refresher rebuilds the index every interval; requests read the latest
+type refresher struct {
+	builder  *builder
+	interval time.Duration
+	current  atomic.Pointer[index]
+}
+
+// run builds now and then every interval until ctx is done. A failed run
+// keeps serving the previous index.
+func (r *refresher) run(ctx context.Context) {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+	for {
+		start := time.Now()
+		idx, err := r.builder.build(ctx)
+		switch {
+		case ctx.Err() != nil:
+			return
+		case err != nil:
+			metricRunErrors.Add(1)
+			log.Printf("Error computing similar users, serving the previous run: %v", err)
+		default:
+			r.current.Store(idx)
+			metricRuns.Add(1)
+			metricLastRunMillis.Set(time.Since(start).Milliseconds())
+			metricLastRun.Set(idx.computedAt.Unix())
+			log.Printf("Computed similar users of %d users in %s", len(idx.users), time.Since(start).Round(time.Millisecond))
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/system-design-lab/pkg/topkclient"
+)
+
+// errTooFewSongs is a user whose live top-K has fewer than MIN_SONGS songs
+var errTooFewSongs = errors.New("too few songs")
+
+// liveLookup answers users the index doesn't have yet (no snapshot at the
+// last run, or too few songs then) from their current top-K, read from the
+// api-server through pkg/topkclient (TOPK_API_URL), against the indexed
+// users' buckets
+type liveLookup struct {
+	api     *topkclient.Client
+	builder *builder
+}
+
+// lookup returns the user's top songs and their nearest indexed users. The
+// api-server leaves out taken-down songs and answers a deleted user with
+// its user_deleted 404.
+func (l *liveLookup) lookup(ctx context.Context, idx *index, userID string) ([]string, []neighbour, error) {
+	b := l.builder
+	top, err := l.api.TopK(ctx, userID, topkclient.TopKQuery{Days: b.windowDays, K: b.topSongs})
+	if err != nil {
+		return nil, nil, fmt.Errorf("read top-K: %w", err)
+	}
+	songs := make([]string, 0, len(top.Results))
+	for _, s := range top.Results {
+		songs = append(songs, s.SongID)
+	}
+	if len(songs) < b.minSongs {
+		return nil, nil, errTooFewSongs
+	}
+	hashes := songHashes(songs)
+	best, scored := idx.nearest(hashes, b.hasher.bandKeys(b.hasher.signature(hashes)), -1, b.minSimilarity, b.neighbours)
+	metricPairsScored.Add(int64(scored))
+	return songs, best, nil
+}
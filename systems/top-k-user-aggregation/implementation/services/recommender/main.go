@@ -0,0 +1,185 @@
+// Command recommender is a prototype of "listeners like you": every
+// SIMILARITY_INTERVAL it reads the materializer's top-K snapshots of one
+// window, finds pairs of users whose top songs overlap with MinHash and
+// locality-sensitive hashing, and serves each user's most similar users on
+// GET /users/{user_id}/similar.
+//
+// The index lives in memory and is rebuilt whole by each run, so every
+// instance computes its own; it is sized for the lab, not production.
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"runtime"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	topkv1 "github.com/system-design-lab/pkg/gen/topk/v1"
+	"github.com/system-design-lab/pkg/grpcutil"
+	"github.com/system-design-lab/pkg/runtimecfg"
+	"github.com/system-design-lab/pkg/startup"
+	"github.com/system-design-lab/pkg/storage"
+	"github.com/system-design-lab/pkg/topkclient"
+	"github.com/system-design-lab/pkg/topology"
+	"github.com/system-design-lab/pkg/userstate"
+)
+
+func main() {
+	if _, err := topology.Apply("recommender"); err != nil {
+		log.Fatalf("Invalid pipeline config: %v", err)
+	}
+
+	port := getEnv("PORT", "9112")
+	redisAddr := getEnv("REDIS_ADDR", "localhost:6379")
+	interval := getEnvDuration("SIMILARITY_INTERVAL", time.Hour)
+	hashes := getEnvInt("MINHASH_HASHES", 128)
+	bands := getEnvInt("LSH_BANDS", 64)
+	b := &builder{
+		windowDays:    getEnvInt("SIMILARITY_DAYS", 30),
+		topSongs:      getEnvInt("TOP_SONGS", 50),
+		minSongs:      getEnvInt("MIN_SONGS", 5),
+		neighbours:    getEnvInt("SIMILAR_N", 20),
+		minSimilarity: getEnvFloat("MIN_SIMILARITY", 0.05),
+		maxBucket:     getEnvInt("MAX_BUCKET", 1000),
+		concurrency:   getEnvInt("CONCURRENCY", runtime.NumCPU()),
+	}
+	if bands < 1 || hashes < bands || hashes%bands != 0 {
+		log.Fatalf("MINHASH_HASHES (%d) must be a multiple of LSH_BANDS (%d)", hashes, bands)
+	}
+	if b.topSongs < 1 || b.minSongs > b.topSongs || b.neighbours < 1 || b.concurrency < 1 {
+		log.Fatalf("TOP_SONGS, SIMILAR_N and CONCURRENCY must be at least 1, and MIN_SONGS at most TOP_SONGS")
+	}
+	b.hasher = newMinHasher(hashes, bands)
+
+	log.Printf("Starting recommender: interval=%s %v", interval, b)
+
+	session, err := startup.Cassandra(context.Background())
+	if err != nil {
+		log.Fatalf("Failed to connect to Cassandra: %v", err)
+	}
+	defer session.Close()
+	log.Println("Connected to Cassandra")
+
+	rdb := redis.NewClient(&redis.Options{Addr: redisAddr})
+	defer rdb.Close()
+	if err := startup.Redis(context.Background(), rdb); err != nil {
+		log.Fatalf("Failed to connect to Redis: %v", err)
+	}
+	log.Println("Connected to Redis")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-sigChan
+		log.Println("Shutting down...")
+		cancel()
+	}()
+
+	// log_level and SIGHUP reloads of pipeline.yaml's settings (pkg/runtimecfg)
+	if err := runtimecfg.New(rdb, "recommender").Start(ctx); err != nil {
+		log.Printf("Warning: runtime config not loaded: %v", err)
+	}
+
+	// Taken-down songs are neither compared nor shown as shared, and
+	// soft-deleted users neither indexed nor suggested, as the API hides both
+	b.snapshots = storage.NewSnapshotRepo(session)
+	snapshotCfg, err := grpcutil.ConfigFromEnv("SNAPSHOT", "")
+	if err != nil {
+		log.Fatalf("Invalid snapshot reader config: %v", err)
+	}
+	if snapshotCfg.Addr != "" {
+		conn, err := snapshotCfg.Dial("topk.v1.SnapshotReader")
+		if err != nil {
+			log.Fatalf("Failed to dial the snapshot reader: %v", err)
+		}
+		defer conn.Close()
+		b.snapshots = rpcSnapshots{
+			client:  topkv1.NewSnapshotReaderClient(conn),
+			k:       b.topSongs,
+			timeout: getEnvDuration("SNAPSHOT_SCAN_TIMEOUT", 10*time.Minute),
+		}
+		log.Printf("Reading snapshots from the snapshot reader at %s", snapshotCfg)
+	}
+	b.takedowns = storage.NewTakedownSet(storage.NewTakedownRepo(session))
+	if err := b.takedowns.Start(ctx); err != nil {
+		log.Printf("Warning: failed to load song takedowns, retrying every %s: %v", storage.TakedownRefresh, err)
+	}
+	b.deletedUsers = userstate.NewSet(rdb)
+	if err := b.deletedUsers.Start(ctx); err != nil {
+		log.Printf("Warning: failed to load deleted users, retrying every %s: %v", userstate.Refresh, err)
+	}
+
+	r := &refresher{builder: b, interval: interval}
+	srv := &server{refresher: r, takedowns: b.takedowns, deletedUsers: b.deletedUsers, windowDays: b.windowDays, maxN: b.neighbours}
+	if os.Getenv("TOPK_API_URL") != "" {
+		apiCfg, err := topkclient.ConfigFromEnv("")
+		if err != nil {
+			log.Fatalf("Invalid api-server config: %v", err)
+		}
+		if b.topSongs > 100 || b.windowDays > 30 {
+			log.Fatalf("TOP_SONGS (%d) and SIMILARITY_DAYS (%d) must be at most 100 and 30 to look users up live", b.topSongs, b.windowDays)
+		}
+		srv.live = &liveLookup{api: topkclient.New(apiCfg), builder: b}
+		log.Printf("Looking up users not indexed on the api-server at %s", apiCfg)
+	}
+
+	http.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+	http.Handle("/users/", srv)
+	go func() {
+		log.Printf("Listening on :%s, metrics on /debug/vars", port)
+		if err := http.ListenAndServe(":"+port, nil); err != nil {
+			log.Printf("HTTP server error: %v", err)
+		}
+	}()
+
+	r.run(ctx)
+	log.Println("Shutdown complete")
+}
+
+func getEnv(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
+
+func getEnvInt(key string, fallback int) int {
+	if v := os.Getenv(key); v != "" {
+		i, err := strconv.Atoi(v)
+		if err == nil {
+			return i
+		}
+	}
+	return fallback
+}
+
+func getEnvFloat(key string, fallback float64) float64 {
+	if v := os.Getenv(key); v != "" {
+		f, err := strconv.ParseFloat(v, 64)
+		if err == nil {
+			return f
+		}
+	}
+	return fallback
+}
+
+func getEnvDuration(key string, fallback time.Duration) time.Duration {
+	if v := os.Getenv(key); v != "" {
+		d, err := time.ParseDuration(v)
+		if err == nil {
+			return d
+		}
+	}
+	return fallback
+}
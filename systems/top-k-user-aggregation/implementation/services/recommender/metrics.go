@@ -0,0 +1,20 @@
+package main
+
+import "expvar"
+
+// Recommender metrics, served as JSON on PORT/debug/vars
+var (
+	metricRuns            = expvar.NewInt("similarity_runs")
+	metricRunErrors       = expvar.NewInt("similarity_run_errors") // previous index kept
+	metricLastRun         = expvar.NewInt("similarity_last_run")   // unix seconds
+	metricLastRunMillis   = expvar.NewInt("similarity_last_run_ms")
+	metricIndexedUsers    = expvar.NewInt("indexed_users")
+	metricUsersSkipped    = expvar.NewInt("users_skipped") // fewer than MIN_SONGS
+	metricBuckets         = expvar.NewInt("lsh_buckets")   // of two or more users
+	metricBucketsSkipped  = expvar.NewInt("lsh_buckets_skipped")
+	metricPairsScored     = expvar.NewInt("pairs_scored")
+	metricSimilarRequests = expvar.NewInt("similar_requests")
+	metricSimilarNotFound = expvar.NewInt("similar_not_found") // not indexed, nor live
+	metricLiveLookups     = expvar.NewInt("similar_live_lookups")
+	metricLiveErrors      = expvar.NewInt("similar_live_errors")
+)
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"hash/fnv"
+	"math"
+	"slices"
+)
+
+// minHasher computes MinHash signatures: for each of its seeds, the
+// smallest hash of any song of a set. Two sets agree on a seed's minimum
+// with probability their Jaccard similarity, so the share of equal
+// positions estimates it, and bands of equal positions find similar pairs
+// without comparing every pair (locality-sensitive hashing).
+type minHasher struct {
+	seeds []uint64
+	bands int
+	rows  int // positions per band
+}
+
+// newMinHasher returns a hasher of hashes positions split into bands. The
+// seeds are fixed, so signatures compare across runs and instances.
+func newMinHasher(hashes, bands int) *minHasher {
+	m := &minHasher{seeds: make([]uint64, hashes), bands: bands, rows: hashes / bands}
+	state := uint64(0x5eed)
+	for i := range m.seeds {
+		state += 0x9e3779b97f4a7c15
+		m.seeds[i] = mix64(state)
+	}
+	return m
+}
+
+// threshold is the similarity at which a pair becomes a candidate with
+// probability about one half: (1/bands)^(1/rows)
+func (m *minHasher) threshold() float64 {
+	return math.Pow(1/float64(m.bands), 1/float64(m.rows))
+}
+
+// signature of a set of song hashes
+func (m *minHasher) signature(songs []uint64) []uint64 {
+	sig := make([]uint64, len(m.seeds))
+	for i := range sig {
+		sig[i] = math.MaxUint64
+	}
+	for _, s := range songs {
+		for i, seed := range m.seeds {
+			if h := mix64(s ^ seed); h < sig[i] {
+				sig[i] = h
+			}
+		}
+	}
+	return sig
+}
+
+// bandKeys hashes each band of sig, with its index, to the bucket in which
+// sets agreeing on the whole band meet
+func (m *minHasher) bandKeys(sig []uint64) []uint64 {
+	keys := make([]uint64, m.bands)
+	for b := range keys {
+		h := mix64(uint64(b) + 1)
+		for _, v := range sig[b*m.rows : (b+1)*m.rows] {
+			h = mix64(h ^ v)
+		}
+		keys[b] = h
+	}
+	return keys
+}
+
+// songHashes maps song IDs to their sorted, distinct 64-bit hashes
+func songHashes(songIDs []string) []uint64 {
+	out := make([]uint64, 0, len(songIDs))
+	for _, id := range songIDs {
+		h := fnv.New64a()
+		h.Write([]byte(id))
+		out = append(out, h.Sum64())
+	}
+	slices.Sort(out)
+	return slices.Compact(out)
+}
+
+// jaccard is |a ∩ b| / |a ∪ b| of two sorted hash sets, and |a ∩ b|
+func jaccard(a, b []uint64) (float64, int) {
+	shared := 0
+	for i, j := 0, 0; i < len(a) && j < len(b); {
+		switch {
+		case a[i] == b[j]:
+			shared++
+			i++
+			j++
+		case a[i] < b[j]:
+			i++
+		default:
+			j++
+		}
+	}
+	union := len(a) + len(b) - shared
+	if union == 0 {
+		return 0, 0
+	}
+	return float64(shared) / float64(union), shared
+}
+
+// mix64 is splitmix64's finalizer: a cheap, well-spread 64-bit hash
+func mix64(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/system-design-lab/pkg/storage"
+	"github.com/system-design-lab/pkg/topkclient"
+	"github.com/system-design-lab/pkg/userstate"
+)
+
+// similarUser is one entry of a /users/{user_id}/similar response
+type similarUser struct {
+	UserID      string   `json:"user_id"`
+	Similarity  float64  `json:"similarity"`
+	SharedSongs int      `json:"shared_songs"`
+	Songs       []string `json:"songs"` // shared, in the requester's rank order
+}
+
+type similarResponse struct {
+	UserID     string        `json:"user_id"`
+	WindowDays int           `json:"window_days"`
+	ComputedAt time.Time     `json:"computed_at"`
+	Live       bool          `json:"live,omitempty"` // not indexed, compared by its current top-K
+	Similar    []similarUser `json:"similar"`
+}
+
+// server answers from the refresher's latest index
+type server struct {
+	refresher    *refresher
+	takedowns    *storage.TakedownSet
+	deletedUsers *userstate.Set
+	live         *liveLookup // nil without TOPK_API_URL
+	windowDays   int
+	maxN         int // neighbours kept per user
+}
+
+// ServeHTTP handles GET /users/{user_id}/similar?n=10
+func (s *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	userID, route, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/users/"), "/")
+	if userID == "" || route != "similar" {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	metricSimilarRequests.Add(1)
+
+	n := min(10, s.maxN)
+	if v := r.URL.Query().Get("n"); v != "" {
+		var err error
+		if n, err = strconv.Atoi(v); err != nil || n < 1 || n > s.maxN {
+			http.Error(w, "n must be between 1 and "+strconv.Itoa(s.maxN), http.StatusBadRequest)
+			return
+		}
+	}
+
+	if _, deleted := s.deletedUsers.Deleted(userID); deleted {
+		writeJSON(w, http.StatusNotFound, map[string]string{
+			"code":    "user_deleted",
+			"error":   "user is deleted",
+			"user_id": userID,
+		})
+		return
+	}
+	idx := s.refresher.current.Load()
+	if idx == nil {
+		w.Header().Set("Retry-After", "60")
+		http.Error(w, "similarities not computed yet", http.StatusServiceUnavailable)
+		return
+	}
+	resp := similarResponse{UserID: userID, WindowDays: s.windowDays, ComputedAt: idx.computedAt, Similar: []similarUser{}}
+	var (
+		songs   []string
+		similar []neighbour
+	)
+	if i, ok := idx.byID[userID]; ok {
+		songs, similar = idx.users[i].songs, idx.similar[i]
+	} else if s.live == nil {
+		metricSimilarNotFound.Add(1)
+		http.Error(w, "no snapshot of user with enough songs", http.StatusNotFound)
+		return
+	} else {
+		metricLiveLookups.Add(1)
+		var err error
+		songs, similar, err = s.live.lookup(r.Context(), idx, userID)
+		switch {
+		case topkclient.IsUserDeleted(err):
+			writeJSON(w, http.StatusNotFound, map[string]string{
+				"code":    "user_deleted",
+				"error":   "user is deleted",
+				"user_id": userID,
+			})
+			return
+		case topkclient.IsNotFound(err), errors.Is(err, errTooFewSongs):
+			metricSimilarNotFound.Add(1)
+			http.Error(w, "no top-K of user with enough songs", http.StatusNotFound)
+			return
+		case err != nil:
+			metricLiveErrors.Add(1)
+			log.Printf("Warning: live lookup of %s failed: %v", userID, err)
+			http.Error(w, "user not indexed and the api-server is unavailable", http.StatusBadGateway)
+			return
+		}
+		resp.Live = true
+	}
+
+	for _, nb := range similar {
+		if len(resp.Similar) == n {
+			break
+		}
+		other := idx.users[nb.user]
+		// Deleted or taken down since the run
+		if _, deleted := s.deletedUsers.Deleted(other.id); deleted {
+			continue
+		}
+		theirs := make(map[string]bool, len(other.songs))
+		for _, song := range other.songs {
+			theirs[song] = true
+		}
+		entry := similarUser{UserID: other.id, Similarity: nb.similarity, SharedSongs: nb.shared, Songs: []string{}}
+		for _, song := range songs {
+			if theirs[song] && !s.takedowns.Has(song) {
+				entry.Songs = append(entry.Songs, song)
+			}
+		}
+		resp.Similar = append(resp.Similar, entry)
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+
+	topkv1 "github.com/system-design-lab/pkg/gen/topk/v1"
+	"github.com/system-design-lab/pkg/storage"
+)
+
+// snapshotScanner reads every snapshot of a window: storage.SnapshotRepo in
+// Cassandra, or rpcSnapshots from the api-server
+type snapshotScanner interface {
+	Scan(ctx context.Context, windowDays int, fn func(storage.Snapshot) error) error
+}
+
+// rpcSnapshots streams the snapshots from the api-server's SnapshotReader
+// (SNAPSHOT_ADDR). The api-server leaves out taken-down songs and deleted
+// users already.
+type rpcSnapshots struct {
+	client  topkv1.SnapshotReaderClient
+	k       int           // songs per snapshot
+	timeout time.Duration // of a whole scan
+}
+
+func (r rpcSnapshots) Scan(ctx context.Context, windowDays int, fn func(storage.Snapshot) error) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+	stream, err := r.client.ListSnapshots(ctx, &topkv1.ListSnapshotsRequest{WindowDays: int32(windowDays), K: int32(r.k)})
+	if err != nil {
+		return err
+	}
+	for {
+		s, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		snap := storage.Snapshot{UserID: s.UserId, WindowDays: int(s.WindowDays), ComputedAt: s.ComputedAt.AsTime()}
+		snap.Songs = make([]storage.SongCount, len(s.Songs))
+		for i, sc := range s.Songs {
+			snap.Songs[i] = storage.SongCount{SongID: sc.SongId, Count: sc.Count}
+		}
+		if err := fn(snap); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,225 @@
+// Command redelivery moves messages from the retry topics back to the topic
+// they failed in once their delay is up, so a consumer's transient failure
+// (a store timing out) is retried minutes later instead of blocking its
+// partition or going to the DLQ. Each retry topic has its own consumer
+// group: a message waits, holding up the ones behind it, which all came
+// later with the same delay.
+//
+// Redelivered messages keep their key, value and headers, retry.attempt
+// included, so a consumer failing again sends them to the next retry topic.
+// Every consumer of the source topic sees them again, as with a replay:
+// they dedup by event ID.
+package main
+
+import (
+	"context"
+	"expvar"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/system-design-lab/pkg/kafkautil"
+	"github.com/system-design-lab/pkg/runtimecfg"
+	"github.com/system-design-lab/pkg/startup"
+	"github.com/system-design-lab/pkg/topology"
+)
+
+func main() {
+	if _, err := topology.Apply("redelivery"); err != nil {
+		log.Fatalf("Invalid pipeline config: %v", err)
+	}
+
+	retryTopics := getEnv("RETRY_TOPICS", "user.listen.retry.5m,user.listen.retry.1h")
+	consumerGroup := getEnv("CONSUMER_GROUP", "redelivery")
+	port := getEnv("PORT", "9113")
+
+	tiers, err := kafkautil.ParseRetryTopics(retryTopics)
+	if err != nil {
+		log.Fatalf("Invalid RETRY_TOPICS: %v", err)
+	}
+	if len(tiers) == 0 {
+		log.Fatalf("RETRY_TOPICS names no topic")
+	}
+
+	kafkaCfg, err := kafkautil.ConfigFromEnv("localhost:29092")
+	if err != nil {
+		log.Fatalf("Invalid Kafka config: %v", err)
+	}
+
+	log.Printf("Starting redelivery: kafka=%v retry_topics=%s group=%s", kafkaCfg.Brokers, retryTopics, consumerGroup)
+
+	if err := startup.Kafka(context.Background(), kafkaCfg); err != nil {
+		log.Fatalf("Failed to connect to Kafka: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-sigChan
+		log.Println("Shutting down...")
+		cancel()
+	}()
+
+	// log_level and SIGHUP reloads of pipeline.yaml's settings (pkg/runtimecfg)
+	if err := runtimecfg.New(nil, "redelivery").Start(ctx); err != nil {
+		log.Printf("Warning: runtime config not loaded: %v", err)
+	}
+
+	http.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+	go func() {
+		log.Printf("Metrics on http://:%s/debug/vars", port)
+		if err := http.ListenAndServe(":"+port, nil); err != nil {
+			log.Printf("HTTP server error: %v", err)
+		}
+	}()
+
+	w := &writers{cfg: kafkaCfg, wc: kafkautil.WriterConfigFromEnv(), byTopic: make(map[string]*kafka.Writer)}
+	defer w.close()
+
+	var wg sync.WaitGroup
+	for _, t := range tiers {
+		// One group per tier, named after its delay: redelivery-5m
+		group := consumerGroup + "-" + t.Topic[strings.LastIndexByte(t.Topic, '.')+1:]
+		reader := kafkaCfg.NewReader(kafkautil.ReaderConfig{Topic: t.Topic, GroupID: group})
+		defer reader.Close()
+		log.Printf("Redelivering %s after %s (group %s)", t.Topic, t.Delay, group)
+
+		wg.Add(1)
+		go func(t kafkautil.RetryTier) {
+			defer wg.Done()
+			run(ctx, reader, t, w)
+		}(t)
+	}
+	wg.Wait()
+
+	log.Println("Shutdown complete")
+}
+
+// run redelivers a tier's messages in order, each once due, until ctx is
+// done. A message is committed only once it's back in its source topic.
+func run(ctx context.Context, reader *kafka.Reader, t kafkautil.RetryTier, w *writers) {
+	for {
+		msg, err := reader.FetchMessage(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			log.Printf("Error fetching from %s: %v", t.Topic, err)
+			continue
+		}
+
+		due := kafkautil.RetryDue(msg)
+		if due.IsZero() {
+			due = msg.Time.Add(t.Delay)
+		}
+		if wait := time.Until(due); wait > 0 {
+			metricWaiting.Add(t.Topic, 1)
+			select {
+			case <-ctx.Done():
+				metricWaiting.Add(t.Topic, -1)
+				return // uncommitted: redelivered after the restart
+			case <-time.After(wait):
+			}
+			metricWaiting.Add(t.Topic, -1)
+		}
+
+		source, ok := kafkautil.Header(msg, kafkautil.HeaderRetrySourceTopic)
+		if !ok || source == "" {
+			metricInvalid.Add(1)
+			log.Printf("Warning: %s message without %s dropped (partition=%d offset=%d)",
+				t.Topic, kafkautil.HeaderRetrySourceTopic, msg.Partition, msg.Offset)
+		} else if !w.publish(ctx, source, redelivered(msg)) {
+			return
+		} else {
+			metricRedelivered.Add(t.Topic, 1)
+			late := new(expvar.Int)
+			late.Set(time.Since(due).Milliseconds())
+			metricLateness.Set(t.Topic, late)
+		}
+
+		if err := kafkautil.CommitWithRetry(ctx, reader, 3, msg); err != nil {
+			metricCommitErrs.Add(1)
+			log.Printf("Error committing %s offset %d: %v", t.Topic, msg.Offset, err)
+		}
+	}
+}
+
+// redelivered is msg as it goes back: same key, value and headers, but for
+// its due time
+func redelivered(msg kafka.Message) kafka.Message {
+	headers := make([]kafka.Header, 0, len(msg.Headers))
+	for _, h := range msg.Headers {
+		if h.Key != kafkautil.HeaderRetryDue {
+			headers = append(headers, h)
+		}
+	}
+	return kafka.Message{Key: msg.Key, Value: msg.Value, Headers: headers}
+}
+
+// writers holds a producer per source topic
+type writers struct {
+	cfg kafkautil.Config
+	wc  kafkautil.WriterConfig
+
+	mu      sync.Mutex
+	byTopic map[string]*kafka.Writer
+}
+
+// publish writes msg to topic, retrying with backoff until it's written or
+// ctx is done; it reports whether it was written
+func (w *writers) publish(ctx context.Context, topic string, msg kafka.Message) bool {
+	w.mu.Lock()
+	wr, ok := w.byTopic[topic]
+	if !ok {
+		wr = w.cfg.NewWriter(topic, w.wc)
+		w.byTopic[topic] = wr
+	}
+	w.mu.Unlock()
+
+	backoff := time.Second
+	for {
+		err := wr.WriteMessages(ctx, msg)
+		if err == nil {
+			return true
+		}
+		if ctx.Err() != nil {
+			return false
+		}
+		metricErrors.Add(1)
+		log.Printf("Error redelivering to %s: %v (retrying in %s)", topic, err, backoff)
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > 30*time.Second {
+			backoff = 30 * time.Second
+		}
+	}
+}
+
+func (w *writers) close() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, wr := range w.byTopic {
+		wr.Close()
+	}
+}
+
+func getEnv(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
@@ -0,0 +1,14 @@
+package main
+
+import "expvar"
+
+// Redelivery metrics, served as JSON on PORT/debug/vars; maps are keyed by
+// retry topic
+var (
+	metricRedelivered = expvar.NewMap("redelivered")        // moved back to their source topic
+	metricWaiting     = expvar.NewMap("redelivery_waiting") // held until due: 1 while a tier waits
+	metricLateness    = expvar.NewMap("redelivery_late_ms") // of the last message, past its due time
+	metricErrors      = expvar.NewInt("redelivery_errors")  // failed publishes, retried
+	metricInvalid     = expvar.NewInt("redelivery_invalid") // without a retry.source.topic, dropped
+	metricCommitErrs  = expvar.NewInt("redelivery_commit_errors")
+)
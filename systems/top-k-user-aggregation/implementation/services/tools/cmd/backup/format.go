@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// counterRecord is one counter in a backup file, in both formats
+type counterRecord struct {
+	UserID string `json:"user_id" parquet:"user_id,dict"`
+	Day    string `json:"day" parquet:"day,dict"`
+	SongID string `json:"song_id" parquet:"song_id,dict"`
+	Count  int64  `json:"listen_count" parquet:"listen_count"`
+}
+
+// Backup files are chosen by extension:
+//
+//	.jsonl.gz  gzip-compressed JSON lines
+//	.jsonl     plain JSON lines
+//	.parquet   Parquet, snappy-compressed
+const (
+	extJSONLGzip = ".jsonl.gz"
+	extJSONL     = ".jsonl"
+	extParquet   = ".parquet"
+)
+
+func checkExt(path string) error {
+	for _, ext := range []string{extJSONLGzip, extJSONL, extParquet} {
+		if strings.HasSuffix(path, ext) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s: want a %s, %s or %s file", path, extJSONLGzip, extJSONL, extParquet)
+}
+
+// recordWriter streams records to a backup file
+type recordWriter interface {
+	Write(counterRecord) error
+	Close() error
+}
+
+func createBackup(path string) (recordWriter, error) {
+	if err := checkExt(path); err != nil {
+		return nil, err
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	if strings.HasSuffix(path, extParquet) {
+		return &parquetWriter{f: f, w: parquet.NewGenericWriter[counterRecord](f, parquet.Compression(&parquet.Snappy))}, nil
+	}
+	w := &jsonlWriter{f: f}
+	if strings.HasSuffix(path, extJSONLGzip) {
+		w.gz = gzip.NewWriter(f)
+		w.buf = bufio.NewWriter(w.gz)
+	} else {
+		w.buf = bufio.NewWriter(f)
+	}
+	w.enc = json.NewEncoder(w.buf)
+	return w, nil
+}
+
+type jsonlWriter struct {
+	f   *os.File
+	gz  *gzip.Writer // nil for plain .jsonl
+	buf *bufio.Writer
+	enc *json.Encoder
+}
+
+func (w *jsonlWriter) Write(r counterRecord) error { return w.enc.Encode(r) }
+
+func (w *jsonlWriter) Close() error {
+	if err := w.buf.Flush(); err != nil {
+		w.f.Close()
+		return err
+	}
+	if w.gz != nil {
+		if err := w.gz.Close(); err != nil {
+			w.f.Close()
+			return err
+		}
+	}
+	return closeSynced(w.f)
+}
+
+// parquetWriter buffers rows and writes them a row group at a time
+type parquetWriter struct {
+	f    *os.File
+	w    *parquet.GenericWriter[counterRecord]
+	rows []counterRecord
+}
+
+const parquetChunk = 10000
+
+func (w *parquetWriter) Write(r counterRecord) error {
+	w.rows = append(w.rows, r)
+	if len(w.rows) < parquetChunk {
+		return nil
+	}
+	return w.flush()
+}
+
+func (w *parquetWriter) flush() error {
+	if _, err := w.w.Write(w.rows); err != nil {
+		return err
+	}
+	w.rows = w.rows[:0]
+	return nil
+}
+
+func (w *parquetWriter) Close() error {
+	if err := w.flush(); err != nil {
+		w.f.Close()
+		return err
+	}
+	if err := w.w.Close(); err != nil {
+		w.f.Close()
+		return err
+	}
+	return closeSynced(w.f)
+}
+
+func closeSynced(f *os.File) error {
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// readBackup calls fn for every record of a backup file
+func readBackup(path string, fn func(counterRecord) error) error {
+	if err := checkExt(path); err != nil {
+		return err
+	}
+	if strings.HasSuffix(path, extParquet) {
+		rows, err := parquet.ReadFile[counterRecord](path)
+		if err != nil {
+			return err
+		}
+		for _, r := range rows {
+			if err := fn(r); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var in io.Reader = f
+	if strings.HasSuffix(path, extJSONLGzip) {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		in = gz
+	}
+
+	sc := bufio.NewScanner(in)
+	sc.Buffer(make([]byte, 64*1024), 1<<20)
+	for line := 1; sc.Scan(); line++ {
+		var r counterRecord
+		if err := json.Unmarshal(sc.Bytes(), &r); err != nil {
+			return fmt.Errorf("%s line %d: %w", path, line, err)
+		}
+		if err := fn(r); err != nil {
+			return err
+		}
+	}
+	return sc.Err()
+}
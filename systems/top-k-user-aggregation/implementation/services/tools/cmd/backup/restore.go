@@ -0,0 +1,238 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/system-design-lab/pkg/storage"
+)
+
+type partitionKey struct{ user, day string }
+
+func runRestore(args []string) {
+	fs := flag.NewFlagSet("restore", flag.ExitOnError)
+	in := fs.String("in", "", "backup file (.jsonl.gz, .jsonl or .parquet)")
+	from := fs.String("from", "", "only restore days from this one (YYYY-MM-DD)")
+	to := fs.String("to", "", "only restore days up to this one, inclusive (default -from)")
+	blind := fs.Bool("blind", false, "increment by the backed-up counts without reading the target (empty keyspace only)")
+	concurrency := fs.Int("concurrency", 16, "partitions restored in parallel")
+	dryRun := fs.Bool("dry-run", false, "print the plan without writing")
+	timeout := fs.Duration("timeout", time.Hour, "overall timeout")
+	fs.Parse(args)
+
+	if *in == "" {
+		log.Fatalf("-in is required")
+	}
+	var keep map[string]bool
+	if *from != "" {
+		days, err := dayRange(*from, *to)
+		if err != nil {
+			log.Fatalf("Invalid day range: %v", err)
+		}
+		keep = make(map[string]bool, len(days))
+		for _, d := range days {
+			keep[d] = true
+		}
+	}
+
+	// Group by partition so each one is read and diffed once
+	parts := make(map[partitionKey]map[string]int64)
+	rows := 0
+	err := readBackup(*in, func(r counterRecord) error {
+		if keep != nil && !keep[r.Day] {
+			return nil
+		}
+		k := partitionKey{r.UserID, r.Day}
+		if parts[k] == nil {
+			parts[k] = make(map[string]int64)
+		}
+		parts[k][r.SongID] += r.Count
+		rows++
+		return nil
+	})
+	if err != nil {
+		log.Fatalf("Read %s: %v", *in, err)
+	}
+
+	cfg, err := storage.ConfigFromEnv()
+	if err != nil {
+		log.Fatalf("Invalid Cassandra config: %v", err)
+	}
+	session, err := storage.Connect(cfg)
+	if err != nil {
+		log.Fatalf("Failed to connect to Cassandra: %v", err)
+	}
+	defer session.Close()
+
+	mode := "diff"
+	if *blind {
+		mode = "blind"
+	}
+	log.Printf("Restoring %d counters in %d partitions from %s into keyspace %s (mode=%s dry_run=%v)",
+		rows, len(parts), *in, cfg.Keyspace, mode, *dryRun)
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	r := &restorer{repo: storage.NewDailyTopKRepo(session), blind: *blind, dryRun: *dryRun}
+	start := time.Now()
+	r.run(ctx, parts, *concurrency)
+
+	verb := "Applied"
+	if *dryRun {
+		verb = "Would apply"
+	}
+	log.Printf("%s %d increments (%d listens) in %s; %d counters already matched",
+		verb, r.increments.Load(), r.listens.Load(), time.Since(start).Round(time.Millisecond), r.matched.Load())
+	if n := r.ahead.Load(); n > 0 {
+		log.Printf("Warning: %d counters are higher in the keyspace than in the backup and were left alone", n)
+	}
+	if n := r.extra.Load(); n > 0 {
+		log.Printf("Warning: %d counters exist in the keyspace but not in the backup", n)
+	}
+	if n := r.failed.Load(); n > 0 {
+		log.Fatalf("%d partitions failed; re-run the restore to finish them (diff mode only applies what's missing)", n)
+	}
+}
+
+// restorer turns backed-up counts into counter increments
+type restorer struct {
+	repo   *storage.DailyTopKRepo
+	blind  bool
+	dryRun bool
+
+	increments, listens, matched, ahead, extra, failed atomic.Int64
+}
+
+func (r *restorer) run(ctx context.Context, parts map[partitionKey]map[string]int64, concurrency int) {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	keys := make([]partitionKey, 0, len(parts))
+	for k := range parts {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].day != keys[j].day {
+			return keys[i].day < keys[j].day
+		}
+		return keys[i].user < keys[j].user
+	})
+
+	work := make(chan partitionKey)
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for k := range work {
+				if err := r.restorePartition(ctx, k, parts[k]); err != nil {
+					log.Printf("Error restoring %s/%s: %v", k.user, k.day, err)
+					r.failed.Add(1)
+				}
+			}
+		}()
+	}
+	for _, k := range keys {
+		work <- k
+	}
+	close(work)
+	wg.Wait()
+}
+
+func (r *restorer) restorePartition(ctx context.Context, k partitionKey, want map[string]int64) error {
+	have := map[string]int64{}
+	if !r.blind {
+		var err error
+		if have, err = r.repo.DayCounts(ctx, k.user, k.day); err != nil {
+			return fmt.Errorf("read current counts: %w", err)
+		}
+		for songID := range have {
+			if _, ok := want[songID]; !ok {
+				r.extra.Add(1)
+			}
+		}
+	}
+
+	for songID, count := range want {
+		delta := count - have[songID]
+		switch {
+		case delta == 0:
+			r.matched.Add(1)
+			continue
+		case delta < 0:
+			// Something wrote after the backup; decrementing would lose it
+			r.ahead.Add(1)
+			continue
+		}
+		if r.dryRun {
+			fmt.Printf("%s %s %s +%d\n", k.user, k.day, songID, delta)
+		} else if err := r.repo.Increment(ctx, k.user, k.day, songID, delta); err != nil {
+			return fmt.Errorf("increment %s: %w", songID, err)
+		}
+		r.increments.Add(1)
+		r.listens.Add(delta)
+	}
+	return nil
+}
+
+func loadUsers(list, file string) ([]string, error) {
+	var ids []string
+	for _, id := range strings.Split(list, ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			ids = append(ids, id)
+		}
+	}
+	if file != "" {
+		f, err := os.Open(file)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		sc := bufio.NewScanner(f)
+		for sc.Scan() {
+			if id := strings.TrimSpace(sc.Text()); id != "" && !strings.HasPrefix(id, "#") {
+				ids = append(ids, id)
+			}
+		}
+		if err := sc.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return ids, nil
+}
+
+// dayRange returns every day from..to inclusive
+func dayRange(from, to string) ([]string, error) {
+	if from == "" {
+		return nil, fmt.Errorf("-from is required")
+	}
+	if to == "" {
+		to = from
+	}
+	start, err := time.Parse(storage.DayFormat, from)
+	if err != nil {
+		return nil, err
+	}
+	end, err := time.Parse(storage.DayFormat, to)
+	if err != nil {
+		return nil, err
+	}
+	if end.Before(start) {
+		return nil, fmt.Errorf("-to %s is before -from %s", to, from)
+	}
+	var days []string
+	for d := start; !d.After(end); d = d.AddDate(0, 0, 1) {
+		days = append(days, d.Format(storage.DayFormat))
+	}
+	return days, nil
+}
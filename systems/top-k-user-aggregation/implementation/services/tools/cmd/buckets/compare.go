@@ -0,0 +1,248 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/system-design-lab/pkg/storage"
+)
+
+// userDay is one (user_id, day) partition of user_daily_topk
+type userDay struct{ user, day string }
+
+// songDiff is a song whose copy doesn't match user_daily_topk
+type songDiff struct {
+	song        string
+	plain, copy storage.SongTotals
+}
+
+// runCompare checks the aggregator's dual-written copy of user_daily_topk
+// (DUAL_WRITE=bucketed) day by day. A partition that differs is read again
+// after -recheck, so a flush that had written one table but not yet the
+// other isn't reported; with -repair, what still differs is added to the
+// copy, user_daily_topk being the one read until the switch.
+func runCompare(args []string) {
+	fs := flag.NewFlagSet("compare", flag.ExitOnError)
+	from := fs.String("from", "", "first day (YYYY-MM-DD), not before DUAL_WRITE_FROM")
+	to := fs.String("to", "", "last day, inclusive (default -from)")
+	buckets := fs.Int("buckets", 16, "DUAL_WRITE_BUCKETS of the aggregators")
+	users := fs.String("users", "", "comma-separated user IDs to read instead of scanning the table")
+	recheck := fs.Duration("recheck", time.Minute, "wait before reading mismatching partitions again")
+	repair := fs.Bool("repair", false, "make the copy match user_daily_topk where it still differs")
+	concurrency := fs.Int("concurrency", 16, "partitions compared in parallel")
+	show := fs.Int("show", 20, "mismatching songs to print")
+	timeout := fs.Duration("timeout", 2*time.Hour, "overall timeout")
+	fs.Parse(args)
+
+	days, err := dayRange(*from, *to)
+	if err != nil {
+		log.Fatalf("Invalid day range: %v", err)
+	}
+	if *buckets < 2 {
+		log.Fatalf("-buckets must be at least 2")
+	}
+
+	session, err := storage.ConnectFromEnv()
+	if err != nil {
+		log.Fatalf("Failed to connect to Cassandra: %v", err)
+	}
+	defer session.Close()
+	c := &comparer{
+		repo:   storage.NewDailyTopKRepo(session),
+		shadow: storage.NewShadowTopKRepo(session, *buckets),
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	start := time.Now()
+	parts, err := c.partitions(ctx, days, splitList(*users))
+	if err != nil {
+		log.Fatalf("List partitions: %v", err)
+	}
+	log.Printf("Comparing %d partitions of %s..%s with their %d-bucket copy", len(parts), days[0], days[len(days)-1], *buckets)
+
+	diffs := c.run(ctx, parts, *concurrency)
+	if len(diffs) > 0 && *recheck > 0 {
+		log.Printf("%d partitions differ, reading them again in %s", len(diffs), *recheck)
+		time.Sleep(*recheck)
+		again := make([]userDay, 0, len(diffs))
+		for p := range diffs {
+			again = append(again, p)
+		}
+		diffs = c.run(ctx, again, *concurrency)
+	}
+
+	printDiffs(diffs, *show)
+	log.Printf("Compared %d partitions in %s: %d differ, %d failed",
+		len(parts), time.Since(start).Round(time.Millisecond), len(diffs), c.failed.Load())
+
+	if *repair && len(diffs) > 0 {
+		fixed := c.repair(ctx, diffs)
+		log.Printf("Repaired %d songs of the copy", fixed)
+	}
+	if c.failed.Load() > 0 || (len(diffs) > 0 && !*repair) {
+		os.Exit(1)
+	}
+}
+
+// comparer reads both layouts of the daily counters
+type comparer struct {
+	repo   *storage.DailyTopKRepo
+	shadow *storage.ShadowTopKRepo
+
+	failed atomic.Int64
+}
+
+// partitions lists the plain partitions of days: every one the scan finds,
+// or the users' (which also finds days only the copy has). Days the layouts
+// already route to the buckets have no copy.
+func (c *comparer) partitions(ctx context.Context, days, users []string) ([]userDay, error) {
+	want := make(map[string]bool, len(days))
+	for _, d := range days {
+		want[d] = true
+	}
+	var parts []userDay
+	if len(users) > 0 {
+		for _, u := range users {
+			for _, d := range days {
+				if !c.repo.Bucketed(ctx, u, d) {
+					parts = append(parts, userDay{u, d})
+				}
+			}
+		}
+		return parts, nil
+	}
+
+	seen := make(map[userDay]bool)
+	err := c.repo.Scan(ctx, func(row storage.CounterRow) error {
+		p := userDay{row.UserID, row.Day}
+		if row.Bucketed || !want[row.Day] || seen[p] {
+			return nil
+		}
+		seen[p] = true
+		parts = append(parts, p)
+		return nil
+	})
+	return parts, err
+}
+
+// run compares parts and returns the songs that differ, by partition
+func (c *comparer) run(ctx context.Context, parts []userDay, concurrency int) map[userDay][]songDiff {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	var (
+		mu    sync.Mutex
+		diffs = make(map[userDay][]songDiff)
+		work  = make(chan userDay)
+		wg    sync.WaitGroup
+	)
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for p := range work {
+				d, err := c.compare(ctx, p)
+				if err != nil {
+					log.Printf("Error comparing %s/%s: %v", p.user, p.day, err)
+					c.failed.Add(1)
+					continue
+				}
+				if len(d) > 0 {
+					mu.Lock()
+					diffs[p] = d
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	for _, p := range parts {
+		work <- p
+	}
+	close(work)
+	wg.Wait()
+	return diffs
+}
+
+func (c *comparer) compare(ctx context.Context, p userDay) ([]songDiff, error) {
+	plain, err := c.repo.DayTotals(ctx, p.user, p.day)
+	if err != nil {
+		return nil, fmt.Errorf("read user_daily_topk: %w", err)
+	}
+	copied, err := c.shadow.DayTotals(ctx, p.user, p.day)
+	if err != nil {
+		return nil, fmt.Errorf("read copy: %w", err)
+	}
+	var diffs []songDiff
+	for song, t := range plain {
+		if copied[song] != t {
+			diffs = append(diffs, songDiff{song: song, plain: t, copy: copied[song]})
+		}
+	}
+	for song, t := range copied {
+		if _, ok := plain[song]; !ok && t != (storage.SongTotals{}) {
+			diffs = append(diffs, songDiff{song: song, copy: t})
+		}
+	}
+	sort.Slice(diffs, func(i, j int) bool { return diffs[i].song < diffs[j].song })
+	return diffs, nil
+}
+
+// repair adds the difference to the copy's counters. A flush landing between
+// compare and repair is applied on top, so repair closed days.
+func (c *comparer) repair(ctx context.Context, diffs map[userDay][]songDiff) int {
+	fixed := 0
+	for p, songs := range diffs {
+		for _, d := range songs {
+			if delta := d.plain.Count - d.copy.Count; delta != 0 {
+				if err := c.shadow.Increment(ctx, p.user, p.day, d.song, delta); err != nil {
+					log.Printf("Error repairing %s/%s %s: %v", p.user, p.day, d.song, err)
+					c.failed.Add(1)
+					continue
+				}
+			}
+			if ms := d.plain.Millis - d.copy.Millis; ms != 0 {
+				if err := c.shadow.IncrementTime(ctx, p.user, p.day, d.song, ms); err != nil {
+					log.Printf("Error repairing %s/%s %s: %v", p.user, p.day, d.song, err)
+					c.failed.Add(1)
+					continue
+				}
+			}
+			fixed++
+		}
+	}
+	return fixed
+}
+
+// printDiffs prints up to show songs as "user day song plain=n/ms copy=n/ms"
+func printDiffs(diffs map[userDay][]songDiff, show int) {
+	parts := make([]userDay, 0, len(diffs))
+	for p := range diffs {
+		parts = append(parts, p)
+	}
+	sort.Slice(parts, func(i, j int) bool {
+		if parts[i].day != parts[j].day {
+			return parts[i].day < parts[j].day
+		}
+		return parts[i].user < parts[j].user
+	})
+	printed := 0
+	for _, p := range parts {
+		for _, d := range diffs[p] {
+			if printed == show {
+				return
+			}
+			fmt.Printf("%s\t%s\t%s\tplain=%d/%dms\tcopy=%d/%dms\n",
+				p.user, p.day, d.song, d.plain.Count, d.plain.Millis, d.copy.Count, d.copy.Millis)
+			printed++
+		}
+	}
+}
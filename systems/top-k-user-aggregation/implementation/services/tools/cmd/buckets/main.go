@@ -0,0 +1,284 @@
+// Command buckets moves users whose daily partitions grow too large onto the
+// bucketed daily counters (user_daily_topk_bucketed), where each day is
+// split over several partitions by song hash.
+//
+//	buckets find -from 2024-05-01 -to 2024-05-07 -min-rows 100000   partitions over the limit (full table scan)
+//	buckets enable -users u1,u2 -buckets 16                         bucket their days from tomorrow on
+//	buckets list                                                    the current layouts
+//	buckets compare -from 2024-05-01 -to 2024-05-07 -buckets 16     check the aggregator's dual-written copy
+//
+// Counters can't be moved atomically, so a user switches at the start of a
+// day: that day and later ones are bucketed, earlier ones stay in
+// user_daily_topk until the counter cleanup removes them. Every service
+// routes by the layouts (pkg/storage DailyTopKRepo), which they reload every
+// storage.BucketRefresh.
+//
+// An aggregator with DUAL_WRITE=bucketed also copies every user's daily
+// counters into the buckets; once compare finds the copy matches, enable
+// -dual-written can switch users over from a past day, history included.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/system-design-lab/pkg/storage"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+	switch os.Args[1] {
+	case "find":
+		runFind(os.Args[2:])
+	case "enable":
+		runEnable(os.Args[2:])
+	case "list":
+		runList(os.Args[2:])
+	case "compare":
+		runCompare(os.Args[2:])
+	default:
+		usage()
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: buckets find|enable|list|compare [flags] (buckets <command> -h for flags)")
+	os.Exit(2)
+}
+
+func runFind(args []string) {
+	fs := flag.NewFlagSet("find", flag.ExitOnError)
+	from := fs.String("from", "", "first day (YYYY-MM-DD)")
+	to := fs.String("to", "", "last day, inclusive (default -from)")
+	minRows := fs.Int("min-rows", 100000, "report partitions with at least this many song rows")
+	timeout := fs.Duration("timeout", 2*time.Hour, "overall timeout")
+	fs.Parse(args)
+
+	days, err := dayRange(*from, *to)
+	if err != nil {
+		log.Fatalf("Invalid day range: %v", err)
+	}
+	want := make(map[string]bool, len(days))
+	for _, d := range days {
+		want[d] = true
+	}
+
+	session, err := storage.ConnectFromEnv()
+	if err != nil {
+		log.Fatalf("Failed to connect to Cassandra: %v", err)
+	}
+	defer session.Close()
+	repo := storage.NewDailyTopKRepo(session)
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	type partition struct {
+		user, day string
+		rows      int
+	}
+	var (
+		found   []partition
+		cur     partition
+		scanned int64
+	)
+	done := func() {
+		if cur.rows >= *minRows {
+			found = append(found, cur)
+		}
+	}
+	start := time.Now()
+	log.Printf("Scanning user_daily_topk for %s..%s partitions with >= %d rows", days[0], days[len(days)-1], *minRows)
+	err = repo.Scan(ctx, func(row storage.CounterRow) error {
+		scanned++
+		if row.Bucketed {
+			return nil // already bounded
+		}
+		if !want[row.Day] {
+			return nil
+		}
+		if row.UserID != cur.user || row.Day != cur.day {
+			done()
+			cur = partition{user: row.UserID, day: row.Day}
+		}
+		cur.rows++
+		return nil
+	})
+	if err != nil {
+		log.Fatalf("Scan failed after %d rows: %v", scanned, err)
+	}
+	done()
+
+	sort.Slice(found, func(i, j int) bool { return found[i].rows > found[j].rows })
+	for _, p := range found {
+		fmt.Printf("%s\t%s\t%d\n", p.user, p.day, p.rows)
+	}
+	log.Printf("Found %d partitions over the limit in %d rows (%s)", len(found), scanned, time.Since(start).Round(time.Millisecond))
+}
+
+func runEnable(args []string) {
+	fs := flag.NewFlagSet("enable", flag.ExitOnError)
+	users := fs.String("users", "", "comma-separated user IDs")
+	buckets := fs.Int("buckets", 16, "partitions per day")
+	from := fs.String("from", "", "first bucketed day, after today (default tomorrow, UTC)")
+	dualWritten := fs.Bool("dual-written", false, "-from was dual-written with -buckets and compared: it may have started")
+	dryRun := fs.Bool("dry-run", false, "print the layouts without writing them")
+	timeout := fs.Duration("timeout", time.Minute, "overall timeout")
+	fs.Parse(args)
+
+	userIDs := splitList(*users)
+	if len(userIDs) == 0 {
+		log.Fatalf("-users is required")
+	}
+	if *buckets < 2 {
+		log.Fatalf("-buckets must be at least 2")
+	}
+	now := time.Now().UTC()
+	fromDay, err := firstDay(*from, now)
+	if *dualWritten {
+		fromDay, err = dualWrittenDay(*from)
+	}
+	if err != nil {
+		log.Fatalf("Invalid -from: %v", err)
+	}
+	today := now.Format(storage.DayFormat)
+
+	session, err := storage.ConnectFromEnv()
+	if err != nil {
+		log.Fatalf("Failed to connect to Cassandra: %v", err)
+	}
+	defer session.Close()
+	repo := storage.NewBucketRepo(session)
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	current, err := repo.List(ctx)
+	if err != nil {
+		log.Fatalf("List layouts: %v", err)
+	}
+	existing := make(map[string]storage.BucketLayout, len(current))
+	for _, l := range current {
+		existing[l.UserID] = l
+	}
+
+	for _, userID := range userIDs {
+		l := storage.BucketLayout{UserID: userID, Buckets: *buckets, FromDay: fromDay}
+		if old, ok := existing[userID]; ok && old.FromDay <= today {
+			// Already bucketed: reads cover every bucket, so more buckets
+			// are safe from now on, fewer would hide the upper ones
+			if *buckets < old.Buckets {
+				log.Fatalf("%s already has %d buckets since %s; they can't be reduced", userID, old.Buckets, old.FromDay)
+			}
+			l.FromDay = old.FromDay
+		}
+		if *dryRun {
+			fmt.Printf("%s\t%d buckets from %s\n", l.UserID, l.Buckets, l.FromDay)
+			continue
+		}
+		if err := repo.Put(ctx, l); err != nil {
+			log.Fatalf("Put %s: %v", userID, err)
+		}
+		log.Printf("%s: %d buckets from %s", l.UserID, l.Buckets, l.FromDay)
+	}
+}
+
+func runList(args []string) {
+	fs := flag.NewFlagSet("list", flag.ExitOnError)
+	timeout := fs.Duration("timeout", time.Minute, "overall timeout")
+	fs.Parse(args)
+
+	session, err := storage.ConnectFromEnv()
+	if err != nil {
+		log.Fatalf("Failed to connect to Cassandra: %v", err)
+	}
+	defer session.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	layouts, err := storage.NewBucketRepo(session).List(ctx)
+	if err != nil {
+		log.Fatalf("List layouts: %v", err)
+	}
+	sort.Slice(layouts, func(i, j int) bool { return layouts[i].UserID < layouts[j].UserID })
+	for _, l := range layouts {
+		fmt.Printf("%s\t%d buckets from %s\t(updated %s)\n", l.UserID, l.Buckets, l.FromDay, l.UpdatedAt.UTC().Format(time.RFC3339))
+	}
+}
+
+// firstDay checks the first bucketed day: it must start after every service
+// has reloaded the layouts, or they would still write today's partition
+// while readers look in the buckets
+func firstDay(from string, now time.Time) (string, error) {
+	tomorrow := now.Truncate(24*time.Hour).AddDate(0, 0, 1)
+	if from == "" {
+		from = tomorrow.Format(storage.DayFormat)
+	}
+	day, err := time.Parse(storage.DayFormat, from)
+	if err != nil {
+		return "", err
+	}
+	if day.Before(tomorrow) {
+		return "", fmt.Errorf("%s has started; the switch must be at a future day", from)
+	}
+	if day.Sub(now) < 2*storage.BucketRefresh {
+		return "", fmt.Errorf("%s starts in under %s, before every service picks the layout up; use a later day", from, 2*storage.BucketRefresh)
+	}
+	return from, nil
+}
+
+// dualWrittenDay checks the first day of a switch onto a dual-written copy.
+// It may have started: every day since is in the buckets already, and
+// services still on the plain table write the copy too until they reload.
+func dualWrittenDay(from string) (string, error) {
+	if from == "" {
+		return "", fmt.Errorf("-dual-written needs -from, the first day compare checked")
+	}
+	_, err := time.Parse(storage.DayFormat, from)
+	return from, err
+}
+
+func splitList(list string) []string {
+	var ids []string
+	for _, id := range strings.Split(list, ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// dayRange returns every day from..to inclusive
+func dayRange(from, to string) ([]string, error) {
+	if from == "" {
+		return nil, fmt.Errorf("-from is required")
+	}
+	if to == "" {
+		to = from
+	}
+	start, err := time.Parse(storage.DayFormat, from)
+	if err != nil {
+		return nil, err
+	}
+	end, err := time.Parse(storage.DayFormat, to)
+	if err != nil {
+		return nil, err
+	}
+	if end.Before(start) {
+		return nil, fmt.Errorf("-to %s is before -from %s", to, from)
+	}
+	var days []string
+	for d := start; !d.After(end); d = d.AddDate(0, 0, 1) {
+		days = append(days, d.Format(storage.DayFormat))
+	}
+	return days, nil
+}
@@ -0,0 +1,129 @@
+// Command experiment registers the experiments listen events can be tagged
+// with, so the aggregator splits their counts out.
+//
+//	experiment add -name provider-v2-b -last 2026-11-15   count tagged listens from today to -last
+//	experiment list                                        registered experiments
+//
+// Tags are only counted on the days an experiment runs: the aggregator and
+// api-server reload the list every storage.ExperimentRefresh, and listens
+// tagged with an unknown or finished experiment count like untagged ones.
+// The counters can't expire, so an experiment lasts at most
+// storage.MaxExperimentDays.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/system-design-lab/pkg/events"
+	"github.com/system-design-lab/pkg/storage"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+	switch os.Args[1] {
+	case "add":
+		runAdd(os.Args[2:])
+	case "list":
+		runList(os.Args[2:])
+	default:
+		usage()
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: experiment add|list [flags] (experiment <command> -h for flags)")
+	os.Exit(2)
+}
+
+func runAdd(args []string) {
+	today := time.Now().UTC().Format(storage.DayFormat)
+	fs := flag.NewFlagSet("add", flag.ExitOnError)
+	name := fs.String("name", "", "experiment tag, as sent in the events' experiment field")
+	description := fs.String("description", "", "what is compared, kept with the experiment")
+	first := fs.String("first", today, "first day counted (YYYY-MM-DD)")
+	last := fs.String("last", "", "last day counted, inclusive (YYYY-MM-DD)")
+	timeout := fs.Duration("timeout", time.Minute, "overall timeout")
+	fs.Parse(args)
+
+	if *name == "" || len(*name) > events.MaxExperimentLen {
+		log.Fatalf("-name is required, at most %d bytes", events.MaxExperimentLen)
+	}
+	firstDay, err := time.Parse(storage.DayFormat, *first)
+	if err != nil {
+		log.Fatalf("Invalid -first: %v", err)
+	}
+	lastDay, err := time.Parse(storage.DayFormat, *last)
+	if err != nil {
+		log.Fatalf("Invalid -last (required): %v", err)
+	}
+	switch {
+	case lastDay.Before(firstDay):
+		log.Fatal("-last is before -first")
+	case *last < today:
+		log.Fatal("-last is in the past: the experiment would count nothing")
+	case lastDay.Sub(firstDay) >= storage.MaxExperimentDays*24*time.Hour:
+		log.Fatalf("An experiment runs at most %d days", storage.MaxExperimentDays)
+	}
+
+	session, err := storage.ConnectFromEnv()
+	if err != nil {
+		log.Fatalf("Failed to connect to Cassandra: %v", err)
+	}
+	defer session.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	// Days already flushed aren't split out again: an earlier -first only
+	// covers listens still to arrive for those days
+	e := storage.Experiment{Name: *name, Description: *description, FirstDay: *first, LastDay: *last}
+	if err := storage.NewExperimentRepo(session).Put(ctx, e); err != nil {
+		log.Fatalf("Register %s: %v", *name, err)
+	}
+	log.Printf("Registered %s (%s to %s); counted within %s", *name, *first, *last, storage.ExperimentRefresh)
+}
+
+func runList(args []string) {
+	fs := flag.NewFlagSet("list", flag.ExitOnError)
+	timeout := fs.Duration("timeout", time.Minute, "overall timeout")
+	fs.Parse(args)
+
+	session, err := storage.ConnectFromEnv()
+	if err != nil {
+		log.Fatalf("Failed to connect to Cassandra: %v", err)
+	}
+	defer session.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	list, err := storage.NewExperimentRepo(session).List(ctx)
+	if err != nil {
+		log.Fatalf("List experiments: %v", err)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].FirstDay < list[j].FirstDay })
+
+	today := time.Now().UTC().Format(storage.DayFormat)
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tFIRST\tLAST\tSTATUS\tDESCRIPTION")
+	for _, e := range list {
+		status := "running"
+		switch {
+		case today < e.FirstDay:
+			status = "scheduled"
+		case today > e.LastDay:
+			status = "ended"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", e.Name, e.FirstDay, e.LastDay, status, e.Description)
+	}
+	tw.Flush()
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/segmentio/kafka-go"
+)
+
+type changeKind int
+
+const (
+	createTopic changeKind = iota
+	addPartitions
+	setConfigs
+	warnOnly // drift the tool won't fix (fewer partitions, replication factor)
+)
+
+// change is one planned action on a topic
+type change struct {
+	kind    changeKind
+	spec    TopicSpec
+	from    int               // current partitions (addPartitions)
+	configs map[string]string // keys to set (setConfigs)
+	note    string
+}
+
+func (c change) String() string {
+	switch c.kind {
+	case createTopic:
+		return fmt.Sprintf("CREATE  %s partitions=%d rf=%d configs=%s", c.spec.Name, c.spec.Partitions, c.spec.ReplicationFactor, formatConfigs(c.spec.Configs))
+	case addPartitions:
+		return fmt.Sprintf("GROW    %s partitions %d -> %d (keys move to new partitions: per-user ordering breaks across the change)", c.spec.Name, c.from, c.spec.Partitions)
+	case setConfigs:
+		return fmt.Sprintf("CONFIG  %s %s", c.spec.Name, formatConfigs(c.configs))
+	default:
+		return fmt.Sprintf("WARN    %s %s", c.spec.Name, c.note)
+	}
+}
+
+type admin struct {
+	client *kafka.Client
+}
+
+// plan compares specs with the cluster and returns the changes needed
+func (a *admin) plan(ctx context.Context, specs []TopicSpec) ([]change, error) {
+	names := make([]string, len(specs))
+	for i, s := range specs {
+		names[i] = s.Name
+	}
+	meta, err := a.client.Metadata(ctx, &kafka.MetadataRequest{Topics: names})
+	if err != nil {
+		return nil, fmt.Errorf("metadata: %w", err)
+	}
+	existing := make(map[string]kafka.Topic)
+	for _, t := range meta.Topics {
+		if t.Error == nil {
+			existing[t.Name] = t
+		} else if !errors.Is(t.Error, kafka.UnknownTopicOrPartition) {
+			return nil, fmt.Errorf("metadata %s: %w", t.Name, t.Error)
+		}
+	}
+
+	var plan []change
+	for _, spec := range specs {
+		t, ok := existing[spec.Name]
+		if !ok {
+			plan = append(plan, change{kind: createTopic, spec: spec})
+			continue
+		}
+
+		switch n := len(t.Partitions); {
+		case n < spec.Partitions:
+			plan = append(plan, change{kind: addPartitions, spec: spec, from: n})
+		case n > spec.Partitions:
+			plan = append(plan, change{kind: warnOnly, spec: spec,
+				note: fmt.Sprintf("has %d partitions, config says %d (partitions can't be removed; update the config)", n, spec.Partitions)})
+		}
+		if len(t.Partitions) > 0 && len(t.Partitions[0].Replicas) != spec.ReplicationFactor {
+			plan = append(plan, change{kind: warnOnly, spec: spec,
+				note: fmt.Sprintf("replication factor is %d, config says %d (needs a partition reassignment)", len(t.Partitions[0].Replicas), spec.ReplicationFactor)})
+		}
+
+		diff, err := a.configDiff(ctx, spec)
+		if err != nil {
+			return nil, err
+		}
+		if len(diff) > 0 {
+			plan = append(plan, change{kind: setConfigs, spec: spec, configs: diff})
+		}
+	}
+	return plan, nil
+}
+
+// configDiff returns declared configs whose current value differs
+func (a *admin) configDiff(ctx context.Context, spec TopicSpec) (map[string]string, error) {
+	if len(spec.Configs) == 0 {
+		return nil, nil
+	}
+	keys := make([]string, 0, len(spec.Configs))
+	for k := range spec.Configs {
+		keys = append(keys, k)
+	}
+	resp, err := a.client.DescribeConfigs(ctx, &kafka.DescribeConfigsRequest{
+		Resources: []kafka.DescribeConfigRequestResource{{
+			ResourceType: kafka.ResourceTypeTopic,
+			ResourceName: spec.Name,
+			ConfigNames:  keys,
+		}},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("describe configs %s: %w", spec.Name, err)
+	}
+
+	current := make(map[string]string)
+	for _, r := range resp.Resources {
+		if r.Error != nil {
+			return nil, fmt.Errorf("describe configs %s: %w", spec.Name, r.Error)
+		}
+		for _, e := range r.ConfigEntries {
+			current[e.ConfigName] = e.ConfigValue
+		}
+	}
+
+	diff := make(map[string]string)
+	for k, v := range spec.Configs {
+		if current[k] != v {
+			diff[k] = v
+		}
+	}
+	return diff, nil
+}
+
+func (a *admin) apply(ctx context.Context, c change) error {
+	switch c.kind {
+	case createTopic:
+		entries := make([]kafka.ConfigEntry, 0, len(c.spec.Configs))
+		for k, v := range c.spec.Configs {
+			entries = append(entries, kafka.ConfigEntry{ConfigName: k, ConfigValue: v})
+		}
+		resp, err := a.client.CreateTopics(ctx, &kafka.CreateTopicsRequest{
+			Topics: []kafka.TopicConfig{{
+				Topic:             c.spec.Name,
+				NumPartitions:     c.spec.Partitions,
+				ReplicationFactor: c.spec.ReplicationFactor,
+				ConfigEntries:     entries,
+			}},
+		})
+		if err != nil {
+			return err
+		}
+		if err := resp.Errors[c.spec.Name]; err != nil && !errors.Is(err, kafka.TopicAlreadyExists) {
+			return err
+		}
+
+	case addPartitions:
+		resp, err := a.client.CreatePartitions(ctx, &kafka.CreatePartitionsRequest{
+			Topics: []kafka.TopicPartitionsConfig{{Name: c.spec.Name, Count: int32(c.spec.Partitions)}},
+		})
+		if err != nil {
+			return err
+		}
+		if err := resp.Errors[c.spec.Name]; err != nil {
+			return err
+		}
+
+	case setConfigs:
+		// Incremental: only the declared keys change, other overrides stay
+		cfgs := make([]kafka.IncrementalAlterConfigsRequestConfig, 0, len(c.configs))
+		for k, v := range c.configs {
+			cfgs = append(cfgs, kafka.IncrementalAlterConfigsRequestConfig{Name: k, Value: v, ConfigOperation: kafka.ConfigOperationSet})
+		}
+		resp, err := a.client.IncrementalAlterConfigs(ctx, &kafka.IncrementalAlterConfigsRequest{
+			Resources: []kafka.IncrementalAlterConfigsRequestResource{{
+				ResourceType: kafka.ResourceTypeTopic,
+				ResourceName: c.spec.Name,
+				Configs:      cfgs,
+			}},
+		})
+		if err != nil {
+			return err
+		}
+		for _, r := range resp.Resources {
+			if r.Error != nil {
+				return r.Error
+			}
+		}
+	}
+	return nil
+}
+
+func formatConfigs(m map[string]string) string {
+	if len(m) == 0 {
+		return "{}"
+	}
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := make([]string, len(keys))
+	for i, k := range keys {
+		parts[i] = k + "=" + m[k]
+	}
+	return "{" + strings.Join(parts, ", ") + "}"
+}
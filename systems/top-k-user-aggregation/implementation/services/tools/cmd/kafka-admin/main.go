@@ -0,0 +1,121 @@
+// Command kafka-admin makes the cluster's topics match a declarative config
+// (kafka/topics.json): missing topics are created, declared topic configs are
+// set, and partition counts are raised when the config asks for more.
+//
+//	kafka-admin -config kafka/topics.json            apply
+//	kafka-admin -config kafka/topics.json -dry-run   show the plan only
+//	kafka-admin -config kafka/topics.json -check     exit 1 if the cluster drifted
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/system-design-lab/pkg/kafkautil"
+)
+
+// TopicSpec is one topic in the config file
+type TopicSpec struct {
+	Name              string            `json:"name"`
+	Partitions        int               `json:"partitions"`
+	ReplicationFactor int               `json:"replication_factor"`
+	Configs           map[string]string `json:"configs"`
+}
+
+type topicsFile struct {
+	Topics []TopicSpec `json:"topics"`
+}
+
+func main() {
+	path := flag.String("config", "kafka/topics.json", "topic config file")
+	dryRun := flag.Bool("dry-run", false, "print the plan without changing anything")
+	check := flag.Bool("check", false, "exit 1 if any change is needed (implies -dry-run)")
+	rf := flag.Int("replication-factor", 0, "override replication_factor of every topic (0 = use config)")
+	timeout := flag.Duration("timeout", time.Minute, "overall timeout")
+	flag.Parse()
+
+	specs, err := loadSpecs(*path, *rf)
+	if err != nil {
+		log.Fatalf("Load %s: %v", *path, err)
+	}
+
+	cfg, err := kafkautil.ConfigFromEnv("localhost:29092")
+	if err != nil {
+		log.Fatalf("Invalid Kafka config: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	admin := &admin{client: cfg.Client()}
+	plan, err := admin.plan(ctx, specs)
+	if err != nil {
+		log.Fatalf("Plan: %v", err)
+	}
+
+	if len(plan) == 0 {
+		log.Printf("All %d topics match %s", len(specs), *path)
+		return
+	}
+	for _, c := range plan {
+		fmt.Println(c)
+	}
+	if *check {
+		os.Exit(1)
+	}
+	if *dryRun {
+		return
+	}
+
+	applied, failed := 0, 0
+	for _, c := range plan {
+		if c.kind == warnOnly {
+			continue
+		}
+		if err := admin.apply(ctx, c); err != nil {
+			log.Printf("FAILED %s: %v", c, err)
+			failed++
+			continue
+		}
+		applied++
+	}
+	if failed > 0 {
+		log.Fatalf("%d of %d changes failed", failed, applied+failed)
+	}
+	log.Printf("Applied %d changes", applied)
+}
+
+func loadSpecs(path string, rfOverride int) ([]TopicSpec, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var f topicsFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+	for i := range f.Topics {
+		t := &f.Topics[i]
+		if t.Name == "" || t.Partitions < 1 {
+			return nil, fmt.Errorf("topic %d: name and partitions >= 1 are required", i)
+		}
+		if seen[t.Name] {
+			return nil, fmt.Errorf("topic %s declared twice", t.Name)
+		}
+		seen[t.Name] = true
+		if rfOverride > 0 {
+			t.ReplicationFactor = rfOverride
+		}
+		if t.ReplicationFactor < 1 {
+			t.ReplicationFactor = 1
+		}
+	}
+	return f.Topics, nil
+}
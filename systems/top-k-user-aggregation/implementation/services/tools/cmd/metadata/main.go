@@ -0,0 +1,381 @@
+// Command metadata loads the song catalog into song_metadata and recomputes
+// the genre/mood rollups (user_daily_tag_topk) after it changes. It also
+// loads the playlists the api-server's filtered top-K resolves.
+//
+//	metadata import -in songs.jsonl                       one SongMetadata JSON object per line
+//	metadata playlists -in playlists.jsonl                one Playlist JSON object per line
+//	metadata backfill -from 2024-05-01 -to 2024-05-07     every user (full table scan)
+//	metadata backfill -from 2024-05-01 -songs s1,s2       only days that played s1 or s2
+//	metadata backfill -from 2024-05-01 -users u1,u2       partition reads
+//
+// The materializer joins counts with metadata when a day is touched, so a
+// metadata change only reaches past days through a backfill. A rollup is a
+// full rewrite of the day from user_daily_topk: re-running one is harmless.
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/system-design-lab/pkg/storage"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+	switch os.Args[1] {
+	case "import":
+		runImport(os.Args[2:])
+	case "playlists":
+		runPlaylists(os.Args[2:])
+	case "backfill":
+		runBackfill(os.Args[2:])
+	default:
+		usage()
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: metadata import|playlists|backfill [flags] (metadata <command> -h for flags)")
+	os.Exit(2)
+}
+
+func runImport(args []string) {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	in := fs.String("in", "", "JSONL file of songs (- for stdin)")
+	dryRun := fs.Bool("dry-run", false, "validate the file without writing")
+	timeout := fs.Duration("timeout", 30*time.Minute, "overall timeout")
+	fs.Parse(args)
+
+	r, closeIn := openInput(*in)
+	defer closeIn()
+
+	var repo *storage.SongMetadataRepo
+	if !*dryRun {
+		session, err := storage.ConnectFromEnv()
+		if err != nil {
+			log.Fatalf("Failed to connect to Cassandra: %v", err)
+		}
+		defer session.Close()
+		repo = storage.NewSongMetadataRepo(session)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	start := time.Now()
+	var n int
+	sc := bufio.NewScanner(r)
+	for line := 1; sc.Scan(); line++ {
+		text := strings.TrimSpace(sc.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		var m storage.SongMetadata
+		if err := json.Unmarshal([]byte(text), &m); err != nil {
+			log.Fatalf("Line %d: %v", line, err)
+		}
+		if m.SongID == "" {
+			log.Fatalf("Line %d: missing song_id", line)
+		}
+		if repo != nil {
+			if err := repo.Put(ctx, m); err != nil {
+				log.Fatalf("Put %s (line %d, %d written before it): %v", m.SongID, line, n, err)
+			}
+		}
+		n++
+	}
+	if err := sc.Err(); err != nil {
+		log.Fatalf("Read %s: %v", *in, err)
+	}
+
+	if *dryRun {
+		log.Printf("Dry run: %d songs valid, nothing written", n)
+		return
+	}
+	log.Printf("Imported %d songs in %s; run `metadata backfill` to update past rollups", n, time.Since(start).Round(time.Millisecond))
+}
+
+// runPlaylists loads playlists, each replacing the stored one whole
+func runPlaylists(args []string) {
+	fs := flag.NewFlagSet("playlists", flag.ExitOnError)
+	in := fs.String("in", "", "JSONL file of playlists (- for stdin)")
+	dryRun := fs.Bool("dry-run", false, "validate the file without writing")
+	timeout := fs.Duration("timeout", 30*time.Minute, "overall timeout")
+	fs.Parse(args)
+
+	r, closeIn := openInput(*in)
+	defer closeIn()
+
+	var repo *storage.PlaylistRepo
+	if !*dryRun {
+		session, err := storage.ConnectFromEnv()
+		if err != nil {
+			log.Fatalf("Failed to connect to Cassandra: %v", err)
+		}
+		defer session.Close()
+		repo = storage.NewPlaylistRepo(session)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	var n int
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 1024*1024) // long playlists are long lines
+	for line := 1; sc.Scan(); line++ {
+		text := strings.TrimSpace(sc.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		var p storage.Playlist
+		if err := json.Unmarshal([]byte(text), &p); err != nil {
+			log.Fatalf("Line %d: %v", line, err)
+		}
+		if p.PlaylistID == "" {
+			log.Fatalf("Line %d: missing playlist_id", line)
+		}
+		if repo != nil {
+			if err := repo.Put(ctx, p); err != nil {
+				log.Fatalf("Put %s (line %d, %d written before it): %v", p.PlaylistID, line, n, err)
+			}
+		}
+		n++
+	}
+	if err := sc.Err(); err != nil {
+		log.Fatalf("Read %s: %v", *in, err)
+	}
+
+	if *dryRun {
+		log.Printf("Dry run: %d playlists valid, nothing written", n)
+		return
+	}
+	log.Printf("Imported %d playlists", n)
+}
+
+func runBackfill(args []string) {
+	fs := flag.NewFlagSet("backfill", flag.ExitOnError)
+	from := fs.String("from", "", "first day (YYYY-MM-DD)")
+	to := fs.String("to", "", "last day, inclusive (default -from)")
+	users := fs.String("users", "", "comma-separated user IDs (default: scan the whole table)")
+	songs := fs.String("songs", "", "comma-separated song IDs: only rebuild days that played one (scan only)")
+	dryRun := fs.Bool("dry-run", false, "count the days that would be rebuilt")
+	timeout := fs.Duration("timeout", 2*time.Hour, "overall timeout")
+	fs.Parse(args)
+
+	days, err := dayRange(*from, *to)
+	if err != nil {
+		log.Fatalf("Invalid day range: %v", err)
+	}
+	userIDs := splitList(*users)
+	songIDs := splitList(*songs)
+	if len(userIDs) > 0 && len(songIDs) > 0 {
+		log.Fatalf("-songs only applies to a full scan, not with -users")
+	}
+
+	session, err := storage.ConnectFromEnv()
+	if err != nil {
+		log.Fatalf("Failed to connect to Cassandra: %v", err)
+	}
+	defer session.Close()
+	b := &backfiller{
+		topk:   storage.NewDailyTopKRepo(session),
+		meta:   storage.NewSongMetadataRepo(session),
+		tags:   storage.NewTagTopKRepo(session),
+		dryRun: *dryRun,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	start := time.Now()
+	if len(userIDs) > 0 {
+		log.Printf("Rebuilding %d users x %d days (%s..%s)", len(userIDs), len(days), days[0], days[len(days)-1])
+		err = b.users(ctx, userIDs, days)
+	} else {
+		log.Printf("Rebuilding %s..%s (full table scan)", days[0], days[len(days)-1])
+		err = b.scan(ctx, days, songIDs)
+	}
+	if err != nil {
+		log.Fatalf("Backfill failed after %d days: %v", b.rebuilt, err)
+	}
+
+	verb := "Rebuilt"
+	if *dryRun {
+		verb = "Dry run: would rebuild"
+	}
+	log.Printf("%s %d user-days in %s", verb, b.rebuilt, time.Since(start).Round(time.Millisecond))
+	if len(userIDs) == 0 {
+		log.Printf("Scanned %d counter rows to find them", b.scanned)
+	}
+}
+
+type backfiller struct {
+	topk   *storage.DailyTopKRepo
+	meta   *storage.SongMetadataRepo
+	tags   *storage.TagTopKRepo
+	dryRun bool
+
+	rebuilt, scanned int64
+}
+
+func (b *backfiller) users(ctx context.Context, userIDs, days []string) error {
+	for _, userID := range userIDs {
+		for _, day := range days {
+			counts, err := b.topk.DayCounts(ctx, userID, day)
+			if err != nil {
+				return err
+			}
+			if err := b.rebuild(ctx, userID, day, counts); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// scan walks user_daily_topk, whose rows arrive grouped by (user, day)
+// partition, and rebuilds each partition in range once its rows are in.
+// Bucketed days arrive as several partitions, so they are rebuilt after the
+// scan from a full read of the day.
+func (b *backfiller) scan(ctx context.Context, days, songIDs []string) error {
+	want := make(map[string]bool, len(days))
+	for _, d := range days {
+		want[d] = true
+	}
+	changed := make(map[string]bool, len(songIDs))
+	for _, s := range songIDs {
+		changed[s] = true
+	}
+
+	var (
+		user, day string
+		counts    map[string]int64
+		hit       bool // the partition played a changed song
+
+		bucketed = make(map[[2]string]bool) // (user, day) -> played a changed song
+	)
+	done := func() error {
+		if counts == nil || (len(changed) > 0 && !hit) {
+			return nil
+		}
+		return b.rebuild(ctx, user, day, counts)
+	}
+
+	err := b.topk.Scan(ctx, func(row storage.CounterRow) error {
+		b.scanned++
+		if !want[row.Day] {
+			return nil
+		}
+		if row.Bucketed {
+			k := [2]string{row.UserID, row.Day}
+			bucketed[k] = bucketed[k] || len(changed) == 0 || changed[row.SongID]
+			return nil
+		}
+		if row.UserID != user || row.Day != day {
+			if err := done(); err != nil {
+				return err
+			}
+			user, day, counts, hit = row.UserID, row.Day, make(map[string]int64), false
+		}
+		counts[row.SongID] += row.Count
+		hit = hit || changed[row.SongID]
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if err := done(); err != nil {
+		return err
+	}
+	for k, hit := range bucketed {
+		if !hit {
+			continue
+		}
+		counts, err := b.topk.DayCounts(ctx, k[0], k[1])
+		if err != nil {
+			return err
+		}
+		if err := b.rebuild(ctx, k[0], k[1], counts); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *backfiller) rebuild(ctx context.Context, userID, day string, counts map[string]int64) error {
+	if len(counts) == 0 {
+		return nil // nothing played, nothing to roll up
+	}
+	b.rebuilt++
+	if b.dryRun {
+		return nil
+	}
+	if err := storage.RebuildTagsFrom(ctx, b.meta, b.tags, userID, day, counts); err != nil {
+		return fmt.Errorf("%s/%s: %w", userID, day, err)
+	}
+	if b.rebuilt%10000 == 0 {
+		log.Printf("Rebuilt %d user-days (at %s/%s)", b.rebuilt, userID, day)
+	}
+	return nil
+}
+
+// openInput opens the -in file, or stdin for -
+func openInput(in string) (io.Reader, func()) {
+	if in == "" {
+		log.Fatalf("-in is required")
+	}
+	if in == "-" {
+		return os.Stdin, func() {}
+	}
+	f, err := os.Open(in)
+	if err != nil {
+		log.Fatalf("Open %s: %v", in, err)
+	}
+	return f, func() { f.Close() }
+}
+
+func splitList(list string) []string {
+	var ids []string
+	for _, id := range strings.Split(list, ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// dayRange returns every day from..to inclusive
+func dayRange(from, to string) ([]string, error) {
+	if from == "" {
+		return nil, fmt.Errorf("-from is required")
+	}
+	if to == "" {
+		to = from
+	}
+	start, err := time.Parse(storage.DayFormat, from)
+	if err != nil {
+		return nil, err
+	}
+	end, err := time.Parse(storage.DayFormat, to)
+	if err != nil {
+		return nil, err
+	}
+	if end.Before(start) {
+		return nil, fmt.Errorf("-to %s is before -from %s", to, from)
+	}
+	var days []string
+	for d := start; !d.After(end); d = d.AddDate(0, 0, 1) {
+		days = append(days, d.Format(storage.DayFormat))
+	}
+	return days, nil
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var migrationFile = regexp.MustCompile(`^(\d+)_([a-z0-9_]+)\.cql$`)
+
+// Migration is one versioned .cql file
+type Migration struct {
+	Version    int
+	Name       string
+	Checksum   string // sha256 of the file
+	Statements []string
+}
+
+// loadMigrations reads and parses every migration in dir, sorted by version
+func loadMigrations(dir string) ([]Migration, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var out []Migration
+	seen := make(map[int]string)
+	for _, e := range entries {
+		if e.IsDir() || filepath.Ext(e.Name()) != ".cql" {
+			continue
+		}
+		m := migrationFile.FindStringSubmatch(e.Name())
+		if m == nil {
+			return nil, fmt.Errorf("%s: want NNNN_description.cql", e.Name())
+		}
+		version, _ := strconv.Atoi(m[1])
+		if version == 0 {
+			return nil, fmt.Errorf("%s: versions start at 1", e.Name())
+		}
+		if prev, dup := seen[version]; dup {
+			return nil, fmt.Errorf("version %d used by both %s and %s", version, prev, e.Name())
+		}
+		seen[version] = e.Name()
+
+		data, err := os.ReadFile(filepath.Join(dir, e.Name()))
+		if err != nil {
+			return nil, err
+		}
+		sum := sha256.Sum256(data)
+		stmts := splitStatements(string(data))
+		if len(stmts) == 0 {
+			return nil, fmt.Errorf("%s: no statements", e.Name())
+		}
+		out = append(out, Migration{
+			Version:    version,
+			Name:       m[2],
+			Checksum:   hex.EncodeToString(sum[:]),
+			Statements: stmts,
+		})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Version < out[j].Version })
+	return out, nil
+}
+
+// splitStatements drops -- and // comments and splits on ';' outside quotes
+func splitStatements(src string) []string {
+	var (
+		stmts []string
+		cur   strings.Builder
+		quote rune
+	)
+	flush := func() {
+		if s := strings.TrimSpace(cur.String()); s != "" {
+			stmts = append(stmts, s)
+		}
+		cur.Reset()
+	}
+
+	runes := []rune(src)
+	for i := 0; i < len(runes); i++ {
+		c := runes[i]
+		if quote != 0 {
+			cur.WriteRune(c)
+			if c == quote {
+				quote = 0
+			}
+			continue
+		}
+		switch {
+		case c == '\'' || c == '"':
+			quote = c
+			cur.WriteRune(c)
+		case (c == '-' || c == '/') && i+1 < len(runes) && runes[i+1] == c:
+			for i < len(runes) && runes[i] != '\n' {
+				i++
+			}
+			cur.WriteRune('\n')
+		case c == ';':
+			flush()
+		default:
+			cur.WriteRune(c)
+		}
+	}
+	flush()
+	return stmts
+}
+
+// verifyChecksums fails if an applied migration's file changed since
+func verifyChecksums(migrations []Migration, applied map[int]appliedVersion) error {
+	for _, m := range migrations {
+		a, ok := applied[m.Version]
+		if ok && a.Checksum != m.Checksum {
+			return fmt.Errorf("migration %04d %s changed after it was applied (checksum %s, recorded %s); add a new migration instead",
+				m.Version, m.Name, m.Checksum[:12], a.Checksum[:min(12, len(a.Checksum))])
+		}
+	}
+	return nil
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gocql/gocql"
+	"github.com/system-design-lab/pkg/storage"
+)
+
+var validKeyspace = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9_]{0,47}$`)
+
+// alterAdd matches ALTER TABLE ... ADD, which has no IF NOT EXISTS before
+// Cassandra 5
+var alterAdd = regexp.MustCompile(`(?is)^\s*ALTER\s+TABLE\s+\S+\s+ADD\s`)
+
+type appliedVersion struct {
+	Name      string
+	Checksum  string
+	AppliedAt time.Time
+}
+
+// migrator holds a session bound to the target keyspace
+type migrator struct {
+	session *storage.Session
+}
+
+// newMigrator creates the keyspace and schema_version table if missing and
+// connects to the keyspace
+func newMigrator(ctx context.Context, cfg storage.Config, replication string) (*migrator, error) {
+	keyspace := cfg.Keyspace
+	if !validKeyspace.MatchString(keyspace) {
+		return nil, fmt.Errorf("invalid keyspace name %q", keyspace)
+	}
+
+	// The keyspace may not exist yet, so bootstrap without one
+	boot := cfg
+	boot.Keyspace = ""
+	bs, err := storage.Connect(boot)
+	if err != nil {
+		return nil, err
+	}
+	err = bs.Gocql().Query(fmt.Sprintf(
+		"CREATE KEYSPACE IF NOT EXISTS %s WITH replication = %s", keyspace, replication,
+	)).WithContext(ctx).Exec()
+	if err == nil {
+		err = bs.Gocql().AwaitSchemaAgreement(ctx)
+	}
+	bs.Close()
+	if err != nil {
+		return nil, fmt.Errorf("create keyspace %s: %w", keyspace, err)
+	}
+
+	s, err := storage.Connect(cfg)
+	if err != nil {
+		return nil, err
+	}
+	err = s.Gocql().Query(`
+		CREATE TABLE IF NOT EXISTS schema_version (
+			version    INT PRIMARY KEY,
+			name       TEXT,
+			checksum   TEXT,
+			applied_at TIMESTAMP
+		)
+	`).WithContext(ctx).Exec()
+	if err != nil {
+		s.Close()
+		return nil, fmt.Errorf("create schema_version: %w", err)
+	}
+	return &migrator{session: s}, nil
+}
+
+func (m *migrator) Close() { m.session.Close() }
+
+// applied returns the recorded versions
+func (m *migrator) applied(ctx context.Context) (map[int]appliedVersion, error) {
+	iter := m.session.Gocql().Query(`SELECT version, name, checksum, applied_at FROM schema_version`).
+		WithContext(ctx).Consistency(gocql.Quorum).Iter()
+
+	out := make(map[int]appliedVersion)
+	var (
+		version int
+		a       appliedVersion
+	)
+	for iter.Scan(&version, &a.Name, &a.Checksum, &a.AppliedAt) {
+		out[version] = a
+	}
+	return out, iter.Close()
+}
+
+// apply runs a migration's statements, waiting for schema agreement after
+// each (DDL on one node isn't visible to the next statement otherwise), then
+// records the version. Cassandra has no transactional DDL: if a statement
+// fails the version isn't recorded and the whole file re-runs next time, so
+// migrations must be idempotent (IF NOT EXISTS / IF EXISTS).
+func (m *migrator) apply(ctx context.Context, mig Migration) error {
+	for i, stmt := range mig.Statements {
+		if err := m.session.Gocql().Query(stmt).WithContext(ctx).Exec(); err != nil {
+			if !columnExists(stmt, err) {
+				return fmt.Errorf("statement %d: %w", i+1, err)
+			}
+			// A re-run of a file that failed after this statement
+			log.Printf("Statement %d of %04d: column already added, skipping", i+1, mig.Version)
+		}
+		if err := m.session.Gocql().AwaitSchemaAgreement(ctx); err != nil {
+			return fmt.Errorf("schema agreement after statement %d: %w", i+1, err)
+		}
+	}
+
+	// IF NOT EXISTS: if two migrators raced, the first record wins
+	_, err := m.session.Gocql().Query(`
+		INSERT INTO schema_version (version, name, checksum, applied_at)
+		VALUES (?, ?, ?, ?) IF NOT EXISTS
+	`, mig.Version, mig.Name, mig.Checksum, time.Now()).WithContext(ctx).MapScanCAS(map[string]interface{}{})
+	return err
+}
+
+// columnExists reports whether err is an ALTER TABLE ADD of a column that is
+// already there, which is how such a statement is made idempotent
+func columnExists(stmt string, err error) bool {
+	return alterAdd.MatchString(stmt) && strings.Contains(err.Error(), "conflicts with an existing column")
+}
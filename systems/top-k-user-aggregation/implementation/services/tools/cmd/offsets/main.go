@@ -0,0 +1,291 @@
+// Command offsets inspects and resets a consumer group's offsets on a topic,
+// e.g. to replay a topic through a consumer in a controlled way.
+//
+//	offsets show -group aggregator -topic user.listen.raw
+//	offsets reset -group aggregator -topic user.listen.raw -to-time 2026-10-14T09:00:00Z
+//	offsets reset -group notifier -topic user.listen.agg -to-offsets 0=1200,1=1185
+//
+// reset prints its plan and asks for the group name before committing. The
+// group's consumers must be stopped: a group with members is refused. A
+// rewind past the dedup window (the aggregator's bloom filters) is refused
+// unless -allow-recount, as the events before it would be counted again.
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/system-design-lab/pkg/kafkautil"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+	switch os.Args[1] {
+	case "show":
+		runShow(os.Args[2:])
+	case "reset":
+		runReset(os.Args[2:])
+	default:
+		usage()
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: offsets show|reset [flags] (offsets <command> -h for flags)")
+	os.Exit(2)
+}
+
+func connect() *groups {
+	cfg, err := kafkautil.ConfigFromEnv("localhost:29092")
+	if err != nil {
+		log.Fatalf("Invalid Kafka config: %v", err)
+	}
+	return &groups{client: cfg.Client()}
+}
+
+func runShow(args []string) {
+	fs := flag.NewFlagSet("show", flag.ExitOnError)
+	group := fs.String("group", "", "consumer group")
+	topic := fs.String("topic", "", "topic")
+	timeout := fs.Duration("timeout", time.Minute, "overall timeout")
+	fs.Parse(args)
+	if *group == "" || *topic == "" {
+		log.Fatal("-group and -topic are required")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	g := connect()
+
+	state, members, err := g.members(ctx, *group)
+	if err != nil {
+		log.Fatal(err)
+	}
+	states, err := g.partitions(ctx, *group, *topic)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Printf("group %s: %s, %d members\n", *group, state, members)
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "PARTITION\tCOMMITTED\tFIRST\tEND\tLAG")
+	var lag int64
+	for _, s := range states {
+		fmt.Fprintf(tw, "%d\t%s\t%d\t%d\t%d\n", s.Partition, formatOffset(s.Committed), s.First, s.End, s.Lag())
+		lag += s.Lag()
+	}
+	fmt.Fprintf(tw, "total\t\t\t\t%d\n", lag)
+	tw.Flush()
+}
+
+func runReset(args []string) {
+	fs := flag.NewFlagSet("reset", flag.ExitOnError)
+	group := fs.String("group", "", "consumer group")
+	topic := fs.String("topic", "", "topic")
+	toTime := fs.String("to-time", "", "first offset written at or after this RFC 3339 time")
+	toOffsets := fs.String("to-offsets", "", "partition=offset pairs, comma-separated (other partitions are left alone)")
+	toEarliest := fs.Bool("to-earliest", false, "oldest retained offset")
+	toLatest := fs.Bool("to-latest", false, "end of each partition: skip the backlog")
+	dedupWindow := fs.Duration("dedup-window", 7*24*time.Hour, "how far back consumers dedup redelivered events (the aggregator keeps 8 days of bloom filters by listen day)")
+	allowRecount := fs.Bool("allow-recount", false, "allow rewinding past -dedup-window, counting those events again")
+	yes := fs.Bool("yes", false, "don't ask for confirmation")
+	dryRun := fs.Bool("dry-run", false, "print the plan only")
+	timeout := fs.Duration("timeout", time.Minute, "overall timeout")
+	fs.Parse(args)
+	if *group == "" || *topic == "" {
+		log.Fatal("-group and -topic are required")
+	}
+	targets := 0
+	for _, set := range []bool{*toTime != "", *toOffsets != "", *toEarliest, *toLatest} {
+		if set {
+			targets++
+		}
+	}
+	if targets != 1 {
+		log.Fatal("exactly one of -to-time, -to-offsets, -to-earliest and -to-latest is required")
+	}
+	var at time.Time
+	if *toTime != "" {
+		var err error
+		if at, err = time.Parse(time.RFC3339, *toTime); err != nil {
+			log.Fatalf("Invalid -to-time: %v", err)
+		}
+	}
+	explicit, err := parseOffsets(*toOffsets)
+	if err != nil {
+		log.Fatalf("Invalid -to-offsets: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	g := connect()
+
+	// Checked again right before the commit, as the confirmation can take a while
+	state, members, err := g.members(ctx, *group)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if members > 0 {
+		log.Fatalf("Group %s is %s with %d members: stop its consumers first", *group, state, members)
+	}
+	states, err := g.partitions(ctx, *group, *topic)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Where each partition goes
+	target := make(map[int]int64)
+	switch {
+	case !at.IsZero():
+		if target, err = g.offsetsAt(ctx, *topic, states, at); err != nil {
+			log.Fatal(err)
+		}
+	case *toEarliest:
+		for _, s := range states {
+			target[s.Partition] = s.First
+		}
+	case *toLatest:
+		for _, s := range states {
+			target[s.Partition] = s.End
+		}
+	default:
+		byID := make(map[int]partitionState)
+		for _, s := range states {
+			byID[s.Partition] = s
+		}
+		for p, o := range explicit {
+			s, ok := byID[p]
+			if !ok {
+				log.Fatalf("Topic %s has no partition %d", *topic, p)
+			}
+			if o < s.First || o > s.End {
+				log.Fatalf("Offset %d of partition %d is outside its retained range %d-%d", o, p, s.First, s.End)
+			}
+			target[p] = o
+		}
+	}
+
+	// The plan, and the oldest message a rewind redelivers
+	fmt.Printf("Reset group %s on %s:\n", *group, *topic)
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "PARTITION\tCOMMITTED\tNEW\tCHANGE")
+	var (
+		redelivered, skipped int64
+		oldest               time.Time
+		changed              = make(map[int]int64)
+	)
+	for _, s := range states {
+		to, ok := target[s.Partition]
+		if !ok || to == s.Committed {
+			continue
+		}
+		changed[s.Partition] = to
+		from := s.Committed
+		if from < 0 {
+			from = s.First
+		}
+		change := "+0"
+		switch {
+		case to < from:
+			redelivered += from - to
+			change = fmt.Sprintf("rewind %d", from-to)
+			if to < s.End {
+				t, err := g.messageTime(ctx, *topic, s.Partition, to)
+				if err != nil {
+					log.Fatal(err)
+				}
+				if oldest.IsZero() || t.Before(oldest) {
+					oldest = t
+				}
+			}
+		case to > from:
+			skipped += to - from
+			change = fmt.Sprintf("skip %d", to-from)
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%d\t%s\n", s.Partition, formatOffset(s.Committed), to, change)
+	}
+	tw.Flush()
+	if len(changed) == 0 {
+		log.Printf("Nothing to change")
+		return
+	}
+	fmt.Printf("%d messages redelivered, %d skipped (never processed by the group)\n", redelivered, skipped)
+
+	if !oldest.IsZero() {
+		fmt.Printf("Oldest redelivered message: %s\n", oldest.UTC().Format(time.RFC3339))
+		if horizon := time.Now().Add(-*dedupWindow); oldest.Before(horizon) {
+			if !*allowRecount {
+				log.Fatalf("Messages before %s are past the %s dedup window and would be counted again; pass -allow-recount if that is the intent, or use the api-server's recompute endpoint to recount a user",
+					horizon.UTC().Format(time.RFC3339), *dedupWindow)
+			}
+			fmt.Printf("WARNING: messages before %s are past the dedup window and will be counted again\n", horizon.UTC().Format(time.RFC3339))
+		} else {
+			fmt.Println("Redelivered events are within the dedup window: consumers that dedup (the aggregator) skip them")
+		}
+	}
+	if *dryRun {
+		return
+	}
+
+	if !*yes {
+		fmt.Printf("Type the group name (%s) to commit: ", *group)
+		line, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+		if strings.TrimSpace(line) != *group {
+			log.Fatal("Not confirmed, nothing changed")
+		}
+	}
+	if _, members, err = g.members(ctx, *group); err != nil {
+		log.Fatal(err)
+	}
+	if members > 0 {
+		log.Fatalf("Group %s has %d members again: nothing changed", *group, members)
+	}
+	if err := g.commit(ctx, *group, *topic, changed); err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("Committed %d partitions of group %s on %s", len(changed), *group, *topic)
+}
+
+// parseOffsets reads "0=1200,1=1185"
+func parseOffsets(s string) (map[int]int64, error) {
+	out := make(map[int]int64)
+	if s == "" {
+		return out, nil
+	}
+	for _, pair := range strings.Split(s, ",") {
+		p, o, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok {
+			return nil, fmt.Errorf("%q is not partition=offset", pair)
+		}
+		partition, err := strconv.Atoi(p)
+		if err != nil || partition < 0 {
+			return nil, fmt.Errorf("invalid partition %q", p)
+		}
+		offset, err := strconv.ParseInt(o, 10, 64)
+		if err != nil || offset < 0 {
+			return nil, fmt.Errorf("invalid offset %q", o)
+		}
+		if _, dup := out[partition]; dup {
+			return nil, fmt.Errorf("partition %d given twice", partition)
+		}
+		out[partition] = offset
+	}
+	return out, nil
+}
+
+func formatOffset(o int64) string {
+	if o < 0 {
+		return "-"
+	}
+	return strconv.FormatInt(o, 10)
+}
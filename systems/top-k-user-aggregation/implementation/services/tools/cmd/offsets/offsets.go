@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// partitionState is one partition of a topic as the group sees it
+type partitionState struct {
+	Partition int
+	Committed int64 // -1 when the group has never committed it
+	First     int64 // oldest offset still retained
+	End       int64 // high watermark: the next offset to be written
+}
+
+// Lag is the messages the group has yet to read
+func (p partitionState) Lag() int64 {
+	if p.Committed < 0 {
+		return p.End - p.First
+	}
+	return p.End - p.Committed
+}
+
+// groups reads and commits a consumer group's offsets on one topic
+type groups struct {
+	client *kafka.Client
+}
+
+// members returns the group's state and its active members
+func (g *groups) members(ctx context.Context, group string) (string, int, error) {
+	resp, err := g.client.DescribeGroups(ctx, &kafka.DescribeGroupsRequest{GroupIDs: []string{group}})
+	if err != nil {
+		return "", 0, fmt.Errorf("describe group: %w", err)
+	}
+	if len(resp.Groups) != 1 {
+		return "", 0, fmt.Errorf("describe group: %d groups in the response", len(resp.Groups))
+	}
+	if err := resp.Groups[0].Error; err != nil {
+		return "", 0, fmt.Errorf("describe group: %w", err)
+	}
+	return resp.Groups[0].GroupState, len(resp.Groups[0].Members), nil
+}
+
+// partitions returns every partition of topic with the group's committed
+// offset and the retained range, by partition
+func (g *groups) partitions(ctx context.Context, group, topic string) ([]partitionState, error) {
+	meta, err := g.client.Metadata(ctx, &kafka.MetadataRequest{Topics: []string{topic}})
+	if err != nil {
+		return nil, fmt.Errorf("metadata: %w", err)
+	}
+	if len(meta.Topics) != 1 || meta.Topics[0].Error != nil {
+		if len(meta.Topics) == 1 {
+			err = meta.Topics[0].Error
+		}
+		return nil, fmt.Errorf("metadata %s: %v", topic, err)
+	}
+	var ids []int
+	for _, p := range meta.Topics[0].Partitions {
+		ids = append(ids, p.ID)
+	}
+	sort.Ints(ids)
+
+	first, err := g.listOffsets(ctx, topic, ids, kafka.FirstOffsetOf)
+	if err != nil {
+		return nil, err
+	}
+	end, err := g.listOffsets(ctx, topic, ids, kafka.LastOffsetOf)
+	if err != nil {
+		return nil, err
+	}
+
+	fetched, err := g.client.OffsetFetch(ctx, &kafka.OffsetFetchRequest{GroupID: group, Topics: map[string][]int{topic: ids}})
+	if err != nil {
+		return nil, fmt.Errorf("fetch offsets: %w", err)
+	}
+	if fetched.Error != nil {
+		return nil, fmt.Errorf("fetch offsets: %w", fetched.Error)
+	}
+	committed := make(map[int]int64)
+	for _, p := range fetched.Topics[topic] {
+		if p.Error != nil {
+			return nil, fmt.Errorf("fetch offset of partition %d: %w", p.Partition, p.Error)
+		}
+		committed[p.Partition] = p.CommittedOffset
+	}
+
+	states := make([]partitionState, len(ids))
+	for i, id := range ids {
+		c, ok := committed[id]
+		if !ok {
+			c = -1
+		}
+		states[i] = partitionState{Partition: id, Committed: c, First: first[id], End: end[id]}
+	}
+	return states, nil
+}
+
+// listOffsets asks for the first or last offset of every partition. They're
+// asked apart: the broker answers both with the same timestamp.
+func (g *groups) listOffsets(ctx context.Context, topic string, ids []int, of func(int) kafka.OffsetRequest) (map[int]int64, error) {
+	reqs := make([]kafka.OffsetRequest, len(ids))
+	for i, id := range ids {
+		reqs[i] = of(id)
+	}
+	resp, err := g.client.ListOffsets(ctx, &kafka.ListOffsetsRequest{Topics: map[string][]kafka.OffsetRequest{topic: reqs}})
+	if err != nil {
+		return nil, fmt.Errorf("list offsets: %w", err)
+	}
+	out := make(map[int]int64)
+	for _, p := range resp.Topics[topic] {
+		if p.Error != nil {
+			return nil, fmt.Errorf("list offsets of partition %d: %w", p.Partition, p.Error)
+		}
+		out[p.Partition] = p.FirstOffset
+		if p.LastOffset >= 0 {
+			out[p.Partition] = p.LastOffset
+		}
+	}
+	return out, nil
+}
+
+// offsetsAt returns, by partition, the first offset written at or after at,
+// or the end when nothing is
+func (g *groups) offsetsAt(ctx context.Context, topic string, states []partitionState, at time.Time) (map[int]int64, error) {
+	reqs := make([]kafka.OffsetRequest, len(states))
+	for i, s := range states {
+		reqs[i] = kafka.TimeOffsetOf(s.Partition, at)
+	}
+	resp, err := g.client.ListOffsets(ctx, &kafka.ListOffsetsRequest{Topics: map[string][]kafka.OffsetRequest{topic: reqs}})
+	if err != nil {
+		return nil, fmt.Errorf("list offsets at %s: %w", at.Format(time.RFC3339), err)
+	}
+	out := make(map[int]int64)
+	for _, s := range states {
+		out[s.Partition] = s.End
+	}
+	for _, p := range resp.Topics[topic] {
+		if p.Error != nil {
+			return nil, fmt.Errorf("list offsets of partition %d: %w", p.Partition, p.Error)
+		}
+		for offset := range p.Offsets {
+			if offset >= 0 {
+				out[p.Partition] = offset
+			}
+		}
+	}
+	return out, nil
+}
+
+// messageTime is the timestamp of the message at offset
+func (g *groups) messageTime(ctx context.Context, topic string, partition int, offset int64) (time.Time, error) {
+	resp, err := g.client.Fetch(ctx, &kafka.FetchRequest{Topic: topic, Partition: partition, Offset: offset, MaxBytes: 1 << 20, MaxWait: time.Second})
+	if err != nil {
+		return time.Time{}, fmt.Errorf("fetch partition %d at %d: %w", partition, offset, err)
+	}
+	if resp.Error != nil {
+		return time.Time{}, fmt.Errorf("fetch partition %d at %d: %w", partition, offset, resp.Error)
+	}
+	// The batch may start before offset
+	for {
+		rec, err := resp.Records.ReadRecord()
+		if errors.Is(err, io.EOF) {
+			return time.Time{}, fmt.Errorf("fetch partition %d at %d: no message", partition, offset)
+		}
+		if err != nil {
+			return time.Time{}, fmt.Errorf("fetch partition %d at %d: %w", partition, offset, err)
+		}
+		if rec.Offset >= offset {
+			return rec.Time, nil
+		}
+	}
+}
+
+// commit sets the group's offsets. The group must have no members: their
+// generation would fence the commit off, and they'd overwrite it anyway.
+func (g *groups) commit(ctx context.Context, group, topic string, offsets map[int]int64) error {
+	commits := make([]kafka.OffsetCommit, 0, len(offsets))
+	for p, o := range offsets {
+		commits = append(commits, kafka.OffsetCommit{Partition: p, Offset: o})
+	}
+	resp, err := g.client.OffsetCommit(ctx, &kafka.OffsetCommitRequest{
+		GroupID:      group,
+		GenerationID: -1,
+		Topics:       map[string][]kafka.OffsetCommit{topic: commits},
+	})
+	if err != nil {
+		return fmt.Errorf("commit offsets: %w", err)
+	}
+	for _, p := range resp.Topics[topic] {
+		if p.Error != nil {
+			return fmt.Errorf("commit offset of partition %d: %w", p.Partition, p.Error)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,153 @@
+// Command prom-export exports the per-day song stats (song_daily_listens and
+// song_daily_listeners) as Prometheus samples, one per series and day
+// stamped at the day's midnight (UTC), for long-term dashboards in Grafana:
+//
+//	prom-export -format openmetrics -out days.om      a file for promtool tsdb create-blocks-from openmetrics
+//	prom-export -format remote-write -url URL         push to a remote-write receiver
+//	prom-export -from 2026-09-01 -to 2026-09-30 ...   a range instead of yesterday
+//
+// Every day gets the global series (all songs) and the series of its
+// -songs most listened songs, so the series count stays bounded however many
+// songs there are. Days are exported once they're over: Prometheus rejects a
+// second, different sample for a timestamp it already has.
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/system-design-lab/pkg/storage"
+)
+
+const (
+	formatOpenMetrics = "openmetrics"
+	formatRemoteWrite = "remote-write"
+)
+
+func main() {
+	yesterday := time.Now().UTC().AddDate(0, 0, -1).Format(storage.DayFormat)
+	from := flag.String("from", yesterday, "first day to export (UTC, YYYY-MM-DD)")
+	to := flag.String("to", yesterday, "last day to export, inclusive")
+	songs := flag.Int("songs", 100, "most listened songs with their own series, per day")
+	job := flag.String("job", "system-design-lab", "job label of every series")
+	format := flag.String("format", formatOpenMetrics, "openmetrics or remote-write")
+	out := flag.String("out", "-", "openmetrics: file to write (- for stdout)")
+	url := flag.String("url", "", "remote-write: receiver URL, e.g. http://prometheus:9090/api/v1/write")
+	batch := flag.Int("batch", 5000, "remote-write: samples per request")
+	timeout := flag.Duration("timeout", 30*time.Minute, "overall timeout")
+	flag.Parse()
+
+	if _, err := time.Parse(storage.DayFormat, *from); err != nil {
+		log.Fatalf("Invalid -from %q", *from)
+	}
+	if _, err := time.Parse(storage.DayFormat, *to); err != nil || *to < *from {
+		log.Fatalf("Invalid -to %q: want a day from -from on", *to)
+	}
+	if *songs < 0 || *batch < 1 {
+		log.Fatalf("-songs must be at least 0 and -batch at least 1")
+	}
+	switch *format {
+	case formatOpenMetrics:
+	case formatRemoteWrite:
+		if *url == "" {
+			log.Fatalf("-format remote-write needs -url")
+		}
+	default:
+		log.Fatalf("Invalid -format %q (want openmetrics or remote-write)", *format)
+	}
+	if today := time.Now().UTC().Format(storage.DayFormat); *to >= today {
+		log.Printf("Warning: %s isn't over yet; re-exporting it later with more listens will be rejected", today)
+	}
+
+	session, err := storage.ConnectFromEnv()
+	if err != nil {
+		log.Fatalf("Failed to connect to Cassandra: %v", err)
+	}
+	defer session.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	takedowns := storage.NewTakedownSet(storage.NewTakedownRepo(session))
+	if err := takedowns.Start(ctx); err != nil {
+		log.Fatalf("Failed to load song takedowns: %v", err)
+	}
+
+	start := time.Now()
+	log.Printf("Reading song stats of %s..%s (full table scan)", *from, *to)
+	days := make(map[string]*dayStats)
+	err = storage.NewSongStatsRepo(session).Scan(ctx, *from, *to, func(row storage.SongDayRow) error {
+		if takedowns.Has(row.SongID) {
+			return nil
+		}
+		d := days[row.Day]
+		if d == nil {
+			d = &dayStats{songs: make(map[string]storage.SongDay)}
+			days[row.Day] = d
+		}
+		d.add(row)
+		return nil
+	})
+	if err != nil {
+		log.Fatalf("Scan failed: %v", err)
+	}
+	families := buildFamilies(days, *songs, *job)
+
+	n := 0
+	for _, f := range families {
+		for _, s := range f.series {
+			n += len(s.samples)
+		}
+	}
+	log.Printf("Exporting %d samples of %d days", n, len(days))
+
+	switch *format {
+	case formatOpenMetrics:
+		var w io.Writer = os.Stdout
+		if *out != "-" {
+			f, err := os.Create(*out)
+			if err != nil {
+				log.Fatalf("Failed to create %s: %v", *out, err)
+			}
+			defer f.Close()
+			w = f
+		}
+		bw := bufio.NewWriter(w)
+		err := writeOpenMetrics(bw, families)
+		if err == nil {
+			err = bw.Flush()
+		}
+		if err != nil {
+			log.Fatalf("Failed to write: %v", err)
+		}
+	case formatRemoteWrite:
+		rw := &remoteWriter{
+			url:      *url,
+			username: os.Getenv("REMOTE_WRITE_USERNAME"),
+			password: os.Getenv("REMOTE_WRITE_PASSWORD"),
+			batch:    *batch,
+			http:     http.Client{Timeout: 30 * time.Second},
+		}
+		if err := rw.push(ctx, families); err != nil {
+			log.Fatalf("Remote write failed: %v", err)
+		}
+	}
+	log.Printf("Exported %d samples in %s", n, time.Since(start).Round(time.Millisecond))
+}
+
+// dayStats are one day's song stats
+type dayStats struct {
+	listens int64
+	songs   map[string]storage.SongDay
+}
+
+func (d *dayStats) add(row storage.SongDayRow) {
+	d.listens += row.Listens
+	d.songs[row.SongID] = row.SongDay
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// labelEscaper escapes label values as the exposition formats want
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// writeOpenMetrics writes the families in the OpenMetrics text format, each
+// sample with its timestamp (in seconds) and the file ending in "# EOF". A
+// family's series follow one another, each oldest first, as
+// promtool tsdb create-blocks-from openmetrics requires.
+func writeOpenMetrics(w *bufio.Writer, families []*family) error {
+	for _, f := range families {
+		if len(f.series) == 0 {
+			continue
+		}
+		fmt.Fprintf(w, "# TYPE %s gauge\n# HELP %s %s\n", f.name, f.name, f.help)
+		for _, s := range f.series {
+			var ls strings.Builder
+			for i, l := range s.labels {
+				if i > 0 {
+					ls.WriteByte(',')
+				}
+				fmt.Fprintf(&ls, `%s="%s"`, l.name, labelEscaper.Replace(l.value))
+			}
+			for _, smp := range s.samples {
+				fmt.Fprintf(w, "%s{%s} %s %d\n", f.name, ls.String(),
+					strconv.FormatFloat(smp.value, 'f', -1, 64), smp.ts/1000)
+			}
+		}
+	}
+	_, err := w.WriteString("# EOF\n")
+	return err
+}
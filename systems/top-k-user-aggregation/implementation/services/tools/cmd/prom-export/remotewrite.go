@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/golang/snappy"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Field numbers of Prometheus' remote-write 1.0 WriteRequest (prompb)
+const (
+	fieldTimeseries = 1 // WriteRequest
+	fieldLabels     = 1 // TimeSeries
+	fieldSamples    = 2
+	fieldName       = 1 // Label
+	fieldValue      = 2
+	fieldSampleVal  = 1 // Sample
+	fieldTimestamp  = 2
+)
+
+// remoteWriter pushes samples to a remote-write receiver: Prometheus with
+// --web.enable-remote-write-receiver, Mimir, Thanos Receive, VictoriaMetrics
+type remoteWriter struct {
+	url                string
+	username, password string // basic auth when set
+	batch              int    // samples per request
+	http               http.Client
+}
+
+// push sends every sample, batch at a time. A request the receiver turns
+// down (4xx) fails the push at once; 429, 5xx and connection errors are
+// retried with backoff, as remote-write senders do.
+func (rw *remoteWriter) push(ctx context.Context, families []*family) error {
+	var (
+		req  []byte
+		n    int
+		sent int
+	)
+	flush := func() error {
+		if n == 0 {
+			return nil
+		}
+		if err := rw.send(ctx, req); err != nil {
+			return fmt.Errorf("after %d samples: %w", sent, err)
+		}
+		sent += n
+		req, n = req[:0], 0
+		return nil
+	}
+	for _, f := range families {
+		for _, s := range f.series {
+			for samples := s.samples; len(samples) > 0; {
+				take := min(len(samples), rw.batch-n)
+				req = appendTimeSeries(req, f.name, s.labels, samples[:take])
+				samples = samples[take:]
+				if n += take; n == rw.batch {
+					if err := flush(); err != nil {
+						return err
+					}
+				}
+			}
+		}
+	}
+	return flush()
+}
+
+// send posts one snappy-compressed WriteRequest, retrying while it may work
+func (rw *remoteWriter) send(ctx context.Context, writeRequest []byte) error {
+	body := snappy.Encode(nil, writeRequest)
+	backoff := time.Second
+	for attempt := 1; ; attempt++ {
+		retry, err := rw.post(ctx, body)
+		if err == nil {
+			return nil
+		}
+		if !retry || attempt == 5 {
+			return err
+		}
+		log.Printf("Warning: remote write attempt %d failed, retrying in %s: %v", attempt, backoff, err)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// post makes one attempt and reports whether a failure is worth retrying
+func (rw *remoteWriter) post(ctx context.Context, body []byte) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, rw.url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("User-Agent", "system-design-lab-prom-export")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	if rw.username != "" || rw.password != "" {
+		req.SetBasicAuth(rw.username, rw.password)
+	}
+	resp, err := rw.http.Do(req)
+	if err != nil {
+		return ctx.Err() == nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 == 2 {
+		return false, nil
+	}
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	err = fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500, err
+}
+
+// appendTimeSeries appends a WriteRequest's timeseries field: the series'
+// labels (__name__ first, then the rest, already sorted) and samples
+func appendTimeSeries(b []byte, name string, labels []label, samples []sample) []byte {
+	var ts []byte
+	ts = appendLabel(ts, "__name__", name)
+	for _, l := range labels {
+		ts = appendLabel(ts, l.name, l.value)
+	}
+	for _, s := range samples {
+		var smp []byte
+		smp = protowire.AppendTag(smp, fieldSampleVal, protowire.Fixed64Type)
+		smp = protowire.AppendFixed64(smp, math.Float64bits(s.value))
+		smp = protowire.AppendTag(smp, fieldTimestamp, protowire.VarintType)
+		smp = protowire.AppendVarint(smp, uint64(s.ts))
+		ts = protowire.AppendTag(ts, fieldSamples, protowire.BytesType)
+		ts = protowire.AppendBytes(ts, smp)
+	}
+	b = protowire.AppendTag(b, fieldTimeseries, protowire.BytesType)
+	return protowire.AppendBytes(b, ts)
+}
+
+func appendLabel(b []byte, name, value string) []byte {
+	var l []byte
+	l = protowire.AppendTag(l, fieldName, protowire.BytesType)
+	l = protowire.AppendString(l, name)
+	l = protowire.AppendTag(l, fieldValue, protowire.BytesType)
+	l = protowire.AppendString(l, value)
+	b = protowire.AppendTag(b, fieldLabels, protowire.BytesType)
+	return protowire.AppendBytes(b, l)
+}
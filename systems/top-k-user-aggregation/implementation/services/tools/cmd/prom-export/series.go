@@ -0,0 +1,113 @@
+package main
+
+import (
+	"sort"
+	"time"
+
+	"github.com/system-design-lab/pkg/storage"
+)
+
+// label is one name="value" pair of a series
+type label struct {
+	name, value string
+}
+
+// sample is one day's value, stamped at its midnight (unix ms)
+type sample struct {
+	ts    int64
+	value float64
+}
+
+// series is one labelled time series, samples oldest first
+type series struct {
+	labels  []label // sorted by name, __name__ excluded
+	samples []sample
+}
+
+// family is a metric and its series
+type family struct {
+	name, help string
+	series     []*series
+}
+
+// buildFamilies turns the days' stats into the exported metrics. Only a
+// day's top songs get song series; the global ones count every song.
+func buildFamilies(days map[string]*dayStats, topSongs int, job string) []*family {
+	var (
+		listens = &family{name: "topk_daily_listens", help: "Listens of every song on the day (UTC) starting at the sample's timestamp."}
+		songs   = &family{name: "topk_daily_songs", help: "Songs with at least one listen on the day."}
+		songL   = &family{name: "topk_song_daily_listens", help: "Listens of the song on the day, for the day's most listened songs."}
+		songU   = &family{name: "topk_song_daily_listeners", help: "Estimated distinct listeners of the song on the day (HyperLogLog)."}
+
+		jobLabel = label{"job", job}
+		global   = [2]*series{{labels: []label{jobLabel}}, {labels: []label{jobLabel}}}
+		bySong   = make(map[string]*[2]*series)
+	)
+	listens.series = []*series{global[0]}
+	songs.series = []*series{global[1]}
+
+	dayKeys := make([]string, 0, len(days))
+	for day := range days {
+		dayKeys = append(dayKeys, day)
+	}
+	sort.Strings(dayKeys)
+	for _, day := range dayKeys {
+		d := days[day]
+		ts := dayTimestamp(day)
+		global[0].samples = append(global[0].samples, sample{ts, float64(d.listens)})
+		global[1].samples = append(global[1].samples, sample{ts, float64(len(d.songs))})
+
+		for _, songID := range topOf(d.songs, topSongs) {
+			s, ok := bySong[songID]
+			if !ok {
+				labels := []label{jobLabel, {"song_id", songID}}
+				s = &[2]*series{{labels: labels}, {labels: labels}}
+				bySong[songID] = s
+				songL.series = append(songL.series, s[0])
+				songU.series = append(songU.series, s[1])
+			}
+			stats := d.songs[songID]
+			s[0].samples = append(s[0].samples, sample{ts, float64(stats.Listens)})
+			if stats.Listeners > 0 {
+				s[1].samples = append(s[1].samples, sample{ts, float64(stats.Listeners)})
+			}
+		}
+	}
+
+	families := []*family{listens, songs, songL, songU}
+	for _, f := range families {
+		// Drop series left without samples (listeners never estimated)
+		kept := f.series[:0]
+		for _, s := range f.series {
+			if len(s.samples) > 0 {
+				kept = append(kept, s)
+			}
+		}
+		f.series = kept
+	}
+	return families
+}
+
+// topOf returns the n songs with the most listens, most first
+func topOf(songs map[string]storage.SongDay, n int) []string {
+	ids := make([]string, 0, len(songs))
+	for id := range songs {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		a, b := songs[ids[i]].Listens, songs[ids[j]].Listens
+		if a != b {
+			return a > b
+		}
+		return ids[i] < ids[j]
+	})
+	if len(ids) > n {
+		ids = ids[:n]
+	}
+	return ids
+}
+
+func dayTimestamp(day string) int64 {
+	t, _ := time.Parse(storage.DayFormat, day)
+	return t.UnixMilli()
+}
@@ -0,0 +1,71 @@
+// Command runtime-config reads and changes the runtime settings services pick
+// up without a restart (see pkg/runtimecfg).
+//
+//	runtime-config list                                      global settings
+//	runtime-config -scope aggregator list                    one service's overrides
+//	runtime-config -scope aggregator set flush_interval 10s  set and notify
+//	runtime-config -scope aggregator del flush_interval      back to the env value
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/system-design-lab/pkg/runtimecfg"
+)
+
+func main() {
+	scope := flag.String("scope", runtimecfg.Global, "global or a service name")
+	timeout := flag.Duration("timeout", 10*time.Second, "overall timeout")
+	flag.Usage = func() {
+		fmt.Fprintln(flag.CommandLine.Output(), "usage: runtime-config [-scope s] list | set <key> <value> | del <key>")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	addr := os.Getenv("REDIS_ADDR")
+	if addr == "" {
+		addr = "localhost:6379"
+	}
+	rdb := redis.NewClient(&redis.Options{Addr: addr})
+	defer rdb.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	args := flag.Args()
+	switch {
+	case len(args) == 1 && args[0] == "list":
+		values, err := runtimecfg.List(ctx, rdb, *scope)
+		if err != nil {
+			log.Fatalf("List %s: %v", *scope, err)
+		}
+		keys := make([]string, 0, len(values))
+		for k := range values {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			fmt.Printf("%s=%s\n", k, values[k])
+		}
+	case len(args) == 3 && args[0] == "set":
+		if err := runtimecfg.Set(ctx, rdb, *scope, args[1], args[2]); err != nil {
+			log.Fatalf("Set: %v", err)
+		}
+		log.Printf("Set %s %s=%s", *scope, args[1], args[2])
+	case len(args) == 2 && args[0] == "del":
+		if err := runtimecfg.Delete(ctx, rdb, *scope, args[1]); err != nil {
+			log.Fatalf("Delete: %v", err)
+		}
+		log.Printf("Deleted %s %s", *scope, args[1])
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+}
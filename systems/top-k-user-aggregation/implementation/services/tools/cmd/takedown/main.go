@@ -0,0 +1,135 @@
+// Command takedown removes songs from every aggregate and cache.
+//
+//	takedown add -songs song-42 -reason "DMCA #1234"   record and announce the takedown
+//	takedown list                                      takedowns and their purge status
+//
+// add writes song_takedowns, which every service filtering songs reloads
+// every storage.TakedownRefresh, then publishes a tombstone on song.takedown
+// for the aggregator to purge the song's counters. The api-server filters
+// the song out in the meantime.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/system-design-lab/pkg/events"
+	"github.com/system-design-lab/pkg/kafkautil"
+	"github.com/system-design-lab/pkg/storage"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+	switch os.Args[1] {
+	case "add":
+		runAdd(os.Args[2:])
+	case "list":
+		runList(os.Args[2:])
+	default:
+		usage()
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: takedown add|list [flags] (takedown <command> -h for flags)")
+	os.Exit(2)
+}
+
+func runAdd(args []string) {
+	fs := flag.NewFlagSet("add", flag.ExitOnError)
+	songsFlag := fs.String("songs", "", "comma-separated song IDs")
+	reason := fs.String("reason", "", "why, kept with the takedown (e.g. a ticket)")
+	topic := fs.String("topic", "song.takedown", "tombstone topic")
+	timeout := fs.Duration("timeout", time.Minute, "overall timeout")
+	fs.Parse(args)
+
+	var songs []string
+	for _, s := range strings.Split(*songsFlag, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			songs = append(songs, s)
+		}
+	}
+	if len(songs) == 0 {
+		log.Fatal("-songs is required")
+	}
+
+	session, err := storage.ConnectFromEnv()
+	if err != nil {
+		log.Fatalf("Failed to connect to Cassandra: %v", err)
+	}
+	defer session.Close()
+	repo := storage.NewTakedownRepo(session)
+
+	kafkaCfg, err := kafkautil.ConfigFromEnv("localhost:29092")
+	if err != nil {
+		log.Fatalf("Invalid Kafka config: %v", err)
+	}
+	writer := kafkaCfg.NewWriter(*topic, kafkautil.WriterConfigFromEnv())
+	defer writer.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	// The table first: once a service has reloaded it the song is hidden,
+	// whether or not the tombstone got through. Re-running add is harmless.
+	now := time.Now()
+	msgs := make([]kafka.Message, 0, len(songs))
+	for _, song := range songs {
+		if err := repo.Add(ctx, storage.Takedown{SongID: song, Reason: *reason, RequestedAt: now}); err != nil {
+			log.Fatalf("Record takedown of %s: %v", song, err)
+		}
+		value, err := events.MarshalTakedown(events.SongTakedown{SongID: song, Reason: *reason, RequestedAt: now.Unix()})
+		if err != nil {
+			log.Fatalf("Encode takedown of %s: %v", song, err)
+		}
+		msgs = append(msgs, kafka.Message{Key: []byte(song), Value: value})
+	}
+	kafkautil.Inject(ctx, msgs)
+	if err := writer.WriteMessages(ctx, msgs...); err != nil {
+		log.Fatalf("Publish %d takedowns to %s (recorded, re-run add to retry): %v", len(msgs), *topic, err)
+	}
+	log.Printf("Took down %d songs (%s); hidden within %s, counters purged by the aggregator",
+		len(songs), strings.Join(songs, ","), storage.TakedownRefresh)
+}
+
+func runList(args []string) {
+	fs := flag.NewFlagSet("list", flag.ExitOnError)
+	timeout := fs.Duration("timeout", time.Minute, "overall timeout")
+	fs.Parse(args)
+
+	session, err := storage.ConnectFromEnv()
+	if err != nil {
+		log.Fatalf("Failed to connect to Cassandra: %v", err)
+	}
+	defer session.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	list, err := storage.NewTakedownRepo(session).List(ctx)
+	if err != nil {
+		log.Fatalf("List takedowns: %v", err)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].RequestedAt.Before(list[j].RequestedAt) })
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "SONG\tREQUESTED\tPURGED\tROWS\tREASON")
+	for _, t := range list {
+		purged := "pending"
+		if !t.PurgedAt.IsZero() {
+			purged = t.PurgedAt.UTC().Format(time.RFC3339)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%s\n", t.SongID, t.RequestedAt.UTC().Format(time.RFC3339), purged, t.RowsDeleted, t.Reason)
+	}
+	tw.Flush()
+}
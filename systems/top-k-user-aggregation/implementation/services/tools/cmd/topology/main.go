@@ -0,0 +1,100 @@
+// Command topology checks pipeline.yaml against the running infra and shows
+// what it gives a service.
+//
+//	topology verify                 every topic, group, table and cache of the file against Kafka, Cassandra and Redis
+//	topology env -service notifier  the env defaults the notifier gets from the file
+//
+// verify exits 1 when a topic, table or cache is missing or the file is
+// invalid. A group without committed offsets is only a warning: a consumer
+// group exists once its service has run.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/system-design-lab/pkg/topology"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+	switch os.Args[1] {
+	case "verify":
+		runVerify(os.Args[2:])
+	case "env":
+		runEnv(os.Args[2:])
+	default:
+		usage()
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: topology verify|env [flags] (topology <command> -h for flags)")
+	os.Exit(2)
+}
+
+// configFlag is -config, defaulting to PIPELINE_CONFIG
+func configFlag(fs *flag.FlagSet) *string {
+	def := os.Getenv("PIPELINE_CONFIG")
+	if def == "" {
+		def = "pipeline.yaml"
+	}
+	return fs.String("config", def, "pipeline config (default $PIPELINE_CONFIG or pipeline.yaml)")
+}
+
+func runVerify(args []string) {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	config := configFlag(fs)
+	skipKafka := fs.Bool("skip-kafka", false, "don't check topics and groups")
+	skipCassandra := fs.Bool("skip-cassandra", false, "don't check tables")
+	skipRedis := fs.Bool("skip-redis", false, "don't check caches")
+	timeout := fs.Duration("timeout", time.Minute, "overall timeout")
+	fs.Parse(args)
+
+	topo, err := topology.Load(*config)
+	if err != nil {
+		log.Fatalf("Invalid pipeline config: %v", err)
+	}
+	v := newVerifier(topo, *timeout)
+	defer v.close()
+	if !*skipKafka {
+		v.kafka()
+	}
+	if !*skipCassandra {
+		v.cassandra()
+	}
+	if !*skipRedis {
+		v.redis()
+	}
+	v.report()
+	if v.failed > 0 {
+		os.Exit(1)
+	}
+}
+
+func runEnv(args []string) {
+	fs := flag.NewFlagSet("env", flag.ExitOnError)
+	config := configFlag(fs)
+	service := fs.String("service", "", "service name, as in the file")
+	fs.Parse(args)
+	if *service == "" {
+		log.Fatal("-service is required")
+	}
+
+	topo, err := topology.Load(*config)
+	if err != nil {
+		log.Fatalf("Invalid pipeline config: %v", err)
+	}
+	env, err := topo.Env(*service)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, k := range sortedKeys(env) {
+		fmt.Printf("%s=%s\n", k, env[k])
+	}
+}
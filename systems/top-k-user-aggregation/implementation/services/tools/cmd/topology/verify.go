@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/segmentio/kafka-go"
+	"github.com/system-design-lab/pkg/kafkautil"
+	"github.com/system-design-lab/pkg/storage"
+	"github.com/system-design-lab/pkg/topology"
+)
+
+// Statuses of a check; statusMissing and statusError fail verify
+const (
+	statusOK      = "ok"
+	statusMissing = "MISSING"
+	statusError   = "ERROR"
+	statusWarning = "warning"
+)
+
+// check is one line of the report
+type check struct {
+	kind, name, status, detail string
+}
+
+// verifier checks a topology against the live infra, collecting the results
+type verifier struct {
+	topo   *topology.Topology
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	partitions map[string][]int // existing topics' partition IDs
+	checks     []check
+	failed     int
+}
+
+func newVerifier(topo *topology.Topology, timeout time.Duration) *verifier {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	return &verifier{topo: topo, ctx: ctx, cancel: cancel, partitions: make(map[string][]int)}
+}
+
+func (v *verifier) close() { v.cancel() }
+
+func (v *verifier) add(kind, name, status, format string, args ...any) {
+	v.checks = append(v.checks, check{kind, name, status, fmt.Sprintf(format, args...)})
+	if status == statusMissing || status == statusError {
+		v.failed++
+	}
+}
+
+// kafka checks every declared topic exists and every group has committed
+// offsets on its topic. Undeclared topics are warned about, except Kafka's
+// internal ones and MirrorMaker 2's mirrors (<dc>.<topic>) of declared ones.
+func (v *verifier) kafka() {
+	cfg, err := kafkautil.ConfigFromEnv("localhost:29092")
+	if err != nil {
+		v.add("kafka", "config", statusError, "%v", err)
+		return
+	}
+	client := cfg.Client()
+	meta, err := client.Metadata(v.ctx, &kafka.MetadataRequest{})
+	if err != nil {
+		v.add("kafka", strings.Join(cfg.Brokers, ","), statusError, "metadata: %v", err)
+		return
+	}
+	for _, t := range meta.Topics {
+		if t.Error != nil {
+			continue
+		}
+		for _, p := range t.Partitions {
+			v.partitions[t.Name] = append(v.partitions[t.Name], p.ID)
+		}
+	}
+
+	for _, name := range sortedKeys(v.topo.Topics) {
+		if ids, ok := v.partitions[name]; ok {
+			v.add("topic", name, statusOK, "%d partitions", len(ids))
+		} else {
+			v.add("topic", name, statusMissing, "create it with kafka-admin")
+		}
+	}
+	for _, name := range sortedKeys(v.partitions) {
+		if _, ok := v.topo.Topics[name]; ok || strings.HasPrefix(name, "_") {
+			continue
+		}
+		if _, mirrored, ok := strings.Cut(name, "."); ok {
+			if _, declared := v.topo.Topics[mirrored]; declared {
+				continue
+			}
+		}
+		v.add("topic", name, statusWarning, "not in the pipeline config")
+	}
+
+	for _, g := range v.topo.Groups() {
+		ids, ok := v.partitions[g.Topic]
+		if !ok {
+			continue // the topic's check failed already
+		}
+		resp, err := client.OffsetFetch(v.ctx, &kafka.OffsetFetchRequest{GroupID: g.ID, Topics: map[string][]int{g.Topic: ids}})
+		if err == nil {
+			err = resp.Error
+		}
+		if err != nil {
+			v.add("group", g.ID, statusError, "offsets on %s: %v", g.Topic, err)
+			continue
+		}
+		committed := 0
+		for _, p := range resp.Topics[g.Topic] {
+			if p.Error == nil && p.CommittedOffset >= 0 {
+				committed++
+			}
+		}
+		if committed == 0 {
+			v.add("group", g.ID, statusWarning, "no offsets committed on %s: has %s run yet?", g.Topic, g.Service)
+		} else {
+			v.add("group", g.ID, statusOK, "committed on %d/%d partitions of %s", committed, len(ids), g.Topic)
+		}
+	}
+}
+
+// cassandra checks every declared table exists in the keyspace. Tables the
+// file doesn't declare (besides migrate's schema_version) are warned about.
+func (v *verifier) cassandra() {
+	cfg, err := storage.ConfigFromEnv()
+	if err != nil {
+		v.add("cassandra", "config", statusError, "%v", err)
+		return
+	}
+	session, err := storage.Connect(cfg)
+	if err != nil {
+		v.add("cassandra", strings.Join(cfg.Hosts, ","), statusError, "%v", err)
+		return
+	}
+	defer session.Close()
+
+	existing := make(map[string]bool)
+	iter := session.Gocql().Query(`SELECT table_name FROM system_schema.tables WHERE keyspace_name = ?`, cfg.Keyspace).
+		WithContext(v.ctx).Iter()
+	var name string
+	for iter.Scan(&name) {
+		existing[name] = true
+	}
+	if err := iter.Close(); err != nil {
+		v.add("cassandra", cfg.Keyspace, statusError, "list tables: %v", err)
+		return
+	}
+
+	declared := make(map[string]bool)
+	for _, table := range v.topo.Tables {
+		declared[table] = true
+		if existing[table] {
+			v.add("table", table, statusOK, "in %s", cfg.Keyspace)
+		} else {
+			v.add("table", table, statusMissing, "not in %s: run migrate", cfg.Keyspace)
+		}
+	}
+	for _, table := range sortedKeys(existing) {
+		if !declared[table] && table != "schema_version" {
+			v.add("table", table, statusWarning, "not in the pipeline config")
+		}
+	}
+}
+
+// redis pings every cache's database on CACHE_REDIS_ADDR (REDIS_ADDR)
+func (v *verifier) redis() {
+	addr := getEnv("CACHE_REDIS_ADDR", getEnv("REDIS_ADDR", "localhost:6379"))
+	for _, name := range sortedKeys(v.topo.Caches) {
+		db := v.topo.Caches[name].DB
+		rdb := redis.NewClient(&redis.Options{Addr: addr, DB: db})
+		err := rdb.Ping(v.ctx).Err()
+		rdb.Close()
+		if err != nil {
+			v.add("cache", name, statusError, "db %d on %s: %v", db, addr, err)
+		} else {
+			v.add("cache", name, statusOK, "db %d on %s", db, addr)
+		}
+	}
+}
+
+func (v *verifier) report() {
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "KIND\tNAME\tSTATUS\tDETAIL")
+	for _, c := range v.checks {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", c.kind, c.name, c.status, c.detail)
+	}
+	tw.Flush()
+	if v.failed > 0 {
+		fmt.Printf("\n%d of %d checks failed\n", v.failed, len(v.checks))
+	} else {
+		fmt.Printf("\nThe infra matches the pipeline config (%d checks)\n", len(v.checks))
+	}
+}
+
+func getEnv(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
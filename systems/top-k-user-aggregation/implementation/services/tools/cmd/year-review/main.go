@@ -0,0 +1,150 @@
+// Command year-review compiles year-in-review reports (top songs and artists,
+// minutes listened, streaks) from the daily counters and stores them in
+// user_year_review, where the api-server serves them.
+//
+//	year-review -year 2025                   every user with a listen that year (full table scan)
+//	year-review -year 2025 -users u1,u2      just these users
+//	year-review -year 2025 -users u1 -print  print the report instead of storing it
+//
+// A report reads two partitions per day of the year for each user, so this is
+// a batch job, not something to run per request. Re-running it replaces the
+// stored reports.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/system-design-lab/pkg/storage"
+)
+
+func main() {
+	year := flag.Int("year", time.Now().UTC().Year()-1, "year to compile")
+	users := flag.String("users", "", "comma-separated user IDs (default: every user with a listen that year)")
+	top := flag.Int("top", 10, "songs and artists per report")
+	concurrency := flag.Int("concurrency", 4, "users compiled in parallel")
+	printOnly := flag.Bool("print", false, "print reports as JSON instead of storing them")
+	timeout := flag.Duration("timeout", 6*time.Hour, "overall timeout")
+	flag.Parse()
+
+	days, err := yearDays(*year, time.Now())
+	if err != nil {
+		log.Fatalf("Invalid -year: %v", err)
+	}
+
+	session, err := storage.ConnectFromEnv()
+	if err != nil {
+		log.Fatalf("Failed to connect to Cassandra: %v", err)
+	}
+	defer session.Close()
+	c := &compiler{
+		songs:   storage.NewDailyTopKRepo(session),
+		artists: storage.NewDailyArtistTopKRepo(session),
+		meta:    storage.NewSongMetadataRepo(session),
+		top:     *top,
+	}
+	reviews := storage.NewYearReviewRepo(session)
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	start := time.Now()
+	userIDs := splitList(*users)
+	if len(userIDs) == 0 {
+		log.Printf("Finding users with listens in %d (full table scan)", *year)
+		if userIDs, err = usersInYear(ctx, c.songs, *year); err != nil {
+			log.Fatalf("Scan failed: %v", err)
+		}
+	}
+	log.Printf("Compiling %d reports for %s..%s", len(userIDs), days[0], days[len(days)-1])
+
+	var (
+		done, failed int64
+		wg           sync.WaitGroup
+		printMu      sync.Mutex
+	)
+	work := make(chan string)
+	for i := 0; i < *concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for userID := range work {
+				rev, err := c.compile(ctx, userID, *year, days)
+				if err == nil && !*printOnly {
+					err = reviews.Put(ctx, rev)
+				}
+				if err != nil {
+					log.Printf("Error compiling %s: %v", userID, err)
+					atomic.AddInt64(&failed, 1)
+					continue
+				}
+				if *printOnly {
+					printMu.Lock()
+					json.NewEncoder(os.Stdout).Encode(rev)
+					printMu.Unlock()
+				}
+				if n := atomic.AddInt64(&done, 1); n%1000 == 0 {
+					log.Printf("Compiled %d/%d reports", n, len(userIDs))
+				}
+			}
+		}()
+	}
+	for _, userID := range userIDs {
+		work <- userID
+	}
+	close(work)
+	wg.Wait()
+
+	log.Printf("Compiled %d reports (%d failed) in %s", done, failed, time.Since(start).Round(time.Millisecond))
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
+
+// usersInYear returns the users with a counter row in the year
+func usersInYear(ctx context.Context, repo *storage.DailyTopKRepo, year int) ([]string, error) {
+	prefix := fmt.Sprintf("%04d-", year)
+	seen := make(map[string]bool)
+	var users []string
+	err := repo.Scan(ctx, func(row storage.CounterRow) error {
+		if strings.HasPrefix(row.Day, prefix) && row.Count > 0 && !seen[row.UserID] {
+			seen[row.UserID] = true
+			users = append(users, row.UserID)
+		}
+		return nil
+	})
+	return users, err
+}
+
+// yearDays returns the year's days in order, up to today for the current
+// year
+func yearDays(year int, now time.Time) ([]string, error) {
+	first := time.Date(year, 1, 1, 0, 0, 0, 0, time.UTC)
+	today := now.UTC().Truncate(24 * time.Hour)
+	if first.After(today) {
+		return nil, fmt.Errorf("%d hasn't started", year)
+	}
+	var days []string
+	for d := first; d.Year() == year && !d.After(today); d = d.AddDate(0, 0, 1) {
+		days = append(days, d.Format(storage.DayFormat))
+	}
+	return days, nil
+}
+
+func splitList(list string) []string {
+	var ids []string
+	for _, id := range strings.Split(list, ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/system-design-lab/pkg/storage"
+)
+
+// compiler builds reports from the daily song and artist counters
+type compiler struct {
+	songs   *storage.DailyTopKRepo
+	artists *storage.DailyArtistTopKRepo
+	meta    *storage.SongMetadataRepo
+	top     int
+}
+
+// compile reads every day of a user's year, oldest first, and builds the
+// report. Artists only count listens whose events carried an artist_id.
+func (c *compiler) compile(ctx context.Context, userID string, year int, days []string) (storage.YearReview, error) {
+	rev := storage.YearReview{
+		UserID:     userID,
+		Year:       year,
+		ComputedAt: time.Now().UTC(),
+		Through:    days[len(days)-1],
+		TopSongs:   []storage.SongCount{},
+		TopArtists: []storage.ArtistCount{},
+	}
+
+	songTotals := make(map[string]int64)
+	artistTotals := make(map[string]int64)
+	var streak storage.Streak
+	for _, day := range days {
+		songs, err := c.songs.DayCounts(ctx, userID, day)
+		if err != nil {
+			return rev, err
+		}
+		artists, err := c.artists.DayCounts(ctx, userID, day)
+		if err != nil {
+			return rev, err
+		}
+
+		var listens int64
+		for song, n := range songs {
+			songTotals[song] += n
+			listens += n
+		}
+		for artist, n := range artists {
+			artistTotals[artist] += n
+		}
+
+		if listens <= 0 {
+			streak = storage.Streak{}
+			continue
+		}
+		rev.Listens += listens
+		rev.DaysListened++
+		if listens > rev.TopDay.Listens {
+			rev.TopDay = storage.DayTop{Day: day, Listens: listens}
+		}
+		if streak.Days == 0 {
+			streak.From = day
+		}
+		streak.Days++
+		streak.To = day
+		if streak.Days > rev.LongestStreak.Days {
+			rev.LongestStreak = streak
+		}
+	}
+
+	if err := c.minutes(ctx, &rev, songTotals); err != nil {
+		return rev, err
+	}
+	for _, ic := range topCounts(songTotals, c.top) {
+		rev.TopSongs = append(rev.TopSongs, storage.SongCount{SongID: ic.id, Count: ic.count})
+	}
+	for _, ic := range topCounts(artistTotals, c.top) {
+		rev.TopArtists = append(rev.TopArtists, storage.ArtistCount{ArtistID: ic.id, Count: ic.count})
+	}
+	return rev, nil
+}
+
+// minutes sets the report's listening minutes from the songs' durations
+func (c *compiler) minutes(ctx context.Context, rev *storage.YearReview, songTotals map[string]int64) error {
+	songIDs := make([]string, 0, len(songTotals))
+	for song := range songTotals {
+		songIDs = append(songIDs, song)
+	}
+	meta, err := c.meta.GetMany(ctx, songIDs)
+	if err != nil {
+		return err
+	}
+
+	var seconds, covered int64
+	for song, n := range songTotals {
+		if d := meta[song].DurationSeconds; d > 0 && n > 0 {
+			seconds += n * int64(d)
+			covered += n
+		}
+	}
+	if covered == 0 {
+		return nil
+	}
+	minutes := seconds / 60
+	rev.Minutes = &minutes
+	rev.MinutesCoverage = float64(covered) / float64(rev.Listens)
+	return nil
+}
+
+type idCount struct {
+	id    string
+	count int64
+}
+
+// topCounts returns the k highest positive counts, ties broken by ID so
+// re-runs over unchanged counts produce the same report
+func topCounts(counts map[string]int64, k int) []idCount {
+	sorted := make([]idCount, 0, len(counts))
+	for id, n := range counts {
+		if n > 0 {
+			sorted = append(sorted, idCount{id, n})
+		}
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].count != sorted[j].count {
+			return sorted[i].count > sorted[j].count
+		}
+		return sorted[i].id < sorted[j].id
+	})
+	if len(sorted) > k {
+		sorted = sorted[:k]
+	}
+	return sorted
+}
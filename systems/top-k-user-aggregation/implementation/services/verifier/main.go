@@ -0,0 +1,168 @@
+// Command verifier continuously checks the aggregator's counters against the
+// raw history: it samples users from the raw topic, recounts their days from
+// user_listen_history and compares the counts and top-K with user_daily_topk,
+// reporting any drift. Dedup and flush bugs show up as drift instead of
+// waiting for someone to notice a wrong chart.
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/system-design-lab/pkg/events"
+	"github.com/system-design-lab/pkg/kafkautil"
+	"github.com/system-design-lab/pkg/runtimecfg"
+	"github.com/system-design-lab/pkg/startup"
+	"github.com/system-design-lab/pkg/storage"
+	"github.com/system-design-lab/pkg/topology"
+)
+
+func main() {
+	if _, err := topology.Apply("verifier"); err != nil {
+		log.Fatalf("Invalid pipeline config: %v", err)
+	}
+
+	topic := getEnv("TOPIC", "user.listen.raw")
+	consumerGroup := getEnv("CONSUMER_GROUP", "verifier")
+	poolSize := getEnvInt("POOL_SIZE", 10000)
+	sampleSize := getEnvInt("SAMPLE_SIZE", 20)
+	interval := getEnvDuration("VERIFY_INTERVAL", time.Minute)
+	settle := getEnvDuration("SETTLE_DELAY", 5*time.Minute)
+	maxAge := getEnvDuration("MAX_AGE", 72*time.Hour)
+	k := getEnvInt("VERIFY_K", 10)
+	minPlayMs := getEnvInt("MIN_PLAY_MS", 0)
+	metricsAddr := getEnv("METRICS_ADDR", ":9109")
+
+	kafkaCfg, err := kafkautil.ConfigFromEnv("localhost:29092")
+	if err != nil {
+		log.Fatalf("Invalid Kafka config: %v", err)
+	}
+
+	log.Printf("Starting verifier: kafka=%v topic=%s group=%s sample=%d/%s settle=%s max_age=%s k=%d",
+		kafkaCfg.Brokers, topic, consumerGroup, sampleSize, interval, settle, maxAge, k)
+
+	if err := startup.Kafka(context.Background(), kafkaCfg); err != nil {
+		log.Fatalf("Failed to connect to Kafka: %v", err)
+	}
+
+	session, err := startup.Cassandra(context.Background())
+	if err != nil {
+		log.Fatalf("Failed to connect to Cassandra: %v", err)
+	}
+	defer session.Close()
+	log.Println("Connected to Cassandra")
+
+	reader := kafkaCfg.NewReader(kafkautil.ReaderConfig{Topic: topic, GroupID: consumerGroup})
+	defer reader.Close()
+
+	startMetricsServer(metricsAddr)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-sigChan
+		log.Println("Shutting down...")
+		cancel()
+	}()
+
+	// log_level, min_play_ms and SIGHUP reloads of pipeline.yaml's settings
+	// (pkg/runtimecfg)
+	settings := runtimecfg.New(nil, "verifier")
+	if err := settings.Start(ctx); err != nil {
+		log.Printf("Warning: runtime config not loaded: %v", err)
+	}
+
+	pool := newPool(poolSize)
+	go consume(ctx, reader, pool)
+
+	v := &Verifier{
+		history:    storage.NewListenHistoryRepo(session),
+		topk:       storage.NewDailyTopKRepo(session),
+		pool:       pool,
+		rechecks:   make(map[userDay]time.Time),
+		sampleSize: sampleSize,
+		settle:     settle,
+		maxAge:     maxAge,
+		k:          k,
+		takedowns:  storage.NewTakedownSet(storage.NewTakedownRepo(session)),
+		settings:   settings,
+		minPlayMs:  minPlayMs,
+	}
+	if err := v.takedowns.Start(ctx); err != nil {
+		log.Printf("Warning: failed to load song takedowns, retrying every %s: %v", storage.TakedownRefresh, err)
+	}
+	v.Run(ctx, interval)
+
+	log.Println("Shutdown complete")
+}
+
+// consume adds the (user, day) of every raw event to the pool. Offsets are
+// committed every few seconds only so a restart doesn't re-read the topic's
+// retention; the pool itself is rebuilt from new events.
+func consume(ctx context.Context, reader *kafka.Reader, pool *pool) {
+	latest := make(map[int]kafka.Message) // uncommitted, by partition
+	lastCommit := time.Now()
+	for {
+		msg, err := reader.FetchMessage(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			log.Printf("Error fetching message: %v", err)
+			continue
+		}
+		metricEventsSeen.Add(1)
+		if event, err := events.Unmarshal(msg.Value); err == nil && event.Validate() == nil {
+			pool.add(userDay{event.UserID, event.Day()}, time.Now())
+		}
+
+		latest[msg.Partition] = msg
+		if time.Since(lastCommit) >= 5*time.Second {
+			msgs := make([]kafka.Message, 0, len(latest))
+			for _, m := range latest {
+				msgs = append(msgs, m)
+			}
+			if err := kafkautil.CommitWithRetry(ctx, reader, 3, msgs...); err != nil {
+				log.Printf("Error committing offsets: %v", err)
+			}
+			clear(latest)
+			lastCommit = time.Now()
+		}
+	}
+}
+
+func getEnv(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
+
+func getEnvInt(key string, fallback int) int {
+	if v := os.Getenv(key); v != "" {
+		i, err := strconv.Atoi(v)
+		if err == nil {
+			return i
+		}
+	}
+	return fallback
+}
+
+func getEnvDuration(key string, fallback time.Duration) time.Duration {
+	if v := os.Getenv(key); v != "" {
+		d, err := time.ParseDuration(v)
+		if err == nil {
+			return d
+		}
+	}
+	return fallback
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"expvar"
+	"log"
+	"net/http"
+)
+
+// Verifier metrics, served as JSON on METRICS_ADDR/debug/vars
+var (
+	metricEventsSeen       = expvar.NewInt("events_seen")
+	metricPoolSize         = expvar.NewInt("pool_size")
+	metricPoolDropped      = expvar.NewInt("pool_dropped")
+	metricSkippedOld       = expvar.NewInt("user_days_skipped_old")
+	metricChecked          = expvar.NewInt("user_days_checked")
+	metricMatched          = expvar.NewInt("user_days_matched")
+	metricTransient        = expvar.NewInt("user_days_transient")
+	metricDrifted          = expvar.NewInt("user_days_drifted")
+	metricRechecksPending  = expvar.NewInt("rechecks_pending")
+	metricListensOver      = expvar.NewInt("listens_over")
+	metricListensUnder     = expvar.NewInt("listens_under")
+	metricTopKMismatches   = expvar.NewInt("topk_mismatches")
+	metricDriftRatio       = expvar.NewFloat("drift_ratio")
+	metricCheckErrors      = expvar.NewInt("check_errors")
+	metricLastRoundChecked = expvar.NewInt("last_round_checked")
+	metricLastRoundMillis  = expvar.NewInt("last_round_ms")
+)
+
+// startMetricsServer exposes expvar metrics over HTTP
+func startMetricsServer(addr string) {
+	go func() {
+		log.Printf("Metrics on http://%s/debug/vars", addr)
+		if err := http.ListenAndServe(addr, nil); err != nil {
+			log.Printf("Metrics server error: %v", err)
+		}
+	}()
+}
@@ -0,0 +1,305 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/system-design-lab/pkg/runtimecfg"
+	"github.com/system-design-lab/pkg/storage"
+)
+
+type userDay struct {
+	UserID string
+	Day    string
+}
+
+// pool holds the (user, day)s with recent events and when the last one was
+// seen. Once full, a new user-day replaces an arbitrary one.
+type pool struct {
+	mu   sync.Mutex
+	size int
+	last map[userDay]time.Time
+}
+
+func newPool(size int) *pool {
+	return &pool{size: size, last: make(map[userDay]time.Time)}
+}
+
+func (p *pool) add(k userDay, at time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := p.last[k]; !ok && len(p.last) >= p.size {
+		for old := range p.last {
+			delete(p.last, old)
+			metricPoolDropped.Add(1)
+			break
+		}
+	}
+	p.last[k] = at
+	metricPoolSize.Set(int64(len(p.last)))
+}
+
+// take removes and returns up to n user-days whose last event was seen
+// before settled, in map order, which is as good as random here
+func (p *pool) take(n int, settled time.Time) []userDay {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var out []userDay
+	for k, at := range p.last {
+		if len(out) == n {
+			break
+		}
+		if at.Before(settled) {
+			out = append(out, k)
+			delete(p.last, k)
+		}
+	}
+	metricPoolSize.Set(int64(len(p.last)))
+	return out
+}
+
+// has reports whether k has events not yet taken
+func (p *pool) has(k userDay) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	_, ok := p.last[k]
+	return ok
+}
+
+// Verifier recounts sampled user-days from user_listen_history and compares
+// them with user_daily_topk. A user-day is only checked once no event has
+// arrived for it for the settle delay, so the aggregator has flushed it; a
+// mismatch is checked again one settle delay later and only reported as
+// drift if it's still there, which filters out consumer lag.
+type Verifier struct {
+	history    *storage.ListenHistoryRepo
+	topk       *storage.DailyTopKRepo
+	pool       *pool
+	rechecks   map[userDay]time.Time // mismatches -> when to check them again
+	sampleSize int
+	settle     time.Duration
+	maxAge     time.Duration
+	k          int
+	takedowns  *storage.TakedownSet // left out of both sides: the history keeps them, the purge doesn't
+	settings   *runtimecfg.Config
+	minPlayMs  int // MIN_PLAY_MS; the history keeps short plays, the aggregator doesn't count them
+
+	checkedListens int64 // history listens of first checks, for drift_ratio
+	driftListens   int64 // |counter - history| of confirmed drift
+}
+
+// result is one comparison of a user-day
+type result struct {
+	userDay
+	history, counters int64      // listens
+	over, under       int64      // listens the counters have beyond / short of the history
+	songs             []songDiff // songs whose counts differ, largest difference first
+	topKMatch         bool
+}
+
+type songDiff struct {
+	SongID           string
+	History, Counter int64
+}
+
+func (r result) ok() bool { return len(r.songs) == 0 }
+
+// Run verifies a round of user-days every interval until ctx is done
+func (v *Verifier) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		v.round(ctx)
+	}
+}
+
+func (v *Verifier) round(ctx context.Context) {
+	start := time.Now()
+	checked := 0
+
+	for k, due := range v.rechecks {
+		if start.Before(due) {
+			continue
+		}
+		if v.pool.has(k) {
+			// New events since the first check: it will be checked afresh
+			// once they settle
+			delete(v.rechecks, k)
+			continue
+		}
+		res, err := v.check(ctx, k)
+		if err != nil {
+			log.Printf("Error rechecking %s %s: %v", k.UserID, k.Day, err)
+			metricCheckErrors.Add(1)
+			continue // retried next round
+		}
+		checked++
+		delete(v.rechecks, k)
+		if res.ok() {
+			metricTransient.Add(1)
+			continue
+		}
+		v.drift(res)
+	}
+
+	oldest := start.Add(-v.maxAge).Format("2006-01-02")
+	for _, k := range v.pool.take(v.sampleSize, start.Add(-v.settle)) {
+		if k.Day < oldest {
+			// Rows this old may have outlived HISTORY_TTL, which would
+			// read as counters over the history
+			metricSkippedOld.Add(1)
+			continue
+		}
+		res, err := v.check(ctx, k)
+		if err != nil {
+			log.Printf("Error checking %s %s: %v", k.UserID, k.Day, err)
+			metricCheckErrors.Add(1)
+			continue
+		}
+		checked++
+		v.checkedListens += res.history
+		if res.ok() {
+			metricMatched.Add(1)
+			continue
+		}
+		v.rechecks[k] = start.Add(v.settle)
+	}
+
+	metricRechecksPending.Set(int64(len(v.rechecks)))
+	if v.checkedListens > 0 {
+		metricDriftRatio.Set(float64(v.driftListens) / float64(v.checkedListens))
+	}
+	metricLastRoundChecked.Set(int64(checked))
+	metricLastRoundMillis.Set(time.Since(start).Milliseconds())
+}
+
+// check recounts one user-day from the history and compares it with the
+// counters. History rows are keyed by event ID, so replays count once there
+// whether or not the aggregator's dedup caught them.
+func (v *Verifier) check(ctx context.Context, k userDay) (result, error) {
+	res := result{userDay: k}
+	metricChecked.Add(1)
+
+	minPlay := int64(v.settings.Int("min_play_ms", v.minPlayMs))
+	history := make(map[string]int64)
+	err := v.history.ScanDay(ctx, k.UserID, k.Day, func(row storage.HistoryRow) error {
+		if !row.ShortPlay(minPlay) {
+			history[row.SongID]++
+		}
+		return nil
+	})
+	if err != nil {
+		return res, fmt.Errorf("scan history: %w", err)
+	}
+	counters, err := v.topk.DayCounts(ctx, k.UserID, k.Day)
+	if err != nil {
+		return res, fmt.Errorf("read counters: %w", err)
+	}
+	storage.DropTakedowns(v.takedowns, history)
+	storage.DropTakedowns(v.takedowns, counters)
+
+	for song, h := range history {
+		res.history += h
+		if c := counters[song]; c != h {
+			res.songs = append(res.songs, songDiff{song, h, c})
+		}
+	}
+	for song, c := range counters {
+		res.counters += c
+		if _, ok := history[song]; !ok && c != 0 {
+			res.songs = append(res.songs, songDiff{song, 0, c})
+		}
+	}
+	for _, d := range res.songs {
+		if d.Counter > d.History {
+			res.over += d.Counter - d.History
+		} else {
+			res.under += d.History - d.Counter
+		}
+	}
+	sort.Slice(res.songs, func(i, j int) bool {
+		di, dj := abs(res.songs[i].Counter-res.songs[i].History), abs(res.songs[j].Counter-res.songs[j].History)
+		if di != dj {
+			return di > dj
+		}
+		return res.songs[i].SongID < res.songs[j].SongID
+	})
+	res.topKMatch = equal(topK(history, v.k), topK(counters, v.k))
+	return res, nil
+}
+
+// drift records a mismatch that survived its recheck
+func (v *Verifier) drift(res result) {
+	metricDrifted.Add(1)
+	metricListensOver.Add(res.over)
+	metricListensUnder.Add(res.under)
+	v.driftListens += res.over + res.under
+	if !res.topKMatch {
+		metricTopKMismatches.Add(1)
+	}
+
+	var songs []string
+	for i, d := range res.songs {
+		if i == 5 {
+			songs = append(songs, fmt.Sprintf("and %d more", len(res.songs)-i))
+			break
+		}
+		songs = append(songs, fmt.Sprintf("%s history=%d counters=%d", d.SongID, d.History, d.Counter))
+	}
+	topk := "matches"
+	if !res.topKMatch {
+		topk = "differs"
+	}
+	log.Printf("Drift user=%s day=%s: history=%d counters=%d (over %d, under %d), top-%d %s: %s",
+		res.UserID, res.Day, res.history, res.counters, res.over, res.under, v.k, topk, strings.Join(songs, ", "))
+}
+
+// topK returns the k most played songs, ties by song ID so that both sides
+// of a comparison rank alike
+func topK(counts map[string]int64, k int) []string {
+	songs := make([]string, 0, len(counts))
+	for song, n := range counts {
+		if n > 0 {
+			songs = append(songs, song)
+		}
+	}
+	sort.Slice(songs, func(i, j int) bool {
+		if counts[songs[i]] != counts[songs[j]] {
+			return counts[songs[i]] > counts[songs[j]]
+		}
+		return songs[i] < songs[j]
+	})
+	if len(songs) > k {
+		songs = songs[:k]
+	}
+	return songs
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func abs(n int64) int64 {
+	if n < 0 {
+		return -n
+	}
+	return n
+}